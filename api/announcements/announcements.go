@@ -0,0 +1,131 @@
+// Package announcements keeps the organizers' announcements, such as
+// "uploads paused for 10 minutes", and tells the frontends listening for
+// them whenever the ones showing change.
+package announcements
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Levels of an announcement, how loudly the frontend shows it
+const (
+	LevelInfo     = "info"
+	LevelWarning  = "warning"
+	LevelCritical = "critical"
+)
+
+// Sources of an announcement
+const (
+	SourceAdmin    = "admin"
+	SourceAirtable = "airtable"
+)
+
+// Announcement is a banner shown to everyone between StartsAt and EndsAt,
+// each unset for no bound.
+type Announcement struct {
+	ID        string     `json:"id"`
+	Message   string     `json:"message"`
+	Level     string     `json:"level"`
+	StartsAt  *time.Time `json:"startsAt,omitempty"`
+	EndsAt    *time.Time `json:"endsAt,omitempty"`
+	Source    string     `json:"source"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+// Showing reports whether a is shown at now.
+func (a Announcement) Showing(now time.Time) bool {
+	return (a.StartsAt == nil || !now.Before(*a.StartsAt)) && (a.EndsAt == nil || now.Before(*a.EndsAt))
+}
+
+// Snapshot is the announcements showing, numbered so listeners can tell
+// whether they're up to date.
+type Snapshot struct {
+	Version       int            `json:"version"`
+	Announcements []Announcement `json:"announcements"`
+}
+
+// Board caches the announcements of every source and the ones showing.
+type Board struct {
+	mu        sync.Mutex
+	sources   map[string][]Announcement
+	current   Snapshot
+	listeners map[chan Snapshot]struct{}
+}
+
+func NewBoard() *Board {
+	return &Board{
+		sources:   map[string][]Announcement{},
+		current:   Snapshot{Announcements: []Announcement{}},
+		listeners: map[chan Snapshot]struct{}{},
+	}
+}
+
+// Set replaces the announcements of a source and refreshes the ones
+// showing.
+func (b *Board) Set(source string, list []Announcement) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sources[source] = list
+	b.refresh(time.Now())
+}
+
+// Refresh recomputes the announcements showing, for ones that started or
+// ended since the last change.
+func (b *Board) Refresh(now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refresh(now)
+}
+
+func (b *Board) refresh(now time.Time) {
+	showing := []Announcement{}
+	for _, list := range b.sources {
+		for _, a := range list {
+			if a.Showing(now) {
+				showing = append(showing, a)
+			}
+		}
+	}
+	sort.Slice(showing, func(i, j int) bool {
+		if showing[i].CreatedAt.Equal(showing[j].CreatedAt) {
+			return showing[i].ID < showing[j].ID
+		}
+		return showing[i].CreatedAt.After(showing[j].CreatedAt)
+	})
+	if reflect.DeepEqual(showing, b.current.Announcements) {
+		return
+	}
+	b.current = Snapshot{Version: b.current.Version + 1, Announcements: showing}
+	for ch := range b.listeners {
+		// Listeners only need the latest snapshot
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- b.current
+	}
+}
+
+// Current returns the announcements showing.
+func (b *Board) Current() Snapshot {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.current
+}
+
+// Subscribe returns the announcements showing and a channel receiving them
+// again whenever they change. Call unsubscribe once done.
+func (b *Board) Subscribe() (current Snapshot, updates <-chan Snapshot, unsubscribe func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ch := make(chan Snapshot, 1)
+	b.listeners[ch] = struct{}{}
+	return b.current, ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.listeners, ch)
+	}
+}
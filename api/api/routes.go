@@ -2,17 +2,205 @@ package api
 
 import (
 	"shiba-api/handlers"
+	"shiba-api/quota"
 	"shiba-api/structs"
 
 	"github.com/go-chi/chi/v5"
 )
 
+// SetupRoutes serves the API under /v1 and /v2. The unversioned routes are
+// the original API, kept as a deprecated alias of v1 for existing clients.
+// Player-facing URLs (/play, /download, /g, /og, /projects/{projectId}/play) are
+// not versioned.
 func SetupRoutes(r *chi.Mux, srv *structs.Server) {
 	r.Get("/", handlers.RootHandler)
 	r.Get("/health", handlers.HealthCheckHandler)
-	r.Post("/uploadGame", handlers.GameUploadHandler(srv))
-	r.Post("/api/uploadGame", handlers.GameUploadHandler(srv)) // Probably required by vibecode..
-	r.Get("/play/{gameId}", handlers.MainGamePlayHandler(srv))
-	r.Get("/play/{gameId}/*", handlers.AssetsPlayHandler(srv))
-	r.Get("/removeGame/{gameId}", handlers.RemoveGameHandler(srv))
+	r.Get("/healthz", handlers.HealthCheckHandler)
+	r.Get("/readyz", handlers.ReadinessHandler(srv))
+	r.Get("/openapi.json", handlers.OpenAPIHandler)
+	r.Get("/metrics", handlers.MetricsHandler(srv))
+	r.Group(func(r chi.Router) {
+		r.Use(handlers.MeterEgress(srv))
+		r.Get("/play/{gameId}", handlers.MainGamePlayHandler(srv))
+		r.Get("/play/{gameId}/*", handlers.AssetsPlayHandler(srv))
+		r.Get("/download/{gameId}", handlers.DownloadBuildHandler(srv))
+	})
+	r.Get("/g/{shortcode}", handlers.ShortlinkRedirectHandler(srv))
+	r.Get("/og/{gameId}.png", handlers.SocialCardHandler(srv))
+	r.Get("/projects/{projectId}/play", handlers.ProjectPlayHandler(srv))
+	r.Get("/projects/{projectId}/dev", handlers.DevChannelHandler(srv))
+
+	r.Route("/v1", func(r chi.Router) {
+		r.Use(handlers.APIVersion(1))
+		apiRoutes(r, srv, 1)
+	})
+	r.Route("/v2", func(r chi.Router) {
+		r.Use(handlers.APIVersion(2))
+		apiRoutes(r, srv, 2)
+	})
+	r.Group(func(r chi.Router) {
+		r.Use(handlers.Deprecated(srv))
+		apiRoutes(r, srv, 0)
+	})
+}
+
+// apiRoutes registers the API of a version, 0 being the unversioned alias of
+// v1 with its legacy extras. Breaking changes go in the highest version only.
+func apiRoutes(r chi.Router, srv *structs.Server, version int) {
+	r.Use(handlers.ValidQuery)
+	r.Use(handlers.InjectFaults(srv))
+
+	if version < 2 {
+		// Removal over GET is gone in v2
+		r.Get("/removeGame/{gameId}", handlers.RemoveGameHandler(srv))
+	}
+
+	r.Group(func(r chi.Router) {
+		r.Use(handlers.Trace)
+		// The scope comes first so Quota can authenticate creator tokens
+		r.Use(handlers.TokenScope(handlers.ScopeUploads))
+		r.Use(handlers.Quota(srv, quota.Uploads))
+		r.Post("/uploadGame", handlers.GameUploadHandler(srv))
+		if version == 0 {
+			r.Post("/api/uploadGame", handlers.GameUploadHandler(srv)) // Probably required by vibecode..
+		}
+		r.Post("/plugin/godot/upload", handlers.PluginUploadHandler(srv))
+	})
+
+	r.Get("/me/usage", handlers.MyUsageHandler(srv))
+	r.Get("/me/sessions", handlers.MySessionsHandler(srv))
+	r.Post("/me/sessions", handlers.CreateSessionHandler(srv))
+	r.Get("/me/tokens", handlers.MyCreatorTokensHandler(srv))
+	r.Post("/me/tokens", handlers.CreateCreatorTokenHandler(srv))
+	r.Group(func(r chi.Router) {
+		r.Use(handlers.TokenScope(handlers.ScopeWebhooks))
+		r.Get("/me/webhooks", handlers.MyWebhooksHandler(srv))
+		r.Post("/me/webhooks", handlers.CreateWebhookHandler(srv))
+		r.Delete("/me/webhooks/{webhookId}", handlers.DeleteWebhookHandler(srv))
+		r.Post("/me/webhooks/{webhookId}/test", handlers.TestWebhookHandler(srv))
+	})
+	r.Put("/me/profile", handlers.UpdateProfileHandler(srv))
+	r.Post("/me/step-up", handlers.StartStepUpHandler(srv))
+	r.Post("/me/step-up/verify", handlers.VerifyStepUpHandler(srv))
+
+	// Destructive actions need a fresh confirmation code
+	r.Group(func(r chi.Router) {
+		r.Use(handlers.RequireStepUp(srv))
+		r.Post("/me/token/rotate", handlers.RotateTokenHandler(srv))
+		r.Delete("/me/sessions/{sessionId}", handlers.RevokeSessionHandler(srv))
+		r.Delete("/me/tokens/{tokenId}", handlers.RevokeCreatorTokenHandler(srv))
+		r.Delete("/games/{gameId}", handlers.DeleteGameHandler(srv))
+	})
+
+	r.Group(func(r chi.Router) {
+		r.Use(handlers.Quota(srv, quota.Reads))
+		r.With(handlers.TokenScope(handlers.ScopeUploads)).Get("/plugin/uploads/{uploadId}", handlers.PluginUploadStatusHandler(srv))
+		r.Get("/uploads/{uploadId}/diagnosis", handlers.UploadDiagnosisHandler(srv))
+		r.Get("/builds/{gameId}/manifest", handlers.ManifestHandler(srv))
+		r.Get("/builds/{gameId}/manifest.sigstore.json", handlers.ManifestBundleHandler(srv))
+		r.Get("/builds/{gameId}/verification", handlers.VerificationHandler(srv))
+		r.Get("/builds/{gameId}/archive", handlers.ColdStorageHandler(srv))
+		r.Post("/builds/{gameId}/restore", handlers.RestoreBuildHandler(srv))
+		r.Get("/projects/{projectId}/changelog", handlers.ChangelogHandler(srv))
+		r.Get("/projects/{projectId}/versions", handlers.ProjectVersionsHandler(srv))
+		r.With(handlers.GameAsProject(srv)).Get("/games/{gameId}/versions", handlers.ProjectVersionsHandler(srv))
+		r.Get("/projects/{projectId}/origins", handlers.GameOriginsHandler(srv))
+		r.Get("/projects/{projectId}/accessibility", handlers.AccessibilityHandler(srv))
+		r.Get("/projects/{projectId}/metadata", handlers.GameMetadataHandler(srv))
+		r.Post("/projects/{projectId}/shortlink", handlers.CreateShortlinkHandler(srv))
+		r.Get("/g/{shortcode}/qr.{format}", handlers.ShortlinkQRHandler(srv))
+		r.Get("/creators/{userId}/devlog", handlers.DevlogHandler(srv))
+		r.Get("/users/{userId}/profile", handlers.UserProfileHandler(srv))
+		r.Get("/me/streak", handlers.MyStreakHandler(srv))
+		r.Get("/me/eligibility", handlers.MyEligibilityHandler(srv))
+		r.Get("/me/dev-time", handlers.MyDevTimeHandler(srv))
+		r.Get("/me/games.zip", handlers.MyGamesArchiveHandler(srv))
+		r.Get("/results", handlers.ResultsHandler(srv))
+		r.Post("/upload/advice", handlers.UploadAdviceHandler(srv))
+		r.With(handlers.TokenScope(handlers.ScopeGamesRead)).Get("/games", handlers.MyGamesHandler(srv))
+		r.Get("/games/search", handlers.GameSearchHandler(srv))
+		r.Get("/games/{gameId}/recommendations", handlers.RecommendationsHandler(srv))
+		r.Get("/games/{gameId}/stats", handlers.GameStatsHandler(srv))
+		r.Get("/games/{gameId}/meta", handlers.GetGameMetaHandler(srv))
+		r.Get("/games/{gameId}/leaderboard", handlers.LeaderboardHandler(srv))
+		r.Get("/games/{gameId}/saves/{playerKey}", handlers.GetSaveHandler(srv))
+		r.Get("/games/{gameId}/comments", handlers.CommentsHandler(srv))
+	})
+
+	r.Put("/builds/{gameId}/changelog", handlers.UpdateChangelogHandler(srv))
+	r.Post("/builds/{gameId}/preview", handlers.PreviewLinkHandler(srv))
+	r.Put("/projects/{projectId}/origins", handlers.UpdateGameOriginsHandler(srv))
+	r.Put("/projects/{projectId}/accessibility", handlers.UpdateAccessibilityHandler(srv))
+	r.Put("/games/{gameId}/meta", handlers.UpdateGameMetaHandler(srv))
+	r.Put("/games/{gameId}/leaderboard", handlers.UpdateLeaderboardHandler(srv))
+	r.Post("/projects/{projectId}/rollback", handlers.RollbackProjectHandler(srv))
+	r.With(handlers.GameAsProject(srv)).Post("/games/{gameId}/rollback", handlers.RollbackProjectHandler(srv))
+	r.Post("/activity", handlers.RecordActivityHandler(srv))
+	r.With(handlers.Quota(srv, quota.Feedback)).Post("/games/{gameId}/crashes", handlers.ReportCrashHandler(srv))
+	r.With(handlers.Quota(srv, quota.Feedback)).Post("/games/{gameId}/comments", handlers.PostCommentHandler(srv))
+	r.Delete("/games/{gameId}/comments/{commentId}", handlers.DeleteCommentHandler(srv))
+	r.With(handlers.Quota(srv, quota.Reads)).Post("/analytics/play", handlers.PlayBeaconHandler(srv))
+	r.Post("/games/{gameId}/scores", handlers.SubmitScoreHandler(srv))
+	r.Put("/games/{gameId}/saves/{playerKey}", handlers.PutSaveHandler(srv))
+	r.Post("/integrations/hackatime/heartbeats", handlers.HackatimeWebhookHandler(srv))
+	r.Post("/play-sessions", handlers.StartPlaySessionHandler(srv))
+	r.Post("/play-sessions/{sessionId}/heartbeat", handlers.PlaySessionHeartbeatHandler(srv))
+	r.Post("/play-sessions/{sessionId}/end", handlers.EndPlaySessionHandler(srv))
+
+	r.Get("/capabilities/{token}", handlers.CapabilityHandler(srv))
+	r.Post("/capabilities/{token}", handlers.UseCapabilityHandler(srv))
+	r.Get("/announcements", handlers.AnnouncementsHandler(srv))
+	r.Get("/announcements/stream", handlers.AnnouncementsStreamHandler(srv))
+	r.Post("/demo/upload", handlers.DemoUploadHandler(srv))
+
+	r.Post("/kiosk/playlists", handlers.SavePlaylistHandler(srv))
+	r.Put("/kiosk/playlists/{playlistId}", handlers.SavePlaylistHandler(srv))
+	r.Post("/kiosk/playlists/{playlistId}/tokens", handlers.CreateKioskTokenHandler(srv))
+	r.Get("/kiosk/current", handlers.KioskCurrentHandler(srv))
+	r.Post("/kiosk/activity", handlers.KioskActivityHandler(srv))
+	r.Post("/kiosk/next", handlers.KioskNextHandler(srv))
+	r.Post("/kiosk/prev", handlers.KioskPrevHandler(srv))
+	r.Post("/kiosk/shuffle", handlers.KioskShuffleHandler(srv))
+
+	r.Route("/judging", func(r chi.Router) {
+		r.Post("/assignments", handlers.AssignJudgeBuildsHandler(srv))
+		r.Get("/builds", handlers.JudgeBuildsHandler(srv))
+		r.Post("/builds/{gameId}/scores", handlers.ScoreBuildHandler(srv))
+		r.Post("/builds/{gameId}/conflict", handlers.ConflictHandler(srv))
+		r.Get("/rankings", handlers.RankingsHandler(srv))
+		r.Post("/bundles", handlers.CreateJudgingBundleHandler(srv))
+		r.Get("/bundles/{bundleId}", handlers.JudgingBundleHandler(srv))
+		r.Get("/bundles/{bundleId}/download", handlers.DownloadJudgingBundleHandler(srv))
+	})
+
+	r.Put("/results", handlers.UpdateResultsHandler(srv))
+	r.Post("/admin/reload-config", handlers.ReloadConfigHandler(srv))
+	r.Get("/admin/builds/{gameId}/events", handlers.UploadEventsHandler(srv))
+	r.Post("/admin/builds/bulk-edit", handlers.BulkEditBuildsHandler(srv))
+	r.Get("/admin/play-sessions/flagged", handlers.FlaggedPlaySessionsHandler(srv))
+	r.Get("/admin/store/stats", handlers.StoreStatsHandler(srv))
+	r.Get("/admin/builds/{gameId}/provenance", handlers.BuildProvenanceHandler(srv))
+	r.Get("/admin/provenance", handlers.ProvenanceSearchHandler(srv))
+	r.Post("/admin/export/airtable", handlers.ExportStatsHandler(srv))
+	r.Get("/admin/users/replica", handlers.UsersReplicaHandler(srv))
+	r.Post("/admin/users/sync", handlers.SyncUsersHandler(srv))
+	r.Post("/admin/retention", handlers.RetentionHandler(srv))
+	r.Get("/admin/costs", handlers.CostReportHandler(srv))
+	r.Post("/admin/events/{event}/archive", handlers.ArchiveEventHandler(srv))
+	r.Post("/admin/events/{event}/export", handlers.ExportSiteHandler(srv))
+	r.Get("/admin/events/{event}/export", handlers.SiteExportHandler(srv))
+	r.Get("/admin/hooks", handlers.HooksHandler(srv))
+	r.Put("/admin/hooks/{event}/{name}", handlers.UpdateHookHandler(srv))
+	r.Get("/admin/validation-rules", handlers.ValidationRulesHandler(srv))
+	r.Put("/admin/validation-rules/{event}/{name}", handlers.UpdateValidationRuleHandler(srv))
+	r.Delete("/admin/validation-rules/{event}/{name}", handlers.DeleteValidationRuleHandler(srv))
+	r.Post("/admin/seed", handlers.SeedHandler(srv))
+	r.Get("/admin/sync-jobs", handlers.SyncJobsHandler(srv))
+	r.Post("/admin/sync-jobs/{gameId}/retry", handlers.RetrySyncJobHandler(srv))
+	r.Get("/admin/quarantine", handlers.QuarantineHandler(srv))
+	r.Post("/admin/quarantine/{gameId}/release", handlers.ReleaseQuarantineHandler(srv))
+	r.Post("/admin/capabilities", handlers.CreateCapabilityHandler(srv))
+	r.Get("/admin/announcements", handlers.AdminAnnouncementsHandler(srv))
+	r.Post("/admin/announcements", handlers.CreateAnnouncementHandler(srv))
+	r.Delete("/admin/announcements/{announcementId}", handlers.DeleteAnnouncementHandler(srv))
 }
@@ -0,0 +1,58 @@
+// Package buildindex keeps which builds exist, their project and which are
+// drafts in memory, so the play routes can gate drafts without reading the whole builds
+// document on every request. The index is loaded on first use and dropped
+// whenever the builds document is written, to be loaded again by the next
+// lookup.
+package buildindex
+
+import "sync"
+
+// Entry is what the serving path needs to know of a build.
+type Entry struct {
+	ProjectID string
+	Draft     bool
+}
+
+// Index maps build IDs to their entry.
+type Index struct {
+	mu      sync.Mutex
+	entries map[string]Entry
+}
+
+func New() *Index {
+	return &Index{}
+}
+
+// Lookup returns the entry of a build, loading the index with load if it was
+// invalidated. A nil Index loads on every lookup.
+func (x *Index) Lookup(id string, load func() (map[string]Entry, error)) (Entry, bool, error) {
+	if x == nil {
+		entries, err := load()
+		e, ok := entries[id]
+		return e, ok, err
+	}
+
+	// Loading under mu means an Invalidate racing the load waits for it, so
+	// a load that read the document before a write never outlives the write
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.entries == nil {
+		entries, err := load()
+		if err != nil {
+			return Entry{}, false, err
+		}
+		x.entries = entries
+	}
+	e, ok := x.entries[id]
+	return e, ok, nil
+}
+
+// Invalidate drops the index after the builds document was written.
+func (x *Index) Invalidate() {
+	if x == nil {
+		return
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.entries = nil
+}
@@ -0,0 +1,55 @@
+package buildindex
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestIndexLookup(t *testing.T) {
+	loads := 0
+	builds := map[string]Entry{"recDraft": {ProjectID: "recP", Draft: true}}
+	load := func() (map[string]Entry, error) {
+		loads++
+		entries := make(map[string]Entry, len(builds))
+		for id, e := range builds {
+			entries[id] = e
+		}
+		return entries, nil
+	}
+
+	x := New()
+	for range 3 {
+		if e, ok, err := x.Lookup("recDraft", load); err != nil || !ok || !e.Draft {
+			t.Fatalf("Lookup(recDraft) = %+v, %v, %v", e, ok, err)
+		}
+	}
+	if _, ok, _ := x.Lookup("recMissing", load); ok {
+		t.Error("unknown build found")
+	}
+	if loads != 1 {
+		t.Errorf("loaded %d times, want once", loads)
+	}
+
+	// A write is seen after Invalidate
+	builds["recNew"] = Entry{ProjectID: "recP"}
+	x.Invalidate()
+	if _, ok, _ := x.Lookup("recNew", load); !ok {
+		t.Error("build written before Invalidate not found")
+	}
+	if loads != 2 {
+		t.Errorf("loaded %d times, want twice", loads)
+	}
+}
+
+func TestIndexLoadError(t *testing.T) {
+	errLoad := errors.New("disk on fire")
+	x := New()
+	if _, _, err := x.Lookup("recA", func() (map[string]Entry, error) { return nil, errLoad }); !errors.Is(err, errLoad) {
+		t.Fatalf("err = %v, want %v", err, errLoad)
+	}
+	// A failed load isn't kept
+	ok := func() (map[string]Entry, error) { return map[string]Entry{"recA": {}}, nil }
+	if _, found, err := x.Lookup("recA", ok); err != nil || !found {
+		t.Errorf("Lookup after a failed load = %v, %v", found, err)
+	}
+}
@@ -0,0 +1,181 @@
+// Package buildscan statically inspects an extracted web build for hints
+// about how it is played, such as which input APIs its scripts use.
+package buildscan
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Files larger than this are not scanned
+const maxScanBytes = 20 << 20
+
+// Report is what a scan found. Engine runtimes (Godot, Unity) wire up every
+// input API whether the game uses it or not, so for engine builds the input
+// flags come from the game's own data instead of the scripts, when it can be
+// read (EngineInputs). Otherwise they are only meaningful with Engine == "".
+type Report struct {
+	Engine       string `json:"engine,omitempty"`
+	EngineInputs bool   `json:"engineInputs,omitempty"`
+	Gamepad      bool   `json:"gamepad"`
+	Keyboard     bool   `json:"keyboard"`
+	Pointer      bool   `json:"pointer"`
+	Touch        bool   `json:"touch"`
+	// Viewport is set when a page declares a viewport meta tag, which games
+	// meant for phones need to render at device size
+	Viewport bool `json:"viewport"`
+}
+
+type inputs struct {
+	gamepad, keyboard, pointer, touch bool
+}
+
+func (in *inputs) scan(data []byte, markers inputMarkers) {
+	in.gamepad = in.gamepad || containsAny(data, markers.gamepad)
+	in.keyboard = in.keyboard || containsAny(data, markers.keyboard)
+	in.pointer = in.pointer || containsAny(data, markers.pointer)
+	in.touch = in.touch || containsAny(data, markers.touch)
+}
+
+type inputMarkers struct {
+	gamepad, keyboard, pointer, touch [][]byte
+}
+
+var webMarkers = inputMarkers{
+	gamepad:  [][]byte{[]byte("getGamepads"), []byte("gamepadconnected")},
+	keyboard: [][]byte{[]byte("keydown"), []byte("keyup"), []byte("keypress")},
+	touch:    [][]byte{[]byte("touchstart"), []byte("touchend"), []byte("touchmove"), []byte("pointerdown")},
+	pointer:  [][]byte{[]byte("mousedown"), []byte("pointerdown"), []byte("touchstart"), []byte("\"click\""), []byte("'click'"), []byte("onclick")},
+}
+
+// godotMarkers are the input event classes of a Godot project's input map,
+// stored by name in the exported project settings, and the Input methods
+// scripts poll devices with. Both sit uncompressed in an unencrypted .pck.
+var godotMarkers = inputMarkers{
+	gamepad:  [][]byte{[]byte("InputEventJoypadButton"), []byte("InputEventJoypadMotion"), []byte("is_joy_button_pressed"), []byte("get_joy_axis")},
+	keyboard: [][]byte{[]byte("InputEventKey"), []byte("is_key_pressed"), []byte("is_physical_key_pressed")},
+	pointer:  [][]byte{[]byte("InputEventMouseButton"), []byte("is_mouse_button_pressed")},
+	touch:    [][]byte{[]byte("InputEventScreenTouch"), []byte("InputEventScreenDrag")},
+}
+
+var viewportMeta = regexp.MustCompile(`(?i)<meta[^>]+name\s*=\s*["']?viewport`)
+
+func containsAny(data []byte, markers [][]byte) bool {
+	for _, m := range markers {
+		if bytes.Contains(data, m) {
+			return true
+		}
+	}
+	return false
+}
+
+func detectEngine(rel string) string {
+	name := strings.ToLower(filepath.Base(rel))
+	switch {
+	case strings.HasSuffix(name, ".pck"):
+		return "godot"
+	case strings.HasSuffix(name, ".loader.js"), name == "unityloader.js":
+		return "unity"
+	}
+	return ""
+}
+
+// Scan walks dir and reads its scripts and pages, and the data packs of
+// Godot builds. Unity's data files are compressed, so Unity builds get no
+// input flags.
+func Scan(dir string) (Report, error) {
+	var report Report
+	var web, godot inputs
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if engine := detectEngine(path); engine != "" {
+			report.Engine = engine
+			if engine == "godot" {
+				report.EngineInputs = true
+				return scanPack(path, &godot)
+			}
+		}
+
+		ext := strings.ToLower(filepath.Ext(path))
+		if ext != ".js" && ext != ".html" && ext != ".htm" {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		data, err := io.ReadAll(io.LimitReader(f, maxScanBytes))
+		if err != nil {
+			return err
+		}
+
+		web.scan(data, webMarkers)
+		if ext != ".js" {
+			report.Viewport = report.Viewport || viewportMeta.Match(data)
+		}
+		return nil
+	})
+
+	in := web
+	switch {
+	case report.EngineInputs:
+		in = godot
+		// Godot turns touches into mouse clicks unless the project opts out
+		in.touch = in.touch || in.pointer
+	case report.Engine != "":
+		in = inputs{}
+	}
+	report.Gamepad, report.Keyboard, report.Pointer, report.Touch = in.gamepad, in.keyboard, in.pointer, in.touch
+	return report, err
+}
+
+// scanPack looks for Godot input markers in a .pck, reading it in chunks
+// that overlap by the longest marker so none is split between two reads.
+func scanPack(path string, in *inputs) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	const chunk = 1 << 20
+	overlap := 0
+	for _, list := range [][][]byte{godotMarkers.gamepad, godotMarkers.keyboard, godotMarkers.pointer, godotMarkers.touch} {
+		for _, m := range list {
+			overlap = max(overlap, len(m)-1)
+		}
+	}
+	buf := make([]byte, overlap+chunk)
+	kept := 0
+	for {
+		n, err := io.ReadFull(f, buf[kept:])
+		in.scan(buf[:kept+n], godotMarkers)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		kept = copy(buf, buf[kept+n-overlap:kept+n])
+	}
+}
+
+// MobileCompatible reports whether the build looks playable on a phone: a
+// page sizes itself to the device and something handles touch input.
+func (r Report) MobileCompatible() bool {
+	return r.Viewport && r.Touch
+}
+
+// ExpectsController reports whether the build looks playable only with a
+// gamepad: it reads gamepads but never listens to keyboard or pointer input.
+func (r Report) ExpectsController() bool {
+	return (r.Engine == "" || r.EngineInputs) && r.Gamepad && !r.Keyboard && !r.Pointer
+}
@@ -0,0 +1,86 @@
+// Package capability signs short-lived URLs that each allow one admin action
+// on one target, e.g. retrying one build's sync, so they can be posted in a
+// Slack alert and clicked without signing in to anything. The URL is the
+// credential: whoever holds it may perform the action, once.
+//
+// A token is "<claims>.<signature>": the unpadded base64url JSON of the
+// claims and the unpadded base64url HMAC-SHA256 of the encoded claims under
+// the key.
+package capability
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalid = errors.New("invalid capability")
+	ErrExpired = errors.New("capability expired")
+)
+
+// Claims is what a capability allows, and until when.
+type Claims struct {
+	// ID tells the uses of capabilities apart, so each is used once
+	ID      string    `json:"id"`
+	Action  string    `json:"action"`
+	Target  string    `json:"target"`
+	Expires time.Time `json:"exp"`
+}
+
+// Signer mints and checks capabilities.
+type Signer struct {
+	key []byte
+}
+
+func NewSigner(key []byte) *Signer {
+	return &Signer{key: key}
+}
+
+func (s *Signer) sign(claims string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(claims))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Mint returns a token allowing action on target until expires.
+func (s *Signer) Mint(action, target string, expires time.Time) (string, Claims, error) {
+	id := make([]byte, 12)
+	if _, err := rand.Read(id); err != nil {
+		return "", Claims{}, err
+	}
+	c := Claims{ID: hex.EncodeToString(id), Action: action, Target: target, Expires: expires.UTC().Truncate(time.Second)}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return "", Claims{}, err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(data)
+	return encoded + "." + s.sign(encoded), c, nil
+}
+
+// Verify checks that token was minted with this key and hasn't expired, and
+// returns its claims. Whether it was used already is up to the caller.
+func (s *Signer) Verify(token string, now time.Time) (Claims, error) {
+	encoded, sig, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(s.sign(encoded))) {
+		return Claims{}, ErrInvalid
+	}
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return Claims{}, ErrInvalid
+	}
+	var c Claims
+	if err := json.Unmarshal(data, &c); err != nil || c.ID == "" {
+		return Claims{}, ErrInvalid
+	}
+	if !now.Before(c.Expires) {
+		return c, ErrExpired
+	}
+	return c, nil
+}
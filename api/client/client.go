@@ -0,0 +1,310 @@
+// Package client is a Go client for the Shiba API, for jam tools and CI
+// scripts that upload builds:
+//
+//	c := client.New("https://api.example.com", os.Getenv("SHIBA_TOKEN"))
+//	res, err := c.UploadGame(ctx, "build.zip", client.UploadOptions{ProjectID: "rec123"})
+//
+// It talks to the v2 API, retries requests that failed for reasons that may
+// pass (rate limits, restarts, dropped connections) and returns API failures
+// as *APIError. It only depends on the standard library, so it can be copied
+// into other modules as is.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Version is sent in X-Shiba-Client, as the version of the client.
+const Version = "1.0.0"
+
+const (
+	defaultRetries      = 3
+	defaultMaxRetryWait = 30 * time.Second
+	// First retry delay, doubled on each retry
+	baseRetryDelay = time.Second
+)
+
+// Client calls the API with a user's token. Set its fields before the first
+// call; a Client is safe for concurrent use after that, except VerifyStepUp,
+// which sets StepUpToken.
+type Client struct {
+	// BaseURL is where the API is served, without the version, e.g.
+	// "https://api.example.com"
+	BaseURL string
+	// Token is an account, session or creator token
+	Token string
+	// HTTPClient sends the requests; http.DefaultClient when nil
+	HTTPClient *http.Client
+	// Channel is recorded as the uploading client in builds' provenance:
+	// "cli", "ci" or "godot-plugin". Defaults to "ci" when the CI
+	// environment variable is set, else "cli".
+	Channel string
+	// Retries is how many times a failed request is retried; 0 means the
+	// default of 3, negative never retries
+	Retries int
+	// MaxRetryWait caps the wait before a retry. Requests the API asks to
+	// retry later than this, e.g. after the daily upload quota ran out, fail
+	// instead. Defaults to 30s.
+	MaxRetryWait time.Duration
+	// StepUpToken is sent as X-Step-Up on destructive calls, see VerifyStepUp
+	StepUpToken string
+}
+
+// New returns a client for the API at baseURL authenticating with token.
+func New(baseURL, token string) *Client {
+	return &Client{BaseURL: baseURL, Token: token}
+}
+
+// FromEnv returns a client for the API at SHIBA_API_URL authenticating with
+// SHIBA_TOKEN.
+func FromEnv() (*Client, error) {
+	baseURL := os.Getenv("SHIBA_API_URL")
+	if baseURL == "" {
+		return nil, errors.New("SHIBA_API_URL is not set")
+	}
+	token := os.Getenv("SHIBA_TOKEN")
+	if token == "" {
+		return nil, errors.New("SHIBA_TOKEN is not set")
+	}
+	return New(baseURL, token), nil
+}
+
+// Errors an *APIError matches with errors.Is, by its code or status
+var (
+	ErrUnauthorized      = errors.New("invalid or missing token")
+	ErrNotFound          = errors.New("not found")
+	ErrStepUpRequired    = errors.New("step-up required")
+	ErrInsufficientScope = errors.New("token lacks the scope")
+	ErrQuotaExceeded     = errors.New("quota exceeded")
+	ErrValidationFailed  = errors.New("build failed validation")
+)
+
+// APIError is a request the API answered with an error.
+type APIError struct {
+	Status int
+	// Code is the machine-readable reason of errors sent as JSON, e.g.
+	// "build_too_large"; empty for plain text errors
+	Code    string
+	Message string
+	// Findings explain why a build failed validation
+	Findings []Finding
+	// Fields are the request fields that failed validation
+	Fields []FieldError
+	// Channels a step-up code can be sent through, for step_up_required
+	Channels []string
+	// RequestID and TraceID find the request in the API's logs
+	RequestID string
+	TraceID   string
+	// RetryAfter is how long the API asked to wait, if it did
+	RetryAfter time.Duration
+}
+
+// Finding is one problem found in a build.
+type Finding struct {
+	Path   string `json:"path"`
+	Rule   string `json:"rule"`
+	Detail string `json:"detail"`
+}
+
+// FieldError is a request field that failed validation.
+type FieldError struct {
+	Field   string `json:"field"`
+	In      string `json:"in"`
+	Message string `json:"message"`
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("shiba: %d %s", e.Status, e.Message)
+	if e.Code != "" {
+		msg += " (" + e.Code + ")"
+	}
+	if e.RequestID != "" {
+		msg += ", request ID " + e.RequestID
+	}
+	return msg
+}
+
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return e.Status == http.StatusUnauthorized
+	case ErrNotFound:
+		return e.Status == http.StatusNotFound
+	case ErrStepUpRequired:
+		return e.Code == "step_up_required"
+	case ErrInsufficientScope:
+		return e.Code == "insufficient_scope"
+	case ErrQuotaExceeded:
+		return e.Status == http.StatusTooManyRequests
+	case ErrValidationFailed:
+		return e.Code == "validation_failed"
+	}
+	return false
+}
+
+// readError turns an error response into an *APIError.
+func readError(resp *http.Response) *APIError {
+	e := &APIError{Status: resp.StatusCode, RetryAfter: retryAfter(resp)}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		var parsed struct {
+			Code      string       `json:"code"`
+			Message   string       `json:"message"`
+			Findings  []Finding    `json:"findings"`
+			Fields    []FieldError `json:"fields"`
+			Channels  []string     `json:"channels"`
+			RequestID string       `json:"requestId"`
+			TraceID   string       `json:"traceId"`
+		}
+		if json.Unmarshal(body, &parsed) == nil {
+			e.Code, e.Message, e.Findings, e.Fields, e.Channels = parsed.Code, parsed.Message, parsed.Findings, parsed.Fields, parsed.Channels
+			e.RequestID, e.TraceID = parsed.RequestID, parsed.TraceID
+		}
+	}
+	if e.Message == "" {
+		// Plain text errors end with the request and trace IDs, one per line
+		lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+		var msg []string
+		for _, line := range lines {
+			if id, ok := strings.CutPrefix(line, "Request ID: "); ok {
+				e.RequestID = id
+			} else if id, ok := strings.CutPrefix(line, "Trace ID: "); ok {
+				e.TraceID = id
+			} else {
+				msg = append(msg, line)
+			}
+		}
+		e.Message = strings.Join(msg, "\n")
+	}
+	if e.RequestID == "" {
+		e.RequestID = resp.Header.Get("X-Request-ID")
+	}
+	if e.Message == "" {
+		e.Message = http.StatusText(resp.StatusCode)
+	}
+	return e
+}
+
+func retryAfter(resp *http.Response) time.Duration {
+	secs, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || secs < 0 {
+		return 0
+	}
+	return time.Duration(secs) * time.Second
+}
+
+func (c *Client) channel() string {
+	if c.Channel != "" {
+		return c.Channel
+	}
+	if os.Getenv("CI") != "" {
+		return "ci"
+	}
+	return "cli"
+}
+
+func (c *Client) url(path string) string {
+	return strings.TrimRight(c.BaseURL, "/") + "/v2" + path
+}
+
+// do sends the request built by newRequest, retrying failures that may pass,
+// and decodes a successful JSON response into out when it isn't nil.
+// idempotent requests are also retried when the API may have handled them.
+func (c *Client) do(ctx context.Context, idempotent bool, newRequest func() (*http.Request, error), out any) error {
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	retries := c.Retries
+	if retries == 0 {
+		retries = defaultRetries
+	}
+	maxWait := c.MaxRetryWait
+	if maxWait == 0 {
+		maxWait = defaultMaxRetryWait
+	}
+
+	for attempt := 0; ; attempt++ {
+		req, err := newRequest()
+		if err != nil {
+			return err
+		}
+		req = req.WithContext(ctx)
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+		req.Header.Set("X-Shiba-Client", c.channel()+"/"+Version)
+		req.Header.Set("User-Agent", "shiba-go-client/"+Version)
+
+		resp, err := httpClient.Do(req)
+		var wait time.Duration
+		if err != nil {
+			if ctx.Err() != nil || attempt >= retries || !(idempotent || dialFailed(err)) {
+				return err
+			}
+		} else {
+			if resp.StatusCode < 300 {
+				return decode(resp, out)
+			}
+			apiErr := readError(resp)
+			resp.Body.Close()
+			if attempt >= retries || !retryable(resp.StatusCode, idempotent) || apiErr.RetryAfter > maxWait {
+				return apiErr
+			}
+			wait = apiErr.RetryAfter
+		}
+
+		if wait == 0 {
+			// Exponential backoff with jitter, so clients failing together
+			// don't retry together
+			wait = baseRetryDelay << attempt
+			wait += time.Duration(rand.Int64N(int64(wait) / 2))
+		}
+		wait = min(wait, maxWait)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func decode(resp *http.Response, out any) error {
+	defer resp.Body.Close()
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("shiba: failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// retryable reports whether a request answered with status should be
+// retried. Gateways failing may have passed the request on, so only
+// idempotent requests are retried then.
+func retryable(status int, idempotent bool) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	case http.StatusBadGateway, http.StatusGatewayTimeout:
+		return idempotent
+	}
+	return false
+}
+
+// dialFailed reports whether err happened before the request was sent, so
+// even a request that isn't idempotent can be retried.
+func dialFailed(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
@@ -0,0 +1,136 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Game is one of the caller's projects.
+type Game struct {
+	// ID is the project ID
+	ID string `json:"id"`
+	// Slug is the project's short link code, once one was created
+	Slug string `json:"slug,omitempty"`
+	// GameID and PlayURL are of the version served
+	GameID    string    `json:"gameId"`
+	PlayURL   string    `json:"playUrl"`
+	CreatedAt time.Time `json:"createdAt"`
+	// Size is the served version's size in bytes, 0 until its manifest is
+	// published
+	Size     int64 `json:"size"`
+	Versions int   `json:"versions"`
+}
+
+// ListGamesOptions pick a page of games.
+type ListGamesOptions struct {
+	// Limit is the page size, 1-100; the API defaults to 20
+	Limit int
+	// Cursor is the NextCursor of the previous page
+	Cursor string
+}
+
+// GamesPage is a page of the caller's games, newest first.
+type GamesPage struct {
+	Games []Game `json:"games"`
+	// NextCursor fetches the next page; empty on the last one
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// ListGames returns a page of the projects the caller uploaded builds to.
+// Creator tokens need the games:read scope.
+func (c *Client) ListGames(ctx context.Context, opts ListGamesOptions) (*GamesPage, error) {
+	query := url.Values{}
+	if opts.Limit > 0 {
+		query.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	if opts.Cursor != "" {
+		query.Set("cursor", opts.Cursor)
+	}
+	u := c.url("/games")
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	var page GamesPage
+	err := c.do(ctx, true, func() (*http.Request, error) {
+		return http.NewRequest(http.MethodGet, u, nil)
+	}, &page)
+	if err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// DeleteGame takes down a build the caller uploaded, for good. When the API
+// requires a step-up it fails with ErrStepUpRequired; get a grant with
+// StartStepUp and VerifyStepUp and call it again.
+func (c *Client) DeleteGame(ctx context.Context, gameID string) error {
+	return c.do(ctx, true, func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodDelete, c.url("/games/"+url.PathEscape(gameID)), nil)
+		if err == nil && c.StepUpToken != "" {
+			req.Header.Set("X-Step-Up", c.StepUpToken)
+		}
+		return req, err
+	}, nil)
+}
+
+// StepUpChallenge is a confirmation code on its way to the user.
+type StepUpChallenge struct {
+	ChallengeID string    `json:"challengeId"`
+	Channel     string    `json:"channel"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+}
+
+// StartStepUp sends the user a confirmation code for destructive calls,
+// through channel ("email" or "slack"), or the first one they have an
+// address for when empty.
+func (c *Client) StartStepUp(ctx context.Context, channel string) (*StepUpChallenge, error) {
+	body, err := json.Marshal(struct {
+		Channel string `json:"channel,omitempty"`
+	}{channel})
+	if err != nil {
+		return nil, err
+	}
+	var challenge StepUpChallenge
+	if err := c.post(ctx, "/me/step-up", body, &challenge); err != nil {
+		return nil, err
+	}
+	return &challenge, nil
+}
+
+// VerifyStepUp exchanges the code the user received for a grant, which the
+// client sends on destructive calls from then on, until it expires.
+func (c *Client) VerifyStepUp(ctx context.Context, challengeID, code string) (time.Time, error) {
+	body, err := json.Marshal(struct {
+		ChallengeID string `json:"challengeId"`
+		Code        string `json:"code"`
+	}{challengeID, code})
+	if err != nil {
+		return time.Time{}, err
+	}
+	var grant struct {
+		StepUpToken string    `json:"stepUpToken"`
+		ExpiresAt   time.Time `json:"expiresAt"`
+	}
+	if err := c.post(ctx, "/me/step-up/verify", body, &grant); err != nil {
+		return time.Time{}, err
+	}
+	c.StepUpToken = grant.StepUpToken
+	return grant.ExpiresAt, nil
+}
+
+// post sends a JSON body. Only failures to connect are retried.
+func (c *Client) post(ctx context.Context, path string, body []byte, out any) error {
+	return c.do(ctx, false, func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodPost, c.url(path), bytes.NewReader(body))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		return req, err
+	}, out)
+}
@@ -0,0 +1,146 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+)
+
+// UploadOptions are the optional fields of an upload.
+type UploadOptions struct {
+	// ProjectID makes the build a new version of an existing project
+	ProjectID string
+	// Changelog says what changed in this version
+	Changelog string
+	// Engine and EngineVersion, e.g. "godot" and "4.3"
+	Engine        string
+	EngineVersion string
+	// Draft builds are only playable with a preview link
+	Draft bool
+	// Packs are data pack zips extracted over the build, for web builds too
+	// large for one zip
+	Packs []string
+	// Progress is called as the files are sent with the bytes sent so far
+	// and the total size of the files. A retried upload starts over from 0.
+	Progress func(sent, total int64)
+}
+
+// UploadResult is a published build.
+type UploadResult struct {
+	GameID      string `json:"gameId"`
+	ProjectID   string `json:"projectId"`
+	PlayURL     string `json:"playUrl,omitempty"`
+	DownloadURL string `json:"downloadUrl,omitempty"`
+	ListingType string `json:"listingType,omitempty"`
+	// Warnings point out likely broken references in the build's HTML
+	Warnings []Finding `json:"warnings,omitempty"`
+}
+
+// UploadGame uploads the build at path (a web build zip, a cartridge or a
+// native build) and publishes it. The API answers once the build is
+// extracted, validated and synced, which takes a while for large builds.
+func (c *Client) UploadGame(ctx context.Context, path string, opts UploadOptions) (*UploadResult, error) {
+	files := append([]string{path}, opts.Packs...)
+	var total int64
+	for _, f := range files {
+		info, err := os.Stat(f)
+		if err != nil {
+			return nil, err
+		}
+		total += info.Size()
+	}
+
+	fields := map[string]string{
+		"projectId":     opts.ProjectID,
+		"changelog":     opts.Changelog,
+		"engine":        opts.Engine,
+		"engineVersion": opts.EngineVersion,
+	}
+	if opts.Draft {
+		fields["draft"] = strconv.FormatBool(true)
+	}
+
+	newRequest := func() (*http.Request, error) {
+		body, pw := io.Pipe()
+		form := multipart.NewWriter(pw)
+		var sent atomic.Int64
+		go func() {
+			pw.CloseWithError(writeUpload(form, fields, files, func(n int64) {
+				if opts.Progress != nil {
+					opts.Progress(sent.Add(n), total)
+				}
+			}))
+		}()
+		req, err := http.NewRequest(http.MethodPost, c.url("/uploadGame"), body)
+		if err != nil {
+			body.Close()
+			return nil, err
+		}
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		return req, nil
+	}
+
+	var res UploadResult
+	if err := c.do(ctx, false, newRequest, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// writeUpload streams the multipart body of an upload: the fields, the build
+// as "file" and the packs as "pack".
+func writeUpload(form *multipart.Writer, fields map[string]string, files []string, progress func(int64)) error {
+	for name, value := range fields {
+		if value == "" {
+			continue
+		}
+		if err := form.WriteField(name, value); err != nil {
+			return err
+		}
+	}
+	for i, path := range files {
+		name := "pack"
+		if i == 0 {
+			name = "file"
+		}
+		if err := writeFile(form, name, path, progress); err != nil {
+			return err
+		}
+	}
+	return form.Close()
+}
+
+func writeFile(form *multipart.Writer, name, path string, progress func(int64)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	part, err := form.CreateFormFile(name, filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(part, &progressReader{f, progress}); err != nil {
+		return fmt.Errorf("failed to send %s: %w", path, err)
+	}
+	return nil
+}
+
+type progressReader struct {
+	r        io.Reader
+	progress func(int64)
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.progress(int64(n))
+	}
+	return n, err
+}
@@ -0,0 +1,105 @@
+// Package clientip works out the real client address of requests that came
+// through reverse proxies (Cloudflare, the load balancer), trusting forwarding
+// headers only when they were set by a proxy we know.
+package clientip
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Cloudflare's published edge ranges, https://www.cloudflare.com/ips/
+var cloudflareRanges = []string{
+	"173.245.48.0/20", "103.21.244.0/22", "103.22.200.0/22", "103.31.4.0/22",
+	"141.101.64.0/18", "108.162.192.0/18", "190.93.240.0/20", "188.114.96.0/20",
+	"197.234.240.0/22", "198.41.128.0/17", "162.158.0.0/15", "104.16.0.0/13",
+	"104.24.0.0/14", "172.64.0.0/13", "131.0.72.0/22",
+	"2400:cb00::/32", "2606:4700::/32", "2803:f800::/32", "2405:b500::/32",
+	"2405:8100::/32", "2a06:98c0::/29", "2c0f:f248::/32",
+}
+
+// ParseTrusted parses a comma separated list of CIDRs and bare IPs. The
+// keyword "cloudflare" expands to Cloudflare's edge ranges, "private" to
+// loopback and private networks.
+func ParseTrusted(list string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		var cidrs []string
+		switch item {
+		case "":
+			continue
+		case "cloudflare":
+			cidrs = cloudflareRanges
+		case "private":
+			cidrs = []string{"127.0.0.0/8", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "::1/128", "fc00::/7"}
+		default:
+			if !strings.Contains(item, "/") {
+				if ip := net.ParseIP(item); ip != nil && ip.To4() != nil {
+					item += "/32"
+				} else {
+					item += "/128"
+				}
+			}
+			cidrs = []string{item}
+		}
+		for _, c := range cidrs {
+			_, n, err := net.ParseCIDR(c)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %v", c, err)
+			}
+			nets = append(nets, n)
+		}
+	}
+	return nets, nil
+}
+
+func isTrusted(ip net.IP, trusted []*net.IPNet) bool {
+	for _, n := range trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func remoteIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// Resolve returns the client IP of r. Forwarding headers are only believed
+// when the direct peer is a trusted proxy: CF-Connecting-IP first, then
+// X-Forwarded-For read right to left, skipping trusted hops, so a client
+// can't spoof its address by sending its own header.
+func Resolve(r *http.Request, trusted []*net.IPNet) net.IP {
+	ip := remoteIP(r)
+	if ip == nil || !isTrusted(ip, trusted) {
+		return ip
+	}
+
+	if cf := net.ParseIP(strings.TrimSpace(r.Header.Get("CF-Connecting-IP"))); cf != nil {
+		return cf
+	}
+
+	var hops []string
+	for _, h := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(h, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !isTrusted(hop, trusted) {
+			break
+		}
+	}
+	return ip
+}
@@ -0,0 +1,28 @@
+// gen-fixtures writes the fixture archive corpus to a directory, for load
+// tests and manual testing against a running API:
+//
+//	go run ./cmd/gen-fixtures -out ./fixtures-out
+package main
+
+import (
+	"flag"
+	"log"
+
+	"shiba-api/fixtures"
+)
+
+func main() {
+	out := flag.String("out", "./fixtures-out", "directory to write the archives to")
+	flag.Parse()
+
+	if err := fixtures.WriteCorpus(*out); err != nil {
+		log.Fatal(err)
+	}
+	for _, f := range fixtures.Corpus() {
+		verdict := "reject"
+		if f.Accept {
+			verdict = "accept"
+		}
+		log.Printf("%s.zip (%s): %s", f.Name, verdict, f.Description)
+	}
+}
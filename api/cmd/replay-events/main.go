@@ -0,0 +1,61 @@
+// replay-events replays the upload event log and prints either the timeline
+// of one upload or the current state of every upload:
+//
+//	go run ./cmd/replay-events -log ./data/events.jsonl
+//	go run ./cmd/replay-events -log ./data/events.jsonl -game <gameId>
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"shiba-api/events"
+)
+
+type state struct {
+	last    events.Event
+	history []string
+}
+
+func main() {
+	path := flag.String("log", "./data/events.jsonl", "event log to replay")
+	game := flag.String("game", "", "only show the timeline of this game ID")
+	flag.Parse()
+
+	states := map[string]*state{}
+	err := events.ReplayFile(*path, func(e events.Event) error {
+		if *game != "" {
+			if e.GameID == *game {
+				fmt.Printf("#%d %s %-9s %s %s\n", e.Seq, e.At.Format(time.RFC3339), e.Type, e.Actor, e.Detail)
+			}
+			return nil
+		}
+		s := states[e.GameID]
+		if s == nil {
+			s = &state{}
+			states[e.GameID] = s
+		}
+		s.last = e
+		s.history = append(s.history, e.Type)
+		return nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *game != "" {
+		return
+	}
+
+	ids := make([]string, 0, len(states))
+	for id := range states {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		s := states[id]
+		fmt.Printf("%s %-9s %s %v\n", id, s.last.Type, s.last.At.Format(time.RFC3339), s.history)
+	}
+}
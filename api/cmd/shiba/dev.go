@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"shiba-api/playpolicy"
+	"shiba-api/validate"
+)
+
+// The build is served under the same kind of path as on Shiba, so links
+// starting with / break here too
+const devGamePath = "/play/dev/"
+
+func devCommand(args []string) {
+	flags := flag.NewFlagSet("dev", flag.ExitOnError)
+	flags.Usage = func() {
+		usage()
+		fmt.Fprintln(os.Stderr, "\nflags:")
+		flags.PrintDefaults()
+	}
+	port := flags.Int("port", 8080, "port of the page playing the game; the game itself is served on the next one")
+	connect := flags.String("connect", "", "comma separated origins the game may fetch from, as in the project's origin allowlist")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	if err := runDev(flags.Arg(0), *port, splitOrigins(*connect)); err != nil {
+		fmt.Fprintln(os.Stderr, "shiba:", err)
+		os.Exit(1)
+	}
+}
+
+func splitOrigins(list string) []string {
+	var origins []string
+	for _, o := range strings.Split(list, ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			origins = append(origins, o)
+		}
+	}
+	return origins
+}
+
+// runDev serves dir the way Shiba does, until interrupted. The game is
+// served on its own origin with the production CSP, embedded by a page on
+// another origin with the site's sandboxed iframe and cross-origin isolation
+// headers, so what breaks on Shiba breaks here.
+func runDev(dir string, port int, connect []string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory: unzip the build first", dir)
+	}
+	playpolicy.RegisterMIMETypes()
+
+	// The checks an upload goes through, reported without stopping the
+	// preview
+	findings, err := validate.CheckPlayable(dir, false)
+	if err != nil {
+		return err
+	}
+	for _, f := range findings {
+		fmt.Fprintf(os.Stderr, "error: %s: %s (%s)\n", f.Path, f.Detail, f.Rule)
+	}
+	if len(findings) > 0 {
+		fmt.Fprintln(os.Stderr, "Shiba would reject this build.")
+	}
+	if warnings, err := validate.LintHTML(dir); err == nil {
+		for _, w := range warnings {
+			fmt.Fprintf(os.Stderr, "warning: %s: %s (%s)\n", w.Path, w.Detail, w.Rule)
+		}
+	}
+
+	gamePort := port + 1
+	pageOrigins := []string{"http://localhost:" + strconv.Itoa(port), "http://127.0.0.1:" + strconv.Itoa(port)}
+	gameURL := "http://localhost:" + strconv.Itoa(gamePort) + devGamePath
+
+	page := http.NewServeMux()
+	page.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		for k, v := range playpolicy.EmbedderHeaders {
+			w.Header().Set(k, v)
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write(playpolicy.Wrapper("shiba dev: "+filepath.Base(dir), gameURL))
+	})
+	game := &devGameHandler{dir: dir, csp: playpolicy.CSP(connect, pageOrigins)}
+
+	servers := []*http.Server{
+		{Addr: "127.0.0.1:" + strconv.Itoa(port), Handler: page, ReadHeaderTimeout: 10 * time.Second},
+		{Addr: "127.0.0.1:" + strconv.Itoa(gamePort), Handler: game, ReadHeaderTimeout: 10 * time.Second},
+	}
+	errs := make(chan error, len(servers))
+	for _, s := range servers {
+		ln, err := net.Listen("tcp", s.Addr)
+		if err != nil {
+			return err
+		}
+		go func() { errs <- s.Serve(ln) }()
+	}
+	fmt.Fprintf(os.Stderr, "Serving %s\nPlay it at http://localhost:%d (the game alone: %s)\n", dir, port, gameURL)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	select {
+	case err = <-errs:
+	case <-ctx.Done():
+	}
+	for _, s := range servers {
+		s.Close()
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// devGameHandler serves a build's files like /play/{gameId}/ does, logging
+// every request. Paths are matched case-sensitively, as on Shiba, even on
+// the case-insensitive disks of Windows and macOS.
+type devGameHandler struct {
+	dir string
+	csp string
+}
+
+func (h *devGameHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	defer func() { log.Printf("%d %s", rec.status, r.URL.Path) }()
+
+	if r.URL.Path == strings.TrimSuffix(devGamePath, "/") {
+		http.Redirect(rec, r, devGamePath, http.StatusFound)
+		return
+	}
+	rel, ok := strings.CutPrefix(r.URL.Path, devGamePath)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "  %s is outside the game's folder: on Shiba it points at the API, not the build. Use a relative path\n", r.URL.Path)
+		http.NotFound(rec, r)
+		return
+	}
+	if rel == "" || strings.HasSuffix(rel, "/") {
+		rel += "index.html"
+	}
+	rel = path.Clean(rel)
+
+	file, ok, err := exactCase(h.dir, rel)
+	if err != nil {
+		http.NotFound(rec, r)
+		return
+	}
+	if !ok {
+		fmt.Fprintf(os.Stderr, "  %s only matches %s: file names are case-sensitive on Shiba\n", rel, filepath.ToSlash(file))
+		http.NotFound(rec, r)
+		return
+	}
+	rec.Header().Set("Content-Security-Policy", h.csp)
+	rec.Header().Set("Cache-Control", "no-store")
+	http.ServeFile(rec, r, filepath.Join(h.dir, filepath.FromSlash(rel)))
+}
+
+// exactCase looks rel up under dir one name at a time. ok is false when it
+// only exists in another case, which file is then the path of.
+func exactCase(dir, rel string) (file string, ok bool, err error) {
+	current := dir
+	ok = true
+	for _, name := range strings.Split(rel, "/") {
+		entries, err := os.ReadDir(current)
+		if err != nil {
+			return "", false, err
+		}
+		match := ""
+		for _, e := range entries {
+			if e.Name() == name {
+				match = name
+				break
+			}
+			if strings.EqualFold(e.Name(), name) && match == "" {
+				match = e.Name()
+			}
+		}
+		if match == "" {
+			return "", false, os.ErrNotExist
+		}
+		ok = ok && match == name
+		current = filepath.Join(current, match)
+	}
+	rel, err = filepath.Rel(dir, current)
+	return rel, ok, err
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
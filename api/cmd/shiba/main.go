@@ -0,0 +1,300 @@
+// shiba uploads a build from the terminal or a CI job. It zips a build
+// directory, checks it against the server's archive limits so a build that
+// would be rejected fails before it is sent, uploads it with a progress bar
+// and prints the play URL:
+//
+//	export SHIBA_API_URL=https://api.example.com SHIBA_TOKEN=...
+//	go run ./cmd/shiba upload -project rec123 ./build/web
+//	go run ./cmd/shiba upload -check build.zip
+//
+// shiba dev previews a build locally the way Shiba serves it, see dev.go:
+//
+//	go run ./cmd/shiba dev ./build/web
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"shiba-api/client"
+	"shiba-api/validate"
+)
+
+// The server's default MAX_BUILD_SIZE_MB
+const defaultMaxSizeMB = 1024
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: shiba upload [flags] <build directory or zip>")
+	fmt.Fprintln(os.Stderr, "       shiba dev [flags] <build directory>")
+	fmt.Fprintln(os.Stderr, "\nSHIBA_API_URL and SHIBA_TOKEN pick the API and the account to upload as.")
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	switch os.Args[1] {
+	case "upload":
+		uploadCommand(os.Args[2:])
+	case "dev":
+		devCommand(os.Args[2:])
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+func uploadCommand(args []string) {
+	flags := flag.NewFlagSet("upload", flag.ExitOnError)
+	flags.Usage = func() {
+		usage()
+		fmt.Fprintln(os.Stderr, "\nflags:")
+		flags.PrintDefaults()
+	}
+	var opts client.UploadOptions
+	flags.StringVar(&opts.ProjectID, "project", "", "upload as a new version of this project ID")
+	flags.StringVar(&opts.Changelog, "changelog", "", "what changed in this version")
+	flags.StringVar(&opts.Engine, "engine", "", "engine the build was made with, e.g. godot")
+	flags.StringVar(&opts.EngineVersion, "engine-version", "", "engine version, e.g. 4.3")
+	flags.BoolVar(&opts.Draft, "draft", false, "upload a private preview instead of publishing")
+	maxSizeMB := flags.Int64("max-size", defaultMaxSizeMB, "extracted size limit to check against, in MB (the server's MAX_BUILD_SIZE_MB)")
+	checkOnly := flags.Bool("check", false, "only check the build, don't upload it")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(flags.Arg(0), opts, *maxSizeMB<<20, *checkOnly); err != nil {
+		fmt.Fprintln(os.Stderr, "shiba:", err)
+		os.Exit(1)
+	}
+}
+
+func run(path string, opts client.UploadOptions, maxBytes int64, checkOnly bool) error {
+	var c *client.Client
+	if !checkOnly {
+		var err error
+		if c, err = client.FromEnv(); err != nil {
+			return err
+		}
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	zipPath := path
+	if info.IsDir() {
+		tmp, err := os.CreateTemp("", "shiba-*.zip")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		fmt.Fprintf(os.Stderr, "Zipping %s...\n", path)
+		err = zipDir(tmp, path)
+		if closeErr := tmp.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("failed to zip %s: %w", path, err)
+		}
+		zipPath = tmp.Name()
+	}
+
+	entries, size, err := checkZip(zipPath, maxBytes)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Build OK: %d files, %s extracted\n", entries, formatMB(size))
+	if checkOnly {
+		return nil
+	}
+
+	bar := &progressBar{out: os.Stderr}
+	if isTerminal(os.Stderr) {
+		opts.Progress = bar.update
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	res, err := c.UploadGame(ctx, zipPath, opts)
+	bar.finish()
+	if err != nil {
+		return describe(err)
+	}
+
+	for _, w := range res.Warnings {
+		fmt.Fprintf(os.Stderr, "warning: %s: %s (%s)\n", w.Path, w.Detail, w.Rule)
+	}
+	fmt.Fprintf(os.Stderr, "Uploaded %s (project %s)\n", res.GameID, res.ProjectID)
+	link := res.PlayURL
+	if link == "" {
+		link = res.DownloadURL
+	}
+	if strings.HasPrefix(link, "/") {
+		link = strings.TrimRight(c.BaseURL, "/") + link
+	}
+	fmt.Println(link)
+	return nil
+}
+
+// zipDir writes the files under dir to w, leaving out dotfiles and
+// directories such as .git, and anything that isn't a regular file.
+func zipDir(w io.Writer, dir string) error {
+	zw := zip.NewWriter(w)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != dir && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		header.Method = zip.Deflate
+		dst, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		src, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		_, err = io.Copy(dst, src)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// checkZip runs the server's archive checks on the zip at path and returns
+// its file count and extracted size.
+func checkZip(path string, maxBytes int64) (int, int64, error) {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s is not a valid zip: %w", path, err)
+	}
+	defer zr.Close()
+
+	if err := validate.CheckArchive(zr.File, maxBytes); err != nil {
+		return 0, 0, err
+	}
+	var files int
+	var size int64
+	for _, f := range zr.File {
+		if !f.FileInfo().IsDir() && !strings.HasPrefix(f.Name, "__MACOSX/") {
+			files++
+			size += int64(f.UncompressedSize64)
+		}
+	}
+	if files == 0 {
+		return 0, 0, errors.New("the build is empty")
+	}
+	return files, size, nil
+}
+
+// describe spells out the details of an API failure worth acting on.
+func describe(err error) error {
+	var apiErr *client.APIError
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+	var b strings.Builder
+	b.WriteString(apiErr.Message)
+	for _, f := range apiErr.Findings {
+		fmt.Fprintf(&b, "\n  %s: %s (%s)", f.Path, f.Detail, f.Rule)
+	}
+	for _, f := range apiErr.Fields {
+		fmt.Fprintf(&b, "\n  %s: %s", f.Field, f.Message)
+	}
+	if apiErr.RequestID != "" {
+		fmt.Fprintf(&b, "\nRequest ID: %s", apiErr.RequestID)
+	}
+	return errors.New(b.String())
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// progressBar redraws a line on a terminal as an upload goes out, at most
+// every redrawInterval. Updates come from the goroutine sending the upload.
+type progressBar struct {
+	mu    sync.Mutex
+	out   io.Writer
+	drawn time.Time
+	sent  int64
+	total int64
+}
+
+const (
+	barWidth       = 30
+	redrawInterval = 100 * time.Millisecond
+)
+
+func (p *progressBar) update(sent, total int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sent, p.total = sent, total
+	if sent < total && time.Since(p.drawn) < redrawInterval {
+		return
+	}
+	p.drawn = time.Now()
+	p.draw()
+}
+
+func (p *progressBar) draw() {
+	if p.total <= 0 {
+		return
+	}
+	filled := int(p.sent * barWidth / p.total)
+	fmt.Fprintf(p.out, "\r[%s%s] %3d%% %s / %s", strings.Repeat("=", filled), strings.Repeat(" ", barWidth-filled),
+		p.sent*100/p.total, formatMB(p.sent), formatMB(p.total))
+}
+
+// finish ends the bar's line, if it was drawn.
+func (p *progressBar) finish() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.drawn.IsZero() {
+		fmt.Fprintln(p.out)
+	}
+}
+
+func formatMB(n int64) string {
+	return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+}
@@ -0,0 +1,97 @@
+package compliance
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Actions gated on eligibility
+const (
+	ShopOrder       = "shop_order"
+	PrizeSubmission = "prize_submission"
+)
+
+// Error codes returned to the frontend, stable so it can render its own copy
+const (
+	CodeBirthdayMissing  = "birthday_missing"
+	CodeCountryMissing   = "country_missing"
+	CodeTooYoung         = "age_below_minimum"
+	CodeTooOld           = "age_above_maximum"
+	CodeRegionRestricted = "region_restricted"
+)
+
+// Rules are the organizer-configured eligibility limits.
+type Rules struct {
+	MinAge int
+	MaxAge int
+	// RestrictedCountries holds lowercased country names or ISO codes
+	RestrictedCountries map[string]bool
+}
+
+// Profile is the part of a user record eligibility depends on.
+type Profile struct {
+	Birthday *time.Time
+	Country  string
+}
+
+// Denial explains why an action is not allowed.
+type Denial struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (d *Denial) Error() string { return d.Message }
+
+// ParseProfile reads the birthday (YYYY-MM-DD) and country fields of an
+// Airtable Users record.
+func ParseProfile(fields map[string]any) Profile {
+	var p Profile
+	if s, ok := fields["birthday"].(string); ok {
+		if t, err := time.Parse("2006-01-02", strings.TrimSpace(s)); err == nil {
+			p.Birthday = &t
+		}
+	}
+	if s, ok := fields["country"].(string); ok {
+		p.Country = strings.TrimSpace(s)
+	}
+	return p
+}
+
+// Age returns the age in whole years on now.
+func Age(birthday, now time.Time) int {
+	years := now.Year() - birthday.Year()
+	if now.Month() < birthday.Month() || now.Month() == birthday.Month() && now.Day() < birthday.Day() {
+		years--
+	}
+	return years
+}
+
+// Check returns nil when the profile may perform action, or the first rule it
+// breaks. Shop orders need a country to ship to; a birthday is only needed
+// when an age limit is set.
+func Check(p Profile, rules Rules, action string, now time.Time) *Denial {
+	if rules.MinAge > 0 || rules.MaxAge > 0 {
+		if p.Birthday == nil {
+			return &Denial{CodeBirthdayMissing, "Add your birthday to your profile first"}
+		}
+		age := Age(*p.Birthday, now)
+		if rules.MinAge > 0 && age < rules.MinAge {
+			return &Denial{CodeTooYoung, fmt.Sprintf("You must be at least %d", rules.MinAge)}
+		}
+		if rules.MaxAge > 0 && age > rules.MaxAge {
+			return &Denial{CodeTooOld, fmt.Sprintf("This event is for ages %d and under", rules.MaxAge)}
+		}
+	}
+
+	if p.Country == "" {
+		if action == ShopOrder {
+			return &Denial{CodeCountryMissing, "Add your country to your profile first"}
+		}
+		return nil
+	}
+	if rules.RestrictedCountries[strings.ToLower(p.Country)] {
+		return &Denial{CodeRegionRestricted, "This isn't available in " + p.Country}
+	}
+	return nil
+}
@@ -0,0 +1,604 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"shiba-api/clientip"
+	"shiba-api/compliance"
+	"shiba-api/costs"
+
+	"github.com/joho/godotenv"
+)
+
+// Config holds the settings that can change while the server runs. Everything
+// else (credentials, paths) is read once at startup.
+type Config struct {
+	// QuotaLimits maps quota resource -> daily allowance
+	QuotaLimits             map[string]int
+	AllowDownloadableBuilds bool
+	CosignEnabled           bool
+	JudgingRubric           []string
+	ResultsRevealAt         *time.Time
+	// Uploads are rejected after SubmissionDeadline, except for users on
+	// LateSubmissionAllowlist
+	SubmissionDeadline      *time.Time
+	LateSubmissionAllowlist map[string]bool
+	// TrustedProxies may set X-Forwarded-For / CF-Connecting-IP
+	TrustedProxies []*net.IPNet
+	// Eligibility for shop orders and prize-eligible submissions
+	Eligibility compliance.Rules
+	// EventID selects the set of post-processing hooks applied to new builds
+	EventID string
+	// ServiceWorkers injects a generated caching service worker into builds
+	ServiceWorkers bool
+	// InjectSDK adds the Shiba SDK, reporting plays to the embedding page,
+	// to the index.html of new web builds
+	InjectSDK bool
+	// PlaySessionsOnly credits playtime only from play session heartbeats,
+	// refusing playtime reported to /activity
+	PlaySessionsOnly bool
+	// The retention job purges hourly stats older than RawRetentionDays and
+	// anonymizes upload IPs older than IPRetentionDays; 0 keeps them forever
+	RawRetentionDays int
+	IPRetentionDays  int
+	// A user is eligible for prizes with PrizeMinHours of Hackatime across
+	// their projects, PrizeMinPlaytests games of others played for at least
+	// PrizePlaytestMinutes each, a shipped build and PrizeMinFeedback
+	// feedback on their games. 0 drops a requirement
+	PrizeMinHours        int
+	PrizeMinPlaytests    int
+	PrizePlaytestMinutes int
+	PrizeMinFeedback     int
+	// HackatimeWebhookSecret signs the heartbeats Hackatime pushes; the
+	// webhook is off when it's empty
+	HackatimeWebhookSecret string
+	// Cloud saves are capped at SaveMaxKB each and SaveGameMaxMB for all the
+	// saves of a game
+	SaveMaxKB     int
+	SaveGameMaxMB int
+	// UploadsInFlight is how many uploads one caller may have processing at
+	// the same time
+	UploadsInFlight int
+	// MaxBuildSizeMB caps the extracted size of a build, its data packs
+	// included
+	MaxBuildSizeMB int
+	// Uploads and syncs hold at most MemoryBudgetMB between them, 0 for 75%
+	// of GOMEMLIMIT. Each upload is admitted with UploadMemoryMB, waiting up
+	// to MemoryQueueTimeout for it
+	MemoryBudgetMB     int
+	UploadMemoryMB     int
+	MemoryQueueTimeout time.Duration
+	// DemoUploads lets people without an account upload builds of up to
+	// DemoMaxSizeMB to try publishing, with a Turnstile challenge solved
+	// with TurnstileSecret. At most DemoMaxActive of them are kept at once
+	DemoUploads     bool
+	DemoMaxSizeMB   int
+	DemoMaxActive   int
+	TurnstileSecret string
+	// ClamAVAddress is the clamd extracted builds are scanned with before
+	// they're published, none if empty. Files over ClamAVMaxFileMB aren't
+	// sent, matching clamd's StreamMaxLength
+	ClamAVAddress   string
+	ClamAVMaxFileMB int
+	// StorageQuotaMB caps what each user's builds may take up between
+	// them, 0 for no cap
+	StorageQuotaMB int
+	// The server reports not ready with less than MinFreeDiskMB free for
+	// ./games
+	MinFreeDiskMB int
+	// CostRates price the storage and traffic in cost reports
+	CostRates costs.Rates
+	// WasmCheck compiles and instantiates uploaded .wasm modules in a
+	// sandbox with memories capped at WasmMaxMemoryMB, which uploads hold
+	// in the memory budget while checked
+	WasmCheck       bool
+	WasmMaxMemoryMB int
+	// UnversionedSunset is announced on the deprecated unversioned routes
+	UnversionedSunset *time.Time
+	// FaultInjection honors X-Shiba-Faults, for chaos testing in staging
+	FaultInjection bool
+	// NormalizeKeyCase lowercases the paths of uploaded builds, and of
+	// requests for them
+	NormalizeKeyCase bool
+	// Seeding allows filling the deployment with made-up data, for staging
+	Seeding bool
+	// Builds are synced to R2 SyncWorkers files at a time. Files larger than
+	// SyncPartSizeMB go up as multipart uploads, SyncPartConcurrency parts
+	// at a time.
+	SyncWorkers         int
+	SyncPartConcurrency int
+	SyncPartSizeMB      int
+	// SyncRetries is how many more times a file that failed to sync is tried
+	SyncRetries int
+	// TrashDays keeps deleted builds in R2 under the trash prefix for this
+	// many days instead of deleting them right away; 0 deletes them.
+	// ManageLifecycle lets the API set the bucket's lifecycle rule expiring
+	// them
+	TrashDays       int
+	ManageLifecycle bool
+	// ContentAddressed stores build files in R2 once per content, as blobs
+	// named by their hash
+	ContentAddressed bool
+	// Extracted builds get BuildFileMode and BuildDirMode whatever their
+	// archive says, and are chowned to BuildUID:BuildGID unless they're -1
+	BuildFileMode os.FileMode
+	BuildDirMode  os.FileMode
+	BuildUID      int
+	BuildGID      int
+}
+
+var quotaLimitEnv = map[string]string{
+	"uploads":      "QUOTA_UPLOADS_PER_DAY",
+	"feedback":     "QUOTA_FEEDBACK_PER_DAY",
+	"reads":        "QUOTA_READS_PER_DAY",
+	"demo_uploads": "QUOTA_DEMO_UPLOADS_PER_DAY",
+	"scores":       "QUOTA_SCORES_PER_DAY",
+	"saves":        "QUOTA_SAVES_PER_DAY",
+	"restores":     "QUOTA_RESTORES_PER_DAY",
+}
+
+var defaultQuotaValues = map[string]int{
+	"uploads":      50,
+	"feedback":     200,
+	"reads":        10000,
+	"demo_uploads": 3,
+	"scores":       1000,
+	"saves":        2000,
+	"restores":     10,
+}
+
+// Countries under comprehensive sanctions, applied unless
+// RESTRICTED_COUNTRIES overrides the list
+var defaultRestrictedCountries = []string{"cuba", "cu", "iran", "ir", "north korea", "kp", "syria", "sy"}
+
+var defaultRubric = []string{"fun", "art", "creativity", "audio", "mood"}
+
+func parseTime(key string) (*time.Time, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be an RFC 3339 time: %v", key, err)
+	}
+	return &t, nil
+}
+
+func parseList(key string) []string {
+	var list []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func parseAge(key string, def int) (int, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative integer", key)
+	}
+	return n, nil
+}
+
+func parseDays(key string, def int) (int, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative number of days", key)
+	}
+	return n, nil
+}
+
+func parseCount(key string, def int) (int, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative integer", key)
+	}
+	return n, nil
+}
+
+func parseRate(key string, def float64) (float64, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative number", key)
+	}
+	return f, nil
+}
+
+// parseMode reads an octal permission mode that must grant its owner at
+// least need and never lets others write.
+func parseMode(key string, def, need os.FileMode) (os.FileMode, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.ParseUint(v, 8, 32)
+	mode := os.FileMode(n)
+	if err != nil || mode > 0777 || mode&need != need || mode&0002 != 0 {
+		return 0, fmt.Errorf("%s must be an octal mode up to 0777 granting its owner %#o and not writable by others", key, need)
+	}
+	return mode, nil
+}
+
+// parseOwner reads a user[:group], as names or IDs, and returns their IDs,
+// -1 when unset. Without a group the user's primary group is used.
+func parseOwner(key string) (uid, gid int, err error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return -1, -1, nil
+	}
+	name, group, hasGroup := strings.Cut(v, ":")
+	u, err := user.Lookup(name)
+	if err != nil {
+		if u, err = user.LookupId(name); err != nil {
+			return 0, 0, fmt.Errorf("%s: unknown user %q", key, name)
+		}
+	}
+	gidStr := u.Gid
+	if hasGroup {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			if g, err = user.LookupGroupId(group); err != nil {
+				return 0, 0, fmt.Errorf("%s: unknown group %q", key, group)
+			}
+		}
+		gidStr = g.Gid
+	}
+	uid, err = strconv.Atoi(u.Uid)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s: user %q has no numeric ID", key, name)
+	}
+	gid, err = strconv.Atoi(gidStr)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s: group %q has no numeric ID", key, gidStr)
+	}
+	return uid, gid, nil
+}
+
+func costRatesFromEnv() (costs.Rates, error) {
+	rates := costs.DefaultRates
+	var err error
+	if rates.StorageGBMonth, err = parseRate("R2_STORAGE_USD_PER_GB_MONTH", rates.StorageGBMonth); err != nil {
+		return rates, err
+	}
+	if rates.IAStorageGBMonth, err = parseRate("R2_IA_STORAGE_USD_PER_GB_MONTH", rates.IAStorageGBMonth); err != nil {
+		return rates, err
+	}
+	if rates.MillionRequests, err = parseRate("R2_USD_PER_MILLION_READS", rates.MillionRequests); err != nil {
+		return rates, err
+	}
+	if rates.EgressGB, err = parseRate("EGRESS_USD_PER_GB", rates.EgressGB); err != nil {
+		return rates, err
+	}
+	return rates, nil
+}
+
+func eligibilityFromEnv() (compliance.Rules, error) {
+	rules := compliance.Rules{RestrictedCountries: map[string]bool{}}
+
+	var err error
+	// Age limits differ per event, so there are none unless configured
+	if rules.MinAge, err = parseAge("ELIGIBLE_MIN_AGE", 0); err != nil {
+		return rules, err
+	}
+	if rules.MaxAge, err = parseAge("ELIGIBLE_MAX_AGE", 0); err != nil {
+		return rules, err
+	}
+	if rules.MaxAge > 0 && rules.MaxAge < rules.MinAge {
+		return rules, fmt.Errorf("ELIGIBLE_MAX_AGE must not be below ELIGIBLE_MIN_AGE")
+	}
+
+	countries := defaultRestrictedCountries
+	if _, set := os.LookupEnv("RESTRICTED_COUNTRIES"); set {
+		countries = parseList("RESTRICTED_COUNTRIES")
+	}
+	for _, c := range countries {
+		rules.RestrictedCountries[strings.ToLower(c)] = true
+	}
+	return rules, nil
+}
+
+// FromEnv builds a Config from the process environment, rejecting invalid
+// values instead of silently falling back to defaults.
+func FromEnv() (*Config, error) {
+	cfg := &Config{
+		QuotaLimits:             map[string]int{},
+		AllowDownloadableBuilds: os.Getenv("ALLOW_DOWNLOADABLE_BUILDS") == "true",
+		CosignEnabled:           os.Getenv("COSIGN_ENABLED") == "true",
+		JudgingRubric:           defaultRubric,
+		LateSubmissionAllowlist: map[string]bool{},
+		EventID:                 os.Getenv("EVENT_ID"),
+		ServiceWorkers:          os.Getenv("SERVICE_WORKERS_ENABLED") != "false",
+		InjectSDK:               os.Getenv("SDK_INJECTION_ENABLED") == "true",
+		PlaySessionsOnly:        os.Getenv("PLAY_SESSIONS_ONLY") == "true",
+		WasmCheck:               os.Getenv("WASM_CHECK_ENABLED") == "true",
+		WasmMaxMemoryMB:         256,
+		FaultInjection:          os.Getenv("FAULT_INJECTION_ENABLED") == "true",
+		NormalizeKeyCase:        os.Getenv("NORMALIZE_KEY_CASE") == "true",
+		ContentAddressed:        os.Getenv("R2_CONTENT_ADDRESSED") == "true",
+		ManageLifecycle:         os.Getenv("R2_MANAGE_LIFECYCLE") == "true",
+		ClamAVAddress:           os.Getenv("CLAMAV_ADDRESS"),
+		DemoUploads:             os.Getenv("DEMO_UPLOADS_ENABLED") == "true",
+		TurnstileSecret:         os.Getenv("TURNSTILE_SECRET_KEY"),
+		HackatimeWebhookSecret:  os.Getenv("HACKATIME_WEBHOOK_SECRET"),
+		Seeding:                 os.Getenv("SEED_ENABLED") == "true",
+	}
+	if cfg.EventID == "" {
+		cfg.EventID = "shiba"
+	}
+
+	for resource, key := range quotaLimitEnv {
+		cfg.QuotaLimits[resource] = defaultQuotaValues[resource]
+		if v := os.Getenv(key); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("%s must be a positive integer", key)
+			}
+			cfg.QuotaLimits[resource] = n
+		}
+	}
+
+	if rubric := parseList("JUDGING_RUBRIC"); len(rubric) > 0 {
+		for i := range rubric {
+			rubric[i] = strings.ToLower(rubric[i])
+		}
+		cfg.JudgingRubric = rubric
+	}
+
+	var err error
+	if cfg.ResultsRevealAt, err = parseTime("RESULTS_REVEAL_AT"); err != nil {
+		return nil, err
+	}
+	if cfg.SubmissionDeadline, err = parseTime("SUBMISSION_DEADLINE"); err != nil {
+		return nil, err
+	}
+	if cfg.UnversionedSunset, err = parseTime("API_UNVERSIONED_SUNSET"); err != nil {
+		return nil, err
+	}
+	for _, id := range parseList("LATE_SUBMISSION_ALLOWLIST") {
+		cfg.LateSubmissionAllowlist[id] = true
+	}
+	if cfg.TrustedProxies, err = clientip.ParseTrusted(os.Getenv("TRUSTED_PROXIES")); err != nil {
+		return nil, err
+	}
+
+	if cfg.Eligibility, err = eligibilityFromEnv(); err != nil {
+		return nil, err
+	}
+	cfg.UploadsInFlight = 2
+	if v := os.Getenv("UPLOADS_IN_FLIGHT_PER_USER"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("UPLOADS_IN_FLIGHT_PER_USER must be a positive integer")
+		}
+		cfg.UploadsInFlight = n
+	}
+	cfg.MaxBuildSizeMB = 1024
+	if v := os.Getenv("MAX_BUILD_SIZE_MB"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("MAX_BUILD_SIZE_MB must be a positive integer")
+		}
+		cfg.MaxBuildSizeMB = n
+	}
+	if v := os.Getenv("MEMORY_BUDGET_MB"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("MEMORY_BUDGET_MB must be a non-negative integer")
+		}
+		cfg.MemoryBudgetMB = n
+	}
+	cfg.UploadMemoryMB = 64
+	if v := os.Getenv("UPLOAD_MEMORY_MB"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 4096 {
+			return nil, fmt.Errorf("UPLOAD_MEMORY_MB must be between 1 and 4096")
+		}
+		cfg.UploadMemoryMB = n
+	}
+	cfg.MemoryQueueTimeout = 2 * time.Minute
+	if v := os.Getenv("MEMORY_QUEUE_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 || d > time.Hour {
+			return nil, fmt.Errorf("MEMORY_QUEUE_TIMEOUT must be a duration of at most 1h")
+		}
+		cfg.MemoryQueueTimeout = d
+	}
+	cfg.DemoMaxSizeMB = 10
+	if v := os.Getenv("DEMO_MAX_SIZE_MB"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 100 {
+			return nil, fmt.Errorf("DEMO_MAX_SIZE_MB must be between 1 and 100")
+		}
+		cfg.DemoMaxSizeMB = n
+	}
+	cfg.DemoMaxActive = 200
+	if v := os.Getenv("DEMO_MAX_ACTIVE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("DEMO_MAX_ACTIVE must be a positive integer")
+		}
+		cfg.DemoMaxActive = n
+	}
+	cfg.SaveMaxKB = 256
+	if v := os.Getenv("SAVE_MAX_KB"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 10240 {
+			return nil, fmt.Errorf("SAVE_MAX_KB must be between 1 and 10240")
+		}
+		cfg.SaveMaxKB = n
+	}
+	cfg.SaveGameMaxMB = 50
+	if v := os.Getenv("SAVE_GAME_MAX_MB"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 10240 {
+			return nil, fmt.Errorf("SAVE_GAME_MAX_MB must be between 1 and 10240")
+		}
+		cfg.SaveGameMaxMB = n
+	}
+	cfg.ClamAVMaxFileMB = 25
+	if v := os.Getenv("CLAMAV_MAX_FILE_MB"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 4096 {
+			return nil, fmt.Errorf("CLAMAV_MAX_FILE_MB must be between 1 and 4096")
+		}
+		cfg.ClamAVMaxFileMB = n
+	}
+	if v := os.Getenv("STORAGE_QUOTA_MB"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("STORAGE_QUOTA_MB must be a non-negative integer")
+		}
+		cfg.StorageQuotaMB = n
+	}
+	cfg.MinFreeDiskMB = 1024
+	if v := os.Getenv("MIN_FREE_DISK_MB"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("MIN_FREE_DISK_MB must be a non-negative integer")
+		}
+		cfg.MinFreeDiskMB = n
+	}
+	if v := os.Getenv("WASM_CHECK_MAX_MEMORY_MB"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 4096 {
+			return nil, fmt.Errorf("WASM_CHECK_MAX_MEMORY_MB must be between 1 and 4096")
+		}
+		cfg.WasmMaxMemoryMB = n
+	}
+	if v := os.Getenv("R2_TRASH_DAYS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > 3650 {
+			return nil, fmt.Errorf("R2_TRASH_DAYS must be between 0 and 3650")
+		}
+		cfg.TrashDays = n
+	}
+	cfg.SyncWorkers = 8
+	if v := os.Getenv("R2_SYNC_WORKERS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 64 {
+			return nil, fmt.Errorf("R2_SYNC_WORKERS must be between 1 and 64")
+		}
+		cfg.SyncWorkers = n
+	}
+	cfg.SyncPartConcurrency = 4
+	if v := os.Getenv("R2_SYNC_PART_CONCURRENCY"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 32 {
+			return nil, fmt.Errorf("R2_SYNC_PART_CONCURRENCY must be between 1 and 32")
+		}
+		cfg.SyncPartConcurrency = n
+	}
+	// R2, like S3, needs parts of at least 5 MB, and allows 10000 of them
+	cfg.SyncPartSizeMB = 16
+	if v := os.Getenv("R2_SYNC_PART_SIZE_MB"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 5 || n > 512 {
+			return nil, fmt.Errorf("R2_SYNC_PART_SIZE_MB must be between 5 and 512")
+		}
+		cfg.SyncPartSizeMB = n
+	}
+	cfg.SyncRetries = 4
+	if v := os.Getenv("R2_SYNC_RETRIES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > 10 {
+			return nil, fmt.Errorf("R2_SYNC_RETRIES must be between 0 and 10")
+		}
+		cfg.SyncRetries = n
+	}
+	if cfg.CostRates, err = costRatesFromEnv(); err != nil {
+		return nil, err
+	}
+	if cfg.BuildFileMode, err = parseMode("BUILD_FILE_MODE", 0644, 0600); err != nil {
+		return nil, err
+	}
+	if cfg.BuildDirMode, err = parseMode("BUILD_DIR_MODE", 0755, 0700); err != nil {
+		return nil, err
+	}
+	if cfg.BuildUID, cfg.BuildGID, err = parseOwner("BUILD_OWNER"); err != nil {
+		return nil, err
+	}
+	if cfg.RawRetentionDays, err = parseDays("RETENTION_RAW_DAYS", 30); err != nil {
+		return nil, err
+	}
+	if cfg.IPRetentionDays, err = parseDays("RETENTION_IP_DAYS", 90); err != nil {
+		return nil, err
+	}
+	if cfg.PrizeMinHours, err = parseCount("PRIZE_MIN_HOURS", 10); err != nil {
+		return nil, err
+	}
+	if cfg.PrizeMinPlaytests, err = parseCount("PRIZE_MIN_PLAYTESTS", 3); err != nil {
+		return nil, err
+	}
+	if cfg.PrizePlaytestMinutes, err = parseCount("PRIZE_PLAYTEST_MINUTES", 5); err != nil {
+		return nil, err
+	}
+	if cfg.PrizeMinFeedback, err = parseCount("PRIZE_MIN_FEEDBACK", 1); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// Holder gives lock-free access to the current Config and swaps it atomically
+// on reload, so in-flight requests keep the config they started with.
+type Holder struct {
+	current atomic.Pointer[Config]
+}
+
+func NewHolder(cfg *Config) *Holder {
+	h := &Holder{}
+	h.current.Store(cfg)
+	return h
+}
+
+func (h *Holder) Get() *Config {
+	return h.current.Load()
+}
+
+// Reload re-reads the env file (CONFIG_FILE, or .env if present) over the
+// process environment and swaps in the new Config if it is valid. On error
+// the current Config stays in place.
+func (h *Holder) Reload() (*Config, error) {
+	file := os.Getenv("CONFIG_FILE")
+	if file == "" {
+		file = ".env"
+	}
+	if _, err := os.Stat(file); err == nil {
+		if err := godotenv.Overload(file); err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", file, err)
+		}
+	}
+
+	cfg, err := FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	h.current.Store(cfg)
+	return cfg, nil
+}
@@ -0,0 +1,144 @@
+// Package costs estimates what each build costs to store and serve, so
+// organizers can see where the Cloudflare bill comes from.
+package costs
+
+import (
+	"sync"
+	"time"
+
+	"shiba-api/store"
+)
+
+// Rates are the prices used for estimates, in US dollars.
+type Rates struct {
+	StorageGBMonth   float64 `json:"storageGbMonth"`
+	IAStorageGBMonth float64 `json:"iaStorageGbMonth"`
+	MillionRequests  float64 `json:"millionRequests"`
+	EgressGB         float64 `json:"egressGb"`
+}
+
+// DefaultRates are R2's list prices: standard and Infrequent Access storage,
+// class B operations (reads), and free egress.
+var DefaultRates = Rates{
+	StorageGBMonth:   0.015,
+	IAStorageGBMonth: 0.01,
+	MillionRequests:  0.36,
+	EgressGB:         0,
+}
+
+const gb = 1 << 30
+
+// Traffic is what was served for a build.
+type Traffic struct {
+	Requests int64 `json:"requests"`
+	Bytes    int64 `json:"bytes"`
+}
+
+func (t *Traffic) add(o Traffic) {
+	t.Requests += o.Requests
+	t.Bytes += o.Bytes
+}
+
+// Estimate prices storing storageBytes for days and serving t. Archived
+// builds are priced at the Infrequent Access rate.
+func (r Rates) Estimate(storageBytes int64, archived bool, days int, t Traffic) float64 {
+	rate := r.StorageGBMonth
+	if archived {
+		rate = r.IAStorageGBMonth
+	}
+	storage := float64(storageBytes) / gb * rate * float64(days) / 30
+	return storage + float64(t.Requests)/1e6*r.MillionRequests + float64(t.Bytes)/gb*r.EgressGB
+}
+
+// Egress documents hold one month each: day -> game ID -> traffic.
+func egressDoc(month string) string { return "egress-" + month }
+
+type egressState struct {
+	Days map[string]map[string]Traffic `json:"days"`
+}
+
+// Meter counts requests and bytes served per build in memory. Flush adds them
+// to the daily totals in the store.
+type Meter struct {
+	mu      sync.Mutex
+	pending map[string]Traffic
+}
+
+func NewMeter() *Meter {
+	return &Meter{pending: map[string]Traffic{}}
+}
+
+// Add counts one response of n bytes for a build.
+func (m *Meter) Add(gameID string, n int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := m.pending[gameID]
+	t.add(Traffic{Requests: 1, Bytes: n})
+	m.pending[gameID] = t
+}
+
+// Flush adds the counted traffic to day of now. On failure it is kept for the
+// next flush.
+func (m *Meter) Flush(st store.Store, now time.Time) error {
+	m.mu.Lock()
+	pending := m.pending
+	m.pending = map[string]Traffic{}
+	m.mu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	now = now.UTC()
+	day := now.Format("2006-01-02")
+	var state egressState
+	err := st.Update(egressDoc(now.Format("2006-01")), &state, func() error {
+		if state.Days == nil {
+			state.Days = map[string]map[string]Traffic{}
+		}
+		if state.Days[day] == nil {
+			state.Days[day] = map[string]Traffic{}
+		}
+		for id, t := range pending {
+			cur := state.Days[day][id]
+			cur.add(t)
+			state.Days[day][id] = cur
+		}
+		return nil
+	})
+	if err != nil {
+		m.mu.Lock()
+		for id, t := range pending {
+			cur := m.pending[id]
+			cur.add(t)
+			m.pending[id] = cur
+		}
+		m.mu.Unlock()
+	}
+	return err
+}
+
+// Egress sums the traffic of every build over the days from from to to.
+func Egress(st store.Store, from, to time.Time) (map[string]Traffic, error) {
+	from, to = from.UTC(), to.UTC()
+	first, last := from.Format("2006-01-02"), to.Format("2006-01-02")
+	totals := map[string]Traffic{}
+
+	start := time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, time.UTC)
+	for month := start; !month.After(to); month = month.AddDate(0, 1, 0) {
+		var state egressState
+		if err := st.Load(egressDoc(month.Format("2006-01")), &state); err != nil {
+			return nil, err
+		}
+		for day, games := range state.Days {
+			if day < first || day > last {
+				continue
+			}
+			for id, t := range games {
+				cur := totals[id]
+				cur.add(t)
+				totals[id] = cur
+			}
+		}
+	}
+	return totals, nil
+}
@@ -0,0 +1,171 @@
+package datastore
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"shiba-api/faults"
+	"shiba-api/metrics"
+	"shiba-api/users"
+	"shiba-api/writequeue"
+
+	"github.com/mehanizm/airtable"
+)
+
+// Airtable reads users from the local replica of the Users table, falling
+// back to Airtable for users it doesn't have yet, and games from the Games
+// table. Writes go through the write queue.
+type Airtable struct {
+	Users   *airtable.Table
+	Games   *airtable.Table
+	Replica *users.Replica
+	Writes  *writequeue.Queue
+}
+
+var airtableLatency = metrics.NewHistogram("shiba_airtable_request_duration_seconds",
+	"Time taken by Airtable reads, by operation.", metrics.DefaultBuckets, "op")
+
+// formulaString quotes s as a string literal in an Airtable formula.
+func formulaString(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
+
+// UserByToken matches the token's hash against the tokenHash field. Records
+// from before tokens were hashed only have the token itself, in the token
+// field: they are matched on it and get their tokenHash filled in.
+func (a *Airtable) UserByToken(ctx context.Context, token string) (*Record, error) {
+	if user, ok := a.Replica.ByToken(token); ok {
+		return user, nil
+	}
+
+	if err := faults.Inject(ctx, faults.AirtableRateLimit); err != nil {
+		return nil, fmt.Errorf("failed to look up user: %v", err)
+	}
+	hash := users.HashToken(token)
+	start := time.Now()
+	records, err := a.Users.GetRecords().
+		WithFilterFormula(fmt.Sprintf(`OR({%s} = %s, AND({%s} = "", {%s} = %s))`,
+			users.TokenHashField, formulaString(hash),
+			users.TokenHashField, users.LegacyTokenField, formulaString(token))).
+		MaxRecords(1).
+		Do()
+	airtableLatency.Since(start, "user_by_token")
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up user: %v", err)
+	}
+	if len(records.Records) == 0 {
+		return nil, ErrNotFound
+	}
+	user := records.Records[0]
+	// The hash replaces the plaintext token in one write, so the token
+	// isn't left in the base once it's hashed
+	stored, _ := user.Fields[users.TokenHashField].(string)
+	legacy, _ := user.Fields[users.LegacyTokenField].(string)
+	if stored == "" || legacy != "" {
+		go func() {
+			err := a.Writes.Update(context.Background(), a.Users, user.ID, map[string]any{users.TokenHashField: hash, users.LegacyTokenField: ""})
+			if err != nil {
+				slog.ErrorContext(ctx, "Failed to store the token hash", "user_id", user.ID, "error", err)
+			}
+		}()
+	}
+	a.Replica.Put(user)
+	delete(user.Fields, users.TokenHashField)
+	delete(user.Fields, users.LegacyTokenField)
+	return user, nil
+}
+
+func (a *Airtable) UserByID(ctx context.Context, id string) (*Record, error) {
+	if user, ok := a.Replica.ByID(id); ok {
+		return user, nil
+	}
+
+	if err := faults.Inject(ctx, faults.AirtableRateLimit); err != nil {
+		return nil, fmt.Errorf("failed to look up user: %v", err)
+	}
+	start := time.Now()
+	user, err := a.Users.GetRecord(id)
+	airtableLatency.Since(start, "user_by_id")
+	if err != nil {
+		return nil, err
+	}
+	a.Replica.Put(user)
+	delete(user.Fields, users.TokenHashField)
+	delete(user.Fields, users.LegacyTokenField)
+	return user, nil
+}
+
+func (a *Airtable) SetUserToken(ctx context.Context, id, token string) error {
+	// One update swaps the hash and clears a legacy token, so the old token
+	// stops working at once
+	fields := map[string]any{users.TokenHashField: users.HashToken(token), users.LegacyTokenField: ""}
+	if err := a.Writes.Update(ctx, a.Users, id, fields); err != nil {
+		return err
+	}
+	a.Replica.SetFields(id, fields)
+	return nil
+}
+
+func (a *Airtable) FlagUser(ctx context.Context, id, reason string) error {
+	fields := map[string]any{FlaggedField: true, FlagReasonField: reason}
+	if err := a.Writes.Update(ctx, a.Users, id, fields); err != nil {
+		return err
+	}
+	a.Replica.SetFields(id, fields)
+	return nil
+}
+
+// GameByID fetches a Games record. IDs that aren't Airtable record IDs, like
+// projects named by their first build, are not found without a request.
+func (a *Airtable) GameByID(ctx context.Context, id string) (*Record, error) {
+	if a.Games == nil || !strings.HasPrefix(id, "rec") {
+		return nil, ErrNotFound
+	}
+	start := time.Now()
+	defer airtableLatency.Since(start, "game_by_id")
+	return a.Games.GetRecord(id)
+}
+
+func (a *Airtable) Unpublish(ctx context.Context, buildID string) ([]string, error) {
+	if a.Games == nil {
+		return nil, nil
+	}
+	start := time.Now()
+	records, err := a.Games.GetRecords().
+		WithFilterFormula(fmt.Sprintf(`FIND(%s, {PlayLink})`, formulaString(buildID))).
+		ReturnFields("PlayLink").
+		Do()
+	airtableLatency.Since(start, "find_games")
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up games: %v", err)
+	}
+	if len(records.Records) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]string, len(records.Records))
+	updates := make([]*airtable.Record, len(records.Records))
+	for i, rec := range records.Records {
+		ids[i] = rec.ID
+		updates[i] = &airtable.Record{ID: rec.ID, Fields: map[string]any{"PlayLink": ""}}
+	}
+	if err := a.Writes.UpdateAll(ctx, a.Games, updates); err != nil {
+		return nil, fmt.Errorf("failed to unpublish games: %v", err)
+	}
+	return ids, nil
+}
+
+// Ping reads one user record, checking the API key and that Airtable is up.
+func (a *Airtable) Ping(ctx context.Context) error {
+	start := time.Now()
+	_, err := a.Users.GetRecords().MaxRecords(1).DoContext(ctx)
+	airtableLatency.Since(start, "ping")
+	if err != nil {
+		return fmt.Errorf("failed to reach Airtable: %v", err)
+	}
+	return nil
+}
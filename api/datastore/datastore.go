@@ -0,0 +1,59 @@
+// Package datastore hides where users and games are kept from the handlers.
+// Airtable is the default; Postgres holds the same records for deployments
+// moving off it. Both hand out records shaped like Airtable's, an ID and a
+// map of fields, so handlers read fields the same way from either.
+package datastore
+
+import (
+	"context"
+	"errors"
+
+	"github.com/mehanizm/airtable"
+)
+
+// ErrNotFound is returned for users and games the store doesn't have.
+var ErrNotFound = errors.New("not found")
+
+// Record is a user or game: its ID and fields.
+type Record = airtable.Record
+
+// UserStore looks up users.
+type UserStore interface {
+	// UserByToken finds the user owning an account token
+	UserByToken(ctx context.Context, token string) (*Record, error)
+	UserByID(ctx context.Context, id string) (*Record, error)
+	// SetUserToken replaces a user's account token
+	SetUserToken(ctx context.Context, id, token string) error
+	// FlagUser marks a user for review by the organizers, setting
+	// FlaggedField and FlagReasonField
+	FlagUser(ctx context.Context, id, reason string) error
+}
+
+// Fields of a user flagged for review
+const (
+	FlaggedField    = "Flagged"
+	FlagReasonField = "FlagReason"
+)
+
+// GameStore looks up the site's game records (Name, Owner, PlayLink, ...).
+type GameStore interface {
+	GameByID(ctx context.Context, id string) (*Record, error)
+	// Unpublish clears the PlayLink of the games pointing at a build and
+	// returns their IDs
+	Unpublish(ctx context.Context, buildID string) ([]string, error)
+}
+
+// Seeder is a store that can be filled with made-up users and games, for
+// staging deployments. Only stores the API owns implement it, so seeding
+// never writes to a shared Airtable base.
+type Seeder interface {
+	// PutUser creates or replaces a user with token as its account token
+	PutUser(ctx context.Context, id string, fields map[string]any, token string) error
+	// PutGame creates or replaces a game record
+	PutGame(ctx context.Context, id string, fields map[string]any) error
+}
+
+// Pinger is a store that can check it is reachable.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
@@ -0,0 +1,79 @@
+package datastore
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// How long a game record is served without a lookup
+	GameTTL = 5 * time.Minute
+	// Entries kept at most, so requests for made-up IDs can't grow the cache
+	// without bound
+	maxCachedGames = 20000
+)
+
+type gameEntry struct {
+	// record is nil for games the store doesn't have
+	record  *Record
+	expires time.Time
+}
+
+// GameCache remembers recently read game records, so public pages listing
+// many projects don't look each one up on every request. Records may be up to
+// GameTTL old; checks that decide ownership read the GameStore directly.
+type GameCache struct {
+	mu      sync.Mutex
+	entries map[string]gameEntry
+}
+
+func NewGameCache() *GameCache {
+	return &GameCache{entries: map[string]gameEntry{}}
+}
+
+// GameByID returns the record for id from the cache, or from games when it
+// isn't cached or has expired. Lookup errors other than ErrNotFound aren't
+// cached.
+func (c *GameCache) GameByID(ctx context.Context, games GameStore, id string) (*Record, error) {
+	now := time.Now()
+	c.mu.Lock()
+	e, ok := c.entries[id]
+	c.mu.Unlock()
+	if ok && now.Before(e.expires) {
+		if e.record == nil {
+			return nil, ErrNotFound
+		}
+		return e.record, nil
+	}
+
+	record, err := games.GameByID(ctx, id)
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+		record = nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= maxCachedGames {
+		c.prune(now)
+	}
+	c.entries[id] = gameEntry{record: record, expires: now.Add(GameTTL)}
+	return record, err
+}
+
+// prune drops expired entries, or everything if that isn't enough. Callers
+// hold mu.
+func (c *GameCache) prune(now time.Time) {
+	for id, e := range c.entries {
+		if now.After(e.expires) {
+			delete(c.entries, id)
+		}
+	}
+	if len(c.entries) >= maxCachedGames {
+		c.entries = map[string]gameEntry{}
+	}
+}
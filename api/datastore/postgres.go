@@ -0,0 +1,175 @@
+package datastore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"shiba-api/users"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// Postgres keeps users and games in two tables of JSONB fields keyed by
+// record ID, so records imported from Airtable keep their IDs and fields.
+// Account tokens are stored as their hash, in a column of their own.
+type Postgres struct {
+	db *sql.DB
+}
+
+func NewPostgres(dsn string) (*Postgres, error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open postgres: %v", err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to postgres: %v", err)
+	}
+
+	for _, stmt := range []string{
+		`CREATE TABLE IF NOT EXISTS users (
+			id         TEXT PRIMARY KEY,
+			token_hash TEXT UNIQUE,
+			fields     JSONB NOT NULL DEFAULT '{}',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`,
+		`CREATE TABLE IF NOT EXISTS games (
+			id         TEXT PRIMARY KEY,
+			fields     JSONB NOT NULL DEFAULT '{}',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to create tables: %v", err)
+		}
+	}
+	return &Postgres{db: db}, nil
+}
+
+// Ping checks the database connection.
+func (p *Postgres) Ping(ctx context.Context) error {
+	if err := p.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("failed to reach postgres: %v", err)
+	}
+	return nil
+}
+
+func scanRecord(row *sql.Row) (*Record, error) {
+	var rec Record
+	var fields []byte
+	var created time.Time
+	if err := row.Scan(&rec.ID, &fields, &created); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(fields, &rec.Fields); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %v", rec.ID, err)
+	}
+	rec.CreatedTime = created.UTC().Format(time.RFC3339)
+	return &rec, nil
+}
+
+func (p *Postgres) UserByToken(ctx context.Context, token string) (*Record, error) {
+	user, err := scanRecord(p.db.QueryRowContext(ctx,
+		`SELECT id, fields, created_at FROM users WHERE token_hash = $1`, users.HashToken(token)))
+	if err != nil && err != ErrNotFound {
+		return nil, fmt.Errorf("failed to look up user: %v", err)
+	}
+	return user, err
+}
+
+func (p *Postgres) UserByID(ctx context.Context, id string) (*Record, error) {
+	user, err := scanRecord(p.db.QueryRowContext(ctx,
+		`SELECT id, fields, created_at FROM users WHERE id = $1`, id))
+	if err != nil && err != ErrNotFound {
+		return nil, fmt.Errorf("failed to look up user %s: %v", id, err)
+	}
+	return user, err
+}
+
+func (p *Postgres) SetUserToken(ctx context.Context, id, token string) error {
+	res, err := p.db.ExecContext(ctx, `UPDATE users SET token_hash = $2 WHERE id = $1`, id, users.HashToken(token))
+	if err != nil {
+		return fmt.Errorf("failed to update user %s: %v", id, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (p *Postgres) FlagUser(ctx context.Context, id, reason string) error {
+	flag, err := json.Marshal(map[string]any{FlaggedField: true, FlagReasonField: reason})
+	if err != nil {
+		return err
+	}
+	res, err := p.db.ExecContext(ctx, `UPDATE users SET fields = fields || $2::jsonb WHERE id = $1`, id, flag)
+	if err != nil {
+		return fmt.Errorf("failed to flag user %s: %v", id, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (p *Postgres) GameByID(ctx context.Context, id string) (*Record, error) {
+	game, err := scanRecord(p.db.QueryRowContext(ctx,
+		`SELECT id, fields, created_at FROM games WHERE id = $1`, id))
+	if err != nil && err != ErrNotFound {
+		return nil, fmt.Errorf("failed to look up game %s: %v", id, err)
+	}
+	return game, err
+}
+
+func (p *Postgres) PutUser(ctx context.Context, id string, fields map[string]any, token string) error {
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	_, err = p.db.ExecContext(ctx, `INSERT INTO users (id, token_hash, fields) VALUES ($1, $2, $3)
+		ON CONFLICT (id) DO UPDATE SET token_hash = EXCLUDED.token_hash, fields = EXCLUDED.fields`,
+		id, users.HashToken(token), data)
+	if err != nil {
+		return fmt.Errorf("failed to save user %s: %v", id, err)
+	}
+	return nil
+}
+
+func (p *Postgres) PutGame(ctx context.Context, id string, fields map[string]any) error {
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	_, err = p.db.ExecContext(ctx, `INSERT INTO games (id, fields) VALUES ($1, $2)
+		ON CONFLICT (id) DO UPDATE SET fields = EXCLUDED.fields`, id, data)
+	if err != nil {
+		return fmt.Errorf("failed to save game %s: %v", id, err)
+	}
+	return nil
+}
+
+func (p *Postgres) Unpublish(ctx context.Context, buildID string) ([]string, error) {
+	rows, err := p.db.QueryContext(ctx, `UPDATE games SET fields = jsonb_set(fields, '{PlayLink}', '""')
+		WHERE strpos(fields->>'PlayLink', $1) > 0 RETURNING id`, buildID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unpublish games: %v", err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to unpublish games: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,78 @@
+// Package devchannel tells open play sessions of a game about new versions,
+// so testers swapping builds during a playtest get prompted to reload
+// instead of playing the old one.
+package devchannel
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Listeners a project may have at once; a playtest rarely has more than a
+// room full of testers
+const MaxListeners = 200
+
+// Messages a slow listener may fall behind by before missing some
+const buffer = 8
+
+// ErrTooManyListeners is returned by Subscribe when a project already has
+// MaxListeners.
+var ErrTooManyListeners = errors.New("too many listeners")
+
+// Message is what listeners are sent when a project serves another version.
+type Message struct {
+	Type      string    `json:"type"`
+	ProjectID string    `json:"projectId"`
+	GameID    string    `json:"gameId"`
+	Version   int       `json:"version,omitempty"`
+	PlayURL   string    `json:"playUrl"`
+	At        time.Time `json:"at"`
+}
+
+// Hub keeps the listeners of every project.
+type Hub struct {
+	mu        sync.Mutex
+	listeners map[string]map[chan Message]struct{}
+}
+
+func NewHub() *Hub {
+	return &Hub{listeners: map[string]map[chan Message]struct{}{}}
+}
+
+// Subscribe starts listening to a project. Call unsubscribe once done.
+func (h *Hub) Subscribe(projectID string) (messages <-chan Message, unsubscribe func(), err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.listeners[projectID]) >= MaxListeners {
+		return nil, nil, ErrTooManyListeners
+	}
+	ch := make(chan Message, buffer)
+	if h.listeners[projectID] == nil {
+		h.listeners[projectID] = map[chan Message]struct{}{}
+	}
+	h.listeners[projectID][ch] = struct{}{}
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		delete(h.listeners[projectID], ch)
+		if len(h.listeners[projectID]) == 0 {
+			delete(h.listeners, projectID)
+		}
+	}, nil
+}
+
+// Publish sends m to every listener of its project without waiting; a
+// listener whose buffer is full misses it.
+func (h *Hub) Publish(m Message) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.listeners[m.ProjectID] {
+		select {
+		case ch <- m:
+		default:
+		}
+	}
+}
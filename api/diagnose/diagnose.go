@@ -0,0 +1,288 @@
+// Package diagnose guesses why an upload failed, or why a published build
+// may not load, from its recorded events, and how to fix it, for a
+// self-serve troubleshooting page.
+package diagnose
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"shiba-api/events"
+	"shiba-api/validate"
+)
+
+// Limits of the summaries of archives
+const (
+	maxExtensions = 5
+	maxArchives   = 10
+)
+
+// File is a file of an upload, at its path once extracted.
+type File struct {
+	Path string
+	Size int64
+}
+
+// nestedArchives are extensions of archives creators zip up by mistake
+var nestedArchives = map[string]bool{".zip": true, ".rar": true, ".7z": true, ".tar": true, ".gz": true}
+
+// Profile summarizes the files of an upload for its received event.
+func Profile(files []File) *events.Archive {
+	a := &events.Archive{}
+	byExt := map[string]*events.ExtensionSize{}
+	for _, f := range files {
+		a.Files++
+		a.Bytes += f.Size
+
+		ext := strings.ToLower(path.Ext(f.Path))
+		if byExt[ext] == nil {
+			byExt[ext] = &events.ExtensionSize{Ext: ext}
+		}
+		byExt[ext].Files++
+		byExt[ext].Bytes += f.Size
+
+		if nestedArchives[ext] && len(a.Archives) < maxArchives {
+			a.Archives = append(a.Archives, f.Path)
+		}
+		if strings.EqualFold(path.Base(f.Path), "index.html") &&
+			(a.IndexHTML == "" || strings.Count(f.Path, "/") < strings.Count(a.IndexHTML, "/")) {
+			a.IndexHTML = f.Path
+		}
+	}
+	for _, e := range byExt {
+		a.Extensions = append(a.Extensions, *e)
+	}
+	sort.Slice(a.Extensions, func(i, j int) bool {
+		if a.Extensions[i].Bytes == a.Extensions[j].Bytes {
+			return a.Extensions[i].Ext < a.Extensions[j].Ext
+		}
+		return a.Extensions[i].Bytes > a.Extensions[j].Bytes
+	})
+	if len(a.Extensions) > maxExtensions {
+		a.Extensions = a.Extensions[:maxExtensions]
+	}
+	return a
+}
+
+// Cause is a probable cause of a problem with an upload and how to fix it.
+type Cause struct {
+	Code  string `json:"code"`
+	Cause string `json:"cause"`
+	Fix   string `json:"fix"`
+}
+
+// Diagnosis is what went wrong with an upload, most probable cause first.
+type Diagnosis struct {
+	// Status is the type of the upload's last event
+	Status string `json:"status"`
+	Failed bool   `json:"failed"`
+	// Failure is the error the upload failed with
+	Failure string  `json:"failure,omitempty"`
+	Causes  []Cause `json:"causes"`
+}
+
+// Diagnose runs every heuristic over the events of an upload, in order.
+func Diagnose(history []events.Event) Diagnosis {
+	d := Diagnosis{Causes: []Cause{}}
+	var failure *events.Event
+	var archive *events.Archive
+	for i, e := range history {
+		switch e.Type {
+		case events.Received:
+			archive = e.Archive
+		case events.Failed:
+			failure = &history[i]
+		case events.Synced:
+			// A sync that failed before succeeded in the end
+			failure = nil
+		}
+		d.Status = e.Type
+	}
+	if failure != nil {
+		d.Failed = true
+		d.Failure = failure.Detail
+		d.Causes = append(d.Causes, failureCauses(*failure, archive)...)
+	}
+	if archive != nil {
+		d.Causes = append(d.Causes, layoutCauses(archive, d.Causes)...)
+	}
+	if d.Failed && len(d.Causes) == 0 {
+		d.Causes = append(d.Causes, Cause{
+			Code:  "unknown",
+			Cause: "The upload failed: " + failure.Detail,
+			Fix:   "Try uploading again. If it keeps failing, ask an organizer for help and give them this upload's ID.",
+		})
+	}
+	return d
+}
+
+// failureCauses explains the error an upload failed with.
+func failureCauses(failure events.Event, archive *events.Archive) []Cause {
+	switch failure.Code {
+	case validate.CodeBuildTooLarge:
+		c := Cause{
+			Code:  "build_too_large",
+			Cause: "Your build is too large: " + reason(failure) + ".",
+			Fix:   "Remove files the game doesn't load, such as source assets or old exports.",
+		}
+		if ext, ok := dominantExtension(archive); ok {
+			c.Cause = fmt.Sprintf("Your build exceeds the limit because of %s files: %d of them take up %d MB of %d MB.",
+				ext.Ext, ext.Files, ext.Bytes>>20, archive.Bytes>>20)
+			c.Fix = shrinkAdvice(ext.Ext)
+		}
+		return []Cause{c}
+	case validate.CodeTooManyEntries:
+		return []Cause{{
+			Code:  "too_many_files",
+			Cause: "Your zip has too many files: " + reason(failure) + ".",
+			Fix:   "Zip only the web export of your game, not its project folder. Folders like .git, node_modules, .godot or Library don't belong in it.",
+		}}
+	case validate.CodeSuspiciousCompression:
+		return []Cause{{
+			Code:  "suspicious_compression",
+			Cause: "A file in your zip compresses so well that it looks like a zip bomb: " + reason(failure) + ".",
+			Fix:   "Remove padding or placeholder files full of zeros, then zip the build again.",
+		}}
+	case "archive_conflict":
+		return []Cause{{
+			Code:  "archive_conflict",
+			Cause: "The same file is in your zip and in a data pack: " + reason(failure) + ".",
+			Fix:   "Leave each file out of every archive but one.",
+		}}
+	case "case_conflict":
+		return []Cause{{
+			Code:  "case_conflict",
+			Cause: "Two files only differ in capitalization: " + reason(failure) + ".",
+			Fix:   "Rename one of them and update the references to it.",
+		}}
+	case "validation_failed":
+		return []Cause{{
+			Code:  "validation_failed",
+			Cause: "Some files failed the safety checks, such as executables or files whose content doesn't match their extension.",
+			Fix:   "The upload's response lists every offending file as findings. Remove or rename them and upload again.",
+		}}
+	case "not_playable":
+		return []Cause{{
+			Code:  "not_playable",
+			Cause: "Your upload isn't a playable web game: " + reason(failure) + ".",
+			Fix:   "Export your game for the web (HTML5) and zip what the export created, with index.html at the top of the zip.",
+		}}
+	case "malware_detected":
+		return []Cause{{
+			Code:  "malware_detected",
+			Cause: "The malware scanner matched a file of your build: " + reason(failure) + ".",
+			Fix:   "Check the file and where it came from. If you think it's a false positive, ask an organizer to review the upload.",
+		}}
+	case "unscanned_files":
+		return []Cause{{
+			Code:  "unscanned_files",
+			Cause: "A file of your build is too large for the malware scanner: " + reason(failure) + ".",
+			Fix:   "Ask an organizer to review the upload, or split large assets into smaller files and upload again.",
+		}}
+	case "storage_quota_exceeded":
+		return []Cause{{
+			Code:  "storage_quota_exceeded",
+			Cause: failure.Detail,
+			Fix:   "Delete builds you no longer need, then upload again.",
+		}}
+	}
+
+	detail := failure.Detail
+	switch {
+	case strings.Contains(detail, "is not a valid zip"):
+		return []Cause{{
+			Code:  "not_a_zip",
+			Cause: "The file you uploaded isn't a zip, or it was cut off while uploading.",
+			Fix:   "Compress your build as a .zip, not a .rar or .7z, and upload it again.",
+		}}
+	case strings.Contains(detail, "Invalid file path in zip"):
+		return []Cause{{
+			Code:  "invalid_path",
+			Cause: "Your zip has a file that would land outside the game's folder: " + detail + ".",
+			Fix:   "Zip the build from inside its folder with a regular zip tool.",
+		}}
+	case strings.HasPrefix(detail, "native "):
+		return []Cause{{
+			Code:  "native_build",
+			Cause: "You uploaded a native app (" + strings.TrimSuffix(strings.TrimPrefix(detail, "native "), " build rejected") + ") instead of a web build.",
+			Fix:   "Export your game for the web (HTML5) and upload that zip instead.",
+		}}
+	case strings.HasPrefix(detail, "data packs sent"):
+		return []Cause{{
+			Code:  "packs_not_supported",
+			Cause: "Data packs were sent with a build that isn't a web build.",
+			Fix:   "Only send data packs with a zipped web build.",
+		}}
+	case strings.HasPrefix(detail, "sync to R2 failed"):
+		return []Cause{{
+			Code:  "sync_failed",
+			Cause: "Your build was accepted, but copying it to storage failed on our side.",
+			Fix:   "Nothing to do: the copy is retried automatically. If your game still doesn't load in an hour, ask an organizer.",
+		}}
+	}
+	return nil
+}
+
+// reason is the error of a failed event without its "Build rejected" prefix.
+func reason(failure events.Event) string {
+	return strings.TrimPrefix(failure.Detail, "Build rejected: ")
+}
+
+// layoutCauses points out problems with where the files of an upload are,
+// the usual reasons a build fails validation or shows a blank page. Causes
+// already found aren't repeated.
+func layoutCauses(archive *events.Archive, found []Cause) []Cause {
+	for _, c := range found {
+		if c.Code == "not_a_zip" || c.Code == "native_build" || c.Code == "not_playable" {
+			return nil
+		}
+	}
+	switch {
+	case archive.IndexHTML == "" && len(archive.Archives) > 0:
+		return []Cause{{
+			Code:  "nested_archive",
+			Cause: fmt.Sprintf("Your zip contains an inner archive (%s) and no index.html.", archive.Archives[0]),
+			Fix:   "Extract the inner archive and zip the files of your web export directly.",
+		}}
+	case archive.IndexHTML == "":
+		return []Cause{{
+			Code:  "missing_index",
+			Cause: "Your zip has no index.html, the page that starts a web game.",
+			Fix:   "Export your game for the web (HTML5), and name the exported page index.html.",
+		}}
+	case strings.Contains(archive.IndexHTML, "/"):
+		return []Cause{{
+			Code:  "nested_index",
+			Cause: "Your index.html is in a subfolder (" + archive.IndexHTML + "), so the game's page isn't found.",
+			Fix:   "Zip the contents of " + path.Dir(archive.IndexHTML) + " instead of the folders around it.",
+		}}
+	}
+	return nil
+}
+
+// dominantExtension returns the extension taking up at least a third of an
+// upload, if one does.
+func dominantExtension(archive *events.Archive) (events.ExtensionSize, bool) {
+	if archive == nil || len(archive.Extensions) == 0 || archive.Bytes == 0 {
+		return events.ExtensionSize{}, false
+	}
+	ext := archive.Extensions[0]
+	return ext, ext.Ext != "" && ext.Bytes*3 >= archive.Bytes
+}
+
+// shrinkAdvice suggests how to make files with an extension smaller.
+func shrinkAdvice(ext string) string {
+	switch ext {
+	case ".wav", ".aiff", ".aif", ".flac":
+		return "Convert the audio to .ogg or .mp3, which are about ten times smaller, and update the references to it."
+	case ".mp4", ".mov", ".avi", ".mkv", ".webm":
+		return "Compress the videos to a lower resolution or bitrate, or cut the ones the game doesn't play."
+	case ".png", ".psd", ".tga", ".bmp", ".tif", ".tiff", ".exr":
+		return "Compress the textures, e.g. as .webp or .jpg, scale down the ones larger than shown on screen, and leave source files like .psd out."
+	case ".pck", ".data", ".wasm":
+		return "The game's data itself is too large. Exclude unused assets from the export and enable compression in the export settings."
+	}
+	return "Remove or shrink the " + ext + " files the game doesn't need."
+}
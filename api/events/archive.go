@@ -0,0 +1,24 @@
+package events
+
+// Archive summarizes the files of an uploaded zip and its data packs, so a
+// failed upload can be diagnosed once its files are gone.
+type Archive struct {
+	Files int `json:"files"`
+	// Bytes is the size of the files once extracted
+	Bytes int64 `json:"bytes"`
+	// Extensions are the file extensions taking up the most space, largest
+	// first
+	Extensions []ExtensionSize `json:"extensions,omitempty"`
+	// Archives are zips and other archives inside the upload
+	Archives []string `json:"archives,omitempty"`
+	// IndexHTML is the path of the least nested index.html, as extracted,
+	// empty if there is none
+	IndexHTML string `json:"indexHtml,omitempty"`
+}
+
+// ExtensionSize is how much of an upload is files with one extension.
+type ExtensionSize struct {
+	Ext   string `json:"ext"`
+	Files int    `json:"files"`
+	Bytes int64  `json:"bytes"`
+}
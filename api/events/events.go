@@ -0,0 +1,143 @@
+// Package events keeps an append-only log of every state transition of every
+// upload, so "my game disappeared" reports can be traced after the fact and
+// new projections can be rebuilt from history.
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Upload lifecycle event types, in pipeline order
+const (
+	Received  = "received"
+	Validated = "validated"
+	// The malware scan found nothing, only with ClamAV enabled
+	Scanned   = "scanned"
+	Extracted = "extracted"
+	// The build's files were hashed into its manifest
+	Hashed = "hashed"
+	Synced = "synced"
+	// The build is on R2, where the CDN serves it from
+	Published = "published"
+	Failed    = "failed"
+	// A build taken down by its owner
+	Deleted = "deleted"
+	// A build's metadata changed by an organizer
+	Edited = "edited"
+)
+
+type Event struct {
+	Seq    int64     `json:"seq"`
+	GameID string    `json:"gameId"`
+	Type   string    `json:"type"`
+	At     time.Time `json:"at"`
+	Actor  string    `json:"actor,omitempty"`
+	Detail string    `json:"detail,omitempty"`
+	// Code is the error code of a failed event, as sent to the uploader
+	Code string `json:"code,omitempty"`
+	// Archive summarizes the uploaded files, on received events
+	Archive *Archive `json:"archive,omitempty"`
+	// RequestID and TraceID identify the request the event happened in
+	RequestID string `json:"requestId,omitempty"`
+	TraceID   string `json:"traceId,omitempty"`
+}
+
+// Log appends events as JSON lines to a file. Events are never rewritten.
+type Log struct {
+	mu   sync.Mutex
+	path string
+	seq  int64
+}
+
+// Open opens the log at path, creating it if needed, and resumes the sequence
+// after the last event.
+func Open(path string) (*Log, error) {
+	l := &Log{path: path}
+	err := l.Replay(func(e Event) error {
+		l.seq = e.Seq
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Append stamps e with the next sequence number (and the current time if
+// unset) and writes it to the log.
+func (l *Log) Append(e Event) (Event, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if e.At.IsZero() {
+		e.At = time.Now().UTC()
+	}
+	e.Seq = l.seq + 1
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return e, fmt.Errorf("failed to encode event: %v", err)
+	}
+
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return e, fmt.Errorf("failed to open event log: %v", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return e, fmt.Errorf("failed to append event: %v", err)
+	}
+	l.seq = e.Seq
+	return e, nil
+}
+
+// Replay calls fn with every event in order, stopping at the first error.
+func (l *Log) Replay(fn func(Event) error) error {
+	return ReplayFile(l.path, fn)
+}
+
+// ForGame returns every event of one upload, in order.
+func (l *Log) ForGame(gameID string) ([]Event, error) {
+	events := []Event{}
+	err := l.Replay(func(e Event) error {
+		if e.GameID == gameID {
+			events = append(events, e)
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	return events, err
+}
+
+// ReplayFile reads a log file directly, e.g. from a backup.
+func ReplayFile(path string, fn func(Event) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,107 @@
+// Package faults simulates failures of the upload pipeline's dependencies on
+// request, so error paths and retries can be exercised in staging before
+// deadline traffic exercises them for real. Faults are requested per request
+// in the X-Shiba-Faults header and carried in its context; the code paths
+// that talk to Airtable, R2 or the disk ask Inject whether to fail.
+package faults
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/mehanizm/airtable"
+)
+
+// Header lists the faults to inject into a request, comma separated
+const Header = "X-Shiba-Faults"
+
+// Faults that can be injected
+const (
+	// Airtable answers 429 Too Many Requests
+	AirtableRateLimit = "airtable-429"
+	// Every R2 upload of the build times out
+	R2Timeout = "r2-timeout"
+	// The disk fills up while the upload is received
+	DiskFull = "disk-full"
+	// Extraction fails halfway through the archive
+	PartialExtract = "extract-partial"
+)
+
+// simulated builds the error each fault produces, shaped like the real one
+// so callers take the same path.
+var simulated = map[string]func() error{
+	AirtableRateLimit: func() error {
+		return &airtable.HTTPClientError{StatusCode: 429, Err: errors.New("injected fault: rate limit exceeded")}
+	},
+	R2Timeout: func() error {
+		return fmt.Errorf("operation error S3: PutObject, injected fault: %w", context.DeadlineExceeded)
+	},
+	DiskFull: func() error {
+		return fmt.Errorf("injected fault: %w", syscall.ENOSPC)
+	},
+	PartialExtract: func() error {
+		return errors.New("injected fault: archive truncated")
+	},
+}
+
+// Names lists the faults that can be injected.
+func Names() []string {
+	names := make([]string, 0, len(simulated))
+	for name := range simulated {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Set is the faults requested for one request.
+type Set map[string]bool
+
+// Parse reads a comma separated list of fault names.
+func Parse(header string) (Set, error) {
+	set := Set{}
+	for _, name := range strings.Split(header, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if _, ok := simulated[name]; !ok {
+			return nil, fmt.Errorf("unknown fault %q", name)
+		}
+		set[name] = true
+	}
+	return set, nil
+}
+
+// String lists the faults of s, sorted.
+func (s Set) String() string {
+	names := make([]string, 0, len(s))
+	for name := range s {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+type contextKey struct{}
+
+// With returns a context carrying set.
+func With(ctx context.Context, set Set) context.Context {
+	if len(set) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, contextKey{}, set)
+}
+
+// Inject returns the simulated error of fault if ctx asks for it, else nil.
+func Inject(ctx context.Context, fault string) error {
+	set, _ := ctx.Value(contextKey{}).(Set)
+	if !set[fault] {
+		return nil
+	}
+	return simulated[fault]()
+}
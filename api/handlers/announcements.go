@@ -0,0 +1,249 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"shiba-api/announcements"
+	"shiba-api/schema"
+	"shiba-api/structs"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const announcementsDoc = "announcements"
+
+const (
+	// How long a long poll waits for the announcements to change
+	announcementsPollTimeout = 25 * time.Second
+	// Comments keep idle streams open through proxies
+	announcementsPingInterval = 30 * time.Second
+)
+
+// announcementsState holds the announcements posted through the admin API.
+type announcementsState struct {
+	Announcements []announcements.Announcement `json:"announcements"`
+}
+
+// LoadAnnouncements puts the stored admin announcements on the board, at
+// startup.
+func LoadAnnouncements(srv *structs.Server) error {
+	var state announcementsState
+	if err := srv.Store.Load(announcementsDoc, &state); err != nil {
+		return err
+	}
+	srv.Announcements.Set(announcements.SourceAdmin, state.Announcements)
+	return nil
+}
+
+// AnnouncementsHandler returns the announcements showing and their version.
+// With ?version set to the version the caller has, it long polls: it
+// answers once they change, or with the same version after
+// announcementsPollTimeout, and the caller asks again.
+func AnnouncementsHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var query struct {
+			Version *int `query:"version" validate:"min=0"`
+		}
+		if !bindQuery(w, r, &query) {
+			return
+		}
+
+		current, updates, unsubscribe := srv.Announcements.Subscribe()
+		defer unsubscribe()
+		if query.Version != nil && *query.Version == current.Version {
+			select {
+			case current = <-updates:
+			case <-time.After(announcementsPollTimeout):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, http.StatusOK, current)
+	}
+}
+
+// AnnouncementsStreamHandler streams the announcements showing as
+// server-sent events: an "announcements" event with the snapshot on connect
+// and whenever it changes.
+func AnnouncementsStreamHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(w)
+		current, updates, unsubscribe := srv.Announcements.Subscribe()
+		defer unsubscribe()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-store")
+		// Stops nginx from buffering the stream
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+
+		send := func(s announcements.Snapshot) error {
+			data, err := json.Marshal(s)
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: announcements\ndata: %s\n\n", s.Version, data); err != nil {
+				return err
+			}
+			return rc.Flush()
+		}
+
+		if send(current) != nil {
+			return
+		}
+		ping := time.NewTicker(announcementsPingInterval)
+		defer ping.Stop()
+		for {
+			var err error
+			select {
+			case s := <-updates:
+				err = send(s)
+			case <-ping.C:
+				if _, err = fmt.Fprint(w, ": ping\n\n"); err == nil {
+					err = rc.Flush()
+				}
+			case <-r.Context().Done():
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+type announcementRequest struct {
+	Message string `json:"message" validate:"required,max=500"`
+	Level   string `json:"level" validate:"oneof=info warning critical"`
+	// StartsAt and EndsAt bound when it shows, from now and forever by
+	// default
+	StartsAt *time.Time `json:"startsAt"`
+	EndsAt   *time.Time `json:"endsAt"`
+	// Minutes sets EndsAt that many minutes after it starts instead
+	Minutes int `json:"minutes" validate:"min=0,max=10080"`
+}
+
+// AdminAnnouncementsHandler lists the announcements posted through the admin
+// API, scheduled and ended ones included. Requires the admin token.
+func AdminAnnouncementsHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(srv, r) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var state announcementsState
+		if err := srv.Store.Load(announcementsDoc, &state); err != nil {
+			http.Error(w, "Failed to load announcements: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if state.Announcements == nil {
+			state.Announcements = []announcements.Announcement{}
+		}
+		writeJSON(w, http.StatusOK, state)
+	}
+}
+
+// CreateAnnouncementHandler posts an announcement. Listeners get it as soon
+// as it starts. Requires the admin token.
+func CreateAnnouncementHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(srv, r) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var req announcementRequest
+		if !bindJSON(w, r, &req) {
+			return
+		}
+		if req.Minutes > 0 && req.EndsAt != nil {
+			invalidField(w, "minutes", schema.InBody, "can't be set with endsAt")
+			return
+		}
+
+		now := time.Now().UTC()
+		a := announcements.Announcement{
+			ID:        "ann_" + newKioskID(),
+			Message:   req.Message,
+			Level:     req.Level,
+			StartsAt:  req.StartsAt,
+			EndsAt:    req.EndsAt,
+			Source:    announcements.SourceAdmin,
+			CreatedAt: now,
+		}
+		if a.Level == "" {
+			a.Level = announcements.LevelInfo
+		}
+		if req.Minutes > 0 {
+			start := now
+			if a.StartsAt != nil {
+				start = *a.StartsAt
+			}
+			end := start.Add(time.Duration(req.Minutes) * time.Minute)
+			a.EndsAt = &end
+		}
+		if a.StartsAt != nil && a.EndsAt != nil && !a.EndsAt.After(*a.StartsAt) {
+			invalidField(w, "endsAt", schema.InBody, "must be after startsAt")
+			return
+		}
+
+		var state announcementsState
+		err := srv.Store.Update(announcementsDoc, &state, func() error {
+			// Ended announcements are only kept a week, for the list
+			kept := state.Announcements[:0]
+			for _, old := range state.Announcements {
+				if old.EndsAt == nil || now.Sub(*old.EndsAt) < 7*24*time.Hour {
+					kept = append(kept, old)
+				}
+			}
+			state.Announcements = append(kept, a)
+			return nil
+		})
+		if err != nil {
+			http.Error(w, "Failed to save announcement: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		srv.Announcements.Set(announcements.SourceAdmin, state.Announcements)
+		writeJSON(w, http.StatusCreated, a)
+	}
+}
+
+// DeleteAnnouncementHandler takes an announcement down. Requires the admin
+// token.
+func DeleteAnnouncementHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(srv, r) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		id := chi.URLParam(r, "announcementId")
+
+		found := false
+		var state announcementsState
+		err := srv.Store.Update(announcementsDoc, &state, func() error {
+			kept := state.Announcements[:0]
+			for _, a := range state.Announcements {
+				if a.ID == id {
+					found = true
+					continue
+				}
+				kept = append(kept, a)
+			}
+			state.Announcements = kept
+			return nil
+		})
+		if err != nil {
+			http.Error(w, "Failed to delete announcement: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			http.Error(w, "Announcement not found", http.StatusNotFound)
+			return
+		}
+		srv.Announcements.Set(announcements.SourceAdmin, state.Announcements)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"path"
+	"sort"
+	"time"
+
+	"shiba-api/gamemeta"
+	"shiba-api/structs"
+	"shiba-api/sync"
+)
+
+// archiveEntry describes one project in a creator archive's index.json.
+type archiveEntry struct {
+	ProjectID string `json:"projectId"`
+	Build     Build  `json:"build"`
+	// Metadata holds the project's accessibility and input flags
+	Metadata gamemeta.Metadata `json:"metadata"`
+	// Files is empty when the build has no manifest (not published yet)
+	Files []ManifestFile `json:"files"`
+	// Missing lists files that could not be read back from R2
+	Missing []string `json:"missing,omitempty"`
+}
+
+// latestOwnedBuilds returns the current build of every project userID
+// uploaded to, ordered by project ID. Projects with only drafts are left
+// out.
+func latestOwnedBuilds(state buildsState, userID string) []Build {
+	latest := map[string]Build{}
+	for _, b := range state.Builds {
+		if b.OwnerID != userID {
+			continue
+		}
+		if cur, ok := latest[b.ProjectID]; !ok || b.CreatedAt.After(cur.CreatedAt) {
+			latest[b.ProjectID] = b
+		}
+	}
+
+	builds := make([]Build, 0, len(latest))
+	for _, b := range latest {
+		// A newer version may have been uploaded by a collaborator, or the
+		// project rolled back. Projects with only drafts aren't served.
+		current, ok := state.currentBuild(b.ProjectID)
+		if !ok {
+			continue
+		}
+		builds = append(builds, current)
+	}
+	sort.Slice(builds, func(i, j int) bool { return builds[i].ProjectID < builds[j].ProjectID })
+	return builds
+}
+
+// MyGamesArchiveHandler streams a zip of the latest build of every project
+// the calling user uploaded to, for archiving at the end of a jam. The zip is
+// assembled on the fly from the build manifests and R2, without temp files.
+// Each project gets a directory named after its ID; index.json at the end
+// lists the builds and their files. Requires a user token.
+func MyGamesArchiveHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := requireUser(srv, w, r)
+		if !ok {
+			return
+		}
+
+		state, err := loadBuilds(srv)
+		if err != nil {
+			http.Error(w, "Failed to load builds: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		builds := latestOwnedBuilds(state, user.ID)
+		if len(builds) == 0 {
+			http.Error(w, "You haven't uploaded any games", http.StatusNotFound)
+			return
+		}
+
+		// Load every manifest before the first byte goes out, so store errors
+		// can still be reported with a proper status
+		entries := make([]archiveEntry, len(builds))
+		for i, b := range builds {
+			manifest, err := loadBuildManifest(srv, b.ID)
+			if err != nil {
+				http.Error(w, "Failed to load manifest: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			meta, err := gamemeta.Get(srv.Store, b.ProjectID)
+			if err != nil {
+				http.Error(w, "Failed to load game metadata: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			entries[i] = archiveEntry{ProjectID: b.ProjectID, Build: b, Metadata: meta, Files: []ManifestFile{}}
+			if manifest != nil {
+				entries[i].Files = manifest.Files
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", `attachment; filename="shiba-games-`+time.Now().UTC().Format("2006-01-02")+`.zip"`)
+		w.Header().Set("Cache-Control", "no-store")
+
+		zw := zip.NewWriter(w)
+		for i := range entries {
+			entry := &entries[i]
+			for _, f := range entry.Files {
+				if err := r.Context().Err(); err != nil {
+					return // client went away
+				}
+				name := path.Join(entry.ProjectID, f.Path)
+				if err := archiveFile(srv, r, zw, entry.Build, f, name); err != nil {
+					slog.ErrorContext(r.Context(), "Failed to archive file", "user_id", user.ID, "path", name, "error", err)
+					entry.Missing = append(entry.Missing, f.Path)
+				}
+			}
+		}
+
+		index, err := zw.Create("index.json")
+		if err == nil {
+			enc := json.NewEncoder(index)
+			enc.SetIndent("", "  ")
+			err = enc.Encode(struct {
+				UserID    string         `json:"userId"`
+				CreatedAt time.Time      `json:"createdAt"`
+				Projects  []archiveEntry `json:"projects"`
+			}{user.ID, time.Now().UTC(), entries})
+		}
+		if err == nil {
+			err = zw.Close()
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to write archive", "user_id", user.ID, "error", err)
+		}
+	}
+}
+
+// archiveFile copies one build file from R2 into the zip, checking it against
+// the manifest hash. A file that fails midway leaves a truncated entry, which
+// the index reports as missing.
+func archiveFile(srv *structs.Server, r *http.Request, zw *zip.Writer, build Build, f ManifestFile, name string) error {
+	body, err := sync.OpenGameFile(r.Context(), *srv, build.ID, f.Path, f.SHA256)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	dst, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: build.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(dst, h), body)
+	if err != nil {
+		return fmt.Errorf("failed to copy %s: %v", name, err)
+	}
+	if n != f.Size || hex.EncodeToString(h.Sum(nil)) != f.SHA256 {
+		return fmt.Errorf("%s doesn't match its manifest", name)
+	}
+	return nil
+}
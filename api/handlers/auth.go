@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"shiba-api/datastore"
+	"shiba-api/structs"
+	"shiba-api/users"
+
+	"github.com/mehanizm/airtable"
+)
+
+var errUnauthorized = errors.New("invalid or missing token")
+
+func bearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+}
+
+func isAdmin(srv *structs.Server, r *http.Request) bool {
+	token := bearerToken(r)
+	return srv.AdminToken != "" && token == srv.AdminToken
+}
+
+// authenticateUser looks up the user owning the request's bearer token.
+// Recent results, including unknown tokens, are served from the token cache.
+func authenticateUser(srv *structs.Server, r *http.Request) (*airtable.Record, error) {
+	token := bearerToken(r)
+	if token == "" {
+		return nil, errUnauthorized
+	}
+
+	if strings.HasPrefix(token, creatorTokenPrefix) {
+		return authenticateCreatorToken(srv, r, token)
+	}
+
+	hash := users.HashToken(token)
+	started := time.Now()
+	if userID, hit := srv.Tokens.Get(hash, started); hit {
+		if userID == "" {
+			return nil, errUnauthorized
+		}
+		if user, err := srv.UserStore.UserByID(r.Context(), userID); err == nil {
+			return user, nil
+		}
+	}
+
+	user, err := lookupUser(srv, r, token)
+	switch {
+	case err == nil:
+		srv.Tokens.Remember(hash, user.ID, started)
+	case errors.Is(err, errUnauthorized):
+		srv.Tokens.Remember(hash, "", started)
+	}
+	return user, err
+}
+
+// lookupUser finds the user owning token in the user store.
+func lookupUser(srv *structs.Server, r *http.Request, token string) (*airtable.Record, error) {
+	if strings.HasPrefix(token, sessionTokenPrefix) {
+		return authenticateSession(srv, r, token)
+	}
+	user, err := srv.UserStore.UserByToken(r.Context(), token)
+	if errors.Is(err, datastore.ErrNotFound) {
+		return nil, errUnauthorized
+	}
+	return user, err
+}
+
+func hasRole(user *airtable.Record, role string) bool {
+	switch v := user.Fields["Role"].(type) {
+	case string:
+		return strings.EqualFold(v, role)
+	case []any:
+		for _, r := range v {
+			if s, ok := r.(string); ok && strings.EqualFold(s, role) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// requireUser authenticates the request with a user token. On failure the error
+// response has already been written.
+func requireUser(srv *structs.Server, w http.ResponseWriter, r *http.Request) (*airtable.Record, bool) {
+	user, err := authenticateUser(srv, r)
+	if errors.Is(err, errUnauthorized) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+	if errors.Is(err, errInsufficientScope) {
+		writeJSON(w, http.StatusForbidden, struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		}{"insufficient_scope", "This token can't be used here; mint one with the needed scope or use your account token"})
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, "Failed to authenticate: "+err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	return user, true
+}
+
+// requireRole authenticates the request and checks the user has role. The admin
+// token always passes and yields a nil user. On failure the error response has
+// already been written.
+func requireRole(srv *structs.Server, w http.ResponseWriter, r *http.Request, role string) (*airtable.Record, bool) {
+	if isAdmin(srv, r) {
+		return nil, true
+	}
+
+	user, ok := requireUser(srv, w, r)
+	if !ok {
+		return nil, false
+	}
+	if !hasRole(user, role) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return nil, false
+	}
+	return user, true
+}
@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"shiba-api/datastore"
+	"shiba-api/structs"
+	"shiba-api/users"
+)
+
+// fakeUsers is a user store holding users by account token.
+type fakeUsers struct {
+	byToken map[string]*datastore.Record
+	lookups int
+}
+
+func (f *fakeUsers) UserByToken(ctx context.Context, token string) (*datastore.Record, error) {
+	f.lookups++
+	if user, ok := f.byToken[token]; ok {
+		return user, nil
+	}
+	return nil, datastore.ErrNotFound
+}
+
+func (f *fakeUsers) UserByID(ctx context.Context, id string) (*datastore.Record, error) {
+	for _, user := range f.byToken {
+		if user.ID == id {
+			return user, nil
+		}
+	}
+	return nil, datastore.ErrNotFound
+}
+
+func (f *fakeUsers) SetUserToken(ctx context.Context, id, token string) error { return nil }
+
+func (f *fakeUsers) FlagUser(ctx context.Context, id, reason string) error { return nil }
+
+func testServer(userTokens map[string]string) (*structs.Server, *fakeUsers) {
+	fake := &fakeUsers{byToken: map[string]*datastore.Record{}}
+	for token, id := range userTokens {
+		fake.byToken[token] = &datastore.Record{ID: id, Fields: map[string]any{}}
+	}
+	return &structs.Server{UserStore: fake, Tokens: users.NewTokenCache()}, fake
+}
+
+func TestBearerToken(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"", ""},
+		{"Bearer abc123", "abc123"},
+		{"Bearer  abc123 ", "abc123"},
+		{"abc123", "abc123"},
+		{"Bearer ", ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		if tt.header != "" {
+			r.Header.Set("Authorization", tt.header)
+		}
+		if got := bearerToken(r); got != tt.want {
+			t.Errorf("bearerToken(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestIsAdmin(t *testing.T) {
+	tests := []struct {
+		name       string
+		adminToken string
+		header     string
+		want       bool
+	}{
+		{"admin token", "s3cret", "Bearer s3cret", true},
+		{"wrong token", "s3cret", "Bearer guess", false},
+		{"no token", "s3cret", "", false},
+		{"admin token unset", "", "Bearer ", false},
+		{"admin token unset, no header", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := &structs.Server{AdminToken: tt.adminToken}
+			r := httptest.NewRequest("GET", "/", nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			if got := isAdmin(srv, r); got != tt.want {
+				t.Errorf("isAdmin() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthenticateUser(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		wantID  string
+		wantErr error
+	}{
+		{"known token", "Bearer tok-ada", "recAda", nil},
+		{"unknown token", "Bearer tok-nobody", "", errUnauthorized},
+		{"no token", "", "", errUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, fake := testServer(map[string]string{"tok-ada": "recAda"})
+			for attempt := 1; attempt <= 2; attempt++ {
+				r := httptest.NewRequest("GET", "/", nil)
+				if tt.header != "" {
+					r.Header.Set("Authorization", tt.header)
+				}
+				user, err := authenticateUser(srv, r)
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("attempt %d: err = %v, want %v", attempt, err, tt.wantErr)
+				}
+				if err == nil && user.ID != tt.wantID {
+					t.Errorf("attempt %d: user = %s, want %s", attempt, user.ID, tt.wantID)
+				}
+			}
+			// The second attempt is answered from the token cache
+			if fake.lookups > 1 {
+				t.Errorf("looked the token up %d times, want at most once", fake.lookups)
+			}
+		})
+	}
+}
+
+func TestHasRole(t *testing.T) {
+	tests := []struct {
+		name string
+		role any
+		want bool
+	}{
+		{"single value", "reviewer", true},
+		{"other case", "Reviewer", true},
+		{"other role", "judge", false},
+		{"multiple select", []any{"judge", "reviewer"}, true},
+		{"multiple select without it", []any{"judge"}, false},
+		{"unset", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := &datastore.Record{Fields: map[string]any{}}
+			if tt.role != nil {
+				user.Fields["Role"] = tt.role
+			}
+			if got := hasRole(user, "reviewer"); got != tt.want {
+				t.Errorf("hasRole() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+
+	"shiba-api/schema"
+)
+
+// Request validation: handlers declare the parameters they accept as structs
+// with `query` or `json` and `validate` tags and bind them before doing any
+// work. Every endpoint then rejects bad input with the same 422 body.
+
+// writeInvalid answers 422 with the fields that failed validation.
+func writeInvalid(w http.ResponseWriter, errs schema.Errors) {
+	writeJSON(w, http.StatusUnprocessableEntity, struct {
+		Code    string        `json:"code"`
+		Message string        `json:"message"`
+		Fields  schema.Errors `json:"fields"`
+	}{"invalid_request", "Request failed validation: " + errs.Error(), errs})
+}
+
+// invalidField answers 422 for a single field, for checks that depend on
+// more than one parameter or on config.
+func invalidField(w http.ResponseWriter, field, in, format string, args ...any) {
+	var errs schema.Errors
+	errs.Add(field, in, format, args...)
+	writeInvalid(w, errs)
+}
+
+// bindQuery decodes and checks the query string into q, a pointer to a
+// struct holding the defaults. It answers 422 and returns false when the
+// query is invalid.
+func bindQuery(w http.ResponseWriter, r *http.Request, q any) bool {
+	if errs := schema.Query(r.URL.Query(), q); len(errs) > 0 {
+		writeInvalid(w, errs)
+		return false
+	}
+	return true
+}
+
+// bindJSON decodes and checks a JSON body into v. Bodies that aren't JSON
+// get 400, wrongly typed or invalid fields 422.
+func bindJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	err := readJSON(r, v)
+	var typeErr *json.UnmarshalTypeError
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &typeErr):
+		field := typeErr.Field
+		if field == "" {
+			field = "body"
+		}
+		invalidField(w, field, schema.InBody, "must be %s", jsonKind(typeErr.Type.Kind().String()))
+		return false
+	case errors.As(err, &tooLarge):
+		http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+		return false
+	case err != nil:
+		writeJSON(w, http.StatusBadRequest, struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		}{"invalid_body", "Invalid request body: " + err.Error()})
+		return false
+	}
+
+	if errs := schema.Check(v); len(errs) > 0 {
+		writeInvalid(w, errs)
+		return false
+	}
+	return true
+}
+
+// jsonKind names the JSON type expected for a Go kind.
+func jsonKind(kind string) string {
+	switch kind {
+	case "string":
+		return "a string"
+	case "bool":
+		return "true or false"
+	case "slice", "array":
+		return "an array"
+	case "map", "struct":
+		return "an object"
+	case "int", "int8", "int16", "int32", "int64", "uint", "uint8", "uint16", "uint32", "uint64":
+		return "an integer"
+	case "float32", "float64":
+		return "a number"
+	}
+	return "a " + kind
+}
+
+// ValidQuery rejects requests whose query string can't be parsed, which
+// r.URL.Query() would otherwise silently drop parameters from.
+func ValidQuery(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := url.ParseQuery(r.URL.RawQuery); err != nil {
+			invalidField(w, "query", schema.InQuery, "is malformed: %v", err)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"shiba-api/buildindex"
+	"shiba-api/structs"
+	"shiba-api/sync"
+)
+
+const buildsDoc = "builds"
+
+// Build is one uploaded snapshot of a game. Every upload gets a fresh ID (the
+// gameId used in play URLs); builds sharing a ProjectID are versions of the
+// same game.
+type Build struct {
+	ID        string `json:"id"`
+	ProjectID string `json:"projectId"`
+	OwnerID   string `json:"ownerId,omitempty"`
+	Changelog string `json:"changelog,omitempty"`
+	// Engine hints sent by the uploader, e.g. "godot" / "4.3"
+	Engine        string `json:"engine,omitempty"`
+	EngineVersion string `json:"engineVersion,omitempty"`
+	// ListingType is "downloadable" for native builds listed with a download
+	// button instead of a play URL, empty for web games
+	ListingType    string    `json:"listingType,omitempty"`
+	ArtifactSHA256 string    `json:"artifactSha256,omitempty"`
+	CreatedAt      time.Time `json:"createdAt"`
+	// Hooks applied after extraction, as "name@version"
+	Hooks []string `json:"hooks,omitempty"`
+	// Event the build was uploaded during (EVENT_ID)
+	Event string `json:"event,omitempty"`
+	// Draft builds are never served as their project's current build and
+	// only play with a preview token
+	Draft bool `json:"draft,omitempty"`
+	// Version is the build's number within its project, kept when other
+	// versions are deleted so links pinning it keep working. Builds from
+	// before versions were recorded have none and are numbered by position.
+	Version int `json:"version,omitempty"`
+}
+
+type buildsState struct {
+	Builds map[string]Build `json:"builds"`
+	// Current pins the build served for a project after a rollback. Projects
+	// without a pin serve their newest build.
+	Current map[string]string `json:"current,omitempty"`
+	// Versions is the last version number given out per project
+	Versions map[string]int `json:"versions,omitempty"`
+}
+
+func (s *buildsState) init() {
+	if s.Builds == nil {
+		s.Builds = map[string]Build{}
+	}
+}
+
+// projectBuilds returns the builds of a project, newest first.
+func (s *buildsState) projectBuilds(projectID string) []Build {
+	builds := []Build{}
+	for _, b := range s.Builds {
+		if b.ProjectID == projectID {
+			builds = append(builds, b)
+		}
+	}
+	sort.Slice(builds, func(i, j int) bool {
+		return builds[i].CreatedAt.After(builds[j].CreatedAt)
+	})
+	return builds
+}
+
+// currentBuild returns the build a project serves: the rollback pin if set,
+// else the newest build that isn't a draft.
+func (s *buildsState) currentBuild(projectID string) (Build, bool) {
+	if id, ok := s.Current[projectID]; ok {
+		if b, ok := s.Builds[id]; ok {
+			return b, true
+		}
+	}
+	for _, b := range s.projectBuilds(projectID) {
+		if !b.Draft {
+			return b, true
+		}
+	}
+	return Build{}, false
+}
+
+// versionNumbers maps the IDs of a project's builds to their version.
+// Builds without a recorded version are numbered in upload order, and always
+// come before those with one.
+func (s *buildsState) versionNumbers(projectID string) map[string]int {
+	builds := s.projectBuilds(projectID)
+	numbers := make(map[string]int, len(builds))
+	unnumbered := 0
+	for i := len(builds) - 1; i >= 0; i-- {
+		b := builds[i]
+		if b.Version > 0 {
+			numbers[b.ID] = b.Version
+		} else {
+			unnumbered++
+			numbers[b.ID] = unnumbered
+		}
+	}
+	return numbers
+}
+
+// buildVersion returns version v of a project, if it wasn't deleted.
+func (s *buildsState) buildVersion(projectID string, v int) (Build, bool) {
+	for id, n := range s.versionNumbers(projectID) {
+		if n == v {
+			return s.Builds[id], true
+		}
+	}
+	return Build{}, false
+}
+
+// nextVersion hands out the version number of a new build of a project.
+func (s *buildsState) nextVersion(projectID string) int {
+	if s.Versions == nil {
+		s.Versions = map[string]int{}
+	}
+	n := s.Versions[projectID]
+	for _, v := range s.versionNumbers(projectID) {
+		n = max(n, v)
+	}
+	s.Versions[projectID] = n + 1
+	return n + 1
+}
+
+// objectState is the state label of a build's objects in R2.
+func (b Build) objectState() string {
+	if b.Draft {
+		return sync.StateDraft
+	}
+	return sync.StatePublished
+}
+
+// objectLabels attributes a build's objects in R2 to it, its owner and its
+// event.
+func objectLabels(srv *structs.Server, gameID string) sync.ObjectLabels {
+	labels := sync.ObjectLabels{GameID: gameID, EventID: srv.Config.Get().EventID, State: sync.StatePublished}
+	if state, err := loadBuilds(srv); err == nil {
+		if b, ok := state.Builds[gameID]; ok {
+			labels.UserID, labels.State = b.OwnerID, b.objectState()
+			if b.Event != "" {
+				labels.EventID = b.Event
+			}
+		}
+	}
+	return labels
+}
+
+// recordBuild saves a new build, numbering it, which becomes its project's
+// current one unless it is a draft.
+func recordBuild(srv *structs.Server, build *Build) error {
+	var state buildsState
+	return updateBuilds(srv, &state, func() error {
+		state.init()
+		build.Version = state.nextVersion(build.ProjectID)
+		state.Builds[build.ID] = *build
+		if !build.Draft {
+			delete(state.Current, build.ProjectID)
+		}
+		return nil
+	})
+}
+
+// updateBuilds updates the builds document like Store.Update, then drops the
+// build index so lookups see the change.
+func updateBuilds(srv *structs.Server, state *buildsState, fn func() error) error {
+	err := srv.Store.Update(buildsDoc, state, fn)
+	srv.BuildIndex.Invalidate()
+	return err
+}
+
+// lookupBuild reports whether gameID has a build record, its project and
+// whether it's a draft, from the build index rather than the builds document.
+func lookupBuild(srv *structs.Server, gameID string) (buildindex.Entry, bool, error) {
+	return srv.BuildIndex.Lookup(gameID, func() (map[string]buildindex.Entry, error) {
+		state, err := loadBuilds(srv)
+		if err != nil {
+			return nil, err
+		}
+		entries := make(map[string]buildindex.Entry, len(state.Builds))
+		for id, b := range state.Builds {
+			entries[id] = buildindex.Entry{ProjectID: b.ProjectID, Draft: b.Draft}
+		}
+		return entries, nil
+	})
+}
+
+func loadBuilds(srv *structs.Server) (buildsState, error) {
+	var state buildsState
+	err := srv.Store.Load(buildsDoc, &state)
+	state.init()
+	return state, err
+}
+
+// publishedBuild returns a build that isn't a draft. Builds from before build
+// records only exist on disk and get a record without a project.
+func publishedBuild(s *buildsState, gameID string) (Build, bool) {
+	if build, ok := s.Builds[gameID]; ok {
+		return build, !build.Draft
+	}
+	if gameID == "" || strings.ContainsAny(gameID, "./\\") {
+		return Build{}, false
+	}
+	if _, err := os.Stat(filepath.Join("./games", gameID)); err != nil {
+		return Build{}, false
+	}
+	return Build{ID: gameID}, true
+}
@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"shiba-api/events"
+	"shiba-api/schema"
+	"shiba-api/structs"
+)
+
+// Builds one bulk edit changes by default, up to 500; larger edits run in
+// several batches
+const defaultBulkEditLimit = 100
+
+// buildFilter selects builds by their metadata. Pointer fields match exactly,
+// "" matching builds without a value; nil fields match anything.
+type buildFilter struct {
+	GameIDs       []string   `json:"gameIds" validate:"max=1000"`
+	ProjectID     string     `json:"projectId"`
+	OwnerID       string     `json:"ownerId"`
+	Event         *string    `json:"event"`
+	Engine        *string    `json:"engine"`
+	EngineVersion *string    `json:"engineVersion"`
+	CreatedAfter  *time.Time `json:"createdAfter"`
+	CreatedBefore *time.Time `json:"createdBefore"`
+}
+
+func (f buildFilter) empty() bool {
+	return len(f.GameIDs) == 0 && f.ProjectID == "" && f.OwnerID == "" && f.Event == nil &&
+		f.Engine == nil && f.EngineVersion == nil && f.CreatedAfter == nil && f.CreatedBefore == nil
+}
+
+func (f buildFilter) matches(b Build) bool {
+	if len(f.GameIDs) > 0 {
+		found := false
+		for _, id := range f.GameIDs {
+			if id == b.ID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return (f.ProjectID == "" || b.ProjectID == f.ProjectID) &&
+		(f.OwnerID == "" || b.OwnerID == f.OwnerID) &&
+		(f.Event == nil || b.Event == *f.Event) &&
+		(f.Engine == nil || b.Engine == *f.Engine) &&
+		(f.EngineVersion == nil || b.EngineVersion == *f.EngineVersion) &&
+		(f.CreatedAfter == nil || b.CreatedAt.After(*f.CreatedAfter)) &&
+		(f.CreatedBefore == nil || b.CreatedAt.Before(*f.CreatedBefore))
+}
+
+// buildMetadata is the metadata a bulk edit can set; nil fields are left as
+// they are.
+type buildMetadata struct {
+	Event         *string `json:"event" validate:"max=100"`
+	Engine        *string `json:"engine" validate:"max=50"`
+	EngineVersion *string `json:"engineVersion" validate:"max=50"`
+}
+
+// MetadataChange is one field of one build changed by a bulk edit.
+type MetadataChange struct {
+	GameID string `json:"gameId"`
+	Field  string `json:"field"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+}
+
+// apply sets m on b and returns what changed.
+func (m buildMetadata) apply(b *Build) []MetadataChange {
+	var changes []MetadataChange
+	set := func(field string, to *string, value *string) {
+		if to != nil && *to != *value {
+			changes = append(changes, MetadataChange{b.ID, field, *value, *to})
+			*value = *to
+		}
+	}
+	set("event", m.Event, &b.Event)
+	set("engine", m.Engine, &b.Engine)
+	set("engineVersion", m.EngineVersion, &b.EngineVersion)
+	return changes
+}
+
+// BulkEditReport is the outcome of a bulk edit, or what it would do in a dry
+// run.
+type BulkEditReport struct {
+	DryRun bool `json:"dryRun"`
+	// Builds matching the filter that the edit changes, including those left
+	// for later batches
+	Matched int `json:"matched"`
+	// Builds changed in this batch
+	Edited    int              `json:"edited"`
+	Remaining int              `json:"remaining"`
+	Changes   []MetadataChange `json:"changes"`
+}
+
+// BulkEditBuildsHandler sets metadata on every build matching a filter, e.g.
+// tagging a batch of builds with their event or renaming an engine. Builds
+// are edited in ID order, at most limit per request; builds the edit
+// wouldn't change don't count, so repeating the request until remaining is 0
+// finishes a large edit. Each change is logged as an edited event of its
+// build. Pass dryRun=true to preview. Requires the admin token.
+func BulkEditBuildsHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(srv, r) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req struct {
+			Filter buildFilter   `json:"filter"`
+			Set    buildMetadata `json:"set"`
+			DryRun bool          `json:"dryRun"`
+			Limit  int           `json:"limit" validate:"min=1,max=500"`
+		}
+		req.Limit = defaultBulkEditLimit
+		if !bindJSON(w, r, &req) {
+			return
+		}
+		if req.Filter.empty() {
+			invalidField(w, "filter", schema.InBody, "must have at least one condition")
+			return
+		}
+		if req.Set == (buildMetadata{}) {
+			invalidField(w, "set", schema.InBody, "must have at least one field")
+			return
+		}
+
+		report := BulkEditReport{DryRun: req.DryRun, Changes: []MetadataChange{}}
+		var state buildsState
+		edit := func() error {
+			state.init()
+			ids := make([]string, 0, len(state.Builds))
+			for id := range state.Builds {
+				ids = append(ids, id)
+			}
+			sort.Strings(ids)
+
+			report.Matched, report.Edited, report.Changes = 0, 0, []MetadataChange{}
+			for _, id := range ids {
+				b := state.Builds[id]
+				if !req.Filter.matches(b) {
+					continue
+				}
+				changes := req.Set.apply(&b)
+				if len(changes) == 0 {
+					continue
+				}
+				report.Matched++
+				if report.Edited == req.Limit {
+					continue
+				}
+				report.Edited++
+				report.Changes = append(report.Changes, changes...)
+				state.Builds[id] = b
+			}
+			report.Remaining = report.Matched - report.Edited
+			return nil
+		}
+
+		var err error
+		if req.DryRun {
+			if err = srv.Store.Load(buildsDoc, &state); err == nil {
+				err = edit()
+			}
+		} else {
+			err = updateBuilds(srv, &state, edit)
+		}
+		if err != nil {
+			http.Error(w, "Failed to edit builds: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if !req.DryRun {
+			for _, c := range report.Changes {
+				emitEvent(r.Context(), srv, c.GameID, events.Edited, "admin", fmt.Sprintf("%s: %q -> %q", c.Field, c.From, c.To))
+			}
+		}
+		writeJSON(w, http.StatusOK, report)
+	}
+}
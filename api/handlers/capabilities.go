@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"shiba-api/capability"
+	"shiba-api/structs"
+	"shiba-api/syncqueue"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const capabilitiesUsedDoc = "capabilities-used"
+
+// Capability actions
+const (
+	CapabilityRetrySync    = "retry-sync"
+	CapabilityApproveBuild = "approve-build"
+)
+
+// capabilityAction is an admin action a capability URL can allow.
+type capabilityAction struct {
+	// Describe says what the action does to target, for the confirmation page
+	Describe func(target string) string
+	// Run performs it and says what happened
+	Run func(ctx context.Context, srv *structs.Server, target string) (string, error)
+}
+
+var capabilityActions = map[string]capabilityAction{
+	CapabilityRetrySync: {
+		Describe: func(target string) string { return "Retry the R2 sync of build " + target },
+		Run: func(ctx context.Context, srv *structs.Server, target string) (string, error) {
+			job, err := srv.SyncQueue.Retry(target)
+			if errors.Is(err, syncqueue.ErrNotFound) {
+				return "", errors.New("no sync job for this build")
+			}
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("The sync of build %s is %s.", target, job.Status), nil
+		},
+	},
+	CapabilityApproveBuild: {
+		Describe: func(target string) string {
+			return "Approve quarantined build " + target + " as a false positive and publish it"
+		},
+		Run: func(ctx context.Context, srv *structs.Server, target string) (string, error) {
+			build, err := releaseBuild(ctx, srv, target)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("Build %s of project %s is published.", build.ID, build.ProjectID), nil
+		},
+	},
+}
+
+// usedCapabilitiesState remembers the capabilities already used, by ID, until
+// they expire and couldn't be used anyway.
+type usedCapabilitiesState struct {
+	Used map[string]time.Time `json:"used"`
+}
+
+var errCapabilityUsed = errors.New("capability already used")
+
+// consumeCapability marks a capability used, failing if it already was.
+func consumeCapability(srv *structs.Server, c capability.Claims, now time.Time) error {
+	var state usedCapabilitiesState
+	return srv.Store.Update(capabilitiesUsedDoc, &state, func() error {
+		if state.Used == nil {
+			state.Used = map[string]time.Time{}
+		}
+		for id, expires := range state.Used {
+			if !now.Before(expires) {
+				delete(state.Used, id)
+			}
+		}
+		if _, ok := state.Used[c.ID]; ok {
+			return errCapabilityUsed
+		}
+		state.Used[c.ID] = c.Expires
+		return nil
+	})
+}
+
+// unconsumeCapability lets a capability be used again after its action
+// failed.
+func unconsumeCapability(srv *structs.Server, c capability.Claims) error {
+	var state usedCapabilitiesState
+	return srv.Store.Update(capabilitiesUsedDoc, &state, func() error {
+		delete(state.Used, c.ID)
+		return nil
+	})
+}
+
+type capabilityRequest struct {
+	Action string `json:"action" validate:"required,oneof=retry-sync approve-build"`
+	Target string `json:"target" validate:"required,max=128"`
+	// Minutes the URL stays valid, an hour by default
+	Minutes int `json:"minutes" validate:"min=0,max=1440"`
+}
+
+// CreateCapabilityHandler mints a single-use URL allowing one admin action on
+// one target, to post in Slack alerts. Requires the admin token.
+func CreateCapabilityHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(srv, r) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var req capabilityRequest
+		if !bindJSON(w, r, &req) {
+			return
+		}
+		if req.Minutes == 0 {
+			req.Minutes = 60
+		}
+
+		token, claims, err := srv.Capabilities.Mint(req.Action, req.Target, time.Now().Add(time.Duration(req.Minutes)*time.Minute))
+		if err != nil {
+			http.Error(w, "Failed to mint capability: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusCreated, struct {
+			URL         string    `json:"url"`
+			Action      string    `json:"action"`
+			Target      string    `json:"target"`
+			Description string    `json:"description"`
+			ExpiresAt   time.Time `json:"expiresAt"`
+		}{
+			URL:         publicBaseURL(r) + apiPath(r, "/capabilities/"+token),
+			Action:      claims.Action,
+			Target:      claims.Target,
+			Description: capabilityActions[claims.Action].Describe(claims.Target),
+			ExpiresAt:   claims.Expires,
+		})
+	}
+}
+
+// verifyCapability checks the capability of a request, answering with a page
+// saying why it can't be used if it can't.
+func verifyCapability(srv *structs.Server, w http.ResponseWriter, r *http.Request, now time.Time) (capability.Claims, capabilityAction, bool) {
+	c, err := srv.Capabilities.Verify(chi.URLParam(r, "token"), now)
+	action, known := capabilityActions[c.Action]
+	switch {
+	case errors.Is(err, capability.ErrExpired):
+		renderCapabilityPage(w, http.StatusGone, capabilityPage{Title: "Link expired", Message: "This link expired. Ask for a new one."})
+	case err != nil || !known:
+		renderCapabilityPage(w, http.StatusForbidden, capabilityPage{Title: "Invalid link", Message: "This link isn't valid."})
+	default:
+		return c, action, true
+	}
+	return c, action, false
+}
+
+// CapabilityHandler shows what a capability URL allows, with a button to do
+// it. Opening the URL does nothing by itself, so link previews in Slack can't
+// use it up.
+func CapabilityHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, action, ok := verifyCapability(srv, w, r, time.Now())
+		if !ok {
+			return
+		}
+		var state usedCapabilitiesState
+		if err := srv.Store.Load(capabilitiesUsedDoc, &state); err != nil {
+			http.Error(w, "Failed to load capabilities: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if _, used := state.Used[c.ID]; used {
+			renderCapabilityPage(w, http.StatusGone, capabilityPage{Title: "Link used", Message: "This link was used already."})
+			return
+		}
+		renderCapabilityPage(w, http.StatusOK, capabilityPage{
+			Title:   action.Describe(c.Target) + "?",
+			Message: "This link works once and expires " + c.Expires.Format("Jan 2 15:04 UTC") + ".",
+			Confirm: true,
+		})
+	}
+}
+
+// UseCapabilityHandler performs the action of a capability URL and uses it
+// up. If the action fails the URL can be used again.
+func UseCapabilityHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		c, action, ok := verifyCapability(srv, w, r, now)
+		if !ok {
+			return
+		}
+		err := consumeCapability(srv, c, now)
+		if errors.Is(err, errCapabilityUsed) {
+			renderCapabilityPage(w, http.StatusGone, capabilityPage{Title: "Link used", Message: "This link was used already."})
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to use capability: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		result, err := action.Run(r.Context(), srv, c.Target)
+		if err != nil {
+			if err := unconsumeCapability(srv, c); err != nil {
+				slog.ErrorContext(r.Context(), "Failed to release capability", "capability_id", c.ID, "error", err)
+			}
+			renderCapabilityPage(w, http.StatusConflict, capabilityPage{Title: "Failed", Message: err.Error()})
+			return
+		}
+		slog.InfoContext(r.Context(), "Capability used", "capability_id", c.ID, "action", c.Action, "target", c.Target)
+		renderCapabilityPage(w, http.StatusOK, capabilityPage{Title: "Done", Message: result})
+	}
+}
+
+type capabilityPage struct {
+	Title   string
+	Message string
+	// Confirm shows the button performing the action
+	Confirm bool
+}
+
+func renderCapabilityPage(w http.ResponseWriter, status int, page capabilityPage) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	// The token is the credential; keep it out of Referer headers
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.WriteHeader(status)
+	capabilityPageTemplate.Execute(w, page)
+}
+
+var capabilityPageTemplate = template.Must(template.New("capability").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; max-width: 32rem; margin: 4rem auto; padding: 0 1rem; line-height: 1.4; }
+button { font-size: 1rem; padding: 0.5rem 1rem; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>{{.Message}}</p>
+{{if .Confirm}}<form method="post"><button type="submit">Confirm</button></form>{{end}}
+</body>
+</html>
+`))
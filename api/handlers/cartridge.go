@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"html/template"
+	"image/png"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	CartPico8 = "pico-8"
+	CartTic80 = "tic-80"
+)
+
+// PICO-8 .p8.png carts are always a 160x205 label image
+const (
+	pico8CartWidth  = 160
+	pico8CartHeight = 205
+)
+
+// Highest TIC-80 chunk type we know about, see
+// https://github.com/nesbox/TIC-80/wiki/.tic-File-Format
+const tic80MaxChunkType = 20
+
+const maxCartridgeSize = 1 << 20
+
+// The runtimes are self-hosted copies of the official players; the shells only
+// point at them.
+const (
+	defaultPico8PlayerURL = "/players/pico8/pico8.js"
+	defaultTic80PlayerURL = "/players/tic80/tic80.js"
+)
+
+var pico8Shell = template.Must(template.New("pico8").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>PICO-8 cartridge</title>
+<style>
+html, body { margin: 0; height: 100%; background: #000; }
+#canvas { display: block; margin: 0 auto; height: 100%; aspect-ratio: 1; image-rendering: pixelated; }
+</style>
+</head>
+<body>
+<canvas id="canvas" oncontextmenu="event.preventDefault()"></canvas>
+<script>
+var _cartname = [{{.Cart}}];
+var _cdpath = "./";
+var Module = { canvas: document.getElementById("canvas") };
+</script>
+<script src="{{.PlayerURL}}"></script>
+</body>
+</html>
+`))
+
+var tic80Shell = template.Must(template.New("tic80").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>TIC-80 cartridge</title>
+<style>
+html, body { margin: 0; height: 100%; background: #000; }
+#canvas { display: block; margin: 0 auto; width: 100%; height: 100%; object-fit: contain; image-rendering: pixelated; }
+</style>
+</head>
+<body>
+<canvas id="canvas" oncontextmenu="event.preventDefault()"></canvas>
+<script>
+var Module = {
+  canvas: document.getElementById("canvas"),
+  arguments: [{{.Cart}}],
+  preRun: [function () { FS.createPreloadedFile("/", {{.Cart}}, {{.Cart}}, true, false); }]
+};
+</script>
+<script src="{{.PlayerURL}}"></script>
+</body>
+</html>
+`))
+
+// detectCartridge returns the fantasy console an uploaded file name belongs
+// to, or "" for anything else.
+func detectCartridge(filename string) string {
+	name := strings.ToLower(filename)
+	switch {
+	case strings.HasSuffix(name, ".p8.png"):
+		return CartPico8
+	case strings.HasSuffix(name, ".tic"):
+		return CartTic80
+	}
+	return ""
+}
+
+func validatePico8Cart(data []byte) error {
+	cfg, err := png.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return newUploadError(http.StatusBadRequest, "Invalid PICO-8 cartridge: not a PNG image")
+	}
+	if cfg.Width != pico8CartWidth || cfg.Height != pico8CartHeight {
+		return newUploadError(http.StatusBadRequest, "Invalid PICO-8 cartridge: image must be 160x205")
+	}
+	return nil
+}
+
+// validateTic80Cart walks the cart's chunk list: each chunk is a 4 byte
+// header (type in the low 5 bits, 16-bit little endian size, reserved byte)
+// followed by its data.
+func validateTic80Cart(data []byte) error {
+	if len(data) == 0 {
+		return newUploadError(http.StatusBadRequest, "Invalid TIC-80 cartridge: empty file")
+	}
+	for off := 0; off < len(data); {
+		if off+4 > len(data) {
+			return newUploadError(http.StatusBadRequest, "Invalid TIC-80 cartridge: truncated chunk header")
+		}
+		chunkType := data[off] & 0x1f
+		size := int(binary.LittleEndian.Uint16(data[off+1 : off+3]))
+		if chunkType > tic80MaxChunkType {
+			return newUploadError(http.StatusBadRequest, "Invalid TIC-80 cartridge: unknown chunk type")
+		}
+		off += 4 + size
+		if off > len(data) {
+			return newUploadError(http.StatusBadRequest, "Invalid TIC-80 cartridge: truncated chunk")
+		}
+	}
+	return nil
+}
+
+// publishCartridge validates a fantasy console cart and lays it out in destDir
+// next to a generated index.html that boots it in the web player.
+func publishCartridge(cartPath, destDir, kind string) error {
+	f, err := os.Open(cartPath)
+	if err != nil {
+		return newUploadError(http.StatusInternalServerError, "Failed to open cartridge: "+err.Error())
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, maxCartridgeSize+1))
+	if err != nil {
+		return newUploadError(http.StatusInternalServerError, "Failed to read cartridge: "+err.Error())
+	}
+	if len(data) > maxCartridgeSize {
+		return newUploadError(http.StatusBadRequest, "Cartridge is too large")
+	}
+
+	var cartName, playerURL string
+	var shell *template.Template
+	switch kind {
+	case CartPico8:
+		if err := validatePico8Cart(data); err != nil {
+			return err
+		}
+		cartName, shell, playerURL = "cart.p8.png", pico8Shell, os.Getenv("PICO8_PLAYER_URL")
+		if playerURL == "" {
+			playerURL = defaultPico8PlayerURL
+		}
+	case CartTic80:
+		if err := validateTic80Cart(data); err != nil {
+			return err
+		}
+		cartName, shell, playerURL = "cart.tic", tic80Shell, os.Getenv("TIC80_PLAYER_URL")
+		if playerURL == "" {
+			playerURL = defaultTic80PlayerURL
+		}
+	}
+
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return newUploadError(http.StatusInternalServerError, "Failed to create game directory: "+err.Error())
+	}
+	if err := os.WriteFile(filepath.Join(destDir, cartName), data, 0644); err != nil {
+		return newUploadError(http.StatusInternalServerError, "Failed to write cartridge: "+err.Error())
+	}
+
+	var index bytes.Buffer
+	err = shell.Execute(&index, struct {
+		Cart      string
+		PlayerURL string
+	}{cartName, playerURL})
+	if err != nil {
+		return newUploadError(http.StatusInternalServerError, "Failed to render player shell: "+err.Error())
+	}
+	if err := os.WriteFile(filepath.Join(destDir, "index.html"), index.Bytes(), 0644); err != nil {
+		return newUploadError(http.StatusInternalServerError, "Failed to write player shell: "+err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"shiba-api/structs"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type ChangelogEntry struct {
+	GameID    string    `json:"gameId"`
+	PlayURL   string    `json:"playUrl"`
+	Version   int       `json:"version"`
+	Notes     string    `json:"notes"`
+	CreatedAt time.Time `json:"createdAt"`
+	Draft     bool      `json:"draft,omitempty"`
+}
+
+// UpdateChangelogHandler attaches release notes to an existing build. Only the
+// build's owner or an admin can change them.
+func UpdateChangelogHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		gameID := chi.URLParam(r, "gameId")
+
+		var req struct {
+			Notes string `json:"notes" validate:"max=10000"`
+		}
+		if !bindJSON(w, r, &req) {
+			return
+		}
+
+		userID := ""
+		if !isAdmin(srv, r) {
+			user, ok := requireUser(srv, w, r)
+			if !ok {
+				return
+			}
+			userID = user.ID
+		}
+
+		var state buildsState
+		var status int
+		err := updateBuilds(srv, &state, func() error {
+			state.init()
+			build, ok := state.Builds[gameID]
+			if !ok {
+				status = http.StatusNotFound
+				return fmt.Errorf("build %s not found", gameID)
+			}
+			if userID != "" && build.OwnerID != userID {
+				status = http.StatusForbidden
+				return fmt.Errorf("you don't own build %s", gameID)
+			}
+			build.Changelog = req.Notes
+			state.Builds[gameID] = build
+			return nil
+		})
+		if err != nil {
+			if status == 0 {
+				status = http.StatusInternalServerError
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, struct {
+			Ok     bool   `json:"ok"`
+			GameID string `json:"gameId"`
+		}{true, gameID})
+	}
+}
+
+// ChangelogHandler lists every version of a project with its release notes,
+// newest first. Drafts are only listed for the project's owners and admins.
+func ChangelogHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		projectID := chi.URLParam(r, "projectId")
+
+		state, err := loadBuilds(srv)
+		if err != nil {
+			http.Error(w, "Failed to load builds: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		builds := state.projectBuilds(projectID)
+		owner, err := viewerOwnsProject(srv, r, projectID)
+		if err != nil {
+			http.Error(w, "Failed to load builds: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if owner {
+			w.Header().Set("Cache-Control", "private, no-cache")
+		} else {
+			builds = slices.DeleteFunc(builds, func(b Build) bool { return b.Draft })
+		}
+		if len(builds) == 0 {
+			http.Error(w, "Project not found", http.StatusNotFound)
+			return
+		}
+
+		numbers := state.versionNumbers(projectID)
+		entries := make([]ChangelogEntry, 0, len(builds))
+		for _, b := range builds {
+			entries = append(entries, ChangelogEntry{
+				GameID:    b.ID,
+				PlayURL:   "/play/" + b.ID + "/",
+				Version:   numbers[b.ID],
+				Notes:     b.Changelog,
+				CreatedAt: b.CreatedAt,
+				Draft:     b.Draft,
+			})
+		}
+
+		writeJSON(w, http.StatusOK, struct {
+			ProjectID string           `json:"projectId"`
+			Entries   []ChangelogEntry `json:"entries"`
+		}{projectID, entries})
+	}
+}
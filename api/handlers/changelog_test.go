@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"shiba-api/store"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestChangelog(t *testing.T) {
+	srv, _ := testServer(map[string]string{"tok-ada": "recAda"})
+	files, err := store.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Store = files
+
+	// Version 2 was deleted and version 4 is a draft
+	at := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	state := buildsState{Builds: map[string]Build{
+		"g1": {ID: "g1", ProjectID: "recP", OwnerID: "recAda", Version: 1, CreatedAt: at},
+		"g3": {ID: "g3", ProjectID: "recP", OwnerID: "recAda", Version: 3, CreatedAt: at.Add(2 * time.Hour)},
+		"g4": {ID: "g4", ProjectID: "recP", OwnerID: "recAda", Version: 4, CreatedAt: at.Add(3 * time.Hour), Draft: true},
+	}}
+	if err := srv.Store.Save(buildsDoc, state); err != nil {
+		t.Fatal(err)
+	}
+
+	router := chi.NewRouter()
+	router.Get("/projects/{projectId}/changelog", ChangelogHandler(srv))
+	tests := []struct {
+		name   string
+		header string
+		want   map[string]int
+	}{
+		{"anyone", "", map[string]int{"g3": 3, "g1": 1}},
+		{"owner", "Bearer tok-ada", map[string]int{"g4": 4, "g3": 3, "g1": 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/projects/recP/changelog", nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", w.Code, w.Body)
+			}
+			var resp struct{ Entries []ChangelogEntry }
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if len(resp.Entries) != len(tt.want) {
+				t.Fatalf("got %d entries, want %d", len(resp.Entries), len(tt.want))
+			}
+			for _, e := range resp.Entries {
+				if v, ok := tt.want[e.GameID]; !ok || v != e.Version {
+					t.Errorf("entry %s is version %d, want %d (listed %v)", e.GameID, e.Version, v, ok)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package handlers
+
+import (
+	"net"
+	"net/http"
+
+	"shiba-api/clientip"
+	"shiba-api/structs"
+)
+
+// RealIP replaces r.RemoteAddr with the client IP resolved through trusted
+// proxies (TRUSTED_PROXIES), so rate limiting and logs see the real client
+// instead of the Cloudflare edge.
+func RealIP(srv *structs.Server) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ip := clientip.Resolve(r, srv.Config.Get().TrustedProxies); ip != nil {
+				r.RemoteAddr = net.JoinHostPort(ip.String(), "0")
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"shiba-api/quota"
+	"shiba-api/structs"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// The saves of a project are listed with their sizes in savesDoc, so the
+// per-game cap is checked without loading them; each save's data and ETag are
+// in its own saveDoc, written in one update so they always match
+func savesDoc(projectID string) string {
+	return "saves-" + projectID
+}
+
+func saveDoc(projectID, keyHash string) string {
+	return "save-" + projectID + "-" + keyHash
+}
+
+// Player keys are secrets the game picks, e.g. a random code the player can
+// type on another device, so they must be long enough not to be guessed
+var playerKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{16,128}$`)
+
+type saveInfo struct {
+	Size      int64     `json:"size"`
+	ETag      string    `json:"etag"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// savedSize is a save's entry in the listing. Gen is the write it was taken
+// from, so a slower write finishing later can't put back an older size.
+type savedSize struct {
+	Size int64 `json:"size"`
+	Gen  int64 `json:"gen,omitempty"`
+}
+
+type savesState struct {
+	// Saves maps the hash of a player key -> save
+	Saves map[string]savedSize `json:"saves"`
+}
+
+func (s savesState) total() int64 {
+	var n int64
+	for _, save := range s.Saves {
+		n += save.Size
+	}
+	return n
+}
+
+// cloudSave is a save as stored. It's always served as
+// application/octet-stream, whatever the game sent it as.
+type cloudSave struct {
+	Data      []byte    `json:"data"`
+	ETag      string    `json:"etag"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	// Gen counts the writes of the save
+	Gen int64 `json:"gen,omitempty"`
+}
+
+var errSaveConflict = errors.New("save changed")
+
+// saveTarget resolves {gameId} and {playerKey}, answering 404 for unknown
+// games and 422 for keys that are too easy to guess. On failure the error
+// response has already been written.
+func saveTarget(srv *structs.Server, w http.ResponseWriter, r *http.Request) (projectID, keyHash string, ok bool) {
+	key := chi.URLParam(r, "playerKey")
+	if !playerKeyPattern.MatchString(key) {
+		http.Error(w, "Player keys must be 16 to 128 letters, digits, - or _", http.StatusUnprocessableEntity)
+		return "", "", false
+	}
+	projectID, ok = gameProject(srv, w, r)
+	if !ok {
+		return "", "", false
+	}
+	sum := sha256.Sum256([]byte(projectID + "\x00" + key))
+	return projectID, hex.EncodeToString(sum[:16]), true
+}
+
+// GetSaveHandler returns a player's save of a game as it was stored.
+func GetSaveHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		projectID, keyHash, ok := saveTarget(srv, w, r)
+		if !ok {
+			return
+		}
+		var save cloudSave
+		if err := srv.Store.Load(saveDoc(projectID, keyHash), &save); err != nil {
+			http.Error(w, "Failed to load save: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if save.UpdatedAt.IsZero() {
+			http.Error(w, "No save", http.StatusNotFound)
+			return
+		}
+
+		// Saves come from anyone with the key, so they're never rendered on
+		// the API origin
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Content-Disposition", "attachment")
+		w.Header().Set("ETag", save.ETag)
+		w.Header().Set("Cache-Control", "no-store")
+		http.ServeContent(w, r, "", save.UpdatedAt, bytes.NewReader(save.Data))
+	}
+}
+
+// PutSaveHandler replaces a player's save of a game with the request body.
+// Saves are capped in size each and per game; If-Match makes the write
+// conditional, so a device with a stale save can't overwrite a newer one.
+func PutSaveHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		projectID, keyHash, ok := saveTarget(srv, w, r)
+		if !ok {
+			return
+		}
+		cfg := srv.Config.Get()
+		maxBytes := int64(cfg.SaveMaxKB) << 10
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBytes))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, fmt.Sprintf("Saves are limited to %d KB", cfg.SaveMaxKB), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to read save: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		now := time.Now()
+		u, ok := srv.Quotas.Take(ipQuotaKey(r), quota.Saves, cfg.QuotaLimits[quota.Saves], now)
+		setRateLimitHeaders(w, u)
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(u.Reset.Sub(now).Seconds())+1))
+			http.Error(w, "Daily saves quota exceeded", http.StatusTooManyRequests)
+			return
+		}
+
+		sum := sha256.Sum256(data)
+		info := saveInfo{Size: int64(len(data)), ETag: `"` + hex.EncodeToString(sum[:8]) + `"`, UpdatedAt: now.UTC()}
+
+		// The cap is checked against the listing before writing, so
+		// concurrent writes of different saves may go over it by a save
+		var state savesState
+		if err := srv.Store.Load(savesDoc(projectID), &state); err != nil {
+			http.Error(w, "Failed to load saves: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if state.total()-state.Saves[keyHash].Size+info.Size > int64(cfg.SaveGameMaxMB)<<20 {
+			http.Error(w, fmt.Sprintf("This game's saves are over their %d MB limit", cfg.SaveGameMaxMB), http.StatusInsufficientStorage)
+			return
+		}
+
+		var save cloudSave
+		var current string
+		err = srv.Store.Update(saveDoc(projectID, keyHash), &save, func() error {
+			existed := !save.UpdatedAt.IsZero()
+			match := r.Header.Get("If-Match")
+			if match == "*" && !existed || match != "" && match != "*" && match != save.ETag {
+				current = save.ETag
+				return errSaveConflict
+			}
+			save = cloudSave{Data: data, ETag: info.ETag, UpdatedAt: info.UpdatedAt, Gen: save.Gen + 1}
+			return nil
+		})
+		if errors.Is(err, errSaveConflict) {
+			if current != "" {
+				w.Header().Set("ETag", current)
+			}
+			http.Error(w, "The save changed since it was read", http.StatusPreconditionFailed)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to save: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		var listing savesState
+		err = srv.Store.Update(savesDoc(projectID), &listing, func() error {
+			if listing.Saves == nil {
+				listing.Saves = map[string]savedSize{}
+			}
+			if listing.Saves[keyHash].Gen < save.Gen {
+				listing.Saves[keyHash] = savedSize{Size: info.Size, Gen: save.Gen}
+			}
+			return nil
+		})
+		if err != nil {
+			// The save is stored; only the cap counts its old size
+			slog.ErrorContext(r.Context(), "Failed to update the saves listing", "project_id", projectID, "error", err)
+		}
+
+		w.Header().Set("ETag", info.ETag)
+		writeJSON(w, http.StatusOK, info)
+	}
+}
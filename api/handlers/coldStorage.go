@@ -0,0 +1,421 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"shiba-api/quota"
+	"shiba-api/structs"
+	"shiba-api/sync"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const coldStorageDoc = "cold-storage"
+
+// Builds recorded before events were tracked belong to the first event
+const defaultEvent = "shiba"
+
+// Cold storage statuses
+const (
+	ColdArchiving = "archiving"
+	ColdArchived  = "archived"
+	ColdRestoring = "restoring"
+	ColdRestored  = "restored"
+	ColdFailed    = "failed"
+)
+
+// ColdBuild tracks a build moved to the archive prefix. Its build record and
+// manifest stay where they are, only the files move.
+type ColdBuild struct {
+	GameID             string     `json:"gameId"`
+	Event              string     `json:"event"`
+	Status             string     `json:"status"`
+	Files              int        `json:"files"`
+	Bytes              int64      `json:"bytes"`
+	ArchivedAt         *time.Time `json:"archivedAt,omitempty"`
+	RestoreRequestedAt *time.Time `json:"restoreRequestedAt,omitempty"`
+	RestoredAt         *time.Time `json:"restoredAt,omitempty"`
+	Error              string     `json:"error,omitempty"`
+}
+
+type coldStorageState struct {
+	Builds map[string]ColdBuild `json:"builds"`
+}
+
+var (
+	errNotArchived  = errors.New("build is not archived")
+	errRestoreQuota = errors.New("restores quota exceeded")
+)
+
+// Restores running at once; more wait for a slot, so requests can't pile
+// R2 moves and downloads onto the server
+var restoreSlots = make(chan struct{}, 2)
+
+func buildEvent(b Build) string {
+	if b.Event == "" {
+		return defaultEvent
+	}
+	return b.Event
+}
+
+func archiveStorageClass() string {
+	if class := os.Getenv("ARCHIVE_STORAGE_CLASS"); class != "" {
+		return class
+	}
+	return "STANDARD_IA"
+}
+
+func updateColdBuild(srv *structs.Server, gameID string, fn func(c *ColdBuild) error) error {
+	var state coldStorageState
+	return srv.Store.Update(coldStorageDoc, &state, func() error {
+		if state.Builds == nil {
+			state.Builds = map[string]ColdBuild{}
+		}
+		c := state.Builds[gameID]
+		if err := fn(&c); err != nil {
+			return err
+		}
+		state.Builds[gameID] = c
+		return nil
+	})
+}
+
+func loadColdBuild(srv *structs.Server, gameID string) (ColdBuild, bool, error) {
+	var state coldStorageState
+	if err := srv.Store.Load(coldStorageDoc, &state); err != nil {
+		return ColdBuild{}, false, err
+	}
+	c, ok := state.Builds[gameID]
+	return c, ok, nil
+}
+
+// archiveBuild moves every file of a build to cold storage and drops the
+// local copy. On failure the files already moved are put back.
+func archiveBuild(srv *structs.Server, manifest BuildManifest) error {
+	ctx := context.Background()
+	// The blobs of a content-addressed build may be shared with builds
+	// still played, so they stay in standard storage
+	index, err := sync.LoadBlobIndex(ctx, *srv, manifest.GameID)
+	if err != nil {
+		return err
+	}
+	if index != nil {
+		return os.RemoveAll(filepath.Join("./games", manifest.GameID))
+	}
+	class := archiveStorageClass()
+	state := objectLabels(srv, manifest.GameID).State
+	for i, f := range manifest.Files {
+		if err := sync.ArchiveGameFile(ctx, *srv, manifest.GameID, f.Path, class); err != nil {
+			for _, moved := range manifest.Files[:i] {
+				if err := sync.RestoreGameFile(ctx, *srv, manifest.GameID, moved.Path, state); err != nil {
+					slog.ErrorContext(ctx, "Failed to roll back archival", "game_id", manifest.GameID, "path", moved.Path, "error", err)
+				}
+			}
+			return err
+		}
+	}
+	return os.RemoveAll(filepath.Join("./games", manifest.GameID))
+}
+
+// restoreBuild moves a build's files back to standard storage and downloads
+// them again, so the game is playable as soon as it finishes.
+func restoreBuild(srv *structs.Server, manifest BuildManifest) error {
+	ctx := context.Background()
+	dir := filepath.Join("./games", manifest.GameID)
+	state := objectLabels(srv, manifest.GameID).State
+	for _, f := range manifest.Files {
+		// After a failed attempt some files are already back in place
+		moveErr := sync.RestoreGameFile(ctx, *srv, manifest.GameID, f.Path, state)
+		if err := downloadGameFile(ctx, srv, manifest.GameID, f, dir); err != nil {
+			if moveErr != nil {
+				return moveErr
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func downloadGameFile(ctx context.Context, srv *structs.Server, gameID string, f ManifestFile, dir string) error {
+	if !validateZipFilePath(f.Path, dir) {
+		return fmt.Errorf("invalid path %s in manifest", f.Path)
+	}
+	dest := filepath.Join(dir, filepath.FromSlash(f.Path))
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+
+	body, err := sync.OpenGameFile(ctx, *srv, gameID, f.Path, f.SHA256)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, body); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to write %s: %v", dest, err)
+	}
+	return out.Close()
+}
+
+func finishCold(ctx context.Context, srv *structs.Server, gameID, status string, err error) {
+	now := time.Now().UTC()
+	updateErr := updateColdBuild(srv, gameID, func(c *ColdBuild) error {
+		c.Status, c.Error = status, ""
+		switch {
+		case err != nil:
+			c.Status, c.Error = ColdFailed, err.Error()
+		case status == ColdArchived:
+			c.ArchivedAt = &now
+		case status == ColdRestored:
+			c.RestoredAt = &now
+		}
+		return nil
+	})
+	if updateErr != nil {
+		slog.ErrorContext(ctx, "Failed to record cold storage status", "game_id", gameID, "error", updateErr)
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Cold storage failed", "status", status, "game_id", gameID, "error", err)
+	}
+}
+
+// ArchiveEventHandler moves every build of a finished event to cold storage
+// in the background. Builds without a manifest are skipped, since their files
+// aren't known. Pass dryRun=true to list what would move. Requires the admin
+// token.
+func ArchiveEventHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(srv, r) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var query struct {
+			DryRun bool `query:"dryRun"`
+		}
+		if !bindQuery(w, r, &query) {
+			return
+		}
+		event := chi.URLParam(r, "event")
+		if event == srv.Config.Get().EventID {
+			http.Error(w, "Can't archive the current event", http.StatusConflict)
+			return
+		}
+
+		builds, err := loadBuilds(srv)
+		if err != nil {
+			http.Error(w, "Failed to load builds: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var cold coldStorageState
+		if err := srv.Store.Load(coldStorageDoc, &cold); err != nil {
+			http.Error(w, "Failed to load cold storage: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		var manifests []BuildManifest
+		skipped := []string{}
+		var bytes int64
+		for id, b := range builds.Builds {
+			if buildEvent(b) != event {
+				continue
+			}
+			if c, ok := cold.Builds[id]; ok && c.Status != ColdRestored && c.Status != ColdFailed {
+				continue
+			}
+			manifest, err := loadBuildManifest(srv, id)
+			if err != nil {
+				http.Error(w, "Failed to load manifest: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if manifest == nil {
+				skipped = append(skipped, id)
+				continue
+			}
+			manifests = append(manifests, *manifest)
+			for _, f := range manifest.Files {
+				bytes += f.Size
+			}
+		}
+		sort.Slice(manifests, func(i, j int) bool { return manifests[i].GameID < manifests[j].GameID })
+		sort.Strings(skipped)
+
+		ids := make([]string, len(manifests))
+		for i, m := range manifests {
+			ids[i] = m.GameID
+		}
+
+		if !query.DryRun {
+			var state coldStorageState
+			err := srv.Store.Update(coldStorageDoc, &state, func() error {
+				if state.Builds == nil {
+					state.Builds = map[string]ColdBuild{}
+				}
+				for _, m := range manifests {
+					c := ColdBuild{GameID: m.GameID, Event: event, Status: ColdArchiving, Files: len(m.Files)}
+					for _, f := range m.Files {
+						c.Bytes += f.Size
+					}
+					state.Builds[m.GameID] = c
+				}
+				return nil
+			})
+			if err != nil {
+				http.Error(w, "Failed to record archival: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			go func() {
+				ctx := context.Background()
+				for _, m := range manifests {
+					finishCold(ctx, srv, m.GameID, ColdArchived, archiveBuild(srv, m))
+				}
+				slog.InfoContext(ctx, "Archived event builds", "event", event, "builds", len(manifests))
+			}()
+		}
+
+		status := http.StatusAccepted
+		if query.DryRun {
+			status = http.StatusOK
+		}
+		writeJSON(w, status, struct {
+			Event   string   `json:"event"`
+			DryRun  bool     `json:"dryRun"`
+			Builds  []string `json:"builds"`
+			Bytes   int64    `json:"bytes"`
+			Skipped []string `json:"skipped"`
+		}{event, query.DryRun, ids, bytes, skipped})
+	}
+}
+
+// RestoreBuildHandler brings an archived build back to standard storage in
+// the background. Any signed-in user may request it, e.g. a player opening an
+// old game; each restore started counts against their restores quota.
+func RestoreBuildHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		gameID := chi.URLParam(r, "gameId")
+		user, ok := requireUser(srv, w, r)
+		if !ok {
+			return
+		}
+
+		manifest, err := loadBuildManifest(srv, gameID)
+		if err != nil {
+			http.Error(w, "Failed to load manifest: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		start := false
+		var cold ColdBuild
+		var usage quota.Usage
+		now := time.Now()
+		err = updateColdBuild(srv, gameID, func(c *ColdBuild) error {
+			switch c.Status {
+			case ColdArchived:
+			case ColdRestoring:
+				cold = *c
+				return nil
+			case ColdFailed:
+				// A failed restore can be retried; a failed archival was
+				// rolled back and has nothing to restore
+				if c.RestoreRequestedAt == nil {
+					return errNotArchived
+				}
+			default:
+				return errNotArchived
+			}
+			var allowed bool
+			usage, allowed = srv.Quotas.Take(userQuotaKey(user.ID), quota.Restores, srv.Config.Get().QuotaLimits[quota.Restores], now)
+			if !allowed {
+				return errRestoreQuota
+			}
+			requested := now.UTC()
+			c.Status, c.Error, c.RestoreRequestedAt = ColdRestoring, "", &requested
+			start = true
+			cold = *c
+			return nil
+		})
+		if start || errors.Is(err, errRestoreQuota) {
+			setRateLimitHeaders(w, usage)
+		}
+		if errors.Is(err, errRestoreQuota) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(usage.Reset.Sub(now).Seconds())+1))
+			http.Error(w, "Daily restores quota exceeded", http.StatusTooManyRequests)
+			return
+		}
+		if errors.Is(err, errNotArchived) {
+			http.Error(w, "This build is not archived", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to request restore: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if start {
+			if manifest == nil {
+				finishCold(r.Context(), srv, gameID, ColdRestored, fmt.Errorf("build has no manifest"))
+				http.Error(w, "Build has no manifest", http.StatusInternalServerError)
+				return
+			}
+			go func() {
+				restoreSlots <- struct{}{}
+				defer func() { <-restoreSlots }()
+				finishCold(context.Background(), srv, gameID, ColdRestored, restoreBuild(srv, *manifest))
+			}()
+		}
+
+		w.Header().Set("Retry-After", "60")
+		writeJSON(w, http.StatusAccepted, cold)
+	}
+}
+
+// ColdStorageHandler returns the cold storage status of a build.
+func ColdStorageHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cold, ok, err := loadColdBuild(srv, chi.URLParam(r, "gameId"))
+		if err != nil {
+			http.Error(w, "Failed to load cold storage: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, "This build was never archived", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, http.StatusOK, cold)
+	}
+}
+
+// serveColdBuild answers plays of builds that are in cold storage, pointing
+// players at the restore endpoint. It reports whether it wrote a response.
+func serveColdBuild(srv *structs.Server, w http.ResponseWriter, gameID string) bool {
+	cold, ok, err := loadColdBuild(srv, gameID)
+	if err != nil || !ok {
+		return false
+	}
+	switch cold.Status {
+	case ColdArchiving, ColdArchived:
+		http.Error(w, "This game is archived. POST /builds/"+gameID+"/restore to bring it back, it takes a few minutes.", http.StatusGone)
+	case ColdRestoring:
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, "This game is being restored from the archive, try again in a minute.", http.StatusServiceUnavailable)
+	default:
+		return false
+	}
+	return true
+}
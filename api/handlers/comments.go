@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"shiba-api/schema"
+	"shiba-api/stats"
+	"shiba-api/structs"
+	"shiba-api/webhooks"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Each project's comments are in their own document, oldest first
+func commentsDoc(projectID string) string {
+	return "comments-" + projectID
+}
+
+// A game keeps its newest maxComments comments
+const maxComments = 5000
+
+// Comment is feedback a player left on a game.
+type Comment struct {
+	ID string `json:"id"`
+	// BuildID is the build that was played, so creators know which version
+	// the feedback is about
+	BuildID    string    `json:"buildId"`
+	AuthorID   string    `json:"authorId"`
+	AuthorName string    `json:"authorName"`
+	Body       string    `json:"body"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+type commentsState struct {
+	Comments []Comment `json:"comments"`
+}
+
+type postCommentRequest struct {
+	Body string `json:"body" validate:"required,max=2000"`
+}
+
+type commentsQuery struct {
+	Cursor string `query:"cursor"`
+	Limit  int    `query:"limit" validate:"min=1,max=100"`
+}
+
+func newCommentID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// PostCommentHandler leaves feedback on a game, attached to the build
+// {gameId} names or, for a project, the build it serves. Feedback from
+// anyone but the game's owners counts towards its stats and the author's
+// streak, and notifies the creators' webhooks. Requires a user token.
+func PostCommentHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := requireUser(srv, w, r)
+		if !ok {
+			return
+		}
+		var req postCommentRequest
+		if !bindJSON(w, r, &req) {
+			return
+		}
+		req.Body = strings.TrimSpace(req.Body)
+		if req.Body == "" {
+			invalidField(w, "body", schema.InBody, "must not be blank")
+			return
+		}
+		projectID, ok := gameProject(srv, w, r)
+		if !ok {
+			return
+		}
+
+		builds, err := loadBuilds(srv)
+		if err != nil {
+			http.Error(w, "Failed to load builds: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		build, ok := builds.Builds[chi.URLParam(r, "gameId")]
+		if !ok {
+			build, _ = builds.currentBuild(projectID)
+		}
+		owns, err := ownsProject(r.Context(), srv, user.ID, projectID)
+		if err != nil {
+			http.Error(w, "Failed to load builds: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		name, _ := user.Fields["Name"].(string)
+		comment := Comment{
+			ID:         newCommentID(),
+			BuildID:    build.ID,
+			AuthorID:   user.ID,
+			AuthorName: name,
+			Body:       req.Body,
+			CreatedAt:  time.Now().UTC(),
+		}
+		var state commentsState
+		err = srv.Store.Update(commentsDoc(projectID), &state, func() error {
+			state.Comments = append(state.Comments, comment)
+			if len(state.Comments) > maxComments {
+				state.Comments = state.Comments[len(state.Comments)-maxComments:]
+			}
+			return nil
+		})
+		if err != nil {
+			http.Error(w, "Failed to save comment: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if !owns {
+			srv.Stats.Add(projectID, comment.CreatedAt, stats.Counts{Feedback: 1})
+			if err := recordActivity(srv, user.ID, ActivityFeedback, comment.CreatedAt); err != nil {
+				slog.ErrorContext(r.Context(), "Failed to record feedback activity", "user_id", user.ID, "error", err)
+			}
+			text := func(name string) string { return "New comment on " + name + ": " + comment.Body }
+			notifyCreators(r.Context(), srv, projectID, webhooks.EventFeedback, text, struct {
+				At      time.Time `json:"at"`
+				Comment Comment   `json:"comment"`
+			}{comment.CreatedAt, comment})
+		}
+
+		writeJSON(w, http.StatusCreated, comment)
+	}
+}
+
+// CommentsHandler lists the comments on a game, newest first, a page at a
+// time. Pages use the same cursors as MyGamesHandler.
+func CommentsHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := commentsQuery{Limit: 20}
+		if !bindQuery(w, r, &query) {
+			return
+		}
+		projectID, ok := gameProject(srv, w, r)
+		if !ok {
+			return
+		}
+		var state commentsState
+		if err := srv.Store.Load(commentsDoc(projectID), &state); err != nil {
+			http.Error(w, "Failed to load comments: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		comments := append([]Comment{}, state.Comments...)
+		sort.Slice(comments, func(i, j int) bool {
+			if !comments[i].CreatedAt.Equal(comments[j].CreatedAt) {
+				return comments[i].CreatedAt.After(comments[j].CreatedAt)
+			}
+			return comments[i].ID < comments[j].ID
+		})
+
+		if query.Cursor != "" {
+			cursor, ok := parseGamesCursor(query.Cursor)
+			if !ok {
+				invalidField(w, "cursor", schema.InQuery, "is not a cursor from a previous page")
+				return
+			}
+			start := sort.Search(len(comments), func(i int) bool {
+				c := comments[i]
+				if !c.CreatedAt.Equal(cursor.createdAt) {
+					return c.CreatedAt.Before(cursor.createdAt)
+				}
+				return c.ID > cursor.id
+			})
+			comments = comments[start:]
+		}
+
+		next := ""
+		if len(comments) > query.Limit {
+			comments = comments[:query.Limit]
+			last := comments[len(comments)-1]
+			next = gamesCursor{last.CreatedAt, last.ID}.String()
+		}
+
+		w.Header().Set("Cache-Control", "no-cache")
+		writeJSON(w, http.StatusOK, struct {
+			Comments   []Comment `json:"comments"`
+			NextCursor string    `json:"nextCursor,omitempty"`
+		}{comments, next})
+	}
+}
+
+// DeleteCommentHandler removes a comment. Its author, the game's owners and
+// admins can delete it.
+func DeleteCommentHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		projectID, ok := gameProject(srv, w, r)
+		if !ok {
+			return
+		}
+		id := chi.URLParam(r, "commentId")
+
+		var state commentsState
+		if err := srv.Store.Load(commentsDoc(projectID), &state); err != nil {
+			http.Error(w, "Failed to load comments: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var comment *Comment
+		for i := range state.Comments {
+			if state.Comments[i].ID == id {
+				comment = &state.Comments[i]
+				break
+			}
+		}
+		if comment == nil {
+			http.Error(w, "Comment not found", http.StatusNotFound)
+			return
+		}
+		if !isAdmin(srv, r) {
+			user, ok := requireUser(srv, w, r)
+			if !ok {
+				return
+			}
+			if user.ID != comment.AuthorID && !requireProjectOwner(srv, w, r, projectID) {
+				return
+			}
+		}
+
+		found := false
+		var updated commentsState
+		err := srv.Store.Update(commentsDoc(projectID), &updated, func() error {
+			kept := updated.Comments[:0]
+			for _, c := range updated.Comments {
+				if c.ID == id {
+					found = true
+					continue
+				}
+				kept = append(kept, c)
+			}
+			updated.Comments = kept
+			return nil
+		})
+		if err != nil {
+			http.Error(w, "Failed to delete comment: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			http.Error(w, "Comment not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
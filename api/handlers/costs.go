@@ -0,0 +1,250 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"shiba-api/costs"
+	"shiba-api/structs"
+	"shiba-api/sync"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// countingWriter counts the body bytes written to a response and records
+// its status. It passes Flush and ReadFrom through, so streamed responses
+// and sendfile keep working behind it.
+type countingWriter struct {
+	http.ResponseWriter
+	n      int64
+	status int
+}
+
+func (w *countingWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+func (w *countingWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *countingWriter) ReadFrom(src io.Reader) (int64, error) {
+	if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		n, err := rf.ReadFrom(src)
+		w.n += n
+		return n, err
+	}
+	return io.Copy(struct{ io.Writer }{w}, src)
+}
+
+func (w *countingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// knownBuild reports whether gameID is a build, on disk or recorded.
+func knownBuild(srv *structs.Server, gameID string) bool {
+	if strings.ContainsAny(gameID, `./\`) {
+		return false
+	}
+	if _, err := os.Stat(filepath.Join("./games", gameID)); err == nil {
+		return true
+	}
+	_, ok, err := lookupBuild(srv, gameID)
+	return err == nil && ok
+}
+
+// MeterEgress counts the requests and bytes served for each build, for cost
+// reports. Only routes with a {gameId} are counted, and only for responses
+// that succeeded for a known build, so made-up IDs can't grow the meter.
+func MeterEgress(srv *structs.Server) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cw := &countingWriter{ResponseWriter: w}
+			next.ServeHTTP(cw, r)
+			gameID := chi.URLParam(r, "gameId")
+			if gameID == "" || cw.status >= http.StatusBadRequest {
+				return
+			}
+			if knownBuild(srv, gameID) {
+				srv.Egress.Add(gameID, cw.n)
+			}
+		})
+	}
+}
+
+// BuildCost is the storage and traffic of one build over a report window.
+type BuildCost struct {
+	GameID    string `json:"gameId"`
+	ProjectID string `json:"projectId"`
+	OwnerID   string `json:"ownerId,omitempty"`
+	EventID   string `json:"eventId,omitempty"`
+	// State matches the state label of the build's objects in R2
+	State        string        `json:"state"`
+	StorageBytes int64         `json:"storageBytes"`
+	Archived     bool          `json:"archived,omitempty"`
+	Traffic      costs.Traffic `json:"traffic"`
+	EstimatedUSD float64       `json:"estimatedUsd"`
+}
+
+// CostTotal sums the builds of a project, a user or an event.
+type CostTotal struct {
+	ID           string        `json:"id"`
+	Builds       int           `json:"builds"`
+	StorageBytes int64         `json:"storageBytes"`
+	Traffic      costs.Traffic `json:"traffic"`
+	EstimatedUSD float64       `json:"estimatedUsd"`
+}
+
+func (t *CostTotal) add(b BuildCost) {
+	t.Builds++
+	t.StorageBytes += b.StorageBytes
+	t.Traffic.Requests += b.Traffic.Requests
+	t.Traffic.Bytes += b.Traffic.Bytes
+	t.EstimatedUSD += b.EstimatedUSD
+}
+
+// buildCosts prices every build matching keep over the last days. Storage
+// comes from the build manifests, traffic from the egress meter.
+func buildCosts(srv *structs.Server, days int, keep func(Build) bool) ([]BuildCost, error) {
+	builds, err := loadBuilds(srv)
+	if err != nil {
+		return nil, err
+	}
+	var cold coldStorageState
+	if err := srv.Store.Load(coldStorageDoc, &cold); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	traffic, err := costs.Egress(srv.Store, now.AddDate(0, 0, -days+1), now)
+	if err != nil {
+		return nil, err
+	}
+
+	rates := srv.Config.Get().CostRates
+	result := []BuildCost{}
+	for id, b := range builds.Builds {
+		if !keep(b) {
+			continue
+		}
+		manifest, err := loadBuildManifest(srv, id)
+		if err != nil {
+			return nil, err
+		}
+		c := BuildCost{GameID: id, ProjectID: b.ProjectID, OwnerID: b.OwnerID, EventID: b.Event, State: b.objectState(), Traffic: traffic[id]}
+		if manifest != nil {
+			for _, f := range manifest.Files {
+				c.StorageBytes += f.Size
+			}
+		}
+		switch cold.Builds[id].Status {
+		case ColdArchiving, ColdArchived:
+			c.Archived = true
+			c.State = sync.StateFrozen
+		}
+		c.EstimatedUSD = rates.Estimate(c.StorageBytes, c.Archived, days, c.Traffic)
+		result = append(result, c)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].EstimatedUSD > result[j].EstimatedUSD })
+	return result, nil
+}
+
+func sumCosts(builds []BuildCost, key func(BuildCost) string) []CostTotal {
+	byKey := map[string]*CostTotal{}
+	for _, b := range builds {
+		k := key(b)
+		if k == "" {
+			continue
+		}
+		if byKey[k] == nil {
+			byKey[k] = &CostTotal{ID: k}
+		}
+		byKey[k].add(b)
+	}
+	totals := make([]CostTotal, 0, len(byKey))
+	for _, t := range byKey {
+		totals = append(totals, *t)
+	}
+	sort.Slice(totals, func(i, j int) bool { return totals[i].EstimatedUSD > totals[j].EstimatedUSD })
+	return totals
+}
+
+// CostReportHandler estimates what every build, project and user cost over
+// the last days, most expensive first, by build, project, user and event.
+// Requires the admin token.
+func CostReportHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(srv, r) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		query := struct {
+			Days int `query:"days" validate:"min=1,max=366"`
+		}{Days: 30}
+		if !bindQuery(w, r, &query) {
+			return
+		}
+		days := query.Days
+
+		builds, err := buildCosts(srv, days, func(Build) bool { return true })
+		if err != nil {
+			http.Error(w, "Failed to compute costs: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		total := CostTotal{ID: "total"}
+		for _, b := range builds {
+			total.add(b)
+		}
+
+		writeJSON(w, http.StatusOK, struct {
+			Days     int         `json:"days"`
+			Rates    costs.Rates `json:"rates"`
+			Total    CostTotal   `json:"total"`
+			Users    []CostTotal `json:"users"`
+			Projects []CostTotal `json:"projects"`
+			Events   []CostTotal `json:"events"`
+			Builds   []BuildCost `json:"builds"`
+		}{
+			Days:     days,
+			Rates:    srv.Config.Get().CostRates,
+			Total:    total,
+			Users:    sumCosts(builds, func(b BuildCost) string { return b.OwnerID }),
+			Projects: sumCosts(builds, func(b BuildCost) string { return b.ProjectID }),
+			Events:   sumCosts(builds, func(b BuildCost) string { return b.EventID }),
+			Builds:   builds,
+		})
+	}
+}
+
+// userStorage sums what userID's builds take up and were served over the
+// last 30 days.
+func userStorage(srv *structs.Server, userID string) (CostTotal, error) {
+	builds, err := buildCosts(srv, 30, func(b Build) bool { return b.OwnerID == userID })
+	if err != nil {
+		return CostTotal{}, err
+	}
+	total := CostTotal{ID: userID}
+	for _, b := range builds {
+		total.add(b)
+	}
+	return total, nil
+}
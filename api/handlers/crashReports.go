@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"shiba-api/stats"
+	"shiba-api/structs"
+	"shiba-api/webhooks"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// A crash with the same message in the same build notifies webhooks at most
+// once per crashNotifyInterval; every report still counts in the stats
+const crashNotifyInterval = time.Hour
+
+// crashNotified remembers when each build and message last notified
+var crashNotified = struct {
+	sync.Mutex
+	at map[string]time.Time
+}{at: map[string]time.Time{}}
+
+// firstCrashInAWhile reports whether a crash should notify, and records it.
+func firstCrashInAWhile(gameID, message string, now time.Time) bool {
+	crashNotified.Lock()
+	defer crashNotified.Unlock()
+	for key, at := range crashNotified.at {
+		if now.Sub(at) >= crashNotifyInterval {
+			delete(crashNotified.at, key)
+		}
+	}
+	key := gameID + "\x00" + message
+	if _, ok := crashNotified.at[key]; ok {
+		return false
+	}
+	crashNotified.at[key] = now
+	return true
+}
+
+type crashReport struct {
+	Message string `json:"message" validate:"required,max=500"`
+	Source  string `json:"source" validate:"max=500"`
+	Line    int    `json:"line" validate:"min=0"`
+	Stack   string `json:"stack" validate:"max=4000"`
+}
+
+// ReportCrashHandler records an uncaught error in a build, sent by the game
+// page, and tells the creator's webhooks about new ones. Anyone playing can
+// report, so reports only count towards the stats and are never shown to
+// players.
+func ReportCrashHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req crashReport
+		if !bindJSON(w, r, &req) {
+			return
+		}
+		builds, err := loadBuilds(srv)
+		if err != nil {
+			http.Error(w, "Failed to load builds: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		build, ok := publishedBuild(&builds, chi.URLParam(r, "gameId"))
+		if !ok {
+			http.Error(w, "Game not found", http.StatusNotFound)
+			return
+		}
+		projectID := build.ProjectID
+		if projectID == "" {
+			projectID = build.ID
+		}
+
+		now := time.Now()
+		srv.Stats.Add(projectID, now, stats.Counts{Crashes: 1})
+		if firstCrashInAWhile(build.ID, req.Message, now) {
+			text := func(name string) string {
+				return fmt.Sprintf("%s crashed for a player: %s", name, req.Message)
+			}
+			notifyCreators(r.Context(), srv, projectID, webhooks.EventCrash, text, struct {
+				GameID string `json:"gameId"`
+				crashReport
+			}{build.ID, req})
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// crashSnippet reports a game page's first few uncaught errors.
+const crashSnippet = `<script>(function () {
+var url = %s, left = 3;
+function report(message, source, line, stack) {
+  if (left-- <= 0 || !window.fetch) return;
+  fetch(url, {method: "POST", keepalive: true, headers: {"Content-Type": "application/json"},
+    body: JSON.stringify({message: String(message).slice(0, 500), source: String(source || "").slice(0, 500), line: line || 0, stack: String(stack || "").slice(0, 4000)})}).catch(function () {});
+}
+window.addEventListener("error", function (e) { report(e.message, e.filename, e.lineno, e.error && e.error.stack); });
+window.addEventListener("unhandledrejection", function (e) { var r = e.reason || {}; report(r.message || r, "", 0, r.stack); });
+})();</script>`
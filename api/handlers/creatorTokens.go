@@ -0,0 +1,269 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"shiba-api/datastore"
+	"shiba-api/schema"
+	"shiba-api/structs"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mehanizm/airtable"
+)
+
+const creatorTokensDoc = "creator-tokens"
+
+// Creator tokens are told apart from account and session tokens by their
+// prefix
+const creatorTokenPrefix = "ctok_"
+
+// Tokens a user may hold at once
+const maxCreatorTokens = 20
+
+// Scopes a creator token can be granted. Routes declare the scope they need
+// with TokenScope; routes that declare none reject creator tokens.
+const (
+	// ScopeUploads allows uploading builds and following their progress
+	ScopeUploads = "uploads"
+	// ScopeGamesRead allows listing the creator's games
+	ScopeGamesRead = "games:read"
+	// ScopeWebhooks allows managing the creator's webhooks
+	ScopeWebhooks = "webhooks"
+)
+
+var tokenScopes = []string{ScopeUploads, ScopeGamesRead, ScopeWebhooks}
+
+var (
+	errInsufficientScope    = errors.New("token lacks the scope for this endpoint")
+	errCreatorTokenNotFound = errors.New("creator token not found")
+)
+
+// CreatorToken is a token a creator minted for a script or integration,
+// limited to some scopes, e.g. a CI job that may only upload.
+type CreatorToken struct {
+	ID         string    `json:"id"`
+	UserID     string    `json:"userId"`
+	Name       string    `json:"name"`
+	Scopes     []string  `json:"scopes"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastUsedAt time.Time `json:"lastUsedAt"`
+}
+
+type creatorTokensState struct {
+	// Keyed by token hash
+	Tokens map[string]CreatorToken `json:"tokens"`
+}
+
+type scopeKey struct{}
+
+// TokenScope marks the routes it wraps as usable with creator tokens granted
+// scope.
+func TokenScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), scopeKey{}, scope)))
+		})
+	}
+}
+
+func requiredScope(ctx context.Context) string {
+	scope, _ := ctx.Value(scopeKey{}).(string)
+	return scope
+}
+
+// authenticateCreatorToken looks up the user owning a creator token, if the
+// token was granted the scope the route needs. Creator tokens bypass the
+// token cache so revoking one takes effect immediately.
+func authenticateCreatorToken(srv *structs.Server, r *http.Request, token string) (*airtable.Record, error) {
+	hash := hashKioskToken(token)
+	var state creatorTokensState
+	if err := srv.Store.Load(creatorTokensDoc, &state); err != nil {
+		return nil, err
+	}
+	ct, ok := state.Tokens[hash]
+	if !ok {
+		return nil, errUnauthorized
+	}
+	if scope := requiredScope(r.Context()); scope == "" || !slices.Contains(ct.Scopes, scope) {
+		return nil, errInsufficientScope
+	}
+
+	now := time.Now().UTC()
+	if now.Sub(ct.LastUsedAt) >= sessionTouchInterval {
+		var latest creatorTokensState
+		err := srv.Store.Update(creatorTokensDoc, &latest, func() error {
+			t, ok := latest.Tokens[hash]
+			if !ok {
+				return errCreatorTokenNotFound
+			}
+			t.LastUsedAt = now
+			latest.Tokens[hash] = t
+			return nil
+		})
+		if errors.Is(err, errCreatorTokenNotFound) {
+			return nil, errUnauthorized
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	user, err := srv.UserStore.UserByID(r.Context(), ct.UserID)
+	if errors.Is(err, datastore.ErrNotFound) {
+		return nil, errUnauthorized
+	}
+	return user, err
+}
+
+type createCreatorTokenRequest struct {
+	Name   string   `json:"name" validate:"required,max=100"`
+	Scopes []string `json:"scopes" validate:"required,min=1"`
+}
+
+type mintedCreatorToken struct {
+	CreatorToken
+	Token string `json:"token"`
+}
+
+// CreateCreatorTokenHandler mints a scoped token for the caller. Only account
+// and session tokens can mint them. The token is only shown once.
+func CreateCreatorTokenHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := requireUser(srv, w, r)
+		if !ok {
+			return
+		}
+		var req createCreatorTokenRequest
+		if !bindJSON(w, r, &req) {
+			return
+		}
+		scopes := []string{}
+		for _, s := range req.Scopes {
+			if !slices.Contains(tokenScopes, s) {
+				invalidField(w, "scopes", schema.InBody, "unknown scope %q, expected any of %s", s, strings.Join(tokenScopes, ", "))
+				return
+			}
+			if !slices.Contains(scopes, s) {
+				scopes = append(scopes, s)
+			}
+		}
+
+		b := make([]byte, 24)
+		if _, err := rand.Read(b); err != nil {
+			http.Error(w, "Failed to generate token: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		token := creatorTokenPrefix + hex.EncodeToString(b)
+
+		ct := CreatorToken{
+			ID:        newKioskID(),
+			UserID:    user.ID,
+			Name:      strings.TrimSpace(req.Name),
+			Scopes:    scopes,
+			CreatedAt: time.Now().UTC(),
+		}
+		var state creatorTokensState
+		tooMany := false
+		err := srv.Store.Update(creatorTokensDoc, &state, func() error {
+			if state.Tokens == nil {
+				state.Tokens = map[string]CreatorToken{}
+			}
+			held := 0
+			for _, t := range state.Tokens {
+				if t.UserID == user.ID {
+					held++
+				}
+			}
+			if held >= maxCreatorTokens {
+				tooMany = true
+				return nil
+			}
+			state.Tokens[hashKioskToken(token)] = ct
+			return nil
+		})
+		if err != nil {
+			http.Error(w, "Failed to save token: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if tooMany {
+			http.Error(w, "Too many tokens, revoke one first", http.StatusConflict)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, http.StatusOK, mintedCreatorToken{ct, token})
+	}
+}
+
+// MyCreatorTokensHandler lists the caller's creator tokens, newest first.
+func MyCreatorTokensHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := requireUser(srv, w, r)
+		if !ok {
+			return
+		}
+
+		var state creatorTokensState
+		if err := srv.Store.Load(creatorTokensDoc, &state); err != nil {
+			http.Error(w, "Failed to load tokens: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		tokens := []CreatorToken{}
+		for _, t := range state.Tokens {
+			if t.UserID == user.ID {
+				tokens = append(tokens, t)
+			}
+		}
+		sort.Slice(tokens, func(i, j int) bool { return tokens[i].CreatedAt.After(tokens[j].CreatedAt) })
+
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, http.StatusOK, struct {
+			Scopes []string       `json:"scopes"`
+			Tokens []CreatorToken `json:"tokens"`
+		}{tokenScopes, tokens})
+	}
+}
+
+// RevokeCreatorTokenHandler deletes one of the caller's creator tokens. It
+// stops working immediately.
+func RevokeCreatorTokenHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := requireUser(srv, w, r)
+		if !ok {
+			return
+		}
+		tokenID := chi.URLParam(r, "tokenId")
+
+		var state creatorTokensState
+		err := srv.Store.Update(creatorTokensDoc, &state, func() error {
+			for hash, t := range state.Tokens {
+				if t.ID == tokenID && t.UserID == user.ID {
+					delete(state.Tokens, hash)
+					return nil
+				}
+			}
+			return errCreatorTokenNotFound
+		})
+		if errors.Is(err, errCreatorTokenNotFound) {
+			http.Error(w, "Token not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to revoke token: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, struct {
+			Ok bool   `json:"ok"`
+			ID string `json:"id"`
+		}{true, tokenID})
+	}
+}
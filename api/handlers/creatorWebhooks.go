@@ -0,0 +1,397 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"shiba-api/schema"
+	"shiba-api/stats"
+	"shiba-api/structs"
+	"shiba-api/webhooks"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const creatorWebhooksDoc = "creator-webhooks"
+
+const (
+	// Webhooks a user may register
+	maxCreatorWebhooks = 10
+	// Webhooks are disabled after this many failed deliveries in a row, until
+	// a test delivery succeeds
+	webhookFailureLimit = 25
+)
+
+var errWebhookNotFound = errors.New("webhook not found")
+
+// CreatorWebhook sends events about a creator's games to a URL of theirs.
+type CreatorWebhook struct {
+	ID     string   `json:"id"`
+	UserID string   `json:"userId"`
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	// ProjectID limits the webhook to one project, else it covers every
+	// project the creator owns
+	ProjectID string `json:"projectId,omitempty"`
+	// Secret signs deliveries. It is only shown when the webhook is created.
+	Secret       string           `json:"secret,omitempty"`
+	CreatedAt    time.Time        `json:"createdAt"`
+	LastDelivery *webhooks.Result `json:"lastDelivery,omitempty"`
+	// Failures counts failed deliveries since the last success
+	Failures int  `json:"failures"`
+	Disabled bool `json:"disabled"`
+}
+
+// public is the webhook as shown after creation, without its secret.
+func (h CreatorWebhook) public() CreatorWebhook {
+	h.Secret = ""
+	return h
+}
+
+type creatorWebhooksState struct {
+	Webhooks map[string]CreatorWebhook `json:"webhooks"`
+}
+
+// notifyCreators sends an event about a project to the webhooks of its
+// owners that subscribed to it, summarized by text from the project's name.
+// Delivery happens in the background; failing to notify never fails the
+// caller.
+func notifyCreators(ctx context.Context, srv *structs.Server, projectID, event string, text func(name string) string, data any) {
+	var state creatorWebhooksState
+	if err := srv.Store.Load(creatorWebhooksDoc, &state); err != nil {
+		slog.ErrorContext(ctx, "Failed to load webhooks", "project_id", projectID, "event", event, "error", err)
+		return
+	}
+	owners := map[string]bool{}
+	summary := ""
+	for _, h := range state.Webhooks {
+		if h.Disabled || !slices.Contains(h.Events, event) || (h.ProjectID != "" && h.ProjectID != projectID) {
+			continue
+		}
+		owns, checked := owners[h.UserID]
+		if !checked {
+			var err error
+			owns, err = ownsProject(ctx, srv, h.UserID, projectID)
+			if err != nil {
+				slog.ErrorContext(ctx, "Failed to check project owner for webhook", "project_id", projectID, "error", err)
+				return
+			}
+			owners[h.UserID] = owns
+		}
+		if !owns {
+			continue
+		}
+		if summary == "" {
+			summary = text(lookupProject(ctx, srv, projectID).name)
+		}
+		sendWebhook(srv, h, webhooks.Payload{
+			Event:     event,
+			ProjectID: projectID,
+			At:        time.Now().UTC(),
+			Text:      summary,
+			Data:      data,
+		})
+	}
+}
+
+// sendWebhook queues a delivery and records its outcome on the webhook.
+func sendWebhook(srv *structs.Server, h CreatorWebhook, p webhooks.Payload) {
+	queued := srv.Webhooks.Send(webhooks.Target{ID: h.ID, URL: h.URL, Secret: h.Secret}, p, func(result webhooks.Result) {
+		var state creatorWebhooksState
+		err := srv.Store.Update(creatorWebhooksDoc, &state, func() error {
+			latest, ok := state.Webhooks[h.ID]
+			if !ok {
+				return nil
+			}
+			latest.LastDelivery = &result
+			if result.OK() {
+				latest.Failures = 0
+				latest.Disabled = false
+			} else if latest.Failures++; latest.Failures >= webhookFailureLimit {
+				latest.Disabled = true
+			}
+			state.Webhooks[h.ID] = latest
+			return nil
+		})
+		if err != nil {
+			slog.Error("Failed to record webhook delivery", "webhook_id", h.ID, "error", err)
+		}
+	})
+	if !queued {
+		slog.Warn("Dropped webhook delivery, queue full", "webhook_id", h.ID, "event", p.Event)
+	}
+}
+
+type createWebhookRequest struct {
+	URL       string   `json:"url" validate:"required,max=2000"`
+	Events    []string `json:"events" validate:"required,min=1"`
+	ProjectID string   `json:"projectId"`
+}
+
+// CreateWebhookHandler registers a webhook for the caller's games. The
+// signing secret is only shown in the response.
+func CreateWebhookHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := requireUser(srv, w, r)
+		if !ok {
+			return
+		}
+		var req createWebhookRequest
+		if !bindJSON(w, r, &req) {
+			return
+		}
+		if err := webhooks.ValidateURL(req.URL); err != nil {
+			invalidField(w, "url", schema.InBody, "%v", err)
+			return
+		}
+		events := []string{}
+		for _, e := range req.Events {
+			if !slices.Contains(webhooks.Events, e) {
+				invalidField(w, "events", schema.InBody, "unknown event %q, expected any of %s", e, strings.Join(webhooks.Events, ", "))
+				return
+			}
+			if !slices.Contains(events, e) {
+				events = append(events, e)
+			}
+		}
+		if req.ProjectID != "" {
+			owns, err := ownsProject(r.Context(), srv, user.ID, req.ProjectID)
+			if err != nil {
+				http.Error(w, "Failed to load builds: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if !owns {
+				http.Error(w, "You don't own this project", http.StatusForbidden)
+				return
+			}
+		}
+
+		h := CreatorWebhook{
+			ID:        newKioskID(),
+			UserID:    user.ID,
+			URL:       req.URL,
+			Events:    events,
+			ProjectID: req.ProjectID,
+			Secret:    webhooks.NewSecret(),
+			CreatedAt: time.Now().UTC(),
+		}
+		var state creatorWebhooksState
+		tooMany := false
+		err := srv.Store.Update(creatorWebhooksDoc, &state, func() error {
+			if state.Webhooks == nil {
+				state.Webhooks = map[string]CreatorWebhook{}
+			}
+			registered := 0
+			for _, existing := range state.Webhooks {
+				if existing.UserID == user.ID {
+					registered++
+				}
+			}
+			if registered >= maxCreatorWebhooks {
+				tooMany = true
+				return nil
+			}
+			state.Webhooks[h.ID] = h
+			return nil
+		})
+		if err != nil {
+			http.Error(w, "Failed to save webhook: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if tooMany {
+			http.Error(w, "Too many webhooks, delete one first", http.StatusConflict)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, http.StatusCreated, h)
+	}
+}
+
+// MyWebhooksHandler lists the caller's webhooks, newest first, with the
+// outcome of their last delivery.
+func MyWebhooksHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := requireUser(srv, w, r)
+		if !ok {
+			return
+		}
+
+		var state creatorWebhooksState
+		if err := srv.Store.Load(creatorWebhooksDoc, &state); err != nil {
+			http.Error(w, "Failed to load webhooks: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		mine := []CreatorWebhook{}
+		for _, h := range state.Webhooks {
+			if h.UserID == user.ID {
+				mine = append(mine, h.public())
+			}
+		}
+		sort.Slice(mine, func(i, j int) bool { return mine[i].CreatedAt.After(mine[j].CreatedAt) })
+
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, http.StatusOK, struct {
+			Events   []string         `json:"events"`
+			Webhooks []CreatorWebhook `json:"webhooks"`
+		}{webhooks.Events, mine})
+	}
+}
+
+// ownWebhook loads one of the caller's webhooks. On failure the error
+// response has already been written.
+func ownWebhook(srv *structs.Server, w http.ResponseWriter, r *http.Request) (CreatorWebhook, bool) {
+	user, ok := requireUser(srv, w, r)
+	if !ok {
+		return CreatorWebhook{}, false
+	}
+	var state creatorWebhooksState
+	if err := srv.Store.Load(creatorWebhooksDoc, &state); err != nil {
+		http.Error(w, "Failed to load webhooks: "+err.Error(), http.StatusInternalServerError)
+		return CreatorWebhook{}, false
+	}
+	h, ok := state.Webhooks[chi.URLParam(r, "webhookId")]
+	if !ok || h.UserID != user.ID {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return CreatorWebhook{}, false
+	}
+	return h, true
+}
+
+type webhookDeleted struct {
+	Ok bool   `json:"ok"`
+	ID string `json:"id"`
+}
+
+// DeleteWebhookHandler removes one of the caller's webhooks.
+func DeleteWebhookHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h, ok := ownWebhook(srv, w, r)
+		if !ok {
+			return
+		}
+		var state creatorWebhooksState
+		err := srv.Store.Update(creatorWebhooksDoc, &state, func() error {
+			if _, ok := state.Webhooks[h.ID]; !ok {
+				return errWebhookNotFound
+			}
+			delete(state.Webhooks, h.ID)
+			return nil
+		})
+		if errors.Is(err, errWebhookNotFound) {
+			http.Error(w, "Webhook not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to delete webhook: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, webhookDeleted{true, h.ID})
+	}
+}
+
+type webhookTestResponse struct {
+	Ok         bool   `json:"ok"`
+	DeliveryID string `json:"deliveryId"`
+}
+
+// TestWebhookHandler queues a ping to one of the caller's webhooks, even a
+// disabled one; its success re-enables the webhook. The outcome shows up as
+// the webhook's lastDelivery.
+func TestWebhookHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h, ok := ownWebhook(srv, w, r)
+		if !ok {
+			return
+		}
+		p := webhooks.Payload{
+			ID:        webhooks.NewID(),
+			Event:     webhooks.EventPing,
+			ProjectID: h.ProjectID,
+			At:        time.Now().UTC(),
+			Text:      "Shiba webhook test: this channel will get events about your games.",
+		}
+		sendWebhook(srv, h, p)
+
+		writeJSON(w, http.StatusAccepted, webhookTestResponse{true, p.ID})
+	}
+}
+
+const playtimeMilestonesDoc = "playtime-milestones"
+
+// Hours of players' playtime at which a project's owners are notified
+var playerHourMilestones = []int64{1, 10, 50, 100, 500, 1000}
+
+type playtimeMilestonesState struct {
+	// Reached maps project ID -> highest milestone hours notified
+	Reached map[string]int64 `json:"reached"`
+	// Baselined is set once the milestones projects had already reached were
+	// recorded without notifying anyone
+	Baselined bool `json:"baselined"`
+}
+
+// NotifyPlaytimeMilestones tells creators' webhooks about projects whose
+// total playtime crossed a milestone since the last call. Run it after each
+// stats flush.
+func NotifyPlaytimeMilestones(srv *structs.Server) {
+	totals, err := stats.Load(srv.Store)
+	if err != nil {
+		slog.Error("Failed to load stats for playtime milestones", "error", err)
+		return
+	}
+
+	type crossing struct {
+		projectID string
+		hours     int64
+		seconds   int64
+	}
+	var crossed []crossing
+	var state playtimeMilestonesState
+	err = srv.Store.Update(playtimeMilestonesDoc, &state, func() error {
+		crossed = nil
+		if state.Reached == nil {
+			state.Reached = map[string]int64{}
+		}
+		for projectID, g := range totals.Games {
+			reached := int64(0)
+			for _, m := range playerHourMilestones {
+				if g.PlaytimeSeconds >= m*3600 {
+					reached = m
+				}
+			}
+			if reached <= state.Reached[projectID] {
+				continue
+			}
+			state.Reached[projectID] = reached
+			if state.Baselined {
+				crossed = append(crossed, crossing{projectID, reached, g.PlaytimeSeconds})
+			}
+		}
+		state.Baselined = true
+		return nil
+	})
+	if err != nil {
+		slog.Error("Failed to save playtime milestones", "error", err)
+		return
+	}
+
+	ctx := context.Background()
+	for _, c := range crossed {
+		text := func(name string) string {
+			return fmt.Sprintf("Players have now spent %d hours in %s!", c.hours, name)
+		}
+		notifyCreators(ctx, srv, c.projectID, webhooks.EventPlaytimeMilestone, text,
+			struct {
+				Hours           int64 `json:"hours"`
+				PlaytimeSeconds int64 `json:"playtimeSeconds"`
+			}{c.hours, c.seconds})
+	}
+}
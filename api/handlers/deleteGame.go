@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"shiba-api/events"
+	"shiba-api/structs"
+	"shiba-api/sync"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// DeleteGameHandler takes a build down for good: its files are deleted from
+// R2 and the server, the games linking to it are unpublished and the
+// build is dropped from its project's versions. Requires the token of the
+// user who uploaded it, or the admin token.
+func DeleteGameHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		gameID := chi.URLParam(r, "gameId")
+
+		actor := "admin"
+		userID := ""
+		if !isAdmin(srv, r) {
+			user, ok := requireUser(srv, w, r)
+			if !ok {
+				return
+			}
+			actor, userID = user.ID, user.ID
+		}
+
+		state, err := loadBuilds(srv)
+		if err != nil {
+			http.Error(w, "Failed to load builds: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		build, ok := state.Builds[gameID]
+		if !ok {
+			http.Error(w, "Build not found", http.StatusNotFound)
+			return
+		}
+		if userID != "" && build.OwnerID != userID {
+			http.Error(w, "You don't own this build", http.StatusForbidden)
+			return
+		}
+		assigned, err := assignedBuilds(srv)
+		if err != nil {
+			http.Error(w, "Failed to load judging data: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if assigned[gameID] {
+			http.Error(w, "This build is assigned to judges; unassign it before deleting it", http.StatusConflict)
+			return
+		}
+
+		// Storage first: until the build record is gone a failed delete can
+		// simply be retried
+		objects, err := sync.DeleteGameFiles(r.Context(), *srv, gameID)
+		if err != nil {
+			http.Error(w, "Failed to delete game from R2: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+		if err := os.RemoveAll(filepath.Join("./games", gameID)); err != nil {
+			http.Error(w, "Failed to remove game: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		unpublished, err := srv.GameStore.Unpublish(r.Context(), gameID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		for _, id := range unpublished {
+			srv.SearchIndex.Remove(id)
+		}
+
+		var latest buildsState
+		wasCurrent := false
+		err = updateBuilds(srv, &latest, func() error {
+			latest.init()
+			current, _ := latest.currentBuild(build.ProjectID)
+			wasCurrent = current.ID == gameID
+			delete(latest.Builds, gameID)
+			if latest.Current[build.ProjectID] == gameID {
+				delete(latest.Current, build.ProjectID)
+			}
+			return nil
+		})
+		if err != nil {
+			http.Error(w, "Failed to delete build: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		emitEvent(r.Context(), srv, gameID, events.Deleted, actor, fmt.Sprintf("%d objects", objects))
+		if err := releaseStorage(srv, gameID); err != nil {
+			slog.ErrorContext(r.Context(), "Failed to release storage", "game_id", gameID, "error", err)
+		}
+		if wasCurrent {
+			notifyDevChannel(srv, &latest, build.ProjectID)
+		}
+
+		if unpublished == nil {
+			unpublished = []string{}
+		}
+		writeJSON(w, http.StatusOK, struct {
+			GameID      string   `json:"gameId"`
+			ProjectID   string   `json:"projectId"`
+			Objects     int      `json:"objects"`
+			Unpublished []string `json:"unpublished"`
+		}{gameID, build.ProjectID, objects, unpublished})
+	}
+}
@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"shiba-api/events"
+	"shiba-api/quota"
+	"shiba-api/structs"
+	"shiba-api/turnstile"
+
+	"github.com/google/uuid"
+)
+
+const (
+	demoUploadsDoc = "demo-uploads"
+	// Demo builds are playable for a day, then deleted
+	demoLifetime = 24 * time.Hour
+)
+
+// DemoUpload is a build uploaded without an account to try publishing. It's
+// served from disk only: never registered, listed or synced to R2.
+type DemoUpload struct {
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type demoUploadsState struct {
+	Demos map[string]DemoUpload `json:"demos"`
+}
+
+var errDemosFull = errors.New("too many demo uploads")
+
+// recordDemo keeps track of a demo build until it expires, unless
+// DEMO_MAX_ACTIVE of them are already live.
+func recordDemo(srv *structs.Server, gameID string, now time.Time) (DemoUpload, error) {
+	demo := DemoUpload{CreatedAt: now, ExpiresAt: now.Add(demoLifetime)}
+	var state demoUploadsState
+	err := srv.Store.Update(demoUploadsDoc, &state, func() error {
+		if state.Demos == nil {
+			state.Demos = map[string]DemoUpload{}
+		}
+		if len(state.Demos) >= srv.Config.Get().DemoMaxActive {
+			return errDemosFull
+		}
+		state.Demos[gameID] = demo
+		return nil
+	})
+	return demo, err
+}
+
+// demosFull reports whether DEMO_MAX_ACTIVE demos are live, checked before
+// anything is received so a full server turns uploads away cheaply.
+func demosFull(srv *structs.Server) (bool, error) {
+	var state demoUploadsState
+	if err := srv.Store.Load(demoUploadsDoc, &state); err != nil {
+		return false, err
+	}
+	return len(state.Demos) >= srv.Config.Get().DemoMaxActive, nil
+}
+
+// DemoUploadHandler publishes a web build without an account, for workshops
+// where people try uploading before they sign up. Demos are capped at
+// DEMO_MAX_SIZE_MB, need a solved Turnstile challenge, are limited per IP by
+// the demo_uploads quota and one at a time, and are deleted after 24 hours.
+func DemoUploadHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg := srv.Config.Get()
+		if !cfg.DemoUploads {
+			http.Error(w, "Demo uploads are disabled", http.StatusNotFound)
+			return
+		}
+		if cfg.TurnstileSecret == "" {
+			writeUploadError(w, r, newUploadError(http.StatusServiceUnavailable, "Demo uploads need TURNSTILE_SECRET_KEY to be set"))
+			return
+		}
+		uploadAttempts.Inc("demo")
+
+		// Counted by IP: a token doesn't buy anyone more demos
+		now := time.Now()
+		u, ok := srv.Quotas.Take(ipQuotaKey(r), quota.DemoUploads, cfg.QuotaLimits[quota.DemoUploads], now)
+		setRateLimitHeaders(w, u)
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(u.Reset.Sub(now).Seconds())+1))
+			writeUploadError(w, r, &uploadError{
+				status: http.StatusTooManyRequests,
+				msg:    "Daily demo upload quota exceeded, sign up to keep uploading",
+				code:   "quota_exceeded",
+			})
+			return
+		}
+		release, ok := srv.UploadSlots.Acquire(ipQuotaKey(r), 1)
+		if !ok {
+			w.Header().Set("Retry-After", "10")
+			writeUploadError(w, r, &uploadError{
+				status: http.StatusTooManyRequests,
+				msg:    "A demo upload is already in progress, wait for it to finish",
+				code:   "uploads_in_flight",
+			})
+			return
+		}
+		defer release()
+		admitted, releaseMemory, err := admitUpload(srv, r)
+		if err != nil {
+			w.Header().Set("Retry-After", "60")
+			writeUploadError(w, r, err)
+			return
+		}
+		defer releaseMemory()
+		r = admitted
+		if full, err := demosFull(srv); err != nil {
+			writeUploadError(w, r, newUploadError(http.StatusInternalServerError, "Failed to load demo uploads: "+err.Error()))
+			return
+		} else if full {
+			w.Header().Set("Retry-After", "3600")
+			writeUploadError(w, r, newUploadError(http.StatusServiceUnavailable, "Too many demo uploads right now, try again later"))
+			return
+		}
+
+		// The form fields come on top of the file, hence the extra megabyte
+		maxBytes := int64(cfg.DemoMaxSizeMB) << 20
+		r.Body = http.MaxBytesReader(w, r.Body, maxBytes+1<<20)
+
+		ctx := r.Context()
+		upload, err := receiveUpload(r)
+		if err != nil {
+			// receiveUpload reports the cut-off body as a broken upload; the
+			// reader keeps returning why it stopped
+			var tooLarge *http.MaxBytesError
+			if _, bodyErr := r.Body.Read(nil); errors.As(bodyErr, &tooLarge) {
+				err = newUploadError(http.StatusRequestEntityTooLarge, fmt.Sprintf("Demo uploads are limited to %d MB", cfg.DemoMaxSizeMB))
+			}
+			writeUploadError(w, r, err)
+			return
+		}
+		zipPath := upload.path
+		defer upload.remove()
+
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		err = turnstile.Verify(ctx, cfg.TurnstileSecret, r.FormValue(turnstile.FormField), host)
+		if errors.Is(err, turnstile.ErrRejected) {
+			writeUploadError(w, r, &uploadError{status: http.StatusForbidden, msg: "Solve the challenge to upload a demo", code: "turnstile_failed"})
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to verify Turnstile token", "error", err)
+			writeUploadError(w, r, newUploadError(http.StatusServiceUnavailable, "Failed to verify the challenge, try again in a few minutes"))
+			return
+		}
+
+		if len(upload.packs) > 0 {
+			writeUploadError(w, r, newUploadError(http.StatusBadRequest, "Demo uploads can't include data packs"))
+			return
+		}
+		if upload.size > maxBytes {
+			writeUploadError(w, r, newUploadError(http.StatusRequestEntityTooLarge, fmt.Sprintf("Demo uploads are limited to %d MB", cfg.DemoMaxSizeMB)))
+			return
+		}
+		if detectCartridge(upload.filename) != "" || detectNativeBuild(upload.filename, zipPath) != "" {
+			writeUploadError(w, r, newUploadError(http.StatusUnsupportedMediaType, "Demo uploads must be zipped web builds"))
+			return
+		}
+
+		id, err := uuid.NewV7()
+		if err != nil {
+			writeUploadError(w, r, newUploadError(http.StatusInternalServerError, "Failed to generate game id: "+err.Error()))
+			return
+		}
+		gameID := id.String()
+		appendEvent(ctx, srv, events.Event{GameID: gameID, Type: events.Received, Detail: "demo upload, " + receivedDetail(upload), Archive: profileUpload(upload)})
+		emitEvent(ctx, srv, gameID, events.Validated, "", "")
+
+		destDir := filepath.Join("./games", gameID)
+		err = extractGame(ctx, zipPath, nil, destDir, maxBytes, nil)
+		if err == nil {
+			err = checkExtractedContent(ctx, srv, destDir)
+		}
+		if err == nil {
+			err = checkPlayable(srv, destDir)
+		}
+		if err == nil {
+			err = applyBuildPermissions(srv, destDir)
+		}
+		if err == nil {
+			err = scanBuild(ctx, srv, gameID, "", destDir, uploadMeta{provenance: requestProvenance(r)})
+		}
+		if err != nil {
+			emitFailure(ctx, srv, gameID, "", err)
+			writeUploadError(w, r, err)
+			return
+		}
+
+		demo, err := recordDemo(srv, gameID, now)
+		if err != nil {
+			os.RemoveAll(destDir)
+			uerr := newUploadError(http.StatusInternalServerError, "Failed to record demo upload: "+err.Error())
+			if errors.Is(err, errDemosFull) {
+				uerr = newUploadError(http.StatusServiceUnavailable, "Too many demo uploads right now, try again later")
+			}
+			emitFailure(ctx, srv, gameID, "", uerr)
+			writeUploadError(w, r, uerr)
+			return
+		}
+		emitEvent(ctx, srv, gameID, events.Extracted, "", "demo upload")
+		slog.InfoContext(ctx, "Demo upload complete", "game_id", gameID, "expires_at", demo.ExpiresAt)
+
+		writeJSON(w, http.StatusOK, struct {
+			Ok        bool      `json:"ok"`
+			GameID    string    `json:"gameId"`
+			PlayURL   string    `json:"playUrl"`
+			ExpiresAt time.Time `json:"expiresAt"`
+		}{
+			Ok:        true,
+			GameID:    gameID,
+			PlayURL:   "/play/" + gameID + "/",
+			ExpiresAt: demo.ExpiresAt,
+		})
+	}
+}
+
+// ExpireDemos deletes the demo builds older than a day.
+func ExpireDemos(ctx context.Context, srv *structs.Server) (int, error) {
+	now := time.Now()
+	var expired []string
+	var state demoUploadsState
+	err := srv.Store.Update(demoUploadsDoc, &state, func() error {
+		for id, demo := range state.Demos {
+			if !now.Before(demo.ExpiresAt) {
+				expired = append(expired, id)
+				delete(state.Demos, id)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, id := range expired {
+		if err := os.RemoveAll(filepath.Join("./games", id)); err != nil {
+			slog.ErrorContext(ctx, "Failed to delete demo build", "game_id", id, "error", err)
+			continue
+		}
+		emitEvent(ctx, srv, id, events.Deleted, "", "demo expired")
+	}
+	return len(expired), nil
+}
@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"shiba-api/devchannel"
+	"shiba-api/structs"
+
+	"github.com/go-chi/chi/v5"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	// Proxies close connections idle for a minute or so
+	devPingInterval = 30 * time.Second
+	devWriteTimeout = 10 * time.Second
+)
+
+// devMessage describes the version a project serves, if it has one.
+func devMessage(state *buildsState, projectID, messageType string) (devchannel.Message, bool) {
+	current, ok := state.currentBuild(projectID)
+	if !ok {
+		return devchannel.Message{}, false
+	}
+	m := devchannel.Message{
+		Type:      messageType,
+		ProjectID: projectID,
+		GameID:    current.ID,
+		PlayURL:   buildURL(current),
+		At:        time.Now().UTC(),
+	}
+	for _, v := range projectVersions(state, projectID) {
+		if v.GameID == current.ID {
+			m.Version = v.Version
+		}
+	}
+	return m, true
+}
+
+// notifyDevChannel tells the open play sessions of a project which version
+// it now serves.
+func notifyDevChannel(srv *structs.Server, state *buildsState, projectID string) {
+	if m, ok := devMessage(state, projectID, "published"); ok {
+		srv.DevChannel.Publish(m)
+	}
+}
+
+// DevChannelHandler serves /projects/{projectId}/dev, a WebSocket sent the
+// version a project serves on connect and again whenever another version is
+// published or rolled back to. Play pages opened with ?dev listen on it and
+// offer to reload into the new version.
+func DevChannelHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		projectID := chi.URLParam(r, "projectId")
+		state, err := loadBuilds(srv)
+		if err != nil {
+			http.Error(w, "Failed to load builds: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		hello, ok := devMessage(&state, projectID, "current")
+		if !ok {
+			http.Error(w, "Project not found", http.StatusNotFound)
+			return
+		}
+
+		messages, unsubscribe, err := srv.DevChannel.Subscribe(projectID)
+		if err != nil {
+			w.Header().Set("Retry-After", "60")
+			http.Error(w, "Too many play sessions are listening to this project", http.StatusServiceUnavailable)
+			return
+		}
+		defer unsubscribe()
+
+		// Play pages are served from other origins too, e.g. the CDN, and
+		// what they learn here is public
+		server := websocket.Server{Handler: func(ws *websocket.Conn) {
+			send := func(v any) error {
+				ws.SetWriteDeadline(time.Now().Add(devWriteTimeout))
+				return websocket.JSON.Send(ws, v)
+			}
+
+			// Listeners never send anything; reading notices them leaving
+			closed := make(chan struct{})
+			go func() {
+				defer close(closed)
+				var discard string
+				for websocket.Message.Receive(ws, &discard) == nil {
+				}
+			}()
+
+			if send(hello) != nil {
+				return
+			}
+			ping := time.NewTicker(devPingInterval)
+			defer ping.Stop()
+			for {
+				var err error
+				select {
+				case m := <-messages:
+					err = send(m)
+				case <-ping.C:
+					err = send(map[string]string{"type": "ping"})
+				case <-closed:
+					return
+				}
+				if err != nil {
+					return
+				}
+			}
+		}}
+		server.ServeHTTP(w, r)
+	}
+}
+
+const devSnippet = `<script>(function () {
+if (!/[?&]dev(=|&|$)/.test(location.search) || !window.WebSocket) return;
+var gameId = %s, url = %s, prompted = false;
+function prompt(m) {
+  prompted = true;
+  var bar = document.createElement("div");
+  bar.setAttribute("style", "position:fixed;top:0;left:0;right:0;z-index:2147483647;padding:8px 12px;background:#4c1d95;color:#fff;font:14px sans-serif;text-align:center");
+  bar.textContent = "Version " + (m.version || "") + " is out. ";
+  var reload = document.createElement("button");
+  reload.textContent = "Reload";
+  reload.onclick = function () { location.href = m.playUrl + (m.playUrl.indexOf("?") < 0 ? "?" : "&") + "dev"; };
+  var later = document.createElement("button");
+  later.textContent = "Later";
+  later.style.marginLeft = "8px";
+  later.onclick = function () { bar.remove(); prompted = false; };
+  bar.appendChild(reload);
+  bar.appendChild(later);
+  document.body.appendChild(bar);
+}
+function connect() {
+  var ws = new WebSocket(url);
+  ws.onmessage = function (e) {
+    var m = JSON.parse(e.data);
+    if (m.gameId && m.gameId != gameId && !prompted) prompt(m);
+  };
+  ws.onclose = function () { setTimeout(connect, 5000); };
+}
+connect();
+})();</script>`
+
+// devChannelOrigin is the WebSocket origin dev channels are served on, which
+// game pages must be allowed to connect to.
+func devChannelOrigin(r *http.Request) string {
+	base := publicBaseURL(r)
+	if rest, ok := strings.CutPrefix(base, "https://"); ok {
+		return "wss://" + rest
+	}
+	return "ws://" + strings.TrimPrefix(base, "http://")
+}
+
+// devChannelURL is the WebSocket URL of a project's dev channel.
+func devChannelURL(r *http.Request, projectID string) string {
+	return devChannelOrigin(r) + "/projects/" + url.PathEscape(projectID) + "/dev"
+}
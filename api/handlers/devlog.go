@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"shiba-api/datastore"
+	"shiba-api/structs"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const milestonesDoc = "milestones"
+
+// Hackatime hours at which a project earns a devlog milestone entry
+var playtimeMilestones = []int{1, 5, 10, 25, 50, 100}
+
+type DevlogItem struct {
+	ID          string    `json:"id"`
+	Kind        string    `json:"kind"` // "version" or "milestone"
+	Title       string    `json:"title"`
+	Summary     string    `json:"summary,omitempty"`
+	ProjectID   string    `json:"projectId"`
+	URL         string    `json:"url,omitempty"`
+	PublishedAt time.Time `json:"publishedAt"`
+}
+
+type milestonesState struct {
+	// Reached maps project ID -> milestone hours -> when we first saw it
+	Reached map[string]map[string]time.Time `json:"reached"`
+}
+
+type projectInfo struct {
+	name         string
+	hackatimeSec float64
+}
+
+// lookupProject reads a project's name and tracked time from its game
+// record, or from the heartbeats Hackatime pushed when they're ahead.
+// Projects without a record just use their ID. Records come from srv.Games,
+// so they may be a few minutes old.
+func lookupProject(ctx context.Context, srv *structs.Server, projectID string) projectInfo {
+	info := projectInfo{name: projectID}
+	record, err := srv.Games.GameByID(ctx, srv.GameStore, projectID)
+	if err != nil {
+		if !errors.Is(err, datastore.ErrNotFound) {
+			slog.ErrorContext(ctx, "Failed to look up project", "project_id", projectID, "error", err)
+		}
+		return info
+	}
+	if name, ok := record.Fields["Name"].(string); ok && name != "" {
+		info.name = name
+	}
+	if secs, ok := record.Fields["HackatimeSeconds"].(float64); ok {
+		info.hackatimeSec = secs
+	}
+	// Heartbeats pushed since HackatimeSeconds was last polled may be ahead
+	slackIDs := recordList(record.Fields, "slack id")
+	if len(slackIDs) > 0 {
+		pushed, err := hackatimeSeconds(srv, slackIDs[0], recordList(record.Fields, "Hackatime Projects"))
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load pushed dev time", "project_id", projectID, "error", err)
+		}
+		info.hackatimeSec = max(info.hackatimeSec, pushed)
+	}
+	return info
+}
+
+// buildDevlog derives a creator's devlog from their uploads and the playtime
+// milestones their projects reached, newest first.
+func buildDevlog(ctx context.Context, srv *structs.Server, ownerID string) ([]DevlogItem, error) {
+	builds, err := loadBuilds(srv)
+	if err != nil {
+		return nil, err
+	}
+
+	items := []DevlogItem{}
+	versions := map[string]int{}
+	owned := []Build{}
+	for _, b := range builds.Builds {
+		if b.OwnerID == ownerID {
+			owned = append(owned, b)
+		}
+	}
+	sort.Slice(owned, func(i, j int) bool {
+		return owned[i].CreatedAt.Before(owned[j].CreatedAt)
+	})
+
+	projects := map[string]projectInfo{}
+	for _, b := range owned {
+		if _, ok := projects[b.ProjectID]; !ok {
+			projects[b.ProjectID] = lookupProject(ctx, srv, b.ProjectID)
+		}
+		versions[b.ProjectID]++
+		items = append(items, DevlogItem{
+			ID:          "version-" + b.ID,
+			Kind:        "version",
+			Title:       fmt.Sprintf("Shipped version %d of %s", versions[b.ProjectID], projects[b.ProjectID].name),
+			Summary:     b.Changelog,
+			ProjectID:   b.ProjectID,
+			URL:         "/play/" + b.ID + "/",
+			PublishedAt: b.CreatedAt,
+		})
+	}
+
+	var milestones milestonesState
+	if err := srv.Store.Load(milestonesDoc, &milestones); err != nil {
+		return nil, err
+	}
+
+	for projectID, info := range projects {
+		for key, at := range milestones.Reached[projectID] {
+			items = append(items, DevlogItem{
+				ID:          "milestone-" + projectID + "-" + key,
+				Kind:        "milestone",
+				Title:       fmt.Sprintf("Spent %s hours on %s", key, info.name),
+				ProjectID:   projectID,
+				PublishedAt: at,
+			})
+		}
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		if !items[i].PublishedAt.Equal(items[j].PublishedAt) {
+			return items[i].PublishedAt.After(items[j].PublishedAt)
+		}
+		return items[i].ID < items[j].ID
+	})
+	return items, nil
+}
+
+// RecordMilestones notes the playtime milestones projects with builds have
+// reached since the last run, so devlogs can show when each was reached
+// without writing anything themselves.
+func RecordMilestones(ctx context.Context, srv *structs.Server) (int, error) {
+	builds, err := loadBuilds(srv)
+	if err != nil {
+		return 0, err
+	}
+	hours := map[string]float64{}
+	for _, b := range builds.Builds {
+		if _, ok := hours[b.ProjectID]; !ok {
+			hours[b.ProjectID] = lookupProject(ctx, srv, b.ProjectID).hackatimeSec / 3600
+		}
+	}
+
+	var milestones milestonesState
+	recorded := 0
+	now := time.Now().UTC()
+	err = srv.Store.Update(milestonesDoc, &milestones, func() error {
+		if milestones.Reached == nil {
+			milestones.Reached = map[string]map[string]time.Time{}
+		}
+		for projectID, h := range hours {
+			for _, m := range playtimeMilestones {
+				if h < float64(m) {
+					break
+				}
+				key := strconv.Itoa(m)
+				if milestones.Reached[projectID] == nil {
+					milestones.Reached[projectID] = map[string]time.Time{}
+				}
+				if _, ok := milestones.Reached[projectID][key]; !ok {
+					milestones.Reached[projectID][key] = now
+					recorded++
+				}
+			}
+		}
+		return nil
+	})
+	return recorded, err
+}
+
+// DevlogHandler serves a creator's devlog feed.
+func DevlogHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := chi.URLParam(r, "userId")
+
+		query := struct {
+			Limit int `query:"limit" validate:"min=1,max=200"`
+		}{Limit: 50}
+		if !bindQuery(w, r, &query) {
+			return
+		}
+		limit := query.Limit
+
+		items, err := buildDevlog(r.Context(), srv, userID)
+		if err != nil {
+			http.Error(w, "Failed to build devlog: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if len(items) > limit {
+			items = items[:limit]
+		}
+
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		writeJSON(w, http.StatusOK, struct {
+			UserID string       `json:"userId"`
+			Items  []DevlogItem `json:"items"`
+		}{userID, items})
+	}
+}
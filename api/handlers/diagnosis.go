@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"archive/zip"
+	"net/http"
+	"strings"
+
+	"shiba-api/diagnose"
+	"shiba-api/events"
+	"shiba-api/structs"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// profileUpload summarizes the files of a zipped web build and its data
+// packs at the paths they're extracted to, for its received event. It's nil
+// if an archive can't be read, which fails the upload anyway.
+func profileUpload(upload receivedUpload) *events.Archive {
+	var files []diagnose.File
+	for i, archive := range append([]string{upload.path}, upload.packs...) {
+		zr, err := zip.OpenReader(archive)
+		if err != nil {
+			return nil
+		}
+		// Only the upload's single root folder is flattened, not a pack's
+		rootPrefix := ""
+		if i == 0 {
+			rootPrefix = getSingleRootPrefix(zr.File)
+		}
+		for _, f := range zr.File {
+			if strings.HasPrefix(f.Name, "__MACOSX/") || f.FileInfo().IsDir() {
+				continue
+			}
+			files = append(files, diagnose.File{
+				Path: strings.TrimPrefix(f.Name, rootPrefix),
+				Size: int64(f.UncompressedSize64),
+			})
+		}
+		zr.Close()
+	}
+	return diagnose.Profile(files)
+}
+
+// UploadDiagnosisHandler guesses from an upload's events why it failed, or
+// why its build may not load, and how to fix it. Uploads made with a user
+// token are only diagnosed for that user or the admin, anonymous ones for
+// anyone with their ID.
+func UploadDiagnosisHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		uploadID := chi.URLParam(r, "uploadId")
+		if srv.Events == nil {
+			http.Error(w, "Upload not found", http.StatusNotFound)
+			return
+		}
+		history, err := srv.Events.ForGame(uploadID)
+		if err != nil {
+			http.Error(w, "Failed to read event log: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		var uploader string
+		found := false
+		for _, e := range history {
+			if e.Type == events.Received {
+				uploader, found = e.Actor, true
+				break
+			}
+		}
+		if !found {
+			http.Error(w, "Upload not found", http.StatusNotFound)
+			return
+		}
+		if uploader != "" && !isAdmin(srv, r) {
+			user, ok := requireUser(srv, w, r)
+			if !ok {
+				return
+			}
+			if user.ID != uploader {
+				http.Error(w, "Upload not found", http.StatusNotFound)
+				return
+			}
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, http.StatusOK, struct {
+			UploadID string `json:"uploadId"`
+			diagnose.Diagnosis
+		}{uploadID, diagnose.Diagnose(history)})
+	}
+}
@@ -0,0 +1,15 @@
+//go:build linux || darwin || freebsd
+
+package handlers
+
+import "syscall"
+
+// freeDiskBytes returns the space available to the server on the filesystem
+// holding dir.
+func freeDiskBytes(dir string) (int64, error) {
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(dir, &fs); err != nil {
+		return 0, err
+	}
+	return int64(fs.Bavail) * int64(fs.Bsize), nil
+}
@@ -0,0 +1,9 @@
+//go:build !(linux || darwin || freebsd)
+
+package handlers
+
+import "errors"
+
+func freeDiskBytes(dir string) (int64, error) {
+	return 0, errors.ErrUnsupported
+}
@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"time"
+
+	"shiba-api/compliance"
+	"shiba-api/stats"
+	"shiba-api/structs"
+
+	"github.com/mehanizm/airtable"
+)
+
+// checkEligibility applies the configured age and region rules to user.
+func checkEligibility(srv *structs.Server, user *airtable.Record, action string) *compliance.Denial {
+	return compliance.Check(compliance.ParseProfile(user.Fields), srv.Config.Get().Eligibility, action, time.Now())
+}
+
+// checkSubmissionEligibility rejects uploads entered for prizes from users
+// who can't take part in judging. Other uploads aren't checked; prize
+// submissions need a user to check.
+func checkSubmissionEligibility(srv *structs.Server, user *airtable.Record, meta uploadMeta) error {
+	if !meta.prize {
+		return nil
+	}
+	if user == nil {
+		return &uploadError{status: http.StatusUnauthorized, code: "unauthorized", msg: "Prize submissions need a user token"}
+	}
+	if d := checkEligibility(srv, user, compliance.PrizeSubmission); d != nil {
+		return &uploadError{status: http.StatusForbidden, msg: d.Message, code: d.Code}
+	}
+	return nil
+}
+
+type eligibilityResult struct {
+	Eligible bool   `json:"eligible"`
+	Code     string `json:"code,omitempty"`
+	Message  string `json:"message,omitempty"`
+}
+
+const playtestsDoc = "playtests"
+
+type playtestsState struct {
+	// Players maps user ID -> project ID -> seconds played
+	Players map[string]map[string]int64 `json:"players"`
+}
+
+// recordPlaytest adds seconds of userID playing projectID, towards the
+// playtests they gave.
+func recordPlaytest(srv *structs.Server, userID, projectID string, seconds int64) error {
+	if userID == "" || projectID == "" || seconds <= 0 {
+		return nil
+	}
+	var state playtestsState
+	return srv.Store.Update(playtestsDoc, &state, func() error {
+		if state.Players == nil {
+			state.Players = map[string]map[string]int64{}
+		}
+		if state.Players[userID] == nil {
+			state.Players[userID] = map[string]int64{}
+		}
+		state.Players[userID][projectID] += seconds
+		return nil
+	})
+}
+
+// Prize criteria
+const (
+	CriterionProfile  = "profile"
+	CriterionHours    = "hackatime_hours"
+	CriterionPlaytest = "playtests_given"
+	CriterionShipped  = "game_shipped"
+	CriterionFeedback = "feedback_received"
+)
+
+// Criterion is one prize requirement and how far the user is with it.
+type Criterion struct {
+	ID       string  `json:"id"`
+	Met      bool    `json:"met"`
+	Current  float64 `json:"current"`
+	Required float64 `json:"required"`
+	Code     string  `json:"code,omitempty"`
+	Message  string  `json:"message,omitempty"`
+}
+
+type prizeEligibility struct {
+	Eligible bool        `json:"eligible"`
+	Criteria []Criterion `json:"criteria"`
+}
+
+// checkPrizeEligibility applies the event's prize rules to user: the age and
+// region rules, Hackatime hours logged on their projects, playtests given,
+// a build shipped during the current event and feedback received.
+func checkPrizeEligibility(ctx context.Context, srv *structs.Server, user *airtable.Record) (prizeEligibility, error) {
+	cfg := srv.Config.Get()
+	builds, err := loadBuilds(srv)
+	if err != nil {
+		return prizeEligibility{}, err
+	}
+	projects := map[string]bool{}
+	shipped := 0
+	for _, b := range builds.Builds {
+		if b.OwnerID != user.ID || b.ProjectID == "" {
+			continue
+		}
+		projects[b.ProjectID] = true
+		if !b.Draft && buildEvent(b) == cfg.EventID {
+			shipped++
+		}
+	}
+
+	state, err := stats.Load(srv.Store)
+	if err != nil {
+		return prizeEligibility{}, err
+	}
+	var playtests playtestsState
+	if err := srv.Store.Load(playtestsDoc, &playtests); err != nil {
+		return prizeEligibility{}, err
+	}
+
+	var hackatimeSec float64
+	feedback := 0
+	for projectID := range projects {
+		hackatimeSec += lookupProject(ctx, srv, projectID).hackatimeSec
+		feedback += state.Games[projectID].Feedback
+	}
+	given := 0
+	for projectID, seconds := range playtests.Players[user.ID] {
+		if !projects[projectID] && seconds >= int64(cfg.PrizePlaytestMinutes)*60 {
+			given++
+		}
+	}
+
+	profile := Criterion{ID: CriterionProfile, Met: true, Current: 1, Required: 1}
+	if d := checkEligibility(srv, user, compliance.PrizeSubmission); d != nil {
+		profile = Criterion{ID: CriterionProfile, Required: 1, Code: d.Code, Message: d.Message}
+	}
+	count := func(id string, current float64, required int) Criterion {
+		return Criterion{ID: id, Met: current >= float64(required), Current: current, Required: float64(required)}
+	}
+	res := prizeEligibility{Eligible: true, Criteria: []Criterion{
+		profile,
+		count(CriterionHours, math.Floor(hackatimeSec/360)/10, cfg.PrizeMinHours),
+		count(CriterionPlaytest, float64(given), cfg.PrizeMinPlaytests),
+		count(CriterionShipped, float64(shipped), 1),
+		count(CriterionFeedback, float64(feedback), cfg.PrizeMinFeedback),
+	}}
+	for _, c := range res.Criteria {
+		res.Eligible = res.Eligible && c.Met
+	}
+	return res, nil
+}
+
+// MyEligibilityHandler tells the frontend which gated actions the calling
+// user may take, so it can explain a denial before they try, and how far
+// they are with each prize requirement.
+func MyEligibilityHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := requireUser(srv, w, r)
+		if !ok {
+			return
+		}
+
+		results := map[string]any{}
+		for _, action := range []string{compliance.ShopOrder, compliance.PrizeSubmission} {
+			res := eligibilityResult{Eligible: true}
+			if d := checkEligibility(srv, user, action); d != nil {
+				res = eligibilityResult{Code: d.Code, Message: d.Message}
+			}
+			results[action] = res
+		}
+		prize, err := checkPrizeEligibility(r.Context(), srv, user)
+		if err != nil {
+			http.Error(w, "Failed to check prize eligibility: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		results["prize"] = prize
+
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, http.StatusOK, results)
+	}
+}
@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"shiba-api/faults"
+	"shiba-api/schema"
+	"shiba-api/structs"
+)
+
+// InjectFaults reads the faults a request asks for from X-Shiba-Faults into
+// its context. Outside staging (FAULT_INJECTION_ENABLED unset) the header is
+// ignored.
+func InjectFaults(srv *structs.Server) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := r.Header.Get(faults.Header)
+			if header == "" || !srv.Config.Get().FaultInjection {
+				next.ServeHTTP(w, r)
+				return
+			}
+			set, err := faults.Parse(header)
+			if err != nil {
+				invalidField(w, faults.Header, schema.InHeader, "%v, known faults are %s", err, strings.Join(faults.Names(), ", "))
+				return
+			}
+			slog.InfoContext(r.Context(), "Injecting faults", "faults", set.String(), "method", r.Method, "path", r.URL.Path)
+			next.ServeHTTP(w, r.WithContext(faults.With(r.Context(), set)))
+		})
+	}
+}
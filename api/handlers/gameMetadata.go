@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"shiba-api/buildscan"
+	"shiba-api/gamemeta"
+	"shiba-api/schema"
+	"shiba-api/search"
+	"shiba-api/structs"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// detectInputs scans a new web build for the input APIs it uses and flags
+// its project when it looks playable only with a controller, or playable on
+// phones. This is a static scan of the build's scripts, or of the input map
+// and scripts in a Godot build's .pck; Unity builds are never flagged. It
+// reads the whole build, so it runs after the upload has been answered, and
+// a build that is no longer its project's current one by then is skipped.
+func detectInputs(ctx context.Context, srv *structs.Server, build Build, dir string) {
+	report, err := buildscan.Scan(dir)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to scan build", "game_id", build.ID, "error", err)
+		return
+	}
+	state, err := loadBuilds(srv)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load builds", "game_id", build.ID, "error", err)
+		return
+	}
+	if current, ok := state.currentBuild(build.ProjectID); !ok || current.ID != build.ID {
+		return
+	}
+
+	expects, mobile := report.ExpectsController(), report.MobileCompatible()
+	_, err = gamemeta.Update(srv.Store, build.ProjectID, func(m *gamemeta.Metadata) {
+		m.ExpectsController = expects
+		m.MobileCompatible = mobile
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to save metadata", "project_id", build.ProjectID, "error", err)
+		return
+	}
+	srv.SearchIndex.Patch(build.ProjectID, func(d *search.Document) {
+		d.ExpectsController = expects
+		d.MobileCompatible = mobile
+	})
+}
+
+// GameMetadataHandler returns everything known about a project beyond its
+// Airtable record: declared accessibility features and detected flags.
+func GameMetadataHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		meta, err := gamemeta.Get(srv.Store, chi.URLParam(r, "projectId"))
+		if err != nil {
+			http.Error(w, "Failed to load metadata: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, meta)
+	}
+}
+
+// linkGameMeta creates the metadata record of a new build's project, linked
+// to its uploader, or updates the engine of an existing one.
+func linkGameMeta(ctx context.Context, srv *structs.Server, build Build) {
+	_, err := gamemeta.Update(srv.Store, build.ProjectID, func(m *gamemeta.Metadata) {
+		if m.OwnerID == "" {
+			m.OwnerID = build.OwnerID
+		}
+		if build.Engine != "" {
+			m.Engine = build.Engine
+		}
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to save game metadata", "project_id", build.ProjectID, "error", err)
+	}
+}
+
+// metaProjectID resolves the ID of /games/{gameId}/meta, a project or any of
+// its builds, to the project. ok is false when neither exists.
+func metaProjectID(srv *structs.Server, id string) (projectID string, ok bool, err error) {
+	state, err := loadBuilds(srv)
+	if err != nil {
+		return "", false, err
+	}
+	if b, found := state.Builds[id]; found {
+		return b.ProjectID, true, nil
+	}
+	return id, len(state.projectBuilds(id)) > 0, nil
+}
+
+// gameProject resolves {gameId} to its project, answering 404 for
+// unknown games. On failure the error response has already been written.
+func gameProject(srv *structs.Server, w http.ResponseWriter, r *http.Request) (string, bool) {
+	projectID, ok, err := metaProjectID(srv, chi.URLParam(r, "gameId"))
+	if err != nil {
+		http.Error(w, "Failed to load builds: "+err.Error(), http.StatusInternalServerError)
+		return "", false
+	}
+	if !ok || projectID == "" {
+		http.Error(w, "Game not found", http.StatusNotFound)
+		return "", false
+	}
+	return projectID, true
+}
+
+// GameMeta is the part of a game's metadata its owner edits.
+type GameMeta struct {
+	ProjectID   string   `json:"projectId"`
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	Tags        []string `json:"tags"`
+	Engine      string   `json:"engine"`
+	OwnerID     string   `json:"ownerId,omitempty"`
+}
+
+func gameMeta(projectID string, m gamemeta.Metadata) GameMeta {
+	tags := m.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	return GameMeta{
+		ProjectID:   projectID,
+		Title:       m.Title,
+		Description: m.Description,
+		Tags:        tags,
+		Engine:      m.Engine,
+		OwnerID:     m.OwnerID,
+	}
+}
+
+// GetGameMetaHandler returns a game's title, description, tags and engine.
+// The game is given by its project ID or the ID of any of its builds.
+func GetGameMetaHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		projectID, ok, err := metaProjectID(srv, chi.URLParam(r, "gameId"))
+		if err != nil {
+			http.Error(w, "Failed to load builds: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, "Game not found", http.StatusNotFound)
+			return
+		}
+		meta, err := gamemeta.Get(srv.Store, projectID)
+		if err != nil {
+			http.Error(w, "Failed to load metadata: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, gameMeta(projectID, meta))
+	}
+}
+
+const maxGameTagLen = 32
+
+// UpdateGameMetaHandler replaces a game's title, description, tags and
+// engine. Tags are lowercased and deduplicated. Requires the project owner's
+// token or the admin token.
+func UpdateGameMetaHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		projectID, ok, err := metaProjectID(srv, chi.URLParam(r, "gameId"))
+		if err != nil {
+			http.Error(w, "Failed to load builds: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, "Game not found", http.StatusNotFound)
+			return
+		}
+		if !requireProjectOwner(srv, w, r, projectID) {
+			return
+		}
+
+		var req struct {
+			Title       string   `json:"title" validate:"max=100"`
+			Description string   `json:"description" validate:"max=5000"`
+			Tags        []string `json:"tags" validate:"max=10"`
+			Engine      string   `json:"engine" validate:"max=32"`
+		}
+		if !bindJSON(w, r, &req) {
+			return
+		}
+		tags := []string{}
+		for _, t := range req.Tags {
+			t = strings.ToLower(strings.TrimSpace(t))
+			if len(t) > maxGameTagLen {
+				invalidField(w, "tags", schema.InBody, "tags must be at most %d characters", maxGameTagLen)
+				return
+			}
+			if t != "" && !slices.Contains(tags, t) {
+				tags = append(tags, t)
+			}
+		}
+
+		meta, err := gamemeta.Update(srv.Store, projectID, func(m *gamemeta.Metadata) {
+			m.Title = strings.TrimSpace(req.Title)
+			m.Description = strings.TrimSpace(req.Description)
+			m.Tags = tags
+			m.Engine = strings.ToLower(strings.TrimSpace(req.Engine))
+		})
+		if err != nil {
+			http.Error(w, "Failed to save metadata: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		srv.SearchIndex.Patch(projectID, func(d *search.Document) {
+			if meta.Title != "" {
+				d.Title = meta.Title
+			}
+			if meta.Description != "" {
+				d.Description = meta.Description
+			}
+			if len(meta.Tags) > 0 {
+				d.Tags = meta.Tags
+			}
+		})
+
+		writeJSON(w, http.StatusOK, gameMeta(projectID, meta))
+	}
+}
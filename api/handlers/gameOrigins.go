@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"shiba-api/playpolicy"
+	"shiba-api/schema"
+	"shiba-api/structs"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const gameOriginsDoc = "game-origins"
+
+// GameOrigins are the external origins a game is allowed to talk to.
+type GameOrigins struct {
+	// ConnectOrigins can be reached with fetch, XHR and WebSockets
+	ConnectOrigins []string `json:"connectOrigins" validate:"max=20"`
+	// MessageOrigins may embed the game and exchange postMessage with it
+	MessageOrigins []string `json:"messageOrigins" validate:"max=20"`
+}
+
+type gameOriginsState struct {
+	// Projects maps project ID -> origins
+	Projects map[string]GameOrigins `json:"projects"`
+}
+
+// validateOrigin accepts scheme://host[:port] with an optional leading *.
+// wildcard on the host, the forms CSP understands.
+func validateOrigin(origin string, schemes ...string) error {
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid origin %q", origin)
+	}
+	if u.Path != "" || u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return fmt.Errorf("origin %q must not have a path, query or credentials", origin)
+	}
+	host := strings.TrimPrefix(u.Hostname(), "*.")
+	if host == "" || strings.ContainsAny(host, "*;' ") {
+		return fmt.Errorf("invalid host in origin %q", origin)
+	}
+	for _, s := range schemes {
+		if u.Scheme == s {
+			return nil
+		}
+	}
+	return fmt.Errorf("origin %q must use %s", origin, strings.Join(schemes, " or "))
+}
+
+// siteOrigins are the Shiba frontends that always may embed games.
+func siteOrigins() []string {
+	var origins []string
+	for _, o := range strings.Split(os.Getenv("SITE_ORIGINS"), ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			origins = append(origins, o)
+		}
+	}
+	return origins
+}
+
+func loadGameOrigins(srv *structs.Server, projectID string) (GameOrigins, error) {
+	var state gameOriginsState
+	err := srv.Store.Load(gameOriginsDoc, &state)
+	return state.Projects[projectID], err
+}
+
+// gameCSP builds the Content-Security-Policy for a build from its project's
+// origin allowlist: network access and embedding are limited to what the
+// creator declared, the dev channel and the site, see playpolicy.CSP.
+func gameCSP(srv *structs.Server, r *http.Request, gameID string) string {
+	origins := GameOrigins{}
+	if build, ok, err := lookupBuild(srv, gameID); err == nil && ok {
+		origins, _ = loadGameOrigins(srv, build.ProjectID)
+	}
+
+	connect := append([]string{devChannelOrigin(r)}, origins.ConnectOrigins...)
+	ancestors := append(siteOrigins(), origins.MessageOrigins...)
+	return playpolicy.CSP(connect, ancestors)
+}
+
+// GameOriginsHandler returns a project's origin allowlist.
+func GameOriginsHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		origins, err := loadGameOrigins(srv, chi.URLParam(r, "projectId"))
+		if err != nil {
+			http.Error(w, "Failed to load origins: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if origins.ConnectOrigins == nil {
+			origins.ConnectOrigins = []string{}
+		}
+		if origins.MessageOrigins == nil {
+			origins.MessageOrigins = []string{}
+		}
+		writeJSON(w, http.StatusOK, origins)
+	}
+}
+
+// ownsProject reports whether userID uploaded any build of projectID, or is
+// the Owner of its Airtable Games record.
+func ownsProject(ctx context.Context, srv *structs.Server, userID, projectID string) (bool, error) {
+	builds, err := loadBuilds(srv)
+	if err != nil {
+		return false, err
+	}
+	for _, b := range builds.projectBuilds(projectID) {
+		if b.OwnerID == userID {
+			return true, nil
+		}
+	}
+
+	record, err := srv.GameStore.GameByID(ctx, projectID)
+	if err != nil {
+		return false, nil
+	}
+	owners, _ := record.Fields["Owner"].([]any)
+	for _, o := range owners {
+		if o == userID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// requireProjectOwner lets admins and owners of projectID through. On
+// failure the error response has already been written.
+func requireProjectOwner(srv *structs.Server, w http.ResponseWriter, r *http.Request, projectID string) bool {
+	if isAdmin(srv, r) {
+		return true
+	}
+	user, ok := requireUser(srv, w, r)
+	if !ok {
+		return false
+	}
+	owns, err := ownsProject(r.Context(), srv, user.ID, projectID)
+	if err != nil {
+		http.Error(w, "Failed to load builds: "+err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	if !owns {
+		http.Error(w, "You don't own this project", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// UpdateGameOriginsHandler replaces a project's origin allowlist. Only owners
+// of the project's builds and admins can change it.
+func UpdateGameOriginsHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		projectID := chi.URLParam(r, "projectId")
+		if !requireProjectOwner(srv, w, r, projectID) {
+			return
+		}
+
+		var req GameOrigins
+		if !bindJSON(w, r, &req) {
+			return
+		}
+		var errs schema.Errors
+		for i, o := range req.ConnectOrigins {
+			if err := validateOrigin(o, "https", "wss"); err != nil {
+				errs.Add(fmt.Sprintf("connectOrigins[%d]", i), schema.InBody, "%v", err)
+			}
+		}
+		for i, o := range req.MessageOrigins {
+			if err := validateOrigin(o, "https"); err != nil {
+				errs.Add(fmt.Sprintf("messageOrigins[%d]", i), schema.InBody, "%v", err)
+			}
+		}
+		if len(errs) > 0 {
+			writeInvalid(w, errs)
+			return
+		}
+
+		var state gameOriginsState
+		err := srv.Store.Update(gameOriginsDoc, &state, func() error {
+			if state.Projects == nil {
+				state.Projects = map[string]GameOrigins{}
+			}
+			state.Projects[projectID] = req
+			return nil
+		})
+		if err != nil {
+			http.Error(w, "Failed to save origins: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, struct {
+			Ok bool `json:"ok"`
+		}{true})
+	}
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"shiba-api/schema"
+	"shiba-api/stats"
+	"shiba-api/structs"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// GameStatsHandler returns a game's totals and its hourly or daily series,
+// read from the rollups kept by the stats aggregator. Only the game's owners
+// and admins can see them.
+func GameStatsHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		gameID := chi.URLParam(r, "gameId")
+		if !requireProjectOwner(srv, w, r, gameID) {
+			return
+		}
+
+		query := struct {
+			Granularity string `query:"granularity" validate:"oneof=hour day"`
+			Days        *int   `query:"days" validate:"min=1,max=90"`
+		}{Granularity: "day"}
+		if !bindQuery(w, r, &query) {
+			return
+		}
+		granularity := query.Granularity
+		days := 30
+		if granularity == "hour" {
+			days = 7
+		}
+		if query.Days != nil {
+			if granularity == "hour" && *query.Days > 7 {
+				invalidField(w, "days", schema.InQuery, "must be at most 7 for hourly stats")
+				return
+			}
+			days = *query.Days
+		}
+
+		state, err := stats.Load(srv.Store)
+		if err != nil {
+			http.Error(w, "Failed to load stats: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		now := time.Now()
+		series, err := stats.Series(srv.Store, gameID, granularity, now.AddDate(0, 0, -days), now)
+		if err != nil {
+			http.Error(w, "Failed to load stats: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "private, max-age=60")
+		writeJSON(w, http.StatusOK, struct {
+			GameID      string        `json:"gameId"`
+			Totals      stats.Game    `json:"totals"`
+			Granularity string        `json:"granularity"`
+			Series      []stats.Point `json:"series"`
+		}{gameID, state.Games[gameID], granularity, series})
+	}
+}
@@ -2,20 +2,89 @@ package handlers
 
 import (
 	"archive/zip"
-	"encoding/json"
+	"context"
+	"errors"
+	"fmt"
 	"io"
-	"log"
+	"io/fs"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
+	"time"
 
+	"shiba-api/events"
+	"shiba-api/faults"
+	"shiba-api/logging"
+	"shiba-api/membudget"
+	"shiba-api/quota"
+	"shiba-api/schema"
+	"shiba-api/stats"
 	"shiba-api/structs"
-	"shiba-api/sync"
+	"shiba-api/trace"
+	"shiba-api/validate"
 
 	"github.com/google/uuid"
+	"github.com/mehanizm/airtable"
 )
 
+// uploadError carries the HTTP status an upload step failed with. Errors
+// with a code are sent as JSON so the frontend can render its own message.
+type uploadError struct {
+	status int
+	msg    string
+	code   string
+	// findings is the rejection report of a build that failed validation
+	findings []validate.Finding
+	// fields lists the request fields that failed validation
+	fields schema.Errors
+}
+
+func (e *uploadError) Error() string { return e.msg }
+
+func newUploadError(status int, msg string) *uploadError {
+	return &uploadError{status: status, msg: msg}
+}
+
+// uploadErrorBody is the JSON of upload errors with a code.
+type uploadErrorBody struct {
+	Code      string             `json:"code"`
+	Message   string             `json:"message"`
+	Findings  []validate.Finding `json:"findings,omitempty"`
+	Fields    schema.Errors      `json:"fields,omitempty"`
+	RequestID string             `json:"requestId,omitempty"`
+	TraceID   string             `json:"traceId,omitempty"`
+}
+
+// writeUploadError answers a failed upload. The response carries the request
+// and trace IDs, so a screenshot of the error is enough to find its logs.
+func writeUploadError(w http.ResponseWriter, r *http.Request, err error) {
+	requestID, traceID := logging.RequestID(r.Context()), trace.ID(r.Context())
+	var ue *uploadError
+	if errors.As(err, &ue) {
+		if ue.code != "" {
+			writeJSON(w, ue.status, uploadErrorBody{ue.code, ue.msg, ue.findings, ue.fields, requestID, traceID})
+			return
+		}
+		http.Error(w, withIDs(ue.msg, requestID, traceID), ue.status)
+		return
+	}
+	http.Error(w, withIDs(err.Error(), requestID, traceID), http.StatusInternalServerError)
+}
+
+func withIDs(msg, requestID, traceID string) string {
+	if requestID != "" {
+		msg += "\nRequest ID: " + requestID
+	}
+	if traceID != "" {
+		msg += "\nTrace ID: " + traceID
+	}
+	return msg
+}
+
 func validateZipFilePath(filePath, destDir string) bool {
 	cleanPath := filepath.Clean(filePath)
 
@@ -32,142 +101,812 @@ func validateZipFilePath(filePath, destDir string) bool {
 	return strings.HasPrefix(absFilePath, absDestDir+string(os.PathSeparator))
 }
 
-func GameUploadHandler(srv *structs.Server) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
+// uploadMeta holds the optional form fields sent alongside a build.
+type uploadMeta struct {
+	projectID     string
+	changelog     string
+	engine        string
+	engineVersion string
+	// Set by the handler for downloadable (native) builds
+	listingType    string
+	artifactSHA256 string
+	// draft builds are uploaded for preview, not published
+	draft bool
+	// prize builds are entered for prizes, so their uploader must be eligible
+	prize bool
+	// Set after post-processing hooks ran
+	hooks []string
+	// Set when the build's paths were lowercased: KeyCaseLower, and the
+	// original path of every renamed file by new path
+	keyCase       string
+	originalPaths map[string]string
+	// Who uploaded the build and with what client
+	provenance Provenance
+}
+
+// uploadForm is the schema of the form fields of an upload. The form must be
+// parsed already.
+type uploadForm struct {
+	ProjectID     string `form:"projectId" validate:"max=64,id"`
+	Changelog     string `form:"changelog" validate:"max=10000"`
+	Engine        string `form:"engine" validate:"max=32"`
+	EngineVersion string `form:"engineVersion" validate:"max=32"`
+	Draft         bool   `form:"draft"`
+	Prize         bool   `form:"prize"`
+}
+
+func parseUploadMeta(r *http.Request) (uploadMeta, error) {
+	var form uploadForm
+	if errs := schema.Form(r.Form, &form); len(errs) > 0 {
+		return uploadMeta{}, &uploadError{
+			status: http.StatusUnprocessableEntity,
+			msg:    "Request failed validation: " + errs.Error(),
+			code:   "invalid_request",
+			fields: errs,
 		}
+	}
+	return uploadMeta{
+		projectID:     form.ProjectID,
+		changelog:     form.Changelog,
+		engine:        strings.ToLower(strings.TrimSpace(form.Engine)),
+		engineVersion: strings.TrimSpace(form.EngineVersion),
+		draft:         form.Draft,
+		prize:         form.Prize,
+		provenance:    requestProvenance(r),
+	}, nil
+}
 
-		if err := r.ParseMultipartForm(100 << 20); err != nil { // 100 MB max
-			http.Error(w, "Failed to parse form: "+err.Error(), http.StatusBadRequest)
-			return
+// Form fields sent alongside an upload are small; the file and data packs
+// are the only large parts
+const (
+	maxUploadFieldSize = 64 << 10
+	maxUploadFields    = 32
+	maxUploadPacks     = 8
+)
+
+// zipEntryBytes is roughly what an entry of a zip's central directory takes
+// in memory once read
+const zipEntryBytes = 512
+
+// receivedUpload is the file of an upload and its data packs, spilled to temp
+// files the caller removes.
+type receivedUpload struct {
+	path     string
+	filename string
+	// size counts the file and its packs
+	size int64
+	// packs are extra archives of a game too large for one zip, extracted
+	// over the file
+	packs []string
+	// thumbnail is the cover image sent along, if any
+	thumbnail []byte
+}
+
+// remove deletes the upload's temp files.
+func (u receivedUpload) remove() {
+	for _, path := range append([]string{u.path}, u.packs...) {
+		if path != "" {
+			os.Remove(path)
 		}
+	}
+}
+
+// receiveUpload reads a multipart upload part by part. The form fields end up
+// in r.Form and the "file" part, and any "pack" parts, are written straight
+// to temp files as they arrive, so an upload takes up its size on disk once
+// and only a copy buffer in memory, whatever its size. Extraction still
+// starts once the whole file is in: a zip's index sits at its very end.
+func receiveUpload(r *http.Request) (receivedUpload, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return receivedUpload{}, newUploadError(http.StatusBadRequest, "Failed to parse form: "+err.Error())
+	}
 
-		file, _, err := r.FormFile("file")
+	var upload receivedUpload
+	fail := func(err error) (receivedUpload, error) {
+		upload.remove()
+		return receivedUpload{}, err
+	}
+
+	form := url.Values{}
+	fields := 0
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
-			http.Error(w, "Missing file field 'file': "+err.Error(), http.StatusBadRequest)
-			return
+			return fail(newUploadError(http.StatusBadRequest, "Failed to parse form: "+err.Error()))
 		}
-		defer file.Close()
 
-		tmpFile, err := os.CreateTemp("", "game-upload-*.zip")
+		name := part.FormName()
+		if name == "file" && part.FileName() != "" {
+			if upload.path != "" {
+				part.Close()
+				return fail(newUploadError(http.StatusBadRequest, "Only one file may be uploaded"))
+			}
+			var size int64
+			upload.filename = part.FileName()
+			upload.path, size, err = spillPart(r.Context(), part)
+			part.Close()
+			if err != nil {
+				return fail(err)
+			}
+			upload.size += size
+			continue
+		}
+		if name == "pack" && part.FileName() != "" {
+			if len(upload.packs) == maxUploadPacks {
+				part.Close()
+				return fail(newUploadError(http.StatusBadRequest, fmt.Sprintf("At most %d data packs may be uploaded", maxUploadPacks)))
+			}
+			path, size, err := spillPart(r.Context(), part)
+			part.Close()
+			if err != nil {
+				return fail(err)
+			}
+			upload.packs = append(upload.packs, path)
+			upload.size += size
+			continue
+		}
+
+		if name == "thumbnail" && part.FileName() != "" {
+			if upload.thumbnail != nil {
+				part.Close()
+				return fail(newUploadError(http.StatusBadRequest, "Only one thumbnail may be uploaded"))
+			}
+			upload.thumbnail, err = io.ReadAll(io.LimitReader(part, maxThumbnailBytes+1))
+			part.Close()
+			if err != nil {
+				return fail(newUploadError(http.StatusBadRequest, "Failed to read thumbnail: "+err.Error()))
+			}
+			continue
+		}
+
+		if fields++; fields > maxUploadFields {
+			part.Close()
+			return fail(newUploadError(http.StatusBadRequest, "Too many form fields"))
+		}
+		value, err := io.ReadAll(io.LimitReader(part, maxUploadFieldSize+1))
+		part.Close()
 		if err != nil {
-			http.Error(w, "Failed to create temporary file: "+err.Error(), http.StatusInternalServerError)
-			return
+			return fail(newUploadError(http.StatusBadRequest, "Failed to read form field "+name+": "+err.Error()))
+		}
+		if len(value) > maxUploadFieldSize {
+			return fail(newUploadError(http.StatusBadRequest, "Form field "+name+" is too large"))
 		}
-		defer os.Remove(tmpFile.Name())
+		form.Add(name, string(value))
+	}
 
-		if _, err := io.Copy(tmpFile, file); err != nil {
-			tmpFile.Close()
-			http.Error(w, "Failed to write uploaded file: "+err.Error(), http.StatusInternalServerError)
-			return
+	if upload.path == "" {
+		return fail(newUploadError(http.StatusBadRequest, "Missing file field 'file'"))
+	}
+	r.Form = form
+	r.PostForm = form
+	return upload, nil
+}
+
+// spillPart copies an uploaded file part to a temp file.
+func spillPart(ctx context.Context, part io.Reader) (string, int64, error) {
+	tmpFile, err := os.CreateTemp("", "game-upload-*.zip")
+	if err != nil {
+		return "", 0, newUploadError(http.StatusInternalServerError, "Failed to create temporary file: "+err.Error())
+	}
+
+	size, err := io.Copy(tmpFile, part)
+	if err == nil {
+		err = faults.Inject(ctx, faults.DiskFull)
+	}
+	if err != nil {
+		tmpFile.Close()
+		os.Remove(tmpFile.Name())
+		return "", 0, newUploadError(http.StatusBadRequest, "Failed to receive uploaded file: "+err.Error())
+	}
+
+	if err := tmpFile.Close(); err != nil {
+		os.Remove(tmpFile.Name())
+		return "", 0, newUploadError(http.StatusInternalServerError, "Failed to close temp file: "+err.Error())
+	}
+	return tmpFile.Name(), size, nil
+}
+
+// zipEntry is a file of an upload's archives and where it goes in the build.
+type zipEntry struct {
+	f    *zip.File
+	name string
+}
+
+// archiveEntries lists the entries of an archive to extract, skipping macOS
+// junk and trimming rootPrefix.
+func archiveEntries(zr *zip.Reader, rootPrefix, destDir string) ([]zipEntry, error) {
+	var entries []zipEntry
+	for _, f := range zr.File {
+		// Skip macOS junk
+		if strings.HasPrefix(f.Name, "__MACOSX/") {
+			continue
 		}
 
-		if err := tmpFile.Close(); err != nil {
-			http.Error(w, "Failed to close temp file: "+err.Error(), http.StatusInternalServerError)
-			return
+		name := f.Name
+		if rootPrefix != "" && strings.HasPrefix(name, rootPrefix) {
+			name = strings.TrimPrefix(name, rootPrefix)
+			if name == "" {
+				continue
+			}
 		}
 
-		zr, err := zip.OpenReader(tmpFile.Name())
-		if err != nil {
-			http.Error(w, "Uploaded file is not a valid zip: "+err.Error(), http.StatusBadRequest)
-			return
+		if !validateZipFilePath(name, destDir) {
+			return nil, newUploadError(http.StatusBadRequest, "Invalid file path in zip: "+f.Name)
 		}
-		defer zr.Close()
+		entries = append(entries, zipEntry{f, name})
+	}
+	return entries, nil
+}
 
-		id, err := uuid.NewV7()
+// extractGame unpacks the zip at zipPath into destDir, flattening a single
+// root folder, then its data packs as they are: pack paths are relative to
+// the game's root. A file may only come from one archive, and the archives
+// must be within the limits of validate.CheckArchive, with at most maxBytes
+// uncompressed between them (0 for no limit).
+// progress, if set, is called after each entry.
+func extractGame(ctx context.Context, zipPath string, packs []string, destDir string, maxBytes int64, progress func(done, total int)) error {
+	start := time.Now()
+	zr, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return newUploadError(http.StatusBadRequest, "Uploaded file is not a valid zip: "+err.Error())
+	}
+	defer zr.Close()
+	// The central directory is in memory for the whole extraction; each
+	// file is decompressed through one window and copy buffer at a time,
+	// which UPLOAD_MEMORY_MB covers
+	membudget.Hold(ctx, int64(len(zr.File))*zipEntryBytes)
+
+	entries, err := archiveEntries(&zr.Reader, getSingleRootPrefix(zr.File), destDir)
+	if err != nil {
+		return err
+	}
+	for i, pack := range packs {
+		pr, err := zip.OpenReader(pack)
+		if err != nil {
+			return newUploadError(http.StatusBadRequest, fmt.Sprintf("Data pack %d is not a valid zip: %v", i+1, err))
+		}
+		defer pr.Close()
+		membudget.Hold(ctx, int64(len(pr.File))*zipEntryBytes)
+		packEntries, err := archiveEntries(&pr.Reader, "", destDir)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
+		entries = append(entries, packEntries...)
+	}
 
-		destDir := filepath.Join("./games/" + id.String() + "/")
-		if err := os.MkdirAll(destDir, 0755); err != nil {
-			http.Error(w, "Failed to create game directory: "+err.Error(), http.StatusInternalServerError)
-			return
+	// The sizes are checked against the data as it is extracted, so the
+	// limits can be enforced before writing anything
+	files := make([]*zip.File, len(entries))
+	for i, e := range entries {
+		files[i] = e.f
+	}
+	if err := validate.CheckArchive(files, maxBytes); err != nil {
+		var archiveErr *validate.ArchiveError
+		if !errors.As(err, &archiveErr) {
+			return err
+		}
+		status := http.StatusRequestEntityTooLarge
+		if archiveErr.Code == validate.CodeSuspiciousCompression {
+			status = http.StatusUnprocessableEntity
+		}
+		return &uploadError{status: status, msg: archiveErr.Msg, code: archiveErr.Code}
+	}
+	seen := map[string]bool{}
+	for _, e := range entries {
+		if e.f.FileInfo().IsDir() {
+			continue
 		}
+		name := path.Clean(e.name)
+		if seen[name] {
+			return &uploadError{
+				status: http.StatusUnprocessableEntity,
+				msg:    "Build rejected: " + name + " is in more than one archive",
+				code:   "archive_conflict",
+			}
+		}
+		seen[name] = true
+	}
 
-		rootPrefix := getSingleRootPrefix(zr.File)
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return newUploadError(http.StatusInternalServerError, "Failed to create game directory: "+err.Error())
+	}
 
-		for _, f := range zr.File {
-			// Skip macOS junk
-			if strings.HasPrefix(f.Name, "__MACOSX/") {
-				continue
-			}
+	for i, e := range entries {
+		if progress != nil {
+			progress(i, len(entries))
+		}
 
-			name := f.Name
-			if rootPrefix != "" && strings.HasPrefix(name, rootPrefix) {
-				name = strings.TrimPrefix(name, rootPrefix)
-				if name == "" {
-					continue
-				}
-			}
+		fpath := filepath.Join(destDir, e.name)
 
-			if !validateZipFilePath(name, destDir) {
-				http.Error(w, "Invalid file path in zip: "+f.Name, http.StatusBadRequest)
-				return
-			}
+		if e.f.FileInfo().IsDir() {
+			os.MkdirAll(fpath, 0755)
+			continue
+		}
 
-			fpath := filepath.Join(destDir, name)
+		if err := os.MkdirAll(filepath.Dir(fpath), 0755); err != nil {
+			return newUploadError(http.StatusInternalServerError, "Failed to create directory: "+err.Error())
+		}
 
-			if f.FileInfo().IsDir() {
-				os.MkdirAll(fpath, f.Mode())
-				continue
+		if i >= len(entries)/2 {
+			if err := faults.Inject(ctx, faults.PartialExtract); err != nil {
+				return newUploadError(http.StatusInternalServerError, "Failed to open file in zip: "+err.Error())
 			}
+		}
+		if err := extractZipFile(e.f, fpath); err != nil {
+			return err
+		}
+	}
+
+	if progress != nil {
+		progress(len(entries), len(entries))
+	}
+	extractionDuration.Since(start)
+	return nil
+}
 
-			if err := os.MkdirAll(filepath.Dir(fpath), 0755); err != nil {
-				http.Error(w, "Failed to create directory: "+err.Error(), http.StatusInternalServerError)
+// checkExtractedContent rejects builds containing disguised executables,
+// files whose content doesn't match their extension or, when enabled, broken
+// wasm modules, or that break one of the current event's validation rules. It
+// removes the extracted files so they are never served.
+func checkExtractedContent(ctx context.Context, srv *structs.Server, destDir string) error {
+	findings, err := validate.CheckContent(destDir)
+	if err != nil {
+		os.RemoveAll(destDir)
+		return newUploadError(http.StatusInternalServerError, "Failed to inspect build: "+err.Error())
+	}
+	rules, err := eventRules(srv)
+	if err == nil {
+		var found []validate.Finding
+		found, err = validate.RunRules(destDir, rules)
+		findings = append(findings, found...)
+	}
+	if err != nil {
+		os.RemoveAll(destDir)
+		return newUploadError(http.StatusInternalServerError, "Failed to run validation rules: "+err.Error())
+	}
+	if cfg := srv.Config.Get(); cfg.WasmCheck {
+		// Modules may grow their memories to the cap while checked
+		defer membudget.Hold(ctx, int64(cfg.WasmMaxMemoryMB)<<20)()
+		found, err := validate.CheckWasm(destDir, validate.WasmLimits{
+			MaxMemoryPages: uint32(cfg.WasmMaxMemoryMB) * 16, // 64 KiB pages
+			Timeout:        30 * time.Second,
+			MaxSize:        256 << 20,
+		})
+		if err != nil {
+			os.RemoveAll(destDir)
+			return newUploadError(http.StatusInternalServerError, "Failed to check wasm modules: "+err.Error())
+		}
+		findings = append(findings, found...)
+	}
+	if len(findings) == 0 {
+		return nil
+	}
+	for _, f := range findings {
+		validationFailures.Inc(f.Rule)
+	}
+	os.RemoveAll(destDir)
+	return &uploadError{
+		status:   http.StatusUnprocessableEntity,
+		msg:      fmt.Sprintf("Build rejected: %d file(s) failed validation", len(findings)),
+		code:     "validation_failed",
+		findings: findings,
+	}
+}
+
+// checkPlayable rejects builds the play page couldn't start, with a report
+// of what's missing: no index.html at the root, a project's source instead
+// of its web export, or an export missing its engine files. It removes the
+// extracted files.
+func checkPlayable(srv *structs.Server, destDir string) error {
+	findings, err := validate.CheckPlayable(destDir, srv.Config.Get().NormalizeKeyCase)
+	if err != nil {
+		os.RemoveAll(destDir)
+		return newUploadError(http.StatusInternalServerError, "Failed to inspect build: "+err.Error())
+	}
+	if len(findings) == 0 {
+		return nil
+	}
+	for _, f := range findings {
+		validationFailures.Inc(f.Rule)
+	}
+	os.RemoveAll(destDir)
+	return &uploadError{
+		status:   http.StatusUnprocessableEntity,
+		msg:      "Build rejected: " + findings[0].Detail,
+		code:     "not_playable",
+		findings: findings,
+	}
+}
+
+// lintBuild returns warnings about broken references in a build's HTML. They
+// are reported to the uploader but never block the upload.
+func lintBuild(ctx context.Context, destDir string) []validate.Finding {
+	warnings, err := validate.LintHTML(destDir)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to lint build", "dir", destDir, "error", err)
+		return nil
+	}
+	return warnings
+}
+
+func extractZipFile(f *zip.File, fpath string) error {
+	rc, err := f.Open()
+	if err != nil {
+		return newUploadError(http.StatusInternalServerError, "Failed to open file in zip: "+err.Error())
+	}
+	defer rc.Close()
+
+	// Modes in the archive depend on the OS it was made on, so they're
+	// ignored; applyBuildPermissions sets the configured ones
+	outFile, err := os.OpenFile(fpath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return newUploadError(http.StatusInternalServerError, "Failed to create file: "+err.Error())
+	}
+
+	n, err := io.Copy(outFile, rc)
+	bytesWritten.Add(float64(n))
+	if err != nil {
+		outFile.Close()
+		return newUploadError(http.StatusInternalServerError, "Failed to write file: "+err.Error())
+	}
+	return outFile.Close()
+}
+
+// applyBuildPermissions gives every file and directory of a published build
+// the configured modes, whatever the umask, and the configured owner if any,
+// so the server or a proxy serving the directory can always read it and
+// nothing in it is left writable by others.
+func applyBuildPermissions(srv *structs.Server, destDir string) error {
+	cfg := srv.Config.Get()
+	err := filepath.WalkDir(destDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type()&fs.ModeSymlink != 0 {
+			return nil
+		}
+		mode := cfg.BuildFileMode
+		if d.IsDir() {
+			mode = cfg.BuildDirMode
+		}
+		if err := os.Chmod(path, mode); err != nil {
+			return err
+		}
+		if cfg.BuildUID >= 0 {
+			return os.Chown(path, cfg.BuildUID, cfg.BuildGID)
+		}
+		return nil
+	})
+	if err != nil {
+		os.RemoveAll(destDir)
+		return newUploadError(http.StatusInternalServerError, "Failed to set build permissions: "+err.Error())
+	}
+	return nil
+}
+
+// checkSubmissionDeadline rejects uploads after the configured deadline unless
+// the uploader is on the late submission allowlist.
+func checkSubmissionDeadline(srv *structs.Server, ownerID string) error {
+	cfg := srv.Config.Get()
+	if cfg.SubmissionDeadline == nil || time.Now().Before(*cfg.SubmissionDeadline) {
+		return nil
+	}
+	if ownerID != "" && cfg.LateSubmissionAllowlist[ownerID] {
+		return nil
+	}
+	return newUploadError(http.StatusForbidden, "Submissions closed at "+cfg.SubmissionDeadline.Format(time.RFC3339))
+}
+
+// checkProjectUpload checks user may add a version to projectID: uploads to
+// an existing project need the token of one of its owners. A project ID
+// nothing uses yet is claimed by the upload, which still needs a user so the
+// project has an owner.
+func checkProjectUpload(ctx context.Context, srv *structs.Server, user *airtable.Record, projectID string) error {
+	if user == nil {
+		return &uploadError{status: http.StatusUnauthorized, code: "unauthorized", msg: "Uploading to a project needs the token of its owner"}
+	}
+	builds, err := loadBuilds(srv)
+	if err != nil {
+		return newUploadError(http.StatusInternalServerError, "Failed to load builds: "+err.Error())
+	}
+	if len(builds.projectBuilds(projectID)) == 0 {
+		if _, err := srv.GameStore.GameByID(ctx, projectID); err != nil {
+			return nil
+		}
+	}
+	owns, err := ownsProject(ctx, srv, user.ID, projectID)
+	if err != nil {
+		return newUploadError(http.StatusInternalServerError, "Failed to load builds: "+err.Error())
+	}
+	if !owns {
+		return &uploadError{status: http.StatusForbidden, code: "not_project_owner", msg: "Project " + projectID + " belongs to someone else"}
+	}
+	return nil
+}
+
+// receivedDetail describes an upload in its received event.
+func receivedDetail(upload receivedUpload) string {
+	if len(upload.packs) == 0 {
+		return fmt.Sprintf("%s (%d bytes)", upload.filename, upload.size)
+	}
+	return fmt.Sprintf("%s + %d data pack(s) (%d bytes)", upload.filename, len(upload.packs), upload.size)
+}
+
+// registerBuild records a freshly extracted build and the owner's activity.
+func registerBuild(ctx context.Context, srv *structs.Server, id, ownerID string, meta uploadMeta) Build {
+	projectID := meta.projectID
+	if projectID == "" {
+		projectID = id
+	}
+	build := Build{
+		ID:             id,
+		ProjectID:      projectID,
+		OwnerID:        ownerID,
+		Changelog:      meta.changelog,
+		Engine:         meta.engine,
+		EngineVersion:  meta.engineVersion,
+		ListingType:    meta.listingType,
+		ArtifactSHA256: meta.artifactSHA256,
+		CreatedAt:      time.Now().UTC(),
+		Hooks:          meta.hooks,
+		Event:          srv.Config.Get().EventID,
+		Draft:          meta.draft,
+	}
+	if err := recordBuild(srv, &build); err != nil {
+		slog.ErrorContext(ctx, "Failed to record build", "game_id", build.ID, "error", err)
+	}
+	saveProvenance(ctx, srv, build, meta.provenance)
+	linkGameMeta(ctx, srv, build)
+	if !build.Draft {
+		if state, err := loadBuilds(srv); err == nil {
+			notifyDevChannel(srv, &state, build.ProjectID)
+		}
+		if err := stats.Shipped(srv.Store, build.ProjectID, build.ListingType, build.CreatedAt); err != nil {
+			slog.ErrorContext(ctx, "Failed to record ship stats", "project_id", build.ProjectID, "error", err)
+		}
+	}
+	go publishManifest(ctx, srv, build, filepath.Join("./games", id), meta.keyCase, meta.originalPaths)
+	if build.ListingType == "" {
+		go detectInputs(ctx, srv, build, filepath.Join("./games", id))
+	}
+	if ownerID != "" {
+		if err := recordActivity(srv, ownerID, ActivityUpload, build.CreatedAt); err != nil {
+			slog.ErrorContext(ctx, "Failed to record upload activity", "owner_id", ownerID, "error", err)
+		}
+	}
+	return build
+}
+
+// uploadResponse describes a published build.
+type uploadResponse struct {
+	Ok          bool   `json:"ok"`
+	GameID      string `json:"gameId"`
+	ProjectID   string `json:"projectId"`
+	PlayURL     string `json:"playUrl,omitempty"`
+	DownloadURL string `json:"downloadUrl,omitempty"`
+	ListingType string `json:"listingType,omitempty"`
+	// Warnings point out likely broken references, see LintHTML
+	Warnings []validate.Finding `json:"warnings,omitempty"`
+	// Storage is what the uploader's builds take up, this one included
+	Storage *quota.StorageUsage `json:"storage,omitempty"`
+	// Thumbnails are the cover image sent with the build and its variants
+	Thumbnails *Thumbnails `json:"thumbnails,omitempty"`
+}
+
+func GameUploadHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		uploadAttempts.Inc("uploadGame")
+
+		// Uploading with a user token records the build's owner, which is
+		// required to edit its changelog later. A token that doesn't
+		// authenticate is refused rather than uploading anonymously.
+		ownerID := ""
+		var user *airtable.Record
+		admin := isAdmin(srv, r)
+		if bearerToken(r) != "" && !admin {
+			var err error
+			user, err = authenticateUser(srv, r)
+			if errors.Is(err, errUnauthorized) {
+				writeUploadError(w, r, &uploadError{status: http.StatusUnauthorized, code: "unauthorized", msg: "Invalid token; upload with a valid token or without one"})
+				return
+			}
+			if errors.Is(err, errInsufficientScope) {
+				writeUploadError(w, r, &uploadError{status: http.StatusForbidden, code: "insufficient_scope", msg: "This token can't upload; mint one with the uploads scope"})
 				return
 			}
-
-			rc, err := f.Open()
 			if err != nil {
-				http.Error(w, "Failed to open file in zip: "+err.Error(), http.StatusInternalServerError)
+				writeUploadError(w, r, newUploadError(http.StatusInternalServerError, "Failed to authenticate: "+err.Error()))
 				return
 			}
-			defer rc.Close()
+			ownerID = user.ID
+		}
 
-			outFile, err := os.OpenFile(fpath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode())
-			if err != nil {
-				http.Error(w, "Failed to create file: "+err.Error(), http.StatusInternalServerError)
+		// Slots are per user, or per IP for anonymous uploads
+		slotKey := ipQuotaKey(r)
+		if user != nil {
+			slotKey = userQuotaKey(user.ID)
+		}
+		release, err := acquireUploadSlot(srv, slotKey)
+		if err != nil {
+			w.Header().Set("Retry-After", "10")
+			writeUploadError(w, r, err)
+			return
+		}
+		defer release()
+		admitted, releaseMemory, err := admitUpload(srv, r)
+		if err != nil {
+			w.Header().Set("Retry-After", "60")
+			writeUploadError(w, r, err)
+			return
+		}
+		defer releaseMemory()
+		r = admitted
+
+		ctx := r.Context()
+		var upload receivedUpload
+		err = traceStep(ctx, "receive", func(context.Context) error {
+			upload, err = receiveUpload(r)
+			return err
+		})
+		if err != nil {
+			writeUploadError(w, r, err)
+			return
+		}
+		zipPath := upload.path
+		defer upload.remove()
+
+		meta, err := parseUploadMeta(r)
+		if err != nil {
+			writeUploadError(w, r, err)
+			return
+		}
+		var thumb *thumbnail
+		if upload.thumbnail != nil {
+			if thumb, err = decodeThumbnail(ctx, upload.thumbnail); err != nil {
+				writeUploadError(w, r, err)
 				return
 			}
+		}
 
-			if _, err := io.Copy(outFile, rc); err != nil {
-				outFile.Close()
-				http.Error(w, "Failed to write file: "+err.Error(), http.StatusInternalServerError)
+		if meta.projectID != "" && !admin {
+			if err := checkProjectUpload(ctx, srv, user, meta.projectID); err != nil {
+				writeUploadError(w, r, err)
 				return
 			}
-			outFile.Close()
 		}
 
-		log.Printf("User successfully uploaded a new game snapshot!")
+		if err := checkSubmissionEligibility(srv, user, meta); err != nil {
+			writeUploadError(w, r, err)
+			return
+		}
 
-		go func(folder string, srv *structs.Server) {
-			if err := sync.UploadFolder(folder, *srv); err != nil {
-				log.Printf("Failed to sync folder %s to R2: %v", folder, err)
-			}
-		}(destDir, srv)
+		if err := checkSubmissionDeadline(srv, ownerID); err != nil {
+			writeUploadError(w, r, err)
+			return
+		}
+
+		cartKind := detectCartridge(upload.filename)
+		if cartKind != "" {
+			meta.engine = cartKind
+		}
+
+		id, err := uuid.NewV7()
+		if err != nil {
+			writeUploadError(w, r, newUploadError(http.StatusInternalServerError, "Failed to generate game id: "+err.Error()))
+			return
+		}
 
-		w.WriteHeader(http.StatusOK)
-		w.Header().Set("Content-Type", "application/json")
-		resp := struct {
-			Ok      bool   `json:"ok"`
-			GameID  string `json:"gameId"`
-			PlayURL string `json:"playUrl"`
-		}{
-			Ok:      true,
-			GameID:  id.String(),
-			PlayURL: "/play/" + id.String() + "/",
+		nativeKind := ""
+		if cartKind == "" {
+			nativeKind = detectNativeBuild(upload.filename, zipPath)
 		}
+		received := events.Event{GameID: id.String(), Type: events.Received, Actor: ownerID, Detail: receivedDetail(upload)}
+		if cartKind == "" && nativeKind == "" {
+			received.Archive = profileUpload(upload)
+		}
+		appendEvent(ctx, srv, received)
+		if nativeKind != "" && !srv.Config.Get().AllowDownloadableBuilds {
+			emitEvent(ctx, srv, id.String(), events.Failed, ownerID, "native "+nativeKind+" build rejected")
+			writeUploadError(w, r, newUploadError(http.StatusUnsupportedMediaType, nativeBuildGuidance))
+			return
+		}
+		if len(upload.packs) > 0 && (cartKind != "" || nativeKind != "") {
+			emitEvent(ctx, srv, id.String(), events.Failed, ownerID, "data packs sent with a non-web build")
+			writeUploadError(w, r, newUploadError(http.StatusBadRequest, "Data packs are only supported for web builds"))
+			return
+		}
+		emitEvent(ctx, srv, id.String(), events.Validated, ownerID, "")
 
-		responseBytes, _ := json.Marshal(resp)
-		response := string(responseBytes)
-		if _, err := w.Write([]byte(response)); err != nil {
-			log.Printf("Failed to write response: %v", err)
-			http.Error(w, "Failed to write response", http.StatusInternalServerError)
+		destDir := filepath.Join("./games/" + id.String() + "/")
+		var warnings []validate.Finding
+		var storage *quota.StorageUsage
+		var thumbnails *Thumbnails
+		err = traceStep(ctx, "extract", func(ctx context.Context) (err error) {
+			switch {
+			case cartKind != "":
+				err = publishCartridge(zipPath, destDir, cartKind)
+			case nativeKind != "":
+				meta.engine = nativeKind
+				meta.listingType = ListingDownloadable
+				meta.artifactSHA256, err = publishDownloadable(zipPath, destDir, nativeKind)
+			default:
+				maxBytes := int64(srv.Config.Get().MaxBuildSizeMB) << 20
+				if err = extractGame(ctx, zipPath, upload.packs, destDir, maxBytes, nil); err == nil {
+					err = checkExtractedContent(ctx, srv, destDir)
+				}
+				if err == nil {
+					err = checkPlayable(srv, destDir)
+				}
+				if err == nil {
+					warnings = lintBuild(ctx, destDir)
+				}
+			}
+			if err == nil && nativeKind == "" {
+				meta.hooks, err = applyBuildHooks(srv, destDir)
+			}
+			if err == nil && nativeKind == "" {
+				meta.hooks, err = applySDK(srv, destDir, id.String(), meta.hooks)
+			}
+			if err == nil && nativeKind == "" && cartKind == "" && srv.Config.Get().NormalizeKeyCase {
+				meta.keyCase = KeyCaseLower
+				meta.originalPaths, err = normalizeKeyCase(destDir)
+			}
+			if err == nil && thumb != nil {
+				if thumbnails, err = saveThumbnail(destDir, id.String(), thumb); err != nil {
+					os.RemoveAll(destDir)
+					err = newUploadError(http.StatusInternalServerError, "Failed to save thumbnail: "+err.Error())
+				}
+			}
+			if err == nil {
+				err = applyBuildPermissions(srv, destDir)
+			}
+			if err == nil {
+				err = scanBuild(ctx, srv, id.String(), ownerID, destDir, meta)
+			}
+			if err == nil {
+				storage, err = reserveStorage(srv, ownerID, id.String(), destDir)
+			}
+			return err
+		})
+		if err != nil {
+			emitFailure(ctx, srv, id.String(), ownerID, err)
+			writeUploadError(w, r, err)
 			return
 		}
+		emitEvent(ctx, srv, id.String(), events.Extracted, ownerID, "")
+
+		build := registerBuild(ctx, srv, id.String(), ownerID, meta)
+		slog.InfoContext(ctx, "Upload complete", "game_id", build.ID, "project_id", build.ProjectID, "owner_id", ownerID)
+
+		// The sync outlives the request but keeps its injected faults and
+		// its trace
+		queueSync(context.WithoutCancel(ctx), srv, build.ID, destDir)
+
+		resp := uploadResponse{
+			Ok:          true,
+			GameID:      build.ID,
+			ProjectID:   build.ProjectID,
+			ListingType: build.ListingType,
+			Warnings:    warnings,
+			Storage:     storage,
+			Thumbnails:  thumbnails,
+		}
+		if build.ListingType == ListingDownloadable {
+			resp.DownloadURL = "/download/" + build.ID
+		} else if build.Draft {
+			resp.PlayURL, _ = previewURL(srv, build.ID, time.Now())
+		} else {
+			resp.PlayURL = "/play/" + build.ID + "/"
+		}
+		writeJSON(w, http.StatusOK, resp)
 	}
 }
 
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"shiba-api/fixtures"
+)
+
+func TestExtractGameCorpus(t *testing.T) {
+	for _, f := range fixtures.Corpus() {
+		t.Run(f.Name, func(t *testing.T) {
+			if testing.Short() && f.Name == "zip-bomb" {
+				t.Skip("inflating the zip bomb takes a few seconds")
+			}
+			data, err := f.Build()
+			if err != nil {
+				t.Fatalf("failed to build fixture: %v", err)
+			}
+			dir := t.TempDir()
+			zipPath := filepath.Join(dir, f.Name+".zip")
+			if err := os.WriteFile(zipPath, data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			destDir := filepath.Join(dir, "game")
+
+			err = extractGame(context.Background(), zipPath, nil, destDir, 1<<30, nil)
+			if f.Accept {
+				if err != nil {
+					t.Fatalf("extractGame() = %v, want the fixture accepted", err)
+				}
+				if _, err := os.Stat(filepath.Join(destDir, "index.html")); err != nil {
+					t.Errorf("index.html not at the root of the extracted build: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("extractGame() = nil, want the fixture rejected")
+			}
+			var ue *uploadError
+			if !errors.As(err, &ue) {
+				t.Fatalf("extractGame() = %T %v, want an *uploadError", err, err)
+			}
+			if ue.code != f.Code {
+				t.Errorf("code = %q, want %q (%s)", ue.code, f.Code, ue.msg)
+			}
+			if ue.status >= 500 {
+				t.Errorf("status = %d, want a client error", ue.status)
+			}
+		})
+	}
+}
+
+func TestValidateZipFilePath(t *testing.T) {
+	destDir := t.TempDir()
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"index.html", true},
+		{"assets/sprites/player.png", true},
+		{"assets/../index.html", true},
+		{"./index.html", true},
+		{"../outside.js", false},
+		{"assets/../../outside.js", false},
+		{"../../etc/cron.d/evil", false},
+		{"..", false},
+		{".", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := validateZipFilePath(tt.path, destDir); got != tt.want {
+			t.Errorf("validateZipFilePath(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
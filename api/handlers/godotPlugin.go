@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"shiba-api/events"
+	"shiba-api/jobs"
+	"shiba-api/logging"
+	"shiba-api/structs"
+	"shiba-api/trace"
+	"shiba-api/validate"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// pluginUploadAccepted points the plugin at the status of its upload.
+type pluginUploadAccepted struct {
+	Ok        bool   `json:"ok"`
+	UploadID  string `json:"uploadId"`
+	StatusURL string `json:"statusUrl"`
+}
+
+// PluginUploadHandler accepts a build exported by the Godot editor plugin. It
+// requires a user token, answers 202 as soon as the zip is received and keeps
+// extracting and syncing in the background; the plugin polls
+// PluginUploadStatusHandler for progress.
+func PluginUploadHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := requireUser(srv, w, r)
+		if !ok {
+			return
+		}
+		uploadAttempts.Inc("plugin")
+
+		// The slot and the memory are held until background processing is done
+		release, err := acquireUploadSlot(srv, userQuotaKey(user.ID))
+		if err != nil {
+			w.Header().Set("Retry-After", "10")
+			writeUploadError(w, r, err)
+			return
+		}
+		defer func() { release() }()
+		admitted, releaseMemory, err := admitUpload(srv, r)
+		if err != nil {
+			w.Header().Set("Retry-After", "60")
+			writeUploadError(w, r, err)
+			return
+		}
+		defer func() { releaseMemory() }()
+		r = admitted
+
+		upload, err := receiveUpload(r)
+		if err != nil {
+			writeUploadError(w, r, err)
+			return
+		}
+		// Ownership passes to the background job once it starts
+		owned := true
+		defer func() {
+			if owned {
+				upload.remove()
+			}
+		}()
+
+		meta, err := parseUploadMeta(r)
+		if err != nil {
+			writeUploadError(w, r, err)
+			return
+		}
+		if meta.engine == "" {
+			meta.engine = "godot"
+		}
+		meta.provenance.Channel = ChannelGodotPlugin
+
+		if meta.projectID != "" {
+			if err := checkProjectUpload(r.Context(), srv, user, meta.projectID); err != nil {
+				writeUploadError(w, r, err)
+				return
+			}
+		}
+		if err := checkSubmissionDeadline(srv, user.ID); err != nil {
+			writeUploadError(w, r, err)
+			return
+		}
+		if err := checkSubmissionEligibility(srv, user, meta); err != nil {
+			writeUploadError(w, r, err)
+			return
+		}
+
+		id, err := uuid.NewV7()
+		if err != nil {
+			writeUploadError(w, r, newUploadError(http.StatusInternalServerError, "Failed to generate game id: "+err.Error()))
+			return
+		}
+
+		srv.UploadJobs.Start(id.String(), user.ID)
+		srv.UploadJobs.Update(id.String(), func(j *jobs.Job) {
+			j.RequestID, j.TraceID = logging.RequestID(r.Context()), trace.ID(r.Context())
+		})
+		appendEvent(r.Context(), srv, events.Event{
+			GameID:  id.String(),
+			Type:    events.Received,
+			Actor:   user.ID,
+			Detail:  "godot plugin upload: " + receivedDetail(upload),
+			Archive: profileUpload(upload),
+		})
+		slot, memory := release, releaseMemory
+		release, releaseMemory = func() {}, func() {}
+		owned = false
+		ctx := context.WithoutCancel(r.Context())
+		go func() {
+			defer slot()
+			defer memory()
+			processPluginUpload(ctx, srv, id.String(), user.ID, upload, meta)
+		}()
+
+		writeJSON(w, http.StatusAccepted, pluginUploadAccepted{true, id.String(), apiPath(r, "/plugin/uploads/"+id.String())})
+	}
+}
+
+func processPluginUpload(ctx context.Context, srv *structs.Server, id, ownerID string, upload receivedUpload, meta uploadMeta) {
+	defer upload.remove()
+
+	fail := func(err error) {
+		var ue *uploadError
+		msg := err.Error()
+		var findings []validate.Finding
+		if errors.As(err, &ue) {
+			msg = ue.msg
+			findings = ue.findings
+		}
+		slog.WarnContext(ctx, "Plugin upload failed", "game_id", id, "error", msg)
+		emitFailure(ctx, srv, id, ownerID, err)
+		srv.UploadJobs.Update(id, func(j *jobs.Job) {
+			j.Status = jobs.StatusFailed
+			j.Error = msg
+			j.Findings = findings
+		})
+	}
+
+	srv.UploadJobs.Update(id, func(j *jobs.Job) { j.Status = jobs.StatusExtracting })
+
+	// Extraction is the first 80% of the progress bar, syncing the rest
+	destDir := filepath.Join("./games/" + id + "/")
+	err := traceStep(ctx, "extract", func(ctx context.Context) error {
+		maxBytes := int64(srv.Config.Get().MaxBuildSizeMB) << 20
+		err := extractGame(ctx, upload.path, upload.packs, destDir, maxBytes, func(done, total int) {
+			srv.UploadJobs.Update(id, func(j *jobs.Job) {
+				if total > 0 {
+					j.Progress = done * 80 / total
+				}
+			})
+		})
+		if err == nil {
+			err = checkExtractedContent(ctx, srv, destDir)
+		}
+		if err == nil {
+			err = checkPlayable(srv, destDir)
+		}
+		if err == nil {
+			meta.hooks, err = applyBuildHooks(srv, destDir)
+		}
+		if err == nil {
+			meta.hooks, err = applySDK(srv, destDir, id, meta.hooks)
+		}
+		if err == nil && srv.Config.Get().NormalizeKeyCase {
+			meta.keyCase = KeyCaseLower
+			meta.originalPaths, err = normalizeKeyCase(destDir)
+		}
+		if err == nil {
+			err = applyBuildPermissions(srv, destDir)
+		}
+		if err == nil {
+			err = scanBuild(ctx, srv, id, ownerID, destDir, meta)
+		}
+		if err == nil {
+			_, err = reserveStorage(srv, ownerID, id, destDir)
+		}
+		return err
+	})
+	if err != nil {
+		fail(err)
+		return
+	}
+	emitEvent(ctx, srv, id, events.Validated, ownerID, "")
+	emitEvent(ctx, srv, id, events.Extracted, ownerID, "")
+
+	warnings := lintBuild(ctx, destDir)
+	build := registerBuild(ctx, srv, id, ownerID, meta)
+	srv.UploadJobs.Update(id, func(j *jobs.Job) {
+		j.Status = jobs.StatusSyncing
+		j.Progress = 80
+		j.GameID = build.ID
+		j.PlayURL = "/play/" + build.ID + "/"
+		if build.Draft {
+			j.PlayURL, _ = previewURL(srv, build.ID, time.Now())
+		}
+		j.Warnings = warnings
+	})
+
+	if err := <-queueSync(ctx, srv, id, destDir); err != nil {
+		srv.UploadJobs.Update(id, func(j *jobs.Job) {
+			j.Status = jobs.StatusFailed
+			j.Error = "Failed to sync build: " + err.Error()
+		})
+		return
+	}
+
+	srv.UploadJobs.Update(id, func(j *jobs.Job) {
+		j.Status = jobs.StatusDone
+		j.Progress = 100
+	})
+}
+
+// PluginUploadStatusHandler reports the progress of a plugin upload to the
+// user who started it.
+func PluginUploadStatusHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := requireUser(srv, w, r)
+		if !ok {
+			return
+		}
+
+		job, found := srv.UploadJobs.Get(chi.URLParam(r, "uploadId"))
+		if !found || job.OwnerID != user.ID {
+			http.Error(w, "Upload not found", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, http.StatusOK, job)
+	}
+}
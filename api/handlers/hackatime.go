@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"shiba-api/structs"
+	"shiba-api/webhooks"
+)
+
+const hackatimeDoc = "hackatime"
+
+// Heartbeats at most this far apart count the time between them as coding,
+// Hackatime's and WakaTime's default keystroke timeout
+const heartbeatTimeout = 2 * time.Minute
+
+// Pushes signed longer ago than this are rejected, so they can't be replayed
+const hackatimeMaxSkew = 5 * time.Minute
+
+// hackatimeUser is the dev time pushed for one Hackatime user.
+type hackatimeUser struct {
+	TotalSeconds float64 `json:"totalSeconds"`
+	// Projects maps Hackatime project name -> seconds
+	Projects map[string]float64 `json:"projects"`
+	// The last heartbeat counted, as Unix seconds, and its project
+	LastHeartbeat float64 `json:"lastHeartbeat"`
+	LastProject   string  `json:"lastProject"`
+}
+
+type hackatimeState struct {
+	// Users maps Slack ID -> dev time
+	Users map[string]hackatimeUser `json:"users"`
+}
+
+// addHeartbeats folds heartbeats, sorted by time, into u: the gap since the
+// previous heartbeat counts towards the previous heartbeat's project when
+// it's within heartbeatTimeout. Heartbeats older than the last one counted
+// are dropped, so pushes sent twice count once.
+func (u *hackatimeUser) addHeartbeats(beats []heartbeat) {
+	if u.Projects == nil {
+		u.Projects = map[string]float64{}
+	}
+	for _, b := range beats {
+		if b.Time <= u.LastHeartbeat {
+			continue
+		}
+		if gap := b.Time - u.LastHeartbeat; u.LastHeartbeat > 0 && gap <= heartbeatTimeout.Seconds() && u.LastProject != "" {
+			u.TotalSeconds += gap
+			u.Projects[u.LastProject] += gap
+		}
+		u.LastHeartbeat, u.LastProject = b.Time, b.Project
+	}
+}
+
+// hackatimeSeconds is the pushed dev time of a Slack user on the given
+// Hackatime projects.
+func hackatimeSeconds(srv *structs.Server, slackID string, projects []string) (float64, error) {
+	var state hackatimeState
+	if err := srv.Store.Load(hackatimeDoc, &state); err != nil {
+		return 0, err
+	}
+	var total float64
+	for _, p := range projects {
+		total += state.Users[slackID].Projects[p]
+	}
+	return total, nil
+}
+
+// recordList reads a record field holding a list: a comma separated string
+// or, for lookups and multiple selects, an array.
+func recordList(fields map[string]any, name string) []string {
+	var items []string
+	switch v := fields[name].(type) {
+	case string:
+		items = strings.Split(v, ",")
+	case []any:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				items = append(items, s)
+			}
+		}
+	}
+	list := []string{}
+	for _, item := range items {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+type heartbeat struct {
+	// UserID is the Slack ID of the Hackatime user
+	UserID string `json:"user_id" validate:"required,max=64"`
+	// Time is when it was sent, in Unix seconds
+	Time    float64 `json:"time" validate:"required"`
+	Project string  `json:"project" validate:"max=200"`
+}
+
+type heartbeatPush struct {
+	Heartbeats []heartbeat `json:"heartbeats" validate:"max=1000"`
+}
+
+// HackatimeWebhookHandler receives the heartbeats Hackatime pushes as
+// developers code and adds them to their dev time right away, rather than
+// waiting for the next poll of their totals. Pushes are signed like our own
+// webhook deliveries, see webhooks.Sign.
+func HackatimeWebhookHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		secret := srv.Config.Get().HackatimeWebhookSecret
+		if secret == "" {
+			http.NotFound(w, r)
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+		if err != nil {
+			http.Error(w, "Failed to read body: "+err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		timestamp := r.Header.Get("X-Hackatime-Timestamp")
+		sent, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil || time.Since(time.Unix(sent, 0)).Abs() > hackatimeMaxSkew {
+			http.Error(w, "Missing or stale X-Hackatime-Timestamp", http.StatusUnauthorized)
+			return
+		}
+		if !hmac.Equal([]byte(r.Header.Get("X-Hackatime-Signature")), []byte(webhooks.Sign(secret, timestamp, body))) {
+			http.Error(w, "Invalid signature", http.StatusUnauthorized)
+			return
+		}
+
+		var push heartbeatPush
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		if !bindJSON(w, r, &push) {
+			return
+		}
+		latest := float64(time.Now().Add(hackatimeMaxSkew).Unix())
+		byUser := map[string][]heartbeat{}
+		for _, b := range push.Heartbeats {
+			if b.Time > latest || math.IsNaN(b.Time) {
+				continue
+			}
+			byUser[b.UserID] = append(byUser[b.UserID], b)
+		}
+		for _, beats := range byUser {
+			sort.Slice(beats, func(i, j int) bool { return beats[i].Time < beats[j].Time })
+		}
+
+		var state hackatimeState
+		err = srv.Store.Update(hackatimeDoc, &state, func() error {
+			if state.Users == nil {
+				state.Users = map[string]hackatimeUser{}
+			}
+			for userID, beats := range byUser {
+				u := state.Users[userID]
+				u.addHeartbeats(beats)
+				state.Users[userID] = u
+			}
+			return nil
+		})
+		if err != nil {
+			http.Error(w, "Failed to record heartbeats: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+type devTimeProject struct {
+	Name    string  `json:"name"`
+	Seconds float64 `json:"seconds"`
+}
+
+// MyDevTimeHandler returns the dev time Hackatime pushed for the calling
+// user, per Hackatime project, most worked on first.
+func MyDevTimeHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := requireUser(srv, w, r)
+		if !ok {
+			return
+		}
+		var state hackatimeState
+		if err := srv.Store.Load(hackatimeDoc, &state); err != nil {
+			http.Error(w, "Failed to load dev time: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		slackID, _ := user.Fields["slack id"].(string)
+		u := state.Users[slackID]
+
+		projects := []devTimeProject{}
+		for name, seconds := range u.Projects {
+			projects = append(projects, devTimeProject{name, math.Round(seconds)})
+		}
+		sort.Slice(projects, func(i, j int) bool { return projects[i].Seconds > projects[j].Seconds })
+		var last *time.Time
+		if u.LastHeartbeat > 0 {
+			t := time.Unix(0, int64(u.LastHeartbeat*float64(time.Second))).UTC()
+			last = &t
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, http.StatusOK, struct {
+			TotalSeconds    float64          `json:"totalSeconds"`
+			Projects        []devTimeProject `json:"projects"`
+			LastHeartbeatAt *time.Time       `json:"lastHeartbeatAt,omitempty"`
+		}{math.Round(u.TotalSeconds), projects, last})
+	}
+}
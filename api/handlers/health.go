@@ -1,8 +1,154 @@
 package handlers
 
-import "net/http"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
 
+	"shiba-api/datastore"
+	"shiba-api/malware"
+	"shiba-api/membudget"
+	"shiba-api/structs"
+	"shiba-api/sync"
+)
+
+// HealthCheckHandler serves /health and /healthz: the process is up and
+// serving requests. It checks nothing else, so a slow dependency never gets
+// the server restarted.
 func HealthCheckHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("OK"))
 }
+
+const (
+	readyCheckTimeout = 5 * time.Second
+	// Probes within readyCacheTTL of a check get its result, sparing
+	// Airtable's rate limit
+	readyCacheTTL = 10 * time.Second
+)
+
+// CheckResult is the outcome of one readiness check.
+type CheckResult struct {
+	OK         bool   `json:"ok"`
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"durationMs"`
+	// Free space for ./games, on the disk check
+	FreeBytes *int64 `json:"freeBytes,omitempty"`
+}
+
+// Readiness is the outcome of every readiness check; the server is ready if
+// they all passed.
+type Readiness struct {
+	Ready     bool                   `json:"ready"`
+	CheckedAt time.Time              `json:"checkedAt"`
+	Checks    map[string]CheckResult `json:"checks"`
+	// Memory is the upload memory budget, for information: a spent budget
+	// queues uploads rather than making the server unready
+	Memory membudget.Usage `json:"memory"`
+}
+
+var lastReadiness atomic.Pointer[Readiness]
+
+// storeName names the readiness check of the datastore after its backend.
+func storeName(store datastore.UserStore) string {
+	switch store.(type) {
+	case *datastore.Airtable:
+		return "airtable"
+	case *datastore.Postgres:
+		return "postgres"
+	}
+	return "datastore"
+}
+
+// checkDisk fails if ./games has less free space than MinFreeDiskMB.
+func checkDisk(srv *structs.Server, result *CheckResult) error {
+	free, err := freeDiskBytes("./games")
+	if errors.Is(err, errors.ErrUnsupported) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	result.FreeBytes = &free
+	if need := int64(srv.Config.Get().MinFreeDiskMB) << 20; free < need {
+		return fmt.Errorf("%d MB free, need %d MB", free>>20, need>>20)
+	}
+	return nil
+}
+
+// checkReadiness runs every check at once, each with its own timeout.
+func checkReadiness(ctx context.Context, srv *structs.Server) *Readiness {
+	checks := map[string]func(ctx context.Context, result *CheckResult) error{
+		"r2": func(ctx context.Context, _ *CheckResult) error {
+			return sync.CheckBucket(ctx, *srv)
+		},
+		"disk": func(_ context.Context, result *CheckResult) error {
+			return checkDisk(srv, result)
+		},
+	}
+	if addr := srv.Config.Get().ClamAVAddress; addr != "" {
+		checks["clamav"] = func(ctx context.Context, _ *CheckResult) error {
+			clamd, err := malware.NewClamd(addr)
+			if err != nil {
+				return err
+			}
+			return clamd.Ping(ctx)
+		}
+	}
+	if pinger, ok := srv.UserStore.(datastore.Pinger); ok {
+		checks[storeName(srv.UserStore)] = func(ctx context.Context, _ *CheckResult) error {
+			return pinger.Ping(ctx)
+		}
+	}
+
+	type namedResult struct {
+		name   string
+		result CheckResult
+	}
+	results := make(chan namedResult, len(checks))
+	for name, check := range checks {
+		go func() {
+			ctx, cancel := context.WithTimeout(ctx, readyCheckTimeout)
+			defer cancel()
+			start := time.Now()
+			var result CheckResult
+			err := check(ctx, &result)
+			result.DurationMs = time.Since(start).Milliseconds()
+			result.OK = err == nil
+			if err != nil {
+				result.Error = err.Error()
+			}
+			results <- namedResult{name, result}
+		}()
+	}
+
+	readiness := &Readiness{Ready: true, CheckedAt: time.Now().UTC(), Checks: map[string]CheckResult{}, Memory: srv.Memory.Usage()}
+	for range checks {
+		r := <-results
+		readiness.Checks[r.name] = r.result
+		readiness.Ready = readiness.Ready && r.result.OK
+	}
+	return readiness
+}
+
+// ReadinessHandler serves /readyz: whether the datastore, R2 and, when
+// uploads are scanned, clamd are reachable and ./games has room for uploads. Deploys stop routing traffic to a server
+// answering 503. Results are cached for a few seconds.
+func ReadinessHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		readiness := lastReadiness.Load()
+		if readiness == nil || time.Since(readiness.CheckedAt) > readyCacheTTL {
+			readiness = checkReadiness(r.Context(), srv)
+			lastReadiness.Store(readiness)
+		}
+		status := http.StatusOK
+		if !readiness.Ready {
+			status = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, status, readiness)
+	}
+}
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"net/http"
+	"os"
+
+	"shiba-api/hooks"
+	"shiba-api/schema"
+	"shiba-api/structs"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const hooksDoc = "hooks"
+
+type hooksState struct {
+	// Events maps event ID -> hook name -> hook
+	Events map[string]map[string]hooks.Hook `json:"events"`
+}
+
+// applyBuildHooks runs the current event's hooks on a freshly extracted
+// build. A failing hook fails the upload rather than publishing a build that
+// is missing organizer-required changes.
+func applyBuildHooks(srv *structs.Server, destDir string) ([]string, error) {
+	var state hooksState
+	if err := srv.Store.Load(hooksDoc, &state); err != nil {
+		os.RemoveAll(destDir)
+		return nil, newUploadError(http.StatusInternalServerError, "Failed to load hooks: "+err.Error())
+	}
+
+	var list []hooks.Hook
+	for _, h := range state.Events[srv.Config.Get().EventID] {
+		list = append(list, h)
+	}
+	applied, err := hooks.Apply(destDir, list)
+	if err != nil {
+		os.RemoveAll(destDir)
+		return nil, newUploadError(http.StatusInternalServerError, "Post-processing failed: "+err.Error())
+	}
+	return applied, nil
+}
+
+// applySDK adds the Shiba SDK to a freshly extracted web build when
+// SDK_INJECTION_ENABLED is set, recording it with the build's hooks.
+func applySDK(srv *structs.Server, destDir, gameID string, applied []string) ([]string, error) {
+	if !srv.Config.Get().InjectSDK {
+		return applied, nil
+	}
+	hook, err := hooks.InjectSDK(destDir, gameID)
+	if err != nil {
+		os.RemoveAll(destDir)
+		return nil, newUploadError(http.StatusInternalServerError, "Failed to inject the Shiba SDK: "+err.Error())
+	}
+	if hook != "" {
+		applied = append(applied, hook)
+	}
+	return applied, nil
+}
+
+// HooksHandler lists the configured hooks of every event and the transforms
+// available. Requires the admin token.
+func HooksHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(srv, r) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var state hooksState
+		if err := srv.Store.Load(hooksDoc, &state); err != nil {
+			http.Error(w, "Failed to load hooks: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if state.Events == nil {
+			state.Events = map[string]map[string]hooks.Hook{}
+		}
+
+		writeJSON(w, http.StatusOK, struct {
+			CurrentEvent string                           `json:"currentEvent"`
+			Transforms   []string                         `json:"transforms"`
+			Events       map[string]map[string]hooks.Hook `json:"events"`
+		}{srv.Config.Get().EventID, hooks.Transforms(), state.Events})
+	}
+}
+
+// UpdateHookHandler creates or replaces a hook of an event, bumping its
+// version. Hooks are never deleted so versions keep increasing; set enabled
+// to false to switch one off.
+// Requires the admin token.
+func UpdateHookHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(srv, r) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		event := chi.URLParam(r, "event")
+		name := chi.URLParam(r, "name")
+
+		var req struct {
+			Transform string            `json:"transform" validate:"required"`
+			Params    map[string]string `json:"params"`
+			Enabled   bool              `json:"enabled"`
+			Order     int               `json:"order"`
+		}
+		if !bindJSON(w, r, &req) {
+			return
+		}
+		hook := hooks.Hook{
+			Name:      name,
+			Transform: req.Transform,
+			Params:    req.Params,
+			Enabled:   req.Enabled,
+			Order:     req.Order,
+		}
+		if err := hook.Validate(); err != nil {
+			invalidField(w, "transform", schema.InBody, "%v", err)
+			return
+		}
+
+		var state hooksState
+		err := srv.Store.Update(hooksDoc, &state, func() error {
+			if state.Events == nil {
+				state.Events = map[string]map[string]hooks.Hook{}
+			}
+			if state.Events[event] == nil {
+				state.Events[event] = map[string]hooks.Hook{}
+			}
+			hook.Version = state.Events[event][name].Version + 1
+			state.Events[event][name] = hook
+			return nil
+		})
+		if err != nil {
+			http.Error(w, "Failed to save hook: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, hook)
+	}
+}
@@ -0,0 +1,404 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"time"
+
+	"shiba-api/schema"
+	"shiba-api/structs"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const judgingDoc = "judging"
+
+const (
+	minScore = 1
+	maxScore = 5
+)
+
+type JudgeScore struct {
+	Scores map[string]int `json:"scores"`
+	// Rubric is the rubric the score was given under, so changing
+	// JUDGING_RUBRIC later doesn't count its new categories as 0. Scores from
+	// before it was recorded use the current rubric.
+	Rubric    []string  `json:"rubric,omitempty"`
+	Notes     string    `json:"notes,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type JudgeConflict struct {
+	Reason    string    `json:"reason"`
+	FlaggedAt time.Time `json:"flaggedAt"`
+}
+
+type judgingState struct {
+	// Assignments maps judge user ID -> frozen build (game) IDs
+	Assignments map[string][]string `json:"assignments"`
+	// Scores maps game ID -> judge user ID -> score
+	Scores map[string]map[string]JudgeScore `json:"scores"`
+	// Conflicts maps game ID -> judge user ID -> conflict
+	Conflicts map[string]map[string]JudgeConflict `json:"conflicts"`
+}
+
+func (s *judgingState) init() {
+	if s.Assignments == nil {
+		s.Assignments = map[string][]string{}
+	}
+	if s.Scores == nil {
+		s.Scores = map[string]map[string]JudgeScore{}
+	}
+	if s.Conflicts == nil {
+		s.Conflicts = map[string]map[string]JudgeConflict{}
+	}
+}
+
+func (s *judgingState) isAssigned(judgeID, gameID string) bool {
+	for _, id := range s.Assignments[judgeID] {
+		if id == gameID {
+			return true
+		}
+	}
+	return false
+}
+
+// assignedBuilds returns the IDs of every build assigned to a judge. They're
+// frozen: they can't be deleted until they're unassigned.
+func assignedBuilds(srv *structs.Server) (map[string]bool, error) {
+	var state judgingState
+	if err := srv.Store.Load(judgingDoc, &state); err != nil {
+		return nil, err
+	}
+	assigned := map[string]bool{}
+	for _, ids := range state.Assignments {
+		for _, id := range ids {
+			assigned[id] = true
+		}
+	}
+	return assigned, nil
+}
+
+// AssignJudgeBuildsHandler lets admins assign frozen builds to a judge. Only
+// published builds can be assigned.
+func AssignJudgeBuildsHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(srv, r) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req struct {
+			JudgeID string   `json:"judgeId" validate:"required"`
+			GameIDs []string `json:"gameIds"`
+		}
+		if !bindJSON(w, r, &req) {
+			return
+		}
+
+		builds, err := loadBuilds(srv)
+		if err != nil {
+			http.Error(w, "Failed to load builds: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var errs schema.Errors
+		for i, id := range req.GameIDs {
+			if b, ok := builds.Builds[id]; ok && b.Draft {
+				errs.Add(fmt.Sprintf("gameIds[%d]", i), schema.InBody, "is a draft")
+			} else if _, ok := publishedBuild(&builds, id); !ok {
+				errs.Add(fmt.Sprintf("gameIds[%d]", i), schema.InBody, "is not a build")
+			}
+		}
+		if len(errs) > 0 {
+			writeInvalid(w, errs)
+			return
+		}
+
+		var state judgingState
+		err = srv.Store.Update(judgingDoc, &state, func() error {
+			state.init()
+			state.Assignments[req.JudgeID] = req.GameIDs
+			return nil
+		})
+		if err != nil {
+			http.Error(w, "Failed to save assignments: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, struct {
+			Ok      bool     `json:"ok"`
+			JudgeID string   `json:"judgeId"`
+			GameIDs []string `json:"gameIds"`
+		}{true, req.JudgeID, req.GameIDs})
+	}
+}
+
+// JudgeBuildsHandler lists the builds assigned to the calling judge.
+func JudgeBuildsHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := requireRole(srv, w, r, "reviewer")
+		if !ok {
+			return
+		}
+		if user == nil {
+			http.Error(w, "Judges must use their own token", http.StatusForbidden)
+			return
+		}
+
+		var state judgingState
+		if err := srv.Store.Load(judgingDoc, &state); err != nil {
+			http.Error(w, "Failed to load judging data: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		state.init()
+
+		type build struct {
+			GameID   string         `json:"gameId"`
+			PlayURL  string         `json:"playUrl"`
+			Scored   bool           `json:"scored"`
+			Conflict *JudgeConflict `json:"conflict,omitempty"`
+			Score    *JudgeScore    `json:"score,omitempty"`
+		}
+
+		builds := []build{}
+		for _, gameID := range state.Assignments[user.ID] {
+			b := build{GameID: gameID, PlayURL: "/play/" + gameID + "/"}
+			if score, ok := state.Scores[gameID][user.ID]; ok {
+				b.Scored = true
+				b.Score = &score
+			}
+			if conflict, ok := state.Conflicts[gameID][user.ID]; ok {
+				b.Conflict = &conflict
+			}
+			builds = append(builds, b)
+		}
+
+		writeJSON(w, http.StatusOK, struct {
+			Rubric []string `json:"rubric"`
+			Builds []build  `json:"builds"`
+		}{srv.Config.Get().JudgingRubric, builds})
+	}
+}
+
+// ScoreBuildHandler records the calling judge's rubric scores for a build.
+func ScoreBuildHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := requireRole(srv, w, r, "reviewer")
+		if !ok {
+			return
+		}
+		if user == nil {
+			http.Error(w, "Judges must use their own token", http.StatusForbidden)
+			return
+		}
+
+		gameID := chi.URLParam(r, "gameId")
+
+		var req struct {
+			Scores map[string]int `json:"scores" validate:"required"`
+			Notes  string         `json:"notes" validate:"max=5000"`
+		}
+		if !bindJSON(w, r, &req) {
+			return
+		}
+
+		rubric := srv.Config.Get().JudgingRubric
+		var errs schema.Errors
+		for _, category := range rubric {
+			score, ok := req.Scores[category]
+			if !ok {
+				errs.Add("scores."+category, schema.InBody, "is required")
+			} else if score < minScore || score > maxScore {
+				errs.Add("scores."+category, schema.InBody, "must be between %d and %d", minScore, maxScore)
+			}
+		}
+		for category := range req.Scores {
+			if !slices.Contains(rubric, category) {
+				errs.Add("scores."+category, schema.InBody, "is not a rubric category")
+			}
+		}
+		if len(errs) > 0 {
+			writeInvalid(w, errs)
+			return
+		}
+
+		var state judgingState
+		var status int
+		err := srv.Store.Update(judgingDoc, &state, func() error {
+			state.init()
+			if !state.isAssigned(user.ID, gameID) {
+				status = http.StatusForbidden
+				return fmt.Errorf("build %s is not assigned to you", gameID)
+			}
+			if _, ok := state.Conflicts[gameID][user.ID]; ok {
+				status = http.StatusConflict
+				return fmt.Errorf("you flagged a conflict of interest for build %s", gameID)
+			}
+			if state.Scores[gameID] == nil {
+				state.Scores[gameID] = map[string]JudgeScore{}
+			}
+			state.Scores[gameID][user.ID] = JudgeScore{
+				Scores:    req.Scores,
+				Rubric:    rubric,
+				Notes:     req.Notes,
+				UpdatedAt: time.Now().UTC(),
+			}
+			return nil
+		})
+		if err != nil {
+			if status == 0 {
+				status = http.StatusInternalServerError
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, struct {
+			Ok     bool   `json:"ok"`
+			GameID string `json:"gameId"`
+		}{true, gameID})
+	}
+}
+
+// ConflictHandler flags a conflict of interest between the calling judge and a
+// build. Conflicted judges can no longer score it and any existing score is
+// dropped from the rankings.
+func ConflictHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := requireRole(srv, w, r, "reviewer")
+		if !ok {
+			return
+		}
+		if user == nil {
+			http.Error(w, "Judges must use their own token", http.StatusForbidden)
+			return
+		}
+
+		gameID := chi.URLParam(r, "gameId")
+
+		var req struct {
+			Reason string `json:"reason" validate:"max=1000"`
+		}
+		if !bindJSON(w, r, &req) {
+			return
+		}
+
+		var state judgingState
+		err := srv.Store.Update(judgingDoc, &state, func() error {
+			state.init()
+			if state.Conflicts[gameID] == nil {
+				state.Conflicts[gameID] = map[string]JudgeConflict{}
+			}
+			state.Conflicts[gameID][user.ID] = JudgeConflict{
+				Reason:    req.Reason,
+				FlaggedAt: time.Now().UTC(),
+			}
+			delete(state.Scores[gameID], user.ID)
+			return nil
+		})
+		if err != nil {
+			http.Error(w, "Failed to flag conflict: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, struct {
+			Ok     bool   `json:"ok"`
+			GameID string `json:"gameId"`
+		}{true, gameID})
+	}
+}
+
+type Ranking struct {
+	Rank       int                `json:"rank"`
+	Tied       bool               `json:"tied"`
+	GameID     string             `json:"gameId"`
+	Overall    float64            `json:"overall"`
+	Categories map[string]float64 `json:"categories"`
+	Judges     int                `json:"judges"`
+}
+
+// computeRankings averages every judge's scores per build and ranks builds by
+// overall average. Each score counts against the rubric it was given under:
+// a judge's overall is the average of their categories, and a category's
+// average only counts the judges who scored it. Builds with equal averages
+// share a rank and the next rank is skipped (1, 2, 2, 4).
+func computeRankings(state judgingState, rubric []string) []Ranking {
+	rankings := []Ranking{}
+	for gameID, byJudge := range state.Scores {
+		if len(byJudge) == 0 {
+			continue
+		}
+
+		totals := map[string]float64{}
+		counts := map[string]int{}
+		rk := Ranking{GameID: gameID, Categories: map[string]float64{}, Judges: len(byJudge)}
+		for _, score := range byJudge {
+			scored := score.Rubric
+			if len(scored) == 0 {
+				scored = rubric
+			}
+			var overall float64
+			for _, category := range scored {
+				totals[category] += float64(score.Scores[category])
+				counts[category]++
+				overall += float64(score.Scores[category])
+			}
+			if len(scored) > 0 {
+				rk.Overall += overall / float64(len(scored))
+			}
+		}
+		for category, total := range totals {
+			rk.Categories[category] = total / float64(counts[category])
+		}
+		rk.Overall /= float64(len(byJudge))
+		// Round so builds with equal scores compare equal despite float error
+		rk.Overall = math.Round(rk.Overall*1e4) / 1e4
+		rankings = append(rankings, rk)
+	}
+
+	sort.Slice(rankings, func(i, j int) bool {
+		if rankings[i].Overall != rankings[j].Overall {
+			return rankings[i].Overall > rankings[j].Overall
+		}
+		return rankings[i].GameID < rankings[j].GameID
+	})
+
+	for i := range rankings {
+		if i > 0 && rankings[i].Overall == rankings[i-1].Overall {
+			rankings[i].Rank = rankings[i-1].Rank
+			rankings[i].Tied = true
+			rankings[i-1].Tied = true
+		} else {
+			rankings[i].Rank = i + 1
+		}
+	}
+	return rankings
+}
+
+// RankingsHandler returns aggregate rankings over all scored builds. Judges
+// don't see them, so they can't be swayed by each other's scores. Requires
+// the admin token.
+func RankingsHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(srv, r) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var state judgingState
+		if err := srv.Store.Load(judgingDoc, &state); err != nil {
+			http.Error(w, "Failed to load judging data: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		state.init()
+
+		rubric := srv.Config.Get().JudgingRubric
+		writeJSON(w, http.StatusOK, struct {
+			Rubric   []string  `json:"rubric"`
+			Rankings []Ranking `json:"rankings"`
+		}{rubric, computeRankings(state, rubric)})
+	}
+}
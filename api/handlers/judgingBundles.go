@@ -0,0 +1,507 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"shiba-api/schema"
+	"shiba-api/structs"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const judgingBundlesDoc = "judging-bundles"
+
+// Bundle zips are kept here until the judge requests a new one
+const judgingBundlesDir = "./judging-bundles"
+
+// Bundles still building after this long are given up on
+const judgingBundleTimeout = time.Hour
+
+// Judging bundle statuses
+const (
+	BundleBuilding = "building"
+	BundleReady    = "ready"
+	BundleFailed   = "failed"
+)
+
+// JudgingBundle is a judge's assigned builds packaged for reviewing offline.
+type JudgingBundle struct {
+	ID      string `json:"id"`
+	JudgeID string `json:"judgeId"`
+	Status  string `json:"status"`
+	// LinksOnly bundles skip the build files and only link to the builds
+	LinksOnly bool `json:"linksOnly"`
+	Builds    int  `json:"builds"`
+	// Missing lists builds whose files weren't on disk, e.g. archived ones;
+	// the index still links to them
+	Missing    []string   `json:"missing,omitempty"`
+	Bytes      int64      `json:"bytes,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	Error      string     `json:"error,omitempty"`
+}
+
+type judgingBundlesState struct {
+	// Bundles maps bundle ID -> bundle; judges have one bundle at most
+	Bundles map[string]JudgingBundle `json:"bundles"`
+}
+
+type judgingBundleRequest struct {
+	// JudgeID picks the judge when the admin token builds a bundle for them
+	JudgeID   string `json:"judgeId"`
+	LinksOnly bool   `json:"linksOnly"`
+}
+
+// judgingBundleAccepted points at the status of a bundle being built.
+type judgingBundleAccepted struct {
+	JudgingBundle
+	StatusURL string `json:"statusUrl"`
+}
+
+// bundleEntry is a build as listed in a bundle.
+type bundleEntry struct {
+	GameID    string
+	Name      string
+	PlayURL   string
+	LocalURL  string
+	Score     *JudgeScore
+	Conflict  *JudgeConflict
+	localPath string
+}
+
+func judgingBundlePath(id string) string {
+	return filepath.Join(judgingBundlesDir, id+".zip")
+}
+
+// CreateJudgingBundleHandler starts packaging the calling judge's assigned
+// builds into a zip they can review without a connection: an index page
+// linking to each build's frozen URL and its offline copy, the build files,
+// and a rubric CSV to fill in. The zip is built in the background; poll
+// JudgingBundleHandler until it is ready. The admin token builds bundles for
+// any judge, e.g. external judges behind firewalls.
+func CreateJudgingBundleHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := requireRole(srv, w, r, "reviewer")
+		if !ok {
+			return
+		}
+
+		var req judgingBundleRequest
+		if !bindJSON(w, r, &req) {
+			return
+		}
+		judgeID := req.JudgeID
+		if user != nil {
+			if judgeID != "" && judgeID != user.ID {
+				http.Error(w, "Judges can only bundle their own builds", http.StatusForbidden)
+				return
+			}
+			judgeID = user.ID
+		} else if judgeID == "" {
+			invalidField(w, "judgeId", schema.InBody, "is required with the admin token")
+			return
+		}
+
+		var judging judgingState
+		if err := srv.Store.Load(judgingDoc, &judging); err != nil {
+			http.Error(w, "Failed to load judging data: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		judging.init()
+		if len(judging.Assignments[judgeID]) == 0 {
+			http.Error(w, "No builds are assigned to this judge", http.StatusNotFound)
+			return
+		}
+
+		bundle := JudgingBundle{
+			ID:        newKioskID(),
+			JudgeID:   judgeID,
+			Status:    BundleBuilding,
+			LinksOnly: req.LinksOnly,
+			Builds:    len(judging.Assignments[judgeID]),
+			CreatedAt: time.Now().UTC(),
+		}
+		var replaced []string
+		var state judgingBundlesState
+		err := srv.Store.Update(judgingBundlesDoc, &state, func() error {
+			if state.Bundles == nil {
+				state.Bundles = map[string]JudgingBundle{}
+			}
+			for id, b := range state.Bundles {
+				if b.JudgeID != judgeID {
+					continue
+				}
+				// Bundles building for longer were cut short by a restart
+				if b.Status == BundleBuilding && time.Since(b.CreatedAt) < judgingBundleTimeout {
+					return errBundleInProgress
+				}
+				replaced = append(replaced, id)
+				delete(state.Bundles, id)
+			}
+			state.Bundles[bundle.ID] = bundle
+			return nil
+		})
+		if errors.Is(err, errBundleInProgress) {
+			http.Error(w, "A bundle is already being built for this judge", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to record bundle: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, id := range replaced {
+			if err := os.Remove(judgingBundlePath(id)); err != nil && !os.IsNotExist(err) {
+				slog.ErrorContext(r.Context(), "Failed to remove judging bundle", "bundle_id", id, "error", err)
+			}
+		}
+
+		baseURL := publicBaseURL(r)
+		rubric := srv.Config.Get().JudgingRubric
+		go func() {
+			ctx := context.Background()
+			missing, size, err := buildJudgingBundle(ctx, srv, bundle, judging, rubric, baseURL)
+			finishJudgingBundle(ctx, srv, bundle.ID, missing, size, err)
+		}()
+
+		w.Header().Set("Location", "/judging/bundles/"+bundle.ID)
+		writeJSON(w, http.StatusAccepted, judgingBundleAccepted{bundle, "/judging/bundles/" + bundle.ID})
+	}
+}
+
+var errBundleInProgress = errors.New("bundle already in progress")
+
+// JudgingBundleHandler reports the status of a bundle.
+func JudgingBundleHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		bundle, ok := requireJudgingBundle(srv, w, r)
+		if !ok {
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, http.StatusOK, bundle)
+	}
+}
+
+// DownloadJudgingBundleHandler serves a ready bundle's zip.
+func DownloadJudgingBundleHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		bundle, ok := requireJudgingBundle(srv, w, r)
+		if !ok {
+			return
+		}
+		if bundle.Status != BundleReady {
+			http.Error(w, "Bundle is "+bundle.Status, http.StatusConflict)
+			return
+		}
+
+		f, err := os.Open(judgingBundlePath(bundle.ID))
+		if err != nil {
+			http.Error(w, "Failed to open bundle: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer f.Close()
+
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", `attachment; filename="judging-`+bundle.CreatedAt.Format("2006-01-02")+`.zip"`)
+		w.Header().Set("Cache-Control", "no-store")
+		http.ServeContent(w, r, "", bundle.CreatedAt, f)
+	}
+}
+
+// requireJudgingBundle loads the bundle in the URL, which only its judge and
+// the admin token may see.
+func requireJudgingBundle(srv *structs.Server, w http.ResponseWriter, r *http.Request) (JudgingBundle, bool) {
+	user, ok := requireRole(srv, w, r, "reviewer")
+	if !ok {
+		return JudgingBundle{}, false
+	}
+
+	var state judgingBundlesState
+	if err := srv.Store.Load(judgingBundlesDoc, &state); err != nil {
+		http.Error(w, "Failed to load judging bundles: "+err.Error(), http.StatusInternalServerError)
+		return JudgingBundle{}, false
+	}
+	bundle, found := state.Bundles[chi.URLParam(r, "bundleId")]
+	if !found || (user != nil && bundle.JudgeID != user.ID) {
+		http.Error(w, "Bundle not found", http.StatusNotFound)
+		return JudgingBundle{}, false
+	}
+	return bundle, true
+}
+
+func finishJudgingBundle(ctx context.Context, srv *structs.Server, id string, missing []string, size int64, buildErr error) {
+	if buildErr != nil {
+		slog.ErrorContext(ctx, "Judging bundle failed", "bundle_id", id, "error", buildErr)
+	}
+	now := time.Now().UTC()
+	var state judgingBundlesState
+	err := srv.Store.Update(judgingBundlesDoc, &state, func() error {
+		b, ok := state.Bundles[id]
+		if !ok {
+			return nil // replaced meanwhile
+		}
+		b.FinishedAt = &now
+		if buildErr != nil {
+			b.Status, b.Error = BundleFailed, buildErr.Error()
+		} else {
+			b.Status, b.Missing, b.Bytes = BundleReady, missing, size
+		}
+		state.Bundles[id] = b
+		return nil
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to record judging bundle", "bundle_id", id, "error", err)
+	}
+}
+
+// buildJudgingBundle writes the bundle's zip next to its final path and moves
+// it there once complete, so downloads never see a partial zip. It returns
+// the builds whose files weren't available and the zip's size.
+func buildJudgingBundle(ctx context.Context, srv *structs.Server, bundle JudgingBundle, judging judgingState, rubric []string, baseURL string) ([]string, int64, error) {
+	builds, err := loadBuilds(srv)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var entries []bundleEntry
+	missing := []string{}
+	for _, gameID := range judging.Assignments[bundle.JudgeID] {
+		e := bundleEntry{GameID: gameID, Name: gameID, PlayURL: baseURL + "/play/" + gameID + "/"}
+		if b, ok := publishedBuild(&builds, gameID); ok {
+			e.PlayURL = baseURL + buildURL(b)
+			if b.ProjectID != "" {
+				e.Name = lookupProject(ctx, srv, b.ProjectID).name
+			}
+		}
+		if score, ok := judging.Scores[gameID][bundle.JudgeID]; ok {
+			e.Score = &score
+		}
+		if conflict, ok := judging.Conflicts[gameID][bundle.JudgeID]; ok {
+			e.Conflict = &conflict
+		}
+		if !bundle.LinksOnly {
+			dir := filepath.Join("./games", gameID)
+			if info, err := os.Stat(dir); !strings.ContainsAny(gameID, "./\\") && err == nil && info.IsDir() {
+				e.localPath = dir
+				e.LocalURL = offlineEntryURL(gameID, dir)
+			} else {
+				missing = append(missing, gameID)
+			}
+		}
+		entries = append(entries, e)
+	}
+
+	if err := os.MkdirAll(judgingBundlesDir, 0755); err != nil {
+		return nil, 0, err
+	}
+	dest := judgingBundlePath(bundle.ID)
+	tmp := dest + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return nil, 0, err
+	}
+	err = writeJudgingBundle(f, bundle, entries, rubric)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, dest)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return nil, 0, err
+	}
+	info, err := os.Stat(dest)
+	if err != nil {
+		return nil, 0, err
+	}
+	return missing, info.Size(), nil
+}
+
+// offlineEntryURL is the page of a build's offline copy the index links to:
+// its index.html for web builds, else the directory of the downloadable files.
+func offlineEntryURL(gameID, dir string) string {
+	if _, err := os.Stat(filepath.Join(dir, "index.html")); err == nil {
+		return "games/" + gameID + "/index.html"
+	}
+	return "games/" + gameID + "/"
+}
+
+func writeJudgingBundle(w io.Writer, bundle JudgingBundle, entries []bundleEntry, rubric []string) error {
+	zw := zip.NewWriter(w)
+	modified := bundle.CreatedAt
+
+	var page bytes.Buffer
+	err := judgingIndexPage.Execute(&page, struct {
+		CreatedAt time.Time
+		Rubric    []string
+		MinScore  int
+		MaxScore  int
+		Builds    []bundleEntry
+		LinksOnly bool
+	}{modified, rubric, minScore, maxScore, entries, bundle.LinksOnly})
+	if err != nil {
+		return err
+	}
+	if err := writeZipFile(zw, "index.html", modified, page.Bytes()); err != nil {
+		return err
+	}
+
+	var sheet bytes.Buffer
+	if err := writeRubricCSV(&sheet, entries, rubric); err != nil {
+		return err
+	}
+	if err := writeZipFile(zw, "rubric.csv", modified, sheet.Bytes()); err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if e.localPath == "" {
+			continue
+		}
+		if err := zipBuildDir(zw, e.localPath, path.Join("games", e.GameID)); err != nil {
+			return fmt.Errorf("failed to add build %s: %v", e.GameID, err)
+		}
+	}
+	return zw.Close()
+}
+
+func writeZipFile(zw *zip.Writer, name string, modified time.Time, data []byte) error {
+	dst, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modified})
+	if err != nil {
+		return err
+	}
+	_, err = dst.Write(data)
+	return err
+}
+
+// zipBuildDir copies a build's files under prefix. Symlinks are skipped,
+// like in the uploads themselves.
+func zipBuildDir(zw *zip.Writer, dir, prefix string) error {
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = path.Join(prefix, filepath.ToSlash(rel))
+		header.Method = zip.Deflate
+		dst, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		src, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		_, err = io.Copy(dst, src)
+		return err
+	})
+}
+
+// writeRubricCSV writes one row per build with a column per rubric category,
+// prefilled with the judge's scores so far.
+func writeRubricCSV(w io.Writer, entries []bundleEntry, rubric []string) error {
+	cw := csv.NewWriter(w)
+	header := append([]string{"gameId", "name", "playUrl"}, rubric...)
+	header = append(header, "notes", "conflict")
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		row := []string{e.GameID, csvCell(e.Name), e.PlayURL}
+		for _, category := range rubric {
+			cell := ""
+			if e.Score != nil {
+				if score, ok := e.Score.Scores[category]; ok {
+					cell = fmt.Sprint(score)
+				}
+			}
+			row = append(row, cell)
+		}
+		notes, conflict := "", ""
+		if e.Score != nil {
+			notes = csvCell(e.Score.Notes)
+		}
+		if e.Conflict != nil {
+			conflict = csvCell("yes: " + e.Conflict.Reason)
+		}
+		row = append(row, notes, conflict)
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// csvCell keeps creator-supplied text from being read as a formula by
+// spreadsheets.
+func csvCell(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// judgingIndexPage works from file:// without a connection: no external
+// styles, scripts or fonts.
+var judgingIndexPage = template.Must(template.New("judging").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Judging bundle</title>
+<style>
+body { font-family: sans-serif; max-width: 48rem; margin: 2rem auto; padding: 0 1rem; line-height: 1.4; }
+table { border-collapse: collapse; width: 100%; }
+th, td { border-bottom: 1px solid #ddd; padding: 0.5rem; text-align: left; vertical-align: top; }
+.conflict { color: #a00; }
+.note { background: #f4f4f4; padding: 0.75rem 1rem; border-radius: 0.5rem; }
+code { word-break: break-all; }
+</style>
+</head>
+<body>
+<h1>Judging bundle</h1>
+<p>Generated {{.CreatedAt.Format "2006-01-02 15:04 UTC"}}. Score every build from {{.MinScore}} to {{.MaxScore}} in each category:
+{{range $i, $c := .Rubric}}{{if $i}}, {{end}}<strong>{{$c}}</strong>{{end}}.
+Fill in <code>rubric.csv</code> as you go; scores you already submitted are prefilled. Submit them online once you're back.</p>
+{{if not .LinksOnly}}<p class="note">Some games won't start from a file:// page. If one doesn't, serve this folder locally, e.g. with
+<code>python3 -m http.server</code>, and open <code>http://localhost:8000</code>.</p>{{end}}
+<table>
+<tr><th>Build</th><th>Offline</th><th>Online</th></tr>
+{{range .Builds}}<tr>
+<td>{{.Name}}<br><small><code>{{.GameID}}</code></small>{{if .Conflict}}<br><span class="conflict">Conflict of interest flagged: {{.Conflict.Reason}}</span>{{end}}</td>
+<td>{{if .LocalURL}}<a href="{{.LocalURL}}">Play offline</a>{{else}}Not included{{end}}</td>
+<td><a href="{{.PlayURL}}">{{.PlayURL}}</a></td>
+</tr>
+{{end}}</table>
+</body>
+</html>
+`))
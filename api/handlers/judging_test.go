@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"shiba-api/config"
+	"shiba-api/store"
+)
+
+func TestComputeRankingsRubricChange(t *testing.T) {
+	oldRubric := []string{"fun", "art"}
+	newRubric := []string{"fun", "art", "audio"}
+	state := judgingState{Scores: map[string]map[string]JudgeScore{
+		// Scored before audio was added: still a 4 overall
+		"gOld": {"recJ1": {Scores: map[string]int{"fun": 4, "art": 4}, Rubric: oldRubric}},
+		"gNew": {"recJ1": {Scores: map[string]int{"fun": 4, "art": 4, "audio": 1}, Rubric: newRubric}},
+		// Scores from before rubrics were recorded use the current one
+		"gLegacy": {"recJ2": {Scores: map[string]int{"fun": 5, "art": 5, "audio": 5}}},
+		"gMixed": {
+			"recJ1": {Scores: map[string]int{"fun": 2, "art": 2}, Rubric: oldRubric},
+			"recJ2": {Scores: map[string]int{"fun": 4, "art": 4, "audio": 4}, Rubric: newRubric},
+		},
+	}}
+
+	rankings := computeRankings(state, newRubric)
+	byGame := map[string]Ranking{}
+	for _, rk := range rankings {
+		byGame[rk.GameID] = rk
+	}
+	want := map[string]float64{"gLegacy": 5, "gOld": 4, "gNew": 3, "gMixed": 3}
+	for game, overall := range want {
+		if got := byGame[game].Overall; got != overall {
+			t.Errorf("%s overall = %v, want %v", game, got, overall)
+		}
+	}
+	if _, ok := byGame["gOld"].Categories["audio"]; ok {
+		t.Error("gOld has an audio average though nobody scored it")
+	}
+	if got := byGame["gMixed"].Categories["audio"]; got != 4 {
+		t.Errorf("gMixed audio = %v, want 4, the one judge who scored it", got)
+	}
+	if rankings[0].GameID != "gLegacy" || rankings[0].Rank != 1 {
+		t.Errorf("first = %s rank %d, want gLegacy rank 1", rankings[0].GameID, rankings[0].Rank)
+	}
+}
+
+func TestAssignJudgeBuildsValidates(t *testing.T) {
+	srv, _ := testServer(nil)
+	srv.AdminToken = "s3cret"
+	files, err := store.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Store = files
+	cfg, err := config.FromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Config = config.NewHolder(cfg)
+	state := buildsState{Builds: map[string]Build{
+		"gPublished": {ID: "gPublished", ProjectID: "recP"},
+		"gDraft":     {ID: "gDraft", ProjectID: "recP", Draft: true},
+	}}
+	if err := srv.Store.Save(buildsDoc, state); err != nil {
+		t.Fatal(err)
+	}
+	// Builds from before build records were kept only exist on disk
+	dir := t.TempDir()
+	wd, _ := os.Getwd()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	if err := os.MkdirAll(filepath.Join("games", "gLegacy"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"published", `{"judgeId": "recJ", "gameIds": ["gPublished", "gLegacy"]}`, http.StatusOK},
+		{"draft", `{"judgeId": "recJ", "gameIds": ["gPublished", "gDraft"]}`, http.StatusUnprocessableEntity},
+		{"unknown", `{"judgeId": "recJ", "gameIds": ["gMadeUp"]}`, http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/judging/assignments", strings.NewReader(tt.body))
+			r.Header.Set("Authorization", "Bearer s3cret")
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			AssignJudgeBuildsHandler(srv)(w, r)
+			if w.Code != tt.status {
+				t.Errorf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+
+	assigned, err := assignedBuilds(srv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !assigned["gPublished"] || !assigned["gLegacy"] || assigned["gDraft"] {
+		t.Errorf("assigned = %v, want only the published builds", assigned)
+	}
+}
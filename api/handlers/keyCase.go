@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"shiba-api/structs"
+)
+
+// KeyCaseLower marks the manifest of a build whose paths were lowercased
+const KeyCaseLower = "lower"
+
+// normalizeKeyCase lowercases the paths of an extracted build, so assets
+// referenced with another case than their file, which load on a
+// case-insensitive dev machine, load from R2 as well once requests are
+// lowercased too. It returns the original path of every renamed file, by
+// new path. Files differing only in case can't both be kept, so they reject
+// the build.
+func normalizeKeyCase(destDir string) (map[string]string, error) {
+	var files []string
+	err := filepath.Walk(destDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(destDir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		os.RemoveAll(destDir)
+		return nil, newUploadError(http.StatusInternalServerError, "Failed to list build files: "+err.Error())
+	}
+	sort.Strings(files)
+
+	renamed := map[string]string{}
+	seen := map[string]string{}
+	for _, rel := range files {
+		lower := strings.ToLower(rel)
+		if other, ok := seen[lower]; ok {
+			os.RemoveAll(destDir)
+			return nil, &uploadError{
+				status: http.StatusUnprocessableEntity,
+				msg:    fmt.Sprintf("Build rejected: %s and %s only differ in case, rename one of them", other, rel),
+				code:   "case_conflict",
+			}
+		}
+		seen[lower] = rel
+		if lower != rel {
+			renamed[lower] = rel
+		}
+	}
+
+	for lower, rel := range renamed {
+		dst := filepath.Join(destDir, filepath.FromSlash(lower))
+		err := os.MkdirAll(filepath.Dir(dst), 0755)
+		if err == nil {
+			err = os.Rename(filepath.Join(destDir, filepath.FromSlash(rel)), dst)
+		}
+		if err != nil {
+			os.RemoveAll(destDir)
+			return nil, newUploadError(http.StatusInternalServerError, "Failed to rename "+rel+": "+err.Error())
+		}
+	}
+	removeEmptyDirs(destDir)
+	return renamed, nil
+}
+
+// removeEmptyDirs removes the directories under root left empty by moving
+// their files out, deepest first.
+func removeEmptyDirs(root string) {
+	var dirs []string
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.IsDir() && path != root {
+			dirs = append(dirs, path)
+		}
+		return nil
+	})
+	for i := len(dirs) - 1; i >= 0; i-- {
+		// Fails, as it should, on directories that still have files
+		os.Remove(dirs[i])
+	}
+}
+
+// caseFoldedAsset returns where an asset of a build is served from: as
+// requested if it's on disk, lowercased if it isn't and the build's paths
+// were lowercased.
+func caseFoldedAsset(srv *structs.Server, gameID, assetPath string) string {
+	path := "./games/" + gameID + "/" + assetPath
+	lower := strings.ToLower(assetPath)
+	if lower == assetPath {
+		return path
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		return path
+	}
+	manifest, err := loadBuildManifest(srv, gameID)
+	if err != nil || manifest == nil || manifest.KeyCase != KeyCaseLower {
+		return path
+	}
+	return "./games/" + gameID + "/" + lower
+}
@@ -0,0 +1,330 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	mrand "math/rand/v2"
+	"net/http"
+	"time"
+
+	"shiba-api/structs"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const kioskDoc = "kiosk"
+
+const defaultKioskIdleTimeout = 120
+
+var errPlaylistNotFound = errors.New("playlist not found")
+
+// Playlist is a curated list of projects shown on demo stations.
+type Playlist struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name" validate:"required,max=200"`
+	ProjectIDs []string `json:"projectIds" validate:"required"`
+	// After this many seconds without input a kiosk goes back to the start
+	IdleTimeoutSeconds int       `json:"idleTimeoutSeconds"`
+	UpdatedAt          time.Time `json:"updatedAt"`
+}
+
+// kiosk is the playback state of one demo station.
+type kiosk struct {
+	PlaylistID string `json:"playlistId"`
+	// Order indexes into the playlist; shuffling permutes it
+	Order        []int     `json:"order"`
+	Position     int       `json:"position"`
+	LastActivity time.Time `json:"lastActivity"`
+}
+
+type kioskState struct {
+	Playlists map[string]Playlist `json:"playlists"`
+	// Kiosks maps kiosk token hash -> state
+	Kiosks map[string]*kiosk `json:"kiosks"`
+}
+
+func (s *kioskState) init() {
+	if s.Playlists == nil {
+		s.Playlists = map[string]Playlist{}
+	}
+	if s.Kiosks == nil {
+		s.Kiosks = map[string]*kiosk{}
+	}
+}
+
+func hashKioskToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func sequentialOrder(n int) []int {
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	return order
+}
+
+// validatePlaylist checks every project has at least one build to show.
+func validatePlaylist(srv *structs.Server, p *Playlist) error {
+	if p.IdleTimeoutSeconds == 0 {
+		p.IdleTimeoutSeconds = defaultKioskIdleTimeout
+	}
+	if p.IdleTimeoutSeconds < 10 {
+		return errors.New("idleTimeoutSeconds must be at least 10")
+	}
+	builds, err := loadBuilds(srv)
+	if err != nil {
+		return err
+	}
+	for _, id := range p.ProjectIDs {
+		if len(builds.projectBuilds(id)) == 0 {
+			return fmt.Errorf("project %s has no builds", id)
+		}
+	}
+	return nil
+}
+
+// SavePlaylistHandler creates a playlist (POST) or replaces one (PUT with an
+// ID in the path). Kiosks playing a replaced playlist restart from the top.
+// Requires the admin token.
+func SavePlaylistHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(srv, r) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var p Playlist
+		if !bindJSON(w, r, &p) {
+			return
+		}
+		if err := validatePlaylist(srv, &p); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		p.ID = chi.URLParam(r, "playlistId")
+		creating := p.ID == ""
+		if creating {
+			p.ID = newKioskID()
+		}
+		p.UpdatedAt = time.Now().UTC()
+
+		var state kioskState
+		err := srv.Store.Update(kioskDoc, &state, func() error {
+			state.init()
+			if _, ok := state.Playlists[p.ID]; !ok && !creating {
+				return errPlaylistNotFound
+			}
+			state.Playlists[p.ID] = p
+			for _, k := range state.Kiosks {
+				if k.PlaylistID == p.ID {
+					k.Order = sequentialOrder(len(p.ProjectIDs))
+					k.Position = 0
+				}
+			}
+			return nil
+		})
+		if errors.Is(err, errPlaylistNotFound) {
+			http.Error(w, "Playlist not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to save playlist: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, p)
+	}
+}
+
+func newKioskID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// CreateKioskTokenHandler issues a token for a demo station playing a
+// playlist. The token is only shown once. Requires the admin token.
+func CreateKioskTokenHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(srv, r) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		playlistID := chi.URLParam(r, "playlistId")
+		b := make([]byte, 24)
+		if _, err := rand.Read(b); err != nil {
+			http.Error(w, "Failed to generate token: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		token := "kiosk_" + hex.EncodeToString(b)
+
+		var state kioskState
+		err := srv.Store.Update(kioskDoc, &state, func() error {
+			state.init()
+			p, ok := state.Playlists[playlistID]
+			if !ok {
+				return errPlaylistNotFound
+			}
+			state.Kiosks[hashKioskToken(token)] = &kiosk{
+				PlaylistID:   playlistID,
+				Order:        sequentialOrder(len(p.ProjectIDs)),
+				LastActivity: time.Now().UTC(),
+			}
+			return nil
+		})
+		if errors.Is(err, errPlaylistNotFound) {
+			http.Error(w, "Playlist not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to save kiosk: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, struct {
+			Token string `json:"token"`
+		}{token})
+	}
+}
+
+type kioskSlide struct {
+	PlaylistID         string `json:"playlistId"`
+	Position           int    `json:"position"`
+	Total              int    `json:"total"`
+	ProjectID          string `json:"projectId"`
+	GameID             string `json:"gameId"`
+	URL                string `json:"url"`
+	IdleTimeoutSeconds int    `json:"idleTimeoutSeconds"`
+	Shuffled           bool   `json:"shuffled"`
+}
+
+// kioskAction applies move (nil to only read) to a kiosk and returns what it
+// should show. A kiosk idle for longer than the playlist's timeout is first reset to
+// the top, so the next visitor starts from the beginning.
+func kioskAction(srv *structs.Server, w http.ResponseWriter, r *http.Request, move func(k *kiosk, n int)) {
+	token := bearerToken(r)
+	if token == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	builds, err := loadBuilds(srv)
+	if err != nil {
+		http.Error(w, "Failed to load builds: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var slide kioskSlide
+	var state kioskState
+	err = srv.Store.Update(kioskDoc, &state, func() error {
+		state.init()
+		k, ok := state.Kiosks[hashKioskToken(token)]
+		if !ok {
+			return errUnauthorized
+		}
+		p, ok := state.Playlists[k.PlaylistID]
+		if !ok || len(p.ProjectIDs) == 0 {
+			return errPlaylistNotFound
+		}
+		n := len(p.ProjectIDs)
+		if len(k.Order) != n {
+			k.Order = sequentialOrder(n)
+		}
+
+		now := time.Now().UTC()
+		if now.Sub(k.LastActivity) > time.Duration(p.IdleTimeoutSeconds)*time.Second {
+			k.Order = sequentialOrder(n)
+			k.Position = 0
+		}
+		// Polling the current game isn't player input and must not keep the
+		// kiosk from going idle
+		if move != nil {
+			move(k, n)
+			k.LastActivity = now
+		}
+
+		projectID := p.ProjectIDs[k.Order[k.Position]]
+		slide = kioskSlide{
+			PlaylistID:         p.ID,
+			Position:           k.Position,
+			Total:              n,
+			ProjectID:          projectID,
+			IdleTimeoutSeconds: p.IdleTimeoutSeconds,
+		}
+		for i, idx := range k.Order {
+			if i != idx {
+				slide.Shuffled = true
+				break
+			}
+		}
+		if current, ok := builds.currentBuild(projectID); ok {
+			slide.GameID = current.ID
+			slide.URL = buildURL(current)
+		}
+		return nil
+	})
+	if errors.Is(err, errUnauthorized) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if errors.Is(err, errPlaylistNotFound) {
+		http.Error(w, "Playlist not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to update kiosk: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, slide)
+}
+
+// KioskCurrentHandler returns the game a kiosk should show.
+func KioskCurrentHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		kioskAction(srv, w, r, nil)
+	}
+}
+
+// KioskActivityHandler is pinged by the kiosk on player input to keep it from
+// resetting.
+func KioskActivityHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		kioskAction(srv, w, r, func(k *kiosk, n int) {})
+	}
+}
+
+// KioskNextHandler advances to the next game, wrapping around.
+func KioskNextHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		kioskAction(srv, w, r, func(k *kiosk, n int) {
+			k.Position = (k.Position + 1) % n
+		})
+	}
+}
+
+// KioskPrevHandler goes back to the previous game, wrapping around.
+func KioskPrevHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		kioskAction(srv, w, r, func(k *kiosk, n int) {
+			k.Position = (k.Position + n - 1) % n
+		})
+	}
+}
+
+// KioskShuffleHandler reshuffles the playlist and starts from its new top.
+func KioskShuffleHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		kioskAction(srv, w, r, func(k *kiosk, n int) {
+			mrand.Shuffle(n, func(i, j int) { k.Order[i], k.Order[j] = k.Order[j], k.Order[i] })
+			k.Position = 0
+		})
+	}
+}
@@ -0,0 +1,364 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"shiba-api/quota"
+	"shiba-api/schema"
+	"shiba-api/structs"
+)
+
+// leaderboardsDoc holds every project's leaderboard settings; the scores of
+// each board get their own document, leaderboardDoc
+const leaderboardsDoc = "leaderboards"
+
+func leaderboardDoc(projectID string) string {
+	return "leaderboard-" + projectID
+}
+
+const leaderboardKeyPrefix = "lb_"
+
+// A board keeps each player's best score, up to maxLeaderboardEntries
+// players; past that a new player has to beat the last one
+const maxLeaderboardEntries = 10000
+
+// A player can submit a score to a board once per scoreInterval
+const scoreInterval = 5 * time.Second
+
+// Leaderboard is how a project's board ranks scores.
+type Leaderboard struct {
+	// Order is "desc" when higher scores are better, "asc" when lower ones
+	// are (times, strokes)
+	Order string `json:"order"`
+	// Scores outside MinScore..MaxScore are rejected
+	MinScore  *int64    `json:"minScore,omitempty"`
+	MaxScore  *int64    `json:"maxScore,omitempty"`
+	KeyHash   string    `json:"keyHash"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type leaderboardsState struct {
+	// Projects maps project ID -> board
+	Projects map[string]Leaderboard `json:"projects"`
+}
+
+// LeaderboardEntry is a player's best score.
+type LeaderboardEntry struct {
+	PlayerID    string    `json:"-"`
+	Name        string    `json:"name"`
+	Score       int64     `json:"score"`
+	SubmittedAt time.Time `json:"submittedAt"`
+}
+
+type leaderboardScores struct {
+	// Players maps player ID -> best entry
+	Players map[string]LeaderboardEntry `json:"players"`
+}
+
+func (b Leaderboard) better(a, c int64) bool {
+	if b.Order == "asc" {
+		return a < c
+	}
+	return a > c
+}
+
+// ranked sorts the entries best first, earlier submissions first on ties.
+func (b Leaderboard) ranked(s leaderboardScores) []LeaderboardEntry {
+	entries := make([]LeaderboardEntry, 0, len(s.Players))
+	for id, e := range s.Players {
+		e.PlayerID = id
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Score != entries[j].Score {
+			return b.better(entries[i].Score, entries[j].Score)
+		}
+		return entries[i].SubmittedAt.Before(entries[j].SubmittedAt)
+	})
+	return entries
+}
+
+func loadLeaderboard(srv *structs.Server, projectID string) (Leaderboard, bool, error) {
+	var state leaderboardsState
+	err := srv.Store.Load(leaderboardsDoc, &state)
+	board, ok := state.Projects[projectID]
+	return board, ok, err
+}
+
+type updateLeaderboardRequest struct {
+	Order     string `json:"order" validate:"oneof=desc asc"`
+	MinScore  *int64 `json:"minScore"`
+	MaxScore  *int64 `json:"maxScore"`
+	RotateKey bool   `json:"rotateKey"`
+}
+
+type leaderboardResponse struct {
+	ProjectID string    `json:"projectId"`
+	Order     string    `json:"order"`
+	MinScore  *int64    `json:"minScore,omitempty"`
+	MaxScore  *int64    `json:"maxScore,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	// Key is only returned when the board is created or its key rotated
+	Key string `json:"key,omitempty"`
+}
+
+// UpdateLeaderboardHandler creates or changes a project's leaderboard. The
+// key games submit scores with is returned when the board is created and
+// when rotateKey is set, and never again.
+func UpdateLeaderboardHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		projectID, ok := gameProject(srv, w, r)
+		if !ok || !requireProjectOwner(srv, w, r, projectID) {
+			return
+		}
+		req := updateLeaderboardRequest{Order: "desc"}
+		if !bindJSON(w, r, &req) {
+			return
+		}
+		if req.MinScore != nil && req.MaxScore != nil && *req.MinScore > *req.MaxScore {
+			invalidField(w, "maxScore", schema.InBody, "must be at least minScore")
+			return
+		}
+
+		key := ""
+		var board Leaderboard
+		var state leaderboardsState
+		err := srv.Store.Update(leaderboardsDoc, &state, func() error {
+			if state.Projects == nil {
+				state.Projects = map[string]Leaderboard{}
+			}
+			existing, found := state.Projects[projectID]
+			board = Leaderboard{Order: req.Order, MinScore: req.MinScore, MaxScore: req.MaxScore,
+				KeyHash: existing.KeyHash, CreatedAt: existing.CreatedAt}
+			if !found {
+				board.CreatedAt = time.Now().UTC()
+			}
+			if !found || req.RotateKey {
+				b := make([]byte, 16)
+				if _, err := rand.Read(b); err != nil {
+					return err
+				}
+				key = leaderboardKeyPrefix + hex.EncodeToString(b)
+				board.KeyHash = hashKioskToken(key)
+			}
+			state.Projects[projectID] = board
+			return nil
+		})
+		if err != nil {
+			http.Error(w, "Failed to save leaderboard: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, http.StatusOK, leaderboardResponse{projectID, board.Order, board.MinScore, board.MaxScore, board.CreatedAt, key})
+	}
+}
+
+type submitScoreRequest struct {
+	// PlayerID is a stable ID the game picks for the player, e.g. a random
+	// one kept in localStorage; defaults to the name
+	PlayerID string `json:"playerId" validate:"max=64"`
+	Name     string `json:"name" validate:"required,max=32"`
+	Score    *int64 `json:"score" validate:"required"`
+}
+
+// lastScore remembers when each player last submitted to each board
+var lastScore = struct {
+	sync.Mutex
+	at map[string]time.Time
+}{at: map[string]time.Time{}}
+
+// scoreTooSoon reports whether a player submitted to the board less than
+// scoreInterval ago, and records the submission otherwise.
+func scoreTooSoon(projectID, playerID string, now time.Time) bool {
+	lastScore.Lock()
+	defer lastScore.Unlock()
+	if len(lastScore.at) > maxLeaderboardEntries {
+		for key, at := range lastScore.at {
+			if now.Sub(at) >= scoreInterval {
+				delete(lastScore.at, key)
+			}
+		}
+	}
+	key := projectID + "\x00" + playerID
+	if at, ok := lastScore.at[key]; ok && now.Sub(at) < scoreInterval {
+		return true
+	}
+	lastScore.at[key] = now
+	return false
+}
+
+// cleanPlayerName trims a display name and drops control characters.
+func cleanPlayerName(name string) string {
+	return strings.TrimSpace(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name))
+}
+
+type submitScoreResponse struct {
+	// Best is whether the score is the player's best, and so on the board
+	Best  bool             `json:"best"`
+	Rank  int              `json:"rank"`
+	Entry LeaderboardEntry `json:"entry"`
+}
+
+// SubmitScoreHandler records a player's score, keeping only their best.
+// Games send the board's key, which is public once shipped in a build, so
+// submissions are also limited per IP and per player, and must fall in the
+// board's score range.
+func SubmitScoreHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		projectID, ok := gameProject(srv, w, r)
+		if !ok {
+			return
+		}
+		board, found, err := loadLeaderboard(srv, projectID)
+		if err != nil {
+			http.Error(w, "Failed to load leaderboard: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			http.Error(w, "This game has no leaderboard", http.StatusNotFound)
+			return
+		}
+		key := hashKioskToken(r.Header.Get("X-Leaderboard-Key"))
+		if subtle.ConstantTimeCompare([]byte(key), []byte(board.KeyHash)) != 1 {
+			http.Error(w, "Invalid leaderboard key", http.StatusUnauthorized)
+			return
+		}
+
+		var req submitScoreRequest
+		if !bindJSON(w, r, &req) {
+			return
+		}
+		req.Name = cleanPlayerName(req.Name)
+		if req.Name == "" {
+			invalidField(w, "name", schema.InBody, "must not be blank")
+			return
+		}
+		playerID := strings.TrimSpace(req.PlayerID)
+		if playerID == "" {
+			playerID = "name:" + strings.ToLower(req.Name)
+		}
+		score := *req.Score
+		if board.MinScore != nil && score < *board.MinScore || board.MaxScore != nil && score > *board.MaxScore {
+			invalidField(w, "score", schema.InBody, "is out of this leaderboard's range")
+			return
+		}
+
+		now := time.Now()
+		u, ok := srv.Quotas.Take(ipQuotaKey(r), quota.Scores, srv.Config.Get().QuotaLimits[quota.Scores], now)
+		setRateLimitHeaders(w, u)
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(u.Reset.Sub(now).Seconds())+1))
+			http.Error(w, "Daily scores quota exceeded", http.StatusTooManyRequests)
+			return
+		}
+		if scoreTooSoon(projectID, playerID, now) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(scoreInterval.Seconds())))
+			http.Error(w, "Scores are limited to one every "+scoreInterval.String()+" per player", http.StatusTooManyRequests)
+			return
+		}
+
+		entry := LeaderboardEntry{Name: req.Name, Score: score, SubmittedAt: now.UTC()}
+		var resp submitScoreResponse
+		var scores leaderboardScores
+		err = srv.Store.Update(leaderboardDoc(projectID), &scores, func() error {
+			if scores.Players == nil {
+				scores.Players = map[string]LeaderboardEntry{}
+			}
+			prev, seen := scores.Players[playerID]
+			switch {
+			case seen && !board.better(score, prev.Score):
+				// Keep the best score, but let players rename themselves
+				prev.Name = entry.Name
+				scores.Players[playerID] = prev
+				entry = prev
+			case !seen && len(scores.Players) >= maxLeaderboardEntries:
+				ranked := board.ranked(scores)
+				last := ranked[len(ranked)-1]
+				if !board.better(score, last.Score) {
+					return nil
+				}
+				delete(scores.Players, last.PlayerID)
+				fallthrough
+			default:
+				scores.Players[playerID] = entry
+				resp.Best = true
+			}
+			for i, e := range board.ranked(scores) {
+				if e.PlayerID == playerID {
+					resp.Rank = i + 1
+					break
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			http.Error(w, "Failed to save score: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		resp.Entry = entry
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+type rankedEntry struct {
+	Rank int `json:"rank"`
+	LeaderboardEntry
+}
+
+// LeaderboardHandler returns the top of a project's leaderboard.
+func LeaderboardHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := struct {
+			Limit int `query:"limit" validate:"min=1,max=100"`
+		}{Limit: 10}
+		if !bindQuery(w, r, &query) {
+			return
+		}
+		projectID, ok := gameProject(srv, w, r)
+		if !ok {
+			return
+		}
+		board, found, err := loadLeaderboard(srv, projectID)
+		if err != nil {
+			http.Error(w, "Failed to load leaderboard: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			http.Error(w, "This game has no leaderboard", http.StatusNotFound)
+			return
+		}
+		var scores leaderboardScores
+		if err := srv.Store.Load(leaderboardDoc(projectID), &scores); err != nil {
+			http.Error(w, "Failed to load leaderboard: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		ranked := board.ranked(scores)
+		entries := make([]rankedEntry, 0, min(query.Limit, len(ranked)))
+		for i, e := range ranked[:min(query.Limit, len(ranked))] {
+			entries = append(entries, rankedEntry{i + 1, e})
+		}
+		w.Header().Set("Cache-Control", "public, max-age=10")
+		writeJSON(w, http.StatusOK, struct {
+			ProjectID string        `json:"projectId"`
+			Order     string        `json:"order"`
+			Players   int           `json:"players"`
+			Entries   []rankedEntry `json:"entries"`
+		}{projectID, board.Order, len(ranked), entries})
+	}
+}
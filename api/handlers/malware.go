@@ -0,0 +1,248 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"shiba-api/events"
+	"shiba-api/malware"
+	"shiba-api/structs"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	quarantineDoc = "quarantine"
+	// Quarantined builds are moved here, out of ./games, so they're never
+	// served or synced
+	quarantineDir = "./quarantine"
+)
+
+// QuarantinedBuild is an upload held back because ClamAV matched its files,
+// or because some were too large to scan.
+type QuarantinedBuild struct {
+	GameID  string          `json:"gameId"`
+	OwnerID string          `json:"ownerId,omitempty"`
+	Matches []malware.Match `json:"matches"`
+	// Unscanned are the files over the scan limit, for builds held back
+	// without a match
+	Unscanned []string `json:"unscanned,omitempty"`
+	// Dir is where its files were moved
+	Dir string    `json:"dir"`
+	At  time.Time `json:"at"`
+	// What it was uploaded with, to publish it if it's released
+	ProjectID      string            `json:"projectId,omitempty"`
+	Changelog      string            `json:"changelog,omitempty"`
+	Engine         string            `json:"engine,omitempty"`
+	EngineVersion  string            `json:"engineVersion,omitempty"`
+	ListingType    string            `json:"listingType,omitempty"`
+	ArtifactSHA256 string            `json:"artifactSha256,omitempty"`
+	Draft          bool              `json:"draft,omitempty"`
+	Hooks          []string          `json:"hooks,omitempty"`
+	KeyCase        string            `json:"keyCase,omitempty"`
+	OriginalPaths  map[string]string `json:"originalPaths,omitempty"`
+	Provenance     Provenance        `json:"provenance"`
+}
+
+// meta is what the build was uploaded with.
+func (q QuarantinedBuild) meta() uploadMeta {
+	return uploadMeta{
+		projectID:      q.ProjectID,
+		changelog:      q.Changelog,
+		engine:         q.Engine,
+		engineVersion:  q.EngineVersion,
+		listingType:    q.ListingType,
+		artifactSHA256: q.ArtifactSHA256,
+		draft:          q.Draft,
+		hooks:          q.Hooks,
+		keyCase:        q.KeyCase,
+		originalPaths:  q.OriginalPaths,
+		provenance:     q.Provenance,
+	}
+}
+
+type quarantineState struct {
+	Builds []QuarantinedBuild `json:"builds"`
+}
+
+// scanBuild scans an extracted build with ClamAV when CLAMAV_ADDRESS is set.
+// A build matching a signature is quarantined, its uploader flagged and the
+// upload rejected. Builds with files too large to scan are quarantined for
+// review too, without flagging anyone, and uploads are rejected while clamd
+// can't be reached: nothing is published unscanned.
+func scanBuild(ctx context.Context, srv *structs.Server, gameID, ownerID, destDir string, meta uploadMeta) error {
+	cfg := srv.Config.Get()
+	if cfg.ClamAVAddress == "" {
+		return nil
+	}
+	clamd, err := malware.NewClamd(cfg.ClamAVAddress)
+	if err != nil {
+		os.RemoveAll(destDir)
+		return newUploadError(http.StatusInternalServerError, "Failed to scan build: "+err.Error())
+	}
+	matches, skipped, err := clamd.ScanDir(ctx, destDir, int64(cfg.ClamAVMaxFileMB)<<20)
+	if err != nil {
+		os.RemoveAll(destDir)
+		slog.ErrorContext(ctx, "Failed to scan build", "game_id", gameID, "error", err)
+		return newUploadError(http.StatusServiceUnavailable, "The malware scanner is unavailable, try again in a few minutes")
+	}
+	if len(matches) == 0 && len(skipped) == 0 {
+		emitEvent(ctx, srv, gameID, events.Scanned, ownerID, "")
+		return nil
+	}
+
+	q := QuarantinedBuild{
+		GameID:         gameID,
+		OwnerID:        ownerID,
+		Matches:        matches,
+		Unscanned:      skipped,
+		ProjectID:      meta.projectID,
+		Changelog:      meta.changelog,
+		Engine:         meta.engine,
+		EngineVersion:  meta.engineVersion,
+		ListingType:    meta.listingType,
+		ArtifactSHA256: meta.artifactSHA256,
+		Draft:          meta.draft,
+		Hooks:          meta.hooks,
+		KeyCase:        meta.keyCase,
+		OriginalPaths:  meta.originalPaths,
+		Provenance:     meta.provenance,
+	}
+	if q.Matches == nil {
+		q.Matches = []malware.Match{}
+	}
+	if err := quarantineBuild(srv, q, destDir); err != nil {
+		slog.ErrorContext(ctx, "Failed to quarantine build", "game_id", gameID, "error", err)
+		os.RemoveAll(destDir)
+	}
+	if len(matches) == 0 {
+		slog.WarnContext(ctx, "Build quarantined with files too large to scan", "game_id", gameID, "owner_id", ownerID, "files", skipped)
+		return &uploadError{
+			status: http.StatusUnprocessableEntity,
+			msg:    fmt.Sprintf("Build rejected: %s is over the %d MB malware scan limit, the build is held for review", skipped[0], cfg.ClamAVMaxFileMB),
+			code:   "unscanned_files",
+		}
+	}
+
+	malwareDetections.Inc()
+	slog.WarnContext(ctx, "Malware found in build", "game_id", gameID, "owner_id", ownerID, "matches", matches)
+	if ownerID != "" {
+		reason := fmt.Sprintf("Upload %s matched %s in %s", gameID, matches[0].Signature, matches[0].Path)
+		if err := srv.UserStore.FlagUser(ctx, ownerID, reason); err != nil {
+			slog.ErrorContext(ctx, "Failed to flag user", "owner_id", ownerID, "error", err)
+		}
+	}
+	return &uploadError{
+		status: http.StatusUnprocessableEntity,
+		msg:    fmt.Sprintf("Build rejected: %s matched the malware signature %s", matches[0].Path, matches[0].Signature),
+		code:   "malware_detected",
+	}
+}
+
+// quarantineBuild moves a build out of ./games and records it for the
+// organizers to review.
+func quarantineBuild(srv *structs.Server, q QuarantinedBuild, destDir string) error {
+	q.Dir = filepath.Join(quarantineDir, q.GameID)
+	q.At = time.Now().UTC()
+	if err := os.MkdirAll(quarantineDir, 0700); err != nil {
+		return err
+	}
+	if err := os.Rename(destDir, q.Dir); err != nil {
+		return err
+	}
+	var state quarantineState
+	return srv.Store.Update(quarantineDoc, &state, func() error {
+		state.Builds = append([]QuarantinedBuild{q}, state.Builds...)
+		return nil
+	})
+}
+
+var errNotQuarantined = errors.New("build is not quarantined")
+
+// releaseBuild publishes a quarantined build after all, once an organizer
+// decided ClamAV's match was a false positive: its files go back to ./games
+// and it's registered and synced as if its upload had just finished.
+func releaseBuild(ctx context.Context, srv *structs.Server, gameID string) (Build, error) {
+	var q QuarantinedBuild
+	var state quarantineState
+	err := srv.Store.Update(quarantineDoc, &state, func() error {
+		for i, b := range state.Builds {
+			if b.GameID == gameID {
+				q = b
+				state.Builds = append(state.Builds[:i], state.Builds[i+1:]...)
+				return nil
+			}
+		}
+		return errNotQuarantined
+	})
+	if err != nil {
+		return Build{}, err
+	}
+
+	destDir := filepath.Join("./games", gameID)
+	if err := os.Rename(q.Dir, destDir); err != nil {
+		// Keep it listed, so the release can be retried
+		srv.Store.Update(quarantineDoc, &state, func() error {
+			state.Builds = append([]QuarantinedBuild{q}, state.Builds...)
+			return nil
+		})
+		return Build{}, fmt.Errorf("failed to move build back: %v", err)
+	}
+	if q.OwnerID != "" {
+		if err := addStorage(srv, q.OwnerID, gameID, destDir); err != nil {
+			slog.ErrorContext(ctx, "Failed to record storage usage", "game_id", gameID, "error", err)
+		}
+	}
+	emitEvent(ctx, srv, gameID, events.Validated, "admin", "released from quarantine")
+	build := registerBuild(ctx, srv, gameID, q.OwnerID, q.meta())
+	queueSync(context.WithoutCancel(ctx), srv, gameID, destDir)
+	return build, nil
+}
+
+// QuarantineHandler lists the builds quarantined by the malware scan,
+// newest first. Requires the admin token.
+func QuarantineHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(srv, r) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var state quarantineState
+		if err := srv.Store.Load(quarantineDoc, &state); err != nil {
+			http.Error(w, "Failed to load quarantine: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if state.Builds == nil {
+			state.Builds = []QuarantinedBuild{}
+		}
+		writeJSON(w, http.StatusOK, state)
+	}
+}
+
+// ReleaseQuarantineHandler publishes a quarantined build after all, for
+// false positives. The uploader stays flagged until an organizer clears it in
+// the Users table. Requires the admin token.
+func ReleaseQuarantineHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(srv, r) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		build, err := releaseBuild(r.Context(), srv, chi.URLParam(r, "gameId"))
+		if errors.Is(err, errNotQuarantined) {
+			http.Error(w, "Build not quarantined", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to release build: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, build)
+	}
+}
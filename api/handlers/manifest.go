@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"shiba-api/events"
+	"shiba-api/structs"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const cosignTimeout = 2 * time.Minute
+
+type ManifestFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	// OriginalPath is the path in the upload of a file whose path was
+	// lowercased
+	OriginalPath string `json:"originalPath,omitempty"`
+}
+
+// BuildManifest describes exactly what was published for a build.
+type BuildManifest struct {
+	GameID      string         `json:"gameId"`
+	ProjectID   string         `json:"projectId"`
+	PublishedAt time.Time      `json:"publishedAt"`
+	Files       []ManifestFile `json:"files"`
+	// KeyCase is KeyCaseLower when the build's paths were lowercased, and
+	// requests for them must be too
+	KeyCase string `json:"keyCase,omitempty"`
+}
+
+type signedManifest struct {
+	// Manifest keeps the exact bytes that were signed
+	Manifest string `json:"manifest"`
+	// Bundle is the Sigstore bundle produced by cosign, if signing is enabled
+	Bundle json.RawMessage `json:"bundle,omitempty"`
+}
+
+func manifestDoc(gameID string) string {
+	return "manifest-" + gameID
+}
+
+func hashFile(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+// buildManifest hashes the files of a build. keyCase and originalPaths, by
+// new path, describe the renames of normalizeKeyCase, if it ran.
+func buildManifest(build Build, dir, keyCase string, originalPaths map[string]string) (BuildManifest, error) {
+	manifest := BuildManifest{
+		GameID:      build.ID,
+		ProjectID:   build.ProjectID,
+		PublishedAt: build.CreatedAt,
+		Files:       []ManifestFile{},
+		KeyCase:     keyCase,
+	}
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		sum, size, err := hashFile(path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		manifest.Files = append(manifest.Files, ManifestFile{Path: rel, Size: size, SHA256: sum, OriginalPath: originalPaths[rel]})
+		return nil
+	})
+	sort.Slice(manifest.Files, func(i, j int) bool {
+		return manifest.Files[i].Path < manifest.Files[j].Path
+	})
+	return manifest, err
+}
+
+// signBlob runs cosign keyless signing over data and returns the Sigstore
+// bundle. cosign picks up ambient OIDC credentials (e.g. SIGSTORE_ID_TOKEN).
+func signBlob(data []byte) (json.RawMessage, error) {
+	dir, err := os.MkdirTemp("", "manifest-sign-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	blob := filepath.Join(dir, "manifest.json")
+	bundle := filepath.Join(dir, "manifest.sigstore.json")
+	if err := os.WriteFile(blob, data, 0600); err != nil {
+		return nil, err
+	}
+
+	cosign := os.Getenv("COSIGN_PATH")
+	if cosign == "" {
+		cosign = "cosign"
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cosignTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, cosign, "sign-blob", "--yes", "--bundle", bundle, blob).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("cosign sign-blob failed: %v: %s", err, out)
+	}
+
+	return os.ReadFile(bundle)
+}
+
+// publishManifest records the manifest of a freshly extracted build and signs
+// it when COSIGN_ENABLED is set. Signing failures are logged, the unsigned
+// manifest is kept.
+func publishManifest(ctx context.Context, srv *structs.Server, build Build, dir, keyCase string, originalPaths map[string]string) {
+	manifest, err := buildManifest(build, dir, keyCase, originalPaths)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to build manifest", "game_id", build.ID, "error", err)
+		return
+	}
+
+	emitEvent(ctx, srv, build.ID, events.Hashed, "", fmt.Sprintf("%d files hashed", len(manifest.Files)))
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to encode manifest", "game_id", build.ID, "error", err)
+		return
+	}
+
+	signed := signedManifest{Manifest: string(data)}
+	if srv.Config.Get().CosignEnabled {
+		bundle, err := signBlob(data)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to sign manifest", "game_id", build.ID, "error", err)
+		} else {
+			signed.Bundle = bundle
+		}
+	}
+
+	if err := srv.Store.Save(manifestDoc(build.ID), signed); err != nil {
+		slog.ErrorContext(ctx, "Failed to save manifest", "game_id", build.ID, "error", err)
+	}
+}
+
+func loadManifest(srv *structs.Server, gameID string) (*signedManifest, error) {
+	var signed signedManifest
+	if err := srv.Store.Load(manifestDoc(gameID), &signed); err != nil {
+		return nil, err
+	}
+	if signed.Manifest == "" {
+		return nil, nil
+	}
+	return &signed, nil
+}
+
+// ManifestHandler serves the exact manifest bytes that were signed.
+func ManifestHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		signed, err := loadManifest(srv, chi.URLParam(r, "gameId"))
+		if err != nil {
+			http.Error(w, "Failed to load manifest: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if signed == nil {
+			http.Error(w, "Manifest not found", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(signed.Manifest))
+	}
+}
+
+// ManifestBundleHandler serves the Sigstore bundle for a build's manifest.
+func ManifestBundleHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		signed, err := loadManifest(srv, chi.URLParam(r, "gameId"))
+		if err != nil {
+			http.Error(w, "Failed to load manifest: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if signed == nil || len(signed.Bundle) == 0 {
+			http.Error(w, "Signature not found", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(signed.Bundle)
+	}
+}
+
+// VerificationHandler tells verifiers where to find the manifest and bundle
+// and which identity signed it, e.g. for
+// cosign verify-blob --bundle <bundle> --certificate-identity <identity> --certificate-oidc-issuer <issuer> <manifest>
+func VerificationHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		gameID := chi.URLParam(r, "gameId")
+		signed, err := loadManifest(srv, gameID)
+		if err != nil {
+			http.Error(w, "Failed to load manifest: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if signed == nil {
+			http.Error(w, "Manifest not found", http.StatusNotFound)
+			return
+		}
+
+		sum := sha256.Sum256([]byte(signed.Manifest))
+		resp := struct {
+			GameID                string `json:"gameId"`
+			Signed                bool   `json:"signed"`
+			ManifestURL           string `json:"manifestUrl"`
+			ManifestSHA256        string `json:"manifestSha256"`
+			BundleURL             string `json:"bundleUrl,omitempty"`
+			CertificateIdentity   string `json:"certificateIdentity,omitempty"`
+			CertificateOIDCIssuer string `json:"certificateOidcIssuer,omitempty"`
+		}{
+			GameID:         gameID,
+			Signed:         len(signed.Bundle) > 0,
+			ManifestURL:    apiPath(r, "/builds/"+gameID+"/manifest"),
+			ManifestSHA256: hex.EncodeToString(sum[:]),
+		}
+		if resp.Signed {
+			resp.BundleURL = apiPath(r, "/builds/"+gameID+"/manifest.sigstore.json")
+			resp.CertificateIdentity = os.Getenv("COSIGN_CERTIFICATE_IDENTITY")
+			resp.CertificateOIDCIssuer = os.Getenv("COSIGN_CERTIFICATE_OIDC_ISSUER")
+		}
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"net/http"
+
+	"shiba-api/metrics"
+	"shiba-api/structs"
+)
+
+var (
+	uploadAttempts = metrics.NewCounter("shiba_upload_attempts_total",
+		"Uploads started, by endpoint.", "endpoint")
+	validationFailures = metrics.NewCounter("shiba_upload_validation_failures_total",
+		"Files that failed upload validation, by rule.", "rule")
+	extractionDuration = metrics.NewHistogram("shiba_upload_extraction_duration_seconds",
+		"Time taken to extract uploaded zips.", []float64{.1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120})
+	bytesWritten = metrics.NewCounter("shiba_upload_bytes_written_total",
+		"Bytes of game files extracted to disk.")
+	r2Syncs = metrics.NewCounter("shiba_r2_syncs_total",
+		"Builds synced to R2, by result (success or failure).", "result")
+	malwareDetections = metrics.NewCounter("shiba_malware_detections_total",
+		"Uploads quarantined because ClamAV matched their files.")
+	memoryWait = metrics.NewHistogram("shiba_upload_memory_wait_seconds",
+		"Time uploads waited for room in the memory budget.", []float64{.01, .1, .5, 1, 5, 10, 30, 60, 120})
+	memoryRejections = metrics.NewCounter("shiba_upload_memory_rejections_total",
+		"Uploads turned away after waiting too long for room in the memory budget.")
+)
+
+// MetricsHandler serves the server's metrics in the Prometheus text format.
+// Requires the admin token.
+func MetricsHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(srv, r) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		metrics.Write(w)
+	}
+}
@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"shiba-api/schema"
+	"shiba-api/structs"
+)
+
+// MyGame is one project in the caller's list of uploads.
+type MyGame struct {
+	ID string `json:"id"`
+	// Slug is the project's short link code, once one was created
+	Slug      string    `json:"slug,omitempty"`
+	GameID    string    `json:"gameId"`
+	CreatedAt time.Time `json:"createdAt"`
+	// Size is the total size of the served build's files, 0 until its
+	// manifest is published
+	Size     int64  `json:"size"`
+	PlayURL  string `json:"playUrl"`
+	Versions int    `json:"versions"`
+}
+
+type myGamesQuery struct {
+	Cursor string `query:"cursor"`
+	Limit  int    `query:"limit" validate:"min=1,max=100"`
+}
+
+// gamesCursor points just past a game in the newest-first list. It encodes
+// the game's creation time and ID, so pages stay stable while new games are
+// uploaded.
+type gamesCursor struct {
+	createdAt time.Time
+	id        string
+}
+
+func (c gamesCursor) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(c.createdAt.UnixNano(), 10) + ":" + c.id))
+}
+
+func parseGamesCursor(s string) (gamesCursor, bool) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return gamesCursor{}, false
+	}
+	nanos, id, ok := strings.Cut(string(raw), ":")
+	if !ok || id == "" {
+		return gamesCursor{}, false
+	}
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return gamesCursor{}, false
+	}
+	return gamesCursor{time.Unix(0, n).UTC(), id}, true
+}
+
+// before reports whether g comes before c in the list, newest first.
+func (c gamesCursor) before(g MyGame) bool {
+	if !g.CreatedAt.Equal(c.createdAt) {
+		return g.CreatedAt.After(c.createdAt)
+	}
+	return g.ID <= c.id
+}
+
+// ownedGames lists the projects userID uploaded to, newest first. A project's
+// creation date is its first upload.
+func ownedGames(state *buildsState, userID string) []MyGame {
+	var games []MyGame
+	for _, b := range latestOwnedBuilds(*state, userID) {
+		builds := state.projectBuilds(b.ProjectID)
+		games = append(games, MyGame{
+			ID:        b.ProjectID,
+			GameID:    b.ID,
+			CreatedAt: builds[len(builds)-1].CreatedAt,
+			PlayURL:   buildURL(b),
+			Versions:  len(builds),
+		})
+	}
+	sort.Slice(games, func(i, j int) bool {
+		if !games[i].CreatedAt.Equal(games[j].CreatedAt) {
+			return games[i].CreatedAt.After(games[j].CreatedAt)
+		}
+		return games[i].ID < games[j].ID
+	})
+	return games
+}
+
+// MyGamesHandler lists the caller's games, newest first, a page at a time.
+// Requires a user token.
+func MyGamesHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := requireUser(srv, w, r)
+		if !ok {
+			return
+		}
+		query := myGamesQuery{Limit: 20}
+		if !bindQuery(w, r, &query) {
+			return
+		}
+
+		state, err := loadBuilds(srv)
+		if err != nil {
+			http.Error(w, "Failed to load builds: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		games := ownedGames(&state, user.ID)
+
+		if query.Cursor != "" {
+			cursor, ok := parseGamesCursor(query.Cursor)
+			if !ok {
+				invalidField(w, "cursor", schema.InQuery, "is not a cursor from a previous page")
+				return
+			}
+			start := sort.Search(len(games), func(i int) bool { return !cursor.before(games[i]) })
+			games = games[start:]
+		}
+
+		next := ""
+		if len(games) > query.Limit {
+			games = games[:query.Limit]
+			last := games[len(games)-1]
+			next = gamesCursor{last.CreatedAt, last.ID}.String()
+		}
+
+		var links shortlinksState
+		if err := srv.Store.Load(shortlinksDoc, &links); err != nil {
+			http.Error(w, "Failed to load short links: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for i := range games {
+			games[i].Slug = links.Projects[games[i].ID]
+			manifest, err := loadBuildManifest(srv, games[i].GameID)
+			if err != nil {
+				http.Error(w, "Failed to load manifest: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if manifest != nil {
+				for _, f := range manifest.Files {
+					games[i].Size += f.Size
+				}
+			}
+		}
+
+		if games == nil {
+			games = []MyGame{}
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, http.StatusOK, struct {
+			Games      []MyGame `json:"games"`
+			NextCursor string   `json:"nextCursor,omitempty"`
+		}{games, next})
+	}
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+func TestGamesCursorRoundTrip(t *testing.T) {
+	tests := []gamesCursor{
+		{time.Date(2026, 10, 17, 12, 30, 0, 123456789, time.UTC), "recProject"},
+		{time.Date(1999, 1, 1, 0, 0, 0, 0, time.UTC), "a:b"},
+		{time.Unix(0, 0).UTC(), "x"},
+	}
+	for _, c := range tests {
+		got, ok := parseGamesCursor(c.String())
+		if !ok {
+			t.Errorf("parseGamesCursor(%q) failed", c.String())
+			continue
+		}
+		if !got.createdAt.Equal(c.createdAt) || got.id != c.id {
+			t.Errorf("parseGamesCursor(%q) = %v %q, want %v %q", c.String(), got.createdAt, got.id, c.createdAt, c.id)
+		}
+	}
+}
+
+func TestParseGamesCursorInvalid(t *testing.T) {
+	encode := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	tests := []struct {
+		name   string
+		cursor string
+	}{
+		{"empty", ""},
+		{"not base64", "not a cursor!"},
+		{"padded base64", base64.URLEncoding.EncodeToString([]byte("1:recAB"))},
+		{"no separator", encode("1700000000")},
+		{"no ID", encode("1700000000:")},
+		{"time not a number", encode("yesterday:recA")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if c, ok := parseGamesCursor(tt.cursor); ok {
+				t.Errorf("parseGamesCursor(%q) = %v, want it rejected", tt.cursor, c)
+			}
+		})
+	}
+}
+
+func TestGamesCursorBefore(t *testing.T) {
+	at := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	cursor := gamesCursor{at, "recM"}
+	tests := []struct {
+		name string
+		game MyGame
+		want bool
+	}{
+		{"newer", MyGame{ID: "recZ", CreatedAt: at.Add(time.Second)}, true},
+		{"older", MyGame{ID: "recA", CreatedAt: at.Add(-time.Second)}, false},
+		{"same time, earlier ID", MyGame{ID: "recA", CreatedAt: at}, true},
+		{"the cursor's own game", MyGame{ID: "recM", CreatedAt: at}, true},
+		{"same time, later ID", MyGame{ID: "recZ", CreatedAt: at}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cursor.before(tt.game); got != tt.want {
+				t.Errorf("before() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"html/template"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"shiba-api/structs"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	NativeAndroid = "android"
+	NativeIOS     = "ios"
+)
+
+const ListingDownloadable = "downloadable"
+
+const nativeBuildGuidance = "Shiba hosts web games only, native mobile builds (.apk/.ipa) can't be played in the browser. " +
+	"Export your game for the web instead (Godot: Project > Export > Web, Unity: File > Build Settings > WebGL) " +
+	"and upload a zip of the exported folder."
+
+var nativeArtifactNames = map[string]string{
+	NativeAndroid: "game.apk",
+	NativeIOS:     "game.ipa",
+}
+
+var downloadPage = template.Must(template.New("download").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Download build</title>
+<style>
+body { font-family: sans-serif; max-width: 32rem; margin: 4rem auto; padding: 0 1rem; text-align: center; }
+a.button { display: inline-block; padding: 0.75rem 1.5rem; background: #333; color: #fff; border-radius: 0.5rem; text-decoration: none; }
+code { word-break: break-all; }
+</style>
+</head>
+<body>
+<p>This game is a downloadable {{.Platform}} build.</p>
+<p><a class="button" href="{{.DownloadURL}}" download>Download {{.Artifact}}</a></p>
+<p>SHA-256: <code>{{.SHA256}}</code></p>
+</body>
+</html>
+`))
+
+// detectNativeBuild recognises Android and iOS packages by name, or by their
+// layout for archives renamed to .zip.
+func detectNativeBuild(filename, path string) string {
+	name := strings.ToLower(filename)
+	switch {
+	case strings.HasSuffix(name, ".apk"):
+		return NativeAndroid
+	case strings.HasSuffix(name, ".ipa"):
+		return NativeIOS
+	}
+
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return ""
+	}
+	defer zr.Close()
+	return nativeLayout(zr.File)
+}
+
+func nativeLayout(files []*zip.File) string {
+	for _, f := range files {
+		if f.Name == "AndroidManifest.xml" {
+			return NativeAndroid
+		}
+		if strings.HasPrefix(f.Name, "Payload/") && strings.Contains(f.Name, ".app/") {
+			return NativeIOS
+		}
+	}
+	return ""
+}
+
+// publishDownloadable checks a native package is structurally sound, stores it
+// in destDir and generates a landing page with a download button in place of
+// the game. It returns the artifact's SHA-256.
+func publishDownloadable(path, destDir, kind string) (string, error) {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return "", newUploadError(http.StatusBadRequest, "Invalid "+kind+" package: not a zip archive")
+	}
+	layout := nativeLayout(zr.File)
+	zr.Close()
+	if layout != kind {
+		return "", newUploadError(http.StatusBadRequest, "Invalid "+kind+" package: missing manifest or app bundle")
+	}
+
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return "", newUploadError(http.StatusInternalServerError, "Failed to create game directory: "+err.Error())
+	}
+
+	src, err := os.Open(path)
+	if err != nil {
+		return "", newUploadError(http.StatusInternalServerError, "Failed to open package: "+err.Error())
+	}
+	defer src.Close()
+
+	artifact := nativeArtifactNames[kind]
+	dst, err := os.Create(filepath.Join(destDir, artifact))
+	if err != nil {
+		return "", newUploadError(http.StatusInternalServerError, "Failed to store package: "+err.Error())
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(dst, hash), src); err != nil {
+		dst.Close()
+		return "", newUploadError(http.StatusInternalServerError, "Failed to store package: "+err.Error())
+	}
+	if err := dst.Close(); err != nil {
+		return "", newUploadError(http.StatusInternalServerError, "Failed to store package: "+err.Error())
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+
+	index, err := os.Create(filepath.Join(destDir, "index.html"))
+	if err != nil {
+		return "", newUploadError(http.StatusInternalServerError, "Failed to write download page: "+err.Error())
+	}
+	defer index.Close()
+
+	err = downloadPage.Execute(index, struct {
+		Platform    string
+		Artifact    string
+		DownloadURL string
+		SHA256      string
+	}{kind, artifact, "/download/" + filepath.Base(destDir), sum})
+	if err != nil {
+		return "", newUploadError(http.StatusInternalServerError, "Failed to write download page: "+err.Error())
+	}
+	return sum, nil
+}
+
+// DownloadBuildHandler serves the stored package of a downloadable build.
+// Packages of drafts need a preview token, like their play URLs.
+func DownloadBuildHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		gameID := chi.URLParam(r, "gameId")
+		if gameID == "" || strings.ContainsAny(gameID, `/\.`) {
+			http.Error(w, "Invalid game ID", http.StatusBadRequest)
+			return
+		}
+		if !draftAccess(srv, w, r, gameID) {
+			return
+		}
+
+		for _, artifact := range nativeArtifactNames {
+			path := filepath.Join("./games", gameID, artifact)
+			if _, err := os.Stat(path); err == nil {
+				w.Header().Set("Content-Type", "application/octet-stream")
+				w.Header().Set("Content-Disposition", `attachment; filename="`+gameID+filepath.Ext(artifact)+`"`)
+				http.ServeFile(w, r, path)
+				return
+			}
+		}
+		http.Error(w, "Download not found", http.StatusNotFound)
+	}
+}
@@ -0,0 +1,350 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	"shiba-api/jobs"
+	"shiba-api/openapi"
+	"shiba-api/playtime"
+	"shiba-api/profiles"
+)
+
+// apiDocument describes the API for client authors. Request and response
+// schemas come from the types the handlers bind and write; document new
+// endpoints here along with their handler.
+func apiDocument() *openapi.Document {
+	doc := openapi.New(openapi.Info{
+		Title:       "Shiba API",
+		Version:     "1",
+		Description: "Hosts and serves the games made for Shiba. Most errors are plain text; errors a client can act on are JSON with a `code`, see the Error schema. See docs/routes.md for everything not covered here.",
+	}, openapi.Server{URL: "/v1"}, openapi.Server{URL: "/v2", Description: "v1 without the legacy routes"})
+	unversioned := []openapi.Server{{URL: "/"}}
+
+	doc.Define("Error", openapi.SchemaOf(uploadErrorBody{}))
+	errorResponse := func(description string) openapi.Response {
+		return openapi.JSON(description, openapi.Ref("Error"))
+	}
+
+	uploadParts := []openapi.FilePart{
+		{Name: "file", Required: true, Description: "A zip of a web build, a PICO-8 (.p8.png) or TIC-80 (.tic) cartridge, or a native build when downloadable builds are allowed"},
+		{Name: "pack", Repeat: maxUploadPacks, Description: "Data packs extracted over the zip, for web builds too large for one zip"},
+	}
+	uploadErrors := map[int]openapi.Response{
+		http.StatusBadRequest:            openapi.Text("Missing or invalid file"),
+		http.StatusUnauthorized:          errorResponse("Invalid user token, or a projectId or prize sent without one (unauthorized)"),
+		http.StatusForbidden:             errorResponse("The projectId belongs to someone else (not_project_owner), submissions are closed, or a prize upload's uploader isn't eligible"),
+		http.StatusRequestEntityTooLarge: errorResponse("The build is over MAX_BUILD_SIZE_MB extracted (build_too_large) or has too many files (too_many_entries)"),
+		http.StatusUnsupportedMediaType:  openapi.Text("Native build while downloadable builds are disabled"),
+		http.StatusUnprocessableEntity:   errorResponse("A form field is invalid (invalid_request), archives overlap (archive_conflict), a file looks like a zip bomb (suspicious_compression) or the build failed validation (validation_failed, with findings)"),
+		http.StatusTooManyRequests:       errorResponse("Daily upload quota used up, or too many uploads in progress (uploads_in_flight)"),
+	}
+
+	doc.Add(http.MethodPost, "/uploadGame", openapi.Operation{
+		OperationID: "uploadGame",
+		Summary:     "Upload and publish a build",
+		Description: "The build is extracted, validated and synced before the response. Send `traceparent` to join the client's trace and `X-Shiba-Client` (e.g. `cli/1.4.0`) to record the client in the build's provenance.",
+		Tags:        []string{"uploads"},
+		RequestBody: openapi.MultipartBody(uploadForm{}, uploadParts...),
+		Responses: withResponses(uploadErrors, map[int]openapi.Response{
+			http.StatusOK: openapi.JSON("Published", openapi.SchemaOf(uploadResponse{})),
+		}),
+	}, openapi.AuthUser)
+	doc.Add(http.MethodPost, "/plugin/godot/upload", openapi.Operation{
+		OperationID: "pluginUpload",
+		Summary:     "Upload a build in the background",
+		Description: "Same form as uploadGame, with engine defaulting to godot. Answers once the zip is received; poll statusUrl for progress.",
+		Tags:        []string{"uploads"},
+		RequestBody: openapi.MultipartBody(uploadForm{}, uploadParts...),
+		Responses: withResponses(uploadErrors, map[int]openapi.Response{
+			http.StatusAccepted: openapi.JSON("Received", openapi.SchemaOf(pluginUploadAccepted{})),
+		}),
+	}, openapi.AuthUser)
+	doc.Add(http.MethodGet, "/plugin/uploads/{uploadId}", openapi.Operation{
+		OperationID: "pluginUploadStatus",
+		Summary:     "Progress of a background upload",
+		Tags:        []string{"uploads"},
+		Parameters:  []openapi.Parameter{openapi.PathParam("uploadId", "From the upload's response")},
+		Responses: map[int]openapi.Response{
+			http.StatusOK:       openapi.JSON("The upload's status", openapi.SchemaOf(jobs.Job{})),
+			http.StatusNotFound: openapi.Text("Unknown, expired or someone else's upload"),
+		},
+	}, openapi.AuthUser)
+
+	sessionID := openapi.PathParam("sessionId", "From the session's start")
+	sessionErrors := map[int]openapi.Response{
+		http.StatusNotFound: errorResponse("The session ended (play_session_not_found)"),
+		http.StatusConflict: errorResponse("Wrong nonce (bad_nonce)"),
+	}
+	doc.Add(http.MethodPost, "/play-sessions", openapi.Operation{
+		OperationID: "startPlaySession",
+		Summary:     "Start a play session",
+		Description: "Playtime is credited from heartbeats sent every intervalSeconds, each with the nonce of the previous response.",
+		Tags:        []string{"playtime"},
+		RequestBody: openapi.JSONBody(startPlaySessionRequest{}),
+		Responses: map[int]openapi.Response{
+			http.StatusCreated:         openapi.JSON("Started", openapi.SchemaOf(playSessionResponse{})),
+			http.StatusNotFound:        openapi.Text("No such build"),
+			http.StatusTooManyRequests: errorResponse(fmt.Sprintf("The player already has %d sessions open (too_many_play_sessions)", playtime.MaxSessions)),
+		},
+	}, openapi.AuthUser)
+	doc.Add(http.MethodPost, "/play-sessions/{sessionId}/heartbeat", openapi.Operation{
+		OperationID: "playSessionHeartbeat",
+		Summary:     "Report that the player is still playing",
+		Tags:        []string{"playtime"},
+		Parameters:  []openapi.Parameter{sessionID},
+		RequestBody: openapi.JSONBody(heartbeatRequest{}),
+		Responses: withResponses(sessionErrors, map[int]openapi.Response{
+			http.StatusOK: openapi.JSON("Recorded, with the next nonce", openapi.SchemaOf(playSessionResponse{})),
+		}),
+	}, openapi.AuthUser)
+	doc.Add(http.MethodPost, "/play-sessions/{sessionId}/end", openapi.Operation{
+		OperationID: "endPlaySession",
+		Summary:     "End a play session and credit its playtime",
+		Tags:        []string{"playtime"},
+		Parameters:  []openapi.Parameter{sessionID},
+		RequestBody: openapi.JSONBody(endPlaySessionRequest{}),
+		Responses: withResponses(sessionErrors, map[int]openapi.Response{
+			http.StatusOK: openapi.JSON("Ended", openapi.SchemaOf(playSessionResponse{})),
+		}),
+	}, openapi.AuthUser)
+
+	doc.Add(http.MethodPost, "/games/{gameId}/crashes", openapi.Operation{
+		OperationID: "reportCrash",
+		Summary:     "Report an uncaught error in a build",
+		Description: "Game pages send these by themselves. New crashes notify the creators' webhooks.",
+		Tags:        []string{"webhooks"},
+		Parameters:  []openapi.Parameter{openapi.PathParam("gameId", "The build that crashed")},
+		RequestBody: openapi.JSONBody(crashReport{}),
+		Responses: map[int]openapi.Response{
+			http.StatusNoContent: {Description: "Recorded"},
+			http.StatusNotFound:  openapi.Text("No such published build"),
+		},
+	})
+	doc.Add(http.MethodPost, "/analytics/play", openapi.Operation{
+		OperationID: "playBeacon",
+		Summary:     "Count an anonymous play of a build",
+		Description: "Game pages send start when they load and end with the seconds they were visible. A session counts once.",
+		Tags:        []string{"playtime"},
+		RequestBody: openapi.JSONBody(playBeacon{}),
+		Responses: map[int]openapi.Response{
+			http.StatusNoContent: {Description: "Recorded, or ignored as a repeat"},
+			http.StatusNotFound:  openapi.Text("No such published build"),
+		},
+	})
+
+	leaderboardGame := openapi.PathParam("gameId", "The project, or any of its builds")
+	doc.Add(http.MethodPost, "/games/{gameId}/scores", openapi.Operation{
+		OperationID: "submitScore",
+		Summary:     "Submit a player's score to a game's leaderboard",
+		Description: "Send the board's key in X-Leaderboard-Key. Only each player's best score is kept.",
+		Tags:        []string{"leaderboards"},
+		Parameters:  []openapi.Parameter{leaderboardGame},
+		RequestBody: openapi.JSONBody(submitScoreRequest{}),
+		Responses: map[int]openapi.Response{
+			http.StatusOK:              openapi.JSON("Recorded", openapi.SchemaOf(submitScoreResponse{})),
+			http.StatusUnauthorized:    openapi.Text("Missing or wrong key"),
+			http.StatusNotFound:        openapi.Text("No such game, or it has no leaderboard"),
+			http.StatusTooManyRequests: openapi.Text("Too many scores from this player or IP"),
+		},
+	})
+	doc.Add(http.MethodPut, "/games/{gameId}/leaderboard", openapi.Operation{
+		OperationID: "updateLeaderboard",
+		Summary:     "Create or change a game's leaderboard",
+		Description: "The key is only returned when the board is created or rotateKey is set.",
+		Tags:        []string{"leaderboards"},
+		Parameters:  []openapi.Parameter{leaderboardGame},
+		RequestBody: openapi.JSONBody(updateLeaderboardRequest{}),
+		Responses: map[int]openapi.Response{
+			http.StatusOK:       openapi.JSON("The board", openapi.SchemaOf(leaderboardResponse{})),
+			http.StatusNotFound: openapi.Text("No such game"),
+		},
+	}, openapi.AuthUser)
+
+	commentGame := openapi.PathParam("gameId", "The project, or any of its builds")
+	doc.Add(http.MethodGet, "/games/{gameId}/comments", openapi.Operation{
+		OperationID: "listComments",
+		Summary:     "List the comments on a game, newest first",
+		Tags:        []string{"comments"},
+		Parameters:  append([]openapi.Parameter{commentGame}, openapi.QueryParams(commentsQuery{})...),
+		Responses: map[int]openapi.Response{
+			http.StatusOK: openapi.JSON("A page of comments", map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"comments":   map[string]any{"type": "array", "items": openapi.SchemaOf(Comment{})},
+					"nextCursor": map[string]any{"type": "string"},
+				},
+			}),
+			http.StatusNotFound: openapi.Text("No such game"),
+		},
+	})
+	doc.Add(http.MethodPost, "/games/{gameId}/comments", openapi.Operation{
+		OperationID: "postComment",
+		Summary:     "Leave a comment on a game",
+		Description: "Comments from anyone but the game's owners count as feedback and notify the creators' webhooks.",
+		Tags:        []string{"comments"},
+		Parameters:  []openapi.Parameter{commentGame},
+		RequestBody: openapi.JSONBody(postCommentRequest{}),
+		Responses: map[int]openapi.Response{
+			http.StatusCreated:  openapi.JSON("The comment", openapi.SchemaOf(Comment{})),
+			http.StatusNotFound: openapi.Text("No such game"),
+		},
+	}, openapi.AuthUser)
+	doc.Add(http.MethodDelete, "/games/{gameId}/comments/{commentId}", openapi.Operation{
+		OperationID: "deleteComment",
+		Summary:     "Delete a comment, as its author, an owner of the game or an admin",
+		Tags:        []string{"comments"},
+		Parameters:  []openapi.Parameter{commentGame, openapi.PathParam("commentId", "From the comment")},
+		Responses: map[int]openapi.Response{
+			http.StatusNoContent: {Description: "Deleted"},
+			http.StatusForbidden: openapi.Text("Not the comment's author or an owner of the game"),
+			http.StatusNotFound:  openapi.Text("No such game or comment"),
+		},
+	}, openapi.AuthUser)
+
+	doc.Add(http.MethodGet, "/users/{userId}/profile", openapi.Operation{
+		OperationID: "userProfile",
+		Summary:     "A creator's games, awards, playtime and streak across events",
+		Description: "Private profiles are only shown to their creator and admins; hidden sections are left out for others.",
+		Tags:        []string{"profiles"},
+		Parameters:  []openapi.Parameter{openapi.PathParam("userId", "The creator's user ID")},
+		Responses: map[int]openapi.Response{
+			http.StatusOK:       openapi.JSON("The profile", openapi.SchemaOf(Profile{})),
+			http.StatusNotFound: openapi.Text("No such user, or the profile is private"),
+		},
+	})
+	doc.Add(http.MethodPut, "/me/profile", openapi.Operation{
+		OperationID: "updateProfile",
+		Summary:     "Set who can see the caller's profile",
+		Tags:        []string{"profiles"},
+		RequestBody: openapi.JSONBody(updateProfileRequest{}),
+		Responses: map[int]openapi.Response{
+			http.StatusOK: openapi.JSON("The settings", openapi.SchemaOf(profiles.Settings{})),
+		},
+	}, openapi.AuthUser)
+
+	scopeError := errorResponse("A creator token without the needed scope (insufficient_scope)")
+	doc.Add(http.MethodGet, "/me/tokens", openapi.Operation{
+		OperationID: "listCreatorTokens",
+		Summary:     "List the caller's creator tokens",
+		Tags:        []string{"tokens"},
+		Responses: map[int]openapi.Response{
+			http.StatusOK: openapi.JSON("The tokens, newest first", map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"scopes": map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+					"tokens": map[string]any{"type": "array", "items": openapi.SchemaOf(CreatorToken{})},
+				},
+			}),
+			http.StatusForbidden: scopeError,
+		},
+	}, openapi.AuthUser)
+	doc.Add(http.MethodPost, "/me/tokens", openapi.Operation{
+		OperationID: "createCreatorToken",
+		Summary:     "Mint a creator token limited to some scopes",
+		Tags:        []string{"tokens"},
+		RequestBody: openapi.JSONBody(createCreatorTokenRequest{}),
+		Responses: map[int]openapi.Response{
+			http.StatusOK:        openapi.JSON("The token, with its secret value shown only once", openapi.SchemaOf(mintedCreatorToken{})),
+			http.StatusForbidden: scopeError,
+			http.StatusConflict:  openapi.Text("Too many tokens"),
+		},
+	}, openapi.AuthUser)
+
+	webhookID := openapi.PathParam("webhookId", "From the webhook's creation")
+	doc.Add(http.MethodGet, "/me/webhooks", openapi.Operation{
+		OperationID: "listWebhooks",
+		Summary:     "List the caller's webhooks",
+		Tags:        []string{"webhooks"},
+		Responses: map[int]openapi.Response{
+			http.StatusOK: openapi.JSON("The webhooks, newest first", map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"events":   map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+					"webhooks": map[string]any{"type": "array", "items": openapi.SchemaOf(CreatorWebhook{})},
+				},
+			}),
+			http.StatusForbidden: scopeError,
+		},
+	}, openapi.AuthUser)
+	doc.Add(http.MethodPost, "/me/webhooks", openapi.Operation{
+		OperationID: "createWebhook",
+		Summary:     "Register a webhook for events about the caller's games",
+		Description: "Deliveries are signed with the returned secret, see docs/routes.md.",
+		Tags:        []string{"webhooks"},
+		RequestBody: openapi.JSONBody(createWebhookRequest{}),
+		Responses: map[int]openapi.Response{
+			http.StatusCreated:   openapi.JSON("Registered, with its secret shown only once", openapi.SchemaOf(CreatorWebhook{})),
+			http.StatusForbidden: openapi.Text("The caller doesn't own projectId, or a creator token without the webhooks scope"),
+			http.StatusConflict:  openapi.Text("Too many webhooks"),
+		},
+	}, openapi.AuthUser)
+	doc.Add(http.MethodDelete, "/me/webhooks/{webhookId}", openapi.Operation{
+		OperationID: "deleteWebhook",
+		Summary:     "Delete a webhook",
+		Tags:        []string{"webhooks"},
+		Parameters:  []openapi.Parameter{webhookID},
+		Responses: map[int]openapi.Response{
+			http.StatusOK:        openapi.JSON("Deleted", openapi.SchemaOf(webhookDeleted{})),
+			http.StatusForbidden: scopeError,
+			http.StatusNotFound:  openapi.Text("No such webhook for the caller"),
+		},
+	}, openapi.AuthUser)
+	doc.Add(http.MethodPost, "/me/webhooks/{webhookId}/test", openapi.Operation{
+		OperationID: "testWebhook",
+		Summary:     "Send a ping to a webhook",
+		Tags:        []string{"webhooks"},
+		Parameters:  []openapi.Parameter{webhookID},
+		Responses: map[int]openapi.Response{
+			http.StatusAccepted:  openapi.JSON("Queued", openapi.SchemaOf(webhookTestResponse{})),
+			http.StatusForbidden: scopeError,
+			http.StatusNotFound:  openapi.Text("No such webhook for the caller"),
+		},
+	}, openapi.AuthUser)
+
+	doc.Add(http.MethodGet, "/healthz", openapi.Operation{
+		OperationID: "liveness",
+		Summary:     "Whether the process is up",
+		Tags:        []string{"health"},
+		Servers:     unversioned,
+		Responses:   map[int]openapi.Response{http.StatusOK: openapi.Text("OK")},
+	})
+	doc.Add(http.MethodGet, "/readyz", openapi.Operation{
+		OperationID: "readiness",
+		Summary:     "Whether the server should get traffic",
+		Tags:        []string{"health"},
+		Servers:     unversioned,
+		Responses: map[int]openapi.Response{
+			http.StatusOK:                 openapi.JSON("Ready", openapi.SchemaOf(Readiness{})),
+			http.StatusServiceUnavailable: openapi.JSON("A check failed", openapi.SchemaOf(Readiness{})),
+		},
+	})
+	doc.Add(http.MethodGet, "/openapi.json", openapi.Operation{
+		OperationID: "openapi",
+		Summary:     "This document",
+		Tags:        []string{"meta"},
+		Servers:     unversioned,
+		Responses:   map[int]openapi.Response{http.StatusOK: openapi.JSON("The OpenAPI document", map[string]any{"type": "object"})},
+	})
+	return doc
+}
+
+// withResponses merges response maps, later ones winning.
+func withResponses(maps ...map[int]openapi.Response) map[int]openapi.Response {
+	merged := map[int]openapi.Response{}
+	for _, m := range maps {
+		for status, r := range m {
+			merged[status] = r
+		}
+	}
+	return merged
+}
+
+var apiDocumentOnce = sync.OnceValue(apiDocument)
+
+// OpenAPIHandler serves the OpenAPI 3 document of the API.
+func OpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	writeJSON(w, http.StatusOK, apiDocumentOnce())
+}
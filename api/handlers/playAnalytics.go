@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"shiba-api/schema"
+	"shiba-api/stats"
+	"shiba-api/structs"
+)
+
+// Beacon sessions are remembered this long, so a session counts once however
+// often its page is reloaded
+const beaconSessionTTL = 24 * time.Hour
+
+// Beacon sessions remembered at most; past it new sessions still count but
+// their time isn't credited
+const maxBeaconSessions = 200000
+
+// The time credited to a session, over all its page loads, is capped at the
+// time since it started and at maxBeaconSeconds
+const maxBeaconSeconds = 8 * 60 * 60
+
+var beaconSessionID = regexp.MustCompile(`^[A-Za-z0-9_-]{16,64}$`)
+
+type beaconSession struct {
+	started  time.Time
+	credited int64
+}
+
+// beaconSessions remembers recent sessions by game and session ID
+var beaconSessions = struct {
+	sync.Mutex
+	sessions map[string]*beaconSession
+	pruned   time.Time
+}{sessions: map[string]*beaconSession{}}
+
+// beaconCounts returns what a beacon adds to the stats, and records it.
+func beaconCounts(gameID, sessionID, event string, seconds int64, now time.Time) stats.Counts {
+	beaconSessions.Lock()
+	defer beaconSessions.Unlock()
+	if now.Sub(beaconSessions.pruned) >= time.Minute {
+		for key, s := range beaconSessions.sessions {
+			if now.Sub(s.started) >= beaconSessionTTL {
+				delete(beaconSessions.sessions, key)
+			}
+		}
+		beaconSessions.pruned = now
+	}
+
+	key := gameID + "\x00" + sessionID
+	s, seen := beaconSessions.sessions[key]
+	switch event {
+	case "start":
+		if seen {
+			return stats.Counts{}
+		}
+		if len(beaconSessions.sessions) < maxBeaconSessions {
+			beaconSessions.sessions[key] = &beaconSession{started: now}
+		}
+		return stats.Counts{Sessions: 1}
+	case "end":
+		if !seen {
+			return stats.Counts{}
+		}
+		seconds = min(seconds, int64(now.Sub(s.started)/time.Second)-s.credited, maxBeaconSeconds-s.credited)
+		if seconds <= 0 {
+			return stats.Counts{}
+		}
+		s.credited += seconds
+		return stats.Counts{SessionSeconds: seconds}
+	}
+	return stats.Counts{}
+}
+
+type playBeacon struct {
+	GameID    string `json:"gameId" validate:"required,max=64"`
+	SessionID string `json:"sessionId" validate:"required,max=64"`
+	Event     string `json:"event" validate:"required,oneof=start end"`
+	Seconds   int64  `json:"seconds" validate:"min=0"`
+}
+
+// PlayBeaconHandler counts an anonymous session of a published game, sent
+// by the game page when it loads and again with the time spent on it when
+// it's left. Sessions carry no user, so they count apart from the plays and
+// playtime of play sessions, and a session only counts once.
+func PlayBeaconHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req playBeacon
+		if !bindJSON(w, r, &req) {
+			return
+		}
+		if !beaconSessionID.MatchString(req.SessionID) {
+			invalidField(w, "sessionId", schema.InBody, "must be 16 to 64 letters, digits, - or _")
+			return
+		}
+		builds, err := loadBuilds(srv)
+		if err != nil {
+			http.Error(w, "Failed to load builds: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		build, ok := publishedBuild(&builds, req.GameID)
+		if !ok {
+			http.Error(w, "Game not found", http.StatusNotFound)
+			return
+		}
+		projectID := build.ProjectID
+		if projectID == "" {
+			projectID = build.ID
+		}
+
+		now := time.Now()
+		if counts := beaconCounts(build.ID, req.SessionID, req.Event, req.Seconds, now); counts != (stats.Counts{}) {
+			srv.Stats.Add(projectID, now, counts)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// playSnippet reports a game page's session when it loads, and the seconds
+// it was visible when it's left. The session ID lives in sessionStorage, so
+// reloads in the same tab are the same session.
+const playSnippet = `<script>(function () {
+var url = %s, gameId = %s, key = "shiba-session-" + gameId, id, visible = 0, since = document.hidden ? 0 : Date.now(), ended = false;
+if (!window.fetch || !window.crypto || !crypto.getRandomValues) return;
+try { id = sessionStorage.getItem(key); } catch (e) {}
+if (!id) {
+  id = Array.prototype.map.call(crypto.getRandomValues(new Uint8Array(16)), function (b) { return ("0" + b.toString(16)).slice(-2); }).join("");
+  try { sessionStorage.setItem(key, id); } catch (e) {}
+}
+function send(event, seconds) {
+  fetch(url, {method: "POST", keepalive: true, headers: {"Content-Type": "application/json"},
+    body: JSON.stringify({gameId: gameId, sessionId: id, event: event, seconds: seconds || 0})}).catch(function () {});
+}
+document.addEventListener("visibilitychange", function () {
+  if (document.hidden && since) { visible += Date.now() - since; since = 0; }
+  else if (!document.hidden) since = Date.now();
+});
+window.addEventListener("pagehide", function () {
+  if (ended) return;
+  ended = true;
+  if (since) visible += Date.now() - since;
+  send("end", Math.round(visible / 1000));
+});
+send("start");
+})();</script>`
@@ -1,11 +1,13 @@
 package handlers
 
 import (
-	"log"
+	"context"
+	"log/slog"
 	"net/http"
 	"os"
 	"shiba-api/structs"
 	"shiba-api/sync"
+	"strings"
 
 	"github.com/go-chi/chi/v5"
 )
@@ -18,27 +20,34 @@ func MainGamePlayHandler(srv *structs.Server) http.HandlerFunc {
 			return
 		}
 
+		if !draftAccess(srv, w, r, gameId) || serveColdBuild(srv, w, gameId) {
+			return
+		}
+
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Content-Security-Policy", gameCSP(srv, r, gameId))
+		hostDerived(w)
 
 		var filepath = "./games/" + gameId + "/index.html"
 
-		log.Printf("Serving game %s from %s", gameId, filepath)
+		slog.DebugContext(r.Context(), "Serving game", "game_id", gameId, "path", filepath)
 
 		// check if the file is present
 		if _, err := os.Stat(filepath); os.IsNotExist(err) {
-			println("File does not exist:", filepath)
+			ctx := context.WithoutCancel(r.Context())
+			slog.InfoContext(ctx, "Game not on disk, fetching it from R2", "game_id", gameId, "path", filepath)
 			go func() {
 				err := sync.FetchGameFromR2(srv, gameId)
 				if err != nil {
-					log.Printf("Failed to fetch game %s: %v", gameId, err)
+					slog.ErrorContext(ctx, "Failed to fetch game", "game_id", gameId, "error", err)
 				} else {
-					log.Printf("Successfully fetched game %s", gameId)
+					slog.InfoContext(ctx, "Fetched game", "game_id", gameId)
 				}
 			}()
 			http.Error(w, "Game not found. The server will try to download it asap. Please try again later.", http.StatusNotFound)
 		}
 
-		http.ServeFile(w, r, filepath)
+		serveGamePage(srv, w, r, gameId, filepath)
 	}
 }
 
@@ -50,14 +59,25 @@ func AssetsPlayHandler(srv *structs.Server) http.HandlerFunc {
 			return
 		}
 
+		if !draftAccess(srv, w, r, gameId) {
+			return
+		}
+
 		assetPath := chi.URLParam(r, "*")
-		if assetPath == "" {
+		if assetPath == "" || strings.HasSuffix(strings.ToLower(assetPath), ".html") {
+			w.Header().Set("Content-Security-Policy", gameCSP(srv, r, gameId))
+			hostDerived(w)
+		}
+		if assetPath == serviceWorkerFile {
+			serveServiceWorker(srv, w, r, gameId)
+			return
+		}
+		if assetPath == "" || assetPath == "index.html" {
 			var filepath = "./games/" + gameId + "/index.html"
 
-			http.ServeFile(w, r, filepath)
+			serveGamePage(srv, w, r, gameId, filepath)
 		} else {
-			var filepath = "./games/" + gameId + "/" + assetPath
-			http.ServeFile(w, r, filepath)
+			http.ServeFile(w, r, caseFoldedAsset(srv, gameId, assetPath))
 		}
 	}
 }
@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sort"
+	"time"
+
+	"shiba-api/playtime"
+	"shiba-api/stats"
+	"shiba-api/structs"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const flaggedSessionsDoc = "flagged-play-sessions"
+
+// Flagged sessions kept for review, newest first
+const maxFlaggedSessions = 1000
+
+type flaggedSessionsState struct {
+	Sessions []playtime.Session `json:"sessions"`
+}
+
+// creditPlaySession counts a finished session as a play of its game and its
+// playtime towards the game's stats and the player's streak. Flagged sessions
+// earn nothing and are kept for admins to review instead.
+func creditPlaySession(ctx context.Context, srv *structs.Server, s playtime.Session) {
+	if s.Flagged() {
+		slog.WarnContext(ctx, "Withheld playtime of flagged play session", "session_id", s.ID, "user_id", s.UserID,
+			"game_id", s.GameID, "seconds", s.CreditedSeconds, "flags", s.Flags)
+		var state flaggedSessionsState
+		err := srv.Store.Update(flaggedSessionsDoc, &state, func() error {
+			state.Sessions = append([]playtime.Session{s}, state.Sessions...)
+			if len(state.Sessions) > maxFlaggedSessions {
+				state.Sessions = state.Sessions[:maxFlaggedSessions]
+			}
+			return nil
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to save flagged play session", "session_id", s.ID, "error", err)
+		}
+		return
+	}
+	if s.CreditedSeconds == 0 {
+		return
+	}
+
+	srv.Stats.Add(s.ProjectID, s.StartedAt, stats.Counts{Plays: 1})
+	days := map[string]time.Time{}
+	for minute, seconds := range s.Credits {
+		srv.Stats.Add(s.ProjectID, minute, stats.Counts{PlaytimeSeconds: seconds})
+		days[minute.Format(dayLayout)] = minute
+	}
+	for _, at := range days {
+		if err := recordActivity(srv, s.UserID, ActivityPlaytime, at); err != nil {
+			slog.ErrorContext(ctx, "Failed to record playtime activity", "user_id", s.UserID, "error", err)
+		}
+	}
+	if err := recordPlaytest(srv, s.UserID, s.ProjectID, s.CreditedSeconds); err != nil {
+		slog.ErrorContext(ctx, "Failed to record playtest", "user_id", s.UserID, "error", err)
+	}
+}
+
+// EndIdlePlaySessions credits the play sessions players left without ending
+// them. Run it periodically.
+func EndIdlePlaySessions(srv *structs.Server) {
+	for _, s := range srv.PlaySessions.EndIdle(time.Now()) {
+		creditPlaySession(context.Background(), srv, s)
+	}
+}
+
+type startPlaySessionRequest struct {
+	GameID string `json:"gameId" validate:"required"`
+}
+
+type heartbeatRequest struct {
+	Nonce   string `json:"nonce" validate:"required"`
+	Focused bool   `json:"focused"`
+	Visible bool   `json:"visible"`
+}
+
+type endPlaySessionRequest struct {
+	Nonce string `json:"nonce" validate:"required"`
+}
+
+type playSessionResponse struct {
+	SessionID string `json:"sessionId"`
+	// Nonce goes with the next heartbeat
+	Nonce           string `json:"nonce"`
+	IntervalSeconds int    `json:"intervalSeconds"`
+	CreditedSeconds int64  `json:"creditedSeconds"`
+}
+
+// StartPlaySessionHandler opens a play session of a build for the calling
+// user. Requires a user token.
+func StartPlaySessionHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := requireUser(srv, w, r)
+		if !ok {
+			return
+		}
+		var req startPlaySessionRequest
+		if !bindJSON(w, r, &req) {
+			return
+		}
+
+		builds, err := loadBuilds(srv)
+		if err != nil {
+			http.Error(w, "Failed to load builds: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		build, ok := publishedBuild(&builds, req.GameID)
+		if !ok {
+			http.Error(w, "Game not found", http.StatusNotFound)
+			return
+		}
+		projectID := build.ProjectID
+		if projectID == "" {
+			projectID = build.ID
+		}
+
+		s, nonce, err := srv.PlaySessions.Start(user.ID, build.ID, projectID, time.Now())
+		if errors.Is(err, playtime.ErrTooManySessions) {
+			writePlaySessionError(w, http.StatusTooManyRequests, "too_many_play_sessions", "End one of your open play sessions first")
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to start play session: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusCreated, playSessionResponse{s.ID, nonce, int(playtime.Interval / time.Second), 0})
+	}
+}
+
+func writePlaySessionError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}{code, message})
+}
+
+// playSessionError answers a heartbeat or end of a session that is gone or
+// was sent the wrong nonce.
+func playSessionError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, playtime.ErrNotFound):
+		writePlaySessionError(w, http.StatusNotFound, "play_session_not_found", "The play session ended; start a new one")
+	case errors.Is(err, playtime.ErrBadNonce):
+		writePlaySessionError(w, http.StatusConflict, "bad_nonce", "Send the nonce returned by the previous heartbeat")
+	default:
+		http.Error(w, "Failed to update play session: "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// PlaySessionHeartbeatHandler records that the calling user is still
+// playing, every playtime.Interval. Only time with the game focused and
+// visible counts. Requires a user token.
+func PlaySessionHeartbeatHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := requireUser(srv, w, r)
+		if !ok {
+			return
+		}
+		var req heartbeatRequest
+		if !bindJSON(w, r, &req) {
+			return
+		}
+
+		s, nonce, err := srv.PlaySessions.Beat(chi.URLParam(r, "sessionId"), user.ID, req.Nonce, req.Focused, req.Visible, time.Now())
+		if err != nil {
+			playSessionError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, playSessionResponse{s.ID, nonce, int(playtime.Interval / time.Second), s.CreditedSeconds})
+	}
+}
+
+// EndPlaySessionHandler ends a play session and credits its playtime.
+// Requires a user token.
+func EndPlaySessionHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := requireUser(srv, w, r)
+		if !ok {
+			return
+		}
+		var req endPlaySessionRequest
+		if !bindJSON(w, r, &req) {
+			return
+		}
+
+		s, err := srv.PlaySessions.End(chi.URLParam(r, "sessionId"), user.ID, req.Nonce, time.Now())
+		if err != nil {
+			playSessionError(w, err)
+			return
+		}
+		creditPlaySession(r.Context(), srv, s)
+		writeJSON(w, http.StatusOK, playSessionResponse{SessionID: s.ID, CreditedSeconds: s.CreditedSeconds})
+	}
+}
+
+// FlaggedPlaySessionsHandler lists the play sessions whose playtime was
+// withheld, newest first, optionally for one user or game. Requires the
+// admin token.
+func FlaggedPlaySessionsHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(srv, r) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var query struct {
+			UserID string `query:"userId"`
+			GameID string `query:"gameId"`
+		}
+		if !bindQuery(w, r, &query) {
+			return
+		}
+
+		var state flaggedSessionsState
+		if err := srv.Store.Load(flaggedSessionsDoc, &state); err != nil {
+			http.Error(w, "Failed to load flagged sessions: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		sessions := []playtime.Session{}
+		for _, s := range state.Sessions {
+			if (query.UserID == "" || s.UserID == query.UserID) &&
+				(query.GameID == "" || s.GameID == query.GameID || s.ProjectID == query.GameID) {
+				sessions = append(sessions, s)
+			}
+		}
+		sort.SliceStable(sessions, func(i, j int) bool { return sessions[i].EndedAt.After(sessions[j].EndedAt) })
+		writeJSON(w, http.StatusOK, struct {
+			Sessions []playtime.Session `json:"sessions"`
+		}{sessions})
+	}
+}
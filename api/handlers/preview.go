@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"shiba-api/preview"
+	"shiba-api/structs"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// previewURL returns a link that opens a draft build for preview.TTL.
+func previewURL(srv *structs.Server, gameID string, now time.Time) (string, time.Time) {
+	expires := now.Add(preview.TTL).Truncate(time.Second)
+	token := srv.Previews.Mint(gameID, expires)
+	return "/play/" + gameID + "/?" + preview.Param + "=" + url.QueryEscape(token), expires
+}
+
+// draftAccess gates the play routes of draft builds: they need a preview
+// token, from a preview link or the cookie it sets. A valid link sets the
+// cookie and redirects to the clean URL, so relative asset URLs and the CDN
+// cache key don't carry the token. Drafts without a token are answered as
+// missing. The cookie is scoped to the route it was opened on, /play or
+// /download. It reports whether the request may be served.
+func draftAccess(srv *structs.Server, w http.ResponseWriter, r *http.Request, gameID string) bool {
+	build, ok, err := lookupBuild(srv, gameID)
+	if err != nil {
+		http.Error(w, "Failed to load builds: "+err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	if !ok || !build.Draft {
+		return true
+	}
+
+	now := time.Now()
+	if token := r.URL.Query().Get(preview.Param); token != "" {
+		expires, ok := srv.Previews.Verify(gameID, token, now)
+		if !ok {
+			http.Error(w, "This preview link is invalid or expired", http.StatusForbidden)
+			return false
+		}
+		path := "/play/" + gameID + "/"
+		if strings.HasPrefix(r.URL.Path, "/download/") {
+			path = "/download/" + gameID
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     preview.Cookie,
+			Value:    token,
+			Path:     path,
+			Expires:  expires,
+			HttpOnly: true,
+			Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+			SameSite: http.SameSiteLaxMode,
+		})
+		clean := *r.URL
+		query := clean.Query()
+		query.Del(preview.Param)
+		clean.RawQuery = query.Encode()
+		w.Header().Set("Cache-Control", "no-store")
+		http.Redirect(w, r, clean.String(), http.StatusFound)
+		return false
+	}
+
+	if cookie, err := r.Cookie(preview.Cookie); err == nil {
+		if _, ok := srv.Previews.Verify(gameID, cookie.Value, now); ok {
+			w.Header().Set("Cache-Control", "private")
+			return true
+		}
+	}
+	http.Error(w, "Game not found", http.StatusNotFound)
+	return false
+}
+
+// PreviewLinkHandler mints a short-lived link to a draft build. Requires the
+// token of the user who uploaded it, or the admin token.
+func PreviewLinkHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		gameID := chi.URLParam(r, "gameId")
+
+		userID := ""
+		if !isAdmin(srv, r) {
+			user, ok := requireUser(srv, w, r)
+			if !ok {
+				return
+			}
+			userID = user.ID
+		}
+
+		state, err := loadBuilds(srv)
+		if err != nil {
+			http.Error(w, "Failed to load builds: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		build, ok := state.Builds[gameID]
+		if !ok {
+			http.Error(w, "Build not found", http.StatusNotFound)
+			return
+		}
+		if userID != "" && build.OwnerID != userID {
+			http.Error(w, "You don't own this build", http.StatusForbidden)
+			return
+		}
+		if !build.Draft {
+			http.Error(w, "Build "+gameID+" is published, its play URL needs no preview link", http.StatusConflict)
+			return
+		}
+
+		link, expires := previewURL(srv, gameID, time.Now())
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, http.StatusOK, struct {
+			GameID    string    `json:"gameId"`
+			URL       string    `json:"url"`
+			ExpiresAt time.Time `json:"expiresAt"`
+		}{gameID, link, expires.UTC()})
+	}
+}
@@ -0,0 +1,303 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"shiba-api/datastore"
+	"shiba-api/gamemeta"
+	"shiba-api/profiles"
+	"shiba-api/schema"
+	"shiba-api/stats"
+	"shiba-api/structs"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// ProfileGame is one of a creator's games, with the event it was made for.
+type ProfileGame struct {
+	ProjectID       string    `json:"projectId"`
+	GameID          string    `json:"gameId"`
+	Title           string    `json:"title"`
+	Event           string    `json:"event"`
+	CreatedAt       time.Time `json:"createdAt"`
+	PlayURL         string    `json:"playUrl"`
+	Plays           int       `json:"plays"`
+	PlaytimeSeconds int64     `json:"playtimeSeconds"`
+	Feedback        int       `json:"feedback"`
+	// Rank is the game's place in its event's revealed judging results
+	Rank *int `json:"rank,omitempty"`
+}
+
+// ProfileAward is an award one of a creator's games won.
+type ProfileAward struct {
+	Event     string `json:"event"`
+	Title     string `json:"title"`
+	GameID    string `json:"gameId"`
+	ProjectID string `json:"projectId"`
+}
+
+// ProfilePlaytime is the playtime a creator gave others' games, from play
+// sessions, and got on their own.
+type ProfilePlaytime struct {
+	GivenSeconds    int64 `json:"givenSeconds"`
+	GamesPlayed     int   `json:"gamesPlayed"`
+	ReceivedSeconds int64 `json:"receivedSeconds"`
+	Plays           int   `json:"plays"`
+	Feedback        int   `json:"feedback"`
+}
+
+// Profile is a creator's history across events. Sections hidden from the
+// caller are left out.
+type Profile struct {
+	UserID string `json:"userId"`
+	Name   string `json:"name"`
+	// Events the creator made games for, oldest first
+	Events   []string         `json:"events"`
+	Games    []ProfileGame    `json:"games,omitempty"`
+	Awards   []ProfileAward   `json:"awards,omitempty"`
+	Playtime *ProfilePlaytime `json:"playtime,omitempty"`
+	Streak   *Streak          `json:"streak,omitempty"`
+	// Settings are only returned to the creator and admins
+	Settings *profiles.Settings `json:"settings,omitempty"`
+}
+
+type updateProfileRequest struct {
+	Public bool     `json:"public"`
+	Hidden []string `json:"hidden" validate:"max=4"`
+}
+
+// revealedResults returns the judging results of every event that revealed
+// them, by event.
+func revealedResults(srv *structs.Server) (map[string]publicResults, error) {
+	var history resultsHistoryState
+	if err := srv.Store.Load(resultsHistoryDoc, &history); err != nil {
+		return nil, err
+	}
+	events := history.Events
+	if events == nil {
+		events = map[string]publicResults{}
+	}
+	// Results revealed before the history was kept
+	if _, ok := events[srv.Config.Get().EventID]; !ok {
+		var current resultsState
+		if err := srv.Store.Load(resultsDoc, &current); err != nil {
+			return nil, err
+		}
+		if current.Snapshot != nil {
+			events[srv.Config.Get().EventID] = *current.Snapshot
+		}
+	}
+	return events, nil
+}
+
+// buildProfile gathers everything a profile can show about userID.
+func buildProfile(ctx context.Context, srv *structs.Server, userID string) (Profile, error) {
+	profile := Profile{UserID: userID, Events: []string{}, Games: []ProfileGame{}, Awards: []ProfileAward{}}
+
+	builds, err := loadBuilds(srv)
+	if err != nil {
+		return profile, err
+	}
+	metas, err := gamemeta.Load(srv.Store)
+	if err != nil {
+		return profile, err
+	}
+	totals, err := stats.Load(srv.Store)
+	if err != nil {
+		return profile, err
+	}
+	results, err := revealedResults(srv)
+	if err != nil {
+		return profile, err
+	}
+
+	owned := map[string]bool{}
+	// Event IDs don't sort by date, so events go by the creator's first
+	// build in them
+	first := map[string]time.Time{}
+	for _, b := range builds.Builds {
+		if b.OwnerID != userID {
+			continue
+		}
+		owned[b.ProjectID] = true
+		event := buildEvent(b)
+		if t, ok := first[event]; !ok {
+			profile.Events = append(profile.Events, event)
+			first[event] = b.CreatedAt
+		} else if b.CreatedAt.Before(t) {
+			first[event] = b.CreatedAt
+		}
+	}
+	sort.Slice(profile.Events, func(i, j int) bool { return first[profile.Events[i]].Before(first[profile.Events[j]]) })
+
+	var playtime ProfilePlaytime
+	for _, b := range latestOwnedBuilds(builds, userID) {
+		game := totals.Games[b.ProjectID]
+		projectBuilds := builds.projectBuilds(b.ProjectID)
+		g := ProfileGame{
+			ProjectID:       b.ProjectID,
+			GameID:          b.ID,
+			Title:           metas.Projects[b.ProjectID].Title,
+			Event:           buildEvent(b),
+			CreatedAt:       projectBuilds[len(projectBuilds)-1].CreatedAt,
+			PlayURL:         buildURL(b),
+			Plays:           game.Plays,
+			PlaytimeSeconds: game.PlaytimeSeconds,
+			Feedback:        game.Feedback,
+		}
+		if g.Title == "" {
+			g.Title = lookupProject(ctx, srv, b.ProjectID).name
+		}
+		for _, pb := range projectBuilds {
+			for _, rank := range results[buildEvent(pb)].Rankings {
+				if rank.GameID == pb.ID && (g.Rank == nil || rank.Rank < *g.Rank) {
+					g.Rank = &rank.Rank
+				}
+			}
+		}
+		profile.Games = append(profile.Games, g)
+		playtime.ReceivedSeconds += game.PlaytimeSeconds
+		playtime.Plays += game.Plays
+		playtime.Feedback += game.Feedback
+	}
+	sort.Slice(profile.Games, func(i, j int) bool { return profile.Games[i].CreatedAt.After(profile.Games[j].CreatedAt) })
+
+	for event, r := range results {
+		for _, a := range r.Awards {
+			projectID := a.GameID
+			if b, ok := builds.Builds[a.GameID]; ok {
+				projectID = b.ProjectID
+			}
+			if owned[projectID] {
+				profile.Awards = append(profile.Awards, ProfileAward{event, a.Title, a.GameID, projectID})
+			}
+		}
+	}
+	sort.Slice(profile.Awards, func(i, j int) bool {
+		if profile.Awards[i].Event != profile.Awards[j].Event {
+			return results[profile.Awards[i].Event].RevealedAt.Before(results[profile.Awards[j].Event].RevealedAt)
+		}
+		return profile.Awards[i].Title < profile.Awards[j].Title
+	})
+
+	var playtests playtestsState
+	if err := srv.Store.Load(playtestsDoc, &playtests); err != nil {
+		return profile, err
+	}
+	for projectID, seconds := range playtests.Players[userID] {
+		if !owned[projectID] {
+			playtime.GivenSeconds += seconds
+			playtime.GamesPlayed++
+		}
+	}
+	profile.Playtime = &playtime
+
+	var activity activityState
+	if err := srv.Store.Load(activityDoc, &activity); err != nil {
+		return profile, err
+	}
+	streak := computeStreak(activity.Days[userID], time.Now())
+	profile.Streak = &streak
+	return profile, nil
+}
+
+// UserProfileHandler returns a creator's games, awards, playtime and streak
+// across every event. Profiles are private unless their creator made them
+// public; private ones answer 404 to anyone but the creator and admins, so
+// they don't reveal who took part. Counts as a read.
+func UserProfileHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := chi.URLParam(r, "userId")
+
+		settings, err := profiles.Get(srv.Store, userID)
+		if err != nil {
+			http.Error(w, "Failed to load profile settings: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		owner := isAdmin(srv, r)
+		if !owner && bearerToken(r) != "" {
+			if viewer, err := authenticateUser(srv, r); err == nil {
+				owner = viewer.ID == userID
+			}
+		}
+		if !owner && !settings.Public {
+			http.Error(w, "Profile not found", http.StatusNotFound)
+			return
+		}
+
+		user, err := srv.UserStore.UserByID(r.Context(), userID)
+		if errors.Is(err, datastore.ErrNotFound) {
+			http.Error(w, "Profile not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to load user: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		profile, err := buildProfile(r.Context(), srv, userID)
+		if err != nil {
+			http.Error(w, "Failed to build profile: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		profile.Name, _ = user.Fields["Name"].(string)
+
+		if owner {
+			profile.Settings = &settings
+			w.Header().Set("Cache-Control", "no-store")
+		} else {
+			if !settings.Shows(profiles.Games) {
+				profile.Games = nil
+				profile.Events = []string{}
+			}
+			if !settings.Shows(profiles.Awards) {
+				profile.Awards = nil
+			}
+			if !settings.Shows(profiles.Playtime) {
+				profile.Playtime = nil
+			}
+			if !settings.Shows(profiles.Streak) {
+				profile.Streak = nil
+			}
+			w.Header().Set("Cache-Control", "no-cache")
+		}
+		writeJSON(w, http.StatusOK, profile)
+	}
+}
+
+// UpdateProfileHandler sets who can see the calling user's profile.
+// Requires a user token.
+func UpdateProfileHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := requireUser(srv, w, r)
+		if !ok {
+			return
+		}
+		var req updateProfileRequest
+		if !bindJSON(w, r, &req) {
+			return
+		}
+		for _, section := range req.Hidden {
+			if !slices.Contains(profiles.Sections, section) {
+				invalidField(w, "hidden", schema.InBody, "unknown section %q, expected any of %s", section, strings.Join(profiles.Sections, ", "))
+				return
+			}
+		}
+		slices.Sort(req.Hidden)
+		settings, err := profiles.Set(srv.Store, user.ID, profiles.Settings{
+			Public: req.Public,
+			Hidden: slices.Compact(req.Hidden),
+		})
+		if err != nil {
+			http.Error(w, "Failed to save profile settings: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, http.StatusOK, settings)
+	}
+}
@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"shiba-api/structs"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const provenanceDoc = "provenance"
+
+// Upload channels
+const (
+	ChannelWeb         = "web"
+	ChannelCLI         = "cli"
+	ChannelCI          = "ci"
+	ChannelGodotPlugin = "godot-plugin"
+)
+
+// Provenance records how a build was uploaded. It is written once when the
+// build is registered and never changed, so it can settle "I never uploaded
+// that" reports. The only exception is the retention job, which coarsens the
+// IP and drops the user agent once they are old enough.
+type Provenance struct {
+	GameID        string    `json:"gameId"`
+	ProjectID     string    `json:"projectId"`
+	UserID        string    `json:"userId,omitempty"`
+	Channel       string    `json:"channel"`
+	ClientVersion string    `json:"clientVersion,omitempty"`
+	UserAgent     string    `json:"userAgent,omitempty"`
+	IP            string    `json:"ip"`
+	RecordedAt    time.Time `json:"recordedAt"`
+	Anonymized    bool      `json:"anonymized,omitempty"`
+}
+
+type provenanceState struct {
+	Builds map[string]Provenance `json:"builds"`
+}
+
+var errProvenanceExists = errors.New("provenance already recorded")
+
+// requestProvenance captures where an upload came from. Clients identify
+// themselves with an X-Shiba-Client header of the form "<channel>/<version>",
+// e.g. "cli/1.4.0" or "ci/1.4.0"; requests without it count as web uploads.
+func requestProvenance(r *http.Request) Provenance {
+	p := Provenance{Channel: ChannelWeb, UserAgent: r.UserAgent()}
+	if len(p.UserAgent) > 512 {
+		p.UserAgent = p.UserAgent[:512]
+	}
+
+	if client := strings.TrimSpace(r.Header.Get("X-Shiba-Client")); client != "" {
+		channel, version, _ := strings.Cut(client, "/")
+		switch channel = strings.ToLower(channel); channel {
+		case ChannelWeb, ChannelCLI, ChannelCI, ChannelGodotPlugin:
+			p.Channel = channel
+		}
+		if len(version) > 64 {
+			version = version[:64]
+		}
+		p.ClientVersion = version
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	p.IP = host
+	return p
+}
+
+func recordProvenance(srv *structs.Server, p Provenance) error {
+	var state provenanceState
+	return srv.Store.Update(provenanceDoc, &state, func() error {
+		if state.Builds == nil {
+			state.Builds = map[string]Provenance{}
+		}
+		if _, exists := state.Builds[p.GameID]; exists {
+			return errProvenanceExists
+		}
+		state.Builds[p.GameID] = p
+		return nil
+	})
+}
+
+// saveProvenance completes and stores the provenance of a registered build.
+func saveProvenance(ctx context.Context, srv *structs.Server, build Build, p Provenance) {
+	p.GameID = build.ID
+	p.ProjectID = build.ProjectID
+	p.UserID = build.OwnerID
+	p.RecordedAt = build.CreatedAt
+	if err := recordProvenance(srv, p); err != nil {
+		slog.ErrorContext(ctx, "Failed to record provenance", "game_id", build.ID, "error", err)
+	}
+}
+
+// BuildProvenanceHandler returns how one build was uploaded. Requires the
+// admin token.
+func BuildProvenanceHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(srv, r) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var state provenanceState
+		if err := srv.Store.Load(provenanceDoc, &state); err != nil {
+			http.Error(w, "Failed to load provenance: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		p, ok := state.Builds[chi.URLParam(r, "gameId")]
+		if !ok {
+			http.Error(w, "No provenance recorded for this build", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, p)
+	}
+}
+
+// ProvenanceSearchHandler lists upload records matching every given filter
+// (userId, projectId, ip, channel), newest first. Requires the admin token.
+func ProvenanceSearchHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(srv, r) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		q := r.URL.Query()
+		filters := map[string]string{
+			"userId":    q.Get("userId"),
+			"projectId": q.Get("projectId"),
+			"ip":        q.Get("ip"),
+			"channel":   q.Get("channel"),
+		}
+		if filters["userId"] == "" && filters["projectId"] == "" && filters["ip"] == "" {
+			http.Error(w, "userId, projectId or ip is required", http.StatusBadRequest)
+			return
+		}
+
+		var state provenanceState
+		if err := srv.Store.Load(provenanceDoc, &state); err != nil {
+			http.Error(w, "Failed to load provenance: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		matches := func(want, got string) bool { return want == "" || want == got }
+		records := []Provenance{}
+		for _, p := range state.Builds {
+			if matches(filters["userId"], p.UserID) &&
+				matches(filters["projectId"], p.ProjectID) &&
+				matches(filters["ip"], p.IP) &&
+				matches(filters["channel"], p.Channel) {
+				records = append(records, p)
+			}
+		}
+		sort.Slice(records, func(i, j int) bool {
+			return records[i].RecordedAt.After(records[j].RecordedAt)
+		})
+
+		writeJSON(w, http.StatusOK, struct {
+			Records []Provenance `json:"records"`
+		}{records})
+	}
+}
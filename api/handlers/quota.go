@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"shiba-api/membudget"
+	"shiba-api/quota"
+	"shiba-api/structs"
+)
+
+// quotaKey identifies who a request counts against: the user its token
+// authenticates, or the client IP for anonymous requests and tokens that
+// don't authenticate, so sending made-up tokens or minting more of them
+// doesn't get a fresh allowance.
+func quotaKey(srv *structs.Server, r *http.Request) string {
+	if bearerToken(r) != "" {
+		if user, err := authenticateUser(srv, r); err == nil {
+			return userQuotaKey(user.ID)
+		}
+	}
+	return ipQuotaKey(r)
+}
+
+func userQuotaKey(userID string) string {
+	return "user:" + userID
+}
+
+// ipQuotaKey identifies the client IP of a request, whatever token it sends.
+func ipQuotaKey(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+func setRateLimitHeaders(w http.ResponseWriter, u quota.Usage) {
+	w.Header().Set("X-RateLimit-Resource", u.Resource)
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(u.Limit))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(u.Remaining))
+	w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(u.Reset.Unix(), 10))
+}
+
+// Quota charges each request against the caller's daily allowance for
+// resource and reports it in X-RateLimit-* headers.
+func Quota(srv *structs.Server, resource string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			now := time.Now()
+			u, ok := srv.Quotas.Take(quotaKey(srv, r), resource, srv.Config.Get().QuotaLimits[resource], now)
+			setRateLimitHeaders(w, u)
+			if !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(u.Reset.Sub(now).Seconds())+1))
+				http.Error(w, "Daily "+resource+" quota exceeded, see GET /me/usage", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// MyUsageHandler reports the caller's quota usage for every resource and,
+// for user tokens, what their builds take up in storage and against their
+// storage cap.
+func MyUsageHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := struct {
+			Usage   []quota.Usage `json:"usage"`
+			Storage *CostTotal    `json:"storage,omitempty"`
+			// StorageQuota is what the user's builds take up against their
+			// storage cap
+			StorageQuota *quota.StorageUsage `json:"storageQuota,omitempty"`
+		}{Usage: srv.Quotas.Usage(quotaKey(srv, r), srv.Config.Get().QuotaLimits, time.Now())}
+
+		if bearerToken(r) != "" {
+			if user, err := authenticateUser(srv, r); err == nil {
+				storage, err := userStorage(srv, user.ID)
+				if err != nil {
+					http.Error(w, "Failed to compute storage: "+err.Error(), http.StatusInternalServerError)
+					return
+				}
+				resp.Storage = &storage
+				storageQuota, err := userStorageUsage(srv, user.ID)
+				if err != nil {
+					http.Error(w, "Failed to load storage usage: "+err.Error(), http.StatusInternalServerError)
+					return
+				}
+				resp.StorageQuota = &storageQuota
+			}
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+// acquireUploadSlot takes one of key's in-flight upload slots, so a scripted
+// uploader can't occupy every extraction at once. Callers authenticate first
+// and pass the uploader's quota key. On success the caller must call release
+// when the upload has finished processing.
+func acquireUploadSlot(srv *structs.Server, key string) (release func(), err error) {
+	release, ok := srv.UploadSlots.Acquire(key, srv.Config.Get().UploadsInFlight)
+	if !ok {
+		return nil, &uploadError{
+			status: http.StatusTooManyRequests,
+			msg:    "Too many uploads in progress, wait for one to finish",
+			code:   "uploads_in_flight",
+		}
+	}
+	return release, nil
+}
+
+// admitUpload waits for the memory an upload needs, UPLOAD_MEMORY_MB, to be
+// free in the memory budget, for up to MEMORY_QUEUE_TIMEOUT, so a rush of
+// uploads queues instead of running the server out of memory. It returns r
+// with a context the rest of the upload charges what it holds to. On success
+// the caller must call release when the upload has finished processing.
+func admitUpload(srv *structs.Server, r *http.Request) (*http.Request, func(), error) {
+	cfg := srv.Config.Get()
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(r.Context(), cfg.MemoryQueueTimeout)
+	defer cancel()
+	res, err := srv.Memory.Admit(ctx, int64(cfg.UploadMemoryMB)<<20)
+	memoryWait.Since(start)
+	if err != nil {
+		memoryRejections.Inc()
+		return nil, nil, &uploadError{
+			status: http.StatusServiceUnavailable,
+			msg:    "The server is busy processing other uploads, try again in a minute",
+			code:   "server_busy",
+		}
+	}
+	return r.WithContext(membudget.WithReservation(r.Context(), res)), res.Release, nil
+}
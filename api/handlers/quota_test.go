@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestQuotaKey(t *testing.T) {
+	tests := []struct {
+		name       string
+		remoteAddr string
+		header     string
+		want       string
+	}{
+		{"anonymous", "203.0.113.7:51234", "", "ip:203.0.113.7"},
+		{"anonymous over IPv6", "[2001:db8::1]:51234", "", "ip:2001:db8::1"},
+		{"address without port", "203.0.113.7", "", "ip:203.0.113.7"},
+		{"user token", "203.0.113.7:51234", "Bearer tok-ada", "user:recAda"},
+		{"another token of the same user", "198.51.100.2:443", "Bearer tok-ada-2", "user:recAda"},
+		{"made-up token", "203.0.113.7:51234", "Bearer tok-made-up", "ip:203.0.113.7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, _ := testServer(map[string]string{"tok-ada": "recAda", "tok-ada-2": "recAda"})
+			r := httptest.NewRequest("POST", "/uploadGame", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			if got := quotaKey(srv, r); got != tt.want {
+				t.Errorf("quotaKey() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"shiba-api/recommend"
+	"shiba-api/structs"
+	"shiba-api/sync"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// RecommendationsHandler returns "players also enjoyed" games for a game
+// record, from the last scheduled co-play computation.
+func RecommendationsHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		gameID := chi.URLParam(r, "gameId")
+
+		query := struct {
+			Limit int `query:"limit" validate:"min=1,max=20"`
+		}{Limit: 5}
+		if !bindQuery(w, r, &query) {
+			return
+		}
+		limit := query.Limit
+
+		state, err := sync.LoadRecommendations(*srv)
+		if err != nil {
+			http.Error(w, "Failed to load recommendations: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		recs := state.Games[gameID]
+		if recs == nil {
+			recs = []recommend.Recommendation{}
+		}
+		if len(recs) > limit {
+			recs = recs[:limit]
+		}
+
+		w.Header().Set("Cache-Control", "public, max-age=600")
+		writeJSON(w, http.StatusOK, struct {
+			GameID          string                     `json:"gameId"`
+			RefreshedAt     time.Time                  `json:"refreshedAt"`
+			Recommendations []recommend.Recommendation `json:"recommendations"`
+		}{gameID, state.RefreshedAt, recs})
+	}
+}
@@ -0,0 +1,21 @@
+package handlers
+
+import (
+	"net/http"
+
+	"shiba-api/logging"
+)
+
+// RequestID tags a request with the ID its log lines carry: the client's
+// X-Request-ID when it is a sensible one, else a new one. The ID is sent
+// back in the X-Request-ID header of every response.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(logging.Header)
+		if !logging.ValidRequestID(id) {
+			id = logging.NewRequestID()
+		}
+		w.Header().Set(logging.Header, id)
+		next.ServeHTTP(w, r.WithContext(logging.WithRequestID(r.Context(), id)))
+	})
+}
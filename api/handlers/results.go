@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"shiba-api/structs"
+)
+
+const resultsDoc = "results"
+
+// Revealed results are also kept per event, so creator profiles still show
+// their awards once the next event's results replace them
+const resultsHistoryDoc = "results-history"
+
+type resultsHistoryState struct {
+	// Events maps event -> its results as revealed
+	Events map[string]publicResults `json:"events"`
+}
+
+type Award struct {
+	Title  string `json:"title" validate:"required,max=200"`
+	GameID string `json:"gameId" validate:"required"`
+}
+
+type resultsState struct {
+	// RevealAt overrides RESULTS_REVEAL_AT when set
+	RevealAt *time.Time `json:"revealAt,omitempty"`
+	Awards   []Award    `json:"awards"`
+	// Snapshot holds the results frozen on first reveal, so late judging
+	// edits don't change what has already been announced
+	Snapshot *publicResults `json:"snapshot,omitempty"`
+}
+
+type publicResults struct {
+	RevealedAt time.Time `json:"revealedAt"`
+	Rankings   []Ranking `json:"rankings"`
+	Awards     []Award   `json:"awards"`
+}
+
+func (s resultsState) revealTime(srv *structs.Server) (time.Time, error) {
+	if s.RevealAt != nil {
+		return *s.RevealAt, nil
+	}
+	if at := srv.Config.Get().ResultsRevealAt; at != nil {
+		return *at, nil
+	}
+	return time.Time{}, fmt.Errorf("no reveal time configured")
+}
+
+func buildResults(srv *structs.Server, state resultsState) (*publicResults, error) {
+	var judging judgingState
+	if err := srv.Store.Load(judgingDoc, &judging); err != nil {
+		return nil, err
+	}
+	judging.init()
+
+	awards := state.Awards
+	if awards == nil {
+		awards = []Award{}
+	}
+	return &publicResults{
+		RevealedAt: time.Now().UTC(),
+		Rankings:   computeRankings(judging, srv.Config.Get().JudgingRubric),
+		Awards:     awards,
+	}, nil
+}
+
+// ResultsHandler serves the final rankings and awards. It answers 403 until the
+// reveal time so the results page can be deployed ahead of the announcement.
+// The admin token can preview live results at any time.
+func ResultsHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var query struct {
+			Preview bool `query:"preview"`
+		}
+		if !bindQuery(w, r, &query) {
+			return
+		}
+
+		var state resultsState
+		if err := srv.Store.Load(resultsDoc, &state); err != nil {
+			http.Error(w, "Failed to load results: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if isAdmin(srv, r) && query.Preview {
+			results, err := buildResults(srv, state)
+			if err != nil {
+				http.Error(w, "Failed to build results: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Cache-Control", "no-store")
+			writeJSON(w, http.StatusOK, results)
+			return
+		}
+
+		revealAt, err := state.revealTime(srv)
+		if err != nil || time.Now().Before(revealAt) {
+			resp := struct {
+				Ok       bool       `json:"ok"`
+				Message  string     `json:"message"`
+				RevealAt *time.Time `json:"revealAt,omitempty"`
+			}{Ok: false, Message: "Results have not been revealed yet"}
+			if err == nil {
+				resp.RevealAt = &revealAt
+			}
+			w.Header().Set("Cache-Control", "no-store")
+			writeJSON(w, http.StatusForbidden, resp)
+			return
+		}
+
+		if state.Snapshot == nil {
+			results, err := buildResults(srv, state)
+			if err != nil {
+				http.Error(w, "Failed to build results: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			err = srv.Store.Update(resultsDoc, &state, func() error {
+				// Another request may have frozen the results while we waited
+				if state.Snapshot == nil {
+					state.Snapshot = results
+				}
+				return nil
+			})
+			if err != nil {
+				http.Error(w, "Failed to build results: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			event := srv.Config.Get().EventID
+			var history resultsHistoryState
+			err = srv.Store.Update(resultsHistoryDoc, &history, func() error {
+				if history.Events == nil {
+					history.Events = map[string]publicResults{}
+				}
+				history.Events[event] = *state.Snapshot
+				return nil
+			})
+			if err != nil {
+				slog.ErrorContext(r.Context(), "Failed to record results", "event", event, "error", err)
+			}
+		}
+
+		w.Header().Set("Cache-Control", "public, max-age=60")
+		writeJSON(w, http.StatusOK, state.Snapshot)
+	}
+}
+
+// UpdateResultsHandler lets admins configure the reveal time and awards. Any
+// frozen snapshot is discarded so the next reveal picks up the changes.
+func UpdateResultsHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(srv, r) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req struct {
+			RevealAt *time.Time `json:"revealAt"`
+			Awards   []Award    `json:"awards"`
+		}
+		if !bindJSON(w, r, &req) {
+			return
+		}
+
+		var state resultsState
+		err := srv.Store.Update(resultsDoc, &state, func() error {
+			if req.RevealAt != nil {
+				state.RevealAt = req.RevealAt
+			}
+			if req.Awards != nil {
+				state.Awards = req.Awards
+			}
+			state.Snapshot = nil
+			return nil
+		})
+		if err != nil {
+			http.Error(w, "Failed to save results config: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, struct {
+			Ok bool `json:"ok"`
+		}{true})
+	}
+}
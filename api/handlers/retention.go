@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sort"
+	"time"
+
+	"shiba-api/stats"
+	"shiba-api/structs"
+	"shiba-api/sync"
+)
+
+// RetentionReport lists what a retention run removed, or would remove in a
+// dry run.
+type RetentionReport struct {
+	DryRun bool      `json:"dryRun"`
+	RanAt  time.Time `json:"ranAt"`
+	// Days whose hourly stats rollups were purged
+	HourlyStatsPurged []string `json:"hourlyStatsPurged"`
+	// Builds whose upload provenance was anonymized
+	ProvenanceAnonymized []string `json:"provenanceAnonymized"`
+	// Content-addressed blobs no build references any more, and their size
+	BlobsCollected     int   `json:"blobsCollected"`
+	BlobBytesCollected int64 `json:"blobBytesCollected"`
+}
+
+// anonymizeIP keeps the network of an address (/24 for IPv4, /48 for IPv6),
+// which is still enough to spot abuse from one place.
+func anonymizeIP(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ""
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return parsed.Mask(net.CIDRMask(48, 128)).String()
+}
+
+// ApplyRetention purges and anonymizes data past the configured retention
+// windows. With dryRun it only reports what would change.
+func ApplyRetention(srv *structs.Server, dryRun bool) (RetentionReport, error) {
+	cfg := srv.Config.Get()
+	now := time.Now().UTC()
+	report := RetentionReport{DryRun: dryRun, RanAt: now, HourlyStatsPurged: []string{}, ProvenanceAnonymized: []string{}}
+
+	if cfg.RawRetentionDays > 0 {
+		days, err := stats.PurgeHourly(srv.Store, now.AddDate(0, 0, -cfg.RawRetentionDays), dryRun)
+		if err != nil {
+			return report, err
+		}
+		report.HourlyStatsPurged = days
+	}
+
+	if cfg.IPRetentionDays > 0 {
+		cutoff := now.AddDate(0, 0, -cfg.IPRetentionDays)
+		var state provenanceState
+		apply := func() error {
+			for id, p := range state.Builds {
+				if p.Anonymized || !p.RecordedAt.Before(cutoff) {
+					continue
+				}
+				report.ProvenanceAnonymized = append(report.ProvenanceAnonymized, id)
+				p.IP = anonymizeIP(p.IP)
+				p.UserAgent = ""
+				p.Anonymized = true
+				state.Builds[id] = p
+			}
+			return nil
+		}
+
+		var err error
+		if dryRun {
+			if err = srv.Store.Load(provenanceDoc, &state); err == nil {
+				err = apply()
+			}
+		} else {
+			err = srv.Store.Update(provenanceDoc, &state, apply)
+		}
+		if err != nil {
+			return report, err
+		}
+		sort.Strings(report.ProvenanceAnonymized)
+	}
+
+	blobs, bytes, err := sync.CollectBlobs(context.Background(), *srv, dryRun)
+	if err != nil {
+		return report, err
+	}
+	report.BlobsCollected, report.BlobBytesCollected = blobs, bytes
+
+	return report, nil
+}
+
+// RetentionHandler runs the retention job now. Pass dryRun=true to see what
+// it would do. Requires the admin token.
+func RetentionHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(srv, r) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var query struct {
+			DryRun bool `query:"dryRun"`
+		}
+		if !bindQuery(w, r, &query) {
+			return
+		}
+
+		report, err := ApplyRetention(srv, query.DryRun)
+		if err != nil {
+			http.Error(w, "Retention failed: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, report)
+	}
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"shiba-api/gamemeta"
+	"shiba-api/schema"
+	"shiba-api/search"
+	"shiba-api/structs"
+)
+
+// searchQuery holds the search text and the gallery facets: accessibility
+// (comma-separated features every game must declare) and mobile=true.
+type searchQuery struct {
+	Q             string `query:"q" validate:"max=200"`
+	Accessibility string `query:"accessibility"`
+	Mobile        bool   `query:"mobile"`
+	Limit         int    `query:"limit" validate:"min=1,max=100"`
+}
+
+// galleryFilter builds the filter for the gallery facets of q. It returns nil
+// when no facet is set.
+func galleryFilter(q searchQuery) (search.Filter, schema.Errors) {
+	var want []string
+	var errs schema.Errors
+	for _, f := range strings.Split(q.Accessibility, ",") {
+		if f = strings.TrimSpace(f); f == "" {
+			continue
+		}
+		if !gamemeta.IsFeature(f) {
+			errs.Add("accessibility", schema.InQuery, "unknown accessibility feature %q", f)
+			continue
+		}
+		want = append(want, f)
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	mobile := q.Mobile
+	if len(want) == 0 && !mobile {
+		return nil, nil
+	}
+	return func(d search.Document) bool {
+		if mobile && !d.MobileCompatible {
+			return false
+		}
+		for _, f := range want {
+			if !slices.Contains(d.Accessibility, f) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+// GameSearchHandler runs a full-text search over published games, optionally
+// filtered by gallery facets. Facets alone browse the gallery.
+func GameSearchHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := searchQuery{Limit: 20}
+		if !bindQuery(w, r, &query) {
+			return
+		}
+		q := strings.TrimSpace(query.Q)
+		filter, errs := galleryFilter(query)
+		if len(errs) > 0 {
+			writeInvalid(w, errs)
+			return
+		}
+		if q == "" && filter == nil {
+			invalidField(w, "q", schema.InQuery, "is required without a filter")
+			return
+		}
+		limit := query.Limit
+
+		w.Header().Set("Cache-Control", "public, max-age=60")
+		writeJSON(w, http.StatusOK, struct {
+			Query   string          `json:"query"`
+			Results []search.Result `json:"results"`
+		}{q, srv.SearchIndex.SearchFiltered(q, limit, filter)})
+	}
+}
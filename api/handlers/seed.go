@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"shiba-api/datastore"
+	"shiba-api/fixtures"
+	"shiba-api/stats"
+	"shiba-api/structs"
+
+	"github.com/google/uuid"
+)
+
+// Fixtures of the golden corpus seeded games are built from: the accepted
+// ones that are quick to extract
+var seedFixtures = []struct {
+	name   string
+	engine string
+	build  func() ([]byte, error)
+}{
+	{"godot-web", "godot", fixtures.GodotBuild},
+	{"godot-web-nested", "godot", fixtures.NestedGodotBuild},
+	{"unity-webgl", "unity", fixtures.UnityBuild},
+}
+
+var (
+	seedFirstNames = []string{"Ada", "Kai", "Noor", "Mateo", "Yuki", "Zara", "Leo", "Priya", "Sam", "Ife", "Mila", "Ravi", "Lena", "Omar", "Tess", "Jun"}
+	seedLastNames  = []string{"Okafor", "Lindqvist", "Tanaka", "Haddad", "Moreau", "Silva", "Novak", "Reyes", "Kim", "Patel", "Brennan", "Adeyemi"}
+	seedAdjectives = []string{"Tiny", "Haunted", "Cosmic", "Soggy", "Neon", "Clockwork", "Sleepy", "Feral", "Paper", "Quantum"}
+	seedNouns      = []string{"Dungeon", "Shiba", "Garden", "Racer", "Lighthouse", "Bakery", "Heist", "Orbit", "Kingdom", "Submarine"}
+	seedCountries  = []string{"United States", "Canada", "India", "Germany", "Brazil", "Nigeria", "Japan", "United Kingdom"}
+	seedChangelogs = []string{"First playable version", "Fixed the jump feeling floaty", "Added sound effects and a title screen", "New level and a boss fight"}
+)
+
+type seedRequest struct {
+	Users int `json:"users" validate:"min=0,max=100"`
+	// Days of stats and activity history to make up, ending today
+	Days int `json:"days" validate:"min=0,max=30"`
+}
+
+type seededUser struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Role  string `json:"role,omitempty"`
+	Token string `json:"token"`
+}
+
+type seededProject struct {
+	ProjectID string `json:"projectId"`
+	Name      string `json:"name"`
+	OwnerID   string `json:"ownerId"`
+	GameID    string `json:"gameId"`
+	PlayURL   string `json:"playUrl"`
+	Fixture   string `json:"fixture"`
+}
+
+type seedResponse struct {
+	Users    []seededUser    `json:"users"`
+	Projects []seededProject `json:"projects"`
+}
+
+// SeedHandler fills a fresh deployment with made-up users, games built from
+// the fixture corpus, stats and feedback, so frontend work and new
+// contributors have data to look at. Users and games go to the datastore,
+// which must be one the API owns (DATASTORE=postgres); builds go through the
+// same extraction and publishing as uploads. Seeding adds to what is there.
+// Requires the admin token and SEED_ENABLED=true.
+func SeedHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(srv, r) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !srv.Config.Get().Seeding {
+			http.Error(w, "Seeding is disabled, set SEED_ENABLED=true on staging deployments", http.StatusForbidden)
+			return
+		}
+		userSeeder, ok := srv.UserStore.(datastore.Seeder)
+		gameSeeder, ok2 := srv.GameStore.(datastore.Seeder)
+		if !ok || !ok2 {
+			http.Error(w, "Seeding needs DATASTORE=postgres, it never writes to Airtable", http.StatusConflict)
+			return
+		}
+
+		req := seedRequest{Users: 8, Days: 14}
+		if r.ContentLength != 0 && !bindJSON(w, r, &req) {
+			return
+		}
+
+		ctx := r.Context()
+		now := time.Now().UTC()
+		resp := seedResponse{Users: []seededUser{}, Projects: []seededProject{}}
+		for i := 0; i < req.Users; i++ {
+			user, err := seedUser(ctx, userSeeder, i == 0)
+			if err != nil {
+				http.Error(w, "Failed to seed users: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			resp.Users = append(resp.Users, user)
+
+			for j := rand.IntN(2) + 1; j > 0; j-- {
+				project, err := seedProject(ctx, srv, gameSeeder, user.ID)
+				if err != nil {
+					http.Error(w, "Failed to seed games: "+err.Error(), http.StatusInternalServerError)
+					return
+				}
+				resp.Projects = append(resp.Projects, project)
+				if err := seedHistory(srv, project.ProjectID, user.ID, req.Days, now); err != nil {
+					http.Error(w, "Failed to seed activity: "+err.Error(), http.StatusInternalServerError)
+					return
+				}
+			}
+		}
+		if err := srv.Stats.Flush(); err != nil {
+			http.Error(w, "Failed to save stats: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusCreated, resp)
+	}
+}
+
+func seedPick(list []string) string {
+	return list[rand.IntN(len(list))]
+}
+
+// seedUser makes up a user old enough to be eligible, with a fresh token.
+// reviewer users can judge.
+func seedUser(ctx context.Context, seeder datastore.Seeder, reviewer bool) (seededUser, error) {
+	token, err := newUserToken()
+	if err != nil {
+		return seededUser{}, err
+	}
+	user := seededUser{
+		ID:    "recSeed" + newKioskID()[:10],
+		Name:  seedPick(seedFirstNames) + " " + seedPick(seedLastNames),
+		Token: token,
+	}
+	birthday := time.Now().AddDate(-13-rand.IntN(5), -rand.IntN(12), 0)
+	fields := map[string]any{
+		"Name":     user.Name,
+		"Email":    user.ID + "@example.com",
+		"birthday": birthday.Format("2006-01-02"),
+		"country":  seedPick(seedCountries),
+	}
+	if reviewer {
+		user.Role = "reviewer"
+		fields["Role"] = user.Role
+	}
+	return user, seeder.PutUser(ctx, user.ID, fields, token)
+}
+
+// seedProject publishes a build of a random fixture for ownerID and records
+// the project's game record.
+func seedProject(ctx context.Context, srv *structs.Server, seeder datastore.Seeder, ownerID string) (seededProject, error) {
+	fixture := seedFixtures[rand.IntN(len(seedFixtures))]
+	data, err := fixture.build()
+	if err != nil {
+		return seededProject{}, fmt.Errorf("failed to build fixture %s: %v", fixture.name, err)
+	}
+	tmp, err := os.CreateTemp("", "seed-*.zip")
+	if err != nil {
+		return seededProject{}, err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return seededProject{}, err
+	}
+
+	id, err := uuid.NewV7()
+	if err != nil {
+		return seededProject{}, err
+	}
+	destDir := filepath.Join("./games", id.String())
+	maxBytes := int64(srv.Config.Get().MaxBuildSizeMB) << 20
+	if err := extractGame(ctx, tmp.Name(), nil, destDir, maxBytes, nil); err != nil {
+		return seededProject{}, fmt.Errorf("failed to extract fixture %s: %v", fixture.name, err)
+	}
+	if err := applyBuildPermissions(srv, destDir); err != nil {
+		return seededProject{}, err
+	}
+
+	project := seededProject{
+		ProjectID: "recSeed" + newKioskID()[:10],
+		Name:      seedPick(seedAdjectives) + " " + seedPick(seedNouns),
+		OwnerID:   ownerID,
+		Fixture:   fixture.name,
+	}
+	build := registerBuild(ctx, srv, id.String(), ownerID, uploadMeta{
+		projectID: project.ProjectID,
+		changelog: seedPick(seedChangelogs),
+		engine:    fixture.engine,
+	})
+	queueSync(context.WithoutCancel(ctx), srv, build.ID, destDir)
+	project.GameID = build.ID
+	project.PlayURL = buildURL(build)
+
+	err = seeder.PutGame(ctx, project.ProjectID, map[string]any{
+		"Name":             project.Name,
+		"Owner":            []any{ownerID},
+		"PlayLink":         project.PlayURL,
+		"HackatimeSeconds": float64(rand.IntN(40*3600) + 3600),
+	})
+	return project, err
+}
+
+// seedHistory makes up a project's plays, playtime and feedback over the
+// last days, and its owner's activity streak.
+func seedHistory(srv *structs.Server, projectID, ownerID string, days int, now time.Time) error {
+	for d := 0; d < days; d++ {
+		day := now.AddDate(0, 0, -d)
+		plays := rand.IntN(20)
+		for p := 0; p < plays; p++ {
+			at := day.Add(-time.Duration(rand.IntN(24*60)) * time.Minute)
+			srv.Stats.Add(projectID, at, stats.Counts{Plays: 1, PlaytimeSeconds: int64(30 + rand.IntN(900))})
+		}
+		if rand.IntN(3) == 0 {
+			srv.Stats.Add(projectID, day, stats.Counts{Feedback: rand.IntN(3) + 1})
+		}
+		// Owners keep a streak most days
+		if rand.IntN(4) > 0 {
+			kind := []string{ActivityFeedback, ActivityPlaytime}[rand.IntN(2)]
+			if err := recordActivity(srv, ownerID, kind, day); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
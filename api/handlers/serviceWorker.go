@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"shiba-api/structs"
+)
+
+// serviceWorkerFile is the path, relative to a build, the generated service
+// worker is served at. It shadows a build file of the same name.
+const serviceWorkerFile = "shiba-sw.js"
+
+// Builds larger than this are cached as files are fetched instead of all up
+// front, so a first visit doesn't download assets the game never loads
+const maxPrecacheBytes = 128 << 20
+
+// The worker serves a build cache-first. Builds are immutable (a new upload
+// gets a new gameId), so cached files never go stale; older versions of the
+// same project are dropped when a new one activates.
+var serviceWorkerTemplate = template.Must(template.New("sw").Parse(`// Generated by Shiba for build {{.GameID}}
+const PREFIX = {{.Prefix}};
+const CACHE = {{.Cache}};
+const PRECACHE = {{.Precache}};
+
+self.addEventListener("install", (event) => {
+  event.waitUntil(
+    caches.open(CACHE)
+      .then((cache) => cache.addAll(PRECACHE))
+      .catch(() => {})
+      .then(() => self.skipWaiting())
+  );
+});
+
+self.addEventListener("activate", (event) => {
+  event.waitUntil(
+    caches.keys()
+      .then((keys) => Promise.all(keys
+        .filter((key) => key.startsWith(PREFIX) && key !== CACHE)
+        .map((key) => caches.delete(key))))
+      .then(() => self.clients.claim())
+  );
+});
+
+self.addEventListener("fetch", (event) => {
+  const req = event.request;
+  if (req.method !== "GET" || req.headers.has("range") || !req.url.startsWith(self.registration.scope)) {
+    return;
+  }
+  event.respondWith(
+    caches.open(CACHE).then((cache) =>
+      cache.match(req).then((hit) => hit || fetch(req).then((res) => {
+        if (res.status === 200) {
+          cache.put(req, res.clone());
+        }
+        return res;
+      }))
+    )
+  );
+});
+`))
+
+func jsString(v any) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// renderServiceWorker generates the worker of a build from its file
+// manifest.
+func renderServiceWorker(manifest BuildManifest) ([]byte, error) {
+	base := "/play/" + manifest.GameID + "/"
+	precache := []string{}
+	var total int64
+	for _, f := range manifest.Files {
+		total += f.Size
+	}
+	if total <= maxPrecacheBytes {
+		precache = append(precache, base)
+		for _, f := range manifest.Files {
+			if f.Path == serviceWorkerFile {
+				continue
+			}
+			segments := strings.Split(f.Path, "/")
+			for i, s := range segments {
+				segments[i] = url.PathEscape(s)
+			}
+			precache = append(precache, base+strings.Join(segments, "/"))
+		}
+	}
+
+	prefix := "shiba-" + manifest.ProjectID + "-"
+	var buf bytes.Buffer
+	err := serviceWorkerTemplate.Execute(&buf, map[string]string{
+		"GameID":   manifest.GameID,
+		"Prefix":   jsString(prefix),
+		"Cache":    jsString(prefix + manifest.GameID),
+		"Precache": jsString(precache),
+	})
+	return buf.Bytes(), err
+}
+
+// loadBuildManifest decodes the stored manifest of a build, nil if there is
+// none yet.
+func loadBuildManifest(srv *structs.Server, gameID string) (*BuildManifest, error) {
+	signed, err := loadManifest(srv, gameID)
+	if err != nil || signed == nil {
+		return nil, err
+	}
+	var manifest BuildManifest
+	if err := json.Unmarshal([]byte(signed.Manifest), &manifest); err != nil {
+		return nil, err
+	}
+	return &manifest, nil
+}
+
+// serveServiceWorker answers /play/{gameId}/shiba-sw.js.
+func serveServiceWorker(srv *structs.Server, w http.ResponseWriter, r *http.Request, gameID string) {
+	manifest, err := loadBuildManifest(srv, gameID)
+	if err != nil {
+		http.Error(w, "Failed to load manifest: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if manifest == nil || !srv.Config.Get().ServiceWorkers {
+		http.NotFound(w, r)
+		return
+	}
+
+	sw, err := renderServiceWorker(*manifest)
+	if err != nil {
+		http.Error(w, "Failed to render service worker: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+	// Browsers check for worker updates on every navigation; the worker of a
+	// build never changes, but keep it short-lived so disabling takes effect
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(sw)
+}
+
+const registerSnippet = `<script>if ("serviceWorker" in navigator) navigator.serviceWorker.register(%s, {scope: %s}).catch(function () {});</script>`
+
+var headCloseTag = regexp.MustCompile(`(?i)</head\s*>`)
+
+// serveGamePage serves a build's index.html with the tags pointing link
+// unfurlers at its social card, the dev channel listener, the crash
+// reporter and the play beacon, registering the build's service worker when one can be
+// generated.
+func serveGamePage(srv *structs.Server, w http.ResponseWriter, r *http.Request, gameID, path string) {
+	var snippet []byte
+	if build, ok, err := lookupBuild(srv, gameID); err == nil && !build.Draft {
+		snippet = append(snippet, socialMeta(r, gameID)...)
+		if ok {
+			snippet = append(snippet, fmt.Sprintf(devSnippet, jsString(gameID), jsString(devChannelURL(r, build.ProjectID)))...)
+		}
+		snippet = append(snippet, fmt.Sprintf(crashSnippet, jsString("/v1/games/"+url.PathEscape(gameID)+"/crashes"))...)
+		snippet = append(snippet, fmt.Sprintf(playSnippet, jsString("/v1/analytics/play"), jsString(gameID))...)
+	}
+	manifest, err := loadBuildManifest(srv, gameID)
+	if err == nil && manifest != nil && srv.Config.Get().ServiceWorkers {
+		base := "/play/" + gameID + "/"
+		snippet = append(snippet, fmt.Sprintf(registerSnippet, jsString(base+serviceWorkerFile), jsString(base))...)
+	}
+	if len(snippet) == 0 {
+		http.ServeFile(w, r, path)
+		return
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		http.ServeFile(w, r, path)
+		return
+	}
+	info, err := os.Stat(path)
+	modTime := time.Time{}
+	if err == nil {
+		modTime = info.ModTime()
+	}
+
+	if loc := headCloseTag.FindIndex(data); loc != nil {
+		data = append(data[:loc[0]:loc[0]], append(snippet, data[loc[0]:]...)...)
+	} else {
+		data = append(data, snippet...)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	http.ServeContent(w, r, "index.html", modTime, bytes.NewReader(data))
+}
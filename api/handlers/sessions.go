@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"shiba-api/datastore"
+	"shiba-api/structs"
+	"shiba-api/users"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mehanizm/airtable"
+)
+
+const sessionsDoc = "sessions"
+
+// Session tokens are told apart from account tokens by their prefix
+const sessionTokenPrefix = "sess_"
+
+// Last-used metadata is only written when it is older than this, so busy
+// clients don't write to the store on every request
+const sessionTouchInterval = 5 * time.Minute
+
+var errSessionNotFound = errors.New("session not found")
+
+// Session is a token issued to one device of a user, e.g. a laptop's Godot
+// plugin or a CI job, that can be revoked on its own.
+type Session struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"userId"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+	// Seen on the last request made with the session. The IP is anonymized
+	// to its network on capture.
+	LastUsedAt    time.Time `json:"lastUsedAt"`
+	LastIP        string    `json:"lastIp,omitempty"`
+	UserAgent     string    `json:"userAgent,omitempty"`
+	Channel       string    `json:"channel"`
+	ClientVersion string    `json:"clientVersion,omitempty"`
+	// TokenKey is the token's key in the token cache, users.HashToken of
+	// it, so revoking the session can evict it. Only kept in the store.
+	TokenKey string `json:"tokenKey,omitempty"`
+}
+
+type sessionsState struct {
+	// Keyed by token hash
+	Sessions map[string]Session `json:"sessions"`
+}
+
+func (s *sessionsState) init() {
+	if s.Sessions == nil {
+		s.Sessions = map[string]Session{}
+	}
+}
+
+// touch records the request as the session's latest use.
+func (s *Session) touch(r *http.Request, now time.Time) {
+	p := requestProvenance(r)
+	s.LastUsedAt = now
+	s.LastIP = anonymizeIP(p.IP)
+	s.UserAgent = p.UserAgent
+	s.Channel = p.Channel
+	s.ClientVersion = p.ClientVersion
+}
+
+// authenticateSession looks up the user owning a session token and records
+// the use.
+func authenticateSession(srv *structs.Server, r *http.Request, token string) (*airtable.Record, error) {
+	hash := hashKioskToken(token)
+	var state sessionsState
+	if err := srv.Store.Load(sessionsDoc, &state); err != nil {
+		return nil, err
+	}
+	session, ok := state.Sessions[hash]
+	if !ok {
+		return nil, errUnauthorized
+	}
+
+	now := time.Now().UTC()
+	if now.Sub(session.LastUsedAt) >= sessionTouchInterval {
+		var latest sessionsState
+		err := srv.Store.Update(sessionsDoc, &latest, func() error {
+			s, ok := latest.Sessions[hash]
+			if !ok {
+				return errSessionNotFound
+			}
+			s.touch(r, now)
+			latest.Sessions[hash] = s
+			return nil
+		})
+		if errors.Is(err, errSessionNotFound) {
+			return nil, errUnauthorized
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	user, err := srv.UserStore.UserByID(r.Context(), session.UserID)
+	if errors.Is(err, datastore.ErrNotFound) {
+		return nil, errUnauthorized
+	}
+	return user, err
+}
+
+// CreateSessionHandler issues a session token for a new device. The token is
+// only shown once.
+func CreateSessionHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := requireUser(srv, w, r)
+		if !ok {
+			return
+		}
+
+		var req struct {
+			Name string `json:"name" validate:"required,max=100"`
+		}
+		if !bindJSON(w, r, &req) {
+			return
+		}
+
+		b := make([]byte, 24)
+		if _, err := rand.Read(b); err != nil {
+			http.Error(w, "Failed to generate token: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		token := sessionTokenPrefix + hex.EncodeToString(b)
+
+		now := time.Now().UTC()
+		session := Session{
+			ID:        newKioskID(),
+			UserID:    user.ID,
+			Name:      strings.TrimSpace(req.Name),
+			CreatedAt: now,
+		}
+		session.touch(r, now)
+
+		stored := session
+		stored.TokenKey = users.HashToken(token)
+		var state sessionsState
+		err := srv.Store.Update(sessionsDoc, &state, func() error {
+			state.init()
+			state.Sessions[hashKioskToken(token)] = stored
+			return nil
+		})
+		if err != nil {
+			http.Error(w, "Failed to save session: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, http.StatusOK, struct {
+			Session
+			Token string `json:"token"`
+		}{session, token})
+	}
+}
+
+// MySessionsHandler lists the caller's sessions, most recently used first.
+func MySessionsHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := requireUser(srv, w, r)
+		if !ok {
+			return
+		}
+
+		var state sessionsState
+		if err := srv.Store.Load(sessionsDoc, &state); err != nil {
+			http.Error(w, "Failed to load sessions: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		type sessionView struct {
+			Session
+			Current bool `json:"current"`
+		}
+		current := hashKioskToken(bearerToken(r))
+		sessions := []sessionView{}
+		for hash, s := range state.Sessions {
+			if s.UserID == user.ID {
+				s.TokenKey = ""
+				sessions = append(sessions, sessionView{s, hash == current})
+			}
+		}
+		sort.Slice(sessions, func(i, j int) bool {
+			return sessions[i].LastUsedAt.After(sessions[j].LastUsedAt)
+		})
+
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, http.StatusOK, struct {
+			Sessions []sessionView `json:"sessions"`
+		}{sessions})
+	}
+}
+
+// RevokeSessionHandler deletes one of the caller's sessions. Its token stops
+// working immediately.
+func RevokeSessionHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := requireUser(srv, w, r)
+		if !ok {
+			return
+		}
+		sessionID := chi.URLParam(r, "sessionId")
+
+		var state sessionsState
+		var revoked Session
+		err := srv.Store.Update(sessionsDoc, &state, func() error {
+			for hash, s := range state.Sessions {
+				if s.ID == sessionID && s.UserID == user.ID {
+					delete(state.Sessions, hash)
+					revoked = s
+					return nil
+				}
+			}
+			return errSessionNotFound
+		})
+		if errors.Is(err, errSessionNotFound) {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to revoke session: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// Sessions from before TokenKey was kept drop out of the cache
+		// within users.TokenTTL
+		if revoked.TokenKey != "" {
+			srv.Tokens.Forget(revoked.TokenKey, time.Now())
+		}
+
+		writeJSON(w, http.StatusOK, struct {
+			Ok bool   `json:"ok"`
+			ID string `json:"id"`
+		}{true, sessionID})
+	}
+}
@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"shiba-api/structs"
+
+	"github.com/go-chi/chi/v5"
+	"rsc.io/qr"
+)
+
+const shortlinksDoc = "shortlinks"
+
+// Short codes avoid characters that are easy to misread off a screen
+const shortcodeAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+const shortcodeLength = 6
+
+type shortlinksState struct {
+	// Codes maps short code -> project ID
+	Codes map[string]string `json:"codes"`
+	// Projects maps project ID -> short code
+	Projects map[string]string `json:"projects"`
+}
+
+func newShortcode() (string, error) {
+	b := make([]byte, shortcodeLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	for i := range b {
+		b[i] = shortcodeAlphabet[int(b[i])%len(shortcodeAlphabet)]
+	}
+	return string(b), nil
+}
+
+// publicBaseURL is the origin short links are printed with: PUBLIC_URL, or
+// the host the request came in on. Responses built with the latter must go
+// through hostDerived.
+func publicBaseURL(r *http.Request) string {
+	if base := os.Getenv("PUBLIC_URL"); base != "" {
+		return strings.TrimSuffix(base, "/")
+	}
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// hostDerived keeps a response that embeds publicBaseURL out of shared
+// caches when PUBLIC_URL is unset: its origin then comes from the Host and
+// X-Forwarded-Proto headers, which any client can forge to poison a CDN.
+// Responses that should be cached are cached privately instead.
+func hostDerived(w http.ResponseWriter) {
+	if os.Getenv("PUBLIC_URL") != "" {
+		return
+	}
+	cache := w.Header().Get("Cache-Control")
+	if cache == "" || cache == "no-cache" {
+		w.Header().Set("Cache-Control", "private, no-cache")
+		return
+	}
+	w.Header().Set("Cache-Control", strings.Replace(cache, "public", "private", 1))
+}
+
+// buildURL is where a build is played or downloaded.
+func buildURL(b Build) string {
+	if b.ListingType == ListingDownloadable {
+		return "/download/" + b.ID
+	}
+	return "/play/" + b.ID + "/"
+}
+
+// CreateShortlinkHandler returns the short link of a project, creating it on
+// first use. The link always points at the project's current build. Only
+// owners of the project and admins can create it.
+func CreateShortlinkHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		projectID := chi.URLParam(r, "projectId")
+		if !requireProjectOwner(srv, w, r, projectID) {
+			return
+		}
+
+		builds, err := loadBuilds(srv)
+		if err != nil {
+			http.Error(w, "Failed to load builds: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if len(builds.projectBuilds(projectID)) == 0 {
+			http.Error(w, "Project not found", http.StatusNotFound)
+			return
+		}
+
+		var code string
+		var state shortlinksState
+		err = srv.Store.Update(shortlinksDoc, &state, func() error {
+			if state.Codes == nil {
+				state.Codes = map[string]string{}
+				state.Projects = map[string]string{}
+			}
+			if existing, ok := state.Projects[projectID]; ok {
+				code = existing
+				return nil
+			}
+			for {
+				c, err := newShortcode()
+				if err != nil {
+					return err
+				}
+				if _, taken := state.Codes[c]; !taken {
+					code = c
+					break
+				}
+			}
+			state.Codes[code] = projectID
+			state.Projects[projectID] = code
+			return nil
+		})
+		if err != nil {
+			http.Error(w, "Failed to save short link: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		short := publicBaseURL(r) + "/g/" + code
+		writeJSON(w, http.StatusOK, struct {
+			Code  string `json:"code"`
+			URL   string `json:"url"`
+			QRPNG string `json:"qrPng"`
+			QRSVG string `json:"qrSvg"`
+		}{code, short, short + "/qr.png", short + "/qr.svg"})
+	}
+}
+
+// resolveShortlink finds the current build behind a short code.
+func resolveShortlink(srv *structs.Server, code string) (Build, bool, error) {
+	var state shortlinksState
+	if err := srv.Store.Load(shortlinksDoc, &state); err != nil {
+		return Build{}, false, err
+	}
+	projectID, ok := state.Codes[strings.ToLower(code)]
+	if !ok {
+		return Build{}, false, nil
+	}
+	builds, err := loadBuilds(srv)
+	if err != nil {
+		return Build{}, false, err
+	}
+	current, ok := builds.currentBuild(projectID)
+	return current, ok, nil
+}
+
+// ShortlinkRedirectHandler sends a short link to the project's current build,
+// or the version pinned with ?v=.
+func ShortlinkRedirectHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var links shortlinksState
+		if err := srv.Store.Load(shortlinksDoc, &links); err != nil {
+			http.Error(w, "Failed to resolve link: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		projectID, ok := links.Codes[strings.ToLower(chi.URLParam(r, "shortcode"))]
+		if !ok {
+			http.Error(w, "Link not found", http.StatusNotFound)
+			return
+		}
+		builds, err := loadBuilds(srv)
+		if err != nil {
+			http.Error(w, "Failed to resolve link: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		build, ok := resolveProjectBuild(&builds, projectID, w, r)
+		if !ok {
+			return
+		}
+		http.Redirect(w, r, buildURL(build), http.StatusFound)
+	}
+}
+
+// qrSVG draws code as an SVG with a 4 module quiet zone.
+func qrSVG(code *qr.Code) string {
+	size := code.Size + 8
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, size, size)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, size, size)
+	for y := 0; y < code.Size; y++ {
+		for x := 0; x < code.Size; x++ {
+			if code.Black(x, y) {
+				fmt.Fprintf(&b, "M%d %dh1v1h-1z", x+4, y+4)
+			}
+		}
+	}
+	b.WriteString(`"/></svg>`)
+	return b.String()
+}
+
+// ShortlinkQRHandler renders the short link as a QR code, PNG or SVG by the
+// format URL param. PNGs take an optional scale (pixels per module, 1-32).
+func ShortlinkQRHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		shortcode := chi.URLParam(r, "shortcode")
+		if _, ok, err := resolveShortlink(srv, shortcode); err != nil {
+			http.Error(w, "Failed to resolve link: "+err.Error(), http.StatusInternalServerError)
+			return
+		} else if !ok {
+			http.Error(w, "Link not found", http.StatusNotFound)
+			return
+		}
+
+		code, err := qr.Encode(publicBaseURL(r)+"/g/"+strings.ToLower(shortcode), qr.M)
+		if err != nil {
+			http.Error(w, "Failed to encode QR code: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+		hostDerived(w)
+		switch chi.URLParam(r, "format") {
+		case "png":
+			query := struct {
+				Scale int `query:"scale" validate:"min=1,max=32"`
+			}{Scale: code.Scale}
+			if !bindQuery(w, r, &query) {
+				return
+			}
+			code.Scale = query.Scale
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(code.PNG())
+		case "svg":
+			w.Header().Set("Content-Type", "image/svg+xml")
+			w.Write([]byte(qrSVG(code)))
+		default:
+			http.Error(w, "Format must be png or svg", http.StatusNotFound)
+		}
+	}
+}
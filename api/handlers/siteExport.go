@@ -0,0 +1,358 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"shiba-api/gamemeta"
+	"shiba-api/stats"
+	"shiba-api/structs"
+	"shiba-api/sync"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const siteExportsDoc = "site-exports"
+
+// Exports still building after this long were cut short by a restart
+const siteExportTimeout = 6 * time.Hour
+
+// Site export statuses
+const (
+	ExportBuilding = "building"
+	ExportReady    = "ready"
+	ExportFailed   = "failed"
+)
+
+// SiteExport is an event's gallery rendered as a static site, so it stays
+// browsable once the API is gone.
+type SiteExport struct {
+	Event  string `json:"event"`
+	Status string `json:"status"`
+	// Prefix is where the site is in the export bucket; its index.html is
+	// the gallery
+	Prefix string `json:"prefix"`
+	URL    string `json:"url,omitempty"`
+	Games  int    `json:"games"`
+	Files  int    `json:"files"`
+	Bytes  int64  `json:"bytes"`
+	// Missing lists builds without a manifest, whose files aren't known;
+	// the gallery still lists them
+	Missing    []string   `json:"missing,omitempty"`
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	Error      string     `json:"error,omitempty"`
+}
+
+type siteExportsState struct {
+	// Exports maps event -> its latest export
+	Exports map[string]SiteExport `json:"exports"`
+}
+
+// exportedGame is a game as listed in an exported gallery and its
+// games.json.
+type exportedGame struct {
+	ProjectID       string    `json:"projectId"`
+	GameID          string    `json:"gameId"`
+	Title           string    `json:"title"`
+	Creator         string    `json:"creator"`
+	Description     string    `json:"description"`
+	Tags            []string  `json:"tags"`
+	Engine          string    `json:"engine"`
+	CreatedAt       time.Time `json:"createdAt"`
+	Plays           int       `json:"plays"`
+	PlaytimeSeconds int64     `json:"playtimeSeconds"`
+	// URL is relative to the site's root, empty when the build's files
+	// weren't exported
+	URL          string `json:"url,omitempty"`
+	Downloadable bool   `json:"downloadable"`
+}
+
+var errExportInProgress = errors.New("export already in progress")
+
+func siteExportPrefix(event string) string {
+	prefix := os.Getenv("EXPORT_PREFIX")
+	if prefix == "" {
+		prefix = "exports"
+	}
+	return path.Join(prefix, event)
+}
+
+// eventBuilds returns the build each project of an event is exported with:
+// its pinned build if that belongs to the event, else its newest published
+// build from the event. Oldest projects first.
+func eventBuilds(state *buildsState, event string) []Build {
+	latest := map[string]Build{}
+	for _, b := range state.Builds {
+		if b.Draft || buildEvent(b) != event {
+			continue
+		}
+		projectID := b.ProjectID
+		if projectID == "" {
+			projectID = b.ID
+		}
+		prev, ok := latest[projectID]
+		pinned := state.Current[projectID]
+		switch {
+		case !ok, b.ID == pinned:
+			latest[projectID] = b
+		case prev.ID != pinned && b.CreatedAt.After(prev.CreatedAt):
+			latest[projectID] = b
+		}
+	}
+	builds := make([]Build, 0, len(latest))
+	for _, b := range latest {
+		builds = append(builds, b)
+	}
+	sort.Slice(builds, func(i, j int) bool { return builds[i].CreatedAt.Before(builds[j].CreatedAt) })
+	return builds
+}
+
+// ExportSiteHandler renders every published game of an event as a static
+// site in the export bucket, in the background: a gallery page, games.json
+// with their metadata, and a copy of each build's files, taken from cold
+// storage for archived builds. Poll SiteExportHandler until it's ready.
+// Exporting again replaces the site. Requires the admin token.
+func ExportSiteHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(srv, r) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		event := chi.URLParam(r, "event")
+
+		export := SiteExport{
+			Event:     event,
+			Status:    ExportBuilding,
+			Prefix:    siteExportPrefix(event),
+			StartedAt: time.Now().UTC(),
+		}
+		if base := os.Getenv("EXPORT_PUBLIC_URL"); base != "" {
+			export.URL = strings.TrimSuffix(base, "/") + "/" + export.Prefix + "/index.html"
+		}
+		var state siteExportsState
+		err := srv.Store.Update(siteExportsDoc, &state, func() error {
+			if state.Exports == nil {
+				state.Exports = map[string]SiteExport{}
+			}
+			if prev, ok := state.Exports[event]; ok && prev.Status == ExportBuilding && time.Since(prev.StartedAt) < siteExportTimeout {
+				return errExportInProgress
+			}
+			state.Exports[event] = export
+			return nil
+		})
+		if errors.Is(err, errExportInProgress) {
+			http.Error(w, "This event is already being exported", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to record export: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		go func() {
+			ctx := context.Background()
+			result, err := buildSiteExport(ctx, srv, export)
+			finishSiteExport(ctx, srv, result, err)
+		}()
+
+		w.Header().Set("Location", "/admin/events/"+event+"/export")
+		writeJSON(w, http.StatusAccepted, export)
+	}
+}
+
+// SiteExportHandler reports the status of an event's latest export.
+// Requires the admin token.
+func SiteExportHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(srv, r) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var state siteExportsState
+		if err := srv.Store.Load(siteExportsDoc, &state); err != nil {
+			http.Error(w, "Failed to load exports: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		export, ok := state.Exports[chi.URLParam(r, "event")]
+		if !ok {
+			http.Error(w, "This event was never exported", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, http.StatusOK, export)
+	}
+}
+
+func finishSiteExport(ctx context.Context, srv *structs.Server, result SiteExport, buildErr error) {
+	if buildErr != nil {
+		slog.ErrorContext(ctx, "Site export failed", "event", result.Event, "error", buildErr)
+	} else {
+		slog.InfoContext(ctx, "Exported event site", "event", result.Event, "games", result.Games, "prefix", result.Prefix)
+	}
+	now := time.Now().UTC()
+	result.FinishedAt = &now
+	result.Status = ExportReady
+	if buildErr != nil {
+		result.Status, result.Error = ExportFailed, buildErr.Error()
+	}
+	var state siteExportsState
+	err := srv.Store.Update(siteExportsDoc, &state, func() error {
+		if prev, ok := state.Exports[result.Event]; !ok || !prev.StartedAt.Equal(result.StartedAt) {
+			return nil // replaced meanwhile
+		}
+		state.Exports[result.Event] = result
+		return nil
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to record site export", "event", result.Event, "error", err)
+	}
+}
+
+// buildSiteExport copies the event's builds and then writes the pages, so
+// the gallery of a failed export never links to missing files.
+func buildSiteExport(ctx context.Context, srv *structs.Server, export SiteExport) (SiteExport, error) {
+	state, err := loadBuilds(srv)
+	if err != nil {
+		return export, err
+	}
+	metas, err := gamemeta.Load(srv.Store)
+	if err != nil {
+		return export, err
+	}
+	totals, err := stats.Load(srv.Store)
+	if err != nil {
+		return export, err
+	}
+
+	games := []exportedGame{}
+	export.Missing = []string{}
+	for _, b := range eventBuilds(&state, export.Event) {
+		projectID := b.ProjectID
+		if projectID == "" {
+			projectID = b.ID
+		}
+		meta := metas.Projects[projectID]
+		g := exportedGame{
+			ProjectID:       projectID,
+			GameID:          b.ID,
+			Title:           meta.Title,
+			Description:     meta.Description,
+			Tags:            meta.Tags,
+			Engine:          meta.Engine,
+			CreatedAt:       b.CreatedAt,
+			Plays:           totals.Games[projectID].Plays,
+			PlaytimeSeconds: totals.Games[projectID].PlaytimeSeconds,
+			Downloadable:    b.ListingType == ListingDownloadable,
+		}
+		if g.Title == "" {
+			g.Title = lookupProject(ctx, srv, projectID).name
+		}
+		if g.Tags == nil {
+			g.Tags = []string{}
+		}
+		ownerID := b.OwnerID
+		if meta.OwnerID != "" {
+			ownerID = meta.OwnerID
+		}
+		if ownerID != "" {
+			if user, err := srv.UserStore.UserByID(ctx, ownerID); err == nil {
+				g.Creator, _ = user.Fields["Name"].(string)
+			}
+		}
+
+		manifest, err := loadBuildManifest(srv, b.ID)
+		if err != nil {
+			return export, err
+		}
+		if manifest == nil {
+			export.Missing = append(export.Missing, b.ID)
+			games = append(games, g)
+			continue
+		}
+		for _, f := range manifest.Files {
+			// Lowercased builds are served case-insensitively; a static host
+			// can't, so files go back under the paths the game asks for
+			name := f.Path
+			if f.OriginalPath != "" {
+				name = f.OriginalPath
+			}
+			key := path.Join(export.Prefix, "games", b.ID, name)
+			if err := sync.ExportGameFile(ctx, *srv, b.ID, f.Path, f.SHA256, key); err != nil {
+				return export, err
+			}
+			export.Files++
+			export.Bytes += f.Size
+			if g.URL == "" && (name == "index.html" || g.Downloadable) {
+				g.URL = "games/" + b.ID + "/" + name
+			}
+		}
+		games = append(games, g)
+	}
+	export.Games = len(games)
+
+	data, err := json.MarshalIndent(games, "", "  ")
+	if err != nil {
+		return export, err
+	}
+	if err := sync.PutExportObject(ctx, *srv, path.Join(export.Prefix, "games.json"), "application/json", data); err != nil {
+		return export, err
+	}
+	var page bytes.Buffer
+	err = siteExportPage.Execute(&page, struct {
+		Event      string
+		ExportedAt time.Time
+		Games      []exportedGame
+	}{export.Event, export.StartedAt, games})
+	if err != nil {
+		return export, err
+	}
+	if err := sync.PutExportObject(ctx, *srv, path.Join(export.Prefix, "index.html"), "text/html; charset=utf-8", page.Bytes()); err != nil {
+		return export, err
+	}
+	return export, nil
+}
+
+// siteExportPage is self-contained, like judgingIndexPage, so the site only
+// needs a bucket to serve it. Links are relative to work under any prefix.
+var siteExportPage = template.Must(template.New("export").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Event}} games</title>
+<style>
+body { font-family: sans-serif; max-width: 60rem; margin: 2rem auto; padding: 0 1rem; line-height: 1.4; }
+ul { list-style: none; padding: 0; display: grid; grid-template-columns: repeat(auto-fill, minmax(16rem, 1fr)); gap: 1rem; }
+li { border: 1px solid #ddd; border-radius: 0.5rem; padding: 1rem; }
+h2 { font-size: 1.1rem; margin: 0 0 0.25rem; }
+.by, .stats { color: #666; font-size: 0.9rem; }
+.tags span { background: #f4f4f4; border-radius: 0.25rem; padding: 0 0.4rem; margin-right: 0.25rem; font-size: 0.8rem; }
+</style>
+</head>
+<body>
+<h1>{{.Event}}</h1>
+<p>{{len .Games}} games, archived {{.ExportedAt.Format "2006-01-02"}}. Their metadata is in <a href="games.json">games.json</a>.</p>
+<ul>
+{{range .Games}}<li id="{{.ProjectID}}">
+<h2>{{.Title}}</h2>
+{{if .Creator}}<div class="by">by {{.Creator}}</div>{{end}}
+{{if .Description}}<p>{{.Description}}</p>{{end}}
+{{if .Tags}}<div class="tags">{{range .Tags}}<span>{{.}}</span>{{end}}</div>{{end}}
+<p class="stats">{{.Plays}} plays{{if .Engine}} · {{.Engine}}{{end}}</p>
+{{if .URL}}<a href="{{.URL}}">{{if .Downloadable}}Download{{else}}Play{{end}}</a>{{else}}Not archived{{end}}
+</li>
+{{end}}</ul>
+</body>
+</html>
+`))
@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"shiba-api/datastore"
+	"shiba-api/gamemeta"
+	"shiba-api/ogcard"
+	"shiba-api/structs"
+	"shiba-api/sync"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// socialCardVersion is part of every card's cache key; bump it when the
+// layout changes so cached cards are rendered again.
+const socialCardVersion = "1"
+
+// Images in a build's root used as its screenshot, best first. Godot's web
+// export ships its boot splash as index.png.
+var screenshotNames = []string{
+	"screenshot.png", "screenshot.jpg", "screenshot.jpeg",
+	"cover.png", "cover.jpg", "cover.jpeg",
+	"thumbnail.png", "thumbnail.jpg", "thumbnail.jpeg",
+	"index.png", "splash.png", "splash.jpg",
+}
+
+const (
+	maxScreenshotBytes = 8 << 20
+	maxScreenshotSide  = 4096
+)
+
+// findScreenshot returns the path of a build's screenshot: the cover
+// uploaded with it, else an image matching names case-insensitively, or ""
+// if it has none.
+func findScreenshot(dir string) (string, os.FileInfo) {
+	if path := uploadedThumbnail(dir); path != "" {
+		if info, err := os.Stat(path); err == nil {
+			return path, info
+		}
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", nil
+	}
+	files := map[string]string{}
+	for _, e := range entries {
+		if !e.IsDir() {
+			files[strings.ToLower(e.Name())] = e.Name()
+		}
+	}
+	for _, name := range screenshotNames {
+		actual, ok := files[name]
+		if !ok {
+			continue
+		}
+		path := filepath.Join(dir, actual)
+		info, err := os.Stat(path)
+		if err != nil || info.Size() > maxScreenshotBytes {
+			continue
+		}
+		return path, info
+	}
+	return "", nil
+}
+
+// decodeScreenshot reads a screenshot, refusing images too large to scale
+// down in a request.
+func decodeScreenshot(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	config, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return nil, err
+	}
+	if config.Width > maxScreenshotSide || config.Height > maxScreenshotSide {
+		return nil, fmt.Errorf("%dx%d is larger than %dx%d", config.Width, config.Height, maxScreenshotSide, maxScreenshotSide)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(f)
+	return img, err
+}
+
+// socialCardText returns the title and creator shown on a build's card: its
+// game's title and owner, or the build ID for builds without either.
+func socialCardText(ctx context.Context, srv *structs.Server, build Build) (title, creator string) {
+	title = build.ID
+	ownerID := build.OwnerID
+	if build.ProjectID != "" {
+		record, err := srv.GameStore.GameByID(ctx, build.ProjectID)
+		switch {
+		case err == nil:
+			if name, ok := record.Fields["Name"].(string); ok && name != "" {
+				title = name
+			}
+			if owners, ok := record.Fields["Owner"].([]any); ok && len(owners) > 0 && ownerID == "" {
+				ownerID, _ = owners[0].(string)
+			}
+		case !errors.Is(err, datastore.ErrNotFound):
+			slog.ErrorContext(ctx, "Failed to look up project", "project_id", build.ProjectID, "error", err)
+		}
+	}
+	if meta, err := gamemeta.Get(srv.Store, build.ProjectID); err == nil && meta.Title != "" {
+		title = meta.Title
+	}
+	if ownerID != "" {
+		if user, err := srv.UserStore.UserByID(ctx, ownerID); err == nil {
+			creator, _ = user.Fields["Name"].(string)
+		}
+	}
+	return title, creator
+}
+
+// SocialCardHandler serves /og/{gameId}.png, the Open Graph image of a
+// build: its title and creator next to a screenshot. Cards are rendered on
+// first request and cached in R2 under a key covering everything they show,
+// so renaming a game or uploading a new screenshot renders a new card.
+func SocialCardHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		gameID := chi.URLParam(r, "gameId")
+		builds, err := loadBuilds(srv)
+		if err != nil {
+			http.Error(w, "Failed to load builds: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// Drafts stay unlisted; their card would show what isn't released
+		build, ok := publishedBuild(&builds, gameID)
+		if !ok {
+			http.Error(w, "Game not found", http.StatusNotFound)
+			return
+		}
+		dir := "./games/" + gameID
+
+		title, creator := socialCardText(r.Context(), srv, build)
+		shotPath, shotInfo := findScreenshot(dir)
+		sum := sha256.New()
+		fmt.Fprintf(sum, "%s\x00%s\x00%s\x00", socialCardVersion, title, creator)
+		if shotInfo != nil {
+			fmt.Fprintf(sum, "%s\x00%d\x00%d", shotInfo.Name(), shotInfo.Size(), shotInfo.ModTime().Unix())
+		}
+		hash := hex.EncodeToString(sum.Sum(nil))[:16]
+		key := "og/" + gameID + "/" + hash + ".png"
+
+		data, ok, err := sync.CachedObject(r.Context(), *srv, key)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to read cached social card", "key", key, "error", err)
+		}
+		if !ok {
+			card := ogcard.Card{Title: title, Creator: creator}
+			if shotPath != "" {
+				if card.Screenshot, err = decodeScreenshot(shotPath); err != nil {
+					slog.WarnContext(r.Context(), "Skipping screenshot", "path", shotPath, "game_id", gameID, "error", err)
+				}
+			}
+			var buf bytes.Buffer
+			if err := ogcard.Encode(&buf, card); err != nil {
+				http.Error(w, "Failed to render card: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			data = buf.Bytes()
+			if err := sync.CacheObject(r.Context(), *srv, key, "image/png", data); err != nil {
+				slog.ErrorContext(r.Context(), "Failed to cache social card", "key", key, "error", err)
+			}
+		}
+
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		w.Header().Set("ETag", `"`+hash+`"`)
+		http.ServeContent(w, r, gameID+".png", time.Time{}, bytes.NewReader(data))
+	}
+}
+
+const socialMetaTags = `<meta property="og:image" content="%[1]s"><meta property="og:image:width" content="%[2]d"><meta property="og:image:height" content="%[3]d"><meta name="twitter:card" content="summary_large_image"><meta name="twitter:image" content="%[1]s">`
+
+// socialMeta returns the tags pointing unfurlers at a build's social card.
+func socialMeta(r *http.Request, gameID string) string {
+	url := publicBaseURL(r) + "/og/" + gameID + ".png"
+	return fmt.Sprintf(socialMetaTags, html.EscapeString(url), ogcard.Width, ogcard.Height)
+}
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"shiba-api/schema"
+	"shiba-api/stepup"
+	"shiba-api/structs"
+
+	"github.com/mehanizm/airtable"
+)
+
+// stepUpHeader carries the grant of a verified step-up
+const stepUpHeader = "X-Step-Up"
+
+// stepUpAddress is where user receives codes on channel, from their Users
+// record.
+func stepUpAddress(user *airtable.Record, channel string) string {
+	field := map[string]string{
+		stepup.ChannelEmail: "Email",
+		stepup.ChannelSlack: "slack id",
+	}[channel]
+	s, _ := user.Fields[field].(string)
+	return strings.TrimSpace(s)
+}
+
+func writeStepUpRequired(w http.ResponseWriter, srv *structs.Server, msg string) {
+	writeJSON(w, http.StatusForbidden, struct {
+		Code     string   `json:"code"`
+		Message  string   `json:"message"`
+		Channels []string `json:"channels"`
+	}{"step_up_required", msg, srv.StepUp.Channels()})
+}
+
+// RequireStepUp guards destructive routes: user tokens must also send a
+// grant from POST /me/step-up/verify in X-Step-Up. The admin token is exempt,
+// and so is everyone while no code channel is configured. Unauthenticated
+// requests are passed on for the handler to reject.
+func RequireStepUp(srv *structs.Server) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !srv.StepUp.Enabled() || isAdmin(srv, r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			user, err := authenticateUser(srv, r)
+			if errors.Is(err, errUnauthorized) || errors.Is(err, errInsufficientScope) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if err != nil {
+				http.Error(w, "Failed to authenticate: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if !srv.StepUp.Check(r.Header.Get(stepUpHeader), user.ID) {
+				writeStepUpRequired(w, srv, "This action needs a confirmation code, see POST /me/step-up")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// StartStepUpHandler sends the caller a confirmation code, by default over
+// the first configured channel they have an address for.
+func StartStepUpHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := requireUser(srv, w, r)
+		if !ok {
+			return
+		}
+		if !srv.StepUp.Enabled() {
+			http.Error(w, "Step-up verification is not configured", http.StatusServiceUnavailable)
+			return
+		}
+
+		var req struct {
+			Channel string `json:"channel" validate:"oneof=email slack"`
+		}
+		// The body is optional
+		if r.ContentLength != 0 && !bindJSON(w, r, &req) {
+			return
+		}
+
+		channel, address := req.Channel, ""
+		if channel != "" {
+			address = stepUpAddress(user, channel)
+		} else {
+			for _, c := range srv.StepUp.Channels() {
+				if address = stepUpAddress(user, c); address != "" {
+					channel = c
+					break
+				}
+			}
+		}
+		if address == "" {
+			invalidField(w, "channel", schema.InBody, "no address on file for %s", strings.Join(srv.StepUp.Channels(), " or "))
+			return
+		}
+
+		challenge, err := srv.StepUp.Start(r.Context(), user.ID, channel, address)
+		switch {
+		case errors.Is(err, stepup.ErrUnknownChannel):
+			invalidField(w, "channel", schema.InBody, "must be one of %s", strings.Join(srv.StepUp.Channels(), ", "))
+			return
+		case errors.Is(err, stepup.ErrTooSoon):
+			w.Header().Set("Retry-After", "30")
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		case err != nil:
+			http.Error(w, "Failed to send code: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, http.StatusOK, challenge)
+	}
+}
+
+// VerifyStepUpHandler exchanges a confirmation code for a step-up grant.
+func VerifyStepUpHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := requireUser(srv, w, r)
+		if !ok {
+			return
+		}
+
+		var req struct {
+			ChallengeID string `json:"challengeId" validate:"required"`
+			Code        string `json:"code" validate:"required,max=6"`
+		}
+		if !bindJSON(w, r, &req) {
+			return
+		}
+
+		grant, expiresAt, err := srv.StepUp.Verify(req.ChallengeID, user.ID, strings.TrimSpace(req.Code))
+		if err != nil {
+			invalidField(w, "code", schema.InBody, "%v", err)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, http.StatusOK, struct {
+			StepUpToken string    `json:"stepUpToken"`
+			ExpiresAt   time.Time `json:"expiresAt"`
+		}{grant, expiresAt})
+	}
+}
@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"shiba-api/quota"
+	"shiba-api/schema"
+	"shiba-api/structs"
+)
+
+const storageUsageDoc = "storage-usage"
+
+// storageLimit is the configured per-user storage cap in bytes, 0 for none.
+func storageLimit(srv *structs.Server) int64 {
+	return int64(srv.Config.Get().StorageQuotaMB) << 20
+}
+
+// buildSize sums the files of an extracted build.
+func buildSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
+
+// megabytes rounds n bytes up to whole megabytes, so what the uploader is
+// told to free is always enough.
+func megabytes(n int64) int64 {
+	return (n + 1<<20 - 1) >> 20
+}
+
+// reserveStorage charges an extracted build against its owner's storage
+// cap. Builds that would take them over it are removed and rejected with how
+// much space to free. Anonymous builds aren't counted.
+func reserveStorage(srv *structs.Server, ownerID, gameID, destDir string) (*quota.StorageUsage, error) {
+	if ownerID == "" {
+		return nil, nil
+	}
+	size, err := buildSize(destDir)
+	if err != nil {
+		os.RemoveAll(destDir)
+		return nil, newUploadError(http.StatusInternalServerError, "Failed to measure build: "+err.Error())
+	}
+
+	limit := storageLimit(srv)
+	var ledger quota.Ledger
+	var usage quota.StorageUsage
+	fits := false
+	err = srv.Store.Update(storageUsageDoc, &ledger, func() error {
+		usage, fits = ledger.Reserve(ownerID, gameID, size, limit)
+		return nil
+	})
+	if err != nil {
+		os.RemoveAll(destDir)
+		return nil, newUploadError(http.StatusInternalServerError, "Failed to record storage usage: "+err.Error())
+	}
+	if !fits {
+		os.RemoveAll(destDir)
+		msg := fmt.Sprintf("This build takes %d MB but only %d MB of your %d MB are left. Delete old builds to free up at least %d MB, see GET /me/usage",
+			megabytes(size), usage.Remaining>>20, limit>>20, megabytes(usage.Used+size-limit))
+		return nil, &uploadError{
+			status: http.StatusUnprocessableEntity,
+			msg:    msg,
+			code:   "storage_quota_exceeded",
+			fields: schema.Errors{{Field: "file", In: schema.InForm, Message: msg}},
+		}
+	}
+	return &usage, nil
+}
+
+// addStorage records what a build takes up against its owner's cap without
+// checking it, for builds an organizer let through.
+func addStorage(srv *structs.Server, ownerID, gameID, dir string) error {
+	size, err := buildSize(dir)
+	if err != nil {
+		return err
+	}
+	var ledger quota.Ledger
+	return srv.Store.Update(storageUsageDoc, &ledger, func() error {
+		ledger.Add(ownerID, gameID, size)
+		return nil
+	})
+}
+
+// releaseStorage gives a deleted build's bytes back to its owner.
+func releaseStorage(srv *structs.Server, gameID string) error {
+	var ledger quota.Ledger
+	return srv.Store.Update(storageUsageDoc, &ledger, func() error {
+		ledger.Release(gameID)
+		return nil
+	})
+}
+
+// userStorageUsage returns what userID's builds take up against the cap.
+func userStorageUsage(srv *structs.Server, userID string) (quota.StorageUsage, error) {
+	var ledger quota.Ledger
+	if err := srv.Store.Load(storageUsageDoc, &ledger); err != nil {
+		return quota.StorageUsage{}, err
+	}
+	return ledger.Usage(userID, storageLimit(srv)), nil
+}
+
+// BackfillStorageUsage records the builds uploaded before storage was
+// tracked, at their manifest's size, so they count against the cap too.
+func BackfillStorageUsage(srv *structs.Server) error {
+	builds, err := loadBuilds(srv)
+	if err != nil {
+		return err
+	}
+	var ledger quota.Ledger
+	if err := srv.Store.Load(storageUsageDoc, &ledger); err != nil {
+		return err
+	}
+	sizes := map[string]int64{}
+	for id, b := range builds.Builds {
+		if b.OwnerID == "" || ledger.Has(id) {
+			continue
+		}
+		manifest, err := loadBuildManifest(srv, id)
+		if err != nil {
+			return err
+		}
+		if manifest == nil {
+			continue
+		}
+		for _, f := range manifest.Files {
+			sizes[id] += f.Size
+		}
+	}
+	if len(sizes) == 0 {
+		return nil
+	}
+	err = srv.Store.Update(storageUsageDoc, &ledger, func() error {
+		for id, size := range sizes {
+			if !ledger.Has(id) {
+				ledger.Add(builds.Builds[id].OwnerID, id, size)
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		slog.Info("Backfilled storage usage", "builds", len(sizes))
+	}
+	return err
+}
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"shiba-api/schema"
+	"shiba-api/stats"
+	"shiba-api/structs"
+	"shiba-api/webhooks"
+)
+
+const activityDoc = "activity"
+
+const (
+	ActivityUpload   = "upload"
+	ActivityFeedback = "feedback"
+	ActivityPlaytime = "playtime"
+)
+
+const dayLayout = "2006-01-02"
+
+type activityState struct {
+	// Days maps user ID -> UTC day (YYYY-MM-DD) -> activity kind -> count
+	Days map[string]map[string]map[string]int `json:"days"`
+}
+
+func recordActivity(srv *structs.Server, userID, kind string, at time.Time) error {
+	var state activityState
+	return srv.Store.Update(activityDoc, &state, func() error {
+		if state.Days == nil {
+			state.Days = map[string]map[string]map[string]int{}
+		}
+		day := at.UTC().Format(dayLayout)
+		if state.Days[userID] == nil {
+			state.Days[userID] = map[string]map[string]int{}
+		}
+		if state.Days[userID][day] == nil {
+			state.Days[userID][day] = map[string]int{}
+		}
+		state.Days[userID][day][kind]++
+		return nil
+	})
+}
+
+type Streak struct {
+	Current     int      `json:"current"`
+	Longest     int      `json:"longest"`
+	ActiveToday bool     `json:"activeToday"`
+	LastActive  string   `json:"lastActive,omitempty"`
+	RecentDays  []string `json:"recentDays"`
+	TotalActive int      `json:"totalActiveDays"`
+}
+
+// computeStreak counts consecutive active days. The current streak stays alive
+// until the end of the day after the last activity, so users who haven't done
+// anything yet today don't see it drop to zero.
+func computeStreak(days map[string]map[string]int, now time.Time) Streak {
+	active := make([]string, 0, len(days))
+	for day := range days {
+		active = append(active, day)
+	}
+	sort.Strings(active)
+
+	streak := Streak{RecentDays: []string{}, TotalActive: len(active)}
+	if len(active) == 0 {
+		return streak
+	}
+
+	run := 0
+	var prev time.Time
+	for _, day := range active {
+		d, err := time.Parse(dayLayout, day)
+		if err != nil {
+			continue
+		}
+		if run > 0 && d.Sub(prev) == 24*time.Hour {
+			run++
+		} else {
+			run = 1
+		}
+		if run > streak.Longest {
+			streak.Longest = run
+		}
+		prev = d
+	}
+
+	today := now.UTC().Format(dayLayout)
+	yesterday := now.UTC().AddDate(0, 0, -1).Format(dayLayout)
+	last := active[len(active)-1]
+	streak.LastActive = last
+	streak.ActiveToday = last == today
+	if last == today || last == yesterday {
+		streak.Current = run
+	}
+
+	start := len(active) - 14
+	if start < 0 {
+		start = 0
+	}
+	streak.RecentDays = active[start:]
+	return streak
+}
+
+// MyStreakHandler returns the calling user's activity streak.
+func MyStreakHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := requireUser(srv, w, r)
+		if !ok {
+			return
+		}
+
+		var state activityState
+		if err := srv.Store.Load(activityDoc, &state); err != nil {
+			http.Error(w, "Failed to load activity: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, http.StatusOK, computeStreak(state.Days[user.ID], time.Now()))
+	}
+}
+
+// RecordActivityHandler lets trusted services (the site backend) report
+// feedback and playtime activity for a user. When a gameId is given the
+// activity also counts towards that game's stats. Requires the admin token.
+func RecordActivityHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(srv, r) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req struct {
+			UserID  string     `json:"userId" validate:"required"`
+			Kind    string     `json:"kind" validate:"required,oneof=upload feedback playtime"`
+			At      *time.Time `json:"at"`
+			GameID  string     `json:"gameId"`
+			Seconds int64      `json:"seconds" validate:"min=0"`
+			Play    bool       `json:"play"`
+		}
+		if !bindJSON(w, r, &req) {
+			return
+		}
+		if req.Kind == ActivityPlaytime && srv.Config.Get().PlaySessionsOnly {
+			invalidField(w, "kind", schema.InBody, "playtime is only credited from play sessions")
+			return
+		}
+
+		at := time.Now()
+		if req.At != nil {
+			at = *req.At
+		}
+		if err := recordActivity(srv, req.UserID, req.Kind, at); err != nil {
+			http.Error(w, "Failed to record activity: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if req.GameID != "" {
+			var c stats.Counts
+			switch req.Kind {
+			case ActivityFeedback:
+				c.Feedback = 1
+			case ActivityPlaytime:
+				c.PlaytimeSeconds = req.Seconds
+				if req.Play {
+					c.Plays = 1
+				}
+			}
+			srv.Stats.Add(req.GameID, at, c)
+			if err := recordPlaytest(srv, req.UserID, req.GameID, c.PlaytimeSeconds); err != nil {
+				http.Error(w, "Failed to record playtest: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if req.Kind == ActivityFeedback {
+				text := func(name string) string { return "New feedback on " + name + "!" }
+				notifyCreators(r.Context(), srv, req.GameID, webhooks.EventFeedback, text, struct {
+					At time.Time `json:"at"`
+				}{at.UTC()})
+			}
+		}
+
+		writeJSON(w, http.StatusOK, struct {
+			Ok bool `json:"ok"`
+		}{true})
+	}
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"shiba-api/schema"
+	"shiba-api/structs"
+	"shiba-api/syncqueue"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// queueSync records the R2 sync of a published build so it survives a
+// restart, and returns a channel receiving the outcome of its first attempt.
+// ctx must outlive the request. If the queue can't be written to, the build
+// is synced right away, as before there was a queue.
+func queueSync(ctx context.Context, srv *structs.Server, gameID, dir string) <-chan error {
+	done, err := srv.SyncQueue.Enqueue(ctx, gameID, dir)
+	if err == nil {
+		return done
+	}
+	slog.ErrorContext(ctx, "Failed to queue sync, syncing without the queue", "game_id", gameID, "error", err)
+	direct := make(chan error, 1)
+	go func() { direct <- SyncBuild(ctx, srv, gameID, dir) }()
+	return direct
+}
+
+// SyncJobsHandler lists the R2 syncs of builds, to find the ones that didn't
+// make it to the CDN. Requires the admin token.
+func SyncJobsHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(srv, r) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		status := r.URL.Query().Get("status")
+		switch status {
+		case "", syncqueue.StatusPending, syncqueue.StatusRunning, syncqueue.StatusSucceeded, syncqueue.StatusFailed:
+		default:
+			invalidField(w, "status", schema.InQuery, "must be pending, running, succeeded or failed")
+			return
+		}
+
+		jobs, err := srv.SyncQueue.Jobs(status)
+		if err != nil {
+			http.Error(w, "Failed to load sync jobs: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, struct {
+			Jobs []syncqueue.Job `json:"jobs"`
+		}{jobs})
+	}
+}
+
+// RetrySyncJobHandler queues a build's sync again, e.g. once R2 credentials
+// are fixed after it failed for good. Requires the admin token.
+func RetrySyncJobHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(srv, r) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		job, err := srv.SyncQueue.Retry(chi.URLParam(r, "gameId"))
+		if errors.Is(err, syncqueue.ErrNotFound) {
+			http.Error(w, "No sync job for this game", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to retry sync: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, job)
+	}
+}
@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"shiba-api/membudget"
+)
+
+// thumbnailDir is where a build's uploaded cover image and its resized
+// variants are kept, relative to the build.
+const thumbnailDir = "shiba-thumbnail"
+
+const maxThumbnailBytes = 5 << 20
+
+// Widths the gallery shows covers at. Variants are only made smaller than
+// the original.
+var thumbnailWidths = []int{320, 640, 1280}
+
+// thumbnail is a cover image sent with an upload, checked before the build
+// is extracted.
+type thumbnail struct {
+	data   []byte
+	format string
+	// img is nil for WebP, which the standard library can't decode
+	img image.Image
+}
+
+// Thumbnails are the URLs of a build's cover image: the original as
+// uploaded and a variant for each width up to the original's.
+type Thumbnails struct {
+	Original string         `json:"original"`
+	Sizes    map[int]string `json:"sizes,omitempty"`
+}
+
+// decodeThumbnail checks that an uploaded cover is a PNG, JPEG or WebP image
+// small enough to resize.
+func decodeThumbnail(ctx context.Context, data []byte) (*thumbnail, error) {
+	invalid := func(msg string) error {
+		return &uploadError{status: http.StatusUnprocessableEntity, msg: msg, code: "invalid_thumbnail"}
+	}
+	if len(data) > maxThumbnailBytes {
+		return nil, newUploadError(http.StatusRequestEntityTooLarge, fmt.Sprintf("Thumbnails are limited to %d MB", maxThumbnailBytes>>20))
+	}
+
+	var width, height int
+	var format string
+	if w, h, ok := webpSize(data); ok {
+		width, height, format = w, h, "webp"
+	} else {
+		config, f, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil || (f != "png" && f != "jpeg") {
+			return nil, invalid("The thumbnail must be a PNG, JPEG or WebP image")
+		}
+		width, height, format = config.Width, config.Height, f
+	}
+	if width == 0 || height == 0 {
+		return nil, invalid("The thumbnail is empty")
+	}
+	if width > maxScreenshotSide || height > maxScreenshotSide {
+		return nil, invalid(fmt.Sprintf("The thumbnail is %dx%d, larger than %dx%d", width, height, maxScreenshotSide, maxScreenshotSide))
+	}
+
+	thumb := &thumbnail{data: data, format: format}
+	if format != "webp" {
+		// Decoded, it stays in memory until the build is saved
+		membudget.Hold(ctx, int64(width)*int64(height)*4)
+		img, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, invalid("Failed to read the thumbnail: " + err.Error())
+		}
+		thumb.img = img
+	}
+	return thumb, nil
+}
+
+// webpSize reads the dimensions of a WebP image from its header.
+func webpSize(data []byte) (width, height int, ok bool) {
+	if len(data) < 30 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return 0, 0, false
+	}
+	chunk := data[20:]
+	switch string(data[12:16]) {
+	case "VP8 ":
+		// Frame tag, start code, then 14 bit dimensions
+		if chunk[3] != 0x9d || chunk[4] != 0x01 || chunk[5] != 0x2a {
+			return 0, 0, false
+		}
+		return int(binary.LittleEndian.Uint16(chunk[6:]) & 0x3fff), int(binary.LittleEndian.Uint16(chunk[8:]) & 0x3fff), true
+	case "VP8L":
+		if chunk[0] != 0x2f {
+			return 0, 0, false
+		}
+		bits := binary.LittleEndian.Uint32(chunk[1:])
+		return int(bits&0x3fff) + 1, int(bits>>14&0x3fff) + 1, true
+	case "VP8X":
+		return int(uint32(chunk[4])|uint32(chunk[5])<<8|uint32(chunk[6])<<16) + 1,
+			int(uint32(chunk[7])|uint32(chunk[8])<<8|uint32(chunk[9])<<16) + 1, true
+	}
+	return 0, 0, false
+}
+
+func thumbnailExt(format string) string {
+	if format == "jpeg" {
+		return ".jpg"
+	}
+	return "." + format
+}
+
+// saveThumbnail writes a cover image and its resized variants into a build
+// and returns their URLs. Variants keep the original's format; WebP covers
+// are kept as uploaded, without variants.
+func saveThumbnail(destDir, gameID string, thumb *thumbnail) (*Thumbnails, error) {
+	dir := filepath.Join(destDir, thumbnailDir)
+	// A folder of the build by that name would mix with the cover
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	base := "/play/" + gameID + "/" + thumbnailDir + "/"
+	ext := thumbnailExt(thumb.format)
+	if err := os.WriteFile(filepath.Join(dir, "original"+ext), thumb.data, 0644); err != nil {
+		return nil, err
+	}
+	urls := &Thumbnails{Original: base + "original" + ext}
+	if thumb.img == nil {
+		return urls, nil
+	}
+
+	b := thumb.img.Bounds()
+	for _, width := range thumbnailWidths {
+		if width >= b.Dx() {
+			break
+		}
+		height := max(1, b.Dy()*width/b.Dx())
+		var buf bytes.Buffer
+		var err error
+		if thumb.format == "jpeg" {
+			err = jpeg.Encode(&buf, scaleDown(thumb.img, width, height), &jpeg.Options{Quality: 85})
+		} else {
+			err = png.Encode(&buf, scaleDown(thumb.img, width, height))
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := strconv.Itoa(width) + ext
+		if err := os.WriteFile(filepath.Join(dir, name), buf.Bytes(), 0644); err != nil {
+			return nil, err
+		}
+		if urls.Sizes == nil {
+			urls.Sizes = map[int]string{}
+		}
+		urls.Sizes[width] = base + name
+	}
+	return urls, nil
+}
+
+// scaleDown shrinks src to width by height, averaging the pixels each
+// destination pixel covers so photos don't alias.
+func scaleDown(src image.Image, width, height int) *image.RGBA {
+	b := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := b.Min.Y + y*b.Dy()/height
+		y1 := max(y0+1, b.Min.Y+(y+1)*b.Dy()/height)
+		for x := 0; x < width; x++ {
+			x0 := b.Min.X + x*b.Dx()/width
+			x1 := max(x0+1, b.Min.X+(x+1)*b.Dx()/width)
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					c := color.RGBA64Model.Convert(src.At(sx, sy)).(color.RGBA64)
+					r, g, bl, a = r+uint64(c.R), g+uint64(c.G), bl+uint64(c.B), a+uint64(c.A)
+					n++
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{uint8(r / n >> 8), uint8(g / n >> 8), uint8(bl / n >> 8), uint8(a / n >> 8)})
+		}
+	}
+	return dst
+}
+
+// uploadedThumbnail returns the largest decodable image of a build's
+// uploaded cover, or "" if it has none.
+func uploadedThumbnail(dir string) string {
+	for i := len(thumbnailWidths) - 1; i >= 0; i-- {
+		for _, ext := range []string{".png", ".jpg"} {
+			path := filepath.Join(dir, thumbnailDir, strconv.Itoa(thumbnailWidths[i])+ext)
+			if _, err := os.Stat(path); err == nil {
+				return path
+			}
+		}
+	}
+	for _, ext := range []string{".png", ".jpg"} {
+		path := filepath.Join(dir, thumbnailDir, "original"+ext)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"shiba-api/structs"
+	"shiba-api/users"
+)
+
+// rotateMu serializes rotations, so two requests racing with the same token
+// can't both succeed and leave one caller holding a token that was already
+// replaced.
+var rotateMu sync.Mutex
+
+func newUserToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// revokeUserTokens deletes every session and creator token of userID, which
+// may have been minted with a leaked account token. It returns how many of
+// each it revoked.
+func revokeUserTokens(srv *structs.Server, userID string) (sessions, creatorTokens int, err error) {
+	var revoked []string
+	var state sessionsState
+	err = srv.Store.Update(sessionsDoc, &state, func() error {
+		for hash, s := range state.Sessions {
+			if s.UserID == userID {
+				delete(state.Sessions, hash)
+				revoked = append(revoked, s.TokenKey)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	now := time.Now()
+	for _, key := range revoked {
+		if key != "" {
+			srv.Tokens.Forget(key, now)
+		}
+	}
+
+	var tokens creatorTokensState
+	err = srv.Store.Update(creatorTokensDoc, &tokens, func() error {
+		for hash, ct := range tokens.Tokens {
+			if ct.UserID == userID {
+				delete(tokens.Tokens, hash)
+				creatorTokens++
+			}
+		}
+		return nil
+	})
+	return len(revoked), creatorTokens, err
+}
+
+// RotateTokenHandler replaces the caller's token with a new one. The old
+// token stops working as soon as the response is sent, and so do the
+// sessions and creator tokens minted with it. Quotas count per user, so the
+// allowance used so far carries over.
+func RotateTokenHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(bearerToken(r), sessionTokenPrefix) {
+			http.Error(w, "Rotate with the account token, or revoke this session instead", http.StatusForbidden)
+			return
+		}
+
+		rotateMu.Lock()
+		defer rotateMu.Unlock()
+
+		// Authenticate under the lock so a token rotated by a concurrent
+		// request is already rejected
+		user, ok := requireUser(srv, w, r)
+		if !ok {
+			return
+		}
+
+		token, err := newUserToken()
+		if err != nil {
+			http.Error(w, "Failed to generate token: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if err := srv.UserStore.SetUserToken(r.Context(), user.ID, token); err != nil {
+			http.Error(w, "Failed to rotate token: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		srv.Tokens.Forget(users.HashToken(bearerToken(r)), time.Now())
+
+		sessions, creatorTokens, err := revokeUserTokens(srv, user.ID)
+		if err != nil {
+			http.Error(w, "Token rotated, but failed to revoke its sessions: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, http.StatusOK, struct {
+			Token                string    `json:"token"`
+			RotatedAt            time.Time `json:"rotatedAt"`
+			RevokedSessions      int       `json:"revokedSessions"`
+			RevokedCreatorTokens int       `json:"revokedCreatorTokens"`
+		}{token, time.Now().UTC(), sessions, creatorTokens})
+	}
+}
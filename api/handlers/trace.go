@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"shiba-api/trace"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// Trace runs a request in a span, continuing the client's trace when it sent
+// a valid traceparent. The response's traceparent names the request's span,
+// so clients can show or report its trace ID.
+func Trace(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if sc, ok := trace.Parse(r.Header.Get(trace.Header)); ok {
+			ctx = trace.WithRemote(ctx, sc)
+		}
+		ctx, span := trace.Start(ctx, r.Method+" "+r.URL.Path)
+		w.Header().Set(trace.Header, span.Context().String())
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		span.Set("status", strconv.Itoa(status))
+		var err error
+		if status >= 500 {
+			err = errors.New(http.StatusText(status))
+		}
+		span.End(err)
+	})
+}
+
+// traceStep runs one step of a traced pipeline in its own span.
+func traceStep(ctx context.Context, name string, step func(ctx context.Context) error) error {
+	ctx, span := trace.Start(ctx, name)
+	err := step(ctx)
+	span.End(err)
+	return err
+}
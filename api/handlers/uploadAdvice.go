@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"shiba-api/structs"
+)
+
+const (
+	maxProbeSize = 2 << 20
+	minProbeSize = 64 << 10
+
+	minChunkSize = 256 << 10
+	maxChunkSize = 16 << 20
+	// Aim for chunks that take about this long, so a retry loses little work
+	chunkTargetDuration = 5 * time.Second
+	// Uploads expected to take longer than this should be resumable
+	resumableThreshold = 30 * time.Second
+)
+
+// UploadAdviceHandler times how fast the client sends a small probe body and
+// recommends a chunk size and whether to upload in resumable mode. Clients
+// send 256 KB-2 MB of any bytes, and optionally the size of the build they
+// plan to upload in ?size=.
+func UploadAdviceHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var query struct {
+			Size int64 `query:"size" validate:"min=0"`
+		}
+		if !bindQuery(w, r, &query) {
+			return
+		}
+		planned := query.Size
+
+		body := http.MaxBytesReader(w, r.Body, maxProbeSize)
+		defer body.Close()
+
+		// Start the clock at the first byte so connection setup isn't counted
+		buf := make([]byte, 32<<10)
+		n, err := body.Read(buf)
+		start := time.Now()
+		received := int64(n)
+		if err == nil {
+			var rest int64
+			rest, err = io.CopyBuffer(io.Discard, body, buf)
+			received += rest
+		}
+		elapsed := time.Since(start)
+		if err != nil && err != io.EOF {
+			http.Error(w, "Failed to read probe: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if received < minProbeSize {
+			http.Error(w, "Probe must be at least 64 KB", http.StatusBadRequest)
+			return
+		}
+		if elapsed < time.Millisecond {
+			elapsed = time.Millisecond
+		}
+
+		bytesPerSec := int64(float64(received) / elapsed.Seconds())
+
+		chunk := int64(float64(bytesPerSec) * chunkTargetDuration.Seconds())
+		if chunk < minChunkSize {
+			chunk = minChunkSize
+		}
+		if chunk > maxChunkSize {
+			chunk = maxChunkSize
+		}
+		// Round down to a whole number of 64 KB blocks
+		chunk -= chunk % (64 << 10)
+
+		resp := struct {
+			BytesPerSecond   int64   `json:"bytesPerSecond"`
+			ChunkSize        int64   `json:"chunkSize"`
+			Resumable        bool    `json:"resumable"`
+			EstimatedSeconds float64 `json:"estimatedSeconds,omitempty"`
+		}{
+			BytesPerSecond: bytesPerSec,
+			ChunkSize:      chunk,
+		}
+		if planned > 0 && bytesPerSec > 0 {
+			estimate := time.Duration(float64(planned) / float64(bytesPerSec) * float64(time.Second))
+			resp.EstimatedSeconds = estimate.Seconds()
+			resp.Resumable = estimate > resumableThreshold
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
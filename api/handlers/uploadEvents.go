@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"shiba-api/events"
+	"shiba-api/logging"
+	"shiba-api/membudget"
+	"shiba-api/structs"
+	"shiba-api/sync"
+	"shiba-api/trace"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// emitEvent appends an upload lifecycle event. Failing to log never fails the
+// upload itself.
+func emitEvent(ctx context.Context, srv *structs.Server, gameID, eventType, actor, detail string) {
+	appendEvent(ctx, srv, events.Event{GameID: gameID, Type: eventType, Actor: actor, Detail: detail})
+}
+
+// appendEvent stamps e with the request's IDs and appends it, like emitEvent.
+func appendEvent(ctx context.Context, srv *structs.Server, e events.Event) {
+	if srv.Events == nil {
+		return
+	}
+	e.RequestID, e.TraceID = logging.RequestID(ctx), trace.ID(ctx)
+	if _, err := srv.Events.Append(e); err != nil {
+		slog.ErrorContext(ctx, "Failed to log event", "game_id", e.GameID, "type", e.Type, "error", err)
+	}
+}
+
+// emitFailure logs a failed event with the user-facing message and code of
+// err.
+func emitFailure(ctx context.Context, srv *structs.Server, gameID, actor string, err error) {
+	e := events.Event{GameID: gameID, Type: events.Failed, Actor: actor, Detail: err.Error()}
+	var ue *uploadError
+	if errors.As(err, &ue) {
+		e.Detail, e.Code = ue.msg, ue.code
+	}
+	appendEvent(ctx, srv, e)
+}
+
+// SyncBuild uploads an extracted build to R2 and records the outcome, the
+// build being published once it is there. Builds are synced through
+// srv.SyncQueue, see queueSync.
+func SyncBuild(ctx context.Context, srv *structs.Server, gameID, dir string) error {
+	// Syncs of published builds run whatever the budget, but what they
+	// buffer delays new uploads
+	res := srv.Memory.Reserve(0)
+	defer res.Release()
+	ctx = membudget.WithReservation(ctx, res)
+	err := traceStep(ctx, "sync", func(ctx context.Context) error {
+		return sync.UploadFolder(ctx, dir, *srv, objectLabels(srv, gameID))
+	})
+	if err != nil {
+		r2Syncs.Inc("failure")
+		slog.ErrorContext(ctx, "Failed to sync build to R2", "game_id", gameID, "dir", dir, "error", err)
+		emitEvent(ctx, srv, gameID, events.Failed, "", "sync to R2 failed: "+err.Error())
+		return err
+	}
+	r2Syncs.Inc("success")
+	emitEvent(ctx, srv, gameID, events.Synced, "", "")
+	if state, err := loadBuilds(srv); err != nil {
+		slog.ErrorContext(ctx, "Failed to load builds", "game_id", gameID, "error", err)
+	} else if build, ok := state.Builds[gameID]; ok {
+		detail := "project " + build.ProjectID
+		if build.Draft {
+			detail = "draft of " + detail
+		}
+		emitEvent(ctx, srv, gameID, events.Published, build.OwnerID, detail)
+	}
+	return nil
+}
+
+// UploadEventsHandler returns the full event history of an upload, for
+// debugging reports of missing games. Requires the admin token.
+func UploadEventsHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(srv, r) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		gameID := chi.URLParam(r, "gameId")
+		history, err := srv.Events.ForGame(gameID)
+		if err != nil {
+			http.Error(w, "Failed to read event log: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, struct {
+			GameID string         `json:"gameId"`
+			Events []events.Event `json:"events"`
+		}{gameID, history})
+	}
+}
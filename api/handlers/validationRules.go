@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"net/http"
+
+	"shiba-api/schema"
+	"shiba-api/structs"
+	"shiba-api/validate"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const validationRulesDoc = "validation-rules"
+
+type validationRulesState struct {
+	// Events maps event ID -> rule name -> config
+	Events map[string]map[string]validate.RuleConfig `json:"events"`
+}
+
+// eventRules returns the validation rules configured for the current event.
+func eventRules(srv *structs.Server) ([]validate.RuleConfig, error) {
+	var state validationRulesState
+	if err := srv.Store.Load(validationRulesDoc, &state); err != nil {
+		return nil, err
+	}
+	var list []validate.RuleConfig
+	for _, c := range state.Events[srv.Config.Get().EventID] {
+		list = append(list, c)
+	}
+	return list, nil
+}
+
+// ValidationRulesHandler lists the configured validation rules of every event
+// and the rules available. Requires the admin token.
+func ValidationRulesHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(srv, r) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var state validationRulesState
+		if err := srv.Store.Load(validationRulesDoc, &state); err != nil {
+			http.Error(w, "Failed to load validation rules: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if state.Events == nil {
+			state.Events = map[string]map[string]validate.RuleConfig{}
+		}
+
+		writeJSON(w, http.StatusOK, struct {
+			CurrentEvent string                                    `json:"currentEvent"`
+			Rules        []string                                  `json:"rules"`
+			Events       map[string]map[string]validate.RuleConfig `json:"events"`
+		}{srv.Config.Get().EventID, validate.Rules(), state.Events})
+	}
+}
+
+// UpdateValidationRuleHandler creates or replaces a validation rule of an
+// event. Requires the admin token.
+func UpdateValidationRuleHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(srv, r) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		event := chi.URLParam(r, "event")
+		name := chi.URLParam(r, "name")
+
+		var req struct {
+			Rule    string            `json:"rule" validate:"required"`
+			Params  map[string]string `json:"params"`
+			Enabled bool              `json:"enabled"`
+			Message string            `json:"message" validate:"max=500"`
+		}
+		if !bindJSON(w, r, &req) {
+			return
+		}
+		config := validate.RuleConfig{
+			Name:    name,
+			Rule:    req.Rule,
+			Params:  req.Params,
+			Enabled: req.Enabled,
+			Message: req.Message,
+		}
+		if err := config.Validate(); err != nil {
+			invalidField(w, "params", schema.InBody, "%v", err)
+			return
+		}
+
+		var state validationRulesState
+		err := srv.Store.Update(validationRulesDoc, &state, func() error {
+			if state.Events == nil {
+				state.Events = map[string]map[string]validate.RuleConfig{}
+			}
+			if state.Events[event] == nil {
+				state.Events[event] = map[string]validate.RuleConfig{}
+			}
+			state.Events[event][name] = config
+			return nil
+		})
+		if err != nil {
+			http.Error(w, "Failed to save validation rule: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, config)
+	}
+}
+
+// DeleteValidationRuleHandler removes a validation rule of an event. Requires
+// the admin token.
+func DeleteValidationRuleHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(srv, r) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		event := chi.URLParam(r, "event")
+		name := chi.URLParam(r, "name")
+
+		var state validationRulesState
+		err := srv.Store.Update(validationRulesDoc, &state, func() error {
+			delete(state.Events[event], name)
+			return nil
+		})
+		if err != nil {
+			http.Error(w, "Failed to delete validation rule: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"shiba-api/structs"
+)
+
+// Unversioned API routes were deprecated when /v1 was introduced
+var unversionedDeprecatedAt = time.Date(2026, time.October, 17, 0, 0, 0, 0, time.UTC)
+
+type apiVersionKey struct{}
+
+// APIVersion marks requests as served by a version of the API, so responses
+// can link to routes of the same version. Responses carry an API-Version
+// header.
+func APIVersion(version int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("API-Version", strconv.Itoa(version))
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiVersionKey{}, version)))
+		})
+	}
+}
+
+// Deprecated serves the unversioned routes kept for existing clients as v1,
+// announcing their deprecation (RFC 9745), their replacement under /v1 and,
+// once API_UNVERSIONED_SUNSET is set, the date they go away (RFC 8594).
+func Deprecated(srv *structs.Server) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Deprecation", "@"+strconv.FormatInt(unversionedDeprecatedAt.Unix(), 10))
+			w.Header().Set("Link", `</v1`+r.URL.EscapedPath()+`>; rel="successor-version"`)
+			if sunset := srv.Config.Get().UnversionedSunset; sunset != nil {
+				w.Header().Set("Sunset", sunset.UTC().Format(http.TimeFormat))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// apiPath prefixes an API path with the version the request was made to, so
+// URLs handed to clients keep them on that version. Unversioned requests get
+// unversioned paths.
+func apiPath(r *http.Request, path string) string {
+	version, _ := r.Context().Value(apiVersionKey{}).(int)
+	if version == 0 {
+		return path
+	}
+	return "/v" + strconv.Itoa(version) + path
+}
@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	"shiba-api/structs"
+
+	"github.com/go-chi/chi/v5"
+)
+
+var (
+	errVersionNotFound = errors.New("version not found")
+	errVersionDraft    = errors.New("version is a draft")
+)
+
+// ProjectVersion is one build of a project. Versions are numbered from 1 in
+// upload order and never renumbered, see Build.Version.
+type ProjectVersion struct {
+	Version int    `json:"version"`
+	GameID  string `json:"gameId"`
+	URL     string `json:"url"`
+	// PinnedURL is the project's stable URL pinned to this version, for
+	// embeds that shouldn't follow new uploads
+	PinnedURL string    `json:"pinnedUrl,omitempty"`
+	Changelog string    `json:"changelog,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	Current   bool      `json:"current"`
+	Draft     bool      `json:"draft,omitempty"`
+}
+
+// projectVersions lists the versions of a project, newest first.
+func projectVersions(state *buildsState, projectID string) []ProjectVersion {
+	builds := state.projectBuilds(projectID)
+	numbers := state.versionNumbers(projectID)
+	current, _ := state.currentBuild(projectID)
+	versions := make([]ProjectVersion, len(builds))
+	for i, b := range builds {
+		versions[i] = ProjectVersion{
+			Version:   numbers[b.ID],
+			GameID:    b.ID,
+			URL:       buildURL(b),
+			PinnedURL: pinnedURL(projectID, numbers[b.ID], b.Draft),
+			Changelog: b.Changelog,
+			CreatedAt: b.CreatedAt,
+			Current:   b.ID == current.ID,
+			Draft:     b.Draft,
+		}
+	}
+	return versions
+}
+
+// GameAsProject lets routes written for {projectId} be mounted under
+// /games/{gameId}, which takes a project ID or the ID of any of its builds.
+func GameAsProject(srv *structs.Server) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			projectID, ok := gameProject(srv, w, r)
+			if !ok {
+				return
+			}
+			chi.RouteContext(r.Context()).URLParams.Add("projectId", projectID)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// viewerOwnsProject reports whether the request comes from an admin or one of
+// the project's owners. Requests without a valid token are anyone's.
+func viewerOwnsProject(srv *structs.Server, r *http.Request, projectID string) (bool, error) {
+	if isAdmin(srv, r) {
+		return true, nil
+	}
+	if bearerToken(r) == "" {
+		return false, nil
+	}
+	viewer, err := authenticateUser(srv, r)
+	if err != nil {
+		return false, nil
+	}
+	return ownsProject(r.Context(), srv, viewer.ID, projectID)
+}
+
+// ProjectVersionsHandler lists the versions of a project and which one is
+// served. Drafts are only listed for the project's owners and admins.
+func ProjectVersionsHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		projectID := chi.URLParam(r, "projectId")
+
+		state, err := loadBuilds(srv)
+		if err != nil {
+			http.Error(w, "Failed to load builds: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		versions := projectVersions(&state, projectID)
+
+		owner, err := viewerOwnsProject(srv, r, projectID)
+		if err != nil {
+			http.Error(w, "Failed to load builds: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if owner {
+			w.Header().Set("Cache-Control", "private, no-cache")
+		} else {
+			versions = slices.DeleteFunc(versions, func(v ProjectVersion) bool { return v.Draft })
+		}
+		if len(versions) == 0 {
+			http.Error(w, "Project not found", http.StatusNotFound)
+			return
+		}
+
+		_, pinned := state.Current[projectID]
+		writeJSON(w, http.StatusOK, struct {
+			ProjectID  string           `json:"projectId"`
+			RolledBack bool             `json:"rolledBack"`
+			Versions   []ProjectVersion `json:"versions"`
+		}{projectID, pinned, versions})
+	}
+}
+
+// RollbackProjectHandler makes an earlier version the one a project serves,
+// until the next upload or rollback. Rolling back to the newest version
+// clears the pin. Requires the project owner's token or the admin token.
+func RollbackProjectHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		projectID := chi.URLParam(r, "projectId")
+		if !requireProjectOwner(srv, w, r, projectID) {
+			return
+		}
+
+		var req struct {
+			Version int `json:"version" validate:"required,min=1"`
+		}
+		if !bindJSON(w, r, &req) {
+			return
+		}
+
+		var state buildsState
+		var versions []ProjectVersion
+		err := updateBuilds(srv, &state, func() error {
+			state.init()
+			target, ok := state.buildVersion(projectID, req.Version)
+			if !ok {
+				return errVersionNotFound
+			}
+			if target.Draft {
+				return errVersionDraft
+			}
+			if state.Current == nil {
+				state.Current = map[string]string{}
+			}
+			if target.ID == state.projectBuilds(projectID)[0].ID {
+				delete(state.Current, projectID)
+			} else {
+				state.Current[projectID] = target.ID
+			}
+			versions = projectVersions(&state, projectID)
+			return nil
+		})
+		if errors.Is(err, errVersionNotFound) {
+			http.Error(w, fmt.Sprintf("Project %s has no version %d", projectID, req.Version), http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, errVersionDraft) {
+			http.Error(w, fmt.Sprintf("Version %d of project %s is a draft, upload it again without draft to publish it", req.Version, projectID), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to roll back: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		notifyDevChannel(srv, &state, projectID)
+		_, pinned := state.Current[projectID]
+		writeJSON(w, http.StatusOK, struct {
+			ProjectID  string           `json:"projectId"`
+			RolledBack bool             `json:"rolledBack"`
+			Versions   []ProjectVersion `json:"versions"`
+		}{projectID, pinned, versions})
+	}
+}
+
+// pinnedURL is the stable URL of a project pinned to a version. Drafts
+// have none, as pinned URLs only serve published builds.
+func pinnedURL(projectID string, version int, draft bool) string {
+	if draft {
+		return ""
+	}
+	return fmt.Sprintf("/projects/%s/play?v=%d", url.PathEscape(projectID), version)
+}
+
+// resolveProjectBuild returns the build a stable URL of a project serves:
+// the version pinned with ?v= if there is one, else the current one. Pinned
+// versions keep serving after newer ones are uploaded or rolled back to,
+// until they are deleted.
+func resolveProjectBuild(state *buildsState, projectID string, w http.ResponseWriter, r *http.Request) (Build, bool) {
+	var query struct {
+		V int `query:"v" validate:"min=0"`
+	}
+	if !bindQuery(w, r, &query) {
+		return Build{}, false
+	}
+	if query.V == 0 {
+		current, ok := state.currentBuild(projectID)
+		if !ok {
+			http.Error(w, "Project not found", http.StatusNotFound)
+			return Build{}, false
+		}
+		// Not cached: the target moves when a new version is uploaded
+		w.Header().Set("Cache-Control", "no-store")
+		return current, true
+	}
+	build, ok := state.buildVersion(projectID, query.V)
+	if !ok || build.Draft {
+		http.Error(w, fmt.Sprintf("Version %d of this game doesn't exist or was deleted", query.V), http.StatusNotFound)
+		return Build{}, false
+	}
+	// A pinned version only moves if it's deleted
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	return build, true
+}
+
+// ProjectPlayHandler redirects the stable URL of a project to the version it
+// currently serves, or the one pinned with ?v=.
+func ProjectPlayHandler(srv *structs.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		state, err := loadBuilds(srv)
+		if err != nil {
+			http.Error(w, "Failed to load builds: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		build, ok := resolveProjectBuild(&state, chi.URLParam(r, "projectId"), w, r)
+		if !ok {
+			return
+		}
+		http.Redirect(w, r, buildURL(build), http.StatusFound)
+	}
+}
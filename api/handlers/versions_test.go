@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"shiba-api/store"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestGameVersionsAlias(t *testing.T) {
+	srv, _ := testServer(nil)
+	files, err := store.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Store = files
+	at := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	state := buildsState{Builds: map[string]Build{
+		"g1": {ID: "g1", ProjectID: "recP", Version: 1, CreatedAt: at},
+		"g2": {ID: "g2", ProjectID: "recP", Version: 2, CreatedAt: at.Add(time.Hour)},
+	}}
+	if err := srv.Store.Save(buildsDoc, state); err != nil {
+		t.Fatal(err)
+	}
+
+	router := chi.NewRouter()
+	router.With(GameAsProject(srv)).Get("/games/{gameId}/versions", ProjectVersionsHandler(srv))
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/games/recP/versions", http.StatusOK},
+		{"/games/g1/versions", http.StatusOK},
+		{"/games/nope/versions", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+		if w.Code != tt.status {
+			t.Errorf("GET %s = %d, want %d", tt.path, w.Code, tt.status)
+			continue
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		var resp struct {
+			ProjectID string           `json:"projectId"`
+			Versions  []ProjectVersion `json:"versions"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.ProjectID != "recP" || len(resp.Versions) != 2 {
+			t.Errorf("GET %s = project %q with %d versions, want recP with 2", tt.path, resp.ProjectID, len(resp.Versions))
+		}
+	}
+}
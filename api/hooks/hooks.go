@@ -0,0 +1,120 @@
+// Package hooks applies organizer-defined transforms to every extracted
+// build, such as injecting an analytics snippet or a jam splash screen.
+// Creators can't run build commands on the server; hooks are the only code
+// that touches their files after extraction.
+package hooks
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Transform rewrites the build in dir using the hook's params.
+type Transform func(dir string, params map[string]string) error
+
+var transforms = map[string]Transform{
+	"inject-html":   injectHTML,
+	"splash-screen": injectSplash,
+}
+
+// Register adds a transform hooks can refer to by name.
+func Register(name string, t Transform) {
+	transforms[name] = t
+}
+
+// Transforms lists the registered transform names.
+func Transforms() []string {
+	names := make([]string, 0, len(transforms))
+	for name := range transforms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Hook is one configured transform. Version is bumped on every change so
+// builds can record exactly which hooks they were processed with.
+type Hook struct {
+	Name      string            `json:"name"`
+	Transform string            `json:"transform"`
+	Params    map[string]string `json:"params"`
+	Enabled   bool              `json:"enabled"`
+	Version   int               `json:"version"`
+	// Order hooks run in, lowest first
+	Order int `json:"order"`
+}
+
+// Validate checks the hook refers to a known transform.
+func (h Hook) Validate() error {
+	if _, ok := transforms[h.Transform]; !ok {
+		return fmt.Errorf("unknown transform %q, expected one of %s", h.Transform, strings.Join(Transforms(), ", "))
+	}
+	return nil
+}
+
+// Apply runs the enabled hooks on dir in order and returns "name@version" for
+// each one applied.
+func Apply(dir string, hooks []Hook) ([]string, error) {
+	sorted := append([]Hook(nil), hooks...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Order < sorted[j].Order })
+
+	applied := []string{}
+	for _, h := range sorted {
+		if !h.Enabled {
+			continue
+		}
+		t, ok := transforms[h.Transform]
+		if !ok {
+			return applied, fmt.Errorf("hook %s: unknown transform %q", h.Name, h.Transform)
+		}
+		if err := t(dir, h.Params); err != nil {
+			return applied, fmt.Errorf("hook %s: %v", h.Name, err)
+		}
+		applied = append(applied, fmt.Sprintf("%s@%d", h.Name, h.Version))
+	}
+	return applied, nil
+}
+
+var (
+	headClose = regexp.MustCompile(`(?i)</head\s*>`)
+	bodyClose = regexp.MustCompile(`(?i)</body\s*>`)
+)
+
+// injectHTML inserts params["html"] into the build's HTML page (params["file"],
+// default index.html) at the end of the head, or of the body with
+// params["position"] = "body". Pages without the closing tag get it appended.
+func injectHTML(dir string, params map[string]string) error {
+	snippet := params["html"]
+	if snippet == "" {
+		return fmt.Errorf("html param is required")
+	}
+	file := params["file"]
+	if file == "" {
+		file = "index.html"
+	}
+	path := filepath.Join(dir, filepath.Clean("/"+file))
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	tag := headClose
+	if params["position"] == "body" {
+		tag = bodyClose
+	}
+	page := string(data)
+	if loc := tag.FindStringIndex(page); loc != nil {
+		page = page[:loc[0]] + snippet + "\n" + page[loc[0]:]
+	} else {
+		page += "\n" + snippet
+	}
+	return os.WriteFile(path, []byte(page), 0644)
+}
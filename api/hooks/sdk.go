@@ -0,0 +1,92 @@
+package hooks
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// SDKVersion is bumped whenever the SDK snippet changes; builds record the
+// version they got as the hook "shiba-sdk@<version>".
+const SDKVersion = 1
+
+// sdkSnippet gives a game window.shiba and bridges it to the page embedding
+// it: the game posts {source: "shiba", type, gameId, data} messages to its
+// parent for loaded, play (first input), focus, visibility and custom events,
+// and answers "ping" messages from its parent with "pong". Messages carry
+// nothing private, so they go to any parent; only the parent's are read.
+const sdkSnippet = `<script data-shiba-sdk="%d">(function () {
+if (window.shiba) return;
+var gameId = %s, parent = window.parent !== window ? window.parent : null, started = false;
+function post(type, data) {
+  if (parent) parent.postMessage({source: "shiba", type: type, gameId: gameId, data: data === undefined ? null : data}, "*");
+}
+function start() {
+  if (started) return;
+  started = true;
+  post("play");
+}
+window.shiba = {
+  gameId: gameId,
+  version: %d,
+  track: function (name, data) { post("event", {name: String(name).slice(0, 64), data: data === undefined ? null : data}); }
+};
+["pointerdown", "keydown", "touchstart"].forEach(function (t) { window.addEventListener(t, start, {capture: true, passive: true}); });
+window.addEventListener("load", function () { post("loaded"); });
+window.addEventListener("focus", function () { post("focus", {focused: true}); });
+window.addEventListener("blur", function () { post("focus", {focused: false}); });
+document.addEventListener("visibilitychange", function () { post("visibility", {visible: !document.hidden}); });
+window.addEventListener("message", function (e) {
+  if (!parent || e.source !== parent || !e.data || e.data.source !== "shiba") return;
+  if (e.data.type === "ping") post("pong", {started: started, version: %d});
+});
+})();</script>`
+
+// InjectSDK puts the Shiba SDK at the top of the head of the build's
+// index.html, before the engine's scripts, and returns the hook name builds
+// record it as. Pages that already have it, e.g. because the creator
+// uploaded a page served by Shiba, are left alone; builds without an
+// index.html are skipped.
+func InjectSDK(dir, gameID string) (string, error) {
+	path, err := rootIndex(dir)
+	if path == "" || err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	if bytes.Contains(data, []byte("data-shiba-sdk=")) {
+		return "", nil
+	}
+
+	snippet := fmt.Sprintf(sdkSnippet, SDKVersion, strconv.Quote(gameID), SDKVersion, SDKVersion)
+	page := string(data)
+	if loc := headOpen.FindStringIndex(page); loc != nil {
+		page = page[:loc[1]] + "\n" + snippet + page[loc[1]:]
+	} else {
+		page = snippet + "\n" + page
+	}
+	if err := os.WriteFile(path, []byte(page), 0644); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("shiba-sdk@%d", SDKVersion), nil
+}
+
+// rootIndex finds the build's index.html in any case, as paths are only
+// lowercased after hooks run.
+func rootIndex(dir string) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	for _, e := range entries {
+		if !e.IsDir() && strings.EqualFold(e.Name(), "index.html") {
+			return filepath.Join(dir, e.Name()), nil
+		}
+	}
+	return "", nil
+}
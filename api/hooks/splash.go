@@ -0,0 +1,159 @@
+package hooks
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+var headOpen = regexp.MustCompile(`(?i)<head(\s[^>]*)?>`)
+
+// The splash runs before any game script so it can wrap fetch and
+// XMLHttpRequest and turn asset downloads into a progress bar. It hides once
+// downloads settle, when the game posts {type: "shiba:ready"}, or after
+// maxMs at the latest.
+var splashTemplate = template.Must(template.New("splash").Parse(`<script>
+(function () {
+  var loaded = 0, total = 0, pending = 0, done = false, settle;
+  var el = document.createElement("div");
+  el.id = "shiba-splash";
+  el.setAttribute("style", "position:fixed;inset:0;z-index:2147483647;display:flex;flex-direction:column;align-items:center;justify-content:center;gap:16px;font-family:system-ui,sans-serif;transition:opacity .3s;background:{{.Background}};color:{{.Foreground}}");
+  function child(parent, tag, style) {
+    var c = document.createElement(tag);
+    c.setAttribute("style", style);
+    parent.appendChild(c);
+    return c;
+  }
+  var logo = {{.Logo}};
+  if (logo) child(el, "img", "max-width:40vw;max-height:30vh").src = logo;
+  child(el, "div", "font-size:20px").textContent = {{.Title}};
+  var track = child(el, "div", "width:min(320px,70vw);height:8px;border-radius:4px;background:rgba(127,127,127,.3);overflow:hidden");
+  var bar = child(track, "div", "width:0;height:100%;transition:width .2s;background:{{.Accent}}");
+  document.documentElement.appendChild(el);
+
+  function draw() {
+    var p = total > 0 ? loaded / total : 0;
+    bar.style.width = Math.min(100, Math.round(p * 100)) + "%";
+  }
+  function hide() {
+    if (done) return;
+    done = true;
+    bar.style.width = "100%";
+    el.style.opacity = "0";
+    setTimeout(function () { el.remove(); }, 300);
+  }
+  function start() { pending++; clearTimeout(settle); }
+  function finish() {
+    pending--;
+    draw();
+    if (pending <= 0) settle = setTimeout(hide, {{.SettleMs}});
+  }
+
+  var origFetch = window.fetch;
+  if (origFetch) {
+    window.fetch = function () {
+      if (done) return origFetch.apply(this, arguments);
+      start();
+      return origFetch.apply(this, arguments).then(function (res) {
+        var size = +res.headers.get("Content-Length") || 0;
+        total += size;
+        if (!res.body || !size) { finish(); return res; }
+        var reader = res.body.getReader();
+        var body = new ReadableStream({
+          pull: function (c) {
+            return reader.read().then(function (r) {
+              if (r.done) { finish(); c.close(); return; }
+              loaded += r.value.byteLength;
+              draw();
+              c.enqueue(r.value);
+            }, function (e) { finish(); c.error(e); });
+          },
+          cancel: function (r) { finish(); return reader.cancel(r); }
+        });
+        return new Response(body, { status: res.status, statusText: res.statusText, headers: res.headers });
+      }, function (e) { finish(); throw e; });
+    };
+  }
+
+  var origSend = XMLHttpRequest.prototype.send;
+  XMLHttpRequest.prototype.send = function () {
+    if (!done) {
+      var last = 0, counted = false;
+      start();
+      this.addEventListener("progress", function (e) {
+        if (e.lengthComputable && !counted) { total += e.total; counted = true; }
+        loaded += e.loaded - last;
+        last = e.loaded;
+        draw();
+      });
+      this.addEventListener("loadend", finish);
+    }
+    return origSend.apply(this, arguments);
+  };
+
+  window.addEventListener("message", function (e) {
+    if (e.data && e.data.type === "shiba:ready") hide();
+  });
+  window.addEventListener("load", function () {
+    if (pending <= 0) settle = setTimeout(hide, {{.SettleMs}});
+  });
+  setTimeout(hide, {{.MaxMs}});
+})();
+</script>
+`))
+
+type splashParams struct {
+	Title      string
+	Logo       string
+	Background string
+	Foreground string
+	Accent     string
+	SettleMs   int
+	MaxMs      int
+}
+
+func paramOr(params map[string]string, key, def string) string {
+	if v := params[key]; v != "" {
+		return v
+	}
+	return def
+}
+
+// injectSplash puts the Shiba loading screen at the top of the build's
+// index.html. Params: title, logo (image URL), background, foreground and
+// accent colors.
+func injectSplash(dir string, params map[string]string) error {
+	path := filepath.Join(dir, "index.html")
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	err = splashTemplate.Execute(&buf, splashParams{
+		Title:      paramOr(params, "title", "Loading..."),
+		Logo:       params["logo"],
+		Background: paramOr(params, "background", "#111"),
+		Foreground: paramOr(params, "foreground", "#fff"),
+		Accent:     paramOr(params, "accent", "#f5a623"),
+		SettleMs:   800,
+		MaxMs:      30000,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to render splash: %v", err)
+	}
+
+	page := string(data)
+	if loc := headOpen.FindStringIndex(page); loc != nil {
+		page = page[:loc[1]] + "\n" + buf.String() + page[loc[1]:]
+	} else {
+		page = buf.String() + page
+	}
+	return os.WriteFile(path, []byte(page), 0644)
+}
@@ -0,0 +1,91 @@
+package jobs
+
+import (
+	"sync"
+	"time"
+
+	"shiba-api/validate"
+)
+
+const (
+	StatusReceived   = "received"
+	StatusExtracting = "extracting"
+	StatusSyncing    = "syncing"
+	StatusDone       = "done"
+	StatusFailed     = "failed"
+)
+
+// Finished jobs are forgotten after this long
+const retention = time.Hour
+
+// Job is the pollable progress of a background upload.
+type Job struct {
+	ID        string    `json:"id"`
+	OwnerID   string    `json:"-"`
+	Status    string    `json:"status"`
+	Progress  int       `json:"progress"` // 0-100
+	GameID    string    `json:"gameId,omitempty"`
+	PlayURL   string    `json:"playUrl,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	// RequestID and TraceID identify the upload request, to quote in bug
+	// reports
+	RequestID string `json:"requestId,omitempty"`
+	TraceID   string `json:"traceId,omitempty"`
+
+	// Findings explains a build that failed validation, file by file
+	Findings []validate.Finding `json:"findings,omitempty"`
+	// Warnings point out likely broken references in a published build
+	Warnings []validate.Finding `json:"warnings,omitempty"`
+}
+
+// Tracker keeps in-memory progress for background upload jobs.
+type Tracker struct {
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{jobs: map[string]*Job{}}
+}
+
+func (t *Tracker) Start(id, ownerID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.expire()
+	t.jobs[id] = &Job{ID: id, OwnerID: ownerID, Status: StatusReceived, UpdatedAt: time.Now()}
+}
+
+// Update applies fn to the job with id, if it still exists.
+func (t *Tracker) Update(id string, fn func(j *Job)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if j, ok := t.jobs[id]; ok {
+		fn(j)
+		j.UpdatedAt = time.Now()
+	}
+}
+
+// Get returns a copy of the job with id.
+func (t *Tracker) Get(id string) (Job, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.expire()
+	j, ok := t.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *j, true
+}
+
+func (t *Tracker) expire() {
+	for id, j := range t.jobs {
+		finished := j.Status == StatusDone || j.Status == StatusFailed
+		if finished && time.Since(j.UpdatedAt) > retention {
+			delete(t.jobs, id)
+		}
+	}
+}
@@ -2,15 +2,45 @@ package main
 
 import (
 	"context"
-	"log"
-	"mime"
+	"crypto/rand"
+	"errors"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"shiba-api/announcements"
 	"shiba-api/api"
+	"shiba-api/buildindex"
+	"shiba-api/capability"
+	appconfig "shiba-api/config"
+	"shiba-api/costs"
+	"shiba-api/datastore"
+	"shiba-api/events"
+	"shiba-api/handlers"
+	"shiba-api/jobs"
+	"shiba-api/membudget"
+	"shiba-api/playpolicy"
+	"shiba-api/quota"
+	"shiba-api/search"
+	"shiba-api/stats"
+	"shiba-api/store"
 	"shiba-api/structs"
 	"shiba-api/sync"
+	"shiba-api/syncqueue"
+	"strconv"
+	"syscall"
 	"time"
 
+	"shiba-api/devchannel"
+	"shiba-api/logging"
+	"shiba-api/playtime"
+	"shiba-api/preview"
+	"shiba-api/stepup"
+	"shiba-api/users"
+	"shiba-api/webhooks"
+	"shiba-api/writequeue"
+
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
@@ -27,26 +57,124 @@ func NewServer(s3c *s3.Client, localStorageDir, gamesPrefix string) *structs.Ser
 		AirtableClient: airtable.NewClient(
 			os.Getenv("AIRTABLE_API_KEY"),
 		),
-		AdminToken: os.Getenv("ADMIN_TOKEN"),
+		AdminToken:   os.Getenv("ADMIN_TOKEN"),
+		UploadJobs:   jobs.NewTracker(),
+		Quotas:       quota.NewTracker(),
+		UploadSlots:  quota.NewSlots(),
+		Egress:       costs.NewMeter(),
+		SearchIndex:  search.NewIndex(),
+		StepUp:       stepup.NewVerifier(stepUpSenders()),
+		Tokens:       users.NewTokenCache(),
+		BuildIndex:   buildindex.New(),
+		Games:        datastore.NewGameCache(),
+		Previews:     preview.NewSigner(previewKey()),
+		Capabilities: capability.NewSigner(capabilityKey()),
+		DevChannel:   devchannel.NewHub(),
+		PlaySessions: playtime.NewTracker(),
+		Webhooks:     webhooks.NewDispatcher(4),
+		// Announcements are loaded once the store is open
+		Announcements: announcements.NewBoard(),
+	}
+}
+
+// metadataBackupTarget is the private bucket metadata snapshots go to,
+// METADATA_BACKUP_BUCKET, reached with the R2 credentials unless
+// METADATA_BACKUP_ACCESS_KEY_ID, METADATA_BACKUP_SECRET_ACCESS_KEY and
+// METADATA_BACKUP_ENDPOINT give others. The server won't start without one,
+// as it couldn't restore or back up its store.
+func metadataBackupTarget(ctx context.Context, r2 aws.Config, r2Endpoint string) sync.BackupTarget {
+	bucket := os.Getenv("METADATA_BACKUP_BUCKET")
+	if bucket == "" {
+		fatal(ctx, "METADATA_BACKUP_BUCKET must be set with STORE_DRIVER=sqlite")
+	}
+	if bucket == os.Getenv("R2_BUCKET") {
+		fatal(ctx, "METADATA_BACKUP_BUCKET must be a private bucket, not R2_BUCKET")
+	}
+
+	cfg, endpoint := r2, r2Endpoint
+	if e := os.Getenv("METADATA_BACKUP_ENDPOINT"); e != "" {
+		endpoint = e
+	}
+	accessKey, secretKey := os.Getenv("METADATA_BACKUP_ACCESS_KEY_ID"), os.Getenv("METADATA_BACKUP_SECRET_ACCESS_KEY")
+	if accessKey != "" || secretKey != "" {
+		if accessKey == "" || secretKey == "" {
+			fatal(ctx, "METADATA_BACKUP_ACCESS_KEY_ID and METADATA_BACKUP_SECRET_ACCESS_KEY must be set together")
+		}
+		cfg = r2.Copy()
+		cfg.Credentials = credentials.NewStaticCredentialsProvider(accessKey, secretKey, "")
+	}
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		o.BaseEndpoint = aws.String(endpoint)
+	})
+	return sync.BackupTarget{Client: client, Bucket: bucket}
+}
+
+// previewKey is the key draft preview tokens are signed with, shared with the
+// CDN worker. Without PREVIEW_SIGNING_KEY a random key is used, so previews
+// only work against this process and stop working after a restart.
+func previewKey() []byte {
+	if key := os.Getenv("PREVIEW_SIGNING_KEY"); key != "" {
+		return []byte(key)
+	}
+	slog.Warn("PREVIEW_SIGNING_KEY is not set, signing draft previews with a random key the CDN can't check")
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		fatal(context.Background(), "Failed to generate preview key", "error", err)
+	}
+	return key
+}
+
+// capabilityKey is the key capability URLs are signed with. Without
+// CAPABILITY_SIGNING_KEY a random key is used, so URLs minted before a
+// restart stop working.
+func capabilityKey() []byte {
+	if key := os.Getenv("CAPABILITY_SIGNING_KEY"); key != "" {
+		return []byte(key)
+	}
+	slog.Warn("CAPABILITY_SIGNING_KEY is not set, capability URLs stop working when the server restarts")
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		fatal(context.Background(), "Failed to generate capability key", "error", err)
+	}
+	return key
+}
+
+// stepUpSenders configures the channels step-up codes can be sent over.
+func stepUpSenders() map[string]stepup.Sender {
+	senders := map[string]stepup.Sender{}
+	if key, template := os.Getenv("LOOPS_TRANSACTIONAL_KEY"), os.Getenv("LOOPS_TRANSACTIONAL_TEMPLATE_ID"); key != "" && template != "" {
+		senders[stepup.ChannelEmail] = stepup.Loops{Key: key, TemplateID: template}
+	}
+	if token := os.Getenv("SLACK_BOT_TOKEN"); token != "" {
+		senders[stepup.ChannelSlack] = stepup.Slack{Token: token}
 	}
+	return senders
 }
 
 func init() {
-	mime.AddExtensionType(".js", "application/javascript")
-	mime.AddExtensionType(".mjs", "application/javascript")
-	mime.AddExtensionType(".wasm", "application/wasm")
-	mime.AddExtensionType(".json", "application/json")
-	mime.AddExtensionType(".pck", "application/octet-stream") // Godot packs
-	mime.AddExtensionType(".ogg", "audio/ogg")
-	mime.AddExtensionType(".png", "image/png")
-	mime.AddExtensionType(".jpg", "image/jpeg")
-	mime.AddExtensionType(".jpeg", "image/jpeg")
+	playpolicy.RegisterMIMETypes()
+}
+
+// fatal logs an error the server can't start or keep running without, and
+// exits.
+func fatal(ctx context.Context, msg string, args ...any) {
+	slog.ErrorContext(ctx, msg, args...)
+	os.Exit(1)
 }
 
 func main() {
+	ctx := context.Background()
 	err := godotenv.Load()
+	logging.Setup()
 	if err != nil {
-		log.Printf("Error loading .env file")
+		slog.InfoContext(ctx, "No .env file loaded", "error", err)
+	}
+
+	// Account tokens are only stored as hashes keyed with TOKEN_PEPPER
+	if pepper := os.Getenv("TOKEN_PEPPER"); pepper != "" {
+		users.SetPepper([]byte(pepper))
+	} else {
+		slog.WarnContext(ctx, "TOKEN_PEPPER is not set, account tokens are hashed without a pepper")
 	}
 
 	r2AccessKey := os.Getenv("R2_ACCESS_KEY_ID")
@@ -55,19 +183,11 @@ func main() {
 	r2Endpoint := os.Getenv("R2_ENDPOINT")
 
 	if r2AccessKey == "" || r2SecretKey == "" || r2Region == "" || r2Endpoint == "" {
-		log.Fatal("R2 credentials and endpoint must be set in environment variables")
+		fatal(ctx, "R2 credentials and endpoint must be set in environment variables")
 	}
 
-	log.Println("----------------------------")
-	log.Println("Shiba API")
-	log.Println("^-^")
-	log.Println("-----------------------------")
-	log.Printf("R2 Access Key: %s\n", r2AccessKey)
-	log.Printf("R2 Secret Key: %s\n", r2SecretKey)
-	log.Printf("R2 Region: %s\n", r2Region)
-	log.Printf("R2 Endpoint: %s\n", r2Endpoint)
-	log.Println("-----------------------------")
-	log.Println("Initializing the server...")
+	// The secret key is never logged
+	slog.InfoContext(ctx, "Initializing Shiba API ^-^", "r2_access_key", r2AccessKey, "r2_region", r2Region, "r2_endpoint", r2Endpoint)
 
 	// Make the s3 client with R2 credentials
 
@@ -78,35 +198,340 @@ func main() {
 		config.WithRegion("auto"),
 	)
 	if err != nil {
-		log.Fatalf("failed to load config: %v", err)
+		fatal(ctx, "Failed to load R2 config", "error", err)
 	}
 
 	s3Client := s3.NewFromConfig(cfg, func(o *s3.Options) {
 		o.BaseEndpoint = aws.String(r2Endpoint)
 	})
 
+	srv := NewServer(s3Client, "/games", "games")
+
+	appCfg, err := appconfig.FromEnv()
 	if err != nil {
-		log.Fatalf("failed to load config: %v", err)
+		fatal(ctx, "Invalid config", "error", err)
 	}
+	srv.Config = appconfig.NewHolder(appCfg)
+	srv.Memory = membudget.New(func() int64 { return int64(srv.Config.Get().MemoryBudgetMB) << 20 })
 
-	srv := NewServer(s3Client, "/games", "games")
+	// Reload limits, flags and deadlines on SIGHUP without dropping uploads
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if _, err := srv.Config.Reload(); err != nil {
+				slog.ErrorContext(ctx, "Config reload failed, keeping the current config", "error", err)
+			} else {
+				slog.InfoContext(ctx, "Config reloaded")
+			}
+		}
+	}()
+
+	dataDir := os.Getenv("DATA_DIR")
+	if dataDir == "" {
+		dataDir = "./data"
+	}
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		fatal(ctx, "Failed to create data directory", "error", err)
+	}
+
+	switch os.Getenv("STORE_DRIVER") {
+	case "sqlite":
+		backups := metadataBackupTarget(ctx, cfg, r2Endpoint)
+		dbPath := filepath.Join(dataDir, "metadata.db")
+		if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+			slog.InfoContext(ctx, "No local metadata database, restoring the latest backup")
+			// Starting empty would let the next backup overwrite the
+			// snapshot that failed to restore
+			if err := sync.RestoreMetadata(ctx, backups, dbPath); err != nil {
+				fatal(ctx, "Metadata restore failed", "error", err)
+			}
+		}
+
+		sqliteStore, err := store.NewSQLiteStore(dbPath)
+		if err != nil {
+			fatal(ctx, "Failed to open data store", "error", err)
+		}
+		srv.Store = sqliteStore
+
+		backupInterval, err := time.ParseDuration(os.Getenv("STORE_BACKUP_INTERVAL"))
+		if err != nil {
+			backupInterval = time.Hour
+		}
+		go func() {
+			ticker := time.NewTicker(backupInterval)
+			defer ticker.Stop()
+
+			for range ticker.C {
+				if err := sync.BackupMetadata(ctx, backups, sqliteStore); err != nil {
+					slog.ErrorContext(ctx, "Metadata backup failed", "error", err)
+				}
+			}
+		}()
+	case "postgres":
+		maxConns, err := strconv.Atoi(os.Getenv("STORE_MAX_CONNS"))
+		if err != nil || maxConns < 1 {
+			maxConns = 10
+		}
+		srv.Store, err = store.NewPostgresStore(os.Getenv("DATABASE_URL"), store.PostgresOptions{
+			MaxOpenConns:    maxConns,
+			MaxIdleConns:    maxConns / 2,
+			ConnMaxLifetime: 30 * time.Minute,
+		})
+		if err != nil {
+			fatal(ctx, "Failed to open data store", "error", err)
+		}
+	default:
+		srv.Store, err = store.NewFileStore(dataDir)
+		if err != nil {
+			fatal(ctx, "Failed to open data store", "error", err)
+		}
+	}
+	srv.Events, err = events.Open(filepath.Join(dataDir, "events.jsonl"))
+	if err != nil {
+		fatal(ctx, "Failed to open event log", "error", err)
+	}
+
+	// Builds are synced to R2 through a queue kept in the store, so syncs
+	// cut short by a restart resume now
+	srv.SyncQueue = syncqueue.New(srv.Store, 2, func(ctx context.Context, gameID, dir string) error {
+		return handlers.SyncBuild(ctx, srv, gameID, dir)
+	})
+	go srv.SyncQueue.Run(context.Background())
+
+	// Heartbeats and served traffic are buffered and written once a minute,
+	// along with the playtime of play sessions players left
+	srv.Stats = stats.NewAggregator(srv.Store)
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+
+		for now := range ticker.C {
+			handlers.EndIdlePlaySessions(srv)
+			if err := srv.Stats.Flush(); err != nil {
+				slog.ErrorContext(ctx, "Stats rollup failed", "error", err)
+			}
+			handlers.NotifyPlaytimeMilestones(srv)
+			if err := srv.Egress.Flush(srv.Store, now); err != nil {
+				slog.ErrorContext(ctx, "Egress flush failed", "error", err)
+			}
+		}
+	}()
 
 	srv.AirtableBaseTable = srv.AirtableClient.GetTable(os.Getenv("AIRTABLE_BASE_ID"), "Users")
 	if srv.AirtableBaseTable == nil {
-		log.Fatal("Failed to get Airtable base table")
+		fatal(ctx, "Failed to get Airtable base table")
+	}
+	slog.InfoContext(ctx, "Adding the Airtable base")
+
+	gamesTable := os.Getenv("AIRTABLE_GAMES_TABLE")
+	if gamesTable == "" {
+		gamesTable = "Games"
+	}
+	srv.AirtableGamesTable = srv.AirtableClient.GetTable(os.Getenv("AIRTABLE_BASE_ID"), gamesTable)
+
+	playsTable := os.Getenv("AIRTABLE_PLAYS_TABLE")
+	if playsTable == "" {
+		playsTable = "Plays"
 	}
-	log.Println("Adding the airtable base...")
+	srv.AirtablePlaysTable = srv.AirtableClient.GetTable(os.Getenv("AIRTABLE_BASE_ID"), playsTable)
+
+	// Record updates go through one queue batching them under the rate limit
+	writesPerSecond := 2.0
+	if v, err := strconv.ParseFloat(os.Getenv("AIRTABLE_WRITES_PER_SECOND"), 64); err == nil && v > 0 {
+		writesPerSecond = v
+	}
+	srv.AirtableWrites = writequeue.New(writesPerSecond)
+	go srv.AirtableWrites.Run(context.Background())
+
+	// Users and games are kept in Airtable unless DATASTORE=postgres moves
+	// them to DATASTORE_URL (default DATABASE_URL)
+	switch os.Getenv("DATASTORE") {
+	case "postgres":
+		dsn := os.Getenv("DATASTORE_URL")
+		if dsn == "" {
+			dsn = os.Getenv("DATABASE_URL")
+		}
+		pg, err := datastore.NewPostgres(dsn)
+		if err != nil {
+			fatal(ctx, "Failed to open datastore", "error", err)
+		}
+		srv.UserStore, srv.GameStore = pg, pg
+	default:
+		// Auth reads users from a local replica of the Users table, refreshed
+		// incrementally every USERS_SYNC_INTERVAL and in full every
+		// USERS_FULL_SYNC_INTERVAL
+		srv.Users = users.NewReplica(srv.Store, srv.AirtableBaseTable)
+		if err := srv.Users.Load(); err != nil {
+			slog.ErrorContext(ctx, "Failed to load users replica", "error", err)
+		}
+		go func() {
+			interval, err := time.ParseDuration(os.Getenv("USERS_SYNC_INTERVAL"))
+			if err != nil || interval <= 0 {
+				interval = time.Minute
+			}
+			fullInterval, err := time.ParseDuration(os.Getenv("USERS_FULL_SYNC_INTERVAL"))
+			if err != nil || fullInterval <= 0 {
+				fullInterval = time.Hour
+			}
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+
+			for {
+				full := time.Since(srv.Users.Status().LastFull) >= fullInterval
+				if report, err := srv.Users.Sync(context.Background(), full); err != nil {
+					slog.ErrorContext(ctx, "Users sync failed", "error", err)
+				} else if report.Full {
+					slog.InfoContext(ctx, "Users replica refreshed", "users", report.Users, "deleted", report.Deleted)
+				}
+				<-ticker.C
+			}
+		}()
+
+		at := &datastore.Airtable{
+			Users:   srv.AirtableBaseTable,
+			Games:   srv.AirtableGamesTable,
+			Replica: srv.Users,
+			Writes:  srv.AirtableWrites,
+		}
+		srv.UserStore, srv.GameStore = at, at
+	}
+
+	// Builds from before storage quotas count against them too
+	go func() {
+		if err := handlers.BackfillStorageUsage(srv); err != nil {
+			slog.ErrorContext(ctx, "Failed to backfill storage usage", "error", err)
+		}
+	}()
+
+	// Announcements posted through the admin API are kept in the store,
+	// those in AIRTABLE_ANNOUNCEMENTS_TABLE are read every minute. Both are
+	// rechecked every 15 seconds for ones starting or ending.
+	if err := handlers.LoadAnnouncements(srv); err != nil {
+		slog.ErrorContext(ctx, "Failed to load announcements", "error", err)
+	}
+	var announcementsTable *airtable.Table
+	if name := os.Getenv("AIRTABLE_ANNOUNCEMENTS_TABLE"); name != "" {
+		announcementsTable = srv.AirtableClient.GetTable(os.Getenv("AIRTABLE_BASE_ID"), name)
+	}
+	go func() {
+		ticker := time.NewTicker(15 * time.Second)
+		defer ticker.Stop()
+
+		var fetched time.Time
+		for now := range ticker.C {
+			if announcementsTable != nil && now.Sub(fetched) >= time.Minute {
+				fetched = now
+				if list, err := sync.LoadAirtableAnnouncements(announcementsTable); err != nil {
+					slog.ErrorContext(ctx, "Announcements sync failed", "error", err)
+				} else {
+					srv.Announcements.Set(announcements.SourceAirtable, list)
+				}
+			}
+			srv.Announcements.Refresh(now)
+		}
+	}()
 
 	go func() {
 		ticker := time.NewTicker(10 * time.Minute) // interval
 		defer ticker.Stop()
 
 		for {
-			log.Println("Starting background R2 sync...")
+			slog.InfoContext(ctx, "Starting background R2 sync")
 			if err := sync.SyncFromR2(*srv); err != nil {
-				log.Printf("R2 sync error: %v", err)
+				slog.ErrorContext(ctx, "R2 sync failed", "error", err)
 			} else {
-				log.Println("R2 sync completed successfully")
+				slog.InfoContext(ctx, "R2 sync completed")
+			}
+			<-ticker.C
+		}
+	}()
+
+	go func() {
+		ticker := time.NewTicker(10 * time.Minute)
+		defer ticker.Stop()
+
+		for {
+			if err := sync.RebuildSearchIndex(*srv, srv.SearchIndex); err != nil {
+				slog.ErrorContext(ctx, "Search index rebuild failed", "error", err)
+			}
+			<-ticker.C
+		}
+	}()
+
+	go func() {
+		ticker := time.NewTicker(10 * time.Minute)
+		defer ticker.Stop()
+
+		for {
+			if n, err := handlers.RecordMilestones(ctx, srv); err != nil {
+				slog.ErrorContext(ctx, "Milestone recording failed", "error", err)
+			} else if n > 0 {
+				slog.InfoContext(ctx, "Recorded devlog milestones", "milestones", n)
+			}
+			<-ticker.C
+		}
+	}()
+
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+
+		for {
+			if err := sync.RefreshRecommendations(*srv); err != nil {
+				slog.ErrorContext(ctx, "Recommendations refresh failed", "error", err)
+			}
+			<-ticker.C
+		}
+	}()
+
+	// Stats export is opt-in: the target fields have to exist in the Games table
+	if exportInterval, err := time.ParseDuration(os.Getenv("AIRTABLE_EXPORT_INTERVAL")); err == nil && exportInterval > 0 {
+		go func() {
+			ticker := time.NewTicker(exportInterval)
+			defer ticker.Stop()
+
+			for range ticker.C {
+				if err := sync.ExportStatsToAirtable(*srv); err != nil {
+					slog.ErrorContext(ctx, "Airtable export failed", "error", err)
+				}
+			}
+		}()
+	}
+
+	go func() {
+		ticker := time.NewTicker(10 * time.Minute)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if n, err := handlers.ExpireDemos(context.Background(), srv); err != nil {
+				slog.ErrorContext(ctx, "Demo cleanup failed", "error", err)
+			} else if n > 0 {
+				slog.InfoContext(ctx, "Deleted expired demo uploads", "uploads", n)
+			}
+		}
+	}()
+
+	if c := srv.Config.Get(); c.ManageLifecycle {
+		go func() {
+			if err := sync.EnsureLifecycle(context.Background(), *srv, c.TrashDays); err != nil {
+				slog.ErrorContext(ctx, "Failed to set lifecycle rules", "error", err)
+			}
+		}()
+	}
+
+	// Retention runs daily; dry runs are available from /admin/retention
+	go func() {
+		ticker := time.NewTicker(24 * time.Hour)
+		defer ticker.Stop()
+
+		for {
+			report, err := handlers.ApplyRetention(srv, false)
+			if err != nil {
+				slog.ErrorContext(ctx, "Retention failed", "error", err)
+			} else if len(report.HourlyStatsPurged) > 0 || len(report.ProvenanceAnonymized) > 0 || report.BlobsCollected > 0 {
+				slog.InfoContext(ctx, "Retention applied", "hourly_stats_days", len(report.HourlyStatsPurged),
+					"provenance_anonymized", len(report.ProvenanceAnonymized), "blobs", report.BlobsCollected, "blob_bytes", report.BlobBytesCollected)
 			}
 			<-ticker.C
 		}
@@ -114,12 +539,16 @@ func main() {
 
 	r := chi.NewRouter()
 
+	r.Use(handlers.RealIP(srv))
+	r.Use(handlers.RequestID)
+
 	// Cors setup
 
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"*"},
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "X-Step-Up", "X-Shiba-Faults", "X-Leaderboard-Key", "If-Match", "traceparent", "X-Request-ID"},
+		ExposedHeaders:   []string{"ETag", "X-RateLimit-Resource", "X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "Retry-After", "API-Version", "Deprecation", "Sunset", "Link", "traceparent", "X-Request-ID"},
 		AllowCredentials: true,
 		MaxAge:           600,
 	}))
@@ -137,8 +566,31 @@ func main() {
 
 	api.SetupRoutes(r, srv)
 
-	log.Println("Listening on :3001")
-	if err := http.ListenAndServe(":3001", r); err != nil {
-		log.Fatal(err)
+	// On SIGTERM or SIGINT stop taking requests, let the in-flight ones
+	// finish, then write out the buffered stats and egress before exiting
+	httpServer := &http.Server{Addr: ":3001", Handler: r}
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		<-stop
+		slog.InfoContext(ctx, "Shutting down")
+		shutdownCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		defer cancel()
+		if err := httpServer.Shutdown(shutdownCtx); err != nil {
+			slog.ErrorContext(ctx, "Shutdown failed", "error", err)
+		}
+	}()
+
+	slog.InfoContext(ctx, "Listening", "addr", ":3001")
+	if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fatal(ctx, "Server failed", "error", err)
+	}
+
+	handlers.EndIdlePlaySessions(srv)
+	if err := srv.Stats.Flush(); err != nil {
+		slog.ErrorContext(ctx, "Stats rollup failed", "error", err)
+	}
+	if err := srv.Egress.Flush(srv.Store, time.Now()); err != nil {
+		slog.ErrorContext(ctx, "Egress flush failed", "error", err)
 	}
 }
@@ -0,0 +1,179 @@
+// Package malware scans build files with ClamAV, through clamd's INSTREAM
+// command, before builds are published.
+package malware
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// How much of a file goes in one INSTREAM chunk
+const chunkSize = 64 << 10
+
+// Match is a file ClamAV found a signature in.
+type Match struct {
+	Path      string `json:"path"`
+	Signature string `json:"signature"`
+}
+
+// Clamd talks to a clamd daemon.
+type Clamd struct {
+	network string
+	address string
+	// Timeout bounds each file's scan
+	Timeout time.Duration
+}
+
+// NewClamd returns a client for the clamd listening at addr:
+// "unix:/run/clamav/clamd.ctl", "tcp:host:3310" or just "host:3310".
+func NewClamd(addr string) (*Clamd, error) {
+	network, address := "tcp", addr
+	if rest, ok := strings.CutPrefix(addr, "unix:"); ok {
+		network, address = "unix", rest
+	} else if rest, ok := strings.CutPrefix(addr, "tcp:"); ok {
+		address = rest
+	}
+	if address == "" {
+		return nil, fmt.Errorf("invalid clamd address %q", addr)
+	}
+	return &Clamd{network: network, address: address, Timeout: 2 * time.Minute}, nil
+}
+
+func (c *Clamd) dial(ctx context.Context) (net.Conn, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, c.network, c.address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach clamd at %s: %v", c.address, err)
+	}
+	deadline := time.Now().Add(c.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+	return conn, nil
+}
+
+// Ping checks that clamd answers.
+func (c *Clamd) Ping(ctx context.Context) error {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("zPING\x00")); err != nil {
+		return fmt.Errorf("failed to ping clamd: %v", err)
+	}
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil {
+		return fmt.Errorf("failed to ping clamd: %v", err)
+	}
+	if reply = strings.TrimRight(reply, "\x00"); reply != "PONG" {
+		return fmt.Errorf("unexpected reply from clamd: %q", reply)
+	}
+	return nil
+}
+
+// Scan streams r to clamd and returns the name of the signature it matched,
+// empty if none did.
+func (c *Clamd) Scan(ctx context.Context, r io.Reader) (string, error) {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return "", fmt.Errorf("failed to start scan: %v", err)
+	}
+	buf := make([]byte, 4+chunkSize)
+	for {
+		n, err := io.ReadFull(r, buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf, uint32(n))
+			if _, werr := conn.Write(buf[:4+n]); werr != nil {
+				// clamd hangs up on streams over its StreamMaxLength, with
+				// the reason as its reply
+				break
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to read file: %v", err)
+		}
+	}
+	// A zero-length chunk ends the stream
+	conn.Write([]byte{0, 0, 0, 0})
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && reply == "" {
+		return "", fmt.Errorf("failed to read scan result: %v", err)
+	}
+	return parseReply(strings.TrimRight(reply, "\x00\n"))
+}
+
+// parseReply reads clamd's answer to INSTREAM: "stream: OK",
+// "stream: <signature> FOUND" or "<reason> ERROR".
+func parseReply(reply string) (string, error) {
+	result := strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case result == "OK":
+		return "", nil
+	case strings.HasSuffix(result, " FOUND"):
+		return strings.TrimSuffix(result, " FOUND"), nil
+	case strings.HasSuffix(result, " ERROR"):
+		return "", fmt.Errorf("clamd: %s", strings.TrimSuffix(result, " ERROR"))
+	}
+	return "", fmt.Errorf("unexpected reply from clamd: %q", reply)
+}
+
+// ScanDir scans every file under dir and returns the ones matching a
+// signature, with paths relative to dir. Files over maxSize bytes (0 for no
+// limit) are skipped, as clamd refuses streams over its StreamMaxLength.
+func (c *Clamd) ScanDir(ctx context.Context, dir string, maxSize int64) (matches []Match, skipped []string, err error) {
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if maxSize > 0 {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			if info.Size() > maxSize {
+				skipped = append(skipped, rel)
+				return nil
+			}
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		signature, err := c.Scan(ctx, f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("failed to scan %s: %w", rel, err)
+		}
+		if signature != "" {
+			matches = append(matches, Match{Path: rel, Signature: signature})
+		}
+		return nil
+	})
+	return matches, skipped, err
+}
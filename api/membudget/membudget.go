@@ -0,0 +1,215 @@
+// Package membudget accounts for the memory uploads and syncs hold, so a
+// spike of uploads before a deadline waits its turn instead of growing the
+// heap until the process is OOM killed along with every upload in flight.
+//
+// Work is admitted with an initial reservation, waiting while the budget is
+// spent or the heap is close to GOMEMLIMIT. Once admitted it is never
+// blocked: what it holds on top (decoded images, multipart buffers, wasm
+// memories) is charged to its reservation, possibly over the budget, and
+// only delays the admission of more work.
+package membudget
+
+import (
+	"context"
+	"math"
+	"runtime/debug"
+	"runtime/metrics"
+	"sync"
+	"time"
+)
+
+// Share of GOMEMLIMIT used as the budget when none is configured, and the
+// heap size, as a share of GOMEMLIMIT, above which nothing new is admitted
+const (
+	defaultShare  = 0.75
+	pressureShare = 0.9
+)
+
+// How often waiting admissions check the heap again, when it's the heap
+// holding them back rather than other reservations
+const pressurePoll = 250 * time.Millisecond
+
+// Budget is the memory uploads and syncs may hold between them.
+type Budget struct {
+	limit func() int64
+
+	mu      sync.Mutex
+	used    int64
+	waiting int
+	// released is closed and replaced whenever memory is given back
+	released chan struct{}
+}
+
+// New returns a budget of limit bytes, read on every admission so it can be
+// reloaded. A limit of 0 or less uses 75% of GOMEMLIMIT, or admits
+// everything when that isn't set either.
+func New(limit func() int64) *Budget {
+	return &Budget{limit: limit, released: make(chan struct{})}
+}
+
+// memoryLimit is the runtime's soft memory limit, 0 when GOMEMLIMIT isn't
+// set.
+func memoryLimit() int64 {
+	limit := debug.SetMemoryLimit(-1)
+	if limit == math.MaxInt64 {
+		return 0
+	}
+	return limit
+}
+
+// Limit is the current budget in bytes, 0 for none.
+func (b *Budget) Limit() int64 {
+	if limit := b.limit(); limit > 0 {
+		return limit
+	}
+	return int64(float64(memoryLimit()) * defaultShare)
+}
+
+var memorySamples = []metrics.Sample{
+	{Name: "/memory/classes/total:bytes"},
+	{Name: "/memory/classes/heap/released:bytes"},
+}
+
+// underPressure reports whether the memory the runtime holds, counted the
+// way GOMEMLIMIT counts it, is close to that limit.
+func underPressure() bool {
+	limit := memoryLimit()
+	if limit == 0 {
+		return false
+	}
+	samples := make([]metrics.Sample, len(memorySamples))
+	copy(samples, memorySamples)
+	metrics.Read(samples)
+	inUse := samples[0].Value.Uint64() - samples[1].Value.Uint64()
+	return float64(inUse) > float64(limit)*pressureShare
+}
+
+// Usage is a snapshot of the budget.
+type Usage struct {
+	LimitBytes int64 `json:"limitBytes"`
+	UsedBytes  int64 `json:"usedBytes"`
+	Waiting    int   `json:"waiting"`
+}
+
+func (b *Budget) Usage() Usage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return Usage{LimitBytes: b.Limit(), UsedBytes: b.used, Waiting: b.waiting}
+}
+
+// Admit reserves n bytes, waiting until they fit in the budget and the heap
+// isn't close to GOMEMLIMIT, or until ctx is done. Work asking for more than
+// the whole budget is admitted once nothing else holds any. Work is always
+// admitted when nothing else holds memory, whatever the heap says, so
+// memory held outside the budget can't stall uploads for good.
+func (b *Budget) Admit(ctx context.Context, n int64) (*Reservation, error) {
+	b.mu.Lock()
+	for {
+		limit := b.Limit()
+		fits := limit <= 0 || b.used == 0 || b.used+n <= limit
+		if fits && (b.used == 0 || !underPressure()) {
+			b.used += n
+			b.mu.Unlock()
+			return &Reservation{budget: b, held: n}, nil
+		}
+
+		released := b.released
+		b.waiting++
+		b.mu.Unlock()
+		var poll <-chan time.Time
+		if fits {
+			poll = time.After(pressurePoll)
+		}
+		select {
+		case <-released:
+		case <-poll:
+		case <-ctx.Done():
+			b.mu.Lock()
+			b.waiting--
+			b.mu.Unlock()
+			return nil, ctx.Err()
+		}
+		b.mu.Lock()
+		b.waiting--
+	}
+}
+
+// Reserve reserves n bytes without waiting, for work that must run anyway,
+// like a sync of a build already published. It delays admissions all the
+// same.
+func (b *Budget) Reserve(n int64) *Reservation {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.used += n
+	return &Reservation{budget: b, held: n}
+}
+
+func (b *Budget) give(n int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.used -= n
+	close(b.released)
+	b.released = make(chan struct{})
+}
+
+// Reservation is the memory one upload or sync holds.
+type Reservation struct {
+	budget *Budget
+	mu     sync.Mutex
+	held   int64
+}
+
+// Hold charges n more bytes to the reservation, without waiting. The
+// returned func gives them back early; calling it is optional, Release gives
+// back everything still held.
+func (r *Reservation) Hold(n int64) (release func()) {
+	r.mu.Lock()
+	r.held += n
+	r.mu.Unlock()
+	r.budget.mu.Lock()
+	r.budget.used += n
+	r.budget.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			r.mu.Lock()
+			if n > r.held {
+				// Already given back by Release
+				n = r.held
+			}
+			r.held -= n
+			r.mu.Unlock()
+			r.budget.give(n)
+		})
+	}
+}
+
+// Release gives back everything the reservation holds. It may be called
+// more than once.
+func (r *Reservation) Release() {
+	r.mu.Lock()
+	n := r.held
+	r.held = 0
+	r.mu.Unlock()
+	if n > 0 {
+		r.budget.give(n)
+	}
+}
+
+type reservationKey struct{}
+
+// WithReservation returns a context carrying r, for the code along the
+// upload or sync to charge what it holds to.
+func WithReservation(ctx context.Context, r *Reservation) context.Context {
+	return context.WithValue(ctx, reservationKey{}, r)
+}
+
+// Hold charges n bytes to the reservation ctx carries, doing nothing for
+// contexts without one.
+func Hold(ctx context.Context, n int64) (release func()) {
+	if r, ok := ctx.Value(reservationKey{}).(*Reservation); ok && n > 0 {
+		return r.Hold(n)
+	}
+	return func() {}
+}
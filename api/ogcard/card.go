@@ -0,0 +1,210 @@
+// Package ogcard renders the social card (Open Graph image) of a game: its
+// title and creator next to a screenshot, as a 1200x630 PNG, the size Slack,
+// Discord and most other unfurlers show in full. Text is drawn with a built-in
+// pixel font, so rendering needs no font files or external tools.
+package ogcard
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"strings"
+)
+
+const (
+	Width  = 1200
+	Height = 630
+
+	margin = 60
+	// Screenshot area, on the right of the card
+	shotX, shotY = 620, margin
+	shotW, shotH = Width - margin - shotX, Height - 2*margin
+	titleLines   = 3
+)
+
+var (
+	backgroundTop    = color.RGBA{0x1e, 0x1b, 0x4b, 0xff}
+	backgroundBottom = color.RGBA{0x4c, 0x1d, 0x95, 0xff}
+	titleColor       = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	creatorColor     = color.RGBA{0xc4, 0xb5, 0xfd, 0xff}
+	footerColor      = color.RGBA{0xa5, 0xb4, 0xfc, 0xff}
+)
+
+// Card is what a social card shows. Screenshot is optional.
+type Card struct {
+	Title      string
+	Creator    string
+	Screenshot image.Image
+}
+
+// latin1 maps U+00C0 to U+00FF to the closest letter the font has
+const latin1 = "AAAAAAACEEEEIIIIDNOOOOOxOUUUUYTsaaaaaaaceeeeiiiidnooooo/ouuuuyty"
+
+// printable reduces s to characters the font can draw: accents are dropped,
+// typographic quotes and dashes made plain and anything else left out.
+func printable(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r >= ' ' && r <= '~':
+			b.WriteRune(r)
+		case r >= 0xC0 && r <= 0xFF:
+			b.WriteByte(latin1[r-0xC0])
+		case r == '‘' || r == '’':
+			b.WriteByte('\'')
+		case r == '“' || r == '”':
+			b.WriteByte('"')
+		case r == '–' || r == '—':
+			b.WriteByte('-')
+		case r == '\t' || r == '\n':
+			b.WriteByte(' ')
+		}
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// wrap breaks s into lines of at most width characters, breaking words only
+// when they don't fit on a line of their own. It reports whether s fit in
+// maxLines; if not, the last line ends with "...".
+func wrap(s string, width, maxLines int) ([]string, bool) {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(s) {
+		for len(word) > width {
+			if line != "" {
+				lines = append(lines, line)
+				line = ""
+			}
+			lines = append(lines, word[:width])
+			word = word[width:]
+		}
+		switch {
+		case line == "":
+			line = word
+		case len(line)+1+len(word) <= width:
+			line += " " + word
+		default:
+			lines = append(lines, line)
+			line = word
+		}
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	if len(lines) <= maxLines {
+		return lines, true
+	}
+	lines = lines[:maxLines]
+	last := lines[maxLines-1]
+	if len(last) > width-3 {
+		last = last[:width-3]
+	}
+	lines[maxLines-1] = strings.TrimRight(last, " ") + "..."
+	return lines, false
+}
+
+// drawText draws s at x, y (its top left corner) with pixels scale wide.
+func drawText(img *image.RGBA, x, y, scale int, c color.RGBA, s string) {
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if ch < ' ' || ch > '~' {
+			continue
+		}
+		glyph := glyphs[ch-' ']
+		gx := x + i*(glyphWidth+1)*scale
+		for col := 0; col < glyphWidth; col++ {
+			for row := 0; row < glyphHeight; row++ {
+				if glyph[col]&(1<<row) == 0 {
+					continue
+				}
+				for dy := 0; dy < scale; dy++ {
+					for dx := 0; dx < scale; dx++ {
+						img.SetRGBA(gx+col*scale+dx, y+row*scale+dy, c)
+					}
+				}
+			}
+		}
+	}
+}
+
+// drawCover scales src to cover the rectangle at x, y, cropping what
+// overflows. Nearest neighbor keeps pixel art crisp.
+func drawCover(img *image.RGBA, src image.Image, x, y, w, h int) {
+	b := src.Bounds()
+	if b.Dx() == 0 || b.Dy() == 0 {
+		return
+	}
+	// Scale so the shorter side fits, then center the crop
+	scale := max(float64(w)/float64(b.Dx()), float64(h)/float64(b.Dy()))
+	offX := (float64(b.Dx())*scale - float64(w)) / 2
+	offY := (float64(b.Dy())*scale - float64(h)) / 2
+	for py := 0; py < h; py++ {
+		sy := b.Min.Y + int((float64(py)+offY)/scale)
+		for px := 0; px < w; px++ {
+			sx := b.Min.X + int((float64(px)+offX)/scale)
+			img.Set(x+px, y+py, src.At(min(sx, b.Max.X-1), min(sy, b.Max.Y-1)))
+		}
+	}
+}
+
+func lerp(a, b uint8, t float64) uint8 {
+	return uint8(float64(a) + (float64(b)-float64(a))*t)
+}
+
+// Render draws the card.
+func Render(card Card) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, Width, Height))
+	for y := 0; y < Height; y++ {
+		t := float64(y) / float64(Height-1)
+		c := color.RGBA{
+			lerp(backgroundTop.R, backgroundBottom.R, t),
+			lerp(backgroundTop.G, backgroundBottom.G, t),
+			lerp(backgroundTop.B, backgroundBottom.B, t),
+			0xff,
+		}
+		for x := 0; x < Width; x++ {
+			img.SetRGBA(x, y, c)
+		}
+	}
+
+	textWidth := Width - 2*margin
+	if card.Screenshot != nil {
+		drawCover(img, card.Screenshot, shotX, shotY, shotW, shotH)
+		textWidth = shotX - 2*margin
+	}
+
+	// The largest title size that fits, down to one that truncates
+	title := printable(card.Title)
+	var lines []string
+	scale := 0
+	for _, s := range []int{8, 7, 6, 5, 4} {
+		var fits bool
+		lines, fits = wrap(title, textWidth/((glyphWidth+1)*s), titleLines)
+		scale = s
+		if fits {
+			break
+		}
+	}
+	y := margin + 30
+	for _, line := range lines {
+		drawText(img, margin, y, scale, titleColor, line)
+		y += (glyphHeight + 3) * scale
+	}
+
+	if creator := printable(card.Creator); creator != "" {
+		const creatorScale = 3
+		byline, _ := wrap("by "+creator, textWidth/((glyphWidth+1)*creatorScale), 1)
+		drawText(img, margin, y+20, creatorScale, creatorColor, strings.Join(byline, ""))
+	}
+
+	const footerScale = 3
+	drawText(img, margin, Height-margin-glyphHeight*footerScale, footerScale, footerColor, "Play on Shiba")
+	return img
+}
+
+// Encode renders the card as a PNG.
+func Encode(w io.Writer, card Card) error {
+	enc := png.Encoder{CompressionLevel: png.BestCompression}
+	return enc.Encode(w, Render(card))
+}
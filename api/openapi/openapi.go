@@ -0,0 +1,201 @@
+// Package openapi builds the OpenAPI 3 document the API serves about itself.
+// Request and response schemas are generated from the Go types handlers bind
+// and write, so the document follows the code instead of being kept in sync
+// by hand.
+package openapi
+
+import (
+	"reflect"
+	"strings"
+
+	"shiba-api/schema"
+)
+
+// Security schemes, for Document.Add
+const (
+	// AuthUser is a user's API, session or creator token as a bearer token
+	AuthUser = "userToken"
+	// AuthAdmin is the ADMIN_TOKEN as a bearer token
+	AuthAdmin = "adminToken"
+)
+
+type Document struct {
+	OpenAPI    string                           `json:"openapi"`
+	Info       Info                             `json:"info"`
+	Servers    []Server                         `json:"servers"`
+	Paths      map[string]map[string]*Operation `json:"paths"`
+	Components Components                       `json:"components"`
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+type Server struct {
+	URL         string `json:"url"`
+	Description string `json:"description,omitempty"`
+}
+
+type Components struct {
+	Schemas         map[string]any            `json:"schemas"`
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes"`
+}
+
+type SecurityScheme struct {
+	Type        string `json:"type"`
+	Scheme      string `json:"scheme"`
+	Description string `json:"description,omitempty"`
+}
+
+type Operation struct {
+	OperationID string                `json:"operationId"`
+	Summary     string                `json:"summary"`
+	Description string                `json:"description,omitempty"`
+	Tags        []string              `json:"tags,omitempty"`
+	Parameters  []Parameter           `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[int]Response      `json:"responses"`
+	Security    []map[string][]string `json:"security,omitempty"`
+	// Servers overrides the document's, for unversioned routes
+	Servers []Server `json:"servers,omitempty"`
+}
+
+type Parameter struct {
+	Name        string         `json:"name"`
+	In          string         `json:"in"`
+	Description string         `json:"description,omitempty"`
+	Required    bool           `json:"required,omitempty"`
+	Schema      map[string]any `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type MediaType struct {
+	Schema map[string]any `json:"schema"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+// New starts a document served under the given base paths.
+func New(info Info, servers ...Server) *Document {
+	return &Document{
+		OpenAPI: "3.0.3",
+		Info:    info,
+		Servers: servers,
+		Paths:   map[string]map[string]*Operation{},
+		Components: Components{
+			Schemas: map[string]any{},
+			SecuritySchemes: map[string]SecurityScheme{
+				AuthUser:  {Type: "http", Scheme: "bearer", Description: "A user's API token, a session token from POST /me/sessions, or a creator token from POST /me/tokens on the routes of its scopes"},
+				AuthAdmin: {Type: "http", Scheme: "bearer", Description: "The server's ADMIN_TOKEN"},
+			},
+		},
+	}
+}
+
+// Add documents an operation. auth lists the security schemes accepted,
+// any one of them being enough; none means the operation is public.
+func (d *Document) Add(method, path string, op Operation, auth ...string) {
+	for _, scheme := range auth {
+		op.Security = append(op.Security, map[string][]string{scheme: {}})
+	}
+	if op.Responses == nil {
+		op.Responses = map[int]Response{}
+	}
+	if d.Paths[path] == nil {
+		d.Paths[path] = map[string]*Operation{}
+	}
+	d.Paths[path][strings.ToLower(method)] = &op
+}
+
+// Define adds a named schema to the components, for Ref.
+func (d *Document) Define(name string, s map[string]any) {
+	d.Components.Schemas[name] = s
+}
+
+// Ref points at a schema added with Define.
+func Ref(name string) map[string]any {
+	return map[string]any{"$ref": "#/components/schemas/" + name}
+}
+
+// SchemaOf is the schema of the JSON encoding of v's type.
+func SchemaOf(v any) map[string]any {
+	return schema.Describe(reflect.TypeOf(v), "json")
+}
+
+// PathParam documents a path parameter.
+func PathParam(name, description string) Parameter {
+	return Parameter{Name: name, In: "path", Description: description, Required: true, Schema: map[string]any{"type": "string"}}
+}
+
+// QueryParams documents the `query` tagged fields of the struct v.
+func QueryParams(v any) []Parameter {
+	var params []Parameter
+	for _, f := range schema.Fields(reflect.TypeOf(v), "query") {
+		params = append(params, Parameter{Name: f.Name, In: "query", Required: f.Required, Schema: f.Schema})
+	}
+	return params
+}
+
+// JSONBody documents a JSON request body of v's type.
+func JSONBody(v any) *RequestBody {
+	return &RequestBody{Required: true, Content: map[string]MediaType{
+		"application/json": {Schema: SchemaOf(v)},
+	}}
+}
+
+// FilePart is a file part of a multipart body.
+type FilePart struct {
+	Name        string
+	Description string
+	Required    bool
+	// Repeat is how many times the part may be sent, 0 for once
+	Repeat int
+}
+
+// MultipartBody documents a multipart/form-data body: the `form` tagged
+// fields of the struct form plus file parts.
+func MultipartBody(form any, files ...FilePart) *RequestBody {
+	s := schema.Describe(reflect.TypeOf(form), "form")
+	properties := s["properties"].(map[string]any)
+	required, _ := s["required"].([]string)
+	for _, f := range files {
+		file := map[string]any{"type": "string", "format": "binary"}
+		if f.Repeat > 0 {
+			file = map[string]any{"type": "array", "items": file, "maxItems": f.Repeat}
+		}
+		file["description"] = f.Description
+		properties[f.Name] = file
+		if f.Required {
+			required = append(required, f.Name)
+		}
+	}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return &RequestBody{Required: true, Content: map[string]MediaType{
+		"multipart/form-data": {Schema: s},
+	}}
+}
+
+// JSON documents a JSON response with the given schema.
+func JSON(description string, s map[string]any) Response {
+	return Response{Description: description, Content: map[string]MediaType{
+		"application/json": {Schema: s},
+	}}
+}
+
+// Text documents a plain text response, which is how most errors are sent.
+func Text(description string) Response {
+	return Response{Description: description, Content: map[string]MediaType{
+		"text/plain": {Schema: map[string]any{"type": "string"}},
+	}}
+}
@@ -0,0 +1,83 @@
+// Package playpolicy is how games are served and embedded on Shiba: the
+// Content-Security-Policy of build files, the MIME types they are served
+// with, and the headers and iframe of the site page playing them. The API
+// serves builds with it and `shiba dev` previews them with it, so a build
+// that works locally works on Shiba.
+package playpolicy
+
+import (
+	"fmt"
+	"html"
+	"mime"
+	"strings"
+)
+
+// mimeTypes are set over the system's, which lack or disagree on the
+// extensions engines export
+var mimeTypes = map[string]string{
+	".js":   "application/javascript",
+	".mjs":  "application/javascript",
+	".wasm": "application/wasm",
+	".json": "application/json",
+	".pck":  "application/octet-stream", // Godot packs
+	".ogg":  "audio/ogg",
+	".png":  "image/png",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+}
+
+// RegisterMIMETypes makes the mime package, and so http.ServeFile, use the
+// types builds are served with.
+func RegisterMIMETypes() {
+	for ext, typ := range mimeTypes {
+		mime.AddExtensionType(ext, typ)
+	}
+}
+
+// CSP is the Content-Security-Policy of a build's pages and files. Engines
+// need inline scripts, eval for wasm and blob: workers, but network access
+// is limited to 'self' and connect, and embedding to 'self' and ancestors.
+func CSP(connect, ancestors []string) string {
+	return strings.Join([]string{
+		"default-src 'self' 'unsafe-inline' 'unsafe-eval' 'wasm-unsafe-eval' blob: data:",
+		"connect-src " + strings.Join(append([]string{"'self'", "blob:", "data:"}, connect...), " "),
+		"frame-ancestors " + strings.Join(append([]string{"'self'"}, ancestors...), " "),
+		"base-uri 'self'",
+		"form-action 'self'",
+	}, "; ")
+}
+
+// The site plays games in an iframe with these sandbox and allow attributes
+// (site/components/utils/playGameComponent.js)
+const (
+	Sandbox = "allow-scripts allow-same-origin allow-downloads allow-forms"
+	Allow   = "autoplay; fullscreen; cross-origin-isolated"
+)
+
+// EmbedderHeaders are sent with the site pages embedding games, making them
+// cross-origin isolated so engines can use SharedArrayBuffer
+// (site/middleware.js).
+var EmbedderHeaders = map[string]string{
+	"Cross-Origin-Embedder-Policy": "credentialless",
+	"Cross-Origin-Opener-Policy":   "same-origin",
+	"Cross-Origin-Resource-Policy": "cross-origin",
+}
+
+const wrapperPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>%[1]s</title>
+<style>html, body { margin: 0; height: 100%%; background: #111; } iframe { display: block; width: 100%%; height: 100%%; border: 0; }</style>
+</head>
+<body>
+<iframe src="%[2]s" title="%[1]s" sandbox="%[3]s" allow="%[4]s" credentialless></iframe>
+</body>
+</html>
+`
+
+// Wrapper is a page embedding the game at src the way the site does. Serve
+// it with EmbedderHeaders.
+func Wrapper(title, src string) []byte {
+	return fmt.Appendf(nil, wrapperPage, html.EscapeString(title), html.EscapeString(src), Sandbox, Allow)
+}
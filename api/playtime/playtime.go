@@ -0,0 +1,247 @@
+// Package playtime tracks play sessions reported by players' browsers and
+// decides how much of their playtime to credit. Every heartbeat must carry the
+// nonce handed out with the previous one, so heartbeats can't be replayed or
+// sent from scripts that never loaded the game, and sessions whose heartbeats
+// look automated are flagged instead of credited.
+package playtime
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// Interval is how often players send heartbeats.
+	Interval = 30 * time.Second
+	// A heartbeat credits the time since the previous one, at most maxCredit,
+	// so a throttled background tab doesn't earn its sleep
+	maxCredit = 2 * Interval
+	// Heartbeats sooner than minGap after the previous one are a strike;
+	// maxStrikes of them flag the session, as do maxStrikes focused
+	// heartbeats in a row overlapping another session's
+	minGap     = Interval / 2
+	maxStrikes = 3
+	// Sessions end after idleTimeout without heartbeats
+	idleTimeout = 3 * Interval
+	// Nobody plays one game for longer without a break
+	maxCredited = 8 * time.Hour
+	// MaxSessions is how many sessions a player may have open at once.
+	MaxSessions = 3
+)
+
+// Reasons a session is flagged
+const (
+	FlagBadNonce        = "bad_nonce"
+	FlagTooFast         = "too_fast"
+	FlagConcurrentFocus = "concurrent_focus"
+	FlagTooLong         = "too_long"
+)
+
+var (
+	ErrNotFound        = errors.New("play session not found")
+	ErrBadNonce        = errors.New("wrong heartbeat nonce")
+	ErrTooManySessions = errors.New("too many open play sessions")
+)
+
+// Session is one player playing one game, from the first heartbeat until
+// they leave.
+type Session struct {
+	ID     string `json:"id"`
+	UserID string `json:"userId"`
+	GameID string `json:"gameId"`
+	// ProjectID is what the playtime counts towards
+	ProjectID  string    `json:"projectId"`
+	StartedAt  time.Time `json:"startedAt"`
+	LastBeatAt time.Time `json:"lastBeatAt"`
+	EndedAt    time.Time `json:"endedAt"`
+	Heartbeats int       `json:"heartbeats"`
+	// Seconds played focused and visible, credited when the session ends
+	// unless it is flagged
+	CreditedSeconds int64    `json:"creditedSeconds"`
+	Flags           []string `json:"flags,omitempty"`
+	// Credits holds CreditedSeconds per UTC minute, for the stats rollups
+	Credits map[time.Time]int64 `json:"-"`
+
+	nonce, prevNonce string
+	strikes          int
+	// Heartbeats in a row focused while another session was
+	overlaps  int
+	focusedAt time.Time
+}
+
+// Flagged reports whether the session's playtime is withheld.
+func (s *Session) Flagged() bool { return len(s.Flags) > 0 }
+
+func (s *Session) flag(reason string) {
+	for _, f := range s.Flags {
+		if f == reason {
+			return
+		}
+	}
+	s.Flags = append(s.Flags, reason)
+}
+
+func (s *Session) copy() Session {
+	c := *s
+	c.Flags = append([]string(nil), s.Flags...)
+	c.Credits = make(map[time.Time]int64, len(s.Credits))
+	for k, v := range s.Credits {
+		c.Credits[k] = v
+	}
+	return c
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Tracker keeps the open play sessions in memory. Sessions open when the
+// server restarts are lost, playtime included.
+type Tracker struct {
+	mu       sync.Mutex
+	sessions map[string]*Session
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{sessions: map[string]*Session{}}
+}
+
+// Start opens a session and returns it with the nonce of its first
+// heartbeat.
+func (t *Tracker) Start(userID, gameID, projectID string, now time.Time) (Session, string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	open := 0
+	for _, s := range t.sessions {
+		if s.UserID == userID {
+			open++
+		}
+	}
+	if open >= MaxSessions {
+		return Session{}, "", ErrTooManySessions
+	}
+	s := &Session{
+		ID:         newID(),
+		UserID:     userID,
+		GameID:     gameID,
+		ProjectID:  projectID,
+		StartedAt:  now,
+		LastBeatAt: now,
+		Credits:    map[time.Time]int64{},
+		nonce:      newID(),
+	}
+	t.sessions[s.ID] = s
+	return s.copy(), s.nonce, nil
+}
+
+// Beat records a heartbeat of a session and returns the nonce of the next.
+// Resending the previous heartbeat, e.g. after its response was lost, gets
+// the same next nonce and credits nothing; any other wrong nonce flags the
+// session.
+func (t *Tracker) Beat(id, userID, nonce string, focused, visible bool, now time.Time) (Session, string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s, ok := t.sessions[id]
+	if !ok || s.UserID != userID {
+		return Session{}, "", ErrNotFound
+	}
+	if s.prevNonce != "" && equal(nonce, s.prevNonce) {
+		return s.copy(), s.nonce, nil
+	}
+	if !equal(nonce, s.nonce) {
+		s.flag(FlagBadNonce)
+		return s.copy(), "", ErrBadNonce
+	}
+
+	gap := now.Sub(s.LastBeatAt)
+	if s.Heartbeats > 0 && gap < minGap {
+		s.strikes++
+		if s.strikes >= maxStrikes {
+			s.flag(FlagTooFast)
+		}
+	}
+	if focused && visible {
+		// A browser focuses one window at a time; a player focused on two
+		// games at once for minutes is running them unattended
+		overlapping := false
+		for _, other := range t.sessions {
+			if other != s && other.UserID == userID && now.Sub(other.focusedAt) < Interval {
+				overlapping = true
+				if s.overlaps+1 >= maxStrikes {
+					other.flag(FlagConcurrentFocus)
+				}
+			}
+		}
+		if overlapping {
+			s.overlaps++
+			if s.overlaps >= maxStrikes {
+				s.flag(FlagConcurrentFocus)
+			}
+		} else {
+			s.overlaps = 0
+		}
+		s.focusedAt = now
+		credit := min(gap, maxCredit)
+		if credit > 0 {
+			seconds := int64(credit / time.Second)
+			s.CreditedSeconds += seconds
+			s.Credits[now.UTC().Truncate(time.Minute)] += seconds
+		}
+		if time.Duration(s.CreditedSeconds)*time.Second > maxCredited {
+			s.flag(FlagTooLong)
+		}
+	}
+
+	s.Heartbeats++
+	s.LastBeatAt = now
+	s.prevNonce, s.nonce = s.nonce, newID()
+	return s.copy(), s.nonce, nil
+}
+
+// End closes a session, given the nonce of its next heartbeat, and returns
+// it for crediting.
+func (t *Tracker) End(id, userID, nonce string, now time.Time) (Session, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s, ok := t.sessions[id]
+	if !ok || s.UserID != userID {
+		return Session{}, ErrNotFound
+	}
+	if !equal(nonce, s.nonce) && (s.prevNonce == "" || !equal(nonce, s.prevNonce)) {
+		s.flag(FlagBadNonce)
+		return Session{}, ErrBadNonce
+	}
+	delete(t.sessions, id)
+	s.EndedAt = now
+	return s.copy(), nil
+}
+
+// EndIdle closes the sessions without heartbeats for a while and returns
+// them for crediting.
+func (t *Tracker) EndIdle(now time.Time) []Session {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var ended []Session
+	for id, s := range t.sessions {
+		if now.Sub(s.LastBeatAt) > idleTimeout {
+			delete(t.sessions, id)
+			s.EndedAt = s.LastBeatAt
+			ended = append(ended, s.copy())
+		}
+	}
+	return ended
+}
+
+func equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
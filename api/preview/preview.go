@@ -0,0 +1,68 @@
+// Package preview signs short-lived tokens granting access to a draft build.
+// The API and the Cloudflare Worker in front of the CDN share the signing key,
+// so the edge can check a preview cookie and serve the draft from cache
+// without asking the API.
+//
+// A token is "<expiry>.<signature>": the expiry in Unix seconds and the
+// unpadded base64url HMAC-SHA256 of "<gameId>.<expiry>" under the key.
+package preview
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// How long a minted token is valid
+	TTL = time.Hour
+	// Cookie holds the token once a preview link was opened
+	Cookie = "shiba_preview"
+	// Param carries the token in preview links
+	Param = "preview"
+)
+
+// Signer mints and checks preview tokens.
+type Signer struct {
+	key []byte
+}
+
+func NewSigner(key []byte) *Signer {
+	return &Signer{key: key}
+}
+
+func (s *Signer) sign(gameID, exp string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(gameID + "." + exp))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Mint returns a token for gameID valid until expires.
+func (s *Signer) Mint(gameID string, expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	return exp + "." + s.sign(gameID, exp)
+}
+
+// Verify checks that token was minted for gameID and hasn't expired, and
+// returns its expiry.
+func (s *Signer) Verify(gameID, token string, now time.Time) (time.Time, bool) {
+	exp, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return time.Time{}, false
+	}
+	unix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	expires := time.Unix(unix, 0)
+	if !now.Before(expires) {
+		return time.Time{}, false
+	}
+	if !hmac.Equal([]byte(sig), []byte(s.sign(gameID, exp))) {
+		return time.Time{}, false
+	}
+	return expires, true
+}
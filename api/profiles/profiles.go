@@ -0,0 +1,73 @@
+// Package profiles holds the privacy settings of creators' profiles, which
+// gather their games, awards, playtime and streaks across events, keyed by
+// user ID.
+package profiles
+
+import (
+	"slices"
+	"time"
+
+	"shiba-api/store"
+)
+
+const doc = "profiles"
+
+// Sections of a profile a user can hide
+const (
+	Games    = "games"
+	Awards   = "awards"
+	Playtime = "playtime"
+	Streak   = "streak"
+)
+
+// Sections lists every section, in the order profiles show them.
+var Sections = []string{Games, Awards, Playtime, Streak}
+
+// Settings is who can see a user's profile. Profiles are private until their
+// user makes them public: participants are often minors, so nothing about
+// them is shown to others without opting in.
+type Settings struct {
+	Public bool `json:"public"`
+	// Hidden sections are left out for everyone but the user and admins,
+	// even on a public profile
+	Hidden    []string   `json:"hidden"`
+	UpdatedAt *time.Time `json:"updatedAt,omitempty"`
+}
+
+// Shows reports whether others see section.
+func (s Settings) Shows(section string) bool {
+	return s.Public && !slices.Contains(s.Hidden, section)
+}
+
+type State struct {
+	Users map[string]Settings `json:"users"`
+}
+
+// Get returns a user's settings, private if they never set any.
+func Get(st store.Store, userID string) (Settings, error) {
+	var state State
+	err := st.Load(doc, &state)
+	s := state.Users[userID]
+	if s.Hidden == nil {
+		s.Hidden = []string{}
+	}
+	return s, err
+}
+
+// Set replaces a user's settings.
+func Set(st store.Store, userID string, s Settings) (Settings, error) {
+	now := time.Now().UTC()
+	s.UpdatedAt = &now
+	if s.Hidden == nil {
+		s.Hidden = []string{}
+	}
+	var state State
+	err := st.Update(doc, &state, func() error {
+		if state.Users == nil {
+			state.Users = map[string]Settings{}
+		}
+		state.Users[userID] = s
+		return nil
+	})
+	return s, err
+}
@@ -0,0 +1,99 @@
+package quota
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Resources with a daily allowance per token
+const (
+	Uploads  = "uploads"
+	Feedback = "feedback"
+	Reads    = "reads"
+	// Uploads without an account, counted per IP
+	DemoUploads = "demo_uploads"
+	// Leaderboard score submissions, counted per IP
+	Scores = "scores"
+	// Cloud save writes, counted per IP
+	Saves = "saves"
+	// Restores of archived builds
+	Restores = "restores"
+)
+
+type Usage struct {
+	Resource  string    `json:"resource"`
+	Limit     int       `json:"limit"`
+	Used      int       `json:"used"`
+	Remaining int       `json:"remaining"`
+	Reset     time.Time `json:"reset"`
+}
+
+// Tracker counts daily usage per key (a token hash or client IP). Counters
+// reset at midnight UTC and live in memory only. Limits are passed in on each
+// call so they can be reloaded at runtime.
+type Tracker struct {
+	mu     sync.Mutex
+	day    string
+	counts map[string]map[string]int
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{counts: map[string]map[string]int{}}
+}
+
+func nextReset(now time.Time) time.Time {
+	y, m, d := now.UTC().Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC)
+}
+
+func (t *Tracker) rollover(now time.Time) {
+	day := now.UTC().Format("2006-01-02")
+	if day != t.day {
+		t.day = day
+		t.counts = map[string]map[string]int{}
+	}
+}
+
+func (t *Tracker) usage(key, resource string, limit int, now time.Time) Usage {
+	used := t.counts[key][resource]
+	remaining := limit - used
+	if remaining < 0 {
+		remaining = 0
+	}
+	return Usage{Resource: resource, Limit: limit, Used: used, Remaining: remaining, Reset: nextReset(now)}
+}
+
+// Take consumes one unit of resource for key. It reports false, without
+// consuming anything, once the daily limit is reached.
+func (t *Tracker) Take(key, resource string, limit int, now time.Time) (Usage, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.rollover(now)
+	u := t.usage(key, resource, limit, now)
+	if u.Remaining == 0 {
+		return u, false
+	}
+	if t.counts[key] == nil {
+		t.counts[key] = map[string]int{}
+	}
+	t.counts[key][resource]++
+	return t.usage(key, resource, limit, now), true
+}
+
+// Usage reports key's usage of every resource in limits.
+func (t *Tracker) Usage(key string, limits map[string]int, now time.Time) []Usage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.rollover(now)
+	usages := make([]Usage, 0, len(limits))
+	for resource, limit := range limits {
+		usages = append(usages, t.usage(key, resource, limit, now))
+	}
+	sort.Slice(usages, func(i, j int) bool {
+		return usages[i].Resource < usages[j].Resource
+	})
+	return usages
+}
@@ -0,0 +1,109 @@
+package quota
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTrackerTake(t *testing.T) {
+	now := time.Date(2026, 10, 17, 23, 59, 0, 0, time.UTC)
+	tr := NewTracker()
+
+	for i := 1; i <= 3; i++ {
+		u, ok := tr.Take("user:recAda", Uploads, 3, now)
+		if !ok {
+			t.Fatalf("take %d refused, want it allowed", i)
+		}
+		if u.Used != i || u.Remaining != 3-i {
+			t.Errorf("take %d: used %d, remaining %d", i, u.Used, u.Remaining)
+		}
+	}
+	u, ok := tr.Take("user:recAda", Uploads, 3, now)
+	if ok {
+		t.Fatal("take over the limit allowed")
+	}
+	if u.Used != 3 || u.Remaining != 0 {
+		t.Errorf("refused take: used %d, remaining %d, want nothing consumed", u.Used, u.Remaining)
+	}
+	if want := time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC); !u.Reset.Equal(want) {
+		t.Errorf("reset = %v, want %v", u.Reset, want)
+	}
+
+	// Other keys and resources have their own allowance
+	if _, ok := tr.Take("ip:203.0.113.7", Uploads, 3, now); !ok {
+		t.Error("another key was refused")
+	}
+	if _, ok := tr.Take("user:recAda", Reads, 3, now); !ok {
+		t.Error("another resource was refused")
+	}
+
+	// Counters reset at midnight UTC
+	if u, ok := tr.Take("user:recAda", Uploads, 3, now.Add(time.Minute)); !ok || u.Used != 1 {
+		t.Errorf("first take of the next day: ok %v, used %d", ok, u.Used)
+	}
+}
+
+func TestTrackerLoweredLimit(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	tr := NewTracker()
+	for range 5 {
+		tr.Take("user:recAda", Uploads, 10, now)
+	}
+	// Limits are reloadable; lowering one below what was used refuses more
+	u, ok := tr.Take("user:recAda", Uploads, 2, now)
+	if ok {
+		t.Fatal("take over a lowered limit allowed")
+	}
+	if u.Remaining != 0 {
+		t.Errorf("remaining = %d, want 0", u.Remaining)
+	}
+}
+
+func TestTrackerUsage(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	tr := NewTracker()
+	tr.Take("user:recAda", Uploads, 5, now)
+	tr.Take("user:recAda", Uploads, 5, now)
+
+	usages := tr.Usage("user:recAda", map[string]int{Uploads: 5, Feedback: 20, Reads: 1000}, now)
+	want := []struct {
+		resource string
+		used     int
+	}{{Feedback, 0}, {Reads, 0}, {Uploads, 2}}
+	if len(usages) != len(want) {
+		t.Fatalf("got %d usages, want %d", len(usages), len(want))
+	}
+	for i, w := range want {
+		if usages[i].Resource != w.resource || usages[i].Used != w.used {
+			t.Errorf("usage %d = %s used %d, want %s used %d", i, usages[i].Resource, usages[i].Used, w.resource, w.used)
+		}
+	}
+}
+
+func TestSlots(t *testing.T) {
+	s := NewSlots()
+	first, ok := s.Acquire("user:recAda", 2)
+	if !ok {
+		t.Fatal("first slot refused")
+	}
+	second, ok := s.Acquire("user:recAda", 2)
+	if !ok {
+		t.Fatal("second slot refused")
+	}
+	if _, ok := s.Acquire("user:recAda", 2); ok {
+		t.Fatal("slot over the limit given")
+	}
+	if _, ok := s.Acquire("ip:203.0.113.7", 2); !ok {
+		t.Error("another key was refused")
+	}
+
+	first()
+	first() // releasing twice gives back one slot
+	if n := s.InFlight("user:recAda"); n != 1 {
+		t.Errorf("in flight = %d, want 1", n)
+	}
+	if _, ok := s.Acquire("user:recAda", 2); !ok {
+		t.Error("released slot not given back")
+	}
+	second()
+}
@@ -0,0 +1,46 @@
+package quota
+
+import "sync"
+
+// Slots caps how many operations each key (a token hash or client IP) may
+// have in flight at once. Unlike Tracker, a slot is given back as soon as the
+// operation finishes.
+type Slots struct {
+	mu   sync.Mutex
+	held map[string]int
+}
+
+func NewSlots() *Slots {
+	return &Slots{held: map[string]int{}}
+}
+
+// Acquire takes a slot for key if it holds fewer than limit. The returned
+// release must be called exactly once when the operation finishes; calling it
+// again is a no-op.
+func (s *Slots) Acquire(key string, limit int) (release func(), ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.held[key] >= limit {
+		return nil, false
+	}
+	s.held[key]++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if s.held[key]--; s.held[key] <= 0 {
+				delete(s.held, key)
+			}
+		})
+	}, true
+}
+
+// InFlight reports how many slots key holds.
+func (s *Slots) InFlight(key string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.held[key]
+}
@@ -0,0 +1,79 @@
+package quota
+
+// StorageUsage is what a user's builds take up against their storage cap.
+// Limit is 0 when storage isn't capped.
+type StorageUsage struct {
+	Limit     int64 `json:"limitBytes"`
+	Used      int64 `json:"usedBytes"`
+	Remaining int64 `json:"remainingBytes"`
+}
+
+// Ledger records the bytes stored for each build, by owner. Unlike Tracker
+// it never resets: a build counts until it's deleted. It's kept in the
+// store, so it's a plain value the caller loads and saves.
+type Ledger struct {
+	Users map[string]map[string]int64 `json:"users"`
+}
+
+func (l *Ledger) init() {
+	if l.Users == nil {
+		l.Users = map[string]map[string]int64{}
+	}
+}
+
+// Has reports whether buildID is recorded.
+func (l *Ledger) Has(buildID string) bool {
+	for _, builds := range l.Users {
+		if _, ok := builds[buildID]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Usage sums what user's builds take up.
+func (l *Ledger) Usage(user string, limit int64) StorageUsage {
+	var used int64
+	for _, size := range l.Users[user] {
+		used += size
+	}
+	remaining := limit - used
+	if remaining < 0 || limit == 0 {
+		remaining = 0
+	}
+	return StorageUsage{Limit: limit, Used: used, Remaining: remaining}
+}
+
+// Add records size bytes for user's build, whatever the limit.
+func (l *Ledger) Add(user, buildID string, size int64) {
+	l.init()
+	if l.Users[user] == nil {
+		l.Users[user] = map[string]int64{}
+	}
+	l.Users[user][buildID] = size
+}
+
+// Reserve records size bytes for user's build if they fit under limit (0
+// for no limit). It reports false, without recording anything, if they
+// don't; the usage returned is the user's either way.
+func (l *Ledger) Reserve(user, buildID string, size, limit int64) (StorageUsage, bool) {
+	u := l.Usage(user, limit)
+	if limit > 0 && u.Used+size > limit {
+		return u, false
+	}
+	l.Add(user, buildID, size)
+	return l.Usage(user, limit), true
+}
+
+// Release forgets a build, giving its bytes back to its owner.
+func (l *Ledger) Release(buildID string) {
+	for user, builds := range l.Users {
+		if _, ok := builds[buildID]; ok {
+			delete(builds, buildID)
+			if len(builds) == 0 {
+				delete(l.Users, user)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,76 @@
+// Package recommend computes "players also enjoyed" recommendations from
+// co-play data: games are similar when the same players played both.
+package recommend
+
+import (
+	"math"
+	"sort"
+)
+
+// Play is one player having played one game.
+type Play struct {
+	GameID   string
+	PlayerID string
+}
+
+type Recommendation struct {
+	GameID string  `json:"gameId"`
+	Score  float64 `json:"score"`
+	// SharedPlayers is how many players played both games
+	SharedPlayers int `json:"sharedPlayers"`
+}
+
+// Compute returns up to perGame recommendations for every played game.
+// Similarity is the cosine of the games' player sets, so blockbusters don't
+// drown out everything else. Among equally similar games the less played one
+// comes first, to spread feedback across submissions.
+func Compute(plays []Play, perGame int) map[string][]Recommendation {
+	players := map[string]map[string]bool{} // game -> players
+	games := map[string]map[string]bool{}   // player -> games
+	for _, p := range plays {
+		if p.GameID == "" || p.PlayerID == "" {
+			continue
+		}
+		if players[p.GameID] == nil {
+			players[p.GameID] = map[string]bool{}
+		}
+		players[p.GameID][p.PlayerID] = true
+		if games[p.PlayerID] == nil {
+			games[p.PlayerID] = map[string]bool{}
+		}
+		games[p.PlayerID][p.GameID] = true
+	}
+
+	out := map[string][]Recommendation{}
+	for game, gamePlayers := range players {
+		shared := map[string]int{}
+		for player := range gamePlayers {
+			for other := range games[player] {
+				if other != game {
+					shared[other]++
+				}
+			}
+		}
+
+		recs := make([]Recommendation, 0, len(shared))
+		for other, n := range shared {
+			score := float64(n) / math.Sqrt(float64(len(gamePlayers))*float64(len(players[other])))
+			recs = append(recs, Recommendation{GameID: other, Score: math.Round(score*1000) / 1000, SharedPlayers: n})
+		}
+		sort.Slice(recs, func(i, j int) bool {
+			if recs[i].Score != recs[j].Score {
+				return recs[i].Score > recs[j].Score
+			}
+			pi, pj := len(players[recs[i].GameID]), len(players[recs[j].GameID])
+			if pi != pj {
+				return pi < pj
+			}
+			return recs[i].GameID < recs[j].GameID
+		})
+		if len(recs) > perGame {
+			recs = recs[:perGame]
+		}
+		out[game] = recs
+	}
+	return out
+}
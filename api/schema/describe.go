@@ -0,0 +1,131 @@
+package schema
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Field is one request field of a struct, as Describe sees it.
+type Field struct {
+	Name     string
+	Required bool
+	Schema   map[string]any
+}
+
+// Fields lists the request fields of a struct type, named by tag, with the
+// JSON Schema of each.
+func Fields(t reflect.Type, tag string) []Field {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	var fields []Field
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.Anonymous && sf.Tag.Get(tag) == "" && indirect(sf.Type).Kind() == reflect.Struct {
+			fields = append(fields, Fields(sf.Type, tag)...)
+			continue
+		}
+		name := fieldName(sf, tag)
+		if name == "" {
+			continue
+		}
+		s := Describe(sf.Type, tag)
+		required := applyRules(s, sf.Type, sf.Tag.Get("validate"))
+		fields = append(fields, Field{name, required, s})
+	}
+	return fields
+}
+
+// Describe returns the JSON Schema, as OpenAPI 3 uses it, of values of type
+// t decoded with tag ("json", "form" or "query"). Validate rules become
+// constraints, so documentation built from it can't drift from what the
+// handlers accept.
+func Describe(t reflect.Type, tag string) map[string]any {
+	t = indirect(t)
+	if t == reflect.TypeOf(time.Time{}) {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]any{"type": "integer"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "format": "byte"}
+		}
+		return map[string]any{"type": "array", "items": Describe(t.Elem(), tag)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": Describe(t.Elem(), tag)}
+	case reflect.Struct:
+		properties := map[string]any{}
+		var required []string
+		for _, f := range Fields(t, tag) {
+			properties[f.Name] = f.Schema
+			if f.Required {
+				required = append(required, f.Name)
+			}
+		}
+		s := map[string]any{"type": "object", "properties": properties}
+		if len(required) > 0 {
+			s["required"] = required
+		}
+		return s
+	}
+	// Interfaces hold anything
+	return map[string]any{}
+}
+
+// applyRules adds the constraints of validate rules to the schema of a field
+// of type t and reports whether the field is required.
+func applyRules(s map[string]any, t reflect.Type, rules string) bool {
+	if rules == "" {
+		return false
+	}
+	required := false
+	for _, rule := range strings.Split(rules, ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(rule), "=")
+		switch name {
+		case "required":
+			required = true
+		case "min", "max":
+			bound, err := strconv.ParseFloat(arg, 64)
+			if err != nil {
+				panic("schema: bad bound in rule " + rule)
+			}
+			var key string
+			switch indirect(t).Kind() {
+			case reflect.String:
+				key = name + "Length"
+			case reflect.Slice, reflect.Array:
+				key = name + "Items"
+			case reflect.Map:
+				key = name + "Properties"
+			default:
+				key = map[string]string{"min": "minimum", "max": "maximum"}[name]
+			}
+			s[key] = bound
+		case "oneof":
+			s["enum"] = strings.Fields(arg)
+		case "id":
+			s["pattern"] = "^[A-Za-z0-9_-]*$"
+		}
+	}
+	return required
+}
+
+func indirect(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t
+}
@@ -0,0 +1,328 @@
+// Package schema decodes request parameters into structs and checks them
+// against rules declared in struct tags, so every endpoint rejects bad input
+// the same way: a list of field errors rather than a one-off message.
+//
+// Fields are named by their `query`, `form` or `json` tag and checked with a
+// `validate` tag holding comma separated rules:
+//
+//	required     the value must not be empty (zero, "", nil or no elements)
+//	min=N, max=N numbers must lie within the bounds, strings, slices and
+//	             maps must have at least/at most N characters or elements
+//	oneof=a b c  strings must be one of the listed values
+//	id           strings may only hold letters, digits, - and _, so they
+//	             are safe in paths and document names
+//
+// Nested structs, and slices or maps of them, are checked too. Missing
+// optional parameters leave the field untouched, so callers set defaults
+// before decoding.
+package schema
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Where a field was read from
+const (
+	InQuery  = "query"
+	InForm   = "form"
+	InBody   = "body"
+	InHeader = "header"
+)
+
+// FieldError is a problem with one request field.
+type FieldError struct {
+	Field   string `json:"field"`
+	In      string `json:"in"`
+	Message string `json:"message"`
+}
+
+// Errors lists every problem found with a request.
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, f := range e {
+		msgs[i] = f.Field + ": " + f.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Add records a problem with a field.
+func (e *Errors) Add(field, in, format string, args ...any) {
+	*e = append(*e, FieldError{Field: field, In: in, Message: fmt.Sprintf(format, args...)})
+}
+
+// Err returns the errors, or nil when there are none.
+func (e Errors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Query decodes query parameters into the `query` tagged fields of dst, a
+// pointer to a struct, and checks the result.
+func Query(values url.Values, dst any) Errors {
+	return decode(values, dst, "query", InQuery)
+}
+
+// Form decodes form values into the `form` tagged fields of dst, a pointer to
+// a struct, and checks the result.
+func Form(values url.Values, dst any) Errors {
+	return decode(values, dst, "form", InForm)
+}
+
+// Check checks a decoded JSON body, naming fields by their `json` tag.
+func Check(v any) Errors {
+	var errs Errors
+	checkValue(reflect.ValueOf(v), "", InBody, "json", &errs)
+	return errs
+}
+
+func decode(values url.Values, dst any, tag, in string) Errors {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		panic("schema: destination must be a pointer to a struct")
+	}
+	v = v.Elem()
+	t := v.Type()
+
+	var errs Errors
+	for i := 0; i < t.NumField(); i++ {
+		name := fieldName(t.Field(i), tag)
+		if name == "" {
+			continue
+		}
+		raw, ok := values[name]
+		if !ok || len(raw) == 0 {
+			continue
+		}
+		if err := setValue(v.Field(i), raw); err != nil {
+			errs.Add(name, in, "%s", err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	checkValue(v, "", in, tag, &errs)
+	return errs
+}
+
+func setValue(f reflect.Value, raw []string) error {
+	if f.Kind() == reflect.Slice && f.Type().Elem().Kind() == reflect.String {
+		f.Set(reflect.ValueOf(append([]string(nil), raw...)).Convert(f.Type()))
+		return nil
+	}
+	if f.Kind() == reflect.Pointer {
+		p := reflect.New(f.Type().Elem())
+		if err := setValue(p.Elem(), raw); err != nil {
+			return err
+		}
+		f.Set(p)
+		return nil
+	}
+
+	s := raw[len(raw)-1]
+	if f.Type() == reflect.TypeOf(time.Time{}) {
+		at, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return fmt.Errorf("must be an RFC 3339 time")
+		}
+		f.Set(reflect.ValueOf(at))
+		return nil
+	}
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("must be true or false")
+		}
+		f.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, f.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("must be an integer")
+		}
+		f.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, f.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("must be a non-negative integer")
+		}
+		f.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, f.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("must be a number")
+		}
+		f.SetFloat(n)
+	default:
+		panic("schema: unsupported field type " + f.Type().String())
+	}
+	return nil
+}
+
+// fieldName is the request name of a field, or "" when it isn't one.
+func fieldName(f reflect.StructField, tag string) string {
+	if !f.IsExported() {
+		return ""
+	}
+	name, _, _ := strings.Cut(f.Tag.Get(tag), ",")
+	if name == "-" {
+		return ""
+	}
+	if name == "" && tag == "json" {
+		return f.Name
+	}
+	return name
+}
+
+func checkValue(v reflect.Value, path, in, tag string, errs *Errors) {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		if v.Type() == reflect.TypeOf(time.Time{}) {
+			return
+		}
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			name := fieldName(sf, tag)
+			if name == "" {
+				continue
+			}
+			field := name
+			if path != "" {
+				field = path + "." + name
+			}
+			if rules := sf.Tag.Get("validate"); rules != "" {
+				if msg := checkRules(v.Field(i), rules); msg != "" {
+					errs.Add(field, in, "%s", msg)
+					continue
+				}
+			}
+			checkValue(v.Field(i), field, in, tag, errs)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			checkValue(v.Index(i), fmt.Sprintf("%s[%d]", path, i), in, tag, errs)
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			checkValue(iter.Value(), fmt.Sprintf("%s[%v]", path, iter.Key()), in, tag, errs)
+		}
+	}
+}
+
+// checkRules returns why v breaks the rules, or "" when it doesn't.
+func checkRules(v reflect.Value, rules string) string {
+	for _, rule := range strings.Split(rules, ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(rule), "=")
+		if name == "required" {
+			if v.IsZero() || (hasLen(v) && v.Len() == 0) {
+				return "is required"
+			}
+			continue
+		}
+
+		for v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				break
+			}
+			v = v.Elem()
+		}
+		if v.Kind() == reflect.Pointer {
+			continue
+		}
+
+		switch name {
+		case "min", "max":
+			bound, err := strconv.ParseFloat(arg, 64)
+			if err != nil {
+				panic("schema: bad bound in rule " + rule)
+			}
+			if msg := checkBound(v, name, bound, arg); msg != "" {
+				return msg
+			}
+		case "oneof":
+			options := strings.Fields(arg)
+			if v.Kind() != reflect.String || v.String() == "" {
+				continue
+			}
+			found := false
+			for _, o := range options {
+				if v.String() == o {
+					found = true
+				}
+			}
+			if !found {
+				return "must be one of " + strings.Join(options, ", ")
+			}
+		case "id":
+			if v.Kind() == reflect.String && !isID(v.String()) {
+				return "may only contain letters, digits, - and _"
+			}
+		default:
+			panic("schema: unknown rule " + name)
+		}
+	}
+	return ""
+}
+
+func isID(s string) bool {
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+func hasLen(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return true
+	}
+	return false
+}
+
+func checkBound(v reflect.Value, rule string, bound float64, arg string) string {
+	var n float64
+	unit := ""
+	switch v.Kind() {
+	case reflect.String:
+		n, unit = float64(utf8.RuneCountInString(v.String())), " characters"
+	case reflect.Slice, reflect.Map, reflect.Array:
+		n, unit = float64(v.Len()), " items"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n = float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n = float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		n = v.Float()
+	default:
+		return ""
+	}
+	if rule == "min" && n < bound {
+		return "must be at least " + arg + unit
+	}
+	if rule == "max" && n > bound {
+		return "must be at most " + arg + unit
+	}
+	return ""
+}
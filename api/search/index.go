@@ -0,0 +1,277 @@
+// Package search is a small in-memory full-text index over published games,
+// with per-field weighting and typo tolerance. The gallery has a few thousand
+// games at most, so a linear scan over the term dictionary is plenty.
+package search
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+type Document struct {
+	ID          string   `json:"id"`
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	Tags        []string `json:"tags"`
+	Creator     string   `json:"creator"`
+	PlayURL     string   `json:"playUrl,omitempty"`
+	// Accessibility lists declared accessibility features, e.g. "captions"
+	Accessibility     []string `json:"accessibility"`
+	ExpectsController bool     `json:"expectsController"`
+	MobileCompatible  bool     `json:"mobileCompatible"`
+}
+
+// Filter narrows results to documents it returns true for.
+type Filter func(Document) bool
+
+type Result struct {
+	Document
+	Score float64 `json:"score"`
+}
+
+// Field weights: a title hit counts far more than a description hit
+var fieldWeights = map[string]float64{
+	"title":       4,
+	"tags":        3,
+	"creator":     2,
+	"description": 1,
+}
+
+type posting struct {
+	doc    int
+	weight float64
+}
+
+type Index struct {
+	mu       sync.RWMutex
+	docs     []Document
+	postings map[string][]posting
+}
+
+func NewIndex() *Index {
+	return &Index{postings: map[string][]posting{}}
+}
+
+func tokenize(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// Replace swaps the whole index for one built from docs.
+func (ix *Index) Replace(docs []Document) {
+	postings := map[string][]posting{}
+	for i, d := range docs {
+		weights := map[string]float64{}
+		add := func(field, text string) {
+			for _, term := range tokenize(text) {
+				weights[term] += fieldWeights[field]
+			}
+		}
+		add("title", d.Title)
+		add("description", d.Description)
+		add("tags", strings.Join(d.Tags, " "))
+		add("creator", d.Creator)
+		for term, w := range weights {
+			postings[term] = append(postings[term], posting{doc: i, weight: w})
+		}
+	}
+
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.docs = docs
+	ix.postings = postings
+}
+
+// Patch applies fn to the document with id, so metadata edits show up before
+// the next rebuild.
+func (ix *Index) Patch(id string, fn func(d *Document)) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	for i := range ix.docs {
+		if ix.docs[i].ID == id {
+			fn(&ix.docs[i])
+		}
+	}
+}
+
+// Remove drops the document with id, so deleted games stop showing up before
+// the next rebuild.
+func (ix *Index) Remove(id string) {
+	ix.mu.RLock()
+	docs := make([]Document, 0, len(ix.docs))
+	for _, d := range ix.docs {
+		if d.ID != id {
+			docs = append(docs, d)
+		}
+	}
+	ix.mu.RUnlock()
+	ix.Replace(docs)
+}
+
+func (ix *Index) Len() int {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return len(ix.docs)
+}
+
+// maxTypos allows one typo in words of 4+ letters and two in 8+.
+func maxTypos(term string) int {
+	n := len([]rune(term))
+	switch {
+	case n >= 8:
+		return 2
+	case n >= 4:
+		return 1
+	}
+	return 0
+}
+
+// Search ranks documents matching every query term. Terms match exactly, as
+// a prefix (for search-as-you-type) or within a small edit distance; inexact
+// matches score lower. Rare terms weigh more than common ones.
+func (ix *Index) Search(query string, limit int) []Result {
+	return ix.SearchFiltered(query, limit, nil)
+}
+
+// SearchFiltered is Search restricted to documents passing filter. With an
+// empty query and a filter it lists every passing document by title, for
+// browsing the gallery by facet.
+func (ix *Index) SearchFiltered(query string, limit int, filter Filter) []Result {
+	terms := tokenize(query)
+	if len(terms) == 0 && filter == nil {
+		return []Result{}
+	}
+
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	if len(terms) == 0 {
+		results := []Result{}
+		for _, d := range ix.docs {
+			if filter(d) {
+				results = append(results, Result{Document: d})
+			}
+		}
+		sort.Slice(results, func(i, j int) bool { return results[i].Title < results[j].Title })
+		if limit > 0 && len(results) > limit {
+			results = results[:limit]
+		}
+		return results
+	}
+
+	scores := map[int]float64{}
+	for i, qt := range terms {
+		termScores := map[int]float64{}
+		for term, list := range ix.postings {
+			quality := matchQuality(qt, term)
+			if quality == 0 {
+				continue
+			}
+			idf := math.Log(1 + float64(len(ix.docs))/float64(len(list)))
+			for _, p := range list {
+				s := p.weight * idf * quality
+				if s > termScores[p.doc] {
+					termScores[p.doc] = s
+				}
+			}
+		}
+
+		// Every query term has to match something in the document
+		if i == 0 {
+			scores = termScores
+			continue
+		}
+		for doc := range scores {
+			if ts, ok := termScores[doc]; ok {
+				scores[doc] += ts
+			} else {
+				delete(scores, doc)
+			}
+		}
+	}
+
+	results := make([]Result, 0, len(scores))
+	for doc, score := range scores {
+		if filter != nil && !filter(ix.docs[doc]) {
+			continue
+		}
+		results = append(results, Result{Document: ix.docs[doc], Score: math.Round(score*1000) / 1000})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Title < results[j].Title
+	})
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// matchQuality scores how well an index term matches a query term: 1 for an
+// exact match, less for prefixes and typos, 0 for no match.
+func matchQuality(query, term string) float64 {
+	if query == term {
+		return 1
+	}
+	if len(query) >= 2 && strings.HasPrefix(term, query) {
+		return 0.8
+	}
+	allowed := maxTypos(query)
+	if allowed == 0 {
+		return 0
+	}
+	if d := editDistance(query, term, allowed); d <= allowed {
+		return 0.6 / float64(d)
+	}
+	return 0
+}
+
+// editDistance returns the optimal string alignment distance between a and b
+// (Levenshtein plus adjacent transpositions, the most common typo), or max+1
+// as soon as it is certain to exceed max.
+func editDistance(a, b string, max int) int {
+	ra, rb := []rune(a), []rune(b)
+	if abs(len(ra)-len(rb)) > max {
+		return max + 1
+	}
+
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		rowMin := curr[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				curr[j] = min(curr[j], prev2[j-2]+1)
+			}
+			rowMin = min(rowMin, curr[j])
+		}
+		if rowMin > max {
+			return max + 1
+		}
+		prev2, prev, curr = prev, curr, prev2
+	}
+	return prev[len(rb)]
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,312 @@
+package stats
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"shiba-api/store"
+)
+
+// Counts is what happened to a game over some period.
+type Counts struct {
+	PlaytimeSeconds int64 `json:"playtimeSeconds"`
+	Plays           int   `json:"plays"`
+	Feedback        int   `json:"feedback"`
+	Crashes         int   `json:"crashes"`
+	// Anonymous page sessions from play beacons, and the time they spent on
+	// the game, counted apart from the verified plays and playtime above
+	Sessions       int   `json:"sessions"`
+	SessionSeconds int64 `json:"sessionSeconds"`
+}
+
+func (c *Counts) add(o Counts) {
+	c.PlaytimeSeconds += o.PlaytimeSeconds
+	c.Plays += o.Plays
+	c.Feedback += o.Feedback
+	c.Crashes += o.Crashes
+	c.Sessions += o.Sessions
+	c.SessionSeconds += o.SessionSeconds
+}
+
+// Rollup documents. Hourly rollups get one document per UTC day and daily
+// rollups one per month, so no single write grows with the event's length.
+func hourlyDoc(day string) string  { return "game-stats-hourly-" + day }
+func dailyDoc(month string) string { return "game-stats-daily-" + month }
+
+// hourlyIndexDoc lists the days that have an hourly rollup, so retention can
+// find them without listing the store.
+const hourlyIndexDoc = "game-stats-hourly-days"
+
+type hourlyIndex struct {
+	Days map[string]bool `json:"days"`
+}
+
+// Rollup maps game ID -> bucket (hour "15" or day "2026-10-17") -> counts.
+type Rollup struct {
+	Games map[string]map[string]Counts `json:"games"`
+}
+
+func (r *Rollup) add(gameID, bucket string, c Counts) {
+	if r.Games == nil {
+		r.Games = map[string]map[string]Counts{}
+	}
+	if r.Games[gameID] == nil {
+		r.Games[gameID] = map[string]Counts{}
+	}
+	cur := r.Games[gameID][bucket]
+	cur.add(c)
+	r.Games[gameID][bucket] = cur
+}
+
+type minuteKey struct {
+	gameID string
+	minute time.Time
+}
+
+// Aggregator buffers heartbeats in memory per game and minute and folds them
+// into the hourly, daily and total rollups on Flush. Recording a heartbeat is
+// a map update instead of a store write, so ingestion stays cheap however
+// many players are online. Heartbeats not yet flushed are lost on a crash,
+// so the server flushes before it exits.
+type Aggregator struct {
+	store   store.Store
+	mu      sync.Mutex
+	pending map[minuteKey]Counts
+	// totals are per-game counts already in the rollups whose totals write
+	// failed, retried on the next flush on their own
+	totals map[string]Counts
+}
+
+func NewAggregator(st store.Store) *Aggregator {
+	return &Aggregator{store: st, pending: map[minuteKey]Counts{}, totals: map[string]Counts{}}
+}
+
+// Add records counts for a game at the given time.
+func (a *Aggregator) Add(gameID string, at time.Time, c Counts) {
+	key := minuteKey{gameID, at.UTC().Truncate(time.Minute)}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	cur := a.pending[key]
+	cur.add(c)
+	a.pending[key] = cur
+}
+
+// Flush writes the buffered minutes into the rollups. On failure the
+// unwritten minutes are put back and retried on the next flush.
+func (a *Aggregator) Flush() error {
+	a.mu.Lock()
+	pending, totals := a.pending, a.totals
+	a.pending, a.totals = map[minuteKey]Counts{}, map[string]Counts{}
+	a.mu.Unlock()
+	if len(pending) == 0 && len(totals) == 0 {
+		return nil
+	}
+	if len(pending) > 0 {
+		if err := a.writeRollups(pending); err != nil {
+			a.requeue(pending)
+			a.requeueTotals(totals)
+			return err
+		}
+		for k, c := range pending {
+			cur := totals[k.gameID]
+			cur.add(c)
+			totals[k.gameID] = cur
+		}
+	}
+
+	// Totals last: they are what the exporter reads, and a retry after a
+	// partial failure would otherwise count the same minutes twice there.
+	// Failed totals are retried without rewriting the rollups.
+	var state State
+	err := a.store.Update(doc, &state, func() error {
+		if state.Games == nil {
+			state.Games = map[string]Game{}
+		}
+		for gameID, c := range totals {
+			g := state.Games[gameID]
+			g.PlaytimeSeconds += c.PlaytimeSeconds
+			g.Plays += c.Plays
+			g.Feedback += c.Feedback
+			g.Crashes += c.Crashes
+			g.Sessions += c.Sessions
+			g.SessionSeconds += c.SessionSeconds
+			state.Games[gameID] = g
+		}
+		return nil
+	})
+	if err != nil {
+		a.requeueTotals(totals)
+		return fmt.Errorf("failed to write totals: %v", err)
+	}
+	return nil
+}
+
+// writeRollups adds buffered minutes to the hourly and daily rollups.
+// Rollup documents written before a failure may double count on retry.
+func (a *Aggregator) writeRollups(pending map[minuteKey]Counts) error {
+
+	byDay := map[string]map[minuteKey]Counts{}
+	byMonth := map[string]map[minuteKey]Counts{}
+	for k, c := range pending {
+		day, month := k.minute.Format("2006-01-02"), k.minute.Format("2006-01")
+		if byDay[day] == nil {
+			byDay[day] = map[minuteKey]Counts{}
+		}
+		if byMonth[month] == nil {
+			byMonth[month] = map[minuteKey]Counts{}
+		}
+		byDay[day][k] = c
+		byMonth[month][k] = c
+	}
+
+	var index hourlyIndex
+	err := a.store.Update(hourlyIndexDoc, &index, func() error {
+		if index.Days == nil {
+			index.Days = map[string]bool{}
+		}
+		for day := range byDay {
+			index.Days[day] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to index rollups: %v", err)
+	}
+
+	var failed error
+	for day, minutes := range byDay {
+		var r Rollup
+		err := a.store.Update(hourlyDoc(day), &r, func() error {
+			for k, c := range minutes {
+				r.add(k.gameID, k.minute.Format("15"), c)
+			}
+			return nil
+		})
+		if err != nil {
+			failed = err
+		}
+	}
+	for month, minutes := range byMonth {
+		var r Rollup
+		err := a.store.Update(dailyDoc(month), &r, func() error {
+			for k, c := range minutes {
+				r.add(k.gameID, k.minute.Format("2006-01-02"), c)
+			}
+			return nil
+		})
+		if err != nil {
+			failed = err
+		}
+	}
+	if failed != nil {
+		return fmt.Errorf("failed to write rollups: %v", failed)
+	}
+	return nil
+}
+
+func (a *Aggregator) requeue(pending map[minuteKey]Counts) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for k, c := range pending {
+		cur := a.pending[k]
+		cur.add(c)
+		a.pending[k] = cur
+	}
+}
+
+func (a *Aggregator) requeueTotals(totals map[string]Counts) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for gameID, c := range totals {
+		cur := a.totals[gameID]
+		cur.add(c)
+		a.totals[gameID] = cur
+	}
+}
+
+// Point is one bucket of a game's time series.
+type Point struct {
+	Start time.Time `json:"start"`
+	Counts
+}
+
+// Series returns a game's counts per hour or per day ("hour" or "day") from
+// from up to to, oldest first, skipping empty buckets.
+func Series(st store.Store, gameID, granularity string, from, to time.Time) ([]Point, error) {
+	from, to = from.UTC(), to.UTC()
+	points := []Point{}
+
+	switch granularity {
+	case "hour":
+		for day := from.Truncate(24 * time.Hour); !day.After(to); day = day.AddDate(0, 0, 1) {
+			var r Rollup
+			if err := st.Load(hourlyDoc(day.Format("2006-01-02")), &r); err != nil {
+				return nil, err
+			}
+			for bucket, c := range r.Games[gameID] {
+				start, err := time.Parse("2006-01-02 15", day.Format("2006-01-02")+" "+bucket)
+				if err != nil || start.Before(from.Truncate(time.Hour)) || start.After(to) {
+					continue
+				}
+				points = append(points, Point{start, c})
+			}
+		}
+	case "day":
+		start := time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, time.UTC)
+		for month := start; !month.After(to); month = month.AddDate(0, 1, 0) {
+			var r Rollup
+			if err := st.Load(dailyDoc(month.Format("2006-01")), &r); err != nil {
+				return nil, err
+			}
+			for bucket, c := range r.Games[gameID] {
+				start, err := time.Parse("2006-01-02", bucket)
+				if err != nil || start.Before(from.Truncate(24*time.Hour)) || start.After(to) {
+					continue
+				}
+				points = append(points, Point{start, c})
+			}
+		}
+	default:
+		return nil, fmt.Errorf("granularity must be hour or day")
+	}
+
+	sort.Slice(points, func(i, j int) bool { return points[i].Start.Before(points[j].Start) })
+	return points, nil
+}
+
+// PurgeHourly deletes the hourly rollups of days before before. Their counts
+// stay in the daily rollups and totals. With dryRun nothing is deleted. It
+// returns the purged days, oldest first.
+func PurgeHourly(st store.Store, before time.Time, dryRun bool) ([]string, error) {
+	var index hourlyIndex
+	if err := st.Load(hourlyIndexDoc, &index); err != nil {
+		return nil, err
+	}
+
+	cutoff := before.UTC().Format("2006-01-02")
+	days := []string{}
+	for day := range index.Days {
+		if day < cutoff {
+			days = append(days, day)
+		}
+	}
+	sort.Strings(days)
+	if dryRun || len(days) == 0 {
+		return days, nil
+	}
+
+	for _, day := range days {
+		if err := st.Delete(hourlyDoc(day)); err != nil {
+			return nil, err
+		}
+	}
+	err := st.Update(hourlyIndexDoc, &index, func() error {
+		for _, day := range days {
+			delete(index.Days, day)
+		}
+		return nil
+	})
+	return days, err
+}
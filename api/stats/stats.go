@@ -0,0 +1,70 @@
+package stats
+
+import (
+	"time"
+
+	"shiba-api/store"
+)
+
+const doc = "game-stats"
+
+// Ship statuses exported to organizers
+const (
+	NotShipped   = "Not shipped"
+	Playable     = "Playable"
+	Downloadable = "Downloadable"
+)
+
+// Game holds the aggregates kept per project (the Airtable Games record ID
+// for projects uploaded from the site).
+type Game struct {
+	PlaytimeSeconds int64     `json:"playtimeSeconds"`
+	Plays           int       `json:"plays"`
+	Feedback        int       `json:"feedback"`
+	Crashes         int       `json:"crashes"`
+	Sessions        int       `json:"sessions"`
+	SessionSeconds  int64     `json:"sessionSeconds"`
+	Versions        int       `json:"versions"`
+	ShipStatus      string    `json:"shipStatus,omitempty"`
+	LastShippedAt   time.Time `json:"lastShippedAt,omitempty"`
+}
+
+type State struct {
+	Games map[string]Game `json:"games"`
+}
+
+func update(st store.Store, gameID string, fn func(g *Game)) error {
+	var state State
+	return st.Update(doc, &state, func() error {
+		if state.Games == nil {
+			state.Games = map[string]Game{}
+		}
+		g := state.Games[gameID]
+		fn(&g)
+		state.Games[gameID] = g
+		return nil
+	})
+}
+
+// Shipped records a new version of a game. listingType is the build's listing
+// type, "downloadable" for native builds and empty for web games.
+func Shipped(st store.Store, gameID, listingType string, at time.Time) error {
+	return update(st, gameID, func(g *Game) {
+		g.Versions++
+		g.LastShippedAt = at
+		g.ShipStatus = Playable
+		if listingType == "downloadable" {
+			g.ShipStatus = Downloadable
+		}
+	})
+}
+
+// Load returns the aggregates of every game.
+func Load(st store.Store) (State, error) {
+	var state State
+	err := st.Load(doc, &state)
+	if state.Games == nil {
+		state.Games = map[string]Game{}
+	}
+	return state, err
+}
@@ -0,0 +1,85 @@
+package stepup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+func postJSON(ctx context.Context, url, token string, payload any, result any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s answered %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// Loops emails codes with a Loops transactional template, the same one the
+// site's login OTP uses. The template gets the code as otp, OTP and code.
+type Loops struct {
+	Key        string
+	TemplateID string
+}
+
+func (l Loops) Send(ctx context.Context, email, code string) error {
+	var result struct {
+		Success *bool  `json:"success"`
+		Error   string `json:"error"`
+	}
+	err := postJSON(ctx, "https://app.loops.so/api/v1/transactional", l.Key, map[string]any{
+		"transactionalId": l.TemplateID,
+		"email":           email,
+		"dataVariables":   map[string]string{"otp": code, "OTP": code, "code": code},
+	}, &result)
+	if err != nil {
+		return err
+	}
+	if result.Success != nil && !*result.Success {
+		return fmt.Errorf("loops: %s", result.Error)
+	}
+	return nil
+}
+
+// Slack sends codes as a direct message from a bot. The bot needs the
+// chat:write scope.
+type Slack struct {
+	Token string
+}
+
+func (s Slack) Send(ctx context.Context, slackID, code string) error {
+	var result struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	err := postJSON(ctx, "https://slack.com/api/chat.postMessage", s.Token, map[string]string{
+		"channel": slackID,
+		"text":    fmt.Sprintf("Your Shiba confirmation code is *%s*. It expires in %d minutes. If you didn't ask for it, rotate your token.", code, int(CodeTTL.Minutes())),
+	}, &result)
+	if err != nil {
+		return err
+	}
+	if !result.OK {
+		return fmt.Errorf("slack: %s", result.Error)
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+// Package stepup re-verifies a user before destructive actions: a one-time
+// code is sent over a second channel (email or Slack) and, once entered,
+// exchanged for a short-lived grant the action has to present. A leaked
+// API token alone is then not enough to delete games or lock the owner out.
+package stepup
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Channels codes can be delivered over
+const (
+	ChannelEmail = "email"
+	ChannelSlack = "slack"
+)
+
+const (
+	// How long a code can be entered
+	CodeTTL = 10 * time.Minute
+	// How long a verified grant unlocks destructive actions
+	GrantTTL = 10 * time.Minute
+	// Wrong codes allowed before a challenge is burned
+	MaxAttempts = 5
+	// Minimum time between two codes for the same user
+	ResendInterval = 30 * time.Second
+)
+
+var (
+	ErrUnknownChannel = errors.New("channel not available")
+	ErrTooSoon        = errors.New("a code was sent moments ago, wait before asking for another")
+	ErrInvalidCode    = errors.New("invalid or expired code")
+)
+
+// Sender delivers a code to an address on its channel.
+type Sender interface {
+	Send(ctx context.Context, to, code string) error
+}
+
+// Challenge is a code sent to a user and waiting to be entered.
+type Challenge struct {
+	ID        string    `json:"challengeId"`
+	Channel   string    `json:"channel"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type challenge struct {
+	userID    string
+	code      string
+	expiresAt time.Time
+	attempts  int
+}
+
+type grant struct {
+	userID    string
+	expiresAt time.Time
+}
+
+// Verifier issues and checks codes and grants. State lives in memory: codes
+// and grants are short-lived, and a restart only means asking for a new one.
+type Verifier struct {
+	senders map[string]Sender
+
+	mu         sync.Mutex
+	challenges map[string]*challenge
+	grants     map[string]grant
+	lastSent   map[string]time.Time
+}
+
+// NewVerifier returns a verifier delivering codes with senders, keyed by
+// channel. Without senders step-up is disabled.
+func NewVerifier(senders map[string]Sender) *Verifier {
+	return &Verifier{
+		senders:    senders,
+		challenges: map[string]*challenge{},
+		grants:     map[string]grant{},
+		lastSent:   map[string]time.Time{},
+	}
+}
+
+// Enabled reports whether any channel is configured.
+func (v *Verifier) Enabled() bool {
+	return len(v.senders) > 0
+}
+
+// Channels lists the configured channels.
+func (v *Verifier) Channels() []string {
+	channels := make([]string, 0, len(v.senders))
+	for c := range v.senders {
+		channels = append(channels, c)
+	}
+	sort.Strings(channels)
+	return channels
+}
+
+func randomID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// prune drops expired state. Callers hold mu.
+func (v *Verifier) prune(now time.Time) {
+	for id, c := range v.challenges {
+		if now.After(c.expiresAt) {
+			delete(v.challenges, id)
+		}
+	}
+	for token, g := range v.grants {
+		if now.After(g.expiresAt) {
+			delete(v.grants, token)
+		}
+	}
+	for userID, at := range v.lastSent {
+		if now.Sub(at) > ResendInterval {
+			delete(v.lastSent, userID)
+		}
+	}
+}
+
+// Start sends a new code for userID to address over channel.
+func (v *Verifier) Start(ctx context.Context, userID, channel, address string) (Challenge, error) {
+	sender, ok := v.senders[channel]
+	if !ok {
+		return Challenge{}, ErrUnknownChannel
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return Challenge{}, err
+	}
+	code := fmt.Sprintf("%06d", n.Int64())
+	now := time.Now()
+
+	v.mu.Lock()
+	v.prune(now)
+	if _, ok := v.lastSent[userID]; ok {
+		v.mu.Unlock()
+		return Challenge{}, ErrTooSoon
+	}
+	v.lastSent[userID] = now
+	v.mu.Unlock()
+
+	if err := sender.Send(ctx, address, code); err != nil {
+		v.mu.Lock()
+		delete(v.lastSent, userID)
+		v.mu.Unlock()
+		return Challenge{}, fmt.Errorf("failed to send code: %v", err)
+	}
+
+	c := Challenge{ID: randomID(), Channel: channel, ExpiresAt: now.Add(CodeTTL).UTC()}
+	v.mu.Lock()
+	v.challenges[c.ID] = &challenge{userID: userID, code: code, expiresAt: c.ExpiresAt}
+	v.mu.Unlock()
+	return c, nil
+}
+
+// Verify checks a code entered by userID and returns a grant for it.
+func (v *Verifier) Verify(challengeID, userID, code string) (string, time.Time, error) {
+	now := time.Now()
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.prune(now)
+	c, ok := v.challenges[challengeID]
+	if !ok || c.userID != userID {
+		return "", time.Time{}, ErrInvalidCode
+	}
+	if subtle.ConstantTimeCompare([]byte(c.code), []byte(code)) != 1 {
+		if c.attempts++; c.attempts >= MaxAttempts {
+			delete(v.challenges, challengeID)
+		}
+		return "", time.Time{}, ErrInvalidCode
+	}
+	delete(v.challenges, challengeID)
+
+	token := randomID()
+	expiresAt := now.Add(GrantTTL).UTC()
+	v.grants[token] = grant{userID: userID, expiresAt: expiresAt}
+	return token, expiresAt, nil
+}
+
+// Check reports whether token is a live grant of userID.
+func (v *Verifier) Check(token, userID string) bool {
+	if token == "" {
+		return false
+	}
+	now := time.Now()
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	g, ok := v.grants[token]
+	return ok && g.userID == userID && now.Before(g.expiresAt)
+}
@@ -0,0 +1,60 @@
+package store
+
+import (
+	"sync"
+	"time"
+)
+
+// StatementStats summarises how one kind of statement performed.
+type StatementStats struct {
+	Count         int64   `json:"count"`
+	Errors        int64   `json:"errors"`
+	TotalMillis   float64 `json:"totalMillis"`
+	AverageMillis float64 `json:"averageMillis"`
+	MaxMillis     float64 `json:"maxMillis"`
+}
+
+// Metrics records per-statement timings for database-backed stores.
+type Metrics struct {
+	mu    sync.Mutex
+	stats map[string]*StatementStats
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{stats: map[string]*StatementStats{}}
+}
+
+// Observe records one execution of statement that started at start.
+func (m *Metrics) Observe(statement string, start time.Time, err error) {
+	elapsed := float64(time.Since(start).Microseconds()) / 1000
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := m.stats[statement]
+	if s == nil {
+		s = &StatementStats{}
+		m.stats[statement] = s
+	}
+	s.Count++
+	if err != nil {
+		s.Errors++
+	}
+	s.TotalMillis += elapsed
+	s.AverageMillis = s.TotalMillis / float64(s.Count)
+	if elapsed > s.MaxMillis {
+		s.MaxMillis = elapsed
+	}
+}
+
+// Snapshot returns a copy of the current stats.
+func (m *Metrics) Snapshot() map[string]StatementStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	out := make(map[string]StatementStats, len(m.stats))
+	for k, v := range m.stats {
+		out[k] = *v
+	}
+	return out
+}
@@ -0,0 +1,135 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// PostgresStore keeps documents in Postgres so several API replicas can share
+// state. Updates lock the document row, so read-modify-write cycles are safe
+// across replicas.
+type PostgresStore struct {
+	db      *sql.DB
+	metrics *Metrics
+}
+
+type PostgresOptions struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
+func NewPostgresStore(dsn string, opts PostgresOptions) (*PostgresStore, error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open postgres: %v", err)
+	}
+	db.SetMaxOpenConns(opts.MaxOpenConns)
+	db.SetMaxIdleConns(opts.MaxIdleConns)
+	db.SetConnMaxLifetime(opts.ConnMaxLifetime)
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to postgres: %v", err)
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS documents (
+		name       TEXT PRIMARY KEY,
+		data       JSONB,
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create documents table: %v", err)
+	}
+	return &PostgresStore{db: db, metrics: NewMetrics()}, nil
+}
+
+func (s *PostgresStore) Load(name string, v any) (err error) {
+	defer func(start time.Time) { s.metrics.Observe("load", start, err) }(time.Now())
+
+	var data []byte
+	err = s.db.QueryRow(`SELECT data FROM documents WHERE name = $1`, name).Scan(&data)
+	if err == sql.ErrNoRows || (err == nil && data == nil) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", name, err)
+	}
+	return decode(name, data, v)
+}
+
+func (s *PostgresStore) Save(name string, v any) (err error) {
+	defer func(start time.Time) { s.metrics.Observe("save", start, err) }(time.Now())
+
+	data, err := encode(name, v)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT INTO documents (name, data, updated_at) VALUES ($1, $2, now())
+		ON CONFLICT (name) DO UPDATE SET data = excluded.data, updated_at = excluded.updated_at`,
+		name, data)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %v", name, err)
+	}
+	return nil
+}
+
+func (s *PostgresStore) Update(name string, v any, fn func() error) (err error) {
+	defer func(start time.Time) { s.metrics.Observe("update", start, err) }(time.Now())
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// Make sure the row exists so it can be locked, even for a new document
+	if _, err = tx.Exec(`INSERT INTO documents (name) VALUES ($1) ON CONFLICT (name) DO NOTHING`, name); err != nil {
+		return fmt.Errorf("failed to create %s: %v", name, err)
+	}
+
+	var data []byte
+	if err = tx.QueryRow(`SELECT data FROM documents WHERE name = $1 FOR UPDATE`, name).Scan(&data); err != nil {
+		return fmt.Errorf("failed to lock %s: %v", name, err)
+	}
+	if data != nil {
+		if err = decode(name, data, v); err != nil {
+			return err
+		}
+	}
+
+	if err = fn(); err != nil {
+		return err
+	}
+
+	encoded, err := encode(name, v)
+	if err != nil {
+		return err
+	}
+	if _, err = tx.Exec(`UPDATE documents SET data = $2, updated_at = now() WHERE name = $1`, name, encoded); err != nil {
+		return fmt.Errorf("failed to write %s: %v", name, err)
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresStore) Delete(name string) (err error) {
+	defer func(start time.Time) { s.metrics.Observe("delete", start, err) }(time.Now())
+
+	if _, err = s.db.Exec(`DELETE FROM documents WHERE name = $1`, name); err != nil {
+		return fmt.Errorf("failed to delete %s: %v", name, err)
+	}
+	return nil
+}
+
+// Stats reports statement timings and connection pool usage.
+func (s *PostgresStore) Stats() (map[string]StatementStats, sql.DBStats) {
+	return s.metrics.Snapshot(), s.db.Stats()
+}
+
+func (s *PostgresStore) Close() error {
+	return s.db.Close()
+}
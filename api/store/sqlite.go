@@ -0,0 +1,121 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// SQLiteStore keeps documents in an embedded SQLite database in WAL mode, so
+// readers never block the writer and a crash loses at most the last
+// transaction.
+type SQLiteStore struct {
+	db *sql.DB
+	// Serialises Update so two read-modify-write cycles can't interleave
+	mu sync.Mutex
+}
+
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)&_pragma=synchronous(NORMAL)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sqlite database %s: %v", path, err)
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS documents (
+		name       TEXT PRIMARY KEY,
+		data       BLOB NOT NULL,
+		updated_at TIMESTAMP NOT NULL
+	)`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create documents table: %v", err)
+	}
+	return &SQLiteStore{db: db}, nil
+}
+
+func (s *SQLiteStore) Load(name string, v any) error {
+	return loadRow(s.db.QueryRow(`SELECT data FROM documents WHERE name = ?`, name), name, v)
+}
+
+func (s *SQLiteStore) Save(name string, v any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return saveRow(s.db, name, v)
+}
+
+func (s *SQLiteStore) Update(name string, v any, fn func() error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := loadRow(tx.QueryRow(`SELECT data FROM documents WHERE name = ?`, name), name, v); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		return err
+	}
+	if err := saveRow(tx, name, v); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.db.Exec(`DELETE FROM documents WHERE name = ?`, name); err != nil {
+		return fmt.Errorf("failed to delete %s: %v", name, err)
+	}
+	return nil
+}
+
+// Snapshot writes a consistent copy of the database to path, which must not
+// exist yet. Safe to call while the store is in use.
+func (s *SQLiteStore) Snapshot(path string) error {
+	if _, err := s.db.Exec(`VACUUM INTO ?`, path); err != nil {
+		return fmt.Errorf("failed to snapshot database: %v", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+func loadRow(row *sql.Row, name string, v any) error {
+	var data []byte
+	err := row.Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", name, err)
+	}
+	return decode(name, data, v)
+}
+
+func saveRow(db execer, name string, v any) error {
+	data, err := encode(name, v)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`INSERT INTO documents (name, data, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET data = excluded.data, updated_at = excluded.updated_at`,
+		name, data, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %v", name, err)
+	}
+	return nil
+}
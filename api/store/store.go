@@ -0,0 +1,143 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Store persists small named JSON documents (judging state, changelogs, ...)
+// that don't belong in Airtable or R2.
+type Store interface {
+	// Load decodes the document called name into v. A missing document
+	// leaves v untouched and is not an error.
+	Load(name string, v any) error
+	// Save replaces the document called name with v.
+	Save(name string, v any) error
+	// Update loads name into v, runs fn and saves v again if fn succeeds,
+	// all while holding the store lock.
+	Update(name string, v any, fn func() error) error
+	// Delete removes the document called name. Deleting a missing document
+	// is not an error.
+	Delete(name string) error
+}
+
+type FileStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create data directory %s: %v", dir, err)
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+// path is where a document is kept. Names may come from request data, e.g.
+// a project ID, so ones that could point outside the data directory are
+// refused.
+func (s *FileStore) path(name string) (string, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) || strings.Contains(name, "..") {
+		return "", fmt.Errorf("invalid document name %q", name)
+	}
+	return filepath.Join(s.dir, name+".json"), nil
+}
+
+func (s *FileStore) Load(name string, v any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.load(name, v)
+}
+
+func (s *FileStore) Save(name string, v any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.save(name, v)
+}
+
+func (s *FileStore) Update(name string, v any, fn func() error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.load(name, v); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		return err
+	}
+	return s.save(name, v)
+}
+
+func (s *FileStore) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete %s: %v", name, err)
+	}
+	return nil
+}
+
+func encode(name string, v any) ([]byte, error) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s: %v", name, err)
+	}
+	return data, nil
+}
+
+func decode(name string, data []byte, v any) error {
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to decode %s: %v", name, err)
+	}
+	return nil
+}
+
+func (s *FileStore) load(name string, v any) error {
+	path, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", name, err)
+	}
+	return decode(name, data, v)
+}
+
+func (s *FileStore) save(name string, v any) error {
+	path, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	data, err := encode(name, v)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temp file first so a crash never leaves a half-written document
+	tmp, err := os.CreateTemp(s.dir, name+"-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file for %s: %v", name, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %v", name, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close %s: %v", name, err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,98 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileStorePath(t *testing.T) {
+	s, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{"builds", true},
+		{"game-stats-hourly-2026-10-17", true},
+		{"project-meta-recA1b2C3", true},
+		{"", false},
+		{"../builds", false},
+		{"..", false},
+		{"a..b", false},
+		{"nested/doc", false},
+		{`nested\doc`, false},
+		{"/etc/passwd", false},
+	}
+	for _, tt := range tests {
+		path, err := s.path(tt.name)
+		if (err == nil) != tt.ok {
+			t.Errorf("path(%q) error = %v, want ok %v", tt.name, err, tt.ok)
+			continue
+		}
+		if err == nil && filepath.Dir(path) != s.dir {
+			t.Errorf("path(%q) = %s, outside %s", tt.name, path, s.dir)
+		}
+	}
+}
+
+func TestFileStoreRefusesBadNames(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewFileStore(filepath.Join(dir, "data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct{ N int }
+	if err := s.Save("../escaped", &doc); err == nil {
+		t.Error("Save outside the data directory succeeded")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "escaped.json")); !os.IsNotExist(err) {
+		t.Errorf("document written outside the data directory: %v", err)
+	}
+	if err := s.Update("../escaped", &doc, func() error { return nil }); err == nil {
+		t.Error("Update outside the data directory succeeded")
+	}
+	if err := s.Delete("../escaped"); err == nil {
+		t.Error("Delete outside the data directory succeeded")
+	}
+}
+
+func TestFileStoreUpdate(t *testing.T) {
+	s, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	type counter struct{ N int }
+
+	// A missing document loads as the zero value
+	var c counter
+	if err := s.Load("counter", &c); err != nil || c.N != 0 {
+		t.Fatalf("Load of a missing document = %v, %+v", err, c)
+	}
+	for range 3 {
+		var c counter
+		if err := s.Update("counter", &c, func() error { c.N++; return nil }); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A failing update writes nothing
+	errStop := errors.New("stop")
+	var failed counter
+	if err := s.Update("counter", &failed, func() error { failed.N = 100; return errStop }); !errors.Is(err, errStop) {
+		t.Fatalf("Update = %v, want %v", err, errStop)
+	}
+	if err := s.Load("counter", &c); err != nil || c.N != 3 {
+		t.Errorf("Load = %v, %+v, want N 3", err, c)
+	}
+
+	if err := s.Delete("counter"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete("counter"); err != nil {
+		t.Errorf("deleting a missing document = %v, want nil", err)
+	}
+}
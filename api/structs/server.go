@@ -1,6 +1,28 @@
 package structs
 
 import (
+	"shiba-api/announcements"
+	"shiba-api/buildindex"
+	"shiba-api/capability"
+	"shiba-api/config"
+	"shiba-api/costs"
+	"shiba-api/datastore"
+	"shiba-api/devchannel"
+	"shiba-api/events"
+	"shiba-api/jobs"
+	"shiba-api/membudget"
+	"shiba-api/playtime"
+	"shiba-api/preview"
+	"shiba-api/quota"
+	"shiba-api/search"
+	"shiba-api/stats"
+	"shiba-api/stepup"
+	"shiba-api/store"
+	"shiba-api/syncqueue"
+	"shiba-api/users"
+	"shiba-api/webhooks"
+	"shiba-api/writequeue"
+
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/mehanizm/airtable"
 )
@@ -8,6 +30,57 @@ import (
 type Server struct {
 	AirtableClient    *airtable.Client
 	AirtableBaseTable *airtable.Table
-	S3Client          *s3.Client
-	AdminToken        string
+	// AirtableGamesTable holds the site's game records (Name, HackatimeSeconds, ...)
+	AirtableGamesTable *airtable.Table
+	// AirtablePlaysTable links players to the games they played (Game, Player)
+	AirtablePlaysTable *airtable.Table
+	S3Client           *s3.Client
+	AdminToken         string
+	Store              store.Store
+	UploadJobs         *jobs.Tracker
+	Quotas             *quota.Tracker
+	Config             *config.Holder
+	Events             *events.Log
+	SearchIndex        *search.Index
+	// Stats buffers game heartbeats between rollup flushes
+	Stats *stats.Aggregator
+	// UploadSlots caps each caller's concurrent uploads
+	UploadSlots *quota.Slots
+	// Memory admits uploads against the memory budget
+	Memory *membudget.Budget
+	// Egress counts traffic served per build between flushes
+	Egress *costs.Meter
+	// StepUp re-verifies users before destructive actions
+	StepUp *stepup.Verifier
+	// AirtableWrites batches and paces every Airtable record update
+	AirtableWrites *writequeue.Queue
+	// Users replicates the Airtable Users table for lookups; nil when users
+	// aren't kept in Airtable
+	Users *users.Replica
+	// UserStore and GameStore are where users and games are kept, Airtable
+	// or Postgres
+	UserStore datastore.UserStore
+	GameStore datastore.GameStore
+	// Games caches game records read for public pages
+	Games *datastore.GameCache
+	// BuildIndex knows which builds exist and which are drafts without
+	// reading the builds document
+	BuildIndex *buildindex.Index
+	// Tokens caches recent token lookups
+	Tokens *users.TokenCache
+	// Previews signs the links that open draft builds
+	Previews *preview.Signer
+	// Capabilities signs the single-use URLs allowing one admin action
+	Capabilities *capability.Signer
+	// DevChannel tells open play sessions about new versions of their game
+	DevChannel *devchannel.Hub
+	// PlaySessions tracks players' heartbeats until their playtime is
+	// credited
+	PlaySessions *playtime.Tracker
+	// Webhooks delivers events to creators' webhooks
+	Webhooks *webhooks.Dispatcher
+	// SyncQueue keeps the R2 syncs of published builds until they're done
+	SyncQueue *syncqueue.Queue
+	// Announcements caches the organizers' announcements for the frontend
+	Announcements *announcements.Board
 }
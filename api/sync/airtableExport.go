@@ -0,0 +1,124 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"shiba-api/stats"
+	"shiba-api/structs"
+	"time"
+
+	"github.com/mehanizm/airtable"
+)
+
+// Fields written on each Games record. They have to exist in the base; the
+// organizer views filter and sort on them.
+const (
+	exportPlayCountField    = "Play Count"
+	exportPlaytimeField     = "Playtime Hours"
+	exportFeedbackField     = "Feedback Count"
+	exportShipStatusField   = "Ship Status"
+	exportVersionsField     = "Versions"
+	exportLastShippedField  = "Last Shipped"
+	exportStatsUpdatedField = "Stats Updated At"
+)
+
+func countPlays(server structs.Server) (map[string]int, error) {
+	plays := map[string]int{}
+	if server.AirtablePlaysTable == nil {
+		return plays, nil
+	}
+
+	offset := ""
+	for {
+		records, err := server.AirtablePlaysTable.GetRecords().
+			ReturnFields("Game").
+			WithOffset(offset).
+			Do()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list plays: %v", err)
+		}
+		for _, r := range records.Records {
+			if game := firstLink(r.Fields, "Game"); game != "" {
+				plays[game]++
+			}
+		}
+		if records.Offset == "" {
+			return plays, nil
+		}
+		offset = records.Offset
+	}
+}
+
+func listGameIDs(server structs.Server) ([]string, error) {
+	var ids []string
+	offset := ""
+	for {
+		records, err := server.AirtableGamesTable.GetRecords().
+			ReturnFields("Name").
+			WithOffset(offset).
+			Do()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list games: %v", err)
+		}
+		for _, r := range records.Records {
+			ids = append(ids, r.ID)
+		}
+		if records.Offset == "" {
+			return ids, nil
+		}
+		offset = records.Offset
+	}
+}
+
+// ExportStatsToAirtable writes per-game aggregates (plays, playtime, feedback
+// and ship status) back onto the Airtable Games records so the organizer
+// team's existing views stay current.
+func ExportStatsToAirtable(server structs.Server) error {
+	if server.AirtableGamesTable == nil {
+		return fmt.Errorf("games table is not configured")
+	}
+
+	gameStats, err := stats.Load(server.Store)
+	if err != nil {
+		return fmt.Errorf("failed to load stats: %v", err)
+	}
+	plays, err := countPlays(server)
+	if err != nil {
+		return err
+	}
+	ids, err := listGameIDs(server)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	records := make([]*airtable.Record, 0, len(ids))
+	for _, id := range ids {
+		g := gameStats.Games[id]
+		status := g.ShipStatus
+		if status == "" {
+			status = stats.NotShipped
+		}
+		fields := map[string]any{
+			exportPlayCountField:    plays[id],
+			exportPlaytimeField:     math.Round(float64(g.PlaytimeSeconds)/36) / 100,
+			exportFeedbackField:     g.Feedback,
+			exportShipStatusField:   status,
+			exportVersionsField:     g.Versions,
+			exportStatsUpdatedField: now,
+		}
+		if !g.LastShippedAt.IsZero() {
+			fields[exportLastShippedField] = g.LastShippedAt.Format(time.RFC3339)
+		}
+		records = append(records, &airtable.Record{ID: id, Fields: fields})
+	}
+
+	if err := server.AirtableWrites.UpdateAll(context.Background(), server.AirtableGamesTable, records); err != nil {
+		return fmt.Errorf("failed to update games: %v", err)
+	}
+
+	slog.Info("Exported stats to Airtable", "games", len(records))
+	return nil
+}
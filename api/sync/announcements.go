@@ -0,0 +1,63 @@
+package sync
+
+import (
+	"fmt"
+	"time"
+
+	"shiba-api/announcements"
+
+	"github.com/mehanizm/airtable"
+)
+
+// LoadAirtableAnnouncements reads the organizers' announcements from their
+// Airtable table: Message, Level (info, warning or critical, default info)
+// and the optional Starts and Ends date-times. Records without a message
+// are left out.
+func LoadAirtableAnnouncements(table *airtable.Table) ([]announcements.Announcement, error) {
+	list := []announcements.Announcement{}
+	offset := ""
+	for {
+		records, err := table.GetRecords().
+			ReturnFields("Message", "Level", "Starts", "Ends").
+			WithOffset(offset).
+			Do()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list announcements: %v", err)
+		}
+		for _, r := range records.Records {
+			message := stringField(r.Fields, "Message")
+			if message == "" {
+				continue
+			}
+			a := announcements.Announcement{
+				ID:       r.ID,
+				Message:  message,
+				Level:    stringField(r.Fields, "Level"),
+				StartsAt: timeField(r.Fields, "Starts"),
+				EndsAt:   timeField(r.Fields, "Ends"),
+				Source:   announcements.SourceAirtable,
+			}
+			switch a.Level {
+			case announcements.LevelWarning, announcements.LevelCritical:
+			default:
+				a.Level = announcements.LevelInfo
+			}
+			if created, err := time.Parse(time.RFC3339, r.CreatedTime); err == nil {
+				a.CreatedAt = created
+			}
+			list = append(list, a)
+		}
+		if records.Offset == "" {
+			return list, nil
+		}
+		offset = records.Offset
+	}
+}
+
+func timeField(fields map[string]any, name string) *time.Time {
+	t, err := time.Parse(time.RFC3339, stringField(fields, name))
+	if err != nil {
+		return nil
+	}
+	return &t
+}
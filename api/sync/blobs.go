@@ -0,0 +1,279 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	gosync "sync"
+	"time"
+
+	"shiba-api/structs"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// BlobIndexFile is the object under games/<id>/ listing the blobs of a
+// content-addressed build.
+const BlobIndexFile = "shiba-blobs.json"
+
+// BlobFile is a file of a content-addressed build.
+type BlobFile struct {
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
+// BlobIndex lists the files of a content-addressed build. Their content is
+// stored once for every build at BlobKey(SHA256).
+type BlobIndex struct {
+	GameID string     `json:"gameId"`
+	Files  []BlobFile `json:"files"`
+}
+
+// BlobKey is the key of the blob holding content with the given hash.
+func BlobKey(sum string) string {
+	return "blobs/" + sum
+}
+
+// isNotFound reports whether R2 answered a request with 404.
+func isNotFound(err error) bool {
+	var respErr *awshttp.ResponseError
+	return errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusNotFound
+}
+
+// prepareBlob hashes a file of a content-addressed build and points it at
+// its blob, reporting whether the blob is already in R2 and needn't be sent.
+func prepareBlob(ctx context.Context, server structs.Server, bucket string, file folderFile) (folderFile, bool, error) {
+	f, err := os.Open(file.path)
+	if err != nil {
+		return file, false, fmt.Errorf("failed to open %s: %v", file.path, err)
+	}
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	f.Close()
+	if err != nil {
+		return file, false, fmt.Errorf("failed to hash %s: %v", file.path, err)
+	}
+	file.sha256 = hex.EncodeToString(h.Sum(nil))
+	file.key = BlobKey(file.sha256)
+	file.metadata = nil
+
+	cfg := server.Config.Get()
+	exists := false
+	err = withRetries(ctx, file.key, cfg.SyncRetries, func() error {
+		_, err := server.S3Client.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(file.key),
+		})
+		if isNotFound(err) {
+			return nil
+		}
+		exists = err == nil
+		return err
+	})
+	if err != nil {
+		return file, false, fmt.Errorf("failed to look up %s: %w", file.key, err)
+	}
+	return file, exists, nil
+}
+
+// putBlobIndex writes the blob index of a build once all of its blobs are
+// in R2, which makes the build complete there.
+func putBlobIndex(ctx context.Context, server structs.Server, bucket, gameID string, files []folderFile, labels ObjectLabels, retries int) error {
+	index := BlobIndex{GameID: gameID, Files: make([]BlobFile, len(files))}
+	for i, f := range files {
+		index.Files[i] = BlobFile{Path: f.rel, SHA256: f.sha256, Size: f.size}
+	}
+	data, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	key := gameKey("", gameID, BlobIndexFile)
+	err = withRetries(ctx, key, retries, func() error {
+		_, err := server.S3Client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:      aws.String(bucket),
+			Key:         aws.String(key),
+			Body:        bytes.NewReader(data),
+			ContentType: aws.String("application/json"),
+			Metadata:    labels.Metadata(),
+		})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %w", key, err)
+	}
+	return nil
+}
+
+// LoadBlobIndex reads the blob index of a build, nil if the build isn't
+// content-addressed.
+func LoadBlobIndex(ctx context.Context, server structs.Server, gameID string) (*BlobIndex, error) {
+	return loadBlobIndex(ctx, server, gameKey("", gameID, BlobIndexFile))
+}
+
+func loadBlobIndex(ctx context.Context, server structs.Server, key string) (*BlobIndex, error) {
+	resp, err := server.S3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(os.Getenv("R2_BUCKET")),
+		Key:    aws.String(key),
+	})
+	if isNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s from R2: %v", key, err)
+	}
+	defer resp.Body.Close()
+	var index BlobIndex
+	if err := json.NewDecoder(resp.Body).Decode(&index); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %v", key, err)
+	}
+	return &index, nil
+}
+
+// downloadBlobs writes the files of a content-addressed build to dir,
+// skipping those already there.
+func downloadBlobs(ctx context.Context, server structs.Server, index *BlobIndex, dir string) error {
+	bucket := os.Getenv("R2_BUCKET")
+	for _, f := range index.Files {
+		dest := filepath.Join(dir, filepath.FromSlash(f.Path))
+		if !strings.HasPrefix(dest, filepath.Clean(dir)+string(os.PathSeparator)) {
+			return fmt.Errorf("invalid path %s in blob index of %s", f.Path, index.GameID)
+		}
+		if _, err := os.Stat(dest); err == nil {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return err
+		}
+		resp, err := server.S3Client.GetObject(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(BlobKey(f.SHA256)),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to download %s from R2: %v", BlobKey(f.SHA256), err)
+		}
+		out, err := os.Create(dest)
+		if err == nil {
+			_, err = io.Copy(out, resp.Body)
+			if closeErr := out.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to write %s: %v", dest, err)
+		}
+	}
+	return nil
+}
+
+// blobSweep is held for reading by content-addressed syncs, from looking up
+// their blobs until their index is up, and for writing while CollectBlobs
+// deletes blobs, so a sync never relies on a blob being swept.
+var blobSweep gosync.RWMutex
+
+// blobGCGrace is how old an unreferenced blob must be before CollectBlobs
+// deletes it: a sync uploads a build's blobs before its index, so younger
+// blobs may belong to a build still being synced.
+const blobGCGrace = 24 * time.Hour
+
+// CollectBlobs deletes the blobs no build references any more, marking
+// those listed by the blob indexes of served, archived and trashed builds
+// and sweeping the rest of blobs/. A blob only referenced by a trashed
+// build goes once the lifecycle rule expires its index. With dryRun nothing
+// is deleted. It returns how many blobs were unreferenced and their size.
+func CollectBlobs(ctx context.Context, server structs.Server, dryRun bool) (int, int64, error) {
+	bucket := os.Getenv("R2_BUCKET")
+	cutoff := time.Now().Add(-blobGCGrace)
+
+	// Blobs are listed before the indexes, so a blob uploaded in between is
+	// too young to be swept and one whose build is indexed in between is
+	// marked
+	candidates := map[string]int64{}
+	paginator := s3.NewListObjectsV2Paginator(server.S3Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(BlobKey("")),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to list blobs: %v", err)
+		}
+		for _, obj := range page.Contents {
+			if aws.ToTime(obj.LastModified).Before(cutoff) {
+				candidates[aws.ToString(obj.Key)] = aws.ToInt64(obj.Size)
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return 0, 0, nil
+	}
+
+	indexes := map[string]bool{}
+	if err := markBlobIndexes(ctx, server, bucket, candidates, indexes); err != nil {
+		return 0, 0, err
+	}
+
+	// Indexes written by syncs that were in flight while marking are marked
+	// again once they're done, and no new sync starts until the sweep ends
+	blobSweep.Lock()
+	defer blobSweep.Unlock()
+	if err := markBlobIndexes(ctx, server, bucket, candidates, indexes); err != nil {
+		return 0, 0, err
+	}
+
+	unreferenced := make([]string, 0, len(candidates))
+	var size int64
+	for key, n := range candidates {
+		unreferenced = append(unreferenced, key)
+		size += n
+	}
+	if dryRun {
+		return len(unreferenced), size, nil
+	}
+	if _, err := deleteObjects(ctx, server, bucket, unreferenced); err != nil {
+		return 0, 0, fmt.Errorf("failed to delete blobs: %v", err)
+	}
+	return len(unreferenced), size, nil
+}
+
+// markBlobIndexes removes the blobs listed by the blob indexes of served,
+// archived and trashed builds from candidates, skipping the indexes already
+// in seen and adding the others to it.
+func markBlobIndexes(ctx context.Context, server structs.Server, bucket string, candidates map[string]int64, seen map[string]bool) error {
+	for _, prefix := range []string{"", archivePrefix(), trashPrefix()} {
+		keys, err := ListR2Objects(bucket, gameKey(prefix, "", "")+"/", server.S3Client)
+		if err != nil {
+			return fmt.Errorf("failed to list builds: %v", err)
+		}
+		for _, key := range keys {
+			if path.Base(key) != BlobIndexFile || seen[key] {
+				continue
+			}
+			index, err := loadBlobIndex(ctx, server, key)
+			if err != nil {
+				return err
+			}
+			seen[key] = true
+			if index == nil {
+				continue
+			}
+			for _, f := range index.Files {
+				delete(candidates, BlobKey(f.SHA256))
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"shiba-api/structs"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// CheckBucket checks that the R2 credentials can reach the bucket.
+func CheckBucket(ctx context.Context, server structs.Server) error {
+	_, err := server.S3Client.HeadBucket(ctx, &s3.HeadBucketInput{
+		Bucket: aws.String(os.Getenv("R2_BUCKET")),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to reach R2 bucket: %v", err)
+	}
+	return nil
+}
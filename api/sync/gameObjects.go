@@ -0,0 +1,167 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"shiba-api/structs"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// OpenGameFile streams one file of a published build from R2, from the
+// blob with hash sum if the build is content-addressed. The caller must
+// close the returned reader.
+func OpenGameFile(ctx context.Context, server structs.Server, gameID, file, sum string) (io.ReadCloser, error) {
+	key := gameKey("", gameID, file)
+	resp, err := server.S3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(os.Getenv("R2_BUCKET")),
+		Key:    aws.String(key),
+	})
+	if isNotFound(err) && sum != "" {
+		key = BlobKey(sum)
+		resp, err = server.S3Client.GetObject(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(os.Getenv("R2_BUCKET")),
+			Key:    aws.String(key),
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s from R2: %v", key, err)
+	}
+	return resp.Body, nil
+}
+
+func gameKey(prefix, gameID, file string) string {
+	return path.Join(prefix, "games", gameID, file)
+}
+
+// moveObject copies an object within the bucket with the given storage class
+// and deletes the original. The copy keeps the object's metadata with its
+// state label set to state.
+func moveObject(ctx context.Context, server structs.Server, from, to string, class types.StorageClass, state string) error {
+	bucket := os.Getenv("R2_BUCKET")
+	head, err := server.S3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(from),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to look up %s: %v", from, err)
+	}
+	metadata := head.Metadata
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+	metadata["state"] = state
+
+	source := bucket + "/" + (&url.URL{Path: from}).EscapedPath()
+	_, err = server.S3Client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:       aws.String(bucket),
+		CopySource:   aws.String(source),
+		Key:          aws.String(to),
+		StorageClass: class,
+		// Replacing the metadata drops the headers it isn't given again
+		MetadataDirective: types.MetadataDirectiveReplace,
+		Metadata:          metadata,
+		ContentType:       head.ContentType,
+		CacheControl:      head.CacheControl,
+		ContentEncoding:   head.ContentEncoding,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to copy %s to %s: %v", from, to, err)
+	}
+	_, err = server.S3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(from),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete %s: %v", from, err)
+	}
+	return nil
+}
+
+// ArchiveGameFile moves a build file under the archive prefix (ARCHIVE_PREFIX,
+// default "archive") in the given storage class, labeled frozen.
+func ArchiveGameFile(ctx context.Context, server structs.Server, gameID, file, storageClass string) error {
+	return moveObject(ctx, server, gameKey("", gameID, file), gameKey(archivePrefix(), gameID, file), types.StorageClass(storageClass), StateFrozen)
+}
+
+// RestoreGameFile moves an archived build file back to its serving location
+// in standard storage, labeled with the build's state, draft or published.
+func RestoreGameFile(ctx context.Context, server structs.Server, gameID, file, state string) error {
+	return moveObject(ctx, server, gameKey(archivePrefix(), gameID, file), gameKey("", gameID, file), types.StorageClassStandard, state)
+}
+
+func archivePrefix() string {
+	if prefix := os.Getenv("ARCHIVE_PREFIX"); prefix != "" {
+		return prefix
+	}
+	return "archive"
+}
+
+// DeleteGameFiles deletes every object of a build from R2, including an
+// archived copy, and returns how many were deleted. With R2_TRASH_DAYS set
+// they are moved under the trash prefix (TRASH_PREFIX, default "trash"),
+// labeled trash, for the lifecycle rule to expire instead. The blobs of a
+// content-addressed build may be shared with other builds, so they stay
+// until CollectBlobs finds nothing references them.
+func DeleteGameFiles(ctx context.Context, server structs.Server, gameID string) (int, error) {
+	bucket := os.Getenv("R2_BUCKET")
+	trash := server.Config.Get().TrashDays > 0
+	deleted := 0
+	for _, prefix := range []string{"", archivePrefix()} {
+		keys, err := ListR2Objects(bucket, gameKey(prefix, gameID, "")+"/", server.S3Client)
+		if err != nil {
+			return deleted, fmt.Errorf("failed to list objects of %s: %v", gameID, err)
+		}
+		if trash {
+			for _, key := range keys {
+				to := path.Join(trashPrefix(), strings.TrimPrefix(key, prefix+"/"))
+				if err := moveObject(ctx, server, key, to, types.StorageClassStandard, StateTrash); err != nil {
+					return deleted, err
+				}
+				deleted++
+			}
+			continue
+		}
+		n, err := deleteObjects(ctx, server, bucket, keys)
+		deleted += n
+		if err != nil {
+			return deleted, fmt.Errorf("failed to delete objects of %s: %v", gameID, err)
+		}
+	}
+	return deleted, nil
+}
+
+// deleteObjects deletes keys from the bucket and returns how many were
+// deleted before any failure.
+func deleteObjects(ctx context.Context, server structs.Server, bucket string, keys []string) (int, error) {
+	deleted := 0
+	// DeleteObjects takes at most 1000 keys per call
+	for start := 0; start < len(keys); start += 1000 {
+		end := min(start+1000, len(keys))
+		objects := make([]types.ObjectIdentifier, 0, end-start)
+		for _, key := range keys[start:end] {
+			objects = append(objects, types.ObjectIdentifier{Key: aws.String(key)})
+		}
+		resp, err := server.S3Client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(bucket),
+			Delete: &types.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return deleted, err
+		}
+		if len(resp.Errors) > 0 {
+			e := resp.Errors[0]
+			return deleted, fmt.Errorf("failed to delete %s: %s", aws.ToString(e.Key), aws.ToString(e.Message))
+		}
+		deleted += len(objects)
+	}
+	return deleted, nil
+}
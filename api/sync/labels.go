@@ -0,0 +1,110 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"shiba-api/structs"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// States of a build's objects
+const (
+	StateDraft     = "draft"
+	StatePublished = "published"
+	// Frozen builds were moved to cold storage, under the archive prefix
+	StateFrozen = "frozen"
+	// Trashed builds were deleted and wait under the trash prefix for the
+	// lifecycle rule expiring them
+	StateTrash = "trash"
+)
+
+// ObjectLabels attribute the objects of a build to who and what they are
+// for, for cost reports and cleanups run against the bucket. R2 doesn't
+// support S3 object tags, so they are stored as custom metadata
+// (x-amz-meta-game-id and so on) and lifecycle rules work on key prefixes,
+// see EnsureLifecycle.
+type ObjectLabels struct {
+	GameID  string
+	UserID  string
+	EventID string
+	State   string
+}
+
+// Metadata is the labels as object metadata, leaving out unset ones.
+func (l ObjectLabels) Metadata() map[string]string {
+	m := map[string]string{}
+	for k, v := range map[string]string{"game-id": l.GameID, "user-id": l.UserID, "event-id": l.EventID, "state": l.State} {
+		if v != "" {
+			m[k] = v
+		}
+	}
+	return m
+}
+
+// LabelsFromMetadata reads the labels back from an object's metadata.
+func LabelsFromMetadata(m map[string]string) ObjectLabels {
+	return ObjectLabels{GameID: m["game-id"], UserID: m["user-id"], EventID: m["event-id"], State: m["state"]}
+}
+
+func trashPrefix() string {
+	if prefix := os.Getenv("TRASH_PREFIX"); prefix != "" {
+		return prefix
+	}
+	return "trash"
+}
+
+// Lifecycle rules EnsureLifecycle manages are named with this prefix; other
+// rules on the bucket are left alone.
+const lifecycleRulePrefix = "shiba-"
+
+// EnsureLifecycle sets the bucket's lifecycle rule expiring trashed builds
+// trashDays after they were deleted, removing it when trashDays is 0. Rules
+// the bucket has besides are kept.
+func EnsureLifecycle(ctx context.Context, server structs.Server, trashDays int) error {
+	bucket := os.Getenv("R2_BUCKET")
+	var rules []types.LifecycleRule
+	current, err := server.S3Client.GetBucketLifecycleConfiguration(ctx, &s3.GetBucketLifecycleConfigurationInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil && !isNotFound(err) && !strings.Contains(err.Error(), "NoSuchLifecycleConfiguration") {
+		return fmt.Errorf("failed to read lifecycle rules: %v", err)
+	}
+	if err == nil {
+		for _, rule := range current.Rules {
+			if !strings.HasPrefix(aws.ToString(rule.ID), lifecycleRulePrefix) {
+				rules = append(rules, rule)
+			}
+		}
+	}
+	if trashDays > 0 {
+		rules = append(rules, types.LifecycleRule{
+			ID:         aws.String(lifecycleRulePrefix + "trash"),
+			Status:     types.ExpirationStatusEnabled,
+			Filter:     &types.LifecycleRuleFilter{Prefix: aws.String(trashPrefix() + "/")},
+			Expiration: &types.LifecycleExpiration{Days: aws.Int32(int32(trashDays))},
+		})
+	}
+
+	if len(rules) == 0 {
+		if err != nil {
+			// Nothing configured and nothing to configure
+			return nil
+		}
+		_, err = server.S3Client.DeleteBucketLifecycle(ctx, &s3.DeleteBucketLifecycleInput{Bucket: aws.String(bucket)})
+	} else {
+		_, err = server.S3Client.PutBucketLifecycleConfiguration(ctx, &s3.PutBucketLifecycleConfigurationInput{
+			Bucket:                 aws.String(bucket),
+			LifecycleConfiguration: &types.BucketLifecycleConfiguration{Rules: rules},
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save lifecycle rules: %v", err)
+	}
+	return nil
+}
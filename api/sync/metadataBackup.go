@@ -0,0 +1,132 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// Latest metadata snapshot; a dated copy is kept alongside it each day
+const metadataBackupKey = "backups/metadata.db"
+
+// BackupTarget is where metadata snapshots are kept. It must be a private
+// bucket: the database holds the users replica, token hashes, sessions and
+// webhook secrets, so it never goes to the bucket serving game files.
+type BackupTarget struct {
+	Client *s3.Client
+	Bucket string
+}
+
+func (t BackupTarget) check() error {
+	if t.Client == nil || t.Bucket == "" {
+		return errors.New("no metadata backup bucket is set")
+	}
+	if t.Bucket == os.Getenv("R2_BUCKET") {
+		return errors.New("the metadata backup bucket must not be the bucket serving game files")
+	}
+	return nil
+}
+
+// Snapshotter writes a consistent copy of a database to a new file.
+type Snapshotter interface {
+	Snapshot(path string) error
+}
+
+// BackupMetadata snapshots the metadata database and uploads it to target.
+func BackupMetadata(ctx context.Context, target BackupTarget, db Snapshotter) error {
+	if os.Getenv("DEBUG_ENV") == "true" {
+		slog.InfoContext(ctx, "Skipping metadata backup in debug environment")
+		return nil
+	}
+	if err := target.check(); err != nil {
+		return err
+	}
+
+	dir, err := os.MkdirTemp("", "metadata-backup-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	snapshot := filepath.Join(dir, "metadata.db")
+	if err := db.Snapshot(snapshot); err != nil {
+		return err
+	}
+
+	uploader := manager.NewUploader(target.Client)
+	dated := "backups/metadata-" + time.Now().UTC().Format("2006-01-02") + ".db"
+	for _, key := range []string{metadataBackupKey, dated} {
+		f, err := os.Open(snapshot)
+		if err != nil {
+			return fmt.Errorf("failed to open snapshot: %v", err)
+		}
+		_, err = uploader.Upload(ctx, &s3.PutObjectInput{
+			Bucket: aws.String(target.Bucket),
+			Key:    aws.String(key),
+			Body:   f,
+		})
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("failed to upload %s: %v", key, err)
+		}
+	}
+
+	slog.InfoContext(ctx, "Backed up metadata database", "bucket", target.Bucket, "key", metadataBackupKey)
+	return nil
+}
+
+// RestoreMetadata downloads the latest metadata snapshot from target to path.
+// A missing backup is not an error, the store starts empty.
+func RestoreMetadata(ctx context.Context, target BackupTarget, path string) error {
+	if err := target.check(); err != nil {
+		return err
+	}
+
+	resp, err := target.Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(target.Bucket),
+		Key:    aws.String(metadataBackupKey),
+	})
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		slog.InfoContext(ctx, "No metadata backup found, starting with an empty store", "bucket", target.Bucket)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %v", metadataBackupKey, err)
+	}
+	defer resp.Body.Close()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %v", path, err)
+	}
+
+	// Download next to the target and rename, so a failed download never
+	// leaves a truncated database behind
+	tmp := path + ".restore"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %v", tmp, err)
+	}
+	if _, err := io.Copy(out, resp.Body); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write %s: %v", tmp, err)
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	slog.InfoContext(ctx, "Restored metadata database", "bucket", target.Bucket, "key", metadataBackupKey)
+	return os.Rename(tmp, path)
+}
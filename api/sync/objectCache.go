@@ -0,0 +1,52 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"shiba-api/structs"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// CachedObject reads a generated file cached in R2 under key, e.g. a social
+// card. ok is false if nothing is cached there.
+func CachedObject(ctx context.Context, server structs.Server, key string) (data []byte, ok bool, err error) {
+	resp, err := server.S3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(os.Getenv("R2_BUCKET")),
+		Key:    aws.String(key),
+	})
+	var missing *types.NoSuchKey
+	if errors.As(err, &missing) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to download %s from R2: %v", key, err)
+	}
+	defer resp.Body.Close()
+	data, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to download %s from R2: %v", key, err)
+	}
+	return data, true, nil
+}
+
+// CacheObject stores a generated file in R2 under key.
+func CacheObject(ctx context.Context, server structs.Server, key, contentType string, data []byte) error {
+	_, err := server.S3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(os.Getenv("R2_BUCKET")),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String(contentType),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload %s to R2: %v", key, err)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package sync
+
+import (
+	"fmt"
+	"log/slog"
+	"shiba-api/recommend"
+	"shiba-api/structs"
+	"time"
+)
+
+const recommendationsDoc = "recommendations"
+
+// Recommendations kept per game
+const recommendationsPerGame = 20
+
+type RecommendationsState struct {
+	RefreshedAt time.Time                             `json:"refreshedAt"`
+	Games       map[string][]recommend.Recommendation `json:"games"`
+}
+
+func firstLink(fields map[string]any, name string) string {
+	if links, ok := fields[name].([]any); ok && len(links) > 0 {
+		if s, ok := links[0].(string); ok {
+			return s
+		}
+	}
+	return ""
+}
+
+// RefreshRecommendations recomputes co-play recommendations from the Airtable
+// Plays table and saves them to the store.
+func RefreshRecommendations(server structs.Server) error {
+	if server.AirtablePlaysTable == nil {
+		return fmt.Errorf("plays table is not configured")
+	}
+
+	var plays []recommend.Play
+	offset := ""
+	for {
+		records, err := server.AirtablePlaysTable.GetRecords().
+			ReturnFields("Game", "Player").
+			WithOffset(offset).
+			Do()
+		if err != nil {
+			return fmt.Errorf("failed to list plays: %v", err)
+		}
+		for _, r := range records.Records {
+			plays = append(plays, recommend.Play{
+				GameID:   firstLink(r.Fields, "Game"),
+				PlayerID: firstLink(r.Fields, "Player"),
+			})
+		}
+		if records.Offset == "" {
+			break
+		}
+		offset = records.Offset
+	}
+
+	state := RecommendationsState{
+		RefreshedAt: time.Now().UTC(),
+		Games:       recommend.Compute(plays, recommendationsPerGame),
+	}
+	if err := server.Store.Save(recommendationsDoc, state); err != nil {
+		return err
+	}
+
+	slog.Info("Recommendations refreshed", "plays", len(plays), "games", len(state.Games))
+	return nil
+}
+
+// LoadRecommendations returns the last computed recommendations.
+func LoadRecommendations(server structs.Server) (RecommendationsState, error) {
+	var state RecommendationsState
+	err := server.Store.Load(recommendationsDoc, &state)
+	return state, err
+}
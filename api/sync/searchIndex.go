@@ -0,0 +1,102 @@
+package sync
+
+import (
+	"fmt"
+	"log/slog"
+	"shiba-api/gamemeta"
+	"shiba-api/search"
+	"shiba-api/structs"
+	"strings"
+)
+
+func stringField(fields map[string]any, name string) string {
+	switch v := fields[name].(type) {
+	case string:
+		return v
+	case []any:
+		// Lookup fields come back as lists
+		parts := make([]string, 0, len(v))
+		for _, p := range v {
+			if s, ok := p.(string); ok {
+				parts = append(parts, s)
+			}
+		}
+		return strings.Join(parts, ", ")
+	}
+	return ""
+}
+
+func tagsField(fields map[string]any) []string {
+	var tags []string
+	for _, t := range strings.Split(stringField(fields, "Tags"), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
+
+// RebuildSearchIndex reloads every published game (one with a PlayLink) from
+// the Airtable Games table into the search index.
+func RebuildSearchIndex(server structs.Server, index *search.Index) error {
+	if server.AirtableGamesTable == nil {
+		return fmt.Errorf("games table is not configured")
+	}
+
+	meta, err := gamemeta.Load(server.Store)
+	if err != nil {
+		return fmt.Errorf("failed to load game metadata: %v", err)
+	}
+
+	var docs []search.Document
+	offset := ""
+	for {
+		records, err := server.AirtableGamesTable.GetRecords().
+			WithFilterFormula(`{PlayLink} != ""`).
+			ReturnFields("Name", "Description", "Tags", "Owner Name", "slack id", "PlayLink").
+			WithOffset(offset).
+			Do()
+		if err != nil {
+			return fmt.Errorf("failed to list games: %v", err)
+		}
+
+		for _, r := range records.Records {
+			creator := stringField(r.Fields, "Owner Name")
+			if creator == "" {
+				creator = stringField(r.Fields, "slack id")
+			}
+			m := meta.Projects[r.ID]
+			doc := search.Document{
+				ID:                r.ID,
+				Title:             stringField(r.Fields, "Name"),
+				Description:       stringField(r.Fields, "Description"),
+				Tags:              tagsField(r.Fields),
+				Creator:           creator,
+				PlayURL:           stringField(r.Fields, "PlayLink"),
+				Accessibility:     m.Accessibility.Features(),
+				ExpectsController: m.ExpectsController,
+				MobileCompatible:  m.MobileCompatible,
+			}
+			// Metadata edited through the API wins over the record
+			if m.Title != "" {
+				doc.Title = m.Title
+			}
+			if m.Description != "" {
+				doc.Description = m.Description
+			}
+			if len(m.Tags) > 0 {
+				doc.Tags = m.Tags
+			}
+			docs = append(docs, doc)
+		}
+
+		if records.Offset == "" {
+			break
+		}
+		offset = records.Offset
+	}
+
+	index.Replace(docs)
+	slog.Info("Search index rebuilt", "games", len(docs))
+	return nil
+}
@@ -0,0 +1,64 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+
+	"shiba-api/structs"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// exportBucket holds static site exports (EXPORT_BUCKET), the builds' bucket
+// by default. It must be in the same account, so files are copied within R2.
+func exportBucket() string {
+	if bucket := os.Getenv("EXPORT_BUCKET"); bucket != "" {
+		return bucket
+	}
+	return os.Getenv("R2_BUCKET")
+}
+
+// ExportGameFile copies one file of a build to key in the export bucket,
+// from wherever the build is: where it's served from, cold storage, or the
+// blob with hash sum if the build is content-addressed.
+func ExportGameFile(ctx context.Context, server structs.Server, gameID, file, sum, key string) error {
+	bucket := os.Getenv("R2_BUCKET")
+	sources := []string{gameKey("", gameID, file), gameKey(archivePrefix(), gameID, file)}
+	if sum != "" {
+		sources = append(sources, BlobKey(sum))
+	}
+	var err error
+	for _, from := range sources {
+		_, err = server.S3Client.CopyObject(ctx, &s3.CopyObjectInput{
+			Bucket:     aws.String(exportBucket()),
+			CopySource: aws.String(bucket + "/" + (&url.URL{Path: from}).EscapedPath()),
+			Key:        aws.String(key),
+		})
+		if !isNotFound(err) {
+			break
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to copy %s/%s to %s: %v", gameID, file, key, err)
+	}
+	return nil
+}
+
+// PutExportObject stores a generated file of a static site export under key
+// in the export bucket.
+func PutExportObject(ctx context.Context, server structs.Server, key, contentType string, data []byte) error {
+	_, err := server.S3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(exportBucket()),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String(contentType),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload %s to R2: %v", key, err)
+	}
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"shiba-api/structs"
@@ -21,11 +22,11 @@ func SyncFromR2(server structs.Server) error {
 	// Check if we're in a debug env and not syncing if so
 
 	if os.Getenv("DEBUG_ENV") == "true" {
-		fmt.Println("Skipping R2 sync in debug environment")
+		slog.Info("Skipping R2 sync in debug environment")
 		return nil
 	}
 
-	fmt.Println("Starting sync from R2 to local .games folder...")
+	slog.Info("Syncing games from R2")
 
 	keys, err := ListR2Objects(bucket, "games/", client)
 	if err != nil {
@@ -35,6 +36,7 @@ func SyncFromR2(server structs.Server) error {
 	// Group keys by game directory and validate each game has index.html
 	validGames := make(map[string]bool)
 	gameFiles := make(map[string][]string)
+	blobGames := make(map[string]bool)
 	
 	for _, key := range keys {
 		// Extract game ID from path like "games/gameId/file"
@@ -49,6 +51,12 @@ func SyncFromR2(server structs.Server) error {
 		if fileName == "index.html" {
 			validGames[gameId] = true
 		}
+
+		// Content-addressed games only have their blob index here
+		if key == gameKey("", gameId, BlobIndexFile) {
+			blobGames[gameId] = true
+			continue
+		}
 		
 		// Store all files for this game
 		if gameFiles[gameId] == nil {
@@ -57,14 +65,14 @@ func SyncFromR2(server structs.Server) error {
 		gameFiles[gameId] = append(gameFiles[gameId], key)
 	}
 
-	fmt.Printf("Found %d games, %d have index.html and will be synced\n", len(gameFiles), len(validGames))
+	slog.Info("Listed games in R2", "games", len(gameFiles), "with_index", len(validGames))
 
 	// Only sync files from games that have index.html
 	syncedCount := 0
 	skippedCount := 0
 	for gameId, files := range gameFiles {
 		if !validGames[gameId] {
-			fmt.Printf("Skipping game %s (no index.html found)\n", gameId)
+			slog.Debug("Skipping game without index.html", "game_id", gameId)
 			skippedCount++
 			continue
 		}
@@ -76,7 +84,7 @@ func SyncFromR2(server structs.Server) error {
 			}
 
 			if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
-				fmt.Printf("Failed to create directory for %s: %v\n", localPath, err)
+				slog.Error("Failed to create directory", "path", localPath, "error", err)
 				continue
 			}
 
@@ -85,14 +93,14 @@ func SyncFromR2(server structs.Server) error {
 				Key:    aws.String(key),
 			})
 			if err != nil {
-				fmt.Printf("Failed to download %s: %v\n", key, err)
+				slog.Error("Failed to download file from R2", "key", key, "error", err)
 				continue
 			}
 
 			outFile, err := os.Create(localPath)
 			if err != nil {
 				resp.Body.Close()
-				fmt.Printf("Failed to create local file %s: %v\n", localPath, err)
+				slog.Error("Failed to create file", "path", localPath, "error", err)
 				continue
 			}
 
@@ -100,16 +108,28 @@ func SyncFromR2(server structs.Server) error {
 			outFile.Close()
 			resp.Body.Close()
 			if err != nil {
-				fmt.Printf("Failed to write file %s: %v\n", localPath, err)
+				slog.Error("Failed to write file", "path", localPath, "error", err)
 				continue
 			}
 
-			fmt.Printf("Downloaded %s -> %s\n", key, localPath)
+			slog.Debug("Downloaded file from R2", "key", key, "path", localPath)
+		}
+		syncedCount++
+	}
+
+	for gameId := range blobGames {
+		index, err := LoadBlobIndex(context.Background(), server, gameId)
+		if err == nil && index != nil {
+			err = downloadBlobs(context.Background(), server, index, filepath.Join(localFolder, gameId))
+		}
+		if err != nil {
+			slog.Error("Failed to download content-addressed game from R2", "game_id", gameId, "error", err)
+			continue
 		}
 		syncedCount++
 	}
 
-	fmt.Printf("Sync complete! Synced %d valid games, skipped %d invalid games\n", syncedCount, skippedCount)
+	slog.Info("Synced games from R2", "synced", syncedCount, "skipped", skippedCount)
 	return nil
 }
 
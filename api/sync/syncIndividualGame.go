@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"shiba-api/structs"
@@ -21,7 +22,7 @@ func FetchGameFromR2(server *structs.Server, gameID string) error {
 	localPath := filepath.Join(localFolder, gameID)
 
 	if _, err := os.Stat(localPath); err == nil {
-		fmt.Printf("Game %s already exists locally at %s\n", gameID, localPath)
+		slog.Debug("Game already on disk", "game_id", gameID, "path", localPath)
 		return nil
 	}
 
@@ -49,6 +50,6 @@ func FetchGameFromR2(server *structs.Server, gameID string) error {
 		return fmt.Errorf("failed to write game %s to disk: %v", localPath, err)
 	}
 
-	fmt.Printf("Downloaded game %s -> %s\n", key, localPath)
+	slog.Info("Downloaded game from R2", "game_id", gameID, "key", key, "path", localPath)
 	return nil
 }
@@ -2,31 +2,109 @@ package sync
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
 	"os"
 	"path/filepath"
+	"shiba-api/faults"
+	"shiba-api/membudget"
 	"shiba-api/structs"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 )
 
-func UploadFolder(folderPath string, server structs.Server) error {
-	fmt.Println("Syncing folder:", folderPath)
+// folderFile is a file of a folder being synced.
+type folderFile struct {
+	path string
+	// rel is the file's path in the build
+	rel  string
+	key  string
+	size int64
+	// sha256 is set once a content-addressed file is hashed
+	sha256 string
+	// metadata labels the object, see ObjectLabels
+	metadata map[string]string
+}
+
+// Backoff between the attempts of a file: retryBaseDelay doubling each
+// attempt, plus up to half of that again as jitter, at most retryMaxDelay
+const (
+	retryBaseDelay = 500 * time.Millisecond
+	retryMaxDelay  = 15 * time.Second
+)
+
+// maxListedFailures caps the objects named in a SyncError's message
+const maxListedFailures = 20
+
+// SyncError is a sync that left files out of R2, after retries.
+type SyncError struct {
+	// Failed are the keys of the objects that could not be uploaded
+	Failed []string
+	Total  int
+	// Err is the last attempt's error of the first failed file
+	Err error
+}
+
+func (e *SyncError) Error() string {
+	listed := e.Failed
+	more := ""
+	if len(listed) > maxListedFailures {
+		more = fmt.Sprintf(" and %d more", len(listed)-maxListedFailures)
+		listed = listed[:maxListedFailures]
+	}
+	return fmt.Sprintf("%d of %d files could not be uploaded: %s%s: %v", len(e.Failed), e.Total,
+		strings.Join(listed, ", "), more, e.Err)
+}
+
+func (e *SyncError) Unwrap() error { return e.Err }
+
+// UploadFolder syncs a build's files to R2 under games/<folder name>/. Files
+// go up SyncWorkers at a time, largest first so a big .pck or .wasm doesn't
+// start last, and files larger than a part are sent as multipart uploads of
+// SyncPartSizeMB parts, SyncPartConcurrency at a time. A file that fails is
+// tried SyncRetries more times with backoff while the others carry on; files
+// that still fail are reported together in a *SyncError once the rest are up.
+//
+// With ContentAddressed, files are stored once as blobs named by their
+// hash instead, skipping those already in R2, and the build gets a blob
+// index listing them, see BlobIndex.
+//
+// Every object of the build is labeled with labels. Blobs aren't, as they
+// can be shared by builds of different users.
+func UploadFolder(ctx context.Context, folderPath string, server structs.Server, labels ObjectLabels) error {
+	slog.InfoContext(ctx, "Syncing folder to R2", "dir", folderPath)
+	start := time.Now()
 
 	// Check environment variables
 	bucket := os.Getenv("R2_BUCKET")
 	if bucket == "" {
 		return fmt.Errorf("R2_BUCKET environment variable is not set")
 	}
-	
-	fmt.Printf("Using bucket: %s\n", bucket)
-	fmt.Printf("S3 Client configured: %v\n", server.S3Client != nil)
 
-	uploader := manager.NewUploader(server.S3Client)
+	slog.DebugContext(ctx, "R2 target", "bucket", bucket, "client_configured", server.S3Client != nil)
+
+	cfg := server.Config.Get()
+	if cfg.ContentAddressed {
+		// Blobs found in R2 must not be collected before the index is up
+		blobSweep.RLock()
+		defer blobSweep.RUnlock()
+	}
+	uploader := manager.NewUploader(server.S3Client, func(u *manager.Uploader) {
+		u.PartSize = int64(cfg.SyncPartSizeMB) << 20
+		u.Concurrency = cfg.SyncPartConcurrency
+	})
 
+	var files []folderFile
+	var total int64
 	err := filepath.Walk(folderPath, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
@@ -34,49 +112,172 @@ func UploadFolder(folderPath string, server structs.Server) error {
 		if info.IsDir() {
 			return nil
 		}
-
-		f, err := os.Open(path)
+		relPath, err := filepath.Rel(folderPath, path)
 		if err != nil {
-			fmt.Printf("Failed to open file %s: %v\n", path, err)
-			return nil
+			return err
 		}
-		defer f.Close()
+		relPath = filepath.ToSlash(relPath)
+		key := "games/" + filepath.Base(folderPath) + "/" + relPath
+		files = append(files, folderFile{path: path, rel: relPath, key: key, size: info.Size(), metadata: labels.Metadata()})
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error walking folder: %v", err)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].size > files[j].size })
 
-		relPath, err := filepath.Rel(folderPath, path)
-		if err != nil {
-			fmt.Printf("Failed to get relative path for %s: %v\n", path, err)
-			return nil
+	queue := make(chan folderFile)
+	go func() {
+		defer close(queue)
+		for _, f := range files {
+			select {
+			case queue <- f:
+			case <-ctx.Done():
+				return
+			}
 		}
+	}()
 
-		s3Key := filepath.ToSlash("games/" + filepath.Base(folderPath) + "/" + relPath)
+	type result struct {
+		file    folderFile
+		deduped bool
+		err     error
+	}
+	workers := min(cfg.SyncWorkers, max(len(files), 1))
+	results := make(chan result)
+	done := make(chan struct{})
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			for f := range queue {
+				var res result
+				if cfg.ContentAddressed {
+					f, res.deduped, res.err = prepareBlob(ctx, server, bucket, f)
+				}
+				if res.err == nil && !res.deduped {
+					res.err = uploadWithRetries(ctx, uploader, bucket, f, cfg.SyncRetries)
+				}
+				res.file = f
+				results <- res
+			}
+		}()
+	}
+	go func() {
+		for i := 0; i < workers; i++ {
+			<-done
+		}
+		close(results)
+	}()
 
-		fmt.Printf("Attempting to upload %s to %s\n", path, s3Key)
-		
-		_, err = uploader.Upload(context.Background(), &s3.PutObjectInput{
-			Bucket: aws.String(bucket),
-			Key:    aws.String(s3Key),
-			Body:   f,
-		})
-		if err != nil {
-			fmt.Printf("Failed to upload %s to R2: %v\n", path, err)
-			// Check if it's an authentication error
-			if strings.Contains(err.Error(), "Unauthorized") || strings.Contains(err.Error(), "invalid or missing upload token") {
-				fmt.Printf("Authentication error detected. Please check R2 credentials:\n")
-				fmt.Printf("- R2_ACCESS_KEY_ID: %s\n", os.Getenv("R2_ACCESS_KEY_ID"))
-				fmt.Printf("- R2_SECRET_ACCESS_KEY: %s\n", os.Getenv("R2_SECRET_ACCESS_KEY"))
-				fmt.Printf("- R2_ENDPOINT: %s\n", os.Getenv("R2_ENDPOINT"))
-				fmt.Printf("- R2_BUCKET: %s\n", os.Getenv("R2_BUCKET"))
+	var failed []string
+	var firstErr error
+	var synced []folderFile
+	var deduped int
+	var dedupedBytes int64
+	for res := range results {
+		if res.err != nil {
+			failed = append(failed, res.file.key)
+			if firstErr == nil {
+				firstErr = res.err
 			}
-		} else {
-			fmt.Printf("Uploaded %s to R2 as %s\n", path, s3Key)
+			continue
 		}
-		return nil
+		synced = append(synced, res.file)
+		if res.deduped {
+			deduped++
+			dedupedBytes += res.file.size
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("sync interrupted: %v", err)
+	}
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return &SyncError{Failed: failed, Total: len(files), Err: firstErr}
+	}
+	if cfg.ContentAddressed {
+		if err := putBlobIndex(ctx, server, bucket, filepath.Base(folderPath), synced, labels, cfg.SyncRetries); err != nil {
+			return err
+		}
+		slog.InfoContext(ctx, "Deduplicated build files", "dir", folderPath, "files", deduped, "bytes", dedupedBytes)
+	}
+
+	slog.InfoContext(ctx, "Synced folder to R2", "dir", folderPath, "files", len(files), "bytes", total,
+		"duration", time.Since(start))
+	return nil
+}
+
+// uploadWithRetries uploads a file, trying again up to retries times while
+// the failure may be temporary.
+func uploadWithRetries(ctx context.Context, uploader *manager.Uploader, bucket string, file folderFile, retries int) error {
+	return withRetries(ctx, file.key, retries, func() error {
+		return uploadFile(ctx, uploader, bucket, file)
 	})
+}
+
+// withRetries runs fn, a request for the object key, trying again up to
+// retries times while the failure may be temporary.
+func withRetries(ctx context.Context, key string, retries int, fn func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || ctx.Err() != nil || attempt >= retries || !retryable(err) {
+			return err
+		}
+		wait := min(retryBaseDelay<<attempt, retryMaxDelay)
+		wait += time.Duration(rand.Int64N(int64(wait)/2 + 1))
+		slog.WarnContext(ctx, "Retrying R2 request", "key", key, "attempt", attempt+1, "wait", wait, "error", err)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
 
+// retryable reports whether an upload that failed with err may succeed when
+// tried again: R2 turning the request down, bad credentials included, won't.
+func retryable(err error) bool {
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) {
+		status := respErr.HTTPStatusCode()
+		return status >= 500 || status == http.StatusTooManyRequests || status == http.StatusRequestTimeout
+	}
+	return true
+}
+
+func uploadFile(ctx context.Context, uploader *manager.Uploader, bucket string, file folderFile) error {
+	f, err := os.Open(file.path)
 	if err != nil {
-		return fmt.Errorf("error walking folder: %v", err)
+		return fmt.Errorf("failed to open %s: %v", file.path, err)
 	}
+	defer f.Close()
 
-	fmt.Println("Sync complete for folder:", folderPath)
+	slog.DebugContext(ctx, "Uploading file to R2", "path", file.path, "key", file.key, "bytes", file.size)
+
+	// Multipart uploads buffer up to Concurrency parts
+	if file.size > uploader.PartSize {
+		defer membudget.Hold(ctx, min(file.size, uploader.PartSize*int64(uploader.Concurrency)))()
+	}
+
+	err = faults.Inject(ctx, faults.R2Timeout)
+	if err == nil {
+		_, err = uploader.Upload(ctx, &s3.PutObjectInput{
+			Bucket:   aws.String(bucket),
+			Key:      aws.String(file.key),
+			Body:     f,
+			Metadata: file.metadata,
+		})
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to upload file to R2", "path", file.path, "key", file.key, "error", err)
+		// Check if it's an authentication error
+		if strings.Contains(err.Error(), "Unauthorized") || strings.Contains(err.Error(), "invalid or missing upload token") {
+			slog.ErrorContext(ctx, "R2 rejected the credentials, check R2_ACCESS_KEY_ID and R2_SECRET_ACCESS_KEY",
+				"endpoint", os.Getenv("R2_ENDPOINT"), "bucket", bucket)
+		}
+		return fmt.Errorf("failed to upload %s: %w", file.key, err)
+	}
+	slog.DebugContext(ctx, "Uploaded file to R2", "path", file.path, "key", file.key)
 	return nil
 }
@@ -0,0 +1,322 @@
+// Package syncqueue keeps the R2 syncs of published builds in the store, so
+// a build whose sync was cut short by a crash or a deploy is synced when the
+// API starts again instead of never reaching the CDN. A failed sync is tried
+// again with backoff, MaxAttempts times in all.
+package syncqueue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"shiba-api/store"
+)
+
+const (
+	StatusPending   = "pending"
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+const (
+	storeDoc = "sync-jobs"
+	// Syncs that keep failing are given up on after this many attempts
+	MaxAttempts = 5
+	// Wait before the second attempt, doubling for each one after it
+	retryDelay = time.Minute
+	// Succeeded jobs are forgotten after this long
+	retention = 7 * 24 * time.Hour
+)
+
+// ErrNotFound is returned for a game without a sync job.
+var ErrNotFound = errors.New("no sync job for this game")
+
+// Job is the sync of one build to R2.
+type Job struct {
+	GameID        string     `json:"gameId"`
+	Dir           string     `json:"dir"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	Error         string     `json:"error,omitempty"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	UpdatedAt     time.Time  `json:"updatedAt"`
+	NextAttemptAt *time.Time `json:"nextAttemptAt,omitempty"`
+}
+
+type state struct {
+	Jobs map[string]*Job `json:"jobs"`
+}
+
+// SyncFunc uploads the build of gameID in dir to R2.
+type SyncFunc func(ctx context.Context, gameID, dir string) error
+
+// Queue runs the sync jobs recorded in the store, workers at a time. Run
+// must be running for jobs to be synced.
+type Queue struct {
+	store   store.Store
+	workers int
+	sync    SyncFunc
+
+	mu sync.Mutex
+	// Contexts of the requests that queued jobs in this process, so their
+	// syncs keep the trace and request ID
+	ctxs    map[string]context.Context
+	waiters map[string][]chan error
+	wake    chan struct{}
+}
+
+// New returns a queue running sync workers syncs at a time.
+func New(st store.Store, workers int, sync SyncFunc) *Queue {
+	return &Queue{
+		store:   st,
+		workers: workers,
+		sync:    sync,
+		ctxs:    map[string]context.Context{},
+		waiters: map[string][]chan error{},
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// Enqueue records a pending sync of the build of gameID in dir, replacing
+// any earlier job of the game, and returns a channel receiving the outcome
+// of its next attempt; a failed sync is still retried after that. ctx is
+// used for the attempts unless the API restarts first, so it should outlive
+// the request.
+func (q *Queue) Enqueue(ctx context.Context, gameID, dir string) (<-chan error, error) {
+	now := time.Now().UTC()
+	var s state
+	err := q.store.Update(storeDoc, &s, func() error {
+		if s.Jobs == nil {
+			s.Jobs = map[string]*Job{}
+		}
+		prune(&s, now)
+		s.Jobs[gameID] = &Job{GameID: gameID, Dir: dir, Status: StatusPending, CreatedAt: now, UpdatedAt: now}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to queue sync: %v", err)
+	}
+
+	done := make(chan error, 1)
+	q.mu.Lock()
+	q.ctxs[gameID] = ctx
+	q.waiters[gameID] = append(q.waiters[gameID], done)
+	q.mu.Unlock()
+	q.signal()
+	return done, nil
+}
+
+// Retry queues a failed sync again, with its attempts reset.
+func (q *Queue) Retry(gameID string) (Job, error) {
+	var job Job
+	var s state
+	err := q.store.Update(storeDoc, &s, func() error {
+		j, ok := s.Jobs[gameID]
+		if !ok {
+			return ErrNotFound
+		}
+		if j.Status == StatusFailed || j.Status == StatusSucceeded {
+			j.Status = StatusPending
+			j.Attempts = 0
+			j.Error = ""
+			j.NextAttemptAt = nil
+			j.UpdatedAt = time.Now().UTC()
+		}
+		job = *j
+		return nil
+	})
+	if err == nil {
+		q.signal()
+	}
+	return job, err
+}
+
+// Jobs returns the recorded jobs, newest first, only those with status if
+// it's set.
+func (q *Queue) Jobs(status string) ([]Job, error) {
+	var s state
+	if err := q.store.Load(storeDoc, &s); err != nil {
+		return nil, err
+	}
+	jobs := []Job{}
+	for _, j := range s.Jobs {
+		if status == "" || j.Status == status {
+			jobs = append(jobs, *j)
+		}
+	}
+	sort.Slice(jobs, func(i, k int) bool { return jobs[i].CreatedAt.After(jobs[k].CreatedAt) })
+	return jobs, nil
+}
+
+func (q *Queue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run syncs pending jobs until ctx is done. Jobs left running by a previous
+// process are pending again first.
+func (q *Queue) Run(ctx context.Context) {
+	var s state
+	err := q.store.Update(storeDoc, &s, func() error {
+		for _, j := range s.Jobs {
+			if j.Status == StatusRunning {
+				j.Status = StatusPending
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Error("Failed to recover sync jobs", "error", err)
+	} else if jobs, err := q.Jobs(StatusPending); err == nil && len(jobs) > 0 {
+		slog.Info("Resuming incomplete syncs", "jobs", len(jobs))
+	}
+
+	slots := make(chan struct{}, q.workers)
+	for {
+		wait := time.Minute
+		for {
+			job, next, err := q.claim()
+			if err != nil {
+				slog.Error("Failed to claim sync job", "error", err)
+				break
+			}
+			if job == nil {
+				if next > 0 {
+					wait = min(wait, next)
+				}
+				break
+			}
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			go func() {
+				defer func() { <-slots }()
+				q.run(ctx, job)
+				q.signal()
+			}()
+		}
+
+		select {
+		case <-q.wake:
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// claim marks the oldest due pending job running and returns it. Without
+// one, it returns how long until the next retry is due, if any.
+func (q *Queue) claim() (*Job, time.Duration, error) {
+	now := time.Now().UTC()
+	var claimed *Job
+	var next time.Duration
+	var s state
+	err := q.store.Update(storeDoc, &s, func() error {
+		for _, j := range s.Jobs {
+			if j.Status != StatusPending {
+				continue
+			}
+			if j.NextAttemptAt != nil && j.NextAttemptAt.After(now) {
+				if d := j.NextAttemptAt.Sub(now); next == 0 || d < next {
+					next = d
+				}
+				continue
+			}
+			if claimed == nil || j.CreatedAt.Before(claimed.CreatedAt) {
+				claimed = j
+			}
+		}
+		if claimed == nil {
+			return nil
+		}
+		claimed.Status = StatusRunning
+		claimed.Attempts++
+		claimed.UpdatedAt = now
+		job := *claimed
+		claimed = &job
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return claimed, next, nil
+}
+
+// run syncs a claimed job and records the outcome.
+func (q *Queue) run(ctx context.Context, job *Job) {
+	q.mu.Lock()
+	syncCtx, ok := q.ctxs[job.GameID]
+	q.mu.Unlock()
+	if !ok {
+		syncCtx = ctx
+	}
+	syncErr := q.sync(syncCtx, job.GameID, job.Dir)
+
+	now := time.Now().UTC()
+	finished, superseded := true, false
+	var s state
+	err := q.store.Update(storeDoc, &s, func() error {
+		j, ok := s.Jobs[job.GameID]
+		// The game was queued again while this attempt ran
+		if !ok || !j.CreatedAt.Equal(job.CreatedAt) {
+			superseded = true
+			return nil
+		}
+		j.UpdatedAt = now
+		j.NextAttemptAt = nil
+		switch {
+		case syncErr == nil:
+			j.Status = StatusSucceeded
+			j.Error = ""
+		case j.Attempts >= MaxAttempts:
+			j.Status = StatusFailed
+			j.Error = syncErr.Error()
+		default:
+			j.Status = StatusPending
+			j.Error = syncErr.Error()
+			at := now.Add(retryDelay << (j.Attempts - 1))
+			j.NextAttemptAt = &at
+			finished = false
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Error("Failed to record sync outcome", "game_id", job.GameID, "error", err)
+	}
+	if superseded {
+		return
+	}
+	if !finished {
+		slog.Warn("Sync failed, retrying later", "game_id", job.GameID, "attempt", job.Attempts, "error", syncErr)
+	}
+
+	q.mu.Lock()
+	waiters := q.waiters[job.GameID]
+	delete(q.waiters, job.GameID)
+	if finished {
+		delete(q.ctxs, job.GameID)
+	}
+	q.mu.Unlock()
+	for _, done := range waiters {
+		done <- syncErr
+	}
+}
+
+// prune forgets succeeded jobs past the retention.
+func prune(s *state, now time.Time) {
+	for id, j := range s.Jobs {
+		if j.Status == StatusSucceeded && now.Sub(j.UpdatedAt) > retention {
+			delete(s.Jobs, id)
+		}
+	}
+}
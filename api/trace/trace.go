@@ -0,0 +1,151 @@
+// Package trace follows a request through the upload pipeline with W3C Trace
+// Context. A client may send a traceparent header to make the pipeline part
+// of its own trace; otherwise a new trace starts. Spans are written to the
+// log as one structured line each when they end, so a trace ID from a bug
+// report is enough to grep every step of the upload it belongs to.
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// Header carries the trace context, in requests and responses
+const Header = "traceparent"
+
+// SpanContext identifies a span within a trace.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+func (sc SpanContext) TraceIDString() string { return hex.EncodeToString(sc.TraceID[:]) }
+func (sc SpanContext) SpanIDString() string  { return hex.EncodeToString(sc.SpanID[:]) }
+
+// String formats sc as a traceparent header value.
+func (sc SpanContext) String() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceIDString() + "-" + sc.SpanIDString() + "-" + flags
+}
+
+func isLowerHex(s string) bool {
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// Parse reads a traceparent header. Per the spec, anything invalid, including
+// all-zero IDs, is ignored rather than rejected: the request starts a new
+// trace. Versions above 00 are read as 00.
+func Parse(header string) (SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 {
+		return SpanContext{}, false
+	}
+	version, traceID, spanID, flags := parts[0], parts[1], parts[2], parts[3]
+	if len(version) != 2 || !isLowerHex(version) || version == "ff" ||
+		(version == "00" && len(parts) != 4) ||
+		len(traceID) != 32 || !isLowerHex(traceID) ||
+		len(spanID) != 16 || !isLowerHex(spanID) ||
+		len(flags) != 2 || !isLowerHex(flags) {
+		return SpanContext{}, false
+	}
+
+	var sc SpanContext
+	hex.Decode(sc.TraceID[:], []byte(traceID))
+	hex.Decode(sc.SpanID[:], []byte(spanID))
+	if sc.TraceID == [16]byte{} || sc.SpanID == [8]byte{} {
+		return SpanContext{}, false
+	}
+	f, _ := hex.DecodeString(flags)
+	sc.Sampled = f[0]&1 == 1
+	return sc, true
+}
+
+type contextKey struct{}
+
+// WithRemote makes the span of an incoming traceparent the parent of the
+// spans started from ctx.
+func WithRemote(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, contextKey{}, sc)
+}
+
+// FromContext returns the span spans started from ctx would be children of.
+func FromContext(ctx context.Context) (SpanContext, bool) {
+	sc, ok := ctx.Value(contextKey{}).(SpanContext)
+	return sc, ok
+}
+
+// ID returns the trace ID of ctx, or "" outside a trace.
+func ID(ctx context.Context) string {
+	if sc, ok := FromContext(ctx); ok {
+		return sc.TraceIDString()
+	}
+	return ""
+}
+
+// Span is one step of a trace.
+type Span struct {
+	ctx    context.Context
+	name   string
+	sc     SpanContext
+	parent string
+	start  time.Time
+	attrs  []any
+	ended  bool
+}
+
+// Start begins a span as a child of the one in ctx, or of a new trace, and
+// returns a ctx carrying it.
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	span := &Span{name: name, start: time.Now()}
+	if parent, ok := FromContext(ctx); ok {
+		span.sc.TraceID = parent.TraceID
+		span.sc.Sampled = parent.Sampled
+		span.parent = parent.SpanIDString()
+	} else {
+		rand.Read(span.sc.TraceID[:])
+		span.sc.Sampled = true
+	}
+	rand.Read(span.sc.SpanID[:])
+	span.ctx = context.WithValue(ctx, contextKey{}, span.sc)
+	return span.ctx, span
+}
+
+func (s *Span) Context() SpanContext { return s.sc }
+
+// Set adds an attribute logged with the span.
+func (s *Span) Set(key, value string) {
+	s.attrs = append(s.attrs, key, value)
+}
+
+// End logs the span with its duration and, if it failed, err. Only the first
+// call counts.
+func (s *Span) End(err error) {
+	if s.ended {
+		return
+	}
+	s.ended = true
+
+	args := []any{"trace_id", s.sc.TraceIDString(), "span_id", s.sc.SpanIDString()}
+	if s.parent != "" {
+		args = append(args, "parent_id", s.parent)
+	}
+	args = append(args, "name", s.name, "duration", time.Since(s.start).Round(time.Microsecond))
+	args = append(args, s.attrs...)
+	if err != nil {
+		args = append(args, "error", err.Error())
+	}
+	slog.InfoContext(s.ctx, "span", args...)
+}
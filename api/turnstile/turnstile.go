@@ -0,0 +1,64 @@
+// Package turnstile checks Cloudflare Turnstile tokens, proving a request
+// comes from a person who solved the challenge on the frontend.
+package turnstile
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// FormField is the form field the Turnstile widget puts its token in
+const FormField = "cf-turnstile-response"
+
+const verifyURL = "https://challenges.cloudflare.com/turnstile/v0/siteverify"
+
+var client = &http.Client{Timeout: 10 * time.Second}
+
+// ErrRejected is returned for tokens Turnstile didn't accept: missing,
+// expired, already used or failed.
+var ErrRejected = errors.New("turnstile challenge failed")
+
+// Verify checks token with Turnstile using the site's secret key. remoteIP
+// is the client's address, empty if unknown.
+func Verify(ctx context.Context, secret, token, remoteIP string) error {
+	if token == "" {
+		return ErrRejected
+	}
+	form := url.Values{"secret": {secret}, "response": {token}}
+	if remoteIP != "" {
+		form.Set("remoteip", remoteIP)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, verifyURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach Turnstile: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Success    bool     `json:"success"`
+		ErrorCodes []string `json:"error-codes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("failed to decode Turnstile response: %v", err)
+	}
+	if !result.Success {
+		codes := strings.Join(result.ErrorCodes, ", ")
+		if strings.Contains(codes, "input-secret") {
+			// Our fault, not the client's
+			return fmt.Errorf("turnstile secret key rejected: %s", codes)
+		}
+		return fmt.Errorf("%w: %s", ErrRejected, codes)
+	}
+	return nil
+}
@@ -0,0 +1,353 @@
+// Package users keeps a local read replica of the Airtable Users table, so
+// authentication and profile lookups keep working while Airtable is slow,
+// rate limited or down. Airtable stays the source of truth: the replica is
+// refreshed incrementally from records modified since the last sync, and in
+// full from time to time to catch deleted users.
+package users
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"shiba-api/store"
+
+	"github.com/mehanizm/airtable"
+)
+
+const replicaDoc = "users-replica"
+
+// How far incremental syncs look back before the previous one started, to
+// absorb clock skew between the API and Airtable
+const syncOverlap = time.Minute
+
+// Fields of the Users table holding a user's account token: its hash, or the
+// token itself on records from before tokens were hashed
+const (
+	TokenHashField   = "tokenHash"
+	LegacyTokenField = "token"
+)
+
+var pepper []byte
+
+// SetPepper sets the secret mixed into token hashes (TOKEN_PEPPER). It must
+// be set before the first lookup and never change: every stored hash depends
+// on it.
+func SetPepper(p []byte) {
+	pepper = p
+}
+
+// HashToken is how account tokens are stored and indexed: the HMAC-SHA256 of
+// the token keyed with the pepper, or its plain SHA-256 without one. Tokens
+// are never kept in the clear.
+func HashToken(token string) string {
+	if len(pepper) == 0 {
+		sum := sha256.Sum256([]byte(token))
+		return hex.EncodeToString(sum[:])
+	}
+	mac := hmac.New(sha256.New, pepper)
+	mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Record is the replica of one user.
+type Record struct {
+	ID          string         `json:"id"`
+	CreatedTime string         `json:"createdTime,omitempty"`
+	Fields      map[string]any `json:"fields"`
+	TokenHash   string         `json:"tokenHash,omitempty"`
+	// SeenAt is when Airtable last returned the record
+	SeenAt time.Time `json:"seenAt"`
+	// Local holds fields the API wrote itself (e.g. a new token hash) and
+	// LocalAt when, until a sync started after that confirms them
+	Local   map[string]any `json:"local,omitempty"`
+	LocalAt time.Time      `json:"localAt,omitempty"`
+}
+
+func copyFields(fields map[string]any) map[string]any {
+	if fields == nil {
+		return nil
+	}
+	copied := make(map[string]any, len(fields))
+	for k, v := range fields {
+		copied[k] = v
+	}
+	return copied
+}
+
+func (rec *Record) airtable() *airtable.Record {
+	return &airtable.Record{ID: rec.ID, CreatedTime: rec.CreatedTime, Fields: copyFields(rec.Fields)}
+}
+
+type replicaState struct {
+	Users      map[string]*Record `json:"users"`
+	LastSync   time.Time          `json:"lastSync,omitempty"`
+	LastFull   time.Time          `json:"lastFull,omitempty"`
+	SyncErrors int                `json:"syncErrors,omitempty"`
+}
+
+// Status describes how fresh the replica is.
+type Status struct {
+	Users    int       `json:"users"`
+	LastSync time.Time `json:"lastSync,omitempty"`
+	LastFull time.Time `json:"lastFull,omitempty"`
+	// SyncErrors counts failed syncs since the last successful one
+	SyncErrors int `json:"syncErrors"`
+}
+
+// Report is the outcome of one sync.
+type Report struct {
+	Full    bool `json:"full"`
+	Updated int  `json:"updated"`
+	Deleted int  `json:"deleted"`
+	Users   int  `json:"users"`
+}
+
+// Replica is the local copy of the Users table.
+type Replica struct {
+	store store.Store
+	table *airtable.Table
+
+	// syncMu serializes syncs; mu guards the state
+	syncMu  sync.Mutex
+	mu      sync.RWMutex
+	state   replicaState
+	byToken map[string]string
+}
+
+func NewReplica(st store.Store, table *airtable.Table) *Replica {
+	return &Replica{
+		store:   st,
+		table:   table,
+		state:   replicaState{Users: map[string]*Record{}},
+		byToken: map[string]string{},
+	}
+}
+
+// Load restores the replica saved by the last sync, so lookups work right
+// after a restart even if Airtable is down.
+func (r *Replica) Load() error {
+	var state replicaState
+	if err := r.store.Load(replicaDoc, &state); err != nil {
+		return err
+	}
+	if state.Users == nil {
+		state.Users = map[string]*Record{}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.state = state
+	r.reindex()
+	return nil
+}
+
+// reindex rebuilds the token index. Callers hold mu.
+func (r *Replica) reindex() {
+	r.byToken = make(map[string]string, len(r.state.Users))
+	for id, rec := range r.state.Users {
+		if rec.TokenHash != "" {
+			r.byToken[rec.TokenHash] = id
+		}
+	}
+}
+
+// Ready reports whether the replica completed a full sync, so a missing user
+// is really missing rather than not synced yet.
+func (r *Replica) Ready() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return !r.state.LastFull.IsZero()
+}
+
+// Status reports the replica's size and freshness.
+func (r *Replica) Status() Status {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return Status{
+		Users:      len(r.state.Users),
+		LastSync:   r.state.LastSync,
+		LastFull:   r.state.LastFull,
+		SyncErrors: r.state.SyncErrors,
+	}
+}
+
+// ByToken finds the user owning token.
+func (r *Replica) ByToken(token string) (*airtable.Record, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	id, ok := r.byToken[HashToken(token)]
+	if !ok {
+		return nil, false
+	}
+	return r.state.Users[id].airtable(), true
+}
+
+// ByID finds a user by record ID.
+func (r *Replica) ByID(id string) (*airtable.Record, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	rec, ok := r.state.Users[id]
+	if !ok {
+		return nil, false
+	}
+	return rec.airtable(), true
+}
+
+// apply merges a record fetched from Airtable by a sync that started at
+// started. Airtable wins, except for fields the API wrote after the sync
+// started: the fetch may predate those writes. Callers hold mu.
+func (r *Replica) apply(fetched *airtable.Record, started time.Time) {
+	fields := copyFields(fetched.Fields)
+	if fields == nil {
+		fields = map[string]any{}
+	}
+	rec := &Record{ID: fetched.ID, CreatedTime: fetched.CreatedTime, Fields: fields, SeenAt: started}
+	rec.TokenHash, _ = fields[TokenHashField].(string)
+	if token, _ := fields[LegacyTokenField].(string); token != "" && rec.TokenHash == "" {
+		rec.TokenHash = HashToken(token)
+	}
+	delete(fields, TokenHashField)
+	delete(fields, LegacyTokenField)
+
+	if old, ok := r.state.Users[fetched.ID]; ok {
+		if old.TokenHash != "" {
+			delete(r.byToken, old.TokenHash)
+		}
+		if !old.LocalAt.IsZero() && !old.LocalAt.Before(started) {
+			rec.Local, rec.LocalAt = old.Local, old.LocalAt
+			for k, v := range old.Local {
+				if k == TokenHashField {
+					rec.TokenHash, _ = v.(string)
+				} else {
+					fields[k] = v
+				}
+			}
+		}
+	}
+	r.state.Users[rec.ID] = rec
+	if rec.TokenHash != "" {
+		r.byToken[rec.TokenHash] = rec.ID
+	}
+}
+
+// Put stores a record fetched from Airtable outside a sync, e.g. a user who
+// signed up since the last one.
+func (r *Replica) Put(fetched *airtable.Record) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.apply(fetched, time.Now())
+}
+
+// SetFields records a write the API made to a user's Airtable record, so
+// lookups see it before the next sync. A TokenHashField replaces the token;
+// the legacy token field is never kept.
+func (r *Replica) SetFields(id string, fields map[string]any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rec, ok := r.state.Users[id]
+	if !ok {
+		return
+	}
+	if rec.Local == nil {
+		rec.Local = map[string]any{}
+	}
+	for k, v := range fields {
+		switch k {
+		case LegacyTokenField:
+			continue
+		case TokenHashField:
+			if rec.TokenHash != "" {
+				delete(r.byToken, rec.TokenHash)
+			}
+			rec.TokenHash = fmt.Sprint(v)
+			r.byToken[rec.TokenHash] = id
+			rec.Local[k] = rec.TokenHash
+			continue
+		}
+		rec.Fields[k] = v
+		rec.Local[k] = v
+	}
+	rec.LocalAt = time.Now()
+}
+
+// Sync refreshes the replica from Airtable: records modified since the last
+// sync or, when full is set or no full sync ran yet, every record, dropping
+// users deleted from Airtable. The result is saved to the store.
+func (r *Replica) Sync(ctx context.Context, full bool) (Report, error) {
+	r.syncMu.Lock()
+	defer r.syncMu.Unlock()
+
+	r.mu.RLock()
+	since := r.state.LastSync
+	full = full || r.state.LastFull.IsZero()
+	r.mu.RUnlock()
+
+	started := time.Now().UTC()
+	call := r.table.GetRecords()
+	if !full {
+		call = call.WithFilterFormula(fmt.Sprintf(`IS_AFTER(LAST_MODIFIED_TIME(), DATETIME_PARSE("%s"))`,
+			since.Add(-syncOverlap).Format(time.RFC3339)))
+	}
+
+	var fetched []*airtable.Record
+	offset := ""
+	for {
+		if err := ctx.Err(); err != nil {
+			return Report{}, err
+		}
+		records, err := call.WithOffset(offset).Do()
+		if err != nil {
+			r.mu.Lock()
+			r.state.SyncErrors++
+			r.mu.Unlock()
+			return Report{}, fmt.Errorf("failed to list users: %v", err)
+		}
+		fetched = append(fetched, records.Records...)
+		if records.Offset == "" {
+			break
+		}
+		offset = records.Offset
+	}
+
+	report := Report{Full: full, Updated: len(fetched)}
+	r.mu.Lock()
+	seen := make(map[string]bool, len(fetched))
+	for _, rec := range fetched {
+		r.apply(rec, started)
+		seen[rec.ID] = true
+	}
+	if full {
+		// Records put since the listing started may be missing from it
+		for id, rec := range r.state.Users {
+			if !seen[id] && rec.SeenAt.Before(started) {
+				delete(r.state.Users, id)
+				report.Deleted++
+			}
+		}
+		r.reindex()
+		r.state.LastFull = started
+	}
+	r.state.LastSync = started
+	r.state.SyncErrors = 0
+	report.Users = len(r.state.Users)
+	snapshot := replicaState{
+		Users:    make(map[string]*Record, len(r.state.Users)),
+		LastSync: r.state.LastSync,
+		LastFull: r.state.LastFull,
+	}
+	for id, rec := range r.state.Users {
+		copied := *rec
+		copied.Fields, copied.Local = copyFields(rec.Fields), copyFields(rec.Local)
+		snapshot.Users[id] = &copied
+	}
+	r.mu.Unlock()
+
+	if err := r.store.Save(replicaDoc, snapshot); err != nil {
+		return report, fmt.Errorf("failed to save users replica: %v", err)
+	}
+	return report, nil
+}
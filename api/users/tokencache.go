@@ -0,0 +1,88 @@
+package users
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// How long a token keeps resolving to its user without a lookup
+	TokenTTL = time.Minute
+	// How long an unknown token is rejected without a lookup
+	UnknownTokenTTL = 30 * time.Second
+	// Entries kept at most, so a flood of made-up tokens can't grow the
+	// cache without bound
+	maxCachedTokens = 100000
+)
+
+type tokenEntry struct {
+	// userID is empty for unknown tokens
+	userID  string
+	expires time.Time
+	// forgotten is set on tombstones left by Forget
+	forgotten time.Time
+}
+
+// TokenCache remembers which user a token (account or session, keyed by its
+// hash) belongs to, and which tokens are unknown, so repeated requests with
+// the same token don't repeat the lookup.
+type TokenCache struct {
+	mu      sync.Mutex
+	entries map[string]tokenEntry
+}
+
+func NewTokenCache() *TokenCache {
+	return &TokenCache{entries: map[string]tokenEntry{}}
+}
+
+// Get returns the user ID cached for hash; an empty ID with hit set means the
+// token is unknown.
+func (c *TokenCache) Get(hash string, now time.Time) (userID string, hit bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[hash]
+	if !ok || !e.forgotten.IsZero() || now.After(e.expires) {
+		return "", false
+	}
+	return e.userID, true
+}
+
+// Remember caches the result of a lookup that started at started. Lookups
+// that started before the token was forgotten are dropped: they may have
+// seen it before it was revoked.
+func (c *TokenCache) Remember(hash, userID string, started time.Time) {
+	ttl := TokenTTL
+	if userID == "" {
+		ttl = UnknownTokenTTL
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[hash]; ok && !e.forgotten.IsZero() && !started.After(e.forgotten) {
+		return
+	}
+	if len(c.entries) >= maxCachedTokens {
+		c.prune(started)
+	}
+	c.entries[hash] = tokenEntry{userID: userID, expires: started.Add(ttl)}
+}
+
+// Forget drops hash, e.g. after the token was rotated or revoked.
+func (c *TokenCache) Forget(hash string, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[hash] = tokenEntry{forgotten: now, expires: now.Add(TokenTTL)}
+}
+
+// prune drops expired entries, or everything if that isn't enough. Callers
+// hold mu.
+func (c *TokenCache) prune(now time.Time) {
+	for hash, e := range c.entries {
+		if now.After(e.expires) {
+			delete(c.entries, hash)
+		}
+	}
+	if len(c.entries) >= maxCachedTokens {
+		c.entries = map[string]tokenEntry{}
+	}
+}
@@ -0,0 +1,69 @@
+package validate
+
+import (
+	"archive/zip"
+	"fmt"
+	"strings"
+)
+
+// Limits on the archives of an upload, checked before anything is extracted
+const (
+	// MaxArchiveEntries caps the files and directories of a build
+	MaxArchiveEntries = 20000
+	// MaxCompressionRatio caps how much larger than its compressed size a
+	// file may be, to turn away zip bombs. Files under ratioMinBytes are
+	// exempt, as tiny files of repeated bytes compress well legitimately.
+	MaxCompressionRatio = 200
+	ratioMinBytes       = 1 << 20
+)
+
+// Codes of the archive limits
+const (
+	CodeTooManyEntries        = "too_many_entries"
+	CodeBuildTooLarge         = "build_too_large"
+	CodeSuspiciousCompression = "suspicious_compression"
+)
+
+// ArchiveError is an archive over one of the limits.
+type ArchiveError struct {
+	Code string
+	Msg  string
+}
+
+func (e *ArchiveError) Error() string { return e.Msg }
+
+// CheckArchive checks the entries of a build's archives, data packs
+// included, against the limits: at most MaxArchiveEntries entries, maxBytes
+// uncompressed between them (0 for no limit) and no file compressed more than
+// MaxCompressionRatio times. macOS junk is left out, as it's never extracted.
+// The CLI runs the same checks before uploading.
+func CheckArchive(files []*zip.File, maxBytes int64) error {
+	var entries int
+	var total uint64
+	for _, f := range files {
+		if strings.HasPrefix(f.Name, "__MACOSX/") {
+			continue
+		}
+		entries++
+		total += f.UncompressedSize64
+		if f.UncompressedSize64 >= ratioMinBytes && f.UncompressedSize64 > f.CompressedSize64*MaxCompressionRatio {
+			return &ArchiveError{
+				Code: CodeSuspiciousCompression,
+				Msg:  fmt.Sprintf("Build rejected: %s is compressed more than %dx, which looks like a zip bomb", f.Name, MaxCompressionRatio),
+			}
+		}
+	}
+	if entries > MaxArchiveEntries {
+		return &ArchiveError{
+			Code: CodeTooManyEntries,
+			Msg:  fmt.Sprintf("Build rejected: %d files is over the limit of %d", entries, MaxArchiveEntries),
+		}
+	}
+	if maxBytes > 0 && total > uint64(maxBytes) {
+		return &ArchiveError{
+			Code: CodeBuildTooLarge,
+			Msg:  fmt.Sprintf("Build rejected: %d MB extracted is over the limit of %d MB", (total+1<<20-1)>>20, maxBytes>>20),
+		}
+	}
+	return nil
+}
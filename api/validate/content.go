@@ -0,0 +1,139 @@
+// Package validate inspects extracted builds before they are published and
+// reports every problem it finds, so creators can fix them in one go.
+package validate
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Rules a file can break
+const (
+	RuleDoubleExtension = "double_extension"
+	RuleContentMismatch = "content_mismatch"
+	RuleServerScript    = "server_script"
+)
+
+// Finding is one problem with one file of a build.
+type Finding struct {
+	Path   string `json:"path"`
+	Rule   string `json:"rule"`
+	Detail string `json:"detail"`
+}
+
+// HTML files are scanned for server-side code up to this size
+const maxScanBytes = 10 << 20
+
+// Extensions that run code outside the browser. Hiding one behind a
+// harmless-looking extension (game.html.exe) is a classic way to trick
+// players into running it.
+var executableExts = map[string]bool{
+	"exe": true, "com": true, "scr": true, "msi": true, "bat": true, "cmd": true,
+	"ps1": true, "vbs": true, "vbe": true, "jse": true, "wsf": true, "hta": true,
+	"lnk": true, "reg": true, "dll": true, "jar": true, "sh": true, "bash": true,
+	"php": true, "phtml": true, "phar": true, "cgi": true, "pl": true, "py": true,
+}
+
+// Extensions people expect to be safe to open
+var decoyExts = map[string]bool{
+	"html": true, "htm": true, "js": true, "css": true, "json": true, "txt": true,
+	"pdf": true, "png": true, "jpg": true, "jpeg": true, "gif": true, "webp": true,
+	"svg": true, "mp3": true, "ogg": true, "wav": true, "mp4": true, "zip": true,
+	"doc": true, "docx": true, "wasm": true, "pck": true,
+}
+
+// expectedTypes maps an extension to the sniffed MIME prefix its content must
+// have. Extensions not listed aren't sniffed.
+var expectedTypes = map[string]string{
+	".html": "text/",
+	".htm":  "text/",
+	".js":   "text/",
+	".css":  "text/",
+	".json": "text/",
+	".png":  "image/",
+	".jpg":  "image/",
+	".jpeg": "image/",
+	".gif":  "image/",
+	".webp": "image/",
+	".wasm": "application/wasm",
+}
+
+func checkName(rel string) *Finding {
+	parts := strings.Split(strings.ToLower(filepath.Base(rel)), ".")
+	if len(parts) < 3 {
+		return nil
+	}
+	last, prev := parts[len(parts)-1], parts[len(parts)-2]
+	if executableExts[last] && decoyExts[prev] {
+		return &Finding{rel, RuleDoubleExtension, fmt.Sprintf(".%s file disguised as .%s", last, prev)}
+	}
+	return nil
+}
+
+func checkContent(path, rel string) ([]Finding, error) {
+	ext := strings.ToLower(filepath.Ext(rel))
+	want, sniffed := expectedTypes[ext]
+	if !sniffed {
+		return nil, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	limit := int64(512)
+	if ext == ".html" || ext == ".htm" {
+		limit = maxScanBytes
+	}
+	data, err := io.ReadAll(io.LimitReader(f, limit))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	var findings []Finding
+	if got := http.DetectContentType(data); !strings.HasPrefix(got, want) {
+		findings = append(findings, Finding{rel, RuleContentMismatch, fmt.Sprintf("%s file contains %s", ext, got)})
+	}
+	if (ext == ".html" || ext == ".htm") && bytes.Contains(bytes.ToLower(data), []byte("<?php")) {
+		findings = append(findings, Finding{rel, RuleServerScript, "HTML file contains PHP code, which is never run on Shiba"})
+	}
+	return findings, nil
+}
+
+// CheckContent walks an extracted build and reports files whose name or
+// content doesn't match what they claim to be.
+func CheckContent(dir string) ([]Finding, error) {
+	var findings []Finding
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if f := checkName(rel); f != nil {
+			findings = append(findings, *f)
+		}
+		found, err := checkContent(path, rel)
+		if err != nil {
+			return err
+		}
+		findings = append(findings, found...)
+		return nil
+	})
+	return findings, err
+}
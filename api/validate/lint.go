@@ -0,0 +1,140 @@
+package validate
+
+import (
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Lint warnings about an HTML page's references. They don't block the upload
+// but are the usual cause of a game stuck on a black screen.
+const (
+	RuleMissingFile  = "missing_file"
+	RuleCaseMismatch = "case_mismatch"
+	RuleLocalPath    = "local_path"
+	RuleRootPath     = "root_path"
+)
+
+// At most this many HTML pages are linted per build
+const maxLintPages = 50
+
+// Attributes that point at files the page loads (links excepted)
+var refAttrs = map[string]bool{"src": true, "href": true, "data": true, "poster": true}
+
+// Paths only valid on the machine the build was exported on
+var localPath = regexp.MustCompile(`(?i)^([a-z]:[\\/]|file:|\\\\|/(users|home|var/folders)/)`)
+
+// LintHTML checks the references of the build's HTML pages against its files:
+// paths to files that aren't in the build, paths only matching a file with
+// different casing (fine on Windows and macOS, broken on the server), paths
+// on the creator's machine and paths starting at the site root.
+func LintHTML(dir string) ([]Finding, error) {
+	files := map[string]bool{}
+	lower := map[string]string{}
+	var pages []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		files[rel] = true
+		lower[strings.ToLower(rel)] = rel
+		if ext := strings.ToLower(path.Ext(rel)); (ext == ".html" || ext == ".htm") && len(pages) < maxLintPages {
+			pages = append(pages, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var findings []Finding
+	for _, page := range pages {
+		refs, err := pageRefs(filepath.Join(dir, filepath.FromSlash(page)))
+		if err != nil {
+			return nil, err
+		}
+		seen := map[string]bool{}
+		for _, ref := range refs {
+			if seen[ref] {
+				continue
+			}
+			seen[ref] = true
+			if f := checkRef(page, ref, files, lower); f != nil {
+				findings = append(findings, *f)
+			}
+		}
+	}
+	return findings, nil
+}
+
+func pageRefs(file string) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var refs []string
+	z := html.NewTokenizer(f)
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return refs, nil
+		case html.StartTagToken, html.SelfClosingTagToken:
+			// Links are navigation, not something the game loads
+			if name, _ := z.TagName(); string(name) == "a" {
+				continue
+			}
+			for {
+				key, val, more := z.TagAttr()
+				if refAttrs[string(key)] {
+					if ref := strings.TrimSpace(string(val)); ref != "" {
+						refs = append(refs, ref)
+					}
+				}
+				if !more {
+					break
+				}
+			}
+		}
+	}
+}
+
+func checkRef(page, ref string, files map[string]bool, lower map[string]string) *Finding {
+	if localPath.MatchString(ref) {
+		return &Finding{page, RuleLocalPath, ref + " points at a file on your computer, reference it relative to " + page + " instead"}
+	}
+	if strings.HasPrefix(ref, "//") || strings.HasPrefix(ref, "#") || strings.Contains(ref, "{{") {
+		return nil
+	}
+	u, err := url.Parse(ref)
+	if err != nil || u.Scheme != "" || u.Path == "" {
+		return nil
+	}
+	if strings.HasPrefix(u.Path, "/") {
+		return &Finding{page, RuleRootPath, ref + " starts at the site root, not your game's folder; drop the leading /"}
+	}
+
+	target := path.Join(path.Dir(page), u.Path)
+	if strings.HasSuffix(u.Path, "/") {
+		target = path.Join(target, "index.html")
+	}
+	if files[target] {
+		return nil
+	}
+	if actual, ok := lower[strings.ToLower(target)]; ok {
+		return &Finding{page, RuleCaseMismatch, ref + " only matches " + actual + " with different capitalization, which breaks on the server"}
+	}
+	return &Finding{page, RuleMissingFile, ref + " is not in the build"}
+}
@@ -0,0 +1,209 @@
+package validate
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Reasons a build isn't a playable web game. Unlike the other rules they are
+// about the build as a whole; Path is the file the finding is about, or the
+// one that's missing.
+const (
+	RuleMissingIndex     = "missing_index"
+	RuleNestedIndex      = "nested_index"
+	RuleSourceCode       = "source_code"
+	RuleIncompleteExport = "incomplete_export"
+)
+
+// Files that only exist in a project, never in its web export, and what
+// kind of project they give away
+var sourceMarkers = map[string]string{
+	"project.godot":          "Godot project",
+	"ProjectSettings":        "Unity project",
+	"Packages/manifest.json": "Unity project",
+	"Cargo.toml":             "Rust project",
+	"go.mod":                 "Go project",
+	"CMakeLists.txt":         "C/C++ project",
+	"pyproject.toml":         "Python project",
+	"requirements.txt":       "Python project",
+	"main.py":                "Python project",
+	"conf.lua":               "LÖVE project",
+	"main.lua":               "LÖVE project",
+	"package.json":           "npm project",
+}
+
+// Extensions of source files rather than exported ones
+var sourceExts = map[string]string{
+	".gd": "Godot project", ".tscn": "Godot project", ".tres": "Godot project",
+	".unity": "Unity project", ".cs": "C# project", ".csproj": "C# project", ".sln": "C# project",
+	".py": "Python project", ".rs": "Rust project", ".c": "C/C++ project", ".cpp": "C/C++ project",
+	".java": "Java project", ".ts": "TypeScript project", ".tsx": "TypeScript project",
+}
+
+// Scripts a browser can't run without a build step, as the entry of a page
+var unbuiltScript = regexp.MustCompile(`(?i)<script[^>]+src=["']?[^"'>\s]+\.(ts|tsx|jsx)["'\s>]`)
+
+// Unity's WebGL loader: Build/<name>.loader.js since 2020, UnityLoader.js before
+func isUnityLoader(rel string) bool {
+	base := path.Base(rel)
+	return strings.HasSuffix(base, ".loader.js") || base == "UnityLoader.js"
+}
+
+// isUnityBuildFile reports files of a Unity WebGL build other than its loader,
+// possibly compressed.
+func isUnityBuildFile(rel string) bool {
+	base := strings.TrimSuffix(strings.TrimSuffix(strings.TrimSuffix(path.Base(rel), ".gz"), ".br"), ".unityweb")
+	return path.Base(path.Dir(rel)) == "Build" &&
+		(strings.HasSuffix(base, ".framework.js") || strings.HasSuffix(base, ".data"))
+}
+
+// CheckPlayable reports why an extracted build isn't a web game the play
+// page can start: no index.html at its root (with what to rename or move
+// when the page is elsewhere), a project's source code instead of its export,
+// or a Godot or Unity export missing the files its page loads. foldCase
+// accepts an index.html in any case, for builds whose paths get lowercased.
+func CheckPlayable(dir string, foldCase bool) ([]Finding, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if d.IsDir() {
+			// Directory markers, like Unity's ProjectSettings
+			if _, ok := sourceMarkers[rel]; ok {
+				files = append(files, rel)
+			}
+			return nil
+		}
+		files = append(files, rel)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	var index string
+	var rootPages, nestedIndexes, pcks, wasms, unityLoaders, unityFiles []string
+	for _, rel := range files {
+		base := path.Base(rel)
+		ext := strings.ToLower(path.Ext(rel))
+		switch {
+		case rel == "index.html" || (foldCase && strings.EqualFold(rel, "index.html")):
+			index = rel
+		case strings.EqualFold(base, "index.html") && rel != base:
+			nestedIndexes = append(nestedIndexes, rel)
+		case (ext == ".html" || ext == ".htm") && rel == base:
+			rootPages = append(rootPages, rel)
+		}
+		switch {
+		case ext == ".pck":
+			pcks = append(pcks, rel)
+		case ext == ".wasm":
+			wasms = append(wasms, rel)
+		case isUnityLoader(rel):
+			unityLoaders = append(unityLoaders, rel)
+		case isUnityBuildFile(rel):
+			unityFiles = append(unityFiles, rel)
+		}
+	}
+
+	if index == "" {
+		return []Finding{missingIndex(files, rootPages, nestedIndexes, pcks, unityLoaders)}, nil
+	}
+
+	var findings []Finding
+	page, err := os.ReadFile(filepath.Join(dir, index))
+	if err != nil {
+		return nil, err
+	}
+	if unbuiltScript.Match(page) && slices.Contains(files, "package.json") {
+		findings = append(findings, Finding{index, RuleSourceCode,
+			"index.html loads TypeScript or JSX directly: this is your project's source. Run its build (e.g. npm run build) and upload the output folder, usually dist"})
+	}
+	if len(pcks) > 0 && len(wasms) == 0 {
+		findings = append(findings, Finding{pcks[0], RuleIncompleteExport,
+			"Godot export without its .wasm engine file: upload every file the web export created"})
+	}
+	if len(unityFiles) > 0 && len(unityLoaders) == 0 {
+		findings = append(findings, Finding{path.Dir(unityFiles[0]), RuleIncompleteExport,
+			"Unity WebGL build without its .loader.js: upload the whole Build folder"})
+	}
+	return findings, nil
+}
+
+// missingIndex explains a build without an index.html at its root.
+func missingIndex(files, rootPages, nestedIndexes, pcks, unityLoaders []string) Finding {
+	for _, rel := range rootPages {
+		if strings.EqualFold(rel, "index.html") {
+			return Finding{rel, RuleMissingIndex, rel + " must be named index.html, in lowercase"}
+		}
+	}
+	if len(nestedIndexes) > 0 {
+		return Finding{nestedIndexes[0], RuleNestedIndex,
+			"The game's page is in " + path.Dir(nestedIndexes[0]) + "/: zip the contents of that folder instead of the folders around it"}
+	}
+	if len(rootPages) == 1 {
+		engine := "The"
+		if len(pcks) > 0 {
+			engine = "Godot export found: the"
+		} else if len(unityLoaders) > 0 {
+			engine = "Unity build found: the"
+		}
+		return Finding{rootPages[0], RuleMissingIndex, engine + " game's page must be named index.html, rename " + rootPages[0]}
+	}
+	if len(rootPages) > 1 {
+		return Finding{"index.html", RuleMissingIndex,
+			fmt.Sprintf("No index.html among the pages %s: rename the one that starts the game to index.html", strings.Join(rootPages, ", "))}
+	}
+	if kind, marker := sourceProject(files); kind != "" {
+		return Finding{marker, RuleSourceCode,
+			"This is a " + kind + " (" + marker + "), not an exported game: export it for the web (HTML5) and upload that"}
+	}
+	if len(pcks) > 0 {
+		return Finding{pcks[0], RuleMissingIndex, "Godot data found without its page: upload every file the web export created, index.html included"}
+	}
+	if len(unityLoaders) > 0 {
+		return Finding{unityLoaders[0], RuleMissingIndex, "Unity loader found without its page: upload the whole WebGL build folder, index.html included"}
+	}
+	return Finding{"index.html", RuleMissingIndex, "No index.html, the page that starts a web game: export your game for the web (HTML5) and upload what it creates"}
+}
+
+// sourceProject names the kind of project files come from, and the file
+// giving it away, if they look like source code.
+func sourceProject(files []string) (kind, marker string) {
+	for _, rel := range files {
+		if kind, ok := sourceMarkers[rel]; ok {
+			return kind, rel
+		}
+	}
+	// Judged by the majority of files, as exports can ship a stray script
+	counts := map[string]int{}
+	first := map[string]string{}
+	for _, rel := range files {
+		if kind, ok := sourceExts[strings.ToLower(path.Ext(rel))]; ok {
+			counts[kind]++
+			if first[kind] == "" {
+				first[kind] = rel
+			}
+		}
+	}
+	for k, n := range counts {
+		if n*2 >= len(files) && (kind == "" || n > counts[kind] || (n == counts[kind] && k < kind)) {
+			kind = k
+		}
+	}
+	return kind, first[kind]
+}
@@ -0,0 +1,184 @@
+package validate
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Rule is an event-specific check, e.g. "must include credits.txt". Rules are
+// registered once and enabled per event with params, so organizers can add
+// checks without touching the upload pipeline.
+type Rule interface {
+	// Validate checks the params a rule is configured with.
+	Validate(params map[string]string) error
+	// Check inspects the extracted build in dir and returns its problems.
+	// Findings should use the configured rule name as their Rule.
+	Check(dir, name string, params map[string]string) ([]Finding, error)
+}
+
+var rules = map[string]Rule{
+	"require-file":     requireFile{},
+	"references-asset": referencesAsset{},
+}
+
+// Register adds a rule organizers can enable by name.
+func Register(name string, r Rule) {
+	rules[name] = r
+}
+
+// Rules lists the registered rule names.
+func Rules() []string {
+	names := make([]string, 0, len(rules))
+	for name := range rules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RuleConfig enables a registered rule for an event.
+type RuleConfig struct {
+	Name    string            `json:"name"`
+	Rule    string            `json:"rule"`
+	Params  map[string]string `json:"params"`
+	Enabled bool              `json:"enabled"`
+	// Message is shown to creators next to the rule's own detail
+	Message string `json:"message,omitempty"`
+}
+
+// Validate checks the config refers to a known rule with valid params.
+func (c RuleConfig) Validate() error {
+	r, ok := rules[c.Rule]
+	if !ok {
+		return fmt.Errorf("unknown rule %q, expected one of %s", c.Rule, strings.Join(Rules(), ", "))
+	}
+	return r.Validate(c.Params)
+}
+
+// RunRules runs the enabled rules on dir, ordered by name, and returns every
+// finding.
+func RunRules(dir string, configs []RuleConfig) ([]Finding, error) {
+	sorted := append([]RuleConfig(nil), configs...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+
+	var findings []Finding
+	for _, c := range sorted {
+		if !c.Enabled {
+			continue
+		}
+		r, ok := rules[c.Rule]
+		if !ok {
+			return nil, fmt.Errorf("rule %s: unknown rule %q", c.Name, c.Rule)
+		}
+		found, err := r.Check(dir, c.Name, c.Params)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %v", c.Name, err)
+		}
+		for i := range found {
+			if c.Message != "" {
+				found[i].Detail += ". " + c.Message
+			}
+		}
+		findings = append(findings, found...)
+	}
+	return findings, nil
+}
+
+// requireFile insists on a file at params["path"], compared case
+// insensitively, e.g. credits.txt or LICENSE.
+type requireFile struct{}
+
+func (requireFile) Validate(params map[string]string) error {
+	if strings.TrimSpace(params["path"]) == "" {
+		return fmt.Errorf("path param is required")
+	}
+	return nil
+}
+
+func (requireFile) Check(dir, name string, params map[string]string) ([]Finding, error) {
+	path := strings.Trim(filepath.ToSlash(strings.TrimSpace(params["path"])), "/")
+	want := strings.ToLower(path)
+	found := false
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || found {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		found = strings.ToLower(filepath.ToSlash(rel)) == want
+		return nil
+	})
+	if err != nil || found {
+		return nil, err
+	}
+	return []Finding{{path, name, "required file is missing"}}, nil
+}
+
+// referencesAsset insists the build uses an asset the event provides, such
+// as a theme sprite: a file named params["asset"] must be in the build or its
+// name must appear in one of its files (engine packs included).
+type referencesAsset struct{}
+
+func (referencesAsset) Validate(params map[string]string) error {
+	if strings.TrimSpace(params["asset"]) == "" {
+		return fmt.Errorf("asset param is required")
+	}
+	return nil
+}
+
+func (referencesAsset) Check(dir, name string, params map[string]string) ([]Finding, error) {
+	asset := strings.TrimSpace(params["asset"])
+	needle := []byte(asset)
+	found := false
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || found {
+			return err
+		}
+		if strings.EqualFold(d.Name(), filepath.Base(asset)) {
+			found = true
+			return nil
+		}
+		found, err = fileContains(path, needle)
+		return err
+	})
+	if err != nil || found {
+		return nil, err
+	}
+	return []Finding{{asset, name, "build doesn't use the required asset " + asset}}, nil
+}
+
+// fileContains reports whether needle occurs in the file, reading it in
+// chunks so large packs don't have to fit in memory.
+func fileContains(path string, needle []byte) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	buf := make([]byte, 64<<10+len(needle))
+	carry := 0
+	for {
+		n, err := io.ReadFull(f, buf[carry:])
+		n += carry
+		if bytes.Contains(buf[:n], needle) {
+			return true, nil
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		// Keep the tail in case the needle straddles two chunks
+		carry = copy(buf, buf[n-len(needle)+1:n])
+	}
+}
@@ -0,0 +1,139 @@
+package validate
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/experimental"
+)
+
+// RuleWasmInvalid flags a .wasm module that doesn't compile or instantiate
+const RuleWasmInvalid = "wasm_invalid"
+
+// WasmLimits bound the sandbox modules are checked in.
+type WasmLimits struct {
+	// MaxMemoryPages caps each memory, in 64 KiB pages
+	MaxMemoryPages uint32
+	// Timeout for compiling and instantiating one module
+	Timeout time.Duration
+	// Modules larger than MaxSize bytes are skipped
+	MaxSize int64
+}
+
+// CheckWasm compiles every .wasm module of a build in a wazero sandbox and
+// instantiates it against stub imports, without running any exported code.
+// Truncated or corrupted modules, which otherwise only show up as a blank
+// screen in the player's browser, are reported as findings.
+//
+// Modules that import memories, tables or globals from JavaScript can't be
+// instantiated against stubs; for them only compilation is checked. Modules
+// using a proposal the sandbox doesn't support are skipped.
+func CheckWasm(dir string, limits WasmLimits) ([]Finding, error) {
+	var findings []Finding
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.EqualFold(filepath.Ext(path), ".wasm") {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if limits.MaxSize > 0 && info.Size() > limits.MaxSize {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if problem := checkModule(data, limits); problem != "" {
+			findings = append(findings, Finding{filepath.ToSlash(rel), RuleWasmInvalid, problem})
+		}
+		return nil
+	})
+	return findings, err
+}
+
+// checkModule returns what is wrong with a module, or "" if nothing is.
+func checkModule(data []byte, limits WasmLimits) string {
+	ctx, cancel := context.WithTimeout(context.Background(), limits.Timeout)
+	defer cancel()
+
+	config := wazero.NewRuntimeConfigInterpreter().
+		WithCoreFeatures(api.CoreFeaturesV2 | experimental.CoreFeaturesThreads).
+		WithCloseOnContextDone(true)
+	if limits.MaxMemoryPages > 0 {
+		config = config.WithMemoryLimitPages(limits.MaxMemoryPages)
+	}
+	rt := wazero.NewRuntimeWithConfig(ctx, config)
+	defer rt.Close(context.Background())
+
+	compiled, err := rt.CompileModule(ctx, data)
+	if err != nil {
+		// Modules starting with more memory than the check may use can't
+		// be checked, which isn't the module's fault either
+		if ctx.Err() != nil || strings.Contains(err.Error(), "is disabled") || strings.Contains(err.Error(), "over limit of") {
+			return ""
+		}
+		return "module doesn't compile: " + err.Error()
+	}
+	if len(compiled.ExportedFunctions()) == 0 {
+		return "module exports no functions"
+	}
+	if len(compiled.ImportedMemories()) > 0 {
+		return ""
+	}
+
+	if err := stubImports(ctx, rt, compiled.ImportedFunctions()); err != nil {
+		return ""
+	}
+	_, err = rt.InstantiateModule(ctx, compiled, wazero.NewModuleConfig().WithName("").WithStartFunctions())
+	if err != nil {
+		// Imported tables and globals can't be stubbed; that isn't the
+		// module's fault
+		if ctx.Err() != nil || strings.Contains(err.Error(), "is not exported in module") {
+			return ""
+		}
+		return "module doesn't instantiate: " + err.Error()
+	}
+	return ""
+}
+
+// stubImports provides every imported function as a host function returning
+// zeros, grouped in one host module per import module name.
+func stubImports(ctx context.Context, rt wazero.Runtime, imports []api.FunctionDefinition) error {
+	builders := map[string]wazero.HostModuleBuilder{}
+	for _, def := range imports {
+		module, name, _ := def.Import()
+		b, ok := builders[module]
+		if !ok {
+			b = rt.NewHostModuleBuilder(module)
+			builders[module] = b
+		}
+		results := len(def.ResultTypes())
+		b.NewFunctionBuilder().
+			WithGoModuleFunction(api.GoModuleFunc(func(ctx context.Context, mod api.Module, stack []uint64) {
+				for i := 0; i < results; i++ {
+					stack[i] = 0
+				}
+			}), def.ParamTypes(), def.ResultTypes()).
+			Export(name)
+	}
+	for module, b := range builders {
+		if _, err := b.Instantiate(ctx); err != nil {
+			return fmt.Errorf("failed to stub %s: %v", module, err)
+		}
+	}
+	return nil
+}
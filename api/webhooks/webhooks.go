@@ -0,0 +1,310 @@
+// Package webhooks delivers events to URLs registered by creators. Payloads
+// are signed with the webhook's secret so receivers can check they came from
+// us, and Discord webhook URLs get a chat message instead, so creators can
+// point a webhook straight at a channel.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Events creators can subscribe to
+const (
+	EventFeedback          = "feedback"
+	EventCrash             = "crash"
+	EventPlaytimeMilestone = "playtime_milestone"
+	// EventPing is sent on request to check a webhook works
+	EventPing = "ping"
+)
+
+// Events lists the events webhooks can subscribe to.
+var Events = []string{EventFeedback, EventCrash, EventPlaytimeMilestone}
+
+// Request headers of a delivery
+const (
+	SignatureHeader = "X-Shiba-Signature"
+	TimestampHeader = "X-Shiba-Timestamp"
+	EventHeader     = "X-Shiba-Event"
+	DeliveryHeader  = "X-Shiba-Delivery"
+)
+
+const (
+	timeout = 10 * time.Second
+	// Tries per delivery. Network errors, 429s and 5xxs are retried after
+	// retryDelays.
+	attempts = 3
+	// Deliveries waiting for a worker; more are dropped
+	queueSize = 1000
+	// Longest message Discord accepts
+	discordMaxContent = 2000
+)
+
+var retryDelays = []time.Duration{5 * time.Second, 30 * time.Second}
+
+// Payload is the JSON body of a delivery.
+type Payload struct {
+	ID        string    `json:"id"`
+	Event     string    `json:"event"`
+	ProjectID string    `json:"projectId"`
+	At        time.Time `json:"at"`
+	// Text summarizes the event for people, and is all Discord receives
+	Text string `json:"text"`
+	Data any    `json:"data,omitempty"`
+}
+
+// Target is where a payload goes.
+type Target struct {
+	ID     string
+	URL    string
+	Secret string
+}
+
+// Result is the outcome of a delivery, after retries.
+type Result struct {
+	DeliveryID string    `json:"deliveryId"`
+	Event      string    `json:"event"`
+	At         time.Time `json:"at"`
+	Attempts   int       `json:"attempts"`
+	// Status is the receiver's last HTTP status, 0 when it couldn't be
+	// reached
+	Status int    `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// OK reports whether the receiver accepted the delivery.
+func (r Result) OK() bool { return r.Error == "" }
+
+type delivery struct {
+	target  Target
+	payload Payload
+	done    func(Result)
+}
+
+// Dispatcher sends deliveries from a queue in the background.
+type Dispatcher struct {
+	client *http.Client
+	queue  chan delivery
+}
+
+// NewDispatcher starts workers sending deliveries.
+func NewDispatcher(workers int) *Dispatcher {
+	d := &Dispatcher{client: newClient(), queue: make(chan delivery, queueSize)}
+	for i := 0; i < workers; i++ {
+		go d.work()
+	}
+	return d
+}
+
+// Send queues a payload for target and reports whether it was queued. done,
+// if not nil, gets the result once delivery succeeds or gives up.
+func (d *Dispatcher) Send(target Target, p Payload, done func(Result)) bool {
+	if p.ID == "" {
+		p.ID = NewID()
+	}
+	select {
+	case d.queue <- delivery{target, p, done}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (d *Dispatcher) work() {
+	for job := range d.queue {
+		result := d.deliver(job.target, job.payload)
+		if job.done != nil {
+			job.done(result)
+		}
+	}
+}
+
+// deliver posts a payload, retrying failures that may be temporary.
+func (d *Dispatcher) deliver(target Target, p Payload) Result {
+	result := Result{DeliveryID: p.ID, Event: p.Event}
+	body, contentType, err := encode(target.URL, p)
+	if err != nil {
+		result.At = time.Now().UTC()
+		result.Error = err.Error()
+		return result
+	}
+	for {
+		result.Attempts++
+		result.At = time.Now().UTC()
+		var retry bool
+		result.Status, retry, err = d.post(target, p, body, contentType)
+		result.Error = ""
+		if err != nil {
+			result.Error = err.Error()
+		}
+		if err == nil || !retry || result.Attempts >= attempts {
+			return result
+		}
+		time.Sleep(retryDelays[result.Attempts-1])
+	}
+}
+
+func (d *Dispatcher) post(target Target, p Payload, body []byte, contentType string) (status int, retry bool, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, false, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("User-Agent", "Shiba-Webhooks/1")
+	req.Header.Set(EventHeader, p.Event)
+	req.Header.Set(DeliveryHeader, p.ID)
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, Sign(target.Secret, timestamp, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, !errors.Is(err, errBlockedAddress), err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp.StatusCode, false, nil
+	}
+	retry = resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return resp.StatusCode, retry, fmt.Errorf("receiver answered %s", resp.Status)
+}
+
+// Sign is the signature header of a delivery: the hex HMAC-SHA256 of
+// "<timestamp>.<body>" under the webhook's secret, prefixed with "sha256=".
+// Receivers should also reject old timestamps to stop replays.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// encode renders the body for a receiver: a chat message for Discord, the
+// payload itself for everyone else.
+func encode(rawURL string, p Payload) ([]byte, string, error) {
+	if IsDiscord(rawURL) {
+		// Player-supplied text mustn't ping the channel
+		type mentions struct {
+			Parse []string `json:"parse"`
+		}
+		text := p.Text
+		if len(text) > discordMaxContent {
+			text = strings.ToValidUTF8(text[:discordMaxContent-3], "") + "..."
+		}
+		body, err := json.Marshal(struct {
+			Content         string   `json:"content"`
+			Username        string   `json:"username"`
+			AllowedMentions mentions `json:"allowed_mentions"`
+		}{text, "Shiba", mentions{Parse: []string{}}})
+		return body, "application/json", err
+	}
+	body, err := json.Marshal(p)
+	return body, "application/json", err
+}
+
+// IsDiscord reports whether rawURL is a Discord channel webhook.
+func IsDiscord(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	switch host {
+	case "discord.com", "discordapp.com", "ptb.discord.com", "canary.discord.com":
+		return strings.HasPrefix(u.Path, "/api/webhooks/")
+	}
+	return false
+}
+
+// ValidateURL checks rawURL can be registered: HTTPS, with a host and no
+// credentials. Where it resolves to is checked on every delivery.
+func ValidateURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return errors.New("must be a URL")
+	}
+	if u.Scheme != "https" {
+		return errors.New("must be an https URL")
+	}
+	if u.Hostname() == "" {
+		return errors.New("must have a host")
+	}
+	if u.User != nil {
+		return errors.New("must not contain credentials")
+	}
+	if ip := net.ParseIP(u.Hostname()); ip != nil && !public(ip) {
+		return errors.New("must not point at a private address")
+	}
+	return nil
+}
+
+// NewSecret generates a signing secret.
+func NewSecret() string {
+	return "whsec_" + randomHex(24)
+}
+
+// NewID generates a delivery ID.
+func NewID() string {
+	return randomHex(16)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+var errBlockedAddress = errors.New("webhook URL resolves to a private address")
+
+// newClient is an HTTP client that only connects to public addresses, so
+// webhooks can't be used to reach the server's own network, and doesn't
+// follow redirects there either.
+func newClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !public(ip) {
+				return errBlockedAddress
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// Carrier-grade NAT, which IsPrivate doesn't cover
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+func public(ip net.IP) bool {
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !sharedAddressSpace.Contains(ip)
+}
@@ -0,0 +1,229 @@
+// Package writequeue funnels every Airtable record update through one queue.
+// Updates are coalesced into batch requests of up to 10 records, sent at a
+// steady pace that leaves room for reads under Airtable's 5 requests per
+// second per base, and held back for Airtable's 30 second penalty when it
+// answers 429 anyway.
+package writequeue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"shiba-api/metrics"
+
+	"github.com/mehanizm/airtable"
+)
+
+const (
+	// Records Airtable accepts per update request
+	BatchSize = 10
+	// How long Airtable rejects all requests after a 429
+	RateLimitPenalty = 30 * time.Second
+	// Batches that keep hitting the rate limit fail after this many tries
+	MaxAttempts = 4
+)
+
+// Table is the part of an Airtable table the queue writes to.
+type Table interface {
+	UpdateRecordsPartialContext(ctx context.Context, records *airtable.Records) (*airtable.Records, error)
+}
+
+type recordKey struct {
+	table Table
+	id    string
+}
+
+// update is a pending write to one record. Updates to a record that is still
+// queued merge into it, later fields winning.
+type update struct {
+	key     recordKey
+	fields  map[string]any
+	waiters []chan error
+}
+
+// Queue batches record updates. Run must be running for updates to be sent.
+type Queue struct {
+	interval time.Duration
+
+	mu      sync.Mutex
+	pending []*update
+	byKey   map[recordKey]*update
+	wake    chan struct{}
+}
+
+// New returns a queue sending at most perSecond batch requests a second.
+func New(perSecond float64) *Queue {
+	return &Queue{
+		interval: time.Duration(float64(time.Second) / perSecond),
+		byKey:    map[recordKey]*update{},
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+func (q *Queue) enqueue(table Table, id string, fields map[string]any) <-chan error {
+	done := make(chan error, 1)
+	key := recordKey{table, id}
+
+	q.mu.Lock()
+	u, ok := q.byKey[key]
+	if !ok {
+		u = &update{key: key, fields: map[string]any{}}
+		q.byKey[key] = u
+		q.pending = append(q.pending, u)
+	}
+	for k, v := range fields {
+		u.fields[k] = v
+	}
+	u.waiters = append(u.waiters, done)
+	q.mu.Unlock()
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return done
+}
+
+// Update writes fields to the record id of table and waits until Airtable
+// took it. A cancelled ctx stops the wait, not the write.
+func (q *Queue) Update(ctx context.Context, table Table, id string, fields map[string]any) error {
+	select {
+	case err := <-q.enqueue(table, id, fields):
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// UpdateAll writes several records and waits for all of them, returning the
+// first error.
+func (q *Queue) UpdateAll(ctx context.Context, table Table, records []*airtable.Record) error {
+	waits := make([]<-chan error, len(records))
+	for i, r := range records {
+		waits[i] = q.enqueue(table, r.ID, r.Fields)
+	}
+	var first error
+	for _, done := range waits {
+		select {
+		case err := <-done:
+			if err != nil && first == nil {
+				first = err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return first
+}
+
+// Len is the number of records waiting to be written.
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending)
+}
+
+// next takes the oldest pending update and up to BatchSize-1 more for the
+// same table, or nil if nothing is pending. Taken updates no longer absorb
+// new writes to their record.
+func (q *Queue) next() []*update {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.pending) == 0 {
+		return nil
+	}
+	table := q.pending[0].key.table
+	var batch []*update
+	rest := q.pending[:0]
+	for _, u := range q.pending {
+		if u.key.table == table && len(batch) < BatchSize {
+			batch = append(batch, u)
+			delete(q.byKey, u.key)
+		} else {
+			rest = append(rest, u)
+		}
+	}
+	q.pending = rest
+	return batch
+}
+
+// Run sends pending updates until ctx is done.
+func (q *Queue) Run(ctx context.Context) {
+	for {
+		batch := q.next()
+		if batch == nil {
+			select {
+			case <-q.wake:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		q.flush(ctx, batch)
+
+		select {
+		case <-time.After(q.interval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// flush sends a batch. If Airtable rejects it for one bad record, the records
+// are retried one by one so the others still get written.
+func (q *Queue) flush(ctx context.Context, batch []*update) {
+	err := q.send(ctx, batch)
+	var httpErr *airtable.HTTPClientError
+	if len(batch) > 1 && errors.As(err, &httpErr) && (httpErr.StatusCode == 404 || httpErr.StatusCode == 422) {
+		slog.WarnContext(ctx, "Airtable rejected a batch of updates, retrying them one by one", "updates", len(batch), "error", err)
+		for _, u := range batch {
+			select {
+			case <-time.After(q.interval):
+			case <-ctx.Done():
+			}
+			q.flush(ctx, []*update{u})
+		}
+		return
+	}
+
+	for _, u := range batch {
+		for _, done := range u.waiters {
+			done <- err
+		}
+	}
+}
+
+var writeLatency = metrics.NewHistogram("shiba_airtable_write_duration_seconds",
+	"Time taken by each Airtable batch update, including rate limited attempts.", metrics.DefaultBuckets)
+
+func (q *Queue) send(ctx context.Context, batch []*update) error {
+	records := make([]*airtable.Record, len(batch))
+	for i, u := range batch {
+		records[i] = &airtable.Record{ID: u.key.id, Fields: u.fields}
+	}
+	table := batch[0].key.table
+
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		_, err := table.UpdateRecordsPartialContext(ctx, &airtable.Records{Records: records, Typecast: true})
+		writeLatency.Since(start)
+		var httpErr *airtable.HTTPClientError
+		if !errors.As(err, &httpErr) || httpErr.StatusCode != 429 {
+			return err
+		}
+		if attempt == MaxAttempts {
+			return fmt.Errorf("still rate limited after %d attempts: %w", attempt, err)
+		}
+		slog.WarnContext(ctx, "Airtable rate limit hit, pausing writes", "pause", RateLimitPenalty)
+		select {
+		case <-time.After(RateLimitPenalty):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}